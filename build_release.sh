@@ -53,6 +53,11 @@ CHANNEL=$channel GOOS=freebsd GOARCH=amd64 f
 # Variables for CI
 echo "version=$version" > $dst/version.txt
 
+# SHA-256 checksum of the package
+checksum() {
+	sha256sum "$dst/$1" | cut -d' ' -f1
+}
+
 # Prepare the version.json file
 echo "{" >> $dst/version.json
 echo "  \"version\": \"$version\"," >> $dst/version.json
@@ -69,5 +74,16 @@ echo "  \"download_linux_arm64\": \"$baseUrl/AdGuardHome_linux_arm64.tar.gz\","
 echo "  \"download_linux_mips\": \"$baseUrl/AdGuardHome_linux_mips.tar.gz\"," >> $dst/version.json
 echo "  \"download_linux_mipsle\": \"$baseUrl/AdGuardHome_linux_mipsle.tar.gz\"," >> $dst/version.json
 echo "  \"download_freebsd_amd64\": \"$baseUrl/AdGuardHome_freebsd_amd64.tar.gz\"," >> $dst/version.json
+echo "  \"checksum_windows_amd64\": \"$(checksum AdGuardHome_Windows_amd64.zip)\"," >> $dst/version.json
+echo "  \"checksum_windows_386\": \"$(checksum AdGuardHome_Windows_386.zip)\"," >> $dst/version.json
+echo "  \"checksum_darwin_amd64\": \"$(checksum AdGuardHome_MacOS.zip)\"," >> $dst/version.json
+echo "  \"checksum_linux_amd64\": \"$(checksum AdGuardHome_linux_amd64.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_linux_386\": \"$(checksum AdGuardHome_linux_386.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_linux_arm\": \"$(checksum AdGuardHome_linux_arm.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_linux_armv5\": \"$(checksum AdGuardHome_linux_armv5.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_linux_arm64\": \"$(checksum AdGuardHome_linux_arm64.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_linux_mips\": \"$(checksum AdGuardHome_linux_mips.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_linux_mipsle\": \"$(checksum AdGuardHome_linux_mipsle.tar.gz)\"," >> $dst/version.json
+echo "  \"checksum_freebsd_amd64\": \"$(checksum AdGuardHome_freebsd_amd64.tar.gz)\"," >> $dst/version.json
 echo "  \"selfupdate_min_version\": \"v0.0\"" >> $dst/version.json
 echo "}" >> $dst/version.json
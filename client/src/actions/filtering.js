@@ -45,10 +45,12 @@ export const addFilterRequest = createAction('ADD_FILTER_REQUEST');
 export const addFilterFailure = createAction('ADD_FILTER_FAILURE');
 export const addFilterSuccess = createAction('ADD_FILTER_SUCCESS');
 
-export const addFilter = (url, name, whitelist = false) => async (dispatch) => {
+export const addFilter = (url, name, whitelist = false, updateInterval = 0) => async (dispatch) => {
     dispatch(addFilterRequest());
     try {
-        await apiClient.addFilter({ url, name, whitelist });
+        await apiClient.addFilter({
+            url, name, whitelist, update_interval: updateInterval,
+        });
         dispatch(addFilterSuccess(url));
         dispatch(toggleFilteringModal());
         dispatch(addSuccessToast('filter_added_successfully'));
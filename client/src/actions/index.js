@@ -278,15 +278,14 @@ export const testUpstream = config => async (dispatch) => {
         values.upstream_dns = normalizeTextarea(values.upstream_dns);
 
         const upstreamResponse = await apiClient.testUpstream(values);
-        const testMessages = Object.keys(upstreamResponse).map((key) => {
-            const message = upstreamResponse[key];
-            if (message !== 'OK') {
+        const { upstreams } = upstreamResponse;
+        upstreams.forEach(({ address: key, ok }) => {
+            if (!ok) {
                 dispatch(addErrorToast({ error: t('dns_test_not_ok_toast', { key }) }));
             }
-            return message;
         });
 
-        if (testMessages.every(message => message === 'OK')) {
+        if (upstreams.every(({ ok }) => ok)) {
             dispatch(addSuccessToast('dns_test_ok_toast'));
         }
 
@@ -395,15 +394,14 @@ export const toggleDhcpRequest = createAction('TOGGLE_DHCP_REQUEST');
 export const toggleDhcpFailure = createAction('TOGGLE_DHCP_FAILURE');
 export const toggleDhcpSuccess = createAction('TOGGLE_DHCP_SUCCESS');
 
-export const toggleDhcp = values => async (dispatch) => {
+export const toggleDhcp = (values, force = false) => async (dispatch) => {
     dispatch(toggleDhcpRequest());
     let config = { ...values, enabled: false };
     let successMessage = 'disabled_dhcp';
 
     if (!values.enabled) {
-        config = { ...values, enabled: true };
+        config = { ...values, enabled: true, force };
         successMessage = 'enabled_dhcp';
-        dispatch(findActiveDhcp(values.interface_name));
     }
 
     try {
@@ -413,6 +411,10 @@ export const toggleDhcp = values => async (dispatch) => {
     } catch (error) {
         dispatch(addErrorToast({ error }));
         dispatch(toggleDhcpFailure());
+        if (!values.enabled) {
+            // the server refuses to start if another DHCP server is found
+            dispatch(findActiveDhcp(values.interface_name));
+        }
     }
 };
 
@@ -468,3 +470,35 @@ export const removeStaticLease = config => async (dispatch) => {
         dispatch(removeStaticLeaseFailure());
     }
 };
+
+export const makeStaticLeaseRequest = createAction('MAKE_STATIC_LEASE_REQUEST');
+export const makeStaticLeaseFailure = createAction('MAKE_STATIC_LEASE_FAILURE');
+export const makeStaticLeaseSuccess = createAction('MAKE_STATIC_LEASE_SUCCESS');
+
+export const makeStaticLease = mac => async (dispatch) => {
+    dispatch(makeStaticLeaseRequest());
+    try {
+        const lease = await apiClient.makeStaticLease({ mac });
+        dispatch(makeStaticLeaseSuccess(lease));
+        dispatch(addSuccessToast(t('dhcp_lease_added', { key: lease.hostname || lease.ip })));
+    } catch (error) {
+        dispatch(addErrorToast({ error }));
+        dispatch(makeStaticLeaseFailure());
+    }
+};
+
+export const removeLeaseRequest = createAction('REMOVE_LEASE_REQUEST');
+export const removeLeaseFailure = createAction('REMOVE_LEASE_FAILURE');
+export const removeLeaseSuccess = createAction('REMOVE_LEASE_SUCCESS');
+
+export const removeLease = config => async (dispatch) => {
+    dispatch(removeLeaseRequest());
+    try {
+        await apiClient.removeLease(config);
+        dispatch(removeLeaseSuccess(config));
+        dispatch(addSuccessToast(t('dhcp_lease_removed', { key: config.hostname || config.ip })));
+    } catch (error) {
+        dispatch(addErrorToast({ error }));
+        dispatch(removeLeaseFailure());
+    }
+};
@@ -51,6 +51,9 @@ export const getStats = () => async (dispatch) => {
         const normalizedStats = {
             ...stats,
             top_blocked_domains: normalizeTopStats(stats.top_blocked_domains),
+            top_blocking_filters: normalizeTopStats(stats.top_blocking_filters || []),
+            query_types: normalizeTopStats(stats.query_types || []),
+            protocols: normalizeTopStats(stats.protocols || []),
             top_clients: topClientsWithInfo,
             top_queried_domains: normalizeTopStats(stats.top_queried_domains),
             avg_processing_time: secondsToMilliseconds(stats.avg_processing_time),
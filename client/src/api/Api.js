@@ -232,6 +232,8 @@ class Api {
     DHCP_INTERFACES = { path: 'dhcp/interfaces', method: 'GET' };
     DHCP_ADD_STATIC_LEASE = { path: 'dhcp/add_static_lease', method: 'POST' };
     DHCP_REMOVE_STATIC_LEASE = { path: 'dhcp/remove_static_lease', method: 'POST' };
+    DHCP_MAKE_STATIC_LEASE = { path: 'dhcp/make_static_lease', method: 'POST' };
+    DHCP_REMOVE_LEASE = { path: 'dhcp/remove_lease', method: 'POST' };
     DHCP_RESET = { path: 'dhcp/reset', method: 'POST' };
 
     getDhcpStatus() {
@@ -280,6 +282,24 @@ class Api {
         return this.makeRequest(path, method, parameters);
     }
 
+    makeStaticLease(config) {
+        const { path, method } = this.DHCP_MAKE_STATIC_LEASE;
+        const parameters = {
+            data: config,
+            headers: { 'Content-Type': 'application/json' },
+        };
+        return this.makeRequest(path, method, parameters);
+    }
+
+    removeLease(config) {
+        const { path, method } = this.DHCP_REMOVE_LEASE;
+        const parameters = {
+            data: config,
+            headers: { 'Content-Type': 'application/json' },
+        };
+        return this.makeRequest(path, method, parameters);
+    }
+
     resetDhcp() {
         const { path, method } = this.DHCP_RESET;
         return this.makeRequest(path, method);
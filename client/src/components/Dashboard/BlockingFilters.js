@@ -0,0 +1,88 @@
+import React from 'react';
+import ReactTable from 'react-table';
+import PropTypes from 'prop-types';
+import { withNamespaces, Trans } from 'react-i18next';
+
+import Card from '../ui/Card';
+import Cell from '../ui/Cell';
+
+import { getPercent } from '../../helpers/helpers';
+import { STATUS_COLORS, CUSTOM_FILTERING_RULES_ID } from '../../helpers/constants';
+
+const CountCell = blockedFiltering =>
+    function cell(row) {
+        const { value } = row;
+        const percent = getPercent(blockedFiltering, value);
+
+        return <Cell value={value} percent={percent} color={STATUS_COLORS.red} />;
+    };
+
+const getFilterName = (filters, filterId, t) => {
+    if (filterId === CUSTOM_FILTERING_RULES_ID) {
+        return t('custom_filter_rules');
+    }
+
+    const filter = filters.find(filter => filter.id === filterId);
+
+    if (filter && filter.name) {
+        return filter.name;
+    }
+
+    return t('unknown_filter', { filterId });
+};
+
+const BlockingFilters = ({
+    t,
+    refreshButton,
+    topBlockingFilters,
+    filters,
+    subtitle,
+    blockedFiltering,
+}) => (
+    <Card
+        title={t('top_blocking_filters')}
+        subtitle={subtitle}
+        bodyType="card-table"
+        refresh={refreshButton}
+    >
+        <ReactTable
+            data={topBlockingFilters.map(({ name: id, count }) => ({
+                name: getFilterName(filters, parseInt(id, 10), t),
+                count,
+            }))}
+            columns={[
+                {
+                    Header: <Trans>list_label</Trans>,
+                    accessor: 'name',
+                    Cell: ({ value }) => (
+                        <div className="logs__row logs__row--overflow">
+                            <span className="logs__text" title={value}>{value}</span>
+                        </div>
+                    ),
+                },
+                {
+                    Header: <Trans>requests_count</Trans>,
+                    accessor: 'count',
+                    maxWidth: 190,
+                    Cell: CountCell(blockedFiltering),
+                },
+            ]}
+            showPagination={false}
+            noDataText={t('no_filters_found')}
+            minRows={6}
+            defaultPageSize={100}
+            className="-highlight card-table-overflow stats__table"
+        />
+    </Card>
+);
+
+BlockingFilters.propTypes = {
+    topBlockingFilters: PropTypes.array.isRequired,
+    filters: PropTypes.array.isRequired,
+    blockedFiltering: PropTypes.number.isRequired,
+    refreshButton: PropTypes.node.isRequired,
+    subtitle: PropTypes.string.isRequired,
+    t: PropTypes.func.isRequired,
+};
+
+export default withNamespaces()(BlockingFilters);
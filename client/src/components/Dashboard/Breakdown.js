@@ -0,0 +1,82 @@
+import React from 'react';
+import ReactTable from 'react-table';
+import PropTypes from 'prop-types';
+import { withNamespaces } from 'react-i18next';
+
+import Card from '../ui/Card';
+import Cell from '../ui/Cell';
+
+import { getPercent } from '../../helpers/helpers';
+import { STATUS_COLORS } from '../../helpers/constants';
+
+const CountCell = totalQueries =>
+    function cell(row) {
+        const { value } = row;
+        const percent = getPercent(totalQueries, value);
+
+        return <Cell value={value} percent={percent} color={STATUS_COLORS.blue} />;
+    };
+
+const Breakdown = ({
+    t,
+    title,
+    nameHeader,
+    data,
+    getName,
+    refreshButton,
+    subtitle,
+    dnsQueries,
+}) => (
+    <Card
+        title={title}
+        subtitle={subtitle}
+        bodyType="card-table"
+        refresh={refreshButton}
+    >
+        <ReactTable
+            data={data.map(({ name, count }) => ({
+                name: getName(name),
+                count,
+            }))}
+            columns={[
+                {
+                    Header: nameHeader,
+                    accessor: 'name',
+                    Cell: ({ value }) => (
+                        <div className="logs__row logs__row--overflow">
+                            <span className="logs__text" title={value}>{value}</span>
+                        </div>
+                    ),
+                },
+                {
+                    Header: t('requests_count'),
+                    accessor: 'count',
+                    maxWidth: 190,
+                    Cell: CountCell(dnsQueries),
+                },
+            ]}
+            showPagination={false}
+            noDataText={t('no_queries_found')}
+            minRows={6}
+            defaultPageSize={100}
+            className="-highlight card-table-overflow stats__table"
+        />
+    </Card>
+);
+
+Breakdown.defaultProps = {
+    getName: name => name,
+};
+
+Breakdown.propTypes = {
+    title: PropTypes.string.isRequired,
+    nameHeader: PropTypes.string.isRequired,
+    data: PropTypes.array.isRequired,
+    getName: PropTypes.func,
+    dnsQueries: PropTypes.number.isRequired,
+    refreshButton: PropTypes.node.isRequired,
+    subtitle: PropTypes.string.isRequired,
+    t: PropTypes.func.isRequired,
+};
+
+export default withNamespaces()(Breakdown);
@@ -7,12 +7,16 @@ import Counters from './Counters';
 import Clients from './Clients';
 import QueriedDomains from './QueriedDomains';
 import BlockedDomains from './BlockedDomains';
+import BlockingFilters from './BlockingFilters';
+import Breakdown from './Breakdown';
 
 import PageTitle from '../ui/PageTitle';
 import Loading from '../ui/Loading';
-import { ACTION } from '../../helpers/constants';
+import { ACTION, DNS_PROTOCOLS } from '../../helpers/constants';
 import './Dashboard.css';
 
+const getProtocolName = name => DNS_PROTOCOLS[name] || name;
+
 class Dashboard extends Component {
     componentDidMount() {
         this.getAllStats();
@@ -22,6 +26,7 @@ class Dashboard extends Component {
         this.props.getAccessList();
         this.props.getStats();
         this.props.getStatsConfig();
+        this.props.getFilteringStatus();
     };
 
     getToggleFilteringButton = () => {
@@ -51,11 +56,12 @@ class Dashboard extends Component {
 
     render() {
         const {
-            dashboard, stats, access, t,
+            dashboard, stats, access, filtering, t,
         } = this.props;
         const statsProcessing = stats.processingStats
             || stats.processingGetConfig
-            || access.processing;
+            || access.processing
+            || filtering.processingFilters;
 
         const subtitle =
             stats.interval === 1
@@ -153,6 +159,36 @@ class Dashboard extends Component {
                                 refreshButton={refreshButton}
                             />
                         </div>
+                        <div className="col-lg-6">
+                            <BlockingFilters
+                                subtitle={subtitle}
+                                topBlockingFilters={stats.topBlockingFilters}
+                                filters={filtering.filters}
+                                blockedFiltering={stats.numBlockedFiltering}
+                                refreshButton={refreshButton}
+                            />
+                        </div>
+                        <div className="col-lg-6">
+                            <Breakdown
+                                subtitle={subtitle}
+                                title={t('query_types')}
+                                nameHeader={t('type_table_header')}
+                                data={stats.queryTypes}
+                                dnsQueries={stats.numDnsQueries}
+                                refreshButton={refreshButton}
+                            />
+                        </div>
+                        <div className="col-lg-6">
+                            <Breakdown
+                                subtitle={subtitle}
+                                title={t('protocols')}
+                                nameHeader={t('protocol_label')}
+                                data={stats.protocols}
+                                getName={getProtocolName}
+                                dnsQueries={stats.numDnsQueries}
+                                refreshButton={refreshButton}
+                            />
+                        </div>
                     </div>
                 )}
             </Fragment>
@@ -164,6 +200,7 @@ Dashboard.propTypes = {
     dashboard: PropTypes.object.isRequired,
     stats: PropTypes.object.isRequired,
     access: PropTypes.object.isRequired,
+    filtering: PropTypes.object.isRequired,
     getStats: PropTypes.func.isRequired,
     getStatsConfig: PropTypes.func.isRequired,
     toggleProtection: PropTypes.func.isRequired,
@@ -171,6 +208,7 @@ Dashboard.propTypes = {
     t: PropTypes.func.isRequired,
     toggleClientBlock: PropTypes.func.isRequired,
     getAccessList: PropTypes.func.isRequired,
+    getFilteringStatus: PropTypes.func.isRequired,
 };
 
 export default withNamespaces()(Dashboard);
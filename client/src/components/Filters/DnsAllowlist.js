@@ -17,14 +17,14 @@ class DnsAllowlist extends Component {
     }
 
     handleSubmit = (values) => {
-        const { name, url } = values;
+        const { name, url, update_interval: updateInterval } = values;
         const { filtering } = this.props;
         const whitelist = true;
 
         if (filtering.modalType === MODAL_TYPE.EDIT) {
             this.props.editFilter(filtering.modalFilterUrl, values, whitelist);
         } else {
-            this.props.addFilter(url, name, whitelist);
+            this.props.addFilter(url, name, whitelist, updateInterval);
         }
     };
 
@@ -17,13 +17,13 @@ class DnsBlocklist extends Component {
     }
 
     handleSubmit = (values) => {
-        const { name, url } = values;
+        const { name, url, update_interval: updateInterval } = values;
         const { filtering } = this.props;
 
         if (filtering.modalType === MODAL_TYPE.EDIT) {
             this.props.editFilter(filtering.modalFilterUrl, values);
         } else {
-            this.props.addFilter(url, name);
+            this.props.addFilter(url, name, false, updateInterval);
         }
     };
 
@@ -4,7 +4,20 @@ import { Field, reduxForm } from 'redux-form';
 import { Trans, withNamespaces } from 'react-i18next';
 import flow from 'lodash/flow';
 
-import { renderInputField, required, isValidPath } from '../../helpers/form';
+import {
+    renderInputField, required, isValidPath, toNumber,
+} from '../../helpers/form';
+import { FILTERS_INTERVALS_HOURS } from '../../helpers/constants';
+
+const getTitleForInterval = (interval, t) => {
+    if (interval === 0) {
+        return t('filter_update_interval_default');
+    } else if (interval === 72 || interval === 168) {
+        return t('interval_days', { count: interval / 24 });
+    }
+
+    return t('interval_hours', { count: interval });
+};
 
 const Form = (props) => {
     const {
@@ -41,6 +54,24 @@ const Form = (props) => {
                         validate={[required, isValidPath]}
                     />
                 </div>
+                <div className="form__group">
+                    <label className="form__label" htmlFor="update_interval">
+                        <Trans>filter_update_interval</Trans>
+                    </label>
+                    <Field
+                        id="update_interval"
+                        name="update_interval"
+                        className="custom-select"
+                        component="select"
+                        normalize={toNumber}
+                    >
+                        {FILTERS_INTERVALS_HOURS.map(interval => (
+                            <option value={interval} key={interval}>
+                                {getTitleForInterval(interval, t)}
+                            </option>
+                        ))}
+                    </Field>
+                </div>
                 <div className="form__description">
                     {whitelist ? (
                         <Trans>enter_valid_allowlist</Trans>
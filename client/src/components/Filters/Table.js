@@ -1,4 +1,4 @@
-import React, { Component } from 'react';
+import React, { Component, Fragment } from 'react';
 import PropTypes from 'prop-types';
 import ReactTable from 'react-table';
 import { withNamespaces, Trans } from 'react-i18next';
@@ -8,12 +8,30 @@ import { formatDetailedDateTime } from '../../helpers/helpers';
 import { isValidAbsolutePath } from '../../helpers/form';
 
 class Table extends Component {
-    getDateCell = row => CellWrap(row, formatDetailedDateTime);
+    getDateCell = (row) => {
+        const { lastError } = row.original;
+        if (!lastError) {
+            return CellWrap(row, formatDetailedDateTime);
+        }
+
+        return (
+            <Fragment>
+                {CellWrap(row, formatDetailedDateTime)}
+                <div className="text-danger" title={lastError}>
+                    <Trans>filter_update_failed</Trans>
+                </div>
+            </Fragment>
+        );
+    };
 
     renderCheckbox = ({ original }) => {
         const { processingConfigFilter, toggleFilter } = this.props;
-        const { url, name, enabled } = original;
-        const data = { name, url, enabled: !enabled };
+        const {
+            url, name, enabled, updateInterval,
+        } = original;
+        const data = {
+            name, url, enabled: !enabled, update_interval: updateInterval,
+        };
 
         return (
             <label className="checkbox">
@@ -84,6 +84,9 @@ const Form = (props) => {
                         <option value={RESPONSE_FILTER.FILTERED}>
                             <Trans>show_filtered_type</Trans>
                         </option>
+                        <option value={RESPONSE_FILTER.REWRITTEN}>
+                            <Trans>show_rewritten_type</Trans>
+                        </option>
                     </Field>
                 </div>
                 <div className="col-6 col-sm-3 my-2">
@@ -14,7 +14,8 @@ class Filters extends Component {
     }) => ({
         filter_domain: filter_domain || '',
         filter_question_type: isValidQuestionType(filter_question_type) ? filter_question_type.toUpperCase() : '',
-        filter_response_status: filter_response_status === RESPONSE_FILTER.FILTERED ? filter_response_status : '',
+        filter_response_status: filter_response_status === RESPONSE_FILTER.FILTERED
+            || filter_response_status === RESPONSE_FILTER.REWRITTEN ? filter_response_status : '',
         filter_client: filter_client || '',
     });
 
@@ -30,6 +30,12 @@ class AutoClients extends Component {
             minWidth: COLUMN_MIN_WIDTH,
             Cell: CellWrap,
         },
+        {
+            Header: this.props.t('dhcp_table_device'),
+            accessor: 'device',
+            minWidth: COLUMN_MIN_WIDTH,
+            Cell: CellWrap,
+        },
         {
             Header: this.props.t('whois'),
             accessor: 'whois_info',
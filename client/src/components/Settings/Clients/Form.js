@@ -43,6 +43,16 @@ const settingsCheckboxes = [
         placeholder: 'enforce_safe_search',
     },
 ];
+const logsCheckboxes = [
+    {
+        name: 'ignore_querylog',
+        placeholder: 'client_ignore_querylog',
+    },
+    {
+        name: 'ignore_statistics',
+        placeholder: 'client_ignore_statistics',
+    },
+];
 const validate = (values) => {
     const errors = {};
     const { name, ids } = values;
@@ -216,6 +226,16 @@ let Form = (props) => {
                                 />
                             </div>
                         ))}
+                        {logsCheckboxes.map(setting => (
+                            <div className="form__group" key={setting.name}>
+                                <Field
+                                    name={setting.name}
+                                    type="checkbox"
+                                    component={renderSelectField}
+                                    placeholder={t(setting.placeholder)}
+                                />
+                            </div>
+                        ))}
                     </div>
                     <div label="services" title={props.t('block_services')}>
                         <div className="form__group">
@@ -5,7 +5,15 @@ import { Field, reduxForm, formValueSelector } from 'redux-form';
 import { Trans, withNamespaces } from 'react-i18next';
 import flow from 'lodash/flow';
 
-import { renderInputField, required, ipv4, isPositive, toNumber } from '../../../helpers/form';
+import {
+    renderInputField,
+    renderSelectField,
+    required,
+    ipv4,
+    ipv6,
+    isPositive,
+    toNumber,
+} from '../../../helpers/form';
 
 const renderInterfaces = (interfaces => (
     Object.keys(interfaces).map((item) => {
@@ -58,6 +66,17 @@ const clearFields = (change, resetDhcp, t) => {
         range_start: '',
         range_end: '',
         lease_duration: 86400,
+        boot_server: '',
+        boot_file: '',
+        proxy_dhcp: false,
+        deny_unknown_clients: false,
+        dhcpv6: {
+            enabled: false,
+            range_start: '',
+            lease_duration: 86400,
+            ra_slaac_only: false,
+            ra_allow_slaac: false,
+        },
     };
 
     // eslint-disable-next-line no-alert
@@ -76,6 +95,7 @@ let Form = (props) => {
         enabled,
         interfaces,
         interfaceValue,
+        v6Enabled,
         processingConfig,
         processingInterfaces,
         resetDhcp,
@@ -182,6 +202,109 @@ let Form = (props) => {
                     </div>
                 </div>
             </div>
+            <hr/>
+            <div className="form__group form__group--settings">
+                <Field
+                    name="dhcpv6.enabled"
+                    type="checkbox"
+                    component={renderSelectField}
+                    placeholder={t('dhcpv6_enable')}
+                />
+            </div>
+            {v6Enabled &&
+                <div className="row">
+                    <div className="col-lg-6">
+                        <div className="form__group form__group--settings">
+                            <label>{t('dhcpv6_form_range_start_title')}</label>
+                            <Field
+                                id="dhcpv6_range_start"
+                                name="dhcpv6.range_start"
+                                component={renderInputField}
+                                type="text"
+                                className="form-control"
+                                placeholder={t('dhcp_form_range_start')}
+                                validate={[ipv6, required]}
+                            />
+                        </div>
+                        <div className="form__group form__group--settings">
+                            <label>{t('dhcp_form_lease_title')}</label>
+                            <Field
+                                name="dhcpv6.lease_duration"
+                                component={renderInputField}
+                                type="number"
+                                className="form-control"
+                                placeholder={t('dhcp_form_lease_input')}
+                                validate={[required, isPositive]}
+                                normalize={toNumber}
+                            />
+                        </div>
+                    </div>
+                    <div className="col-lg-6">
+                        <div className="form__group form__group--settings">
+                            <Field
+                                name="dhcpv6.ra_slaac_only"
+                                type="checkbox"
+                                component={renderSelectField}
+                                placeholder={t('dhcpv6_ra_slaac_only')}
+                            />
+                        </div>
+                        <div className="form__group form__group--settings">
+                            <Field
+                                name="dhcpv6.ra_allow_slaac"
+                                type="checkbox"
+                                component={renderSelectField}
+                                placeholder={t('dhcpv6_ra_allow_slaac')}
+                            />
+                        </div>
+                    </div>
+                </div>
+            }
+            <hr/>
+            <div className="row">
+                <div className="col-lg-6">
+                    <div className="form__group form__group--settings">
+                        <label>{t('dhcp_form_boot_file_title')}</label>
+                        <Field
+                            name="boot_file"
+                            component={renderInputField}
+                            type="text"
+                            className="form-control"
+                            placeholder="pxelinux.0"
+                        />
+                    </div>
+                    <div className="form__group form__group--settings">
+                        <label>{t('dhcp_form_boot_server_title')}</label>
+                        <Field
+                            name="boot_server"
+                            component={renderInputField}
+                            type="text"
+                            className="form-control"
+                            placeholder={t('dhcp_form_boot_server_desc')}
+                            validate={[ipv4]}
+                        />
+                    </div>
+                </div>
+                <div className="col-lg-6">
+                    <div className="form__group form__group--settings">
+                        <Field
+                            name="proxy_dhcp"
+                            type="checkbox"
+                            component={renderSelectField}
+                            placeholder={t('dhcp_proxy_dhcp')}
+                        />
+                    </div>
+                </div>
+                <div className="col-lg-6">
+                    <div className="form__group form__group--settings">
+                        <Field
+                            name="deny_unknown_clients"
+                            type="checkbox"
+                            component={renderSelectField}
+                            placeholder={t('dhcp_deny_unknown_clients')}
+                        />
+                    </div>
+                </div>
+            </div>
 
             <div className="btn-list">
                 <button
@@ -210,6 +333,7 @@ Form.propTypes = {
     invalid: PropTypes.bool.isRequired,
     interfaces: PropTypes.object.isRequired,
     interfaceValue: PropTypes.string,
+    v6Enabled: PropTypes.bool,
     initialValues: PropTypes.object.isRequired,
     processingConfig: PropTypes.bool.isRequired,
     processingInterfaces: PropTypes.bool.isRequired,
@@ -223,8 +347,10 @@ const selector = formValueSelector('dhcpForm');
 
 Form = connect((state) => {
     const interfaceValue = selector(state, 'interface_name');
+    const v6Enabled = selector(state, 'dhcpv6.enabled');
     return {
         interfaceValue,
+        v6Enabled,
     };
 })(Form);
 
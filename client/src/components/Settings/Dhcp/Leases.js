@@ -14,7 +14,9 @@ class Leases extends Component {
     );
 
     render() {
-        const { leases, t } = this.props;
+        const {
+            leases, makeStaticLease, removeLease, processingMaking, t,
+        } = this.props;
         return (
             <ReactTable
                 data={leases || []}
@@ -31,10 +33,42 @@ class Leases extends Component {
                         Header: <Trans>dhcp_table_hostname</Trans>,
                         accessor: 'hostname',
                         Cell: this.cellWrap,
+                    }, {
+                        Header: <Trans>dhcp_table_device</Trans>,
+                        accessor: 'device',
+                        Cell: this.cellWrap,
                     }, {
                         Header: <Trans>dhcp_table_expires</Trans>,
                         accessor: 'expires',
                         Cell: this.cellWrap,
+                    }, {
+                        Header: <Trans>actions_table_header</Trans>,
+                        accessor: 'actions',
+                        maxWidth: 180,
+                        Cell: row => (
+                            <div className="logs__row logs__row--center">
+                                <button
+                                    type="button"
+                                    className="btn btn-outline-primary btn-sm"
+                                    title={t('dhcp_make_static_lease_title')}
+                                    disabled={processingMaking}
+                                    onClick={() => makeStaticLease(row.original.mac)}
+                                >
+                                    <Trans>dhcp_make_static_lease</Trans>
+                                </button>
+                                <button
+                                    type="button"
+                                    className="btn btn-icon btn-outline-secondary btn-sm ml-2"
+                                    title={t('dhcp_remove_lease_title')}
+                                    disabled={processingMaking}
+                                    onClick={() => removeLease(row.original)}
+                                >
+                                    <svg className="icons">
+                                        <use xlinkHref="#delete"/>
+                                    </svg>
+                                </button>
+                            </div>
+                        ),
                     },
                 ]}
                 pageSize={SMALL_TABLE_DEFAULT_PAGE_SIZE}
@@ -50,6 +84,9 @@ class Leases extends Component {
 
 Leases.propTypes = {
     leases: PropTypes.array,
+    makeStaticLease: PropTypes.func.isRequired,
+    removeLease: PropTypes.func.isRequired,
+    processingMaking: PropTypes.bool,
     t: PropTypes.func,
 };
 
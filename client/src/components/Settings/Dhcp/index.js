@@ -12,6 +12,8 @@ import Accordion from '../../ui/Accordion';
 import PageTitle from '../../ui/PageTitle';
 import Loading from '../../ui/Loading';
 
+const DHCP_V4_REQUIRED_FIELDS = ['gateway_ip', 'subnet_mask', 'range_start', 'range_end', 'lease_duration'];
+
 class Dhcp extends Component {
     componentDidMount() {
         this.props.getDhcpStatus();
@@ -24,8 +26,8 @@ class Dhcp extends Component {
         }
     };
 
-    handleToggle = (config) => {
-        this.props.toggleDhcp(config);
+    handleToggle = (config, force) => {
+        this.props.toggleDhcp(config, force);
     };
 
     getToggleDhcpButton = () => {
@@ -34,13 +36,12 @@ class Dhcp extends Component {
         } = this.props.dhcp;
         const otherDhcpFound =
             check && check.otherServer && check.otherServer.found === DHCP_STATUS_RESPONSE.YES;
-        const filledConfig = Object.keys(config).every((key) => {
-            if (key === 'enabled' || key === 'icmp_timeout_msec') {
-                return true;
-            }
-
-            return config[key];
-        });
+        const filledV4Config = DHCP_V4_REQUIRED_FIELDS.every(key => config[key]);
+        const filledConfig = config.interface_name && (
+            filledV4Config ||
+            (config.dhcpv6 && config.dhcpv6.enabled) ||
+            (config.proxy_dhcp && config.boot_file)
+        );
 
         if (config.enabled) {
             return (
@@ -56,16 +57,40 @@ class Dhcp extends Component {
         }
 
         return (
-            <button
-                type="button"
-                className="btn btn-standard mr-2 btn-success"
-                onClick={() => this.handleToggle(config)}
-                disabled={
-                    !filledConfig || !check || otherDhcpFound || processingDhcp || processingConfig
-                }
-            >
-                <Trans>dhcp_enable</Trans>
-            </button>
+            <Fragment>
+                <button
+                    type="button"
+                    className="btn btn-standard mr-2 btn-success"
+                    onClick={() => this.handleToggle(config)}
+                    disabled={
+                        !filledConfig || !check || otherDhcpFound || processingDhcp || processingConfig
+                    }
+                >
+                    <Trans>dhcp_enable</Trans>
+                </button>
+                {otherDhcpFound && (
+                    <button
+                        type="button"
+                        className="btn btn-standard mr-2 btn-danger"
+                        onClick={() => this.handleToggle(config, true)}
+                        disabled={!filledConfig || processingDhcp || processingConfig}
+                    >
+                        <Trans>dhcp_enable_force</Trans>
+                    </button>
+                )}
+            </Fragment>
+        );
+    };
+
+    getRunningDhcpWarning = (otherServer) => {
+        if (!otherServer || otherServer.found !== DHCP_STATUS_RESPONSE.YES) {
+            return '';
+        }
+
+        return (
+            <div className="text-danger mb-2">
+                <Trans values={{ ip: otherServer.ip }}>dhcp_found_running</Trans>
+            </div>
         );
     };
 
@@ -161,6 +186,8 @@ class Dhcp extends Component {
             findActiveDhcp,
             addStaticLease,
             removeStaticLease,
+            makeStaticLease,
+            removeLease,
             toggleLeaseModal,
         } = this.props;
         const statusButtonClass = classnames({
@@ -208,6 +235,7 @@ class Dhcp extends Component {
                                             <Trans>check_dhcp_servers</Trans>
                                         </button>
                                     </div>
+                                    {enabled && this.getRunningDhcpWarning(dhcp.other_server)}
                                     {!enabled && dhcp.check && (
                                         <Fragment>
                                             {this.getStaticIpWarning(t, dhcp.check, interface_name)}
@@ -225,7 +253,12 @@ class Dhcp extends Component {
                             >
                                 <div className="row">
                                     <div className="col">
-                                        <Leases leases={dhcp.leases} />
+                                        <Leases
+                                            leases={dhcp.leases}
+                                            makeStaticLease={makeStaticLease}
+                                            removeLease={removeLease}
+                                            processingMaking={dhcp.processingMaking}
+                                        />
                                     </div>
                                 </div>
                             </Card>
@@ -272,6 +305,8 @@ Dhcp.propTypes = {
     findActiveDhcp: PropTypes.func.isRequired,
     addStaticLease: PropTypes.func.isRequired,
     removeStaticLease: PropTypes.func.isRequired,
+    makeStaticLease: PropTypes.func.isRequired,
+    removeLease: PropTypes.func.isRequired,
     toggleLeaseModal: PropTypes.func.isRequired,
     getDhcpInterfaces: PropTypes.func.isRequired,
     t: PropTypes.func.isRequired,
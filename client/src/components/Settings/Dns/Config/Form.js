@@ -132,7 +132,7 @@ let Form = ({
                                 component={renderInputField}
                                 className="form-control"
                                 placeholder={t('form_enter_ip')}
-                                validate={[validateIp, required]}
+                                validate={[validateIp]}
                             />
                         </div>
                     </div>)}
@@ -12,6 +12,9 @@ const CertificateStatus = ({
     issuer,
     notAfter,
     dnsNames,
+    chainComplete,
+    daysUntilExpiry,
+    ocspStatus,
 }) => (
     <Fragment>
         <div className="form__label form__label--bold">
@@ -27,6 +30,11 @@ const CertificateStatus = ({
                     <Trans>encryption_chain_invalid</Trans>
                 )}
             </li>
+            {validCert && !chainComplete && (
+                <li className="text-danger">
+                    <Trans>encryption_chain_incomplete</Trans>
+                </li>
+            )}
             {validCert && (
                 <Fragment>
                     {subject && (
@@ -45,6 +53,13 @@ const CertificateStatus = ({
                         <li>
                             <Trans>encryption_expire</Trans>:&nbsp;
                             {format(notAfter, 'YYYY-MM-DD HH:mm:ss')}
+                            {daysUntilExpiry >= 0 && (
+                                <span className={daysUntilExpiry < 30 ? 'text-danger' : ''}>
+                                    &nbsp;(<Trans values={{ count: daysUntilExpiry }}>
+                                        encryption_days_left
+                                    </Trans>)
+                                </span>
+                            )}
                         </li>
                     )}
                     {dnsNames && (
@@ -53,6 +68,12 @@ const CertificateStatus = ({
                             {dnsNames}
                         </li>
                     )}
+                    {ocspStatus && (
+                        <li className={ocspStatus === 'revoked' ? 'text-danger' : ''}>
+                            <Trans>encryption_ocsp_status</Trans>:&nbsp;
+                            <Trans>{`encryption_ocsp_${ocspStatus}`}</Trans>
+                        </li>
+                    )}
                 </Fragment>
             )}
         </ul>
@@ -66,6 +87,9 @@ CertificateStatus.propTypes = {
     issuer: PropTypes.string,
     notAfter: PropTypes.string,
     dnsNames: PropTypes.string,
+    chainComplete: PropTypes.bool,
+    daysUntilExpiry: PropTypes.number,
+    ocspStatus: PropTypes.string,
 };
 
 export default withNamespaces()(CertificateStatus);
@@ -41,6 +41,8 @@ const clearFields = (change, setTlsConfig, t) => {
         port_dns_over_tls: 853,
         server_name: '',
         force_https: false,
+        hsts: false,
+        edns_padding: true,
         enabled: false,
     };
     // eslint-disable-next-line no-alert
@@ -67,6 +69,9 @@ let Form = (props) => {
         processingValidate,
         not_after,
         valid_chain,
+        chain_complete,
+        days_until_expiry,
+        ocsp_status,
         valid_key,
         valid_cert,
         valid_pair,
@@ -143,6 +148,32 @@ let Form = (props) => {
                             <Trans>encryption_redirect_desc</Trans>
                         </div>
                     </div>
+                    <div className="form__group form__group--settings">
+                        <Field
+                            name="hsts"
+                            type="checkbox"
+                            component={renderSelectField}
+                            placeholder={t('encryption_hsts')}
+                            onChange={handleChange}
+                            disabled={!isEnabled}
+                        />
+                        <div className="form__desc">
+                            <Trans>encryption_hsts_desc</Trans>
+                        </div>
+                    </div>
+                    <div className="form__group form__group--settings">
+                        <Field
+                            name="edns_padding"
+                            type="checkbox"
+                            component={renderSelectField}
+                            placeholder={t('encryption_edns_padding')}
+                            onChange={handleChange}
+                            disabled={!isEnabled}
+                        />
+                        <div className="form__desc">
+                            <Trans>encryption_edns_padding_desc</Trans>
+                        </div>
+                    </div>
                 </div>
             </div>
             <div className="row">
@@ -270,6 +301,9 @@ let Form = (props) => {
                                 issuer={issuer}
                                 notAfter={not_after}
                                 dnsNames={dns_names}
+                                chainComplete={chain_complete}
+                                daysUntilExpiry={days_until_expiry}
+                                ocspStatus={ocsp_status}
                             />
                         )}
                     </div>
@@ -382,6 +416,9 @@ Form.propTypes = {
     not_after: PropTypes.string,
     warning_validation: PropTypes.string,
     valid_chain: PropTypes.bool,
+    chain_complete: PropTypes.bool,
+    days_until_expiry: PropTypes.number,
+    ocsp_status: PropTypes.string,
     valid_key: PropTypes.bool,
     valid_cert: PropTypes.bool,
     valid_pair: PropTypes.bool,
@@ -64,24 +64,38 @@ class Encryption extends Component {
             enabled,
             server_name,
             force_https,
+            hsts,
+            edns_padding,
             port_https,
             port_dns_over_tls,
             certificate_chain,
             private_key,
             certificate_path,
             private_key_path,
+            min_version,
+            max_version,
+            cipher_suites,
+            client_ca_path,
+            pkcs12_path,
         } = encryption;
 
         const initialValues = this.getInitialValues({
             enabled,
             server_name,
             force_https,
+            hsts,
+            edns_padding,
             port_https,
             port_dns_over_tls,
             certificate_chain,
             private_key,
             certificate_path,
             private_key_path,
+            min_version,
+            max_version,
+            cipher_suites,
+            client_ca_path,
+            pkcs12_path,
         });
 
         return (
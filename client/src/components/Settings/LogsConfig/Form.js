@@ -9,8 +9,12 @@ import { QUERY_LOG_INTERVALS_DAYS } from '../../../helpers/constants';
 
 const getIntervalFields = (processing, t, toNumber) =>
     QUERY_LOG_INTERVALS_DAYS.map((interval) => {
-        const title =
-            interval === 1 ? t('interval_24_hour') : t('interval_days', { count: interval });
+        let title = t('interval_days', { count: interval });
+        if (interval === 0) {
+            title = t('interval_no_limit');
+        } else if (interval === 1) {
+            title = t('interval_24_hour');
+        }
 
         return (
             <Field
@@ -2,11 +2,16 @@ import { connect } from 'react-redux';
 import { toggleProtection, getClients } from '../actions';
 import { getStats, getStatsConfig, setStatsConfig } from '../actions/stats';
 import { toggleClientBlock, getAccessList } from '../actions/access';
+import { getFilteringStatus } from '../actions/filtering';
 import Dashboard from '../components/Dashboard';
 
 const mapStateToProps = (state) => {
-    const { dashboard, stats, access } = state;
-    const props = { dashboard, stats, access };
+    const {
+        dashboard, stats, access, filtering,
+    } = state;
+    const props = {
+        dashboard, stats, access, filtering,
+    };
     return props;
 };
 
@@ -18,6 +23,7 @@ const mapDispatchToProps = {
     setStatsConfig,
     toggleClientBlock,
     getAccessList,
+    getFilteringStatus,
 };
 
 export default connect(
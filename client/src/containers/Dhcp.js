@@ -8,6 +8,8 @@ import {
     toggleLeaseModal,
     addStaticLease,
     removeStaticLease,
+    makeStaticLease,
+    removeLease,
     resetDhcp,
 } from '../actions';
 import Dhcp from '../components/Settings/Dhcp';
@@ -29,6 +31,8 @@ const mapDispatchToProps = {
     toggleLeaseModal,
     addStaticLease,
     removeStaticLease,
+    makeStaticLease,
+    removeLease,
     resetDhcp,
 };
 
@@ -2,8 +2,8 @@ import React, { Fragment } from 'react';
 import { Trans } from 'react-i18next';
 import PropTypes from 'prop-types';
 import {
-    R_IPV4, R_MAC, R_HOST, R_IPV6, R_CIDR, R_CIDR_IPV6,
-    UNSAFE_PORTS, R_URL_REQUIRES_PROTOCOL, R_WIN_ABSOLUTE_PATH, R_UNIX_ABSOLUTE_PATH,
+    R_IPV4, R_MAC, R_HOST, R_IPV6, R_CIDR, R_CIDR_IPV6, R_CLIENT_ID,
+    UNSAFE_PORTS, R_URL_REQUIRES_PROTOCOL, R_WIN_ABSOLUTE_PATH, R_UNIX_ABSOLUTE_PATH, R_FILE_URL,
 } from '../helpers/constants';
 import { createOnBlurHandler } from './helpers';
 
@@ -201,6 +201,7 @@ export const clientId = (value) => {
         || R_MAC.test(formattedValue)
         || R_CIDR.test(formattedValue)
         || R_CIDR_IPV6.test(formattedValue)
+        || R_CLIENT_ID.test(formattedValue)
     )) {
         return <Trans>form_error_client_id_format</Trans>;
     }
@@ -294,7 +295,7 @@ export const isValidUrl = (value) => {
 };
 
 export const isValidAbsolutePath = value => R_WIN_ABSOLUTE_PATH.test(value)
-    || R_UNIX_ABSOLUTE_PATH.test(value);
+    || R_UNIX_ABSOLUTE_PATH.test(value) || R_FILE_URL.test(value);
 
 export const isValidPath = (value) => {
     if (value && !isValidAbsolutePath(value) && !R_URL_REQUIRES_PROTOCOL.test(value)) {
@@ -131,6 +131,8 @@ export const normalizeFilters = filters => (
             last_updated,
             name = 'Default name',
             rules_count: rules_count = 0,
+            update_interval: updateInterval = 0,
+            last_error: lastError = '',
         } = filter;
 
         return {
@@ -140,6 +142,8 @@ export const normalizeFilters = filters => (
             lastUpdated: last_updated,
             name,
             rulesCount: rules_count,
+            updateInterval,
+            lastError,
         };
     }) : []
 );
@@ -430,7 +434,8 @@ export const createOnBlurHandler = (event, input, normalizeOnBlur) => (
         : input.onBlur());
 
 export const checkFiltered = reason => reason.indexOf(FILTERED) === 0;
-export const checkRewrite = reason => reason === FILTERED_STATUS.REWRITE;
+export const checkRewrite = reason => reason === FILTERED_STATUS.REWRITE
+    || reason === FILTERED_STATUS.REWRITE_RULE;
 export const checkRewriteHosts = reason => reason === FILTERED_STATUS.REWRITE_HOSTS;
 export const checkBlackList = reason => reason === FILTERED_STATUS.FILTERED_BLACK_LIST;
 export const checkWhiteList = reason => reason === FILTERED_STATUS.NOT_FILTERED_WHITE_LIST;
@@ -444,11 +449,15 @@ export const getCurrentFilter = (url, filters) => {
     const filter = filters && filters.find(item => url === item.url);
 
     if (filter) {
-        const { enabled, name, url } = filter;
-        return { enabled, name, url };
+        const {
+            enabled, name, url, updateInterval,
+        } = filter;
+        return {
+            enabled, name, url, update_interval: updateInterval,
+        };
     }
 
-    return { name: '', url: '' };
+    return { name: '', url: '', update_interval: 0 };
 };
 
 /**
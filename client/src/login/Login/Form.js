@@ -46,6 +46,21 @@ const Form = (props) => {
                         validate={[required]}
                     />
                 </div>
+                <div className="form__group form__group--settings">
+                    <label className="form__label" htmlFor="otp">
+                        <Trans>otp_label</Trans>
+                    </label>
+                    <Field
+                        id="otp"
+                        name="otp"
+                        type="text"
+                        className="form-control"
+                        component={renderInputField}
+                        placeholder={t('otp_placeholder')}
+                        autoComplete="one-time-code"
+                        disabled={processing}
+                    />
+                </div>
                 <div className="form-footer">
                     <button
                         type="submit"
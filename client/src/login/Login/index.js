@@ -18,8 +18,8 @@ class Login extends Component {
         isForgotPasswordVisible: false,
     };
 
-    handleSubmit = ({ username: name, password }) => {
-        this.props.processLogin({ name, password });
+    handleSubmit = ({ username: name, password, otp }) => {
+        this.props.processLogin({ name, password, otp });
     };
 
     toggleText = () => {
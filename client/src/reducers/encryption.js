@@ -36,6 +36,7 @@ const encryption = handleActions({
             subject = '',
             warning_validation = '',
             dns_names = '',
+            ocsp_status = '',
             ...values
         } = payload;
 
@@ -49,6 +50,7 @@ const encryption = handleActions({
             subject,
             warning_validation,
             dns_names,
+            ocsp_status,
             processingValidate: false,
         };
         return newState;
@@ -60,6 +62,13 @@ const encryption = handleActions({
     enabled: false,
     dns_names: null,
     force_https: false,
+    hsts: false,
+    edns_padding: true,
+    min_version: '',
+    max_version: '',
+    cipher_suites: [],
+    client_ca_path: '',
+    pkcs12_path: '',
     issuer: '',
     key_type: '',
     not_after: '',
@@ -68,6 +77,9 @@ const encryption = handleActions({
     port_https: '',
     subject: '',
     valid_chain: false,
+    chain_complete: false,
+    days_until_expiry: 0,
+    ocsp_status: '',
     valid_key: false,
     valid_cert: false,
     valid_pair: false,
@@ -282,6 +282,31 @@ const dhcp = handleActions(
             };
             return newState;
         },
+
+        [actions.makeStaticLeaseRequest]: state => ({ ...state, processingMaking: true }),
+        [actions.makeStaticLeaseFailure]: state => ({ ...state, processingMaking: false }),
+        [actions.makeStaticLeaseSuccess]: (state, { payload }) => {
+            const { ip, mac, hostname } = payload;
+            const newState = {
+                ...state,
+                leases: state.leases.filter(item => item.mac !== mac),
+                staticLeases: [...state.staticLeases, { ip, mac, hostname }],
+                processingMaking: false,
+            };
+            return newState;
+        },
+
+        [actions.removeLeaseRequest]: state => ({ ...state, processingMaking: true }),
+        [actions.removeLeaseFailure]: state => ({ ...state, processingMaking: false }),
+        [actions.removeLeaseSuccess]: (state, { payload }) => {
+            const { ip, mac } = payload;
+            const newState = {
+                ...state,
+                leases: state.leases.filter(item => item.ip !== ip || item.mac !== mac),
+                processingMaking: false,
+            };
+            return newState;
+        },
     },
     {
         processing: true,
@@ -291,6 +316,7 @@ const dhcp = handleActions(
         processingConfig: false,
         processingAdding: false,
         processingDeleting: false,
+        processingMaking: false,
         config: {
             enabled: false,
         },
@@ -9,6 +9,9 @@ const defaultStats = {
     replacedParental: [],
     replacedSafebrowsing: [],
     topBlockedDomains: [],
+    topBlockingFilters: [],
+    queryTypes: [],
+    protocols: [],
     topClients: [],
     topQueriedDomains: [],
     numBlockedFiltering: 0,
@@ -46,6 +49,9 @@ const stats = handleActions(
                 replaced_parental: replacedParental,
                 replaced_safebrowsing: replacedSafebrowsing,
                 top_blocked_domains: topBlockedDomains,
+                top_blocking_filters: topBlockingFilters,
+                query_types: queryTypes,
+                protocols,
                 top_clients: topClients,
                 top_queried_domains: topQueriedDomains,
                 num_blocked_filtering: numBlockedFiltering,
@@ -64,6 +70,9 @@ const stats = handleActions(
                 replacedParental,
                 replacedSafebrowsing,
                 topBlockedDomains,
+                topBlockingFilters,
+                queryTypes,
+                protocols,
                 topClients,
                 normalizedTopClients: normalizeTopClients(topClients),
                 topQueriedDomains,
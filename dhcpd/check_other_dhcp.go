@@ -15,19 +15,29 @@ import (
 	"golang.org/x/net/ipv4"
 )
 
+// The interval of checking for other DHCP servers while our server is running
+const otherServerCheckInterval = 10 * time.Minute
+
 // CheckIfOtherDHCPServersPresent sends a DHCP request to the specified network interface,
 // and waits for a response for a period defined by defaultDiscoverTime
-// nolint
 func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
+	ip, err := findOtherDHCPServer(ifaceName)
+	return ip != nil, err
+}
+
+// Send DHCP Discover to the network interface and return the address of DHCP server which responded
+// Return nil if there's no other DHCP server:  the responses of our own server are skipped
+// nolint
+func findOtherDHCPServer(ifaceName string) (net.IP, error) {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't find interface by name %s", ifaceName)
+		return nil, wrapErrPrint(err, "Couldn't find interface by name %s", ifaceName)
 	}
 
 	// get ipv4 address of an interface
 	ifaceIPNet := getIfaceIPv4(iface)
 	if ifaceIPNet == nil {
-		return false, fmt.Errorf("Couldn't find IPv4 address of interface %s %+v", ifaceName, iface)
+		return nil, fmt.Errorf("Couldn't find IPv4 address of interface %s %+v", ifaceName, iface)
 	}
 
 	srcIP := ifaceIPNet.IP
@@ -41,11 +51,11 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 		err = fmt.Errorf("Generated less than 4 bytes")
 	}
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't generate random bytes")
+		return nil, wrapErrPrint(err, "Couldn't generate random bytes")
 	}
 	hostname, err := os.Hostname()
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't get hostname")
+		return nil, wrapErrPrint(err, "Couldn't get hostname")
 	}
 	requestList := []byte{
 		byte(dhcp4.OptionSubnetMask),
@@ -76,18 +86,18 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 	// resolve 0.0.0.0:68
 	udpAddr, err := net.ResolveUDPAddr("udp4", src)
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't resolve UDP address %s", src)
+		return nil, wrapErrPrint(err, "Couldn't resolve UDP address %s", src)
 	}
 	// spew.Dump(udpAddr, err)
 
 	if !udpAddr.IP.To4().Equal(srcIP) {
-		return false, wrapErrPrint(err, "Resolved UDP address is not %s", src)
+		return nil, wrapErrPrint(err, "Resolved UDP address is not %s", src)
 	}
 
 	// resolve 255.255.255.255:67
 	dstAddr, err := net.ResolveUDPAddr("udp4", dst)
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't resolve UDP address %s", dst)
+		return nil, wrapErrPrint(err, "Couldn't resolve UDP address %s", dst)
 	}
 
 	// bind to 0.0.0.0:68
@@ -97,14 +107,14 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 		defer c.Close()
 	}
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't listen on :68")
+		return nil, wrapErrPrint(err, "Couldn't listen on :68")
 	}
 
 	// send to 255.255.255.255:67
 	cm := ipv4.ControlMessage{}
 	_, err = c.WriteTo(packet, &cm, dstAddr)
 	if err != nil {
-		return false, wrapErrPrint(err, "Couldn't send a packet to %s", dst)
+		return nil, wrapErrPrint(err, "Couldn't send a packet to %s", dst)
 	}
 
 	for {
@@ -113,13 +123,13 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 		// TODO: replicate dhclient's behaviour of retrying several times with progressively bigger timeouts
 		b := make([]byte, 1500)
 		_ = c.SetReadDeadline(time.Now().Add(defaultDiscoverTime))
-		n, _, _, err = c.ReadFrom(b)
+		n, _, src, err := c.ReadFrom(b)
 		if isTimeout(err) {
 			// timed out -- no DHCP servers
-			return false, nil
+			return nil, nil
 		}
 		if err != nil {
-			return false, wrapErrPrint(err, "Couldn't receive packet")
+			return nil, wrapErrPrint(err, "Couldn't receive packet")
 		}
 		// spew.Dump(n, fromAddr, err, b)
 
@@ -144,8 +154,53 @@ func CheckIfOtherDHCPServersPresent(ifaceName string) (bool, error) {
 			continue //packet without DHCP message type
 		}
 
-		log.Tracef("The packet is from an active DHCP server")
+		serverIP := net.IP(parsedOptions[dhcp4.OptionServerIdentifier])
+		if udpAddr, ok := src.(*net.UDPAddr); ok && serverIP == nil {
+			serverIP = udpAddr.IP
+		}
+		if serverIP.Equal(srcIP) {
+			continue // the response from our own server
+		}
+
+		log.Tracef("The packet is from an active DHCP server %s", serverIP)
 		// that's a DHCP server there
-		return true, nil
+		return serverIP, nil
+	}
+}
+
+// The result of the check for other DHCP servers
+type otherServerStatus struct {
+	checked time.Time
+	ip      net.IP // the address of another DHCP server;  nil: not found
+	err     error
+}
+
+// Check for other DHCP servers on the network interface and store the result
+func (s *Server) checkOtherServer(ifaceName string) otherServerStatus {
+	ip, err := findOtherDHCPServer(ifaceName)
+	st := otherServerStatus{checked: time.Now(), ip: ip, err: err}
+	if ip != nil {
+		log.Error("DHCP: found another DHCP server %s on interface %s", ip, ifaceName)
+	} else if err != nil {
+		log.Debug("DHCP: can't check for other DHCP servers: %s", err)
+	}
+
+	s.otherServerLock.Lock()
+	s.otherServer = st
+	s.otherServerLock.Unlock()
+	return st
+}
+
+// Check for other DHCP servers periodically until stopped
+func (s *Server) checkOtherServerLoop(ifaceName string, stop chan bool) {
+	t := time.NewTicker(otherServerCheckInterval)
+	defer t.Stop()
+	for {
+		s.checkOtherServer(ifaceName)
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
 	}
 }
@@ -21,6 +21,7 @@ type leaseJSON struct {
 	IP       []byte `json:"ip"`
 	Hostname string `json:"host"`
 	Expiry   int64  `json:"exp"`
+	Device   string `json:"device,omitempty"`
 }
 
 func normalizeIP(ip net.IP) net.IP {
@@ -43,6 +44,7 @@ func ipInRange(start, stop, ip net.IP) bool {
 // Load lease table from DB
 func (s *Server) dbLoad() {
 	s.leases = nil
+	s.leases6 = nil
 	s.IPpool = make(map[[4]byte]net.HardwareAddr)
 	dynLeases := []*Lease{}
 	staticLeases := []*Lease{}
@@ -66,6 +68,19 @@ func (s *Server) dbLoad() {
 	for i := range obj {
 		obj[i].IP = normalizeIP(obj[i].IP)
 
+		if len(obj[i].IP) == net.IPv6len {
+			if s.v6InRange(obj[i].IP) {
+				s.leases6 = append(s.leases6, &Lease{
+					HWAddr:   obj[i].HWAddr,
+					IP:       obj[i].IP,
+					Hostname: obj[i].Hostname,
+					Expiry:   time.Unix(obj[i].Expiry, 0),
+					Device:   obj[i].Device,
+				})
+			}
+			continue
+		}
+
 		if obj[i].Expiry != leaseExpireStatic &&
 			!ipInRange(s.leaseStart, s.leaseStop, obj[i].IP) {
 
@@ -78,6 +93,7 @@ func (s *Server) dbLoad() {
 			IP:       obj[i].IP,
 			Hostname: obj[i].Hostname,
 			Expiry:   time.Unix(obj[i].Expiry, 0),
+			Device:   obj[i].Device,
 		}
 
 		if obj[i].Expiry == leaseExpireStatic {
@@ -93,7 +109,7 @@ func (s *Server) dbLoad() {
 		s.reserveIP(lease.IP, lease.HWAddr)
 	}
 
-	log.Info("DHCP: loaded %d (%d) leases from DB", len(s.leases), numLeases)
+	log.Info("DHCP: loaded %d (%d) leases from DB", len(s.leases)+len(s.leases6), numLeases)
 }
 
 // Skip duplicate leases
@@ -127,15 +143,17 @@ func normalizeLeases(staticLeases, dynLeases []*Lease) []*Lease {
 func (s *Server) dbStore() {
 	var leases []leaseJSON
 
-	for i := range s.leases {
-		if s.leases[i].Expiry.Unix() == 0 {
+	all := append([]*Lease{}, s.leases...)
+	for _, l := range append(all, s.leases6...) {
+		if l.Expiry.Unix() == 0 {
 			continue
 		}
 		lease := leaseJSON{
-			HWAddr:   s.leases[i].HWAddr,
-			IP:       s.leases[i].IP,
-			Hostname: s.leases[i].Hostname,
-			Expiry:   s.leases[i].Expiry.Unix(),
+			HWAddr:   l.HWAddr,
+			IP:       l.IP,
+			Hostname: l.Hostname,
+			Expiry:   l.Expiry.Unix(),
+			Device:   l.Device,
 		}
 		leases = append(leases, lease)
 	}
@@ -34,12 +34,32 @@ func convertLeases(inputLeases []Lease, includeExpires bool) []map[string]string
 		if includeExpires {
 			lease["expires"] = l.Expiry.Format(time.RFC3339)
 		}
+		if len(l.Device) != 0 {
+			lease["device"] = l.Device
+		}
 
 		leases = append(leases, lease)
 	}
 	return leases
 }
 
+// The result of the check for other DHCP servers
+type otherServerJSON struct {
+	Found   string `json:"found"`             // yes|no|error
+	IP      string `json:"ip,omitempty"`      // set if found=yes
+	Error   string `json:"error,omitempty"`   // set if found=error
+	Checked string `json:"checked,omitempty"` // the time of the last periodic check
+}
+
+func toOtherServerJSON(ip net.IP, err error) otherServerJSON {
+	if ip != nil {
+		return otherServerJSON{Found: "yes", IP: ip.String()}
+	} else if err != nil {
+		return otherServerJSON{Found: "error", Error: err.Error()}
+	}
+	return otherServerJSON{Found: "no"}
+}
+
 func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 	leases := convertLeases(s.Leases(LeasesDynamic), true)
 	staticLeases := convertLeases(s.Leases(LeasesStatic), false)
@@ -49,6 +69,15 @@ func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 		"static_leases": staticLeases,
 	}
 
+	s.otherServerLock.Lock()
+	st := s.otherServer
+	s.otherServerLock.Unlock()
+	if !st.checked.IsZero() {
+		othSrv := toOtherServerJSON(st.ip, st.err)
+		othSrv.Checked = st.checked.Format(time.RFC3339)
+		status["other_server"] = othSrv
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(status)
 	if err != nil {
@@ -57,6 +86,42 @@ func (s *Server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// The lease in GET /control/dhcp/leases response
+type leaseStatusJSON struct {
+	HWAddr   string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+	Expires  string `json:"expires,omitempty"` // not set for static leases
+	Static   bool   `json:"static"`
+	Device   string `json:"device,omitempty"` // device type/vendor guessed from DHCP fingerprint
+}
+
+// Get the list of all active leases
+func (s *Server) handleDHCPLeases(w http.ResponseWriter, r *http.Request) {
+	leases := []leaseStatusJSON{}
+	for _, l := range s.Leases(LeasesAll) {
+		lj := leaseStatusJSON{
+			HWAddr:   l.HWAddr.String(),
+			IP:       l.IP.String(),
+			Hostname: l.Hostname,
+			Device:   l.Device,
+		}
+		if l.Expiry.Unix() == leaseExpireStatic {
+			lj.Static = true
+		} else {
+			lj.Expires = l.Expiry.Format(time.RFC3339)
+		}
+		leases = append(leases, lj)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(leases)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
 type staticLeaseJSON struct {
 	HWAddr   string `json:"mac"`
 	IP       string `json:"ip"`
@@ -66,6 +131,7 @@ type staticLeaseJSON struct {
 type dhcpServerConfigJSON struct {
 	ServerConfig `json:",inline"`
 	StaticLeases []staticLeaseJSON `json:"static_leases"`
+	Force        bool              `json:"force"` // enable the server even if another DHCP server is found
 }
 
 func (s *Server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
@@ -82,6 +148,19 @@ func (s *Server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check for other DHCP servers when the server is enabled or moved to another interface
+	if newconfig.Enabled && !newconfig.Force &&
+		(!s.conf.Enabled || s.conf.InterfaceName != newconfig.InterfaceName) &&
+		len(newconfig.RangeStart) != 0 && !newconfig.ProxyDHCP {
+
+		st := s.checkOtherServer(newconfig.InterfaceName)
+		if st.ip != nil {
+			httpError(r, w, http.StatusBadRequest, "Found another DHCP server %s on interface %s",
+				st.ip, newconfig.InterfaceName)
+			return
+		}
+	}
+
 	err = s.Stop()
 	if err != nil {
 		log.Error("failed to stop the DHCP server: %s", err)
@@ -96,7 +175,7 @@ func (s *Server) handleDHCPSetConfig(w http.ResponseWriter, r *http.Request) {
 
 	if newconfig.Enabled {
 		staticIP, err := HasStaticIP(newconfig.InterfaceName)
-		if !staticIP && err == nil {
+		if !staticIP && err == nil && len(newconfig.RangeStart) != 0 && !newconfig.ProxyDHCP {
 			err = SetStaticIP(newconfig.InterfaceName)
 			if err != nil {
 				httpError(r, w, http.StatusInternalServerError, "Failed to configure static IP: %s", err)
@@ -201,17 +280,7 @@ func (s *Server) handleDHCPFindActiveServer(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	found, err := CheckIfOtherDHCPServersPresent(interfaceName)
-
-	othSrv := map[string]interface{}{}
-	foundVal := "no"
-	if found {
-		foundVal = "yes"
-	} else if err != nil {
-		foundVal = "error"
-		othSrv["error"] = err.Error()
-	}
-	othSrv["found"] = foundVal
+	othSrv := toOtherServerJSON(findOtherDHCPServer(interfaceName))
 
 	staticIP := map[string]interface{}{}
 	isStaticIP, err := HasStaticIP(interfaceName)
@@ -264,6 +333,7 @@ func (s *Server) handleDHCPAddStaticLease(w http.ResponseWriter, r *http.Request
 		httpError(r, w, http.StatusBadRequest, "%s", err)
 		return
 	}
+	s.conf.ConfigModified()
 }
 
 func (s *Server) handleDHCPRemoveStaticLease(w http.ResponseWriter, r *http.Request) {
@@ -293,6 +363,112 @@ func (s *Server) handleDHCPRemoveStaticLease(w http.ResponseWriter, r *http.Requ
 		httpError(r, w, http.StatusBadRequest, "%s", err)
 		return
 	}
+	s.conf.ConfigModified()
+}
+
+// Remove a dynamic lease
+func (s *Server) handleDHCPRemoveLease(w http.ResponseWriter, r *http.Request) {
+	lj := staticLeaseJSON{}
+	err := json.NewDecoder(r.Body).Decode(&lj)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	ip := net.ParseIP(lj.IP)
+	if ip == nil {
+		httpError(r, w, http.StatusBadRequest, "invalid IP")
+		return
+	}
+	if ip.To4() != nil {
+		ip = ip.To4()
+	}
+
+	mac, err := net.ParseMAC(lj.HWAddr)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "invalid MAC")
+		return
+	}
+
+	err = s.RemoveLease(Lease{HWAddr: mac, IP: ip})
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+}
+
+type makeStaticLeaseJSON struct {
+	HWAddr string `json:"mac"`
+}
+
+// Convert a dynamic lease to static
+func (s *Server) handleDHCPMakeStaticLease(w http.ResponseWriter, r *http.Request) {
+	req := makeStaticLeaseJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	mac, err := net.ParseMAC(req.HWAddr)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "invalid MAC")
+		return
+	}
+
+	lease, err := s.MakeLeaseStatic(mac)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	s.conf.ConfigModified()
+
+	resp := staticLeaseJSON{
+		HWAddr:   lease.HWAddr.String(),
+		IP:       lease.IP.String(),
+		Hostname: lease.Hostname,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+type importLeasesJSON struct {
+	Data string `json:"data"` // contents of the leases file and the configuration file
+}
+
+type importLeasesResultJSON struct {
+	Dynamic int `json:"dynamic"`
+	Static  int `json:"static"`
+}
+
+// Import leases from dnsmasq or ISC dhcpd files
+func (s *Server) handleDHCPImportLeases(w http.ResponseWriter, r *http.Request) {
+	req := importLeasesJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	nDynamic, nStatic := s.ImportLeases([]byte(req.Data))
+	if nStatic != 0 {
+		s.conf.ConfigModified()
+	}
+
+	resp := importLeasesResultJSON{
+		Dynamic: nDynamic,
+		Static:  nStatic,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
 }
 
 func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
@@ -301,11 +477,16 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 		log.Error("DHCP: Stop: %s", err)
 	}
 
-	err = os.Remove(s.conf.DBFilePath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Error("DHCP: os.Remove: %s: %s", s.conf.DBFilePath, err)
+	for _, srv := range append([]*Server{s}, s.ifaceServers...) {
+		err = os.Remove(srv.conf.DBFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("DHCP: os.Remove: %s: %s", srv.conf.DBFilePath, err)
+		}
 	}
 
+	s.reset()
+	s.ifaceServers = nil
+
 	oldconf := s.conf
 	s.conf = ServerConfig{}
 	s.conf.LeaseDuration = 86400
@@ -320,9 +501,13 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister("GET", "/control/dhcp/status", s.handleDHCPStatus)
 	s.conf.HTTPRegister("GET", "/control/dhcp/interfaces", s.handleDHCPInterfaces)
+	s.conf.HTTPRegister("GET", "/control/dhcp/leases", s.handleDHCPLeases)
 	s.conf.HTTPRegister("POST", "/control/dhcp/set_config", s.handleDHCPSetConfig)
 	s.conf.HTTPRegister("POST", "/control/dhcp/find_active_dhcp", s.handleDHCPFindActiveServer)
 	s.conf.HTTPRegister("POST", "/control/dhcp/add_static_lease", s.handleDHCPAddStaticLease)
 	s.conf.HTTPRegister("POST", "/control/dhcp/remove_static_lease", s.handleDHCPRemoveStaticLease)
+	s.conf.HTTPRegister("POST", "/control/dhcp/make_static_lease", s.handleDHCPMakeStaticLease)
+	s.conf.HTTPRegister("POST", "/control/dhcp/remove_lease", s.handleDHCPRemoveLease)
+	s.conf.HTTPRegister("POST", "/control/dhcp/import_leases", s.handleDHCPImportLeases)
 	s.conf.HTTPRegister("POST", "/control/dhcp/reset", s.handleReset)
 }
@@ -30,6 +30,16 @@ type Lease struct {
 	// Lease expiration time
 	// 1: static lease
 	Expiry time.Time `json:"expires"`
+
+	// Device type/vendor guessed from DHCP fingerprint, e.g. "Apple iPhone"
+	Device string `json:"device"`
+}
+
+// StaticLease - a static lease in configuration file
+type StaticLease struct {
+	HWAddr   string `yaml:"mac"`
+	IP       string `yaml:"ip"`
+	Hostname string `yaml:"hostname"`
 }
 
 // ServerConfig - DHCP server configuration
@@ -47,6 +57,30 @@ type ServerConfig struct {
 	// 0: disable
 	ICMPTimeout uint32 `json:"icmp_timeout_msec" yaml:"icmp_timeout_msec"`
 
+	// Network boot (PXE)
+	BootServer string `json:"boot_server" yaml:"boot_server"` // TFTP server address ("next-server");  default: our address
+	BootFile   string `json:"boot_file" yaml:"boot_file"`     // boot file name;  empty: network boot is disabled
+
+	// ProxyDHCP mode: don't assign the addresses, respond to PXE clients with the boot parameters only
+	// DHCPv4 range settings aren't used in this mode
+	ProxyDHCP bool `json:"proxy_dhcp" yaml:"proxy_dhcp"`
+
+	// Assign DHCPv4 addresses only to the clients with static leases
+	// The requests from other clients are ignored
+	DenyUnknownClients bool `json:"deny_unknown_clients" yaml:"deny_unknown_clients"`
+
+	// Static leases: MAC address -> IP address and host name
+	// nil: the setting is missing in configuration file of an older version,
+	//  the static leases are loaded from DB
+	StaticLeases []StaticLease `json:"-" yaml:"static_leases"`
+
+	// DHCPv6 server and Router Advertisement
+	// If DHCPv6 is enabled, DHCPv4 settings may be empty:  DHCPv4 server isn't started then
+	V6 V6ServerConf `json:"dhcpv6" yaml:"dhcpv6"`
+
+	// Additional network interfaces with their own DHCPv4 settings
+	Interfaces []InterfaceConfig `json:"interfaces" yaml:"interfaces"`
+
 	WorkDir    string `json:"-" yaml:"-"`
 	DBFilePath string `json:"-" yaml:"-"` // path to DB file
 
@@ -65,6 +99,7 @@ const (
 	LeaseChangedAddedStatic
 	LeaseChangedRemovedStatic
 	LeaseChangedBlacklisted
+	LeaseChangedRemoved
 )
 
 // Server - the current state of the DHCP server
@@ -85,14 +120,31 @@ type Server struct {
 	leaseStop    net.IP        // parsed from config RangeEnd
 	leaseTime    time.Duration // parsed from config LeaseDuration
 	leaseOptions dhcp4.Options // parsed from config GatewayIP and SubnetMask
+	bootServer   net.IP        // parsed from config BootServer
 
 	// IP address pool -- if entry is in the pool, then it's attached to a lease
 	IPpool map[[4]byte]net.HardwareAddr
 
+	// DHCPv6 leases (protected by leasesLock)
+	// HWAddr is MAC address from client's DUID, or DUID itself if it doesn't contain MAC address
+	leases6 []*Lease
+	srv6    v6Server
+
+	// The servers of additional interfaces
+	ifaceServers []*Server
+
+	// MAC address of the interface:  our own requests checking for other DHCP servers are ignored
+	ifaceMAC net.HardwareAddr
+
+	// The result of the last check for other DHCP servers
+	otherServer     otherServerStatus
+	otherServerLock sync.Mutex
+	otherServerStop chan bool // stop periodic checks
+
 	conf ServerConfig
 
 	// Called when the leases DB is modified
-	onLeaseChanged onLeaseChangedT
+	onLeaseChanged []onLeaseChangedT
 }
 
 // Print information about the available network interfaces
@@ -132,33 +184,127 @@ func Create(config ServerConfig) *Server {
 	// we can't delay database loading until DHCP server is started,
 	//  because we need static leases functionality available beforehand
 	s.dbLoad()
+	s.initInterfaces(config.StaticLeases)
 	return &s
 }
 
+// Replace the static leases loaded from DB with the leases from configuration file
+func (s *Server) loadStaticLeases(leases []StaticLease) {
+	if leases == nil {
+		return
+	}
+
+	byServer := map[*Server][]StaticLease{}
+	for _, sl := range leases {
+		ip, _ := parseIPv4(sl.IP)
+		srv := s.ifaceServerByIP(ip)
+		byServer[srv] = append(byServer[srv], sl)
+	}
+	s.replaceStaticLeases(byServer[s])
+	for _, srv := range s.ifaceServers {
+		srv.replaceStaticLeases(byServer[srv])
+	}
+}
+
+// Replace the static leases of the server
+func (s *Server) replaceStaticLeases(leases []StaticLease) {
+	s.leasesLock.Lock()
+	dynLeases := []*Lease{}
+	for _, l := range s.leases {
+		if l.Expiry.Unix() == leaseExpireStatic {
+			s.unreserveIP(l.IP)
+			continue
+		}
+		dynLeases = append(dynLeases, l)
+	}
+	s.leases = dynLeases
+
+	for _, sl := range leases {
+		ip, _ := parseIPv4(sl.IP)
+		mac, _ := net.ParseMAC(sl.HWAddr)
+		l := Lease{
+			HWAddr:   mac,
+			IP:       ip,
+			Hostname: sl.Hostname,
+		}
+		err := s.addStaticLease(l)
+		if err != nil {
+			log.Error("DHCP: static lease %s -> %s: %s", sl.HWAddr, sl.IP, err)
+		}
+	}
+	s.dbStore()
+	s.leasesLock.Unlock()
+}
+
 // Init checks the configuration and initializes the server
 func (s *Server) Init(config ServerConfig) error {
+	staticLeases := s.staticLeasesConfig()
 	err := s.setConfig(config)
 	if err != nil {
 		return err
 	}
+	s.initInterfaces(staticLeases)
 	return nil
 }
 
-// SetOnLeaseChanged - set callback
+// Reconfigure - stop the server, apply the new configuration (e.g. after the configuration file is reloaded)
+//  and start the server if it's enabled
+// The static leases are replaced with the ones from the configuration (if any).
+func (s *Server) Reconfigure(config ServerConfig) error {
+	if config.Enabled {
+		err := s.CheckConfig(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.Stop()
+	if err != nil {
+		log.Error("DHCP: failed to stop the server: %s", err)
+	}
+
+	if !config.Enabled {
+		s.conf.Enabled = false
+		s.loadStaticLeases(config.StaticLeases)
+		return nil
+	}
+
+	err = s.setConfig(config)
+	if err != nil {
+		return err
+	}
+	s.initInterfaces(config.StaticLeases)
+	return s.Start()
+}
+
+// SetOnLeaseChanged - add callback
 func (s *Server) SetOnLeaseChanged(onLeaseChanged onLeaseChangedT) {
-	s.onLeaseChanged = onLeaseChanged
+	s.onLeaseChanged = append(s.onLeaseChanged, onLeaseChanged)
 }
 
 func (s *Server) notify(flags int) {
-	if s.onLeaseChanged == nil {
-		return
+	for _, f := range s.onLeaseChanged {
+		f(flags)
 	}
-	s.onLeaseChanged(flags)
 }
 
 // WriteDiskConfig - write configuration
 func (s *Server) WriteDiskConfig(c *ServerConfig) {
 	*c = s.conf
+	c.StaticLeases = s.staticLeasesConfig()
+}
+
+// Get the static leases of all interfaces for configuration file
+func (s *Server) staticLeasesConfig() []StaticLease {
+	leases := []StaticLease{}
+	for _, l := range s.Leases(LeasesStatic) {
+		leases = append(leases, StaticLease{
+			HWAddr:   l.HWAddr.String(),
+			IP:       l.IP.String(),
+			Hostname: l.Hostname,
+		})
+	}
+	return leases
 }
 
 func (s *Server) setConfig(config ServerConfig) error {
@@ -168,11 +314,50 @@ func (s *Server) setConfig(config ServerConfig) error {
 		return wrapErrPrint(err, "Couldn't find interface by name %s", config.InterfaceName)
 	}
 
+	err = s.setConfigV6(config.V6, iface)
+	if err != nil {
+		return err
+	}
+
+	if !config.V6.Enabled || len(config.RangeStart) != 0 || config.ProxyDHCP {
+		err = s.setConfigV4(config, iface)
+		if err != nil {
+			return err
+		}
+	}
+
+	ifaceServers, err := s.setConfigInterfaces(config)
+	if err != nil {
+		return err
+	}
+	s.ifaceServers = ifaceServers
+
+	oldconf := s.conf
+	s.conf = config
+	s.conf.WorkDir = oldconf.WorkDir
+	s.conf.HTTPRegister = oldconf.HTTPRegister
+	s.conf.ConfigModified = oldconf.ConfigModified
+	s.conf.DBFilePath = oldconf.DBFilePath
+	return nil
+}
+
+func (s *Server) setConfigV4(config ServerConfig, iface *net.Interface) error {
+	var err error
+
 	// get ipv4 address of an interface
 	s.ipnet = getIfaceIPv4(iface)
 	if s.ipnet == nil {
 		return wrapErrPrint(err, "Couldn't find IPv4 address of interface %s %+v", config.InterfaceName, iface)
 	}
+	s.ifaceMAC = iface.HardwareAddr
+
+	err = s.setBootConfig(config)
+	if err != nil {
+		return err
+	}
+	if config.ProxyDHCP {
+		return nil
+	}
 
 	if config.LeaseDuration == 0 {
 		s.leaseTime = time.Hour * 2
@@ -212,41 +397,62 @@ func (s *Server) setConfig(config ServerConfig) error {
 		dhcp4.OptionRouter:           router,
 		dhcp4.OptionDomainNameServer: s.ipnet.IP,
 	}
-
-	oldconf := s.conf
-	s.conf = config
-	s.conf.WorkDir = oldconf.WorkDir
-	s.conf.HTTPRegister = oldconf.HTTPRegister
-	s.conf.ConfigModified = oldconf.ConfigModified
-	s.conf.DBFilePath = oldconf.DBFilePath
 	return nil
 }
 
-// Start will listen on port 67 and serve DHCP requests.
+// Start will listen on port 67 (and 547 if DHCPv6 is enabled) and serve DHCP requests.
 func (s *Server) Start() error {
+	if len(s.conf.RangeStart) != 0 || s.conf.ProxyDHCP || len(s.ifaceServers) != 0 {
+		err := s.startV4()
+		if err != nil {
+			return err
+		}
+	}
+	return s.startV6()
+}
+
+func (s *Server) startV4() error {
 	// TODO: don't close if interface and addresses are the same
 	if s.conn != nil {
 		_ = s.closeConn()
 	}
 
-	iface, err := net.InterfaceByName(s.conf.InterfaceName)
-	if err != nil {
-		return wrapErrPrint(err, "Couldn't find interface by name %s", s.conf.InterfaceName)
+	servers := []*Server{}
+	if len(s.conf.RangeStart) != 0 || s.conf.ProxyDHCP {
+		servers = append(servers, s)
 	}
+	servers = append(servers, s.ifaceServers...)
 
-	c, err := newFilterConn(*iface, ":67") // it has to be bound to 0.0.0.0:67, otherwise it won't see DHCP discover/request packets
+	ifaces := []net.Interface{}
+	dispatcher := &ifaceDispatcher{servers: map[int]*Server{}}
+	for _, srv := range servers {
+		iface, err := net.InterfaceByName(srv.conf.InterfaceName)
+		if err != nil {
+			return wrapErrPrint(err, "Couldn't find interface by name %s", srv.conf.InterfaceName)
+		}
+		ifaces = append(ifaces, *iface)
+		dispatcher.servers[iface.Index] = srv
+	}
+
+	c, err := newFilterConn(ifaces, ":67") // it has to be bound to 0.0.0.0:67, otherwise it won't see DHCP discover/request packets
 	if err != nil {
 		return wrapErrPrint(err, "Couldn't start listening socket on 0.0.0.0:67")
 	}
-	log.Info("DHCP: listening on 0.0.0.0:67")
+	dispatcher.conn = c
+	log.Info("DHCP: listening on 0.0.0.0:67 (%d interfaces)", len(ifaces))
 
 	s.conn = c
 	s.cond = sync.NewCond(&s.mutex)
 
+	if len(s.conf.RangeStart) != 0 && !s.conf.ProxyDHCP {
+		s.otherServerStop = make(chan bool)
+		go s.checkOtherServerLoop(s.conf.InterfaceName, s.otherServerStop)
+	}
+
 	s.running = true
 	go func() {
 		// operate on c instead of c.conn because c.conn can change over time
-		err := dhcp4.Serve(c, s)
+		err := dhcp4.Serve(c, dispatcher)
 		if err != nil && !s.stopping {
 			log.Printf("dhcp4.Serve() returned with error: %s", err)
 		}
@@ -258,8 +464,18 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop closes the listening UDP socket
+// Stop closes the listening UDP sockets
 func (s *Server) Stop() error {
+	s.stopV6()
+	return s.stopV4()
+}
+
+func (s *Server) stopV4() error {
+	if s.otherServerStop != nil {
+		close(s.otherServerStop)
+		s.otherServerStop = nil
+	}
+
 	if s.conn == nil {
 		// nothing to do, return silently
 		return nil
@@ -343,6 +559,11 @@ func (s *Server) findLease(p dhcp4.Packet) *Lease {
 	return nil
 }
 
+// Return TRUE if the lease is static
+func isStaticLease(l *Lease) bool {
+	return l != nil && l.Expiry.Unix() == leaseExpireStatic
+}
+
 // Find an expired lease and return its index or -1
 func (s *Server) findExpiredLease() int {
 	now := time.Now().Unix()
@@ -403,6 +624,14 @@ func (s *Server) unreserveIP(ip net.IP) {
 func (s *Server) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
 	s.printLeases()
 
+	if len(s.ifaceMAC) != 0 && bytes.Equal(p.CHAddr(), s.ifaceMAC) {
+		return nil // our own check for other DHCP servers
+	}
+
+	if s.conf.ProxyDHCP {
+		return s.handleProxyDHCP(p, msgType, options)
+	}
+
 	switch msgType {
 	case dhcp4.Discover: // Broadcast Packet From Client - Can I have an IP?
 		return s.handleDiscover(p, options)
@@ -511,6 +740,10 @@ func (s *Server) handleDiscover(p dhcp4.Packet, options dhcp4.Options) dhcp4.Pac
 	}
 
 	lease = s.findLease(p)
+	if s.conf.DenyUnknownClients && !isStaticLease(lease) {
+		log.Tracef("No static lease for %s: ignoring Discover", p.CHAddr())
+		return nil
+	}
 	for lease == nil {
 		lease, err = s.reserveLease(p)
 		if err != nil {
@@ -528,7 +761,9 @@ func (s *Server) handleDiscover(p dhcp4.Packet, options dhcp4.Options) dhcp4.Pac
 	}
 
 	opt := s.leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList])
+	opt = append(opt, s.bootOptions()...)
 	reply := dhcp4.ReplyPacket(p, dhcp4.Offer, s.ipnet.IP, lease.IP, s.leaseTime, opt)
+	s.setBootFields(reply)
 	log.Tracef("Replying with offer: offered IP %v for %v with options %+v", lease.IP, s.leaseTime, reply.ParseOptions())
 	return reply
 }
@@ -564,23 +799,40 @@ func (s *Server) handleDHCP4Request(p dhcp4.Packet, options dhcp4.Options) dhcp4
 		return dhcp4.ReplyPacket(p, dhcp4.NAK, s.ipnet.IP, nil, 0, nil)
 	}
 
+	if s.conf.DenyUnknownClients && !isStaticLease(lease) {
+		// the client has got the lease before the mode was enabled
+		log.Tracef("No static lease for %s: NAK", p.CHAddr())
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, s.ipnet.IP, nil, 0, nil)
+	}
+
 	if !lease.IP.Equal(reqIP) {
 		log.Tracef("Lease for %s doesn't match requested/client IP: %s vs %s",
 			lease.HWAddr, lease.IP, reqIP)
 		return dhcp4.ReplyPacket(p, dhcp4.NAK, s.ipnet.IP, nil, 0, nil)
 	}
 
+	device := requestDevice(options)
 	if lease.Expiry.Unix() != leaseExpireStatic {
 		lease.Expiry = time.Now().Add(s.leaseTime)
 		s.leasesLock.Lock()
+		if len(device) != 0 {
+			lease.Device = device
+		}
 		s.dbStore()
 		s.leasesLock.Unlock()
 		s.notify(LeaseChangedAdded) // Note: maybe we shouldn't call this function if only expiration time is updated
+	} else if len(device) != 0 {
+		s.leasesLock.Lock()
+		lease.Device = device
+		s.leasesLock.Unlock()
 	}
-	log.Tracef("Replying with ACK.  IP: %s  HW: %s  Expire: %s",
-		lease.IP, lease.HWAddr, lease.Expiry)
+	log.Tracef("Replying with ACK.  IP: %s  HW: %s  Expire: %s  Device: %s",
+		lease.IP, lease.HWAddr, lease.Expiry, lease.Device)
 	opt := s.leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList])
-	return dhcp4.ReplyPacket(p, dhcp4.ACK, s.ipnet.IP, lease.IP, s.leaseTime, opt)
+	opt = append(opt, s.bootOptions()...)
+	reply := dhcp4.ReplyPacket(p, dhcp4.ACK, s.ipnet.IP, lease.IP, s.leaseTime, opt)
+	s.setBootFields(reply)
+	return reply
 }
 
 func (s *Server) handleInform(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
@@ -607,6 +859,24 @@ func (s *Server) handleDecline(p dhcp4.Packet, options dhcp4.Options) dhcp4.Pack
 
 // AddStaticLease adds a static lease (thread-safe)
 func (s *Server) AddStaticLease(l Lease) error {
+	if srv := s.ifaceServerByIP(l.IP); srv != s {
+		return srv.AddStaticLease(l)
+	}
+
+	s.leasesLock.Lock()
+	err := s.addStaticLease(l)
+	if err != nil {
+		s.leasesLock.Unlock()
+		return err
+	}
+	s.dbStore()
+	s.leasesLock.Unlock()
+	s.notify(LeaseChangedAddedStatic)
+	return nil
+}
+
+// Add a static lease, replacing the dynamic lease with the same IP or MAC address
+func (s *Server) addStaticLease(l Lease) error {
 	if len(l.IP) != 4 {
 		return fmt.Errorf("invalid IP")
 	}
@@ -615,27 +885,51 @@ func (s *Server) AddStaticLease(l Lease) error {
 	}
 	l.Expiry = time.Unix(leaseExpireStatic, 0)
 
-	s.leasesLock.Lock()
-
 	if s.findReservedHWaddr(l.IP) != nil {
 		err := s.rmDynamicLeaseWithIP(l.IP)
 		if err != nil {
-			s.leasesLock.Unlock()
 			return err
 		}
 	} else {
 		err := s.rmDynamicLeaseWithMAC(l.HWAddr)
 		if err != nil {
-			s.leasesLock.Unlock()
 			return err
 		}
 	}
 	s.leases = append(s.leases, &l)
 	s.reserveIP(l.IP, l.HWAddr)
+	return nil
+}
+
+// MakeLeaseStatic converts the active dynamic lease with the specified MAC address
+//  to a static lease with the same IP address and host name (thread-safe)
+func (s *Server) MakeLeaseStatic(mac net.HardwareAddr) (Lease, error) {
+	now := time.Now().Unix()
+
+	s.leasesLock.Lock()
+	var lease *Lease
+	for _, l := range s.leases {
+		if bytes.Equal(l.HWAddr, mac) && l.Expiry.Unix() > now {
+			lease = l
+			break
+		}
+	}
+	if lease == nil {
+		s.leasesLock.Unlock()
+		for _, srv := range s.ifaceServers {
+			l, err := srv.MakeLeaseStatic(mac)
+			if err == nil {
+				return l, nil
+			}
+		}
+		return Lease{}, fmt.Errorf("dynamic lease for %s not found", mac)
+	}
+	lease.Expiry = time.Unix(leaseExpireStatic, 0)
+	l := *lease
 	s.dbStore()
 	s.leasesLock.Unlock()
 	s.notify(LeaseChangedAddedStatic)
-	return nil
+	return l, nil
 }
 
 // Remove a dynamic lease by IP address
@@ -698,6 +992,9 @@ func (s *Server) RemoveStaticLease(l Lease) error {
 	if len(l.HWAddr) != 6 {
 		return fmt.Errorf("invalid MAC")
 	}
+	if srv := s.ifaceServerByIP(l.IP); srv != s {
+		return srv.RemoveStaticLease(l)
+	}
 
 	s.leasesLock.Lock()
 
@@ -717,6 +1014,52 @@ func (s *Server) RemoveStaticLease(l Lease) error {
 	return nil
 }
 
+// RemoveLease removes a dynamic lease (thread-safe)
+// The client gets NAK (or NoBinding status for DHCPv6) when it tries to renew the lease
+//  and starts over again:  it's offered a new lease
+func (s *Server) RemoveLease(l Lease) error {
+	if srv := s.ifaceServerByIP(l.IP); srv != s {
+		return srv.RemoveLease(l)
+	}
+
+	s.leasesLock.Lock()
+
+	found := false
+	leases := []*Lease{}
+	for _, lease := range s.leases {
+		if bytes.Equal(lease.HWAddr, l.HWAddr) && lease.IP.Equal(l.IP) {
+			if lease.Expiry.Unix() == leaseExpireStatic {
+				s.leasesLock.Unlock()
+				return fmt.Errorf("static lease can't be removed this way")
+			}
+			s.unreserveIP(lease.IP)
+			found = true
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	s.leases = leases
+
+	leases = []*Lease{}
+	for _, lease := range s.leases6 {
+		if bytes.Equal(lease.HWAddr, l.HWAddr) && lease.IP.Equal(l.IP) {
+			found = true
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	s.leases6 = leases
+
+	if !found {
+		s.leasesLock.Unlock()
+		return fmt.Errorf("lease not found")
+	}
+	s.dbStore()
+	s.leasesLock.Unlock()
+	s.notify(LeaseChangedRemoved)
+	return nil
+}
+
 // flags for Leases() function
 const (
 	LeasesDynamic = 1
@@ -735,8 +1078,18 @@ func (s *Server) Leases(flags int) []Lease {
 			result = append(result, *lease)
 		}
 	}
+	if (flags & LeasesDynamic) != 0 {
+		for _, lease := range s.leases6 {
+			if lease.Expiry.Unix() > now {
+				result = append(result, *lease)
+			}
+		}
+	}
 	s.leasesLock.RUnlock()
 
+	for _, srv := range s.ifaceServers {
+		result = append(result, srv.Leases(flags)...)
+	}
 	return result
 }
 
@@ -753,12 +1106,20 @@ func (s *Server) printLeases() {
 func (s *Server) FindIPbyMAC(mac net.HardwareAddr) net.IP {
 	now := time.Now().Unix()
 	s.leasesLock.RLock()
-	defer s.leasesLock.RUnlock()
 	for _, l := range s.leases {
 		if l.Expiry.Unix() > now && bytes.Equal(mac, l.HWAddr) {
+			s.leasesLock.RUnlock()
 			return l.IP
 		}
 	}
+	s.leasesLock.RUnlock()
+
+	for _, srv := range s.ifaceServers {
+		ip := srv.FindIPbyMAC(mac)
+		if ip != nil {
+			return ip
+		}
+	}
 	return nil
 }
 
@@ -766,11 +1127,20 @@ func (s *Server) FindIPbyMAC(mac net.HardwareAddr) net.IP {
 func (s *Server) FindMACbyIP(ip net.IP) net.HardwareAddr {
 	now := time.Now().Unix()
 
+	ip4 := ip.To4()
+	if srv := s.ifaceServerByIP(ip4); ip4 != nil && srv != s {
+		return srv.FindMACbyIP(ip4)
+	}
+
 	s.leasesLock.RLock()
 	defer s.leasesLock.RUnlock()
 
-	ip4 := ip.To4()
 	if ip4 == nil {
+		for _, l := range s.leases6 {
+			if l.IP.Equal(ip) && l.Expiry.Unix() > now && len(l.HWAddr) == 6 {
+				return l.HWAddr
+			}
+		}
 		return nil
 	}
 
@@ -789,6 +1159,7 @@ func (s *Server) FindMACbyIP(ip net.IP) net.HardwareAddr {
 func (s *Server) reset() {
 	s.leasesLock.Lock()
 	s.leases = nil
+	s.leases6 = nil
 	s.IPpool = make(map[[4]byte]net.HardwareAddr)
 	s.leasesLock.Unlock()
 }
@@ -2,6 +2,7 @@ package dhcpd
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"os"
 	"testing"
@@ -235,6 +236,96 @@ func TestDB(t *testing.T) {
 	_ = os.Remove("leases.db")
 }
 
+// Static leases in configuration file;  convert a dynamic lease to static
+func TestStaticLeasesConfig(t *testing.T) {
+	var s = Server{}
+	s.conf.DBFilePath = dbFilename
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.reset()
+
+	// a static lease from DB is replaced by the leases from configuration file
+	s.leases = append(s.leases, &Lease{
+		HWAddr: []byte{1, 2, 3, 4, 5, 6},
+		IP:     []byte{1, 1, 1, 1},
+		Expiry: time.Unix(leaseExpireStatic, 0),
+	})
+	s.leases = append(s.leases, &Lease{
+		HWAddr: []byte{2, 2, 3, 4, 5, 6},
+		IP:     []byte{1, 1, 1, 2},
+		Expiry: time.Now().Add(time.Hour),
+	})
+	s.loadStaticLeases([]StaticLease{
+		{HWAddr: "aa:aa:aa:aa:aa:aa", IP: "1.1.1.3", Hostname: "host"},
+		{HWAddr: "invalid", IP: "1.1.1.4"},
+	})
+
+	ll := s.Leases(LeasesStatic)
+	assert.Equal(t, 1, len(ll))
+	assert.Equal(t, "aa:aa:aa:aa:aa:aa", ll[0].HWAddr.String())
+	assert.Equal(t, "1.1.1.3", ll[0].IP.String())
+	assert.Equal(t, "host", ll[0].Hostname)
+	assert.Equal(t, 1, len(s.Leases(LeasesDynamic)))
+
+	// nil: keep the leases loaded from DB
+	s.loadStaticLeases(nil)
+	assert.Equal(t, 1, len(s.Leases(LeasesStatic)))
+
+	_, err := s.MakeLeaseStatic([]byte{3, 2, 3, 4, 5, 6})
+	assert.NotNil(t, err)
+	l, err := s.MakeLeaseStatic([]byte{2, 2, 3, 4, 5, 6})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.1.1.2", l.IP.String())
+	assert.Equal(t, 0, len(s.Leases(LeasesDynamic)))
+
+	conf := ServerConfig{}
+	s.WriteDiskConfig(&conf)
+	assert.Equal(t, 2, len(conf.StaticLeases))
+	assert.Equal(t, StaticLease{HWAddr: "02:02:03:04:05:06", IP: "1.1.1.2"}, conf.StaticLeases[0])
+	assert.Equal(t, StaticLease{HWAddr: "aa:aa:aa:aa:aa:aa", IP: "1.1.1.3", Hostname: "host"}, conf.StaticLeases[1])
+}
+
+func TestRemoveLease(t *testing.T) {
+	var s = Server{}
+	s.conf.DBFilePath = dbFilename
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.reset()
+
+	s.leases = append(s.leases, &Lease{
+		HWAddr: []byte{1, 2, 3, 4, 5, 6},
+		IP:     []byte{1, 1, 1, 1},
+		Expiry: time.Now().Add(time.Hour),
+	})
+	s.reserveIP(net.IP{1, 1, 1, 1}, []byte{1, 2, 3, 4, 5, 6})
+	s.leases = append(s.leases, &Lease{
+		HWAddr: []byte{2, 2, 3, 4, 5, 6},
+		IP:     []byte{1, 1, 1, 2},
+		Expiry: time.Unix(leaseExpireStatic, 0),
+	})
+	s.leases6 = append(s.leases6, &Lease{
+		HWAddr: []byte{1, 2, 3, 4, 5, 6},
+		IP:     net.ParseIP("2001::1"),
+		Expiry: time.Now().Add(time.Hour),
+	})
+
+	// static lease
+	err := s.RemoveLease(Lease{HWAddr: []byte{2, 2, 3, 4, 5, 6}, IP: []byte{1, 1, 1, 2}})
+	assert.NotNil(t, err)
+
+	// IP doesn't match
+	err = s.RemoveLease(Lease{HWAddr: []byte{1, 2, 3, 4, 5, 6}, IP: []byte{1, 1, 1, 3}})
+	assert.NotNil(t, err)
+
+	err = s.RemoveLease(Lease{HWAddr: []byte{1, 2, 3, 4, 5, 6}, IP: []byte{1, 1, 1, 1}})
+	assert.Nil(t, err)
+	assert.Nil(t, s.findReservedHWaddr(net.IP{1, 1, 1, 1}))
+	assert.Equal(t, 2, len(s.Leases(LeasesAll)))
+
+	err = s.RemoveLease(Lease{HWAddr: []byte{1, 2, 3, 4, 5, 6}, IP: net.ParseIP("2001::1")})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(s.Leases(LeasesDynamic)))
+	assert.Equal(t, 1, len(s.Leases(LeasesStatic)))
+}
+
 func TestIsValidSubnetMask(t *testing.T) {
 	if !isValidSubnetMask([]byte{255, 255, 255, 0}) {
 		t.Fatalf("isValidSubnetMask([]byte{255,255,255,0})")
@@ -275,3 +366,67 @@ func TestNormalizeLeases(t *testing.T) {
 	assert.True(t, bytes.Equal(leases[1].HWAddr, []byte{2, 2, 3, 4}))
 	assert.True(t, bytes.Equal(leases[2].HWAddr, []byte{1, 2, 3, 5}))
 }
+
+func TestOtherServerCheck(t *testing.T) {
+	s := Server{}
+	s.reset()
+	s.leaseStart = net.IP{1, 1, 1, 1}
+	s.leaseStop = net.IP{1, 1, 1, 2}
+	s.leaseOptions = dhcp4.Options{}
+	s.ipnet = &net.IPNet{IP: net.IP{1, 1, 1, 10}, Mask: net.IPMask{255, 255, 255, 0}}
+	s.ifaceMAC = net.HardwareAddr{1, 2, 3, 4, 5, 6}
+
+	// our own Discover is ignored
+	p := dhcp4.RequestPacket(dhcp4.Discover, s.ifaceMAC, nil, []byte{1, 2, 3, 4}, true, nil)
+	assert.Nil(t, s.ServeDHCP(p, dhcp4.Discover, p.ParseOptions()))
+	assert.Equal(t, 0, len(s.IPpool))
+
+	assert.Equal(t, otherServerJSON{Found: "yes", IP: "1.1.1.1"}, toOtherServerJSON(net.IP{1, 1, 1, 1}, nil))
+	assert.Equal(t, otherServerJSON{Found: "no"}, toOtherServerJSON(nil, nil))
+	assert.Equal(t, "error", toOtherServerJSON(nil, fmt.Errorf("timeout")).Found)
+}
+
+func TestDenyUnknownClients(t *testing.T) {
+	s := Server{}
+	s.conf.DBFilePath = dbFilename
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.reset()
+	s.conf.DenyUnknownClients = true
+	s.leaseStart = []byte{1, 1, 1, 1}
+	s.leaseStop = []byte{1, 1, 1, 2}
+	s.leaseTime = 5 * time.Second
+	s.leaseOptions = dhcp4.Options{}
+	s.ipnet = &net.IPNet{
+		IP:   []byte{1, 2, 3, 4},
+		Mask: []byte{0xff, 0xff, 0xff, 0xff},
+	}
+	xid := []byte{1, 2, 3, 4}
+
+	// unknown client:  Discover is ignored, no lease is reserved
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	p := dhcp4.RequestPacket(dhcp4.Discover, hw, nil, xid, true, nil)
+	assert.Nil(t, s.ServeDHCP(p, dhcp4.Discover, p.ParseOptions()))
+	assert.Equal(t, 0, len(s.Leases(LeasesAll)))
+
+	// the client with a dynamic lease received before the mode was enabled:  Request -> NAK
+	s.leases = append(s.leases, &Lease{HWAddr: hw, IP: net.IP{1, 1, 1, 1}, Expiry: time.Now().Add(time.Hour)})
+	s.reserveIP(net.IP{1, 1, 1, 1}, hw)
+	reqOpt := []dhcp4.Option{{Code: dhcp4.OptionRequestedIPAddress, Value: []byte{1, 1, 1, 1}}}
+	p = dhcp4.RequestPacket(dhcp4.Request, hw, nil, xid, true, reqOpt)
+	reply := s.ServeDHCP(p, dhcp4.Request, p.ParseOptions())
+	assert.Equal(t, []byte{byte(dhcp4.NAK)}, reply.ParseOptions()[dhcp4.OptionDHCPMessageType])
+
+	// the client with a static lease:  Discover -> Offer, Request -> ACK
+	hw = net.HardwareAddr{2, 2, 3, 4, 5, 6}
+	assert.Nil(t, s.AddStaticLease(Lease{HWAddr: hw, IP: net.IP{1, 1, 1, 100}}))
+	p = dhcp4.RequestPacket(dhcp4.Discover, hw, nil, xid, true, nil)
+	reply = s.ServeDHCP(p, dhcp4.Discover, p.ParseOptions())
+	assert.Equal(t, []byte{byte(dhcp4.Offer)}, reply.ParseOptions()[dhcp4.OptionDHCPMessageType])
+	assert.Equal(t, "1.1.1.100", reply.YIAddr().String())
+
+	reqOpt = []dhcp4.Option{{Code: dhcp4.OptionRequestedIPAddress, Value: []byte{1, 1, 1, 100}}}
+	p = dhcp4.RequestPacket(dhcp4.Request, hw, nil, xid, true, reqOpt)
+	reply = s.ServeDHCP(p, dhcp4.Request, p.ParseOptions())
+	assert.Equal(t, []byte{byte(dhcp4.ACK)}, reply.ParseOptions()[dhcp4.OptionDHCPMessageType])
+	assert.Equal(t, "1.1.1.100", reply.YIAddr().String())
+}
@@ -7,18 +7,20 @@ import (
 	"golang.org/x/net/ipv4"
 )
 
-// filterConn listens to 0.0.0.0:67, but accepts packets only from specific interface
+// filterConn listens to 0.0.0.0:67, but accepts packets only from specific interfaces
 // This is necessary for DHCP daemon to work, since binding to IP address doesn't
 // us access to see Discover/Request packets from clients.
+// The response is sent to the interface the last packet was received on.
 //
 // TODO: on windows, controlmessage does not work, try to find out another way
 // https://github.com/golang/net/blob/master/ipv4/payload.go#L13
 type filterConn struct {
-	iface net.Interface
-	conn  *ipv4.PacketConn
+	ifaces  []net.Interface
+	ifIndex int // index of the interface the last packet was received on
+	conn    *ipv4.PacketConn
 }
 
-func newFilterConn(iface net.Interface, address string) (*filterConn, error) {
+func newFilterConn(ifaces []net.Interface, address string) (*filterConn, error) {
 	c, err := net.ListenPacket("udp4", address)
 	if err != nil {
 		return nil, errorx.Decorate(err, "Couldn't listen to %s on UDP4", address)
@@ -31,7 +33,7 @@ func newFilterConn(iface net.Interface, address string) (*filterConn, error) {
 		return nil, errorx.Decorate(err, "Couldn't set control message FlagInterface on connection")
 	}
 
-	return &filterConn{iface: iface, conn: p}, nil
+	return &filterConn{ifaces: ifaces, ifIndex: ifaces[0].Index, conn: p}, nil
 }
 
 func (f *filterConn) ReadFrom(b []byte) (int, net.Addr, error) {
@@ -42,10 +44,14 @@ func (f *filterConn) ReadFrom(b []byte) (int, net.Addr, error) {
 		}
 		if cm == nil {
 			// no controlmessage was passed, so pass the packet to the caller
+			f.ifIndex = f.ifaces[0].Index
 			return n, addr, nil
 		}
-		if cm.IfIndex == f.iface.Index {
-			return n, addr, nil
+		for _, iface := range f.ifaces {
+			if cm.IfIndex == iface.Index {
+				f.ifIndex = iface.Index
+				return n, addr, nil
+			}
 		}
 		// packet doesn't match criteria, drop it
 	}
@@ -53,7 +59,7 @@ func (f *filterConn) ReadFrom(b []byte) (int, net.Addr, error) {
 
 func (f *filterConn) WriteTo(b []byte, addr net.Addr) (int, error) {
 	cm := ipv4.ControlMessage{
-		IfIndex: f.iface.Index,
+		IfIndex: f.ifIndex,
 	}
 	return f.conn.WriteTo(b, &cm, addr)
 }
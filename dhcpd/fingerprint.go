@@ -0,0 +1,132 @@
+package dhcpd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// DHCP fingerprinting:
+//  the device type/vendor is guessed from the host name (option 12), Vendor Class Identifier (option 60)
+//  and Parameter Request List (option 55) of the client's request.
+// The host name is the most specific source (e.g. "Johns-iPhone"), the parameter list is the least specific one.
+
+// Host name substring -> device
+var hostnameDevices = []struct {
+	substr string
+	device string
+}{
+	{"iphone", "Apple iPhone"},
+	{"ipad", "Apple iPad"},
+	{"macbook", "Apple Mac"},
+	{"imac", "Apple Mac"},
+	{"apple-tv", "Apple TV"},
+	{"appletv", "Apple TV"},
+	{"watch", "Smart watch"},
+	{"galaxy", "Samsung Galaxy"},
+	{"samsung-tv", "Samsung TV"},
+	{"samsungtv", "Samsung TV"},
+	{"tizen", "Samsung TV"},
+	{"lgwebostv", "LG TV"},
+	{"webos", "LG TV"},
+	{"bravia", "Sony TV"},
+	{"chromecast", "Google Chromecast"},
+	{"google-home", "Google Home"},
+	{"googlehome", "Google Home"},
+	{"pixel", "Google Pixel"},
+	{"roku", "Roku"},
+	{"firetv", "Amazon Fire TV"},
+	{"echo", "Amazon Echo"},
+	{"kindle", "Amazon Kindle"},
+	{"playstation", "Sony PlayStation"},
+	{"ps4", "Sony PlayStation"},
+	{"ps5", "Sony PlayStation"},
+	{"xbox", "Microsoft Xbox"},
+	{"nintendo", "Nintendo Switch"},
+	{"raspberrypi", "Raspberry Pi"},
+	{"android", "Android"},
+	{"desktop-", "Windows"},
+	{"laptop-", "Windows"},
+	{"printer", "Printer"},
+	{"epson", "Epson printer"},
+	{"brother", "Brother printer"},
+	{"canon", "Canon printer"},
+}
+
+// Vendor Class Identifier prefix -> device
+var vendorClassDevices = []struct {
+	prefix string
+	device string
+}{
+	{"msft", "Windows"},
+	{"android-dhcp", "Android"},
+	{"dhcpcd", "Linux"},
+	{"udhcp", "Linux (embedded)"},
+	{"ubnt", "Ubiquiti"},
+	{"cisco", "Cisco"},
+	{"aastra", "Aastra VoIP phone"},
+	{"polycom", "Polycom VoIP phone"},
+	{"yealink", "Yealink VoIP phone"},
+	{"pxeclient", "Network boot client"},
+	{"hewlett-packard", "HP printer"},
+}
+
+// Parameter Request List -> device
+var paramListDevices = map[string]string{
+	"1,121,3,6,15,119,252,95,44,46":                      "Apple macOS",
+	"1,121,3,6,15,114,119,252,95,44,46":                  "Apple macOS",
+	"1,121,3,6,15,119,252":                               "Apple iOS",
+	"1,121,3,6,15,108,114,119,252":                       "Apple iOS",
+	"1,3,6,15,31,33,43,44,46,47,119,121,249,252":         "Windows",
+	"1,3,6,15,31,33,43,44,46,47,121,249,252":             "Windows",
+	"1,3,6,15,31,33,43,44,46,47,119,121,249,252,12":      "Windows",
+	"1,3,6,15,26,28,51,58,59":                            "Android",
+	"1,3,6,15,26,28,51,58,59,43":                         "Android",
+	"1,3,6,15,26,28,51,58,59,43,114":                     "Android",
+	"1,33,3,6,15,28,51,58,59":                            "Android",
+	"1,28,2,3,15,6,119,12,44,47,26,121,42":               "Linux",
+	"1,28,2,121,3,15,6,119,12,44,47,26,42":               "Linux",
+	"1,2,6,12,15,26,28,121,3,33,40,41,42,119,249,252,17": "Linux",
+	"1,3,6,12,15,28,42":                                  "Linux (embedded)",
+	"1,3,6,12,15,28,40,41,42":                            "Linux (embedded)",
+	"1,3,6,15,12":                                        "Linux (embedded)",
+	"1,3,28,6":                                           "IoT device",
+	"1,3,6":                                              "IoT device",
+}
+
+// Guess the device type/vendor by the host name, Vendor Class Identifier and Parameter Request List
+// Return an empty string if the device is unknown
+func guessDevice(hostname, vendorClass string, paramList []byte) string {
+	hostname = strings.ToLower(hostname)
+	for _, d := range hostnameDevices {
+		if len(hostname) != 0 && strings.Contains(hostname, d.substr) {
+			return d.device
+		}
+	}
+
+	vendorClass = strings.ToLower(vendorClass)
+	for _, d := range vendorClassDevices {
+		if len(vendorClass) != 0 && strings.HasPrefix(vendorClass, d.prefix) {
+			return d.device
+		}
+	}
+
+	return paramListDevices[paramListString(paramList)]
+}
+
+// Get Parameter Request List as a comma-separated string of option codes: "1,3,6"
+func paramListString(paramList []byte) string {
+	codes := []string{}
+	for _, c := range paramList {
+		codes = append(codes, strconv.Itoa(int(c)))
+	}
+	return strings.Join(codes, ",")
+}
+
+// Guess the device type/vendor from the client's request
+func requestDevice(options dhcp4.Options) string {
+	return guessDevice(string(options[dhcp4.OptionHostName]),
+		string(options[dhcp4.OptionVendorClassIdentifier]),
+		options[dhcp4.OptionParameterRequestList])
+}
@@ -0,0 +1,34 @@
+package dhcpd
+
+import (
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuessDevice(t *testing.T) {
+	// host name
+	assert.Equal(t, "Apple iPhone", guessDevice("Johns-iPhone", "", []byte{1, 121, 3, 6, 15, 119, 252}))
+	assert.Equal(t, "Samsung TV", guessDevice("Samsung-TV", "", nil))
+
+	// vendor class
+	assert.Equal(t, "Windows", guessDevice("WORKSTATION", "MSFT 5.0", nil))
+	assert.Equal(t, "Android", guessDevice("", "android-dhcp-10", nil))
+
+	// parameter request list
+	assert.Equal(t, "Apple iOS", guessDevice("", "", []byte{1, 121, 3, 6, 15, 119, 252}))
+	assert.Equal(t, "Windows", guessDevice("", "", []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252}))
+
+	// unknown
+	assert.Equal(t, "", guessDevice("host", "unknown", []byte{1, 2}))
+	assert.Equal(t, "", guessDevice("", "", nil))
+
+	opt := dhcp4.Options{
+		dhcp4.OptionHostName:             []byte("galaxy-s10"),
+		dhcp4.OptionParameterRequestList: []byte{1, 3, 6, 15, 26, 28, 51, 58, 59},
+	}
+	assert.Equal(t, "Samsung Galaxy", requestDevice(opt))
+	delete(opt, dhcp4.OptionHostName)
+	assert.Equal(t, "Android", requestDevice(opt))
+}
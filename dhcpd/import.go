@@ -0,0 +1,381 @@
+package dhcpd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Import leases from the configuration of another DHCP server:
+//  dnsmasq: "dhcp.leases" file and "dhcp-host=" lines of "dnsmasq.conf"
+//  ISC dhcpd: "dhcpd.leases" file and "host" declarations of "dhcpd.conf"
+// The format is detected automatically:  ISC files contain the declarations in curly braces.
+
+// Parse leases and static hosts of dnsmasq or ISC dhcpd
+func parseImportedLeases(data []byte) []Lease {
+	if bytes.IndexByte(data, '{') != -1 {
+		return parseISCLeases(data)
+	}
+	return parseDnsmasqLeases(data)
+}
+
+// Parse dnsmasq leases file and "dhcp-host=" lines
+// Lease: "<expiry time> <MAC> <IP> <hostname> <client ID>";  expiry time 0 means infinite lease
+func parseDnsmasqLeases(data []byte) []Lease {
+	leases := []Lease{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "dhcp-host=") {
+			l := parseDnsmasqHost(line[len("dhcp-host="):])
+			if l != nil {
+				leases = append(leases, *l)
+			}
+			continue
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 4 {
+			continue // "duid ..." line or an unknown format
+		}
+		exp, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		mac, err := net.ParseMAC(f[1])
+		if err != nil {
+			continue // DHCPv6 lease:  IAID instead of MAC address
+		}
+		ip, err := parseIPv4(f[2])
+		if err != nil {
+			continue
+		}
+		l := Lease{HWAddr: mac, IP: ip}
+		if f[3] != "*" {
+			l.Hostname = f[3]
+		}
+		if exp == 0 {
+			l.Expiry = time.Unix(leaseExpireStatic, 0)
+		} else {
+			l.Expiry = time.Unix(exp, 0)
+		}
+		leases = append(leases, l)
+	}
+	return leases
+}
+
+// Parse the value of "dhcp-host=" setting: comma-separated MAC address, IP address, host name, lease time, tags
+// Return nil if either MAC or IP address is missing
+func parseDnsmasqHost(s string) *Lease {
+	l := Lease{Expiry: time.Unix(leaseExpireStatic, 0)}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if mac, err := net.ParseMAC(f); err == nil {
+			l.HWAddr = mac
+		} else if ip, err := parseIPv4(f); err == nil {
+			l.IP = ip
+		} else if len(f) == 0 || strings.Contains(f, ":") || f == "infinite" || f == "ignore" ||
+			(f[0] >= '0' && f[0] <= '9') {
+			continue // tag, client ID, IPv6 address, lease time
+		} else if len(l.Hostname) == 0 {
+			l.Hostname = f
+		}
+	}
+	if l.HWAddr == nil || l.IP == nil {
+		return nil
+	}
+	return &l
+}
+
+// Split ISC configuration into tokens:  words, quoted strings, '{', '}', ';'
+func tokenizeISC(data []byte) []string {
+	tokens := []string{}
+	s := string(data)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+
+		case c == '"':
+			// the escape sequences aren't decoded:  they're used only in "uid" strings
+			start := i + 1
+			for i = start; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i > len(s) {
+				i = len(s)
+			}
+			tokens = append(tokens, s[start:i])
+			i++
+
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n{};\"#", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		}
+	}
+	return tokens
+}
+
+// Parse ISC dhcpd leases file and "host" declarations
+// The later declaration of a lease for the same IP address overrides the previous one.
+// Only the leases in "active" binding state are imported.
+func parseISCLeases(data []byte) []Lease {
+	tokens := tokenizeISC(data)
+	leases := []Lease{}
+	index := map[string]int{} // declaration type and IP -> index in "leases"
+
+	for i := 0; i < len(tokens); i++ {
+		if i+2 >= len(tokens) || tokens[i+2] != "{" ||
+			(tokens[i] != "lease" && tokens[i] != "host") {
+			continue // any other declaration:  the hosts may be declared inside "subnet" or "group"
+		}
+		decl := tokens[i]
+		name := tokens[i+1]
+		stmts, end := parseISCBlock(tokens, i+3)
+		i = end
+
+		var l *Lease
+		if decl == "lease" {
+			l = iscLease(name, stmts)
+		} else {
+			l = iscHost(name, stmts)
+		}
+		if l == nil {
+			continue
+		}
+
+		key := decl + " " + l.IP.String()
+		n, ok := index[key]
+		if ok {
+			leases[n] = *l
+			continue
+		}
+		index[key] = len(leases)
+		leases = append(leases, *l)
+	}
+
+	// the lease which isn't active anymore overrides the previous declarations, but isn't imported
+	result := []Lease{}
+	for _, l := range leases {
+		if l.HWAddr != nil {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// Get the statements of the block which starts at index "i"
+// Return the statements and the index of the closing brace
+func parseISCBlock(tokens []string, i int) ([][]string, int) {
+	stmts := [][]string{}
+	stmt := []string{}
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "{":
+			depth++
+		case "}":
+			if depth == 0 {
+				return stmts, i
+			}
+			depth--
+		case ";":
+			if depth == 0 && len(stmt) != 0 {
+				stmts = append(stmts, stmt)
+			}
+			stmt = []string{}
+		default:
+			stmt = append(stmt, tokens[i])
+		}
+	}
+	return stmts, i
+}
+
+// Get MAC address from "hardware ethernet <MAC>" statement
+func iscHardware(stmt []string) net.HardwareAddr {
+	if len(stmt) != 3 || stmt[0] != "hardware" || stmt[1] != "ethernet" {
+		return nil
+	}
+	mac, _ := net.ParseMAC(stmt[2])
+	return mac
+}
+
+// Get a lease from "lease <IP> { ... }" declaration
+// The returned lease has no MAC address if the lease isn't active
+func iscLease(name string, stmts [][]string) *Lease {
+	ip, err := parseIPv4(name)
+	if err != nil {
+		return nil
+	}
+	l := Lease{IP: ip}
+	var mac net.HardwareAddr
+	active := false
+
+	for _, stmt := range stmts {
+		switch {
+		case iscHardware(stmt) != nil:
+			mac = iscHardware(stmt)
+
+		case len(stmt) == 3 && stmt[0] == "binding" && stmt[1] == "state":
+			active = stmt[2] == "active"
+
+		case len(stmt) == 2 && stmt[0] == "client-hostname":
+			l.Hostname = stmt[1]
+
+		case len(stmt) == 2 && stmt[0] == "ends" && stmt[1] == "never":
+			l.Expiry = time.Unix(leaseExpireStatic, 0)
+
+		case len(stmt) == 3 && stmt[0] == "ends" && stmt[1] == "epoch":
+			// "db-time-format local;":  "ends epoch <seconds>"
+			sec, err := strconv.ParseInt(stmt[2], 10, 64)
+			if err != nil {
+				return nil
+			}
+			l.Expiry = time.Unix(sec, 0)
+
+		case len(stmt) == 4 && stmt[0] == "ends":
+			// "ends <weekday> <YYYY/MM/DD> <HH:MM:SS>" in UTC
+			t, err := time.Parse("2006/01/02 15:04:05", stmt[2]+" "+stmt[3])
+			if err != nil {
+				log.Debug("DHCP: import: lease %s: %s", name, err)
+				return nil
+			}
+			l.Expiry = t
+		}
+	}
+
+	if active && mac != nil && !l.Expiry.IsZero() {
+		l.HWAddr = mac
+	}
+	return &l
+}
+
+// Get a static lease from "host <name> { ... }" declaration
+func iscHost(name string, stmts [][]string) *Lease {
+	l := Lease{Hostname: name, Expiry: time.Unix(leaseExpireStatic, 0)}
+	for _, stmt := range stmts {
+		if mac := iscHardware(stmt); mac != nil {
+			l.HWAddr = mac
+		} else if len(stmt) == 2 && stmt[0] == "fixed-address" {
+			ip, err := parseIPv4(stmt[1])
+			if err == nil {
+				l.IP = ip
+			}
+		} else if len(stmt) == 3 && stmt[0] == "option" && stmt[1] == "host-name" {
+			l.Hostname = stmt[2]
+		}
+	}
+	if l.HWAddr == nil || l.IP == nil {
+		return nil
+	}
+	return &l
+}
+
+// Add the dynamic lease if its IP address is within the current range and isn't used
+func (s *Server) importDynamicLease(l Lease) error {
+	if l.Expiry.Before(time.Now()) {
+		return fmt.Errorf("expired")
+	}
+	if !ipInRange(s.leaseStart, s.leaseStop, l.IP) {
+		return fmt.Errorf("not within current IP range")
+	}
+	if s.findReservedHWaddr(l.IP) != nil {
+		return fmt.Errorf("IP address is already leased")
+	}
+	for _, it := range s.leases {
+		if bytes.Equal(it.HWAddr, l.HWAddr) {
+			return fmt.Errorf("MAC address already has a lease")
+		}
+	}
+	s.leases = append(s.leases, &l)
+	s.reserveIP(l.IP, l.HWAddr)
+	return nil
+}
+
+// ImportLeases adds the leases and static hosts from dnsmasq or ISC dhcpd files (thread-safe)
+// Return the number of imported dynamic and static leases
+func (s *Server) ImportLeases(data []byte) (int, int) {
+	byServer := map[*Server][]Lease{}
+	for _, l := range parseImportedLeases(data) {
+		srv := s.ifaceServerByIP(l.IP)
+		byServer[srv] = append(byServer[srv], l)
+	}
+
+	nDynamic := 0
+	nStatic := 0
+	for srv, leases := range byServer {
+		d, st := srv.importLeases(leases)
+		nDynamic += d
+		nStatic += st
+	}
+	log.Info("DHCP: imported %d dynamic and %d static leases", nDynamic, nStatic)
+	return nDynamic, nStatic
+}
+
+// Add the leases to the lease table of the server
+// Static leases are added first:  the dynamic leases conflicting with them are skipped.
+func (s *Server) importLeases(leases []Lease) (int, int) {
+	nDynamic := 0
+	nStatic := 0
+
+	s.leasesLock.Lock()
+	for _, l := range leases {
+		if l.Expiry.Unix() != leaseExpireStatic {
+			continue
+		}
+		err := s.addStaticLease(l)
+		if err != nil {
+			log.Debug("DHCP: import: skipping static lease %s for %s: %s", l.IP, l.HWAddr, err)
+			continue
+		}
+		nStatic++
+	}
+	for _, l := range leases {
+		if l.Expiry.Unix() == leaseExpireStatic {
+			continue
+		}
+		err := s.importDynamicLease(l)
+		if err != nil {
+			log.Debug("DHCP: import: skipping lease %s for %s: %s", l.IP, l.HWAddr, err)
+			continue
+		}
+		nDynamic++
+	}
+	if nDynamic+nStatic != 0 {
+		s.dbStore()
+	}
+	s.leasesLock.Unlock()
+
+	if nDynamic != 0 {
+		s.notify(LeaseChangedAdded)
+	}
+	if nStatic != 0 {
+		s.notify(LeaseChangedAddedStatic)
+	}
+	return nDynamic, nStatic
+}
@@ -0,0 +1,147 @@
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	data := fmt.Sprintf(`%d 00:11:22:33:44:55 192.168.1.10 laptop 01:00:11:22:33:44:55
+%d 00:11:22:33:44:56 192.168.1.11 * *
+0 00:11:22:33:44:57 192.168.1.12 printer *
+duid 00:01:00:01:26:00:00:00:00:11:22:33:44:55
+%d 1234567 2001::10 phone 00:01:00:01
+# dnsmasq.conf
+dhcp-host=00:11:22:33:44:58,192.168.1.13,nas,infinite
+dhcp-host=set:red,00:11:22:33:44:59,tv,192.168.1.14
+dhcp-host=00:11:22:33:44:60,ignore
+`, exp, exp, exp)
+
+	leases := parseImportedLeases([]byte(data))
+	assert.Equal(t, 5, len(leases))
+
+	assert.Equal(t, "00:11:22:33:44:55", leases[0].HWAddr.String())
+	assert.Equal(t, "192.168.1.10", leases[0].IP.String())
+	assert.Equal(t, "laptop", leases[0].Hostname)
+	assert.Equal(t, exp, leases[0].Expiry.Unix())
+
+	assert.Equal(t, "", leases[1].Hostname)
+
+	// infinite lease
+	assert.Equal(t, "printer", leases[2].Hostname)
+	assert.Equal(t, int64(leaseExpireStatic), leases[2].Expiry.Unix())
+
+	assert.Equal(t, "00:11:22:33:44:58", leases[3].HWAddr.String())
+	assert.Equal(t, "192.168.1.13", leases[3].IP.String())
+	assert.Equal(t, "nas", leases[3].Hostname)
+	assert.Equal(t, int64(leaseExpireStatic), leases[3].Expiry.Unix())
+
+	assert.Equal(t, "00:11:22:33:44:59", leases[4].HWAddr.String())
+	assert.Equal(t, "192.168.1.14", leases[4].IP.String())
+	assert.Equal(t, "tv", leases[4].Hostname)
+}
+
+func TestParseISCLeases(t *testing.T) {
+	data := `# dhcpd.conf
+subnet 192.168.1.0 netmask 255.255.255.0 {
+  range 192.168.1.100 192.168.1.200;
+  host nas {
+    hardware ethernet 00:11:22:33:44:58;
+    fixed-address 192.168.1.13;
+  }
+}
+host "tv" {
+  hardware ethernet 00:11:22:33:44:59;
+  fixed-address 192.168.1.14;
+  option host-name "television";
+}
+
+# dhcpd.leases
+lease 192.168.1.100 {
+  starts 4 2020/06/04 09:00:00;
+  ends 4 2099/06/04 10:00:00;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:55;
+  uid "\001\000\021\"3DU";
+  client-hostname "laptop";
+}
+lease 192.168.1.101 {
+  ends 4 2099/06/04 10:00:00;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:56;
+}
+lease 192.168.1.101 {
+  ends 4 2020/06/04 10:00:00;
+  binding state free;
+  hardware ethernet 00:11:22:33:44:56;
+}
+lease 192.168.1.102 {
+  ends never;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:57;
+}
+`
+	leases := parseImportedLeases([]byte(data))
+	assert.Equal(t, 4, len(leases))
+
+	assert.Equal(t, "00:11:22:33:44:58", leases[0].HWAddr.String())
+	assert.Equal(t, "192.168.1.13", leases[0].IP.String())
+	assert.Equal(t, "nas", leases[0].Hostname)
+	assert.Equal(t, int64(leaseExpireStatic), leases[0].Expiry.Unix())
+
+	assert.Equal(t, "television", leases[1].Hostname)
+
+	assert.Equal(t, "00:11:22:33:44:55", leases[2].HWAddr.String())
+	assert.Equal(t, "192.168.1.100", leases[2].IP.String())
+	assert.Equal(t, "laptop", leases[2].Hostname)
+	assert.Equal(t, "2099-06-04 10:00:00 +0000 UTC", leases[2].Expiry.String())
+
+	assert.Equal(t, "192.168.1.102", leases[3].IP.String())
+	assert.Equal(t, int64(leaseExpireStatic), leases[3].Expiry.Unix())
+}
+
+func TestImportLeases(t *testing.T) {
+	s := Server{}
+	s.conf.DBFilePath = dbFilename
+	defer func() { _ = os.Remove(dbFilename) }()
+	s.reset()
+	s.leaseStart = net.IP{192, 168, 1, 100}
+	s.leaseStop = net.IP{192, 168, 1, 200}
+
+	// the existing lease
+	s.leases = append(s.leases, &Lease{
+		HWAddr: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x60},
+		IP:     []byte{192, 168, 1, 103},
+		Expiry: time.Now().Add(time.Hour),
+	})
+	s.reserveIP(net.IP{192, 168, 1, 103}, []byte{0, 0x11, 0x22, 0x33, 0x44, 0x60})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	data := fmt.Sprintf(`%d 00:11:22:33:44:55 192.168.1.100 laptop *
+%d 00:11:22:33:44:56 192.168.1.101 * *
+%d 00:11:22:33:44:57 192.168.1.103 * *
+%d 00:11:22:33:44:58 192.168.2.100 * *
+%d 00:11:22:33:44:59 192.168.1.104 * *
+dhcp-host=00:11:22:33:44:56,192.168.1.101,nas
+`, exp, exp, exp, exp, exp-7200)
+
+	nDynamic, nStatic := s.ImportLeases([]byte(data))
+	assert.Equal(t, 1, nDynamic)
+	assert.Equal(t, 1, nStatic)
+
+	ll := s.Leases(LeasesStatic)
+	assert.Equal(t, 1, len(ll))
+	assert.Equal(t, "nas", ll[0].Hostname)
+
+	ll = s.Leases(LeasesDynamic)
+	assert.Equal(t, 2, len(ll))
+	assert.Equal(t, "192.168.1.100", ll[1].IP.String())
+	assert.Equal(t, "laptop", ll[1].Hostname)
+	assert.NotNil(t, s.findReservedHWaddr(net.IP{192, 168, 1, 100}))
+}
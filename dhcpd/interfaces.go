@@ -0,0 +1,108 @@
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Additional network interfaces (e.g. separate LAN and IoT/guest VLANs):
+//  each interface is served by its own Server object with its own range, gateway and lease table (DHCPv4 only).
+//  The leases are stored in a separate file "leases-<interface>.db".
+// All interfaces share one listening socket:
+//  the packets are passed to the Server of the interface they were received on.
+// Static leases are kept by the Server whose interface subnet contains the IP address.
+
+// InterfaceConfig - DHCPv4 settings of an additional network interface
+type InterfaceConfig struct {
+	InterfaceName string `json:"interface_name" yaml:"interface_name"`
+	GatewayIP     string `json:"gateway_ip" yaml:"gateway_ip"`
+	SubnetMask    string `json:"subnet_mask" yaml:"subnet_mask"`
+	RangeStart    string `json:"range_start" yaml:"range_start"`
+	RangeEnd      string `json:"range_end" yaml:"range_end"`
+	LeaseDuration uint32 `json:"lease_duration" yaml:"lease_duration"` // in seconds;  0: the same as the main interface
+}
+
+// Get the configuration of the Server for an additional interface
+// The other settings (ICMP timeout, network boot) are the same as for the main interface
+func ifaceServerConfig(config ServerConfig, ic InterfaceConfig, workDir string) ServerConfig {
+	c := config
+	c.InterfaceName = ic.InterfaceName
+	c.GatewayIP = ic.GatewayIP
+	c.SubnetMask = ic.SubnetMask
+	c.RangeStart = ic.RangeStart
+	c.RangeEnd = ic.RangeEnd
+	if ic.LeaseDuration != 0 {
+		c.LeaseDuration = ic.LeaseDuration
+	}
+	c.ProxyDHCP = false
+	c.StaticLeases = nil
+	c.V6 = V6ServerConf{}
+	c.Interfaces = nil
+	c.WorkDir = workDir
+	c.DBFilePath = filepath.Join(workDir, "leases-"+ic.InterfaceName+".db")
+	c.ConfigModified = nil
+	c.HTTPRegister = nil
+	return c
+}
+
+// Check the settings of additional interfaces and create their servers
+func (s *Server) setConfigInterfaces(config ServerConfig) ([]*Server, error) {
+	servers := []*Server{}
+	names := map[string]bool{config.InterfaceName: true}
+	for _, ic := range config.Interfaces {
+		if names[ic.InterfaceName] {
+			return nil, fmt.Errorf("interface %s is used more than once", ic.InterfaceName)
+		}
+		names[ic.InterfaceName] = true
+
+		conf := ifaceServerConfig(config, ic, s.conf.WorkDir)
+		srv := &Server{}
+		srv.conf.WorkDir = conf.WorkDir
+		srv.conf.DBFilePath = conf.DBFilePath
+		srv.reset()
+		err := srv.setConfig(conf)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %s", ic.InterfaceName, err)
+		}
+		servers = append(servers, srv)
+	}
+	return servers, nil
+}
+
+// Load the lease tables of additional interfaces and distribute the static leases among the servers
+func (s *Server) initInterfaces(staticLeases []StaticLease) {
+	for _, srv := range s.ifaceServers {
+		srv.dbLoad()
+		srv.SetOnLeaseChanged(s.notify)
+	}
+	s.loadStaticLeases(staticLeases)
+}
+
+// Get the server of the additional interface whose subnet contains the IP address
+// Return the main server if there's no such interface
+func (s *Server) ifaceServerByIP(ip net.IP) *Server {
+	for _, srv := range s.ifaceServers {
+		if srv.ipnet != nil && srv.ipnet.Contains(ip) {
+			return srv
+		}
+	}
+	return s
+}
+
+// ifaceDispatcher passes DHCPv4 packets to the server of the interface they were received on
+type ifaceDispatcher struct {
+	conn    *filterConn
+	servers map[int]*Server // interface index -> server
+}
+
+// ServeDHCP - dhcp4.Handler interface
+func (d *ifaceDispatcher) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	srv, ok := d.servers[d.conn.ifIndex]
+	if !ok {
+		return nil
+	}
+	return srv.ServeDHCP(p, msgType, options)
+}
@@ -0,0 +1,103 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+// Prepare the server for an interface with 1.1.1.0/24 (main) or 10.0.0.0/24 subnet
+func prepareIfaceServer(dbFile string, subnet byte) *Server {
+	s := &Server{}
+	s.conf.DBFilePath = dbFile
+	s.reset()
+	s.leaseStart = net.IP{subnet, 0, 0, 10}
+	s.leaseStop = net.IP{subnet, 0, 0, 20}
+	s.leaseTime = time.Hour
+	s.leaseOptions = dhcp4.Options{}
+	s.ipnet = &net.IPNet{
+		IP:   net.IP{subnet, 0, 0, 1},
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+	return s
+}
+
+func TestInterfaces(t *testing.T) {
+	s := prepareIfaceServer(dbFilename, 1)
+	iot := prepareIfaceServer("leases-iot.db", 10)
+	defer func() { _ = os.Remove(dbFilename) }()
+	defer func() { _ = os.Remove("leases-iot.db") }()
+	s.ifaceServers = []*Server{iot}
+	iot.SetOnLeaseChanged(s.notify)
+	notified := 0
+	s.SetOnLeaseChanged(func(flags int) { notified++ })
+
+	// static leases are added to the server of the interface whose subnet contains the IP
+	err := s.AddStaticLease(Lease{HWAddr: []byte{1, 2, 3, 4, 5, 6}, IP: net.IP{1, 0, 0, 5}})
+	assert.Nil(t, err)
+	err = s.AddStaticLease(Lease{HWAddr: []byte{2, 2, 3, 4, 5, 6}, IP: net.IP{10, 0, 0, 5}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(s.leases))
+	assert.Equal(t, 1, len(iot.leases))
+	assert.Equal(t, 2, notified)
+	assert.Equal(t, 2, len(s.Leases(LeasesStatic)))
+	assert.Equal(t, "02:02:03:04:05:06", s.FindMACbyIP(net.IP{10, 0, 0, 5}).String())
+
+	// the packet is passed to the server of the interface it was received on
+	d := ifaceDispatcher{
+		conn:    &filterConn{ifIndex: 3},
+		servers: map[int]*Server{2: s, 3: iot},
+	}
+	p := dhcp4.RequestPacket(dhcp4.Discover, net.HardwareAddr{3, 2, 3, 4, 5, 6}, nil, []byte{1, 2, 3, 4}, true, nil)
+	reply := d.ServeDHCP(p, dhcp4.Discover, p.ParseOptions())
+	assert.Equal(t, "10.0.0.10", reply.YIAddr().String())
+	d.conn.ifIndex = 4
+	assert.Nil(t, d.ServeDHCP(p, dhcp4.Discover, p.ParseOptions()))
+
+	// static leases from configuration file are distributed among the servers
+	conf := s.staticLeasesConfig()
+	assert.Equal(t, 2, len(conf))
+	s.loadStaticLeases([]StaticLease{
+		{HWAddr: "aa:aa:aa:aa:aa:aa", IP: "10.0.0.6", Hostname: "tv"},
+	})
+	assert.Equal(t, 0, len(s.leases))
+	ll := iot.Leases(LeasesStatic)
+	assert.Equal(t, 1, len(ll))
+	assert.Equal(t, "tv", ll[0].Hostname)
+
+	err = s.RemoveStaticLease(Lease{HWAddr: ll[0].HWAddr, IP: ll[0].IP, Hostname: "tv"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(s.Leases(LeasesStatic)))
+}
+
+func TestIfaceServerConfig(t *testing.T) {
+	config := ServerConfig{
+		InterfaceName: "eth0",
+		LeaseDuration: 86400,
+		ICMPTimeout:   1000,
+		StaticLeases:  []StaticLease{},
+		V6:            V6ServerConf{Enabled: true},
+		Interfaces: []InterfaceConfig{
+			{InterfaceName: "eth0.10", RangeStart: "10.0.0.10"},
+		},
+	}
+	c := ifaceServerConfig(config, config.Interfaces[0], "/work")
+	assert.Equal(t, "eth0.10", c.InterfaceName)
+	assert.Equal(t, "10.0.0.10", c.RangeStart)
+	assert.Equal(t, uint32(86400), c.LeaseDuration)
+	assert.Equal(t, uint32(1000), c.ICMPTimeout)
+	assert.Equal(t, "/work/leases-eth0.10.db", c.DBFilePath)
+	assert.False(t, c.V6.Enabled)
+	assert.Nil(t, c.StaticLeases)
+	assert.Nil(t, c.Interfaces)
+
+	// the same interface twice
+	config.Interfaces = []InterfaceConfig{{InterfaceName: "eth0"}}
+	s := Server{}
+	_, err := s.setConfigInterfaces(config)
+	assert.Equal(t, "interface eth0 is used more than once", err.Error())
+}
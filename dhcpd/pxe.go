@@ -0,0 +1,104 @@
+package dhcpd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/krolaw/dhcp4"
+)
+
+// Network boot (PXE):
+//  the responses contain boot server address ("next-server") and boot file name.
+// ProxyDHCP mode:
+//  the addresses are assigned by another DHCP server in the network,
+//  we respond only to PXE clients and only with the boot parameters.
+
+// Vendor Class Identifier of PXE clients
+const pxeClientID = "PXEClient"
+
+// The maximum length of "file" field of DHCP packet
+const maxBootFileLen = 127
+
+// Check and parse network boot settings
+func (s *Server) setBootConfig(config ServerConfig) error {
+	s.bootServer = nil
+	if len(config.BootFile) > maxBootFileLen {
+		return fmt.Errorf("boot file name is too long")
+	}
+	if config.ProxyDHCP && len(config.BootFile) == 0 {
+		return fmt.Errorf("boot file name is required in ProxyDHCP mode")
+	}
+
+	if len(config.BootServer) != 0 {
+		ip, err := parseIPv4(config.BootServer)
+		if err != nil {
+			return wrapErrPrint(err, "Failed to parse boot server address %s", config.BootServer)
+		}
+		s.bootServer = ip
+	} else if len(config.BootFile) != 0 {
+		s.bootServer = s.ipnet.IP
+	}
+	return nil
+}
+
+// Return TRUE if Vendor Class Identifier option of the request identifies PXE client
+func isPXEClient(options dhcp4.Options) bool {
+	return bytes.HasPrefix(options[dhcp4.OptionVendorClassIdentifier], []byte(pxeClientID))
+}
+
+// Get the options with boot parameters
+func (s *Server) bootOptions() []dhcp4.Option {
+	if len(s.conf.BootFile) == 0 {
+		return nil
+	}
+	return []dhcp4.Option{
+		{Code: dhcp4.OptionTFTPServerName, Value: []byte(s.bootServer.String())},
+		{Code: dhcp4.OptionBootFileName, Value: []byte(s.conf.BootFile)},
+	}
+}
+
+// Set boot parameters in the header of the response
+func (s *Server) setBootFields(reply dhcp4.Packet) {
+	if len(s.conf.BootFile) == 0 {
+		return
+	}
+	reply.SetSIAddr(s.bootServer)
+	reply.SetFile([]byte(s.conf.BootFile))
+}
+
+// Respond to PXE client in ProxyDHCP mode
+func (s *Server) handleProxyDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	if !isValidPacket(p) || !isPXEClient(options) {
+		return nil
+	}
+
+	mt := dhcp4.Offer
+	switch msgType {
+	case dhcp4.Discover:
+		//
+
+	case dhcp4.Request:
+		server := options[dhcp4.OptionServerIdentifier]
+		if server == nil || !net.IP(server).Equal(s.ipnet.IP) {
+			return nil // the request is for the DHCP server which assigns the addresses
+		}
+		mt = dhcp4.ACK
+
+	default:
+		return nil
+	}
+
+	log.Tracef("ProxyDHCP: %s from %s: boot file %s", msgType, p.CHAddr(), s.conf.BootFile)
+
+	opt := []dhcp4.Option{
+		{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte(pxeClientID)},
+		// PXE_DISCOVERY_CONTROL: don't use boot server discovery, download the boot file from the response
+		{Code: dhcp4.OptionVendorSpecificInformation, Value: []byte{6, 1, 8, 255}},
+	}
+	opt = append(opt, s.bootOptions()...)
+	reply := dhcp4.ReplyPacket(p, mt, s.ipnet.IP, nil, 0, opt)
+	s.setBootFields(reply)
+	return reply
+}
@@ -0,0 +1,73 @@
+package dhcpd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareServerPXE(t *testing.T, conf ServerConfig) *Server {
+	s := &Server{}
+	s.reset()
+	s.ipnet = &net.IPNet{
+		IP:   net.IP{192, 168, 1, 1},
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+	err := s.setBootConfig(conf)
+	assert.Nil(t, err)
+	s.conf = conf
+	return s
+}
+
+func TestProxyDHCP(t *testing.T) {
+	s := prepareServerPXE(t, ServerConfig{ProxyDHCP: true, BootFile: "pxelinux.0"})
+	hw := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	xid := []byte{1, 2, 3, 4}
+
+	// not a PXE client
+	p := dhcp4.RequestPacket(dhcp4.Discover, hw, nil, xid, true, nil)
+	assert.Nil(t, s.ServeDHCP(p, dhcp4.Discover, p.ParseOptions()))
+
+	// Discover -> Offer without address
+	pxeOpt := []dhcp4.Option{{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00000:UNDI:002001")}}
+	p = dhcp4.RequestPacket(dhcp4.Discover, hw, nil, xid, true, pxeOpt)
+	reply := s.ServeDHCP(p, dhcp4.Discover, p.ParseOptions())
+	opt := reply.ParseOptions()
+	assert.Equal(t, []byte{byte(dhcp4.Offer)}, opt[dhcp4.OptionDHCPMessageType])
+	assert.Equal(t, "0.0.0.0", reply.YIAddr().String())
+	assert.Equal(t, "192.168.1.1", reply.SIAddr().String())
+	assert.Equal(t, "pxelinux.0", string(reply.File()))
+	assert.Equal(t, "PXEClient", string(opt[dhcp4.OptionVendorClassIdentifier]))
+	assert.Nil(t, opt[dhcp4.OptionIPAddressLeaseTime])
+	assert.Equal(t, 0, len(s.Leases(LeasesAll)))
+
+	// Request to another server is ignored
+	reqOpt := append(pxeOpt, dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: []byte{192, 168, 1, 2}})
+	p = dhcp4.RequestPacket(dhcp4.Request, hw, nil, xid, true, reqOpt)
+	assert.Nil(t, s.ServeDHCP(p, dhcp4.Request, p.ParseOptions()))
+
+	// Request to us -> ACK
+	reqOpt = append(pxeOpt, dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: []byte{192, 168, 1, 1}})
+	p = dhcp4.RequestPacket(dhcp4.Request, hw, nil, xid, true, reqOpt)
+	reply = s.ServeDHCP(p, dhcp4.Request, p.ParseOptions())
+	assert.Equal(t, []byte{byte(dhcp4.ACK)}, reply.ParseOptions()[dhcp4.OptionDHCPMessageType])
+	assert.Equal(t, "pxelinux.0", string(reply.File()))
+}
+
+func TestBootConfig(t *testing.T) {
+	s := prepareServerPXE(t, ServerConfig{BootServer: "192.168.1.10", BootFile: "boot.efi"})
+	assert.Equal(t, "192.168.1.10", s.bootServer.String())
+	opts := s.bootOptions()
+	assert.Equal(t, 2, len(opts))
+	assert.Equal(t, "192.168.1.10", string(opts[0].Value))
+	assert.Equal(t, "boot.efi", string(opts[1].Value))
+
+	// network boot is disabled
+	s = prepareServerPXE(t, ServerConfig{})
+	assert.Nil(t, s.bootOptions())
+
+	assert.NotNil(t, s.setBootConfig(ServerConfig{ProxyDHCP: true}))
+	assert.NotNil(t, s.setBootConfig(ServerConfig{BootServer: "::1", BootFile: "boot.efi"}))
+}
@@ -0,0 +1,406 @@
+package dhcpd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/net/ipv6"
+)
+
+// The time the address offered to a client is reserved for it
+const v6OfferTime = time.Minute
+
+// V6ServerConf - DHCPv6 server configuration
+type V6ServerConf struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// The first IP address of dynamic leases
+	// The last byte of the address is incremented for the next lease:
+	//  the addresses up to the one with the last byte 0xff are assigned
+	// It also sets the network prefix (/64) advertised by Router Advertisement messages
+	RangeStart string `json:"range_start" yaml:"range_start"`
+
+	LeaseDuration uint32 `json:"lease_duration" yaml:"lease_duration"` // in seconds
+
+	// Don't assign the addresses by DHCPv6:
+	//  the clients configure their addresses by themselves (SLAAC) using the prefix from Router Advertisement,
+	//  DHCPv6 server only provides DNS server address
+	RASLAACOnly bool `json:"ra_slaac_only" yaml:"ra_slaac_only"`
+
+	// Allow the clients to configure their addresses by themselves (SLAAC) in addition to DHCPv6
+	RAAllowSLAAC bool `json:"ra_allow_slaac" yaml:"ra_allow_slaac"`
+}
+
+// The state of DHCPv6 server
+type v6Server struct {
+	rangeStart net.IP        // parsed from config RangeStart
+	leaseTime  time.Duration // parsed from config LeaseDuration
+	serverID   []byte        // DUID-LL of the network interface
+	dnsIP      net.IP        // the address of the network interface which is advertised as DNS server
+
+	conn   *ipv6.PacketConn // DHCPv6 socket
+	raConn *ipv6.PacketConn // ICMPv6 socket for Router Advertisement messages
+	stop   chan bool
+	wg     sync.WaitGroup
+}
+
+// Get the IPv6 address of the network interface:  a global unicast address is preferred
+func getIfaceIPv6(iface *net.Interface) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var linkLocal net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil {
+			continue
+		}
+		if ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP
+		}
+		if ipnet.IP.IsLinkLocalUnicast() && linkLocal == nil {
+			linkLocal = ipnet.IP
+		}
+	}
+	return linkLocal
+}
+
+func (s *Server) setConfigV6(conf V6ServerConf, iface *net.Interface) error {
+	if !conf.Enabled {
+		return nil
+	}
+
+	ip := net.ParseIP(conf.RangeStart)
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf("DHCPv6: %s is not an IPv6 address", conf.RangeStart)
+	}
+	s.srv6.rangeStart = ip.To16()
+
+	if conf.LeaseDuration == 0 {
+		s.srv6.leaseTime = time.Hour * 24
+	} else {
+		s.srv6.leaseTime = time.Second * time.Duration(conf.LeaseDuration)
+	}
+
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("DHCPv6: interface %s has no MAC address", iface.Name)
+	}
+	s.srv6.serverID = duidFromMAC(iface.HardwareAddr)
+
+	s.srv6.dnsIP = getIfaceIPv6(iface)
+	if s.srv6.dnsIP == nil {
+		return fmt.Errorf("DHCPv6: couldn't find IPv6 address of interface %s", iface.Name)
+	}
+	return nil
+}
+
+// Return TRUE if IP address is within the range of dynamic leases
+func (s *Server) v6InRange(ip net.IP) bool {
+	start := s.srv6.rangeStart
+	return len(start) == net.IPv6len && len(ip) == net.IPv6len &&
+		bytes.Equal(start[:15], ip[:15]) && ip[15] >= start[15]
+}
+
+// Get the client's identifier which is stored in a lease as HWAddr:
+//  MAC address if DUID contains it, or DUID itself
+func clientIDToHWAddr(clientID []byte) net.HardwareAddr {
+	mac := macFromDUID(clientID)
+	if mac != nil {
+		return mac
+	}
+	return net.HardwareAddr(append([]byte{}, clientID...))
+}
+
+func (s *Server) findLease6(hwaddr net.HardwareAddr) *Lease {
+	for _, l := range s.leases6 {
+		if bytes.Equal(l.HWAddr, hwaddr) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Create a lease for the client:  find a free IP address or reuse an expired lease
+func (s *Server) reserveLease6(hwaddr net.HardwareAddr) *Lease {
+	used := map[byte]bool{}
+	for _, l := range s.leases6 {
+		if s.v6InRange(l.IP) {
+			used[l.IP[15]] = true
+		}
+	}
+
+	start := s.srv6.rangeStart
+	for i := int(start[15]); i <= 0xff; i++ {
+		if used[byte(i)] {
+			continue
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, start)
+		ip[15] = byte(i)
+		l := &Lease{HWAddr: hwaddr, IP: ip}
+		s.leases6 = append(s.leases6, l)
+		return l
+	}
+
+	now := time.Now().Unix()
+	for _, l := range s.leases6 {
+		if l.Expiry.Unix() <= now && l.Expiry.Unix() != leaseExpireStatic {
+			log.Tracef("DHCPv6: assigning IP address %s to %s (lease for %s expired at %s)",
+				l.IP, hwaddr, l.HWAddr, l.Expiry)
+			l.HWAddr = hwaddr
+			l.Hostname = ""
+			return l
+		}
+	}
+	return nil
+}
+
+// Remove the lease of the client
+func (s *Server) releaseLease6(hwaddr net.HardwareAddr) {
+	leases := []*Lease{}
+	for _, l := range s.leases6 {
+		if bytes.Equal(l.HWAddr, hwaddr) {
+			continue
+		}
+		leases = append(leases, l)
+	}
+	s.leases6 = leases
+	s.dbStore()
+}
+
+// Assign the address for IA_NA option and add IA_NA to the response
+// Return TRUE if the lease is committed
+func (s *Server) processIANA6(req, resp *dhcp6Msg, hwaddr net.HardwareAddr) bool {
+	data := req.option(dhcp6OptIANA)
+	if data == nil {
+		return false
+	}
+	ia, err := parseDHCP6IANA(data)
+	if err != nil {
+		log.Debug("DHCPv6: %s", err)
+		return false
+	}
+	ia.options = nil
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	lease := s.findLease6(hwaddr)
+	if lease == nil && (req.msgType == dhcp6Solicit || req.msgType == dhcp6Request) {
+		lease = s.reserveLease6(hwaddr)
+	}
+	if lease == nil {
+		status := dhcp6StatusNoBinding
+		if req.msgType == dhcp6Solicit || req.msgType == dhcp6Request {
+			status = dhcp6StatusNoAddrsAvail
+			log.Info("DHCPv6: no free IP addresses for %s", hwaddr)
+		}
+		ia.options = append(ia.options, dhcp6Option{code: dhcp6OptStatusCode,
+			data: packDHCP6Status(uint16(status), "")})
+		resp.addOption(dhcp6OptIANA, ia.pack())
+		return false
+	}
+
+	commit := resp.msgType == dhcp6Reply
+	now := time.Now()
+	if commit {
+		lease.Expiry = now.Add(s.srv6.leaseTime)
+		hostname := parseDHCP6ClientFQDN(req.option(dhcp6OptClientFQDN))
+		if len(hostname) != 0 {
+			lease.Hostname = hostname
+		}
+		log.Debug("DHCPv6: lease %s for %s (%s) is committed", lease.IP, lease.HWAddr, lease.Hostname)
+		s.dbStore()
+	} else if lease.Expiry.Before(now.Add(v6OfferTime)) {
+		lease.Expiry = now.Add(v6OfferTime)
+	}
+
+	valid := uint32(s.srv6.leaseTime.Seconds())
+	ia.t1 = valid / 2
+	ia.t2 = valid / 5 * 4
+	ia.options = append(ia.options, dhcp6Option{code: dhcp6OptIAAddr,
+		data: packDHCP6IAAddr(lease.IP, valid, valid)})
+	resp.addOption(dhcp6OptIANA, ia.pack())
+	return commit
+}
+
+// Return TRUE if all addresses in IA_NA options of Confirm message belong to our network
+func (s *Server) onLink6(req *dhcp6Msg) bool {
+	for _, o := range req.options {
+		if o.code != dhcp6OptIANA {
+			continue
+		}
+		ia, err := parseDHCP6IANA(o.data)
+		if err != nil {
+			return false
+		}
+		for _, ao := range ia.options {
+			if ao.code != dhcp6OptIAAddr || len(ao.data) < net.IPv6len {
+				continue
+			}
+			if !bytes.Equal(ao.data[:8], s.srv6.rangeStart[:8]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Process DHCPv6 message from a client and get the response
+// Return nil if the message must be discarded
+func (s *Server) process6(req *dhcp6Msg) *dhcp6Msg {
+	clientID := req.option(dhcp6OptClientID)
+	serverID := req.option(dhcp6OptServerID)
+	switch req.msgType {
+	case dhcp6Solicit, dhcp6Rebind, dhcp6Confirm:
+		if clientID == nil || serverID != nil {
+			return nil
+		}
+	case dhcp6Request, dhcp6Renew, dhcp6Release, dhcp6Decline:
+		if clientID == nil || !bytes.Equal(serverID, s.srv6.serverID) {
+			return nil
+		}
+	case dhcp6InformationRequest:
+		if serverID != nil && !bytes.Equal(serverID, s.srv6.serverID) {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	resp := &dhcp6Msg{msgType: dhcp6Reply, xid: req.xid}
+	rapidCommit := req.msgType == dhcp6Solicit && req.hasOption(dhcp6OptRapidCommit)
+	if req.msgType == dhcp6Solicit && !rapidCommit {
+		resp.msgType = dhcp6Advertise
+	}
+	if clientID != nil {
+		resp.addOption(dhcp6OptClientID, clientID)
+	}
+	resp.addOption(dhcp6OptServerID, s.srv6.serverID)
+	if rapidCommit {
+		resp.addOption(dhcp6OptRapidCommit, nil)
+	}
+
+	committed := false
+	switch req.msgType {
+	case dhcp6Solicit, dhcp6Request, dhcp6Renew, dhcp6Rebind:
+		if !s.conf.V6.RASLAACOnly {
+			committed = s.processIANA6(req, resp, clientIDToHWAddr(clientID))
+		}
+
+	case dhcp6Confirm:
+		status := dhcp6StatusSuccess
+		if !s.onLink6(req) {
+			status = dhcp6StatusNotOnLink
+		}
+		resp.addOption(dhcp6OptStatusCode, packDHCP6Status(uint16(status), ""))
+
+	case dhcp6Release, dhcp6Decline:
+		s.leasesLock.Lock()
+		s.releaseLease6(clientIDToHWAddr(clientID))
+		s.leasesLock.Unlock()
+		resp.addOption(dhcp6OptStatusCode, packDHCP6Status(dhcp6StatusSuccess, ""))
+	}
+
+	resp.addOption(dhcp6OptDNSServers, s.srv6.dnsIP.To16())
+
+	if committed {
+		s.notify(LeaseChangedAdded)
+	}
+	return resp
+}
+
+// Read DHCPv6 messages from the network interface and respond to them
+func (s *Server) serve6(conn *ipv6.PacketConn, iface *net.Interface) {
+	defer s.srv6.wg.Done()
+
+	buf := make([]byte, 4096)
+	for {
+		n, cm, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Debug("DHCPv6: %s", err)
+			return
+		}
+		if cm != nil && cm.IfIndex != iface.Index {
+			continue
+		}
+
+		req, err := parseDHCP6(buf[:n])
+		if err != nil {
+			log.Debug("DHCPv6: invalid message from %s: %s", src, err)
+			continue
+		}
+		log.Tracef("DHCPv6: message %d from %s", req.msgType, src)
+
+		resp := s.process6(req)
+		if resp == nil {
+			continue
+		}
+		_, err = conn.WriteTo(resp.pack(), nil, src)
+		if err != nil {
+			log.Debug("DHCPv6: can't send the response to %s: %s", src, err)
+		}
+	}
+}
+
+// Start DHCPv6 server and Router Advertisement sender
+func (s *Server) startV6() error {
+	if !s.conf.V6.Enabled {
+		return nil
+	}
+	s.stopV6()
+
+	iface, err := net.InterfaceByName(s.conf.InterfaceName)
+	if err != nil {
+		return wrapErrPrint(err, "Couldn't find interface by name %s", s.conf.InterfaceName)
+	}
+
+	c, err := net.ListenPacket("udp6", "[::]:547")
+	if err != nil {
+		return wrapErrPrint(err, "Couldn't start listening socket on [::]:547")
+	}
+	conn := ipv6.NewPacketConn(c)
+	err = conn.JoinGroup(iface, &net.UDPAddr{IP: net.ParseIP("ff02::1:2")})
+	if err == nil {
+		err = conn.SetControlMessage(ipv6.FlagInterface, true)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return wrapErrPrint(err, "DHCPv6: couldn't set up the socket")
+	}
+
+	raConn, err := newRAConn(iface)
+	if err != nil {
+		_ = conn.Close()
+		return wrapErrPrint(err, "DHCPv6: couldn't set up the socket for Router Advertisement")
+	}
+	log.Info("DHCPv6: listening on [::]:547")
+
+	s.srv6.conn = conn
+	s.srv6.raConn = raConn
+	s.srv6.stop = make(chan bool)
+	s.srv6.wg.Add(3)
+	go s.serve6(conn, iface)
+	go s.serveRS(raConn, iface)
+	go s.sendRALoop(raConn, iface, s.srv6.stop)
+	return nil
+}
+
+// Stop DHCPv6 server and wait until its goroutines exit
+func (s *Server) stopV6() {
+	if s.srv6.conn == nil {
+		return
+	}
+	close(s.srv6.stop)
+	_ = s.srv6.conn.Close()
+	_ = s.srv6.raConn.Close()
+	s.srv6.wg.Wait()
+	s.srv6.conn = nil
+	s.srv6.raConn = nil
+}
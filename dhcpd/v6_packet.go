@@ -0,0 +1,219 @@
+package dhcpd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DHCPv6 message types (RFC 8415)
+const (
+	dhcp6Solicit            = 1
+	dhcp6Advertise          = 2
+	dhcp6Request            = 3
+	dhcp6Confirm            = 4
+	dhcp6Renew              = 5
+	dhcp6Rebind             = 6
+	dhcp6Reply              = 7
+	dhcp6Release            = 8
+	dhcp6Decline            = 9
+	dhcp6InformationRequest = 11
+)
+
+// DHCPv6 options
+const (
+	dhcp6OptClientID    = 1
+	dhcp6OptServerID    = 2
+	dhcp6OptIANA        = 3
+	dhcp6OptIAAddr      = 5
+	dhcp6OptStatusCode  = 13
+	dhcp6OptRapidCommit = 14
+	dhcp6OptDNSServers  = 23
+	dhcp6OptClientFQDN  = 39
+)
+
+// DHCPv6 status codes
+const (
+	dhcp6StatusSuccess      = 0
+	dhcp6StatusNoAddrsAvail = 2
+	dhcp6StatusNoBinding    = 3
+	dhcp6StatusNotOnLink    = 4
+)
+
+type dhcp6Option struct {
+	code uint16
+	data []byte
+}
+
+// DHCPv6 message: client/server message format (relay messages aren't supported)
+type dhcp6Msg struct {
+	msgType byte
+	xid     [3]byte // transaction ID
+	options []dhcp6Option
+}
+
+// Parse the sequence of options
+func parseDHCP6Options(b []byte) ([]dhcp6Option, error) {
+	opts := []dhcp6Option{}
+	for len(b) != 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("option header is too short")
+		}
+		code := binary.BigEndian.Uint16(b)
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return nil, fmt.Errorf("option %d: data is too short", code)
+		}
+		opts = append(opts, dhcp6Option{code: code, data: b[4 : 4+n]})
+		b = b[4+n:]
+	}
+	return opts, nil
+}
+
+// Serialize the sequence of options
+func packDHCP6Options(opts []dhcp6Option) []byte {
+	b := []byte{}
+	for _, o := range opts {
+		hdr := make([]byte, 4)
+		binary.BigEndian.PutUint16(hdr, o.code)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(o.data)))
+		b = append(b, hdr...)
+		b = append(b, o.data...)
+	}
+	return b
+}
+
+func parseDHCP6(b []byte) (*dhcp6Msg, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("message is too short")
+	}
+	m := dhcp6Msg{msgType: b[0]}
+	copy(m.xid[:], b[1:4])
+	var err error
+	m.options, err = parseDHCP6Options(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *dhcp6Msg) pack() []byte {
+	b := []byte{m.msgType, m.xid[0], m.xid[1], m.xid[2]}
+	return append(b, packDHCP6Options(m.options)...)
+}
+
+// Get the data of the first option with the specified code
+// Return nil if there's no such option
+func (m *dhcp6Msg) option(code uint16) []byte {
+	for _, o := range m.options {
+		if o.code == code {
+			return o.data
+		}
+	}
+	return nil
+}
+
+func (m *dhcp6Msg) hasOption(code uint16) bool {
+	for _, o := range m.options {
+		if o.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *dhcp6Msg) addOption(code uint16, data []byte) {
+	m.options = append(m.options, dhcp6Option{code: code, data: data})
+}
+
+// Identity association for non-temporary addresses
+type dhcp6IANA struct {
+	iaid    uint32
+	t1      uint32 // in seconds
+	t2      uint32 // in seconds
+	options []dhcp6Option
+}
+
+func parseDHCP6IANA(b []byte) (*dhcp6IANA, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("IA_NA option is too short")
+	}
+	ia := dhcp6IANA{
+		iaid: binary.BigEndian.Uint32(b),
+		t1:   binary.BigEndian.Uint32(b[4:]),
+		t2:   binary.BigEndian.Uint32(b[8:]),
+	}
+	var err error
+	ia.options, err = parseDHCP6Options(b[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &ia, nil
+}
+
+func (ia *dhcp6IANA) pack() []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b, ia.iaid)
+	binary.BigEndian.PutUint32(b[4:], ia.t1)
+	binary.BigEndian.PutUint32(b[8:], ia.t2)
+	return append(b, packDHCP6Options(ia.options)...)
+}
+
+// Get IA Address option data
+func packDHCP6IAAddr(ip net.IP, preferred, valid uint32) []byte {
+	b := make([]byte, 24)
+	copy(b, ip.To16())
+	binary.BigEndian.PutUint32(b[16:], preferred)
+	binary.BigEndian.PutUint32(b[20:], valid)
+	return b
+}
+
+// Get Status Code option data
+func packDHCP6Status(code uint16, msg string) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, code)
+	return append(b, []byte(msg)...)
+}
+
+// Get MAC address from DUID of type DUID-LLT or DUID-LL with Ethernet hardware type
+// Return nil if DUID doesn't contain MAC address
+func macFromDUID(duid []byte) net.HardwareAddr {
+	if len(duid) < 4 || binary.BigEndian.Uint16(duid[2:]) != 1 {
+		return nil
+	}
+	var mac []byte
+	switch binary.BigEndian.Uint16(duid) {
+	case 1: // DUID-LLT: type, hardware type, time, link-layer address
+		if len(duid) == 14 {
+			mac = duid[8:]
+		}
+	case 3: // DUID-LL: type, hardware type, link-layer address
+		if len(duid) == 10 {
+			mac = duid[4:]
+		}
+	}
+	if mac == nil {
+		return nil
+	}
+	return net.HardwareAddr(append([]byte{}, mac...))
+}
+
+// Get DUID-LL from MAC address
+func duidFromMAC(mac net.HardwareAddr) []byte {
+	duid := []byte{0, 3, 0, 1} // DUID-LL, Ethernet
+	return append(duid, mac...)
+}
+
+// Get the first label of the domain name in Client FQDN option:  it's used as a host name
+func parseDHCP6ClientFQDN(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	b = b[1:] // skip flags
+	n := int(b[0])
+	if len(b) < 1+n {
+		return ""
+	}
+	return strings.ToLower(string(b[1 : 1+n]))
+}
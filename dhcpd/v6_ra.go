@@ -0,0 +1,181 @@
+package dhcpd
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/net/ipv6"
+)
+
+// Router Advertisement (RFC 4861) with Recursive DNS Server option (RFC 8106):
+//  the clients learn the network prefix, whether to use DHCPv6 and our DNS server address.
+// Router lifetime is 0:  we aren't a default router, the clients must not route their traffic through us.
+
+// The interval of unsolicited Router Advertisement messages
+const raInterval = time.Minute
+
+// The lifetime (in seconds) of the prefix and DNS server in Router Advertisement
+const raLifetime = 3600
+
+// Router Advertisement parameters
+type raParams struct {
+	managed   bool             // "Managed address configuration" flag: use DHCPv6 to obtain the address
+	other     bool             // "Other configuration" flag: use DHCPv6 to obtain DNS server address, etc.
+	slaac     bool             // "Autonomous address-configuration" flag of the prefix
+	prefix    net.IP           // network prefix (/64)
+	dnsIP     net.IP           // DNS server address
+	sourceMAC net.HardwareAddr // MAC address of the network interface
+	mtu       uint32
+}
+
+// Get the parameters of Router Advertisement from configuration
+func (s *Server) raParams(iface *net.Interface) raParams {
+	p := raParams{
+		managed:   !s.conf.V6.RASLAACOnly,
+		other:     true,
+		slaac:     s.conf.V6.RASLAACOnly || s.conf.V6.RAAllowSLAAC,
+		prefix:    make(net.IP, net.IPv6len),
+		dnsIP:     s.srv6.dnsIP,
+		sourceMAC: iface.HardwareAddr,
+		mtu:       uint32(iface.MTU),
+	}
+	copy(p.prefix, s.srv6.rangeStart[:8])
+	return p
+}
+
+// Get ICMPv6 Router Advertisement message
+// The checksum is calculated by the kernel
+func buildRA(p raParams) []byte {
+	b := make([]byte, 16)
+	b[0] = byte(ipv6.ICMPTypeRouterAdvertisement)
+	b[4] = 64 // current hop limit
+	if p.managed {
+		b[5] |= 0x80
+	}
+	if p.other {
+		b[5] |= 0x40
+	}
+	// router lifetime, reachable time and retransmission timer are 0
+
+	// Prefix Information
+	opt := make([]byte, 32)
+	opt[0] = 3
+	opt[1] = 4 // length in units of 8 bytes
+	opt[2] = 64
+	opt[3] = 0x80 // on-link
+	if p.slaac {
+		opt[3] |= 0x40
+	}
+	binary.BigEndian.PutUint32(opt[4:], raLifetime) // valid lifetime
+	binary.BigEndian.PutUint32(opt[8:], raLifetime) // preferred lifetime
+	copy(opt[16:], p.prefix.To16())
+	b = append(b, opt...)
+
+	// Recursive DNS Server
+	opt = make([]byte, 24)
+	opt[0] = 25
+	opt[1] = 3
+	binary.BigEndian.PutUint32(opt[4:], raLifetime)
+	copy(opt[8:], p.dnsIP.To16())
+	b = append(b, opt...)
+
+	// Source Link-Layer Address
+	if len(p.sourceMAC) == 6 {
+		opt = []byte{1, 1}
+		opt = append(opt, p.sourceMAC...)
+		b = append(b, opt...)
+	}
+
+	// MTU
+	if p.mtu != 0 {
+		opt = make([]byte, 8)
+		opt[0] = 5
+		opt[1] = 1
+		binary.BigEndian.PutUint32(opt[4:], p.mtu)
+		b = append(b, opt...)
+	}
+
+	return b
+}
+
+// Create ICMPv6 socket for sending Router Advertisement and receiving Router Solicitation messages
+func newRAConn(iface *net.Interface) (*ipv6.PacketConn, error) {
+	c, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+	conn := ipv6.NewPacketConn(c)
+
+	// the messages with hop limit other than 255 are discarded by the clients
+	err = conn.SetMulticastHopLimit(255)
+	if err == nil {
+		err = conn.SetHopLimit(255)
+	}
+	if err == nil {
+		err = conn.SetMulticastInterface(iface)
+	}
+	if err == nil {
+		err = conn.JoinGroup(iface, &net.IPAddr{IP: net.IPv6linklocalallrouters})
+	}
+	if err == nil {
+		f := ipv6.ICMPFilter{}
+		f.SetAll(true)
+		f.Accept(ipv6.ICMPTypeRouterSolicitation)
+		err = conn.SetICMPFilter(&f)
+	}
+	if err == nil {
+		err = conn.SetControlMessage(ipv6.FlagInterface, true)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Send Router Advertisement message to all nodes on the link
+func (s *Server) sendRA(conn *ipv6.PacketConn, iface *net.Interface) {
+	dst := &net.IPAddr{IP: net.IPv6linklocalallnodes, Zone: iface.Name}
+	_, err := conn.WriteTo(buildRA(s.raParams(iface)), nil, dst)
+	if err != nil {
+		log.Debug("DHCPv6: can't send Router Advertisement: %s", err)
+	}
+}
+
+// Send Router Advertisement messages periodically until stopped
+func (s *Server) sendRALoop(conn *ipv6.PacketConn, iface *net.Interface, stop chan bool) {
+	defer s.srv6.wg.Done()
+
+	t := time.NewTicker(raInterval)
+	defer t.Stop()
+	for {
+		s.sendRA(conn, iface)
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Respond to Router Solicitation messages until the socket is closed
+func (s *Server) serveRS(conn *ipv6.PacketConn, iface *net.Interface) {
+	defer s.srv6.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, cm, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Debug("DHCPv6: %s", err)
+			return
+		}
+		if n == 0 || buf[0] != byte(ipv6.ICMPTypeRouterSolicitation) ||
+			(cm != nil && cm.IfIndex != iface.Index) {
+			continue
+		}
+		log.Tracef("DHCPv6: Router Solicitation from %s", src)
+		s.sendRA(conn, iface)
+	}
+}
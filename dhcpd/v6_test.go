@@ -0,0 +1,167 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareServer6() *Server {
+	s := &Server{}
+	s.conf.DBFilePath = dbFilename
+	s.conf.V6.Enabled = true
+	s.reset()
+	s.srv6.rangeStart = net.ParseIP("2001::1")
+	s.srv6.leaseTime = time.Hour
+	s.srv6.serverID = duidFromMAC(net.HardwareAddr{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa})
+	s.srv6.dnsIP = net.ParseIP("2001::ffff")
+	return s
+}
+
+// Get the request with IA_NA option
+func newRequest6(msgType byte, clientID, serverID []byte) *dhcp6Msg {
+	req := &dhcp6Msg{msgType: msgType, xid: [3]byte{1, 2, 3}}
+	req.addOption(dhcp6OptClientID, clientID)
+	if serverID != nil {
+		req.addOption(dhcp6OptServerID, serverID)
+	}
+	ia := dhcp6IANA{iaid: 7}
+	req.addOption(dhcp6OptIANA, ia.pack())
+	return req
+}
+
+// Get the address from IA_NA option of the response
+func respAddr6(t *testing.T, resp *dhcp6Msg) net.IP {
+	ia, err := parseDHCP6IANA(resp.option(dhcp6OptIANA))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(7), ia.iaid)
+	for _, o := range ia.options {
+		if o.code == dhcp6OptIAAddr {
+			return net.IP(o.data[:16])
+		}
+	}
+	return nil
+}
+
+func TestDHCP6Packet(t *testing.T) {
+	m := &dhcp6Msg{msgType: dhcp6Solicit, xid: [3]byte{1, 2, 3}}
+	m.addOption(dhcp6OptClientID, []byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6})
+	m.addOption(dhcp6OptRapidCommit, nil)
+
+	m2, err := parseDHCP6(m.pack())
+	assert.Nil(t, err)
+	assert.Equal(t, m.msgType, m2.msgType)
+	assert.Equal(t, m.xid, m2.xid)
+	assert.Equal(t, []byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6}, m2.option(dhcp6OptClientID))
+	assert.True(t, m2.hasOption(dhcp6OptRapidCommit))
+	assert.False(t, m2.hasOption(dhcp6OptServerID))
+
+	_, err = parseDHCP6([]byte{1, 2, 3, 4, 0, 1, 0, 5, 1})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "01:02:03:04:05:06", macFromDUID([]byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6}).String())
+	assert.Equal(t, "01:02:03:04:05:06", macFromDUID([]byte{0, 1, 0, 1, 9, 9, 9, 9, 1, 2, 3, 4, 5, 6}).String())
+	assert.Nil(t, macFromDUID([]byte{0, 2, 0, 0, 0, 9, 1, 2}))
+
+	assert.Equal(t, "host", parseDHCP6ClientFQDN([]byte{0, 4, 'H', 'o', 's', 't', 3, 'l', 'a', 'n', 0}))
+	assert.Equal(t, "", parseDHCP6ClientFQDN([]byte{0}))
+}
+
+func TestDHCP6Leases(t *testing.T) {
+	s := prepareServer6()
+	defer func() { _ = os.Remove(dbFilename) }()
+	clientID := []byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6}
+
+	// Solicit -> Advertise
+	resp := s.process6(newRequest6(dhcp6Solicit, clientID, nil))
+	assert.Equal(t, byte(dhcp6Advertise), resp.msgType)
+	assert.Equal(t, [3]byte{1, 2, 3}, resp.xid)
+	assert.Equal(t, clientID, resp.option(dhcp6OptClientID))
+	assert.Equal(t, s.srv6.serverID, resp.option(dhcp6OptServerID))
+	assert.Equal(t, "2001::ffff", net.IP(resp.option(dhcp6OptDNSServers)).String())
+	assert.Equal(t, "2001::1", respAddr6(t, resp).String())
+
+	// Request without our server ID is discarded
+	assert.Nil(t, s.process6(newRequest6(dhcp6Request, clientID, nil)))
+
+	// Request -> Reply
+	req := newRequest6(dhcp6Request, clientID, s.srv6.serverID)
+	req.addOption(dhcp6OptClientFQDN, []byte{0, 4, 'h', 'o', 's', 't', 0})
+	resp = s.process6(req)
+	assert.Equal(t, byte(dhcp6Reply), resp.msgType)
+	assert.Equal(t, "2001::1", respAddr6(t, resp).String())
+
+	ll := s.Leases(LeasesDynamic)
+	assert.Equal(t, 1, len(ll))
+	assert.Equal(t, "01:02:03:04:05:06", ll[0].HWAddr.String())
+	assert.Equal(t, "2001::1", ll[0].IP.String())
+	assert.Equal(t, "host", ll[0].Hostname)
+	assert.True(t, ll[0].Expiry.After(time.Now().Add(time.Minute*59)))
+	assert.Equal(t, "01:02:03:04:05:06", s.FindMACbyIP(net.ParseIP("2001::1")).String())
+
+	// another client with Rapid Commit
+	req = newRequest6(dhcp6Solicit, []byte{0, 2, 0, 0, 0, 9, 1, 2}, nil)
+	req.addOption(dhcp6OptRapidCommit, nil)
+	resp = s.process6(req)
+	assert.Equal(t, byte(dhcp6Reply), resp.msgType)
+	assert.True(t, resp.hasOption(dhcp6OptRapidCommit))
+	assert.Equal(t, "2001::2", respAddr6(t, resp).String())
+	assert.Equal(t, 2, len(s.Leases(LeasesDynamic)))
+
+	// the leases are stored in DB
+	s.reset()
+	s.dbLoad()
+	assert.Equal(t, 2, len(s.Leases(LeasesDynamic)))
+
+	// Release
+	resp = s.process6(newRequest6(dhcp6Release, clientID, s.srv6.serverID))
+	assert.Equal(t, byte(dhcp6Reply), resp.msgType)
+	assert.Equal(t, 1, len(s.Leases(LeasesDynamic)))
+
+	// Renew for unknown client
+	resp = s.process6(newRequest6(dhcp6Renew, clientID, s.srv6.serverID))
+	assert.Nil(t, respAddr6(t, resp))
+
+	// Information-Request
+	resp = s.process6(&dhcp6Msg{msgType: dhcp6InformationRequest})
+	assert.Equal(t, byte(dhcp6Reply), resp.msgType)
+	assert.Equal(t, "2001::ffff", net.IP(resp.option(dhcp6OptDNSServers)).String())
+}
+
+func TestDHCP6SLAACOnly(t *testing.T) {
+	s := prepareServer6()
+	s.conf.V6.RASLAACOnly = true
+
+	resp := s.process6(newRequest6(dhcp6Solicit, []byte{0, 3, 0, 1, 1, 2, 3, 4, 5, 6}, nil))
+	assert.False(t, resp.hasOption(dhcp6OptIANA))
+	assert.Equal(t, 0, len(s.Leases(LeasesDynamic)))
+}
+
+func TestBuildRA(t *testing.T) {
+	b := buildRA(raParams{
+		managed:   true,
+		other:     true,
+		prefix:    net.ParseIP("2001::"),
+		dnsIP:     net.ParseIP("2001::ffff"),
+		sourceMAC: net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		mtu:       1500,
+	})
+	assert.Equal(t, 16+32+24+8+8, len(b))
+	assert.Equal(t, byte(134), b[0])
+	assert.Equal(t, byte(0xc0), b[5])
+	assert.Equal(t, []byte{0, 0}, b[6:8]) // router lifetime
+
+	// prefix information
+	assert.Equal(t, []byte{3, 4, 64, 0x80}, b[16:20])
+	assert.Equal(t, "2001::", net.IP(b[32:48]).String())
+
+	// RDNSS
+	assert.Equal(t, []byte{25, 3}, b[48:50])
+	assert.Equal(t, "2001::ffff", net.IP(b[56:72]).String())
+
+	// source link-layer address
+	assert.Equal(t, []byte{1, 1, 1, 2, 3, 4, 5, 6}, b[72:80])
+}
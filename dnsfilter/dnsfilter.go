@@ -54,6 +54,10 @@ type Config struct {
 	// Per-client settings can override this configuration.
 	BlockedServices []string `yaml:"blocked_services"`
 
+	// Respond with the IP addresses of hosts-style rules (e.g. "10.1.1.1 printer.lan") instead of blocking the host
+	// The rules with null IP addresses (0.0.0.0, ::, 127.0.0.1, ::1) still block the host.
+	HostsRulesRespondIP bool `yaml:"hosts_rules_respond_ip"`
+
 	// IP-hostname pairs taken from system configuration (e.g. /etc/hosts) files
 	AutoHosts *util.AutoHosts `yaml:"-"`
 
@@ -66,10 +70,10 @@ type Config struct {
 
 // LookupStats store stats collected during safebrowsing or parental checks
 type LookupStats struct {
-	Requests   uint64 // number of HTTP requests that were sent
+	Requests   uint64 // number of requests that were sent
 	CacheHits  uint64 // number of lookups that didn't need HTTP requests
-	Pending    int64  // number of currently pending HTTP requests
-	PendingMax int64  // maximum number of pending HTTP requests
+	Pending    int64  // number of currently pending requests
+	PendingMax int64  // maximum number of pending requests
 }
 
 // Stats store LookupStats for safebrowsing, parental and safesearch
@@ -91,7 +95,17 @@ type Dnsfilter struct {
 	filteringEngine      *urlfilter.DNSEngine
 	rulesStorageWhite    *filterlist.RuleStorage
 	filteringEngineWhite *urlfilter.DNSEngine
-	engineLock           sync.RWMutex
+
+	// user rules (filter ID 0)
+	rulesStorageUser    *filterlist.RuleStorage
+	filteringEngineUser *urlfilter.DNSEngine
+
+	// rules with $dnsrewrite modifier
+	rulesStorageRewrite    *filterlist.RuleStorage
+	filteringEngineRewrite *urlfilter.DNSEngine
+	dnsRewriteRules        map[dnsRewriteKey]*dnsRewriteRule
+
+	engineLock sync.RWMutex
 
 	parentalServer       string // access via methods
 	safeBrowsingServer   string // access via methods
@@ -146,6 +160,10 @@ const (
 
 	// RewriteEtcHosts - rewrite by /etc/hosts rule
 	RewriteEtcHosts
+
+	// RewriteRule - rewrite by filtering rule with $dnsrewrite modifier
+	//  or by hosts-style rule (if HostsRulesRespondIP is enabled)
+	RewriteRule
 )
 
 var reasonNames = []string{
@@ -162,6 +180,7 @@ var reasonNames = []string{
 
 	"Rewrite",
 	"RewriteEtcHosts",
+	"RewriteRule",
 }
 
 func (r Reason) String() string {
@@ -171,6 +190,43 @@ func (r Reason) String() string {
 	return reasonNames[r]
 }
 
+// RulePriority - the priority of the rule which has determined the result of filtering
+// User rules win over allowlists, allowlists win over blocklists.
+// Inside user rules and inside blocklists $important rules win over exceptions.
+type RulePriority int
+
+// Note: "...Important" value must follow the value without $important modifier
+const (
+	// PriorityNone - the result wasn't determined by a filtering rule
+	PriorityNone RulePriority = iota
+	// PriorityBlocklist - a rule from a blocklist
+	PriorityBlocklist
+	// PriorityBlocklistImportant - a rule with $important modifier from a blocklist
+	PriorityBlocklistImportant
+	// PriorityAllowlist - a rule from an allowlist
+	PriorityAllowlist
+	// PriorityUserRules - a user rule
+	PriorityUserRules
+	// PriorityUserRulesImportant - a user rule with $important modifier
+	PriorityUserRulesImportant
+)
+
+var rulePriorityNames = []string{
+	"",
+	"Blocklist",
+	"BlocklistImportant",
+	"Allowlist",
+	"UserRules",
+	"UserRulesImportant",
+}
+
+func (p RulePriority) String() string {
+	if uint(p) >= uint(len(rulePriorityNames)) {
+		return ""
+	}
+	return rulePriorityNames[p]
+}
+
 // GetConfig - get configuration
 func (d *Dnsfilter) GetConfig() RequestFilteringSettings {
 	c := RequestFilteringSettings{}
@@ -191,6 +247,20 @@ func (d *Dnsfilter) WriteDiskConfig(c *Config) {
 	d.confLock.Unlock()
 }
 
+// SetConfig - apply the new settings (e.g. after the configuration file is reloaded)
+// The sizes of the caches aren't changed.
+func (d *Dnsfilter) SetConfig(c Config) {
+	d.confLock.Lock()
+	d.Config.ParentalEnabled = c.ParentalEnabled
+	d.Config.SafeSearchEnabled = c.SafeSearchEnabled
+	d.Config.SafeBrowsingEnabled = c.SafeBrowsingEnabled
+	d.Config.Rewrites = rewriteArrayDup(c.Rewrites)
+	d.prepareRewrites()
+	d.Config.BlockedServices = c.BlockedServices
+	d.Config.HostsRulesRespondIP = c.HostsRulesRespondIP
+	d.confLock.Unlock()
+}
+
 // SetFilters - set new filters (synchronously or asynchronously)
 // When filters are set asynchronously, the old filters continue working until the new filters are ready.
 //  In this case the caller must ensure that the old filter files are intact.
@@ -253,6 +323,12 @@ func (d *Dnsfilter) reset() {
 	if d.rulesStorageWhite != nil {
 		d.rulesStorageWhite.Close()
 	}
+	if d.rulesStorageUser != nil {
+		_ = d.rulesStorageUser.Close()
+	}
+	if d.rulesStorageRewrite != nil {
+		_ = d.rulesStorageRewrite.Close()
+	}
 }
 
 type dnsFilterContext struct {
@@ -272,17 +348,22 @@ type Result struct {
 	IP         net.IP `json:",omitempty"` // Not nil only in the case of a hosts file syntax
 	FilterID   int64  `json:",omitempty"` // Filter ID the rule belongs to
 
-	// for ReasonRewrite:
+	Priority RulePriority `json:",omitempty"` // Priority of the rule
+
+	// for ReasonRewrite, FilteredSafeSearch & RewriteRule:
 	CanonName string `json:",omitempty"` // CNAME value
 
 	// for RewriteEtcHosts:
 	ReverseHost string `json:",omitempty"`
 
-	// for ReasonRewrite & RewriteEtcHosts:
+	// for ReasonRewrite, RewriteEtcHosts & RewriteRule:
 	IPList []net.IP `json:",omitempty"` // list of IP addresses
 
 	// for FilteredBlockedService:
 	ServiceName string `json:",omitempty"` // Name of the blocked service
+
+	// for RewriteRule:
+	DNSRewrites []DNSRewrite `json:",omitempty"` // the response set by $dnsrewrite rules or hosts-style rules
 }
 
 // Matched can be used to see if any match at all was found, no matter filtered or not
@@ -290,13 +371,29 @@ func (r Reason) Matched() bool {
 	return r != NotFilteredNotFound
 }
 
-// CheckHostRules tries to match the host against filtering rules only
+// CheckHostRules tries to match the host against filtering rules and blocked services rules only
+// It's used to check the host names and IP addresses from DNS response (e.g. the targets of CNAME records).
 func (d *Dnsfilter) CheckHostRules(host string, qtype uint16, setts *RequestFilteringSettings) (Result, error) {
-	if !setts.FilteringEnabled {
-		return Result{}, nil
+	host = strings.ToLower(host)
+
+	if setts.FilteringEnabled {
+		result, err := d.matchHost(host, qtype, setts.ClientTags)
+		if err != nil {
+			return result, err
+		}
+		if result.Reason.Matched() {
+			return result, nil
+		}
 	}
 
-	return d.matchHost(host, qtype, setts.ClientTags)
+	if len(setts.ServicesRules) != 0 {
+		result := matchBlockedServicesRules(host, setts.ServicesRules)
+		if result.Reason.Matched() {
+			return result, nil
+		}
+	}
+
+	return Result{}, nil
 }
 
 // CheckHost tries to match the host against filtering rules,
@@ -316,24 +413,20 @@ func (d *Dnsfilter) CheckHost(host string, qtype uint16, setts *RequestFiltering
 		return result, nil
 	}
 
-	if d.Config.AutoHosts != nil {
-		ips := d.Config.AutoHosts.Process(host, qtype)
-		if ips != nil {
-			result.Reason = RewriteEtcHosts
-			result.IPList = ips
-			return result, nil
-		}
-
-		revHost := d.Config.AutoHosts.ProcessReverse(host, qtype)
-		if len(revHost) != 0 {
-			result.Reason = RewriteEtcHosts
-			result.ReverseHost = revHost + "."
-			return result, nil
-		}
+	result = d.processHostsFile(host, qtype)
+	if result.Reason == RewriteEtcHosts {
+		return result, nil
 	}
 
 	// try filter lists first
 	if setts.FilteringEnabled {
+		d.engineLock.RLock()
+		result = d.matchDNSRewrite(host, setts.ClientTags)
+		d.engineLock.RUnlock()
+		if result.Reason.Matched() {
+			return result, nil
+		}
+
 		result, err = d.matchHost(host, qtype, setts.ClientTags)
 		if err != nil {
 			return result, err
@@ -387,6 +480,34 @@ func (d *Dnsfilter) CheckHost(host string, qtype uint16, setts *RequestFiltering
 	return Result{}, nil
 }
 
+// CheckHostsFile looks up the host in the system hosts-file
+// Unlike CheckHost(), it doesn't apply any filters - it's used when filtering is disabled.
+func (d *Dnsfilter) CheckHostsFile(host string, qtype uint16) Result {
+	return d.processHostsFile(strings.ToLower(host), qtype)
+}
+
+// Find IP addresses for the host (or the host name for PTR request) in the system hosts-file
+func (d *Dnsfilter) processHostsFile(host string, qtype uint16) Result {
+	var result Result
+	if d.Config.AutoHosts == nil {
+		return result
+	}
+
+	ips := d.Config.AutoHosts.Process(host, qtype)
+	if ips != nil {
+		result.Reason = RewriteEtcHosts
+		result.IPList = ips
+		return result
+	}
+
+	revHost := d.Config.AutoHosts.ProcessReverse(host, qtype)
+	if len(revHost) != 0 {
+		result.Reason = RewriteEtcHosts
+		result.ReverseHost = revHost + "."
+	}
+	return result
+}
+
 // Process rewrites table
 // . Find CNAME for a domain name (exact match or by wildcard)
 //  . if found, set domain name to canonical name
@@ -464,37 +585,51 @@ func fileExists(fn string) bool {
 
 func createFilteringEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilter.DNSEngine, error) {
 	listArray := []filterlist.RuleList{}
+	regexRules := &regexRulesChecker{}
 	for _, f := range filters {
 		var list filterlist.RuleList
 
 		if f.ID == 0 {
 			list = &filterlist.StringRuleList{
 				ID:             0,
-				RulesText:      string(f.Data),
+				RulesText:      regexRules.filterText(string(f.Data)),
 				IgnoreCosmetic: true,
 			}
+			listArray = append(listArray, list)
+			continue
+		}
 
-		} else if !fileExists(f.FilePath) {
+		if !fileExists(f.FilePath) {
 			list = &filterlist.StringRuleList{
 				ID:             int(f.ID),
 				IgnoreCosmetic: true,
 			}
+			listArray = append(listArray, list)
+			continue
+		}
+
+		prevState := *regexRules
+		allRulesOK, err := regexRules.checkFile(f.FilePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("regex rules: %s: %s", f.FilePath, err)
+		}
 
-		} else if runtime.GOOS == "windows" {
+		if runtime.GOOS == "windows" || !allRulesOK {
 			// On Windows we don't pass a file to urlfilter because
 			//  it's difficult to update this file while it's being used.
+			// We also don't pass a file containing the regex rules which must not be used.
 			data, err := ioutil.ReadFile(f.FilePath)
 			if err != nil {
 				return nil, nil, fmt.Errorf("ioutil.ReadFile(): %s: %s", f.FilePath, err)
 			}
+			*regexRules = prevState
 			list = &filterlist.StringRuleList{
 				ID:             int(f.ID),
-				RulesText:      string(data),
+				RulesText:      regexRules.filterText(string(data)),
 				IgnoreCosmetic: true,
 			}
 
 		} else {
-			var err error
 			list, err = filterlist.NewFileRuleList(int(f.ID), f.FilePath, true)
 			if err != nil {
 				return nil, nil, fmt.Errorf("filterlist.NewFileRuleList(): %s: %s", f.FilePath, err)
@@ -503,6 +638,10 @@ func createFilteringEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilte
 		listArray = append(listArray, list)
 	}
 
+	if regexRules.dropped != 0 {
+		log.Info("Filtering: %d regex rules are invalid or too complex, they are not used", regexRules.dropped)
+	}
+
 	rulesStorage, err := filterlist.NewRuleStorage(listArray)
 	if err != nil {
 		return nil, nil, fmt.Errorf("filterlist.NewRuleStorage(): %s", err)
@@ -516,7 +655,22 @@ func (d *Dnsfilter) initFiltering(allowFilters, blockFilters []Filter) error {
 	d.engineLock.Lock()
 	defer d.engineLock.Unlock()
 	d.reset()
-	rulesStorage, filteringEngine, err := createFilteringEngine(blockFilters)
+
+	// user rules are checked separately because they have higher priority than allowlists
+	userFilters := []Filter{}
+	otherFilters := []Filter{}
+	for _, f := range blockFilters {
+		if f.ID == 0 {
+			userFilters = append(userFilters, f)
+		} else {
+			otherFilters = append(otherFilters, f)
+		}
+	}
+	rulesStorageUser, filteringEngineUser, err := createFilteringEngine(userFilters)
+	if err != nil {
+		return err
+	}
+	rulesStorage, filteringEngine, err := createFilteringEngine(otherFilters)
 	if err != nil {
 		return err
 	}
@@ -524,22 +678,37 @@ func (d *Dnsfilter) initFiltering(allowFilters, blockFilters []Filter) error {
 	if err != nil {
 		return err
 	}
+	rulesStorageRewrite, filteringEngineRewrite, dnsRewriteRules, err := createDNSRewriteEngine(blockFilters)
+	if err != nil {
+		return err
+	}
+	d.rulesStorageUser = rulesStorageUser
+	d.filteringEngineUser = filteringEngineUser
 	d.rulesStorage = rulesStorage
 	d.filteringEngine = filteringEngine
 	d.rulesStorageWhite = rulesStorageWhite
 	d.filteringEngineWhite = filteringEngineWhite
+	d.rulesStorageRewrite = rulesStorageRewrite
+	d.filteringEngineRewrite = filteringEngineRewrite
+	d.dnsRewriteRules = dnsRewriteRules
 	log.Debug("initialized filtering engine")
 
 	return nil
 }
 
 // matchHost is a low-level way to check only if hostname is filtered by rules, skipping expensive safebrowsing and parental lookups
+// User rules are checked first, then allowlists, then blocklists.
 func (d *Dnsfilter) matchHost(host string, qtype uint16, ctags []string) (Result, error) {
 	d.engineLock.RLock()
 	// Keep in mind that this lock must be held no just when calling Match()
 	//  but also while using the rules returned by it.
 	defer d.engineLock.RUnlock()
 
+	res, ok := d.matchEngine(d.filteringEngineUser, host, qtype, ctags, PriorityUserRules)
+	if ok {
+		return res, nil
+	}
+
 	if d.filteringEngineWhite != nil {
 		rr, ok := d.filteringEngineWhite.Match(host, ctags)
 		if ok {
@@ -555,17 +724,26 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, ctags []string) (Result
 			log.Debug("Filtering: found whitelist rule for host '%s': '%s'  list_id: %d",
 				host, rule.Text(), rule.GetFilterListID())
 			res := makeResult(rule, NotFilteredWhiteList)
+			res.Priority = PriorityAllowlist
 			return res, nil
 		}
 	}
 
-	if d.filteringEngine == nil {
-		return Result{}, nil
+	res, _ = d.matchEngine(d.filteringEngine, host, qtype, ctags, PriorityBlocklist)
+	return res, nil
+}
+
+// Match host against the rules of a blocking filtering engine
+// Inside the engine $important rules win over exceptions and exceptions win over the other rules.
+// Return FALSE if no rule has matched
+func (d *Dnsfilter) matchEngine(engine *urlfilter.DNSEngine, host string, qtype uint16, ctags []string, priority RulePriority) (Result, bool) {
+	if engine == nil {
+		return Result{}, false
 	}
 
-	rr, ok := d.filteringEngine.Match(host, ctags)
+	rr, ok := engine.Match(host, ctags)
 	if !ok {
-		return Result{}, nil
+		return Result{}, false
 	}
 
 	if rr.NetworkRule != nil {
@@ -576,7 +754,21 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, ctags []string) (Result
 			reason = NotFilteredWhiteList
 		}
 		res := makeResult(rr.NetworkRule, reason)
-		return res, nil
+		res.Priority = priority
+		if rr.NetworkRule.IsOptionEnabled(rules.OptionImportant) {
+			res.Priority++
+		}
+		return res, true
+	}
+
+	if d.Config.HostsRulesRespondIP {
+		res, ok := hostsRulesIPResult(rr)
+		if ok {
+			log.Debug("Filtering: found hosts rule with IP address for host '%s': '%s'  list_id: %d",
+				host, res.Rule, res.FilterID)
+			res.Priority = priority
+			return res, true
+		}
 	}
 
 	if qtype == dns.TypeA && rr.HostRulesV4 != nil {
@@ -584,8 +776,9 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, ctags []string) (Result
 		log.Debug("Filtering: found rule for host '%s': '%s'  list_id: %d",
 			host, rule.Text(), rule.GetFilterListID())
 		res := makeResult(rule, FilteredBlackList)
+		res.Priority = priority
 		res.IP = rule.IP.To4()
-		return res, nil
+		return res, true
 	}
 
 	if qtype == dns.TypeAAAA && rr.HostRulesV6 != nil {
@@ -593,8 +786,9 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, ctags []string) (Result
 		log.Debug("Filtering: found rule for host '%s': '%s'  list_id: %d",
 			host, rule.Text(), rule.GetFilterListID())
 		res := makeResult(rule, FilteredBlackList)
+		res.Priority = priority
 		res.IP = rule.IP
-		return res, nil
+		return res, true
 	}
 
 	if rr.HostRulesV4 != nil || rr.HostRulesV6 != nil {
@@ -609,11 +803,37 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, ctags []string) (Result
 		log.Debug("Filtering: found rule for host '%s': '%s'  list_id: %d",
 			host, rule.Text(), rule.GetFilterListID())
 		res := makeResult(rule, FilteredBlackList)
+		res.Priority = priority
 		res.IP = net.IP{}
-		return res, nil
+		return res, true
 	}
 
-	return Result{}, nil
+	return Result{}, false
+}
+
+// Get the response from the matched hosts-style rules with non-null IP addresses
+// Return FALSE if there are no such rules
+func hostsRulesIPResult(rr urlfilter.DNSResult) (Result, bool) {
+	hostRules := make([]*rules.HostRule, 0, len(rr.HostRulesV4)+len(rr.HostRulesV6))
+	hostRules = append(hostRules, rr.HostRulesV4...)
+	hostRules = append(hostRules, rr.HostRulesV6...)
+
+	res := Result{}
+	for _, rule := range hostRules {
+		if rule.IP.IsUnspecified() || rule.IP.IsLoopback() {
+			continue
+		}
+		if len(res.DNSRewrites) == 0 {
+			res = makeResult(rule, RewriteRule)
+		}
+		r := DNSRewrite{RCode: dns.RcodeSuccess, RRType: dns.TypeAAAA, Value: rule.IP.String()}
+		if rule.IP.To4() != nil {
+			r.RRType = dns.TypeA
+		}
+		res.DNSRewrites = append(res.DNSRewrites, r)
+		res.IPList = append(res.IPList, rule.IP)
+	}
+	return res, len(res.DNSRewrites) != 0
 }
 
 // Construct Result object
@@ -1,11 +1,15 @@
 package dnsfilter
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -178,6 +182,49 @@ func TestParallelSB(t *testing.T) {
 	})
 }
 
+// testSbUpstream responds to safe browsing or parental requests with the hash of a blocked host name
+type testSbUpstream struct {
+	host   string
+	suffix string // sbTXTSuffix or pcTXTSuffix
+}
+
+func (u *testSbUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	resp := &dns.Msg{}
+	resp.SetReply(m)
+	if strings.HasSuffix(m.Question[0].Name, u.suffix) {
+		sum := sha256.Sum256([]byte(u.host))
+		resp.Answer = append(resp.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{hex.EncodeToString(sum[:])},
+		})
+	}
+	return resp, nil
+}
+
+func (u *testSbUpstream) Address() string {
+	return "sb"
+}
+
+func TestSafeBrowsingLookup(t *testing.T) {
+	d := NewForTest(&Config{SafeBrowsingEnabled: true}, nil)
+	defer d.Close()
+	d.safeBrowsingUpstream = &testSbUpstream{host: "malware.example.com", suffix: sbTXTSuffix}
+	gctx.stats.Safebrowsing = LookupStats{}
+
+	d.checkMatch(t, "malware.example.com")
+	d.checkMatch(t, "sub.malware.example.com")
+	d.checkMatchEmpty(t, "example.com")
+	assert.Equal(t, uint64(3), gctx.stats.Safebrowsing.Requests)
+	assert.Equal(t, int64(0), gctx.stats.Safebrowsing.Pending)
+	assert.Equal(t, int64(1), gctx.stats.Safebrowsing.PendingMax)
+
+	// cached
+	d.checkMatch(t, "malware.example.com")
+	d.checkMatchEmpty(t, "example.com")
+	assert.Equal(t, uint64(3), gctx.stats.Safebrowsing.Requests)
+	assert.Equal(t, uint64(2), gctx.stats.Safebrowsing.CacheHits)
+}
+
 // SAFE SEARCH
 
 func TestSafeSearch(t *testing.T) {
@@ -226,7 +273,7 @@ func TestCheckHostSafeSearchGoogle(t *testing.T) {
 			t.Errorf("SafeSearch doesn't work for %s cause %s", host, err)
 		}
 
-		if result.IP == nil {
+		if !result.IsFiltered || result.CanonName != "forcesafesearch.google.com" {
 			t.Errorf("SafeSearch doesn't work for %s", host)
 		}
 	}
@@ -295,28 +342,14 @@ func TestSafeSearchCacheGoogle(t *testing.T) {
 		t.Fatalf("Failed to get safesearch domain for %s", domain)
 	}
 
-	ips, err := net.LookupIP(safeDomain)
-	if err != nil {
-		t.Fatalf("Failed to lookup for %s", safeDomain)
-	}
-
-	t.Logf("IP addresses: %v", ips)
-	ip := ips[0]
-	for _, i := range ips {
-		if i.To4() != nil {
-			ip = i
-			break
-		}
-	}
-
 	result, err = d.CheckHost(domain, dns.TypeA, &setts)
 	if err != nil {
 		t.Fatalf("CheckHost for safesearh domain %s failed cause %s", domain, err)
 	}
 
-	if result.IP.String() != ip.String() {
-		t.Fatalf("Wrong IP for %s safesearch: %s.  Should be: %s",
-			domain, result.IP.String(), ip)
+	if result.CanonName != safeDomain {
+		t.Fatalf("Wrong CNAME for %s safesearch: %s.  Should be: %s",
+			domain, result.CanonName, safeDomain)
 	}
 
 	// Check cache
@@ -326,13 +359,38 @@ func TestSafeSearchCacheGoogle(t *testing.T) {
 		t.Fatalf("Safesearch cache doesn't work for %s!", domain)
 	}
 
-	if cachedValue.IP.String() != ip.String() {
-		t.Fatalf("Wrong IP in cache for %s safesearch: %s", domain, cachedValue.IP.String())
+	if cachedValue.CanonName != safeDomain {
+		t.Fatalf("Wrong CNAME in cache for %s safesearch: %s", domain, cachedValue.CanonName)
 	}
 }
 
 // PARENTAL
 
+func TestParentalLookup(t *testing.T) {
+	d := NewForTest(&Config{ParentalEnabled: true}, []Filter{{ID: 0, Data: []byte("||ads.example.com^\n")}})
+	defer d.Close()
+	d.parentalUpstream = &testSbUpstream{host: "adult.example.com", suffix: pcTXTSuffix}
+	gctx.stats.Parental = LookupStats{}
+
+	r, err := d.CheckHost("www.adult.example.com", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, FilteredParental, r.Reason)
+	d.checkMatch(t, "ads.example.com")
+
+	// ad filtering is disabled, but parental control still works
+	setts.FilteringEnabled = false
+	d.checkMatchEmpty(t, "ads.example.com")
+	r, err = d.CheckHost("adult.example.com", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.Equal(t, FilteredParental, r.Reason)
+
+	// parental control is disabled for the client
+	setts.ParentalEnabled = false
+	d.checkMatchEmpty(t, "adult.example.com")
+	assert.Equal(t, uint64(3), gctx.stats.Parental.Requests)
+}
+
 func TestParentalControl(t *testing.T) {
 	d := NewForTest(&Config{ParentalEnabled: true}, nil)
 	defer d.Close()
@@ -429,13 +487,19 @@ func TestMatching(t *testing.T) {
 func TestWhitelist(t *testing.T) {
 	rules := `||host1^
 ||host2^
+||host4^$important
 `
+	// user rules win over allowlists, so use a filter list
+	fn := "./test-whitelist-rules.txt"
+	_ = ioutil.WriteFile(fn, []byte(rules), 0644)
+	defer func() { _ = os.Remove(fn) }()
 	filters := []Filter{Filter{
-		ID: 0, Data: []byte(rules),
+		ID: 1, FilePath: fn,
 	}}
 
 	whiteRules := `||host1^
 ||host3^
+0.0.0.0 host4
 `
 	whiteFilters := []Filter{Filter{
 		ID: 0, Data: []byte(whiteRules),
@@ -456,6 +520,171 @@ func TestWhitelist(t *testing.T) {
 	assert.True(t, ret.IsFiltered && ret.Reason == FilteredBlackList)
 	assert.True(t, ret.Rule == "||host2^")
 
+	// allowlist entries win even over $important rules,
+	//  hosts-style entries are treated as exceptions too
+	ret, err = d.CheckHost("host4", dns.TypeA, &setts)
+	assert.True(t, err == nil)
+	assert.True(t, !ret.IsFiltered && ret.Reason == NotFilteredWhiteList)
+}
+
+func TestRulePriority(t *testing.T) {
+	blockRules := `||block.example.org^
+||important.example.org^$important
+||allowed.example.org^$important
+||user-allowed.example.org^$important
+@@||exception.example.org^
+0.0.0.0 host.example.org
+`
+	fn := "./test-priority-rules.txt"
+	_ = ioutil.WriteFile(fn, []byte(blockRules), 0644)
+	defer func() { _ = os.Remove(fn) }()
+
+	userRules := `@@||user-allowed.example.org^
+||exception.example.org^
+||user-important.example.org^$important
+@@||user-important.example.org^
+`
+	allowRules := `||allowed.example.org^
+||user-important.example.org^
+0.0.0.0 host.example.org
+`
+	filters := []Filter{
+		{ID: 0, Data: []byte(userRules)},
+		{ID: 1, FilePath: fn},
+	}
+	d := NewForTest(nil, filters)
+	_ = d.SetFilters(filters, []Filter{{ID: 0, Data: []byte(allowRules)}}, false)
+	defer d.Close()
+
+	check := func(host string, reason Reason, priority RulePriority) {
+		t.Helper()
+		r, err := d.CheckHost(host, dns.TypeA, &setts)
+		assert.Nil(t, err)
+		assert.Equal(t, reason, r.Reason, host)
+		assert.Equal(t, priority, r.Priority, host)
+	}
+
+	check("block.example.org", FilteredBlackList, PriorityBlocklist)
+	check("important.example.org", FilteredBlackList, PriorityBlocklistImportant)
+	// allowlists win over blocklists, even over $important rules
+	check("allowed.example.org", NotFilteredWhiteList, PriorityAllowlist)
+	// hosts-style entries of allowlists are exceptions too
+	check("host.example.org", NotFilteredWhiteList, PriorityAllowlist)
+	// user rules win over blocklists
+	check("user-allowed.example.org", NotFilteredWhiteList, PriorityUserRules)
+	check("exception.example.org", FilteredBlackList, PriorityUserRules)
+	// user rules win over allowlists, $important rules win over exceptions
+	check("user-important.example.org", FilteredBlackList, PriorityUserRulesImportant)
+	check("other.example.org", NotFilteredNotFound, PriorityNone)
+}
+
+func TestHostsRulesRespondIP(t *testing.T) {
+	rules := `10.1.1.1 printer.lan
+fe80::1 printer.lan
+0.0.0.0 ads.example.org
+127.0.0.1 tracker.example.org
+`
+	filters := []Filter{{ID: 0, Data: []byte(rules)}}
+	d := NewForTest(nil, filters)
+	defer d.Close()
+
+	// disabled: the hosts are blocked
+	r, err := d.CheckHost("printer.lan", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, FilteredBlackList, r.Reason)
+	assert.True(t, r.IP.Equal(net.IP{10, 1, 1, 1}))
+
+	d.Config.HostsRulesRespondIP = true
+
+	r, err = d.CheckHost("printer.lan", dns.TypeAAAA, &setts)
+	assert.Nil(t, err)
+	assert.False(t, r.IsFiltered)
+	assert.Equal(t, RewriteRule, r.Reason)
+	assert.Equal(t, "10.1.1.1 printer.lan", r.Rule)
+	assert.Equal(t, PriorityUserRules, r.Priority)
+	assert.Equal(t, []DNSRewrite{
+		{RCode: dns.RcodeSuccess, RRType: dns.TypeA, Value: "10.1.1.1"},
+		{RCode: dns.RcodeSuccess, RRType: dns.TypeAAAA, Value: "fe80::1"},
+	}, r.DNSRewrites)
+	assert.Equal(t, 2, len(r.IPList))
+
+	// null IP addresses still block the host
+	d.checkMatch(t, "ads.example.org")
+	d.checkMatch(t, "tracker.example.org")
+}
+
+// REGEX RULES
+
+func TestRegexRules(t *testing.T) {
+	rules := `/^ads[0-9]+\.example\.org$/
+/^track[/
+/^(tracker){200}$/
+@@/^ads1\.example\.org$/
+`
+	fileRules := `! comment
+/^banner\.example\.(com|net)$/
+/^(banner){200}$/
+`
+	fn := "./test-regex-rules.txt"
+	_ = ioutil.WriteFile(fn, []byte(fileRules), 0644)
+	defer func() { _ = os.Remove(fn) }()
+
+	filters := []Filter{
+		{ID: 0, Data: []byte(rules)},
+		{ID: 1, FilePath: fn},
+	}
+	d := NewForTest(nil, filters)
+	defer d.Close()
+
+	d.checkMatch(t, "ads12.example.org")
+	d.checkMatchEmpty(t, "ads.example.org")
+	r, err := d.CheckHost("ads1.example.org", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.Equal(t, NotFilteredWhiteList, r.Reason)
+
+	d.checkMatch(t, "banner.example.net")
+	d.checkMatchEmpty(t, "banner.example.org")
+
+	// invalid and too complex rules are not used
+	c := regexRulesChecker{}
+	assert.Equal(t, "/^ads[0-9]+$/\n||example.org^", c.filterText("/^ads[0-9]+$/\n/^track[/\n/^(tracker){200}$/\n||example.org^"))
+	assert.Equal(t, 2, c.dropped)
+	ok, err := c.checkFile(fn)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 3, c.dropped)
+
+	assert.Equal(t, "^ads$", regexRulePattern("@@/^ads$/$important"))
+	assert.Equal(t, "", regexRulePattern("||ads.example.org^"))
+}
+
+// CLIENT TAGS
+
+func TestClientTags(t *testing.T) {
+	rules := "||tv.example.org^$ctag=device_tv|user_child\n" +
+		"||admin.example.org^$ctag=~user_admin\n"
+	d := NewForTest(nil, []Filter{{ID: 0, Data: []byte(rules)}})
+	defer d.Close()
+
+	// $ctag rules are never applied to the clients without tags
+	d.checkMatchEmpty(t, "tv.example.org")
+	d.checkMatchEmpty(t, "admin.example.org")
+
+	setts.ClientTags = []string{"device_tv"}
+	d.checkMatch(t, "tv.example.org")
+	d.checkMatch(t, "admin.example.org")
+
+	setts.ClientTags = []string{"device_pc", "user_admin"}
+	d.checkMatchEmpty(t, "tv.example.org")
+	d.checkMatchEmpty(t, "admin.example.org")
+
+	// the tags are used for the host names from DNS response too
+	setts.ClientTags = []string{"user_child"}
+	r, err := d.CheckHostRules("tv.example.org", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	setts.ClientTags = nil
 }
 
 // CLIENT SETTINGS
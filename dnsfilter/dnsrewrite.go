@@ -0,0 +1,306 @@
+package dnsfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/utils"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// Rules with $dnsrewrite modifier set the response for the matched host:
+//  ||example.org^$dnsrewrite=NOERROR;A;1.2.3.4
+//  ||example.org^$dnsrewrite=NOERROR;CNAME;example.net
+//  ||example.org^$dnsrewrite=NOERROR;TXT;some text
+//  ||example.org^$dnsrewrite=NXDOMAIN;;
+// Short forms:
+//  ||example.org^$dnsrewrite=1.2.3.4 (A), ...=1::2 (AAAA), ...=example.net (CNAME), ...=REFUSED (response code)
+// Exception rule "@@||example.org^$dnsrewrite" disables all $dnsrewrite rules for the host.
+//
+// urlfilter doesn't know this modifier and ignores these rules,
+//  so we build a separate filtering engine from the rules with this modifier removed.
+
+const dnsRewriteOption = "dnsrewrite"
+
+// DNSRewrite - the response set by $dnsrewrite rule
+type DNSRewrite struct {
+	RCode  int    // response code
+	RRType uint16 // type of the record;  0: no record
+	Value  string // IP address, host name or text
+}
+
+// A rule with $dnsrewrite modifier
+type dnsRewriteRule struct {
+	text     string // original rule text
+	rewrites []DNSRewrite
+}
+
+// Parse $dnsrewrite modifier value
+func parseDNSRewrite(s string) (DNSRewrite, error) {
+	r := DNSRewrite{}
+
+	if !strings.Contains(s, ";") {
+		// short form
+		if rcode, ok := dns.StringToRcode[strings.ToUpper(s)]; ok {
+			r.RCode = rcode
+			return r, nil
+		}
+		ip := net.ParseIP(s)
+		if ip != nil {
+			r.RRType = dns.TypeAAAA
+			if ip.To4() != nil {
+				r.RRType = dns.TypeA
+			}
+			r.Value = ip.String()
+			return r, nil
+		}
+		if utils.IsValidHostname(s) != nil {
+			return r, fmt.Errorf("invalid value: %s", s)
+		}
+		r.RRType = dns.TypeCNAME
+		r.Value = strings.ToLower(s)
+		return r, nil
+	}
+
+	parts := strings.SplitN(s, ";", 3)
+	if len(parts) != 3 {
+		return r, fmt.Errorf("invalid value: %s", s)
+	}
+	rcode, ok := dns.StringToRcode[strings.ToUpper(parts[0])]
+	if !ok {
+		return r, fmt.Errorf("invalid response code: %s", parts[0])
+	}
+	r.RCode = rcode
+	if rcode != dns.RcodeSuccess || (len(parts[1]) == 0 && len(parts[2]) == 0) {
+		if len(parts[1]) != 0 || len(parts[2]) != 0 {
+			return r, fmt.Errorf("records may be set only for NOERROR response: %s", s)
+		}
+		return r, nil
+	}
+
+	r.RRType = dns.StringToType[strings.ToUpper(parts[1])]
+	r.Value = parts[2]
+	switch r.RRType {
+	case dns.TypeA, dns.TypeAAAA:
+		ip := net.ParseIP(r.Value)
+		if ip == nil || (ip.To4() != nil) != (r.RRType == dns.TypeA) {
+			return r, fmt.Errorf("invalid IP address: %s", r.Value)
+		}
+		r.Value = ip.String()
+
+	case dns.TypeCNAME, dns.TypePTR:
+		if utils.IsValidHostname(r.Value) != nil {
+			return r, fmt.Errorf("invalid host name: %s", r.Value)
+		}
+		r.Value = strings.ToLower(r.Value)
+
+	case dns.TypeTXT:
+		//
+
+	default:
+		return r, fmt.Errorf("unsupported record type: %s", parts[1])
+	}
+	return r, nil
+}
+
+// Parse the rule with $dnsrewrite modifier
+// Return the rule text without this modifier (it's understood by urlfilter) and the rewrite
+// Return "" if the rule has no $dnsrewrite modifier
+func parseDNSRewriteRule(line string) (string, *DNSRewrite, error) {
+	if !strings.Contains(line, dnsRewriteOption) {
+		return "", nil, nil
+	}
+	i := strings.LastIndex(line, "$")
+	if i <= 0 {
+		return "", nil, nil
+	}
+	pattern := line[:i]
+	found := false
+	var rewrite *DNSRewrite
+	opts := []string{}
+	for _, o := range strings.Split(line[i+1:], ",") {
+		if o != dnsRewriteOption && !strings.HasPrefix(o, dnsRewriteOption+"=") {
+			opts = append(opts, o)
+			continue
+		}
+		found = true
+		if o == dnsRewriteOption || o == dnsRewriteOption+"=" {
+			continue
+		}
+		r, err := parseDNSRewrite(o[len(dnsRewriteOption)+1:])
+		if err != nil {
+			return "", nil, err
+		}
+		rewrite = &r
+	}
+	if !found {
+		return "", nil, nil
+	}
+
+	exception := strings.HasPrefix(line, "@@")
+	if exception != (rewrite == nil) {
+		return "", nil, fmt.Errorf("only exception rules may have empty $dnsrewrite value")
+	}
+
+	if len(opts) != 0 {
+		pattern += "$" + strings.Join(opts, ",")
+	}
+	return pattern, rewrite, nil
+}
+
+// Key of the rule with $dnsrewrite modifier: filter ID and rule text without this modifier
+type dnsRewriteKey struct {
+	filterID int
+	text     string
+}
+
+// dnsRewriteRules collects the rules with $dnsrewrite modifier
+type dnsRewriteRules struct {
+	filterID int
+	rules    map[dnsRewriteKey]*dnsRewriteRule
+	lines    []string // rule texts for urlfilter
+}
+
+func (rr *dnsRewriteRules) add(line string) {
+	line = strings.TrimSpace(line)
+	text, r, err := parseDNSRewriteRule(line)
+	if err != nil {
+		log.Debug("Filtering: invalid $dnsrewrite rule: %s: %s", line, err)
+		return
+	}
+	if len(text) == 0 {
+		return
+	}
+
+	key := dnsRewriteKey{filterID: rr.filterID, text: text}
+	rule, ok := rr.rules[key]
+	if !ok {
+		rule = &dnsRewriteRule{text: line}
+		rr.rules[key] = rule
+		rr.lines = append(rr.lines, text)
+	}
+	if r != nil {
+		rule.rewrites = append(rule.rewrites, *r)
+	}
+}
+
+func (rr *dnsRewriteRules) addFile(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if strings.Contains(line, dnsRewriteOption) {
+			rr.add(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create filtering engine from the rules with $dnsrewrite modifier
+// Return nil engine if there are no such rules
+func createDNSRewriteEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilter.DNSEngine, map[dnsRewriteKey]*dnsRewriteRule, error) {
+	listArray := []filterlist.RuleList{}
+	rr := dnsRewriteRules{rules: map[dnsRewriteKey]*dnsRewriteRule{}}
+	for _, f := range filters {
+		rr.filterID = int(f.ID)
+		rr.lines = nil
+		if f.ID == 0 {
+			for _, line := range strings.Split(string(f.Data), "\n") {
+				if strings.Contains(line, dnsRewriteOption) {
+					rr.add(line)
+				}
+			}
+		} else if fileExists(f.FilePath) {
+			err := rr.addFile(f.FilePath)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("$dnsrewrite rules: %s: %s", f.FilePath, err)
+			}
+		}
+		if len(rr.lines) == 0 {
+			continue
+		}
+
+		list := &filterlist.StringRuleList{
+			ID:             int(f.ID),
+			RulesText:      strings.Join(rr.lines, "\n"),
+			IgnoreCosmetic: true,
+		}
+		listArray = append(listArray, list)
+	}
+
+	if len(listArray) == 0 {
+		return nil, nil, nil, nil
+	}
+	log.Debug("Filtering: %d $dnsrewrite rules", len(rr.rules))
+
+	rulesStorage, err := filterlist.NewRuleStorage(listArray)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("filterlist.NewRuleStorage(): %s", err)
+	}
+	return rulesStorage, urlfilter.NewDNSEngine(rulesStorage), rr.rules, nil
+}
+
+// Match host against the rules with $dnsrewrite modifier
+// Call with engineLock held
+func (d *Dnsfilter) matchDNSRewrite(host string, ctags []string) Result {
+	if d.filteringEngineRewrite == nil {
+		return Result{}
+	}
+
+	mr, ok := d.filteringEngineRewrite.Match(host, ctags)
+	if !ok || mr.NetworkRule == nil || mr.NetworkRule.Whitelist {
+		return Result{}
+	}
+
+	key := dnsRewriteKey{filterID: mr.NetworkRule.GetFilterListID(), text: mr.NetworkRule.Text()}
+	rule, ok := d.dnsRewriteRules[key]
+	if !ok || len(rule.rewrites) == 0 {
+		return Result{}
+	}
+
+	log.Debug("Filtering: found $dnsrewrite rule for host '%s': '%s'  list_id: %d",
+		host, rule.text, key.filterID)
+	res := Result{
+		Reason:      RewriteRule,
+		Rule:        rule.text,
+		FilterID:    int64(key.filterID),
+		DNSRewrites: rule.rewrites,
+	}
+	res.Priority = PriorityBlocklist
+	if key.filterID == 0 {
+		res.Priority = PriorityUserRules
+	}
+	if mr.NetworkRule.IsOptionEnabled(rules.OptionImportant) {
+		res.Priority++
+	}
+	for _, r := range rule.rewrites {
+		switch r.RRType {
+		case dns.TypeCNAME:
+			if len(res.CanonName) == 0 {
+				res.CanonName = r.Value
+			}
+		case dns.TypeA, dns.TypeAAAA:
+			res.IPList = append(res.IPList, net.ParseIP(r.Value))
+		}
+	}
+	return res
+}
@@ -0,0 +1,102 @@
+package dnsfilter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDNSRewrite(t *testing.T) {
+	r, err := parseDNSRewrite("NOERROR;A;1.2.3.4")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RCode: dns.RcodeSuccess, RRType: dns.TypeA, Value: "1.2.3.4"}, r)
+
+	r, err = parseDNSRewrite("noerror;aaaa;::1")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RCode: dns.RcodeSuccess, RRType: dns.TypeAAAA, Value: "::1"}, r)
+
+	r, err = parseDNSRewrite("NXDOMAIN;;")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RCode: dns.RcodeNameError}, r)
+
+	r, err = parseDNSRewrite("NOERROR;TXT;hello world")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RCode: dns.RcodeSuccess, RRType: dns.TypeTXT, Value: "hello world"}, r)
+
+	// short forms
+	r, err = parseDNSRewrite("REFUSED")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RCode: dns.RcodeRefused}, r)
+
+	r, err = parseDNSRewrite("1.2.3.4")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RRType: dns.TypeA, Value: "1.2.3.4"}, r)
+
+	r, err = parseDNSRewrite("Host.Example.org")
+	assert.Nil(t, err)
+	assert.Equal(t, DNSRewrite{RRType: dns.TypeCNAME, Value: "host.example.org"}, r)
+
+	// invalid
+	_, err = parseDNSRewrite("NOERROR;A;::1")
+	assert.NotNil(t, err)
+	_, err = parseDNSRewrite("NXDOMAIN;A;1.2.3.4")
+	assert.NotNil(t, err)
+	_, err = parseDNSRewrite("NOERROR;MX;example.org")
+	assert.NotNil(t, err)
+	_, err = parseDNSRewrite("BADCODE;;")
+	assert.NotNil(t, err)
+}
+
+func TestDNSRewriteMatching(t *testing.T) {
+	rules := `||example.com^$dnsrewrite=1.2.3.4
+||example.com^$dnsrewrite=NOERROR;AAAA;::1
+||sub.example.org^$dnsrewrite=NOERROR;CNAME;example.net
+||tagged.example.org^$ctag=device_tv,dnsrewrite=REFUSED
+@@||allowed.example.org^$dnsrewrite
+||bad.example.org^$dnsrewrite=NOERROR;A;bad
+||blocked.example.org^
+`
+	d := NewForTest(nil, []Filter{{ID: 0, Data: []byte(rules)}})
+	defer d.Close()
+
+	r, err := d.CheckHost("www.example.com", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.Equal(t, RewriteRule, r.Reason)
+	assert.False(t, r.IsFiltered)
+	assert.Equal(t, "||example.com^$dnsrewrite=1.2.3.4", r.Rule)
+	assert.Equal(t, 2, len(r.DNSRewrites))
+	assert.Equal(t, 2, len(r.IPList))
+	assert.True(t, r.IPList[0].Equal(net.IP{1, 2, 3, 4}))
+
+	r, _ = d.CheckHost("sub.example.org", dns.TypeA, &setts)
+	assert.Equal(t, RewriteRule, r.Reason)
+	assert.Equal(t, "example.net", r.CanonName)
+
+	// exception
+	r, _ = d.CheckHost("allowed.example.org", dns.TypeA, &setts)
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+
+	// other modifiers are used
+	r, _ = d.CheckHost("tagged.example.org", dns.TypeA, &setts)
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+	setts.ClientTags = []string{"device_tv"}
+	r, _ = d.CheckHost("tagged.example.org", dns.TypeA, &setts)
+	setts.ClientTags = nil
+	assert.Equal(t, RewriteRule, r.Reason)
+	assert.Equal(t, dns.RcodeRefused, r.DNSRewrites[0].RCode)
+
+	// invalid rule is not used
+	r, _ = d.CheckHost("bad.example.org", dns.TypeA, &setts)
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+
+	// ordinary rules still work
+	d.checkMatch(t, "blocked.example.org")
+
+	// filtering is disabled
+	setts.FilteringEnabled = false
+	r, _ = d.CheckHost("www.example.com", dns.TypeA, &setts)
+	setts.FilteringEnabled = true
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+}
@@ -0,0 +1,122 @@
+package dnsfilter
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Regular expression rules: "/pattern/", "@@/pattern/$important".
+// urlfilter compiles the expression only once and keeps it in the rule object,
+//  but these rules have no shortcuts and so all of them are evaluated for every request.
+// The evaluation cost of RE2 expression is linear in the size of its program,
+//  so we limit the program size of a single rule and the total size of all regex rules in a filtering engine.
+// The rules exceeding the limits (and the invalid ones) are not used.
+
+const (
+	maxRegexRuleSize  = 1000   // max. number of instructions in the program of a single rule
+	maxRegexRulesSize = 100000 // max. number of instructions in the programs of all rules
+)
+
+// regexRulesChecker checks regular expression rules of one filtering engine
+type regexRulesChecker struct {
+	size    int // the total size of the programs of the accepted rules
+	dropped int // the number of rules which are not used
+}
+
+// Get regular expression from the rule text
+// Return "" if it's not a regular expression rule
+func regexRulePattern(line string) string {
+	line = strings.TrimPrefix(line, "@@")
+	if len(line) < 2 || line[0] != '/' {
+		return ""
+	}
+	if i := strings.LastIndex(line, "/$"); i > 0 {
+		line = line[:i+1]
+	}
+	if len(line) < 2 || line[len(line)-1] != '/' {
+		return ""
+	}
+	return line[1 : len(line)-1]
+}
+
+// Get the program size of the regular expression
+func regexProgSize(pattern string) (int, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0, err
+	}
+	return len(prog.Inst), nil
+}
+
+// Return FALSE if the rule must not be used
+func (c *regexRulesChecker) check(line string) bool {
+	pattern := regexRulePattern(strings.TrimSpace(line))
+	if len(pattern) == 0 {
+		return true
+	}
+
+	n, err := regexProgSize(pattern)
+	if err != nil {
+		log.Debug("Filtering: invalid regex rule: %s: %s", line, err)
+		c.dropped++
+		return false
+	}
+	if n > maxRegexRuleSize || c.size+n > maxRegexRulesSize {
+		log.Debug("Filtering: regex rule is too complex: %s", line)
+		c.dropped++
+		return false
+	}
+	c.size += n
+	return true
+}
+
+// Remove the rules which must not be used
+func (c *regexRulesChecker) filterText(text string) string {
+	if !strings.Contains(text, "/") {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	n := 0
+	for _, l := range lines {
+		if c.check(l) {
+			lines[n] = l
+			n++
+		}
+	}
+	return strings.Join(lines[:n], "\n")
+}
+
+// Check the rules from file
+// Return TRUE if all rules may be used
+func (c *regexRulesChecker) checkFile(fn string) (bool, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	dropped := c.dropped
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) != 0 {
+			c.check(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return c.dropped == dropped, nil
+}
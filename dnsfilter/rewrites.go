@@ -30,10 +30,10 @@ func isWildcard(host string) bool {
 		host[0] == '*' && host[1] == '.'
 }
 
-// Return TRUE of host name matches a wildcard pattern
+// Return TRUE of host name matches a wildcard pattern (case-insensitive)
 func matchDomainWildcard(host, wildcard string) bool {
 	return isWildcard(wildcard) &&
-		strings.HasSuffix(host, wildcard[1:])
+		strings.HasSuffix(strings.ToLower(host), strings.ToLower(wildcard[1:]))
 }
 
 type rewritesArray []RewriteEntry
@@ -43,16 +43,10 @@ func (a rewritesArray) Len() int { return len(a) }
 func (a rewritesArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
 // Priority:
-//  . CNAME > A/AAAA;
 //  . exact > wildcard;
+//  . CNAME > A/AAAA;
 //  . higher level wildcard > lower level wildcard
 func (a rewritesArray) Less(i, j int) bool {
-	if a[i].Type == dns.TypeCNAME && a[j].Type != dns.TypeCNAME {
-		return false
-	} else if a[i].Type != dns.TypeCNAME && a[j].Type == dns.TypeCNAME {
-		return true
-	}
-
 	if isWildcard(a[i].Domain) {
 		if !isWildcard(a[j].Domain) {
 			return false
@@ -63,10 +57,40 @@ func (a rewritesArray) Less(i, j int) bool {
 		}
 	}
 
+	if a[i].Type == dns.TypeCNAME && a[j].Type != dns.TypeCNAME {
+		return false
+	} else if a[i].Type != dns.TypeCNAME && a[j].Type == dns.TypeCNAME {
+		return true
+	}
+
 	// both are wildcards
 	return len(a[i].Domain) > len(a[j].Domain)
 }
 
+// Return TRUE if the domain name and the answer are valid
+// Domain name may be a wildcard: "*.example.org".
+// Answer is either an IP address or a canonical name.
+func (r *RewriteEntry) isValid() bool {
+	if len(r.Domain) == 0 || len(r.Answer) == 0 {
+		return false
+	}
+
+	host := r.Domain
+	if isWildcard(host) {
+		host = host[2:]
+	}
+	if _, ok := dns.IsDomainName(host); !ok || strings.Contains(host, "*") {
+		return false
+	}
+
+	if net.ParseIP(r.Answer) == nil {
+		if _, ok := dns.IsDomainName(r.Answer); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // Prepare entry for use
 func (r *RewriteEntry) prepare() {
 	ip := net.ParseIP(r.Answer)
@@ -92,17 +116,22 @@ func (d *Dnsfilter) prepareRewrites() {
 }
 
 // Get the list of matched rewrite entries.
-// Priority: CNAME, A/AAAA;  exact, wildcard.
+// Priority: exact, wildcard;  CNAME, A/AAAA.
 // If matched exactly, don't return wildcard entries.
-// If matched by several wildcards, select the more specific one
+// If matched by several wildcards, select the more specific one.
+// If a matched CNAME entry points to the host itself, return nothing.
 func findRewrites(a []RewriteEntry, host string) []RewriteEntry {
 	rr := rewritesArray{}
 	for _, r := range a {
-		if r.Domain != host {
+		if !strings.EqualFold(r.Domain, host) {
 			if !matchDomainWildcard(host, r.Domain) {
 				continue
 			}
 		}
+		if r.Type == dns.TypeCNAME && strings.EqualFold(r.Answer, host) {
+			// "host -> host" is an exception: the host is resolved normally
+			return nil
+		}
 		rr = append(rr, r)
 	}
 
@@ -173,8 +202,20 @@ func (d *Dnsfilter) handleRewriteAdd(w http.ResponseWriter, r *http.Request) {
 		Domain: jsent.Domain,
 		Answer: jsent.Answer,
 	}
+	if !ent.isValid() {
+		httpError(r, w, http.StatusBadRequest, "invalid rewrite entry: %s -> %s", ent.Domain, ent.Answer)
+		return
+	}
 	ent.prepare()
+
 	d.confLock.Lock()
+	for _, e := range d.Config.Rewrites {
+		if e.equals(ent) {
+			d.confLock.Unlock()
+			httpError(r, w, http.StatusBadRequest, "rewrite entry already exists: %s -> %s", ent.Domain, ent.Answer)
+			return
+		}
+	}
 	d.Config.Rewrites = append(d.Config.Rewrites, ent)
 	d.confLock.Unlock()
 	log.Debug("Rewrites: added element: %s -> %s [%d]",
@@ -125,3 +125,51 @@ func TestRewritesLevels(t *testing.T) {
 	assert.Equal(t, 1, len(r.IPList))
 	assert.Equal(t, "3.3.3.3", r.IPList[0].String())
 }
+
+func TestRewritesExceptions(t *testing.T) {
+	d := Dnsfilter{}
+	d.Rewrites = []RewriteEntry{
+		RewriteEntry{"*.lab.home", "10.0.0.5", 0, nil},
+		RewriteEntry{"*.cname.home", "proxy.cname.home", 0, nil},
+		RewriteEntry{"proxy.cname.home", "proxy.cname.home", 0, nil},
+		RewriteEntry{"public.lab.home", "public.lab.home", 0, nil},
+		RewriteEntry{"www.lab.home", "host.com", 0, nil},
+	}
+	d.prepareRewrites()
+
+	// case-insensitive match
+	r := d.processRewrites("NAS.Lab.Home")
+	assert.Equal(t, ReasonRewrite, r.Reason)
+	assert.Equal(t, 1, len(r.IPList))
+	assert.Equal(t, "10.0.0.5", r.IPList[0].String())
+
+	// exact CNAME overrides a wildcard
+	r = d.processRewrites("www.lab.home")
+	assert.Equal(t, ReasonRewrite, r.Reason)
+	assert.Equal(t, "host.com", r.CanonName)
+	assert.Equal(t, 0, len(r.IPList))
+
+	// exception for a wildcard
+	r = d.processRewrites("public.lab.home")
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+
+	// CNAME target is resolved by upstream servers
+	r = d.processRewrites("www.cname.home")
+	assert.Equal(t, ReasonRewrite, r.Reason)
+	assert.Equal(t, "proxy.cname.home", r.CanonName)
+	assert.Equal(t, 0, len(r.IPList))
+
+	r = d.processRewrites("proxy.cname.home")
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+}
+
+func TestRewriteEntryValid(t *testing.T) {
+	assert.True(t, (&RewriteEntry{Domain: "host.com", Answer: "1.1.1.1"}).isValid())
+	assert.True(t, (&RewriteEntry{Domain: "*.host.com", Answer: "::1"}).isValid())
+	assert.True(t, (&RewriteEntry{Domain: "www.host.com", Answer: "host.com"}).isValid())
+
+	assert.False(t, (&RewriteEntry{Domain: "", Answer: "1.1.1.1"}).isValid())
+	assert.False(t, (&RewriteEntry{Domain: "host.com", Answer: ""}).isValid())
+	assert.False(t, (&RewriteEntry{Domain: "a.*.host.com", Answer: "1.1.1.1"}).isValid())
+	assert.False(t, (&RewriteEntry{Domain: "host..com", Answer: "1.1.1.1"}).isValid())
+}
@@ -13,6 +13,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -114,7 +115,7 @@ func (d *Dnsfilter) checkSafeSearch(host string) (Result, error) {
 	// Check cache. Return cached result if it was found
 	cachedValue, isFound := getCachedResult(gctx.safeSearchCache, host)
 	if isFound {
-		// atomic.AddUint64(&gctx.stats.Safesearch.CacheHits, 1)
+		atomic.AddUint64(&gctx.stats.Safesearch.CacheHits, 1)
 		log.Tracef("SafeSearch: found in cache: %s", host)
 		return cachedValue, nil
 	}
@@ -127,30 +128,11 @@ func (d *Dnsfilter) checkSafeSearch(host string) (Result, error) {
 	res := Result{IsFiltered: true, Reason: FilteredSafeSearch}
 	if ip := net.ParseIP(safeHost); ip != nil {
 		res.IP = ip
-		valLen := d.setCacheResult(gctx.safeSearchCache, host, res)
-		log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, valLen)
-		return res, nil
+	} else {
+		// the safe host is resolved by dnsforward using the configured upstream servers
+		res.CanonName = safeHost
 	}
 
-	// TODO this address should be resolved with upstream that was configured in dnsforward
-	addrs, err := net.LookupIP(safeHost)
-	if err != nil {
-		log.Tracef("SafeSearchDomain for %s was found but failed to lookup for %s cause %s", host, safeHost, err)
-		return Result{}, err
-	}
-
-	for _, i := range addrs {
-		if ipv4 := i.To4(); ipv4 != nil {
-			res.IP = ipv4
-			break
-		}
-	}
-
-	if len(res.IP) == 0 {
-		return Result{}, fmt.Errorf("no ipv4 addresses in safe search response for %s", safeHost)
-	}
-
-	// Cache result
 	valLen := d.setCacheResult(gctx.safeSearchCache, host, res)
 	log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, valLen)
 	return res, nil
@@ -189,6 +171,23 @@ func hostnameToHashParam(host string) (string, map[string]bool) {
 	return hashparam.String(), hashes
 }
 
+// Count a request to the service
+func (s *LookupStats) requestStarted() {
+	atomic.AddUint64(&s.Requests, 1)
+	n := atomic.AddInt64(&s.Pending, 1)
+	for {
+		max := atomic.LoadInt64(&s.PendingMax)
+		if n <= max || atomic.CompareAndSwapInt64(&s.PendingMax, max, n) {
+			break
+		}
+	}
+}
+
+// Count the completion of a request to the service
+func (s *LookupStats) requestFinished() {
+	atomic.AddInt64(&s.Pending, -1)
+}
+
 // Find the target hash in TXT response
 func (d *Dnsfilter) processTXT(svc, host string, resp *dns.Msg, hashes map[string]bool) bool {
 	for _, a := range resp.Answer {
@@ -219,7 +218,7 @@ func (d *Dnsfilter) checkSafeBrowsing(host string) (Result, error) {
 	// check cache
 	cachedValue, isFound := getCachedResult(gctx.safebrowsingCache, host)
 	if isFound {
-		// atomic.AddUint64(&gctx.stats.Safebrowsing.CacheHits, 1)
+		atomic.AddUint64(&gctx.stats.Safebrowsing.CacheHits, 1)
 		log.Tracef("SafeBrowsing: found in cache: %s", host)
 		return cachedValue, nil
 	}
@@ -232,7 +231,9 @@ func (d *Dnsfilter) checkSafeBrowsing(host string) (Result, error) {
 
 	req := dns.Msg{}
 	req.SetQuestion(question, dns.TypeTXT)
+	gctx.stats.Safebrowsing.requestStarted()
 	resp, err := d.safeBrowsingUpstream.Exchange(&req)
+	gctx.stats.Safebrowsing.requestFinished()
 	if err != nil {
 		return result, err
 	}
@@ -259,7 +260,7 @@ func (d *Dnsfilter) checkParental(host string) (Result, error) {
 	// check cache
 	cachedValue, isFound := getCachedResult(gctx.parentalCache, host)
 	if isFound {
-		// atomic.AddUint64(&gctx.stats.Parental.CacheHits, 1)
+		atomic.AddUint64(&gctx.stats.Parental.CacheHits, 1)
 		log.Tracef("Parental: found in cache: %s", host)
 		return cachedValue, nil
 	}
@@ -272,7 +273,9 @@ func (d *Dnsfilter) checkParental(host string) (Result, error) {
 
 	req := dns.Msg{}
 	req.SetQuestion(question, dns.TypeTXT)
+	gctx.stats.Parental.requestStarted()
 	resp, err := d.parentalUpstream.Exchange(&req)
+	gctx.stats.Parental.requestFinished()
 	if err != nil {
 		return result, err
 	}
@@ -22,16 +22,19 @@ type accessCtx struct {
 	allowedClientsIPNet    []net.IPNet // CIDRs of whitelist clients
 	disallowedClientsIPNet []net.IPNet // CIDRs of clients that should be blocked
 
+	allowedClientIDs    map[string]bool // ClientIDs of whitelist clients
+	disallowedClientIDs map[string]bool // ClientIDs of clients that should be blocked
+
 	blockedHostsEngine *urlfilter.DNSEngine // finds hosts that should be blocked
 }
 
 func (a *accessCtx) Init(allowedClients, disallowedClients, blockedHosts []string) error {
-	err := processIPCIDRArray(&a.allowedClients, &a.allowedClientsIPNet, allowedClients)
+	err := processIPCIDRArray(&a.allowedClients, &a.allowedClientsIPNet, &a.allowedClientIDs, allowedClients)
 	if err != nil {
 		return err
 	}
 
-	err = processIPCIDRArray(&a.disallowedClients, &a.disallowedClientsIPNet, disallowedClients)
+	err = processIPCIDRArray(&a.disallowedClients, &a.disallowedClientsIPNet, &a.disallowedClientIDs, disallowedClients)
 	if err != nil {
 		return err
 	}
@@ -58,9 +61,10 @@ func (a *accessCtx) Init(allowedClients, disallowedClients, blockedHosts []strin
 	return nil
 }
 
-// Split array of IP or CIDR into 2 containers for fast search
-func processIPCIDRArray(dst *map[string]bool, dstIPNet *[]net.IPNet, src []string) error {
+// Split array of IP, CIDR or ClientID into 3 containers for fast search
+func processIPCIDRArray(dst *map[string]bool, dstIPNet *[]net.IPNet, dstIDs *map[string]bool, src []string) error {
 	*dst = make(map[string]bool)
+	*dstIDs = make(map[string]bool)
 
 	for _, s := range src {
 		ip := net.ParseIP(s)
@@ -69,6 +73,11 @@ func processIPCIDRArray(dst *map[string]bool, dstIPNet *[]net.IPNet, src []strin
 			continue
 		}
 
+		if IsValidClientID(s) {
+			(*dstIDs)[strings.ToLower(s)] = true
+			continue
+		}
+
 		_, ipnet, err := net.ParseCIDR(s)
 		if err != nil {
 			return err
@@ -81,15 +90,25 @@ func processIPCIDRArray(dst *map[string]bool, dstIPNet *[]net.IPNet, src []strin
 
 // IsBlockedIP - return TRUE if this client should be blocked
 func (a *accessCtx) IsBlockedIP(ip string) bool {
+	return a.IsBlockedClient(ip, "")
+}
+
+// IsBlockedClient - return TRUE if this client (specified by IP address or ClientID) should be blocked
+// clientID: ClientID sent by the client via DNS-over-TLS or DNS-over-HTTPS (may be empty)
+func (a *accessCtx) IsBlockedClient(ip string, clientID string) bool {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
-	if len(a.allowedClients) != 0 || len(a.allowedClientsIPNet) != 0 {
+	if len(a.allowedClients) != 0 || len(a.allowedClientsIPNet) != 0 || len(a.allowedClientIDs) != 0 {
 		_, ok := a.allowedClients[ip]
 		if ok {
 			return false
 		}
 
+		if len(clientID) != 0 && a.allowedClientIDs[clientID] {
+			return false
+		}
+
 		if len(a.allowedClientsIPNet) != 0 {
 			ipAddr := net.ParseIP(ip)
 			for _, ipnet := range a.allowedClientsIPNet {
@@ -107,6 +126,10 @@ func (a *accessCtx) IsBlockedIP(ip string) bool {
 		return true
 	}
 
+	if len(clientID) != 0 && a.disallowedClientIDs[clientID] {
+		return true
+	}
+
 	if len(a.disallowedClientsIPNet) != 0 {
 		ipAddr := net.ParseIP(ip)
 		for _, ipnet := range a.disallowedClientsIPNet {
@@ -150,16 +173,18 @@ func (s *Server) handleAccessList(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func checkIPCIDRArray(src []string) error {
+// ValidateAccessClients - check the list of clients for allowed_clients or disallowed_clients:
+//  each entry must be an IP address, CIDR or ClientID
+func ValidateAccessClients(src []string) error {
 	for _, s := range src {
 		ip := net.ParseIP(s)
-		if ip != nil {
+		if ip != nil || IsValidClientID(s) {
 			continue
 		}
 
 		_, _, err := net.ParseCIDR(s)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid IP address, CIDR or ClientID: %s", s)
 		}
 	}
 
@@ -174,9 +199,9 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = checkIPCIDRArray(j.AllowedClients)
+	err = ValidateAccessClients(j.AllowedClients)
 	if err == nil {
-		err = checkIPCIDRArray(j.DisallowedClients)
+		err = ValidateAccessClients(j.DisallowedClients)
 	}
 	if err != nil {
 		httpError(r, w, http.StatusBadRequest, "%s", err)
@@ -0,0 +1,317 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultCacheSize = 64 * 1024 // in bytes
+	staleTTL         = 30        // TTL of the records in a stale response (RFC 8767)
+	cacheMinTTLLimit = 60 * 60   // max. value of the minimum TTL (the same as dnsproxy uses)
+
+	// default network masks for EDNS Client Subnet option (the same as dnsproxy uses)
+	ecsDefaultMaskV4 = 24
+	ecsDefaultMaskV6 = 56
+)
+
+// dnsCache stores the responses from upstream servers
+// Expired responses are kept until they are pushed out by the newer ones,
+// they are used when upstream servers are unreachable.
+type dnsCache struct {
+	items    cache.Cache
+	maxStale uint32 // max. time (in seconds) since expiration when a response may be used
+	minTTL   uint32 // override TTL of the stored responses (minimum)
+	maxTTL   uint32 // override TTL of the stored responses (maximum);  0: no limit
+}
+
+func newDNSCache(size uint32, maxStale uint32, minTTL uint32, maxTTL uint32) *dnsCache {
+	conf := cache.Config{
+		MaxSize:   defaultCacheSize,
+		EnableLRU: true,
+	}
+	if size != 0 {
+		conf.MaxSize = uint(size)
+	}
+	return &dnsCache{
+		items:    cache.New(conf),
+		maxStale: maxStale,
+		minTTL:   minTTL,
+		maxTTL:   maxTTL,
+	}
+}
+
+// Get cache key
+// Format:
+// uint8(do)
+// uint16(qtype)
+// uint16(qclass)
+// uint8(ECS mask)
+// ECS IP
+// name
+func cacheKey(req *dns.Msg, subnet *net.IPNet) []byte {
+	q := req.Question[0]
+	var ip net.IP
+	mask := 0
+	if subnet != nil {
+		ip = subnet.IP
+		mask, _ = subnet.Mask.Size()
+	}
+	b := make([]byte, 1+2+2+1+len(ip)+len(q.Name))
+
+	opt := req.IsEdns0()
+	if opt != nil && opt.Do() {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint16(b[1:], q.Qtype)
+	binary.BigEndian.PutUint16(b[3:], q.Qclass)
+	b[5] = byte(mask)
+	copy(b[6:], ip)
+	copy(b[6+len(ip):], strings.ToLower(q.Name))
+	return b
+}
+
+// Get the lowest TTL of the records in the message
+func findLowestTTL(m *dns.Msg) uint32 {
+	var ttl uint32 = math.MaxUint32
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT && rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+	if ttl == math.MaxUint32 {
+		return 0
+	}
+	return ttl
+}
+
+// Apply the TTL limits of the cache
+func (c *dnsCache) limitTTL(ttl uint32) uint32 {
+	minTTL := c.minTTL
+	if minTTL > cacheMinTTLLimit {
+		minTTL = cacheMinTTLLimit
+	}
+	if ttl < minTTL {
+		return minTTL
+	}
+	if c.maxTTL != 0 && ttl > c.maxTTL {
+		return c.maxTTL
+	}
+	return ttl
+}
+
+// Return TRUE if the response may be stored in cache
+func isCacheable(m *dns.Msg) bool {
+	if m.Truncated || len(m.Question) != 1 {
+		return false
+	}
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return false
+	}
+	if findLowestTTL(m) == 0 {
+		return false
+	}
+
+	qtype := m.Question[0].Qtype
+	if m.Rcode == dns.RcodeSuccess && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		// a NOERROR response must contain at least one A or AAAA record
+		for _, rr := range m.Answer {
+			t := rr.Header().Rrtype
+			if t == dns.TypeA || t == dns.TypeAAAA {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Store the response
+// Format:
+// uint32(expire)
+// uint32(TTL)
+// DNS message
+func (c *dnsCache) set(key []byte, m *dns.Msg) {
+	if m == nil || !isCacheable(m) {
+		return
+	}
+
+	pm, err := m.Pack()
+	if err != nil {
+		return
+	}
+	data := make([]byte, 4+4+len(pm))
+	ttl := c.limitTTL(findLowestTTL(m))
+	binary.BigEndian.PutUint32(data, uint32(time.Now().Unix())+ttl)
+	binary.BigEndian.PutUint32(data[4:], ttl)
+	copy(data[8:], pm)
+	_ = c.items.Set(key, data)
+}
+
+// Return TRUE if the response is still valid, but less than 10% of its TTL is left
+func (c *dnsCache) expiresSoon(key []byte) bool {
+	data := c.items.Get(key)
+	if data == nil {
+		return false
+	}
+
+	now := uint32(time.Now().Unix())
+	expire := binary.BigEndian.Uint32(data)
+	ttl := binary.BigEndian.Uint32(data[4:])
+	return expire > now && (expire-now)*10 <= ttl
+}
+
+// Get the response
+// stale: allow to return an expired response
+// Return nil if there's no suitable response
+func (c *dnsCache) get(key []byte, req *dns.Msg, stale bool) *dns.Msg {
+	data := c.items.Get(key)
+	if data == nil {
+		return nil
+	}
+
+	now := uint32(time.Now().Unix())
+	expire := binary.BigEndian.Uint32(data)
+	var ttl uint32
+	if expire > now {
+		ttl = expire - now
+	} else {
+		if !stale || now-expire > c.maxStale {
+			return nil
+		}
+		ttl = staleTTL
+	}
+
+	m := dns.Msg{}
+	err := m.Unpack(data[8:])
+	if err != nil {
+		c.items.Del(key)
+		return nil
+	}
+
+	reqOpt := req.IsEdns0()
+	res := &dns.Msg{}
+	res.SetReply(req)
+	res.AuthenticatedData = m.AuthenticatedData
+	res.RecursionAvailable = m.RecursionAvailable
+	res.Rcode = m.Rcode
+
+	copyRecords := func(rrs []dns.RR) []dns.RR {
+		out := []dns.RR{}
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				// OPT record is hop-by-hop
+				if reqOpt != nil {
+					opt := rr.(*dns.OPT)
+					res.SetEdns0(opt.UDPSize(), reqOpt.Do())
+				}
+				continue
+			}
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = ttl
+			out = append(out, rr)
+		}
+		return out
+	}
+	res.Answer = copyRecords(m.Answer)
+	res.Ns = copyRecords(m.Ns)
+	extra := copyRecords(m.Extra)
+	res.Extra = append(extra, res.Extra...)
+	return res
+}
+
+// Get the client's subnet which is sent to upstream servers in EDNS Client Subnet option
+// Return nil if ECS is disabled or stripped
+func (s *Server) ecsSubnet(d *proxy.DNSContext) *net.IPNet {
+	if !s.ecsEnabled() {
+		return nil
+	}
+
+	opt := d.Req.IsEdns0()
+	if opt != nil {
+		for _, o := range opt.Option {
+			sn, ok := o.(*dns.EDNS0_SUBNET)
+			if ok {
+				bits := 32
+				if sn.Family == 2 {
+					bits = 128
+				}
+				mask := net.CIDRMask(int(sn.SourceNetmask), bits)
+				return &net.IPNet{IP: sn.Address.Mask(mask), Mask: mask}
+			}
+		}
+	}
+
+	ip := s.dnsProxy.EDNSAddr
+	if ip == nil {
+		ip = getIP(d.Addr)
+	}
+	if ip == nil {
+		return nil
+	}
+	if ip.To4() != nil {
+		mask := net.CIDRMask(ecsDefaultMaskV4, 32)
+		return &net.IPNet{IP: ip.To4().Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(ecsDefaultMaskV6, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// Get the response from cache or from upstream servers
+// If upstream servers fail, an expired response may be used (serve-stale).
+func (s *Server) resolve(d *proxy.DNSContext) error {
+	if len(d.Upstreams) != 0 {
+		// don't mix the responses from the client-specific upstream servers with the others
+		return s.dnsProxy.Resolve(d)
+	}
+
+	key := cacheKey(d.Req, s.ecsSubnet(d))
+	resp := s.cache.get(key, d.Req, false)
+	s.metrics.addCacheLookup(resp != nil)
+	if resp != nil {
+		log.Debug("DNS: serving cached response for %s", d.Req.Question[0].Name)
+		d.Res = resp
+		if s.prefetch != nil {
+			s.prefetch.hit(key, d)
+		}
+		return nil
+	}
+
+	err := s.exchangeUpstream(d)
+	if err == nil && d.Res.Rcode != dns.RcodeServerFailure {
+		s.cache.set(key, d.Res)
+		return nil
+	}
+
+	resp = s.cache.get(key, d.Req, true)
+	if resp == nil {
+		return err
+	}
+	log.Debug("DNS: upstream servers failed (%v), serving stale response for %s", err, d.Req.Question[0].Name)
+	d.Res = resp
+	return nil
+}
+
+// Send the request to upstream servers
+// d.Upstreams may be set for this request only:  it's reset so that it isn't used by the other modules
+func (s *Server) exchangeUpstream(d *proxy.DNSContext) error {
+	defer func() { d.Upstreams = nil }()
+
+	if s.useFastestUpstream() {
+		return s.resolveWithFastestUpstream(d)
+	}
+	if s.conf.UpstreamCheckInterval != 0 {
+		d.Upstreams = s.getAliveUpstreamsForDomain(d.Req.Question[0].Name)
+	}
+	return s.dnsProxy.Resolve(d)
+}
@@ -0,0 +1,263 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAResponse(req *dns.Msg, ip net.IP, ttl uint32) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   ip,
+	})
+	return resp
+}
+
+// Make the cached response expired 'ago' seconds ago
+func expireCached(c *dnsCache, key []byte, ago uint32) {
+	data := c.items.Get(key)
+	binary.BigEndian.PutUint32(data, uint32(time.Now().Unix())-ago)
+	c.items.Set(key, data)
+}
+
+func TestDNSCache(t *testing.T) {
+	c := newDNSCache(0, 60, 0, 0)
+	req := &dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+	key := cacheKey(req, nil)
+	assert.Nil(t, c.get(key, req, false))
+
+	c.set(key, newTestAResponse(req, net.IP{1, 2, 3, 4}, 100))
+	req.Id = 1234
+	resp := c.get(key, req, false)
+	assert.NotNil(t, resp)
+	assert.Equal(t, uint16(1234), resp.Id)
+	assert.Equal(t, "1.2.3.4", resp.Answer[0].(*dns.A).A.String())
+	assert.True(t, resp.Answer[0].Header().Ttl <= 100)
+
+	// different subnets and DO flag
+	_, subnet, _ := net.ParseCIDR("1.2.3.0/24")
+	assert.Nil(t, c.get(cacheKey(req, subnet), req, false))
+	reqDO := req.Copy()
+	reqDO.SetEdns0(4096, true)
+	assert.Nil(t, c.get(cacheKey(reqDO, nil), reqDO, false))
+
+	// expired, but may be used if upstream servers fail
+	expireCached(c, key, 10)
+	assert.Nil(t, c.get(key, req, false))
+	resp = c.get(key, req, true)
+	assert.NotNil(t, resp)
+	assert.Equal(t, uint32(staleTTL), resp.Answer[0].Header().Ttl)
+
+	// too old
+	expireCached(c, key, 61)
+	assert.Nil(t, c.get(key, req, true))
+
+	// serve-stale is disabled
+	c = newDNSCache(0, 0, 0, 0)
+	c.set(key, newTestAResponse(req, net.IP{1, 2, 3, 4}, 100))
+	expireCached(c, key, 1)
+	assert.Nil(t, c.get(key, req, true))
+
+	// not cacheable
+	resp = &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeServerFailure)
+	c.set(key, resp)
+	assert.Nil(t, c.get(key, req, false))
+}
+
+func TestDNSCacheTTLLimits(t *testing.T) {
+	c := newDNSCache(0, 0, 600, 3600)
+	req := &dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+	key := cacheKey(req, nil)
+
+	// a short TTL is raised
+	c.set(key, newTestAResponse(req, net.IP{1, 2, 3, 4}, 10))
+	resp := c.get(key, req, false)
+	assert.NotNil(t, resp)
+	assert.True(t, resp.Answer[0].Header().Ttl > 590)
+	assert.True(t, resp.Answer[0].Header().Ttl <= 600)
+
+	// a long TTL is capped
+	c.set(key, newTestAResponse(req, net.IP{1, 2, 3, 4}, 86400))
+	resp = c.get(key, req, false)
+	assert.NotNil(t, resp)
+	assert.True(t, resp.Answer[0].Header().Ttl > 3590)
+	assert.True(t, resp.Answer[0].Header().Ttl <= 3600)
+
+	// a TTL within the limits isn't changed
+	c.set(key, newTestAResponse(req, net.IP{1, 2, 3, 4}, 1000))
+	resp = c.get(key, req, false)
+	assert.True(t, resp.Answer[0].Header().Ttl > 990)
+	assert.True(t, resp.Answer[0].Header().Ttl <= 1000)
+
+	// the minimum TTL is limited to 1 hour
+	c = newDNSCache(0, 0, 86400, 0)
+	c.set(key, newTestAResponse(req, net.IP{1, 2, 3, 4}, 10))
+	resp = c.get(key, req, false)
+	assert.True(t, resp.Answer[0].Header().Ttl > 3590)
+	assert.True(t, resp.Answer[0].Header().Ttl <= 3600)
+}
+
+// failingUpstream fails if 'fail' is set
+type failingUpstream struct {
+	fail bool
+}
+
+func (u *failingUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if u.fail {
+		return nil, fmt.Errorf("upstream is unreachable")
+	}
+	return newTestAResponse(m, net.IP{1, 2, 3, 4}, 100), nil
+}
+
+func (u *failingUpstream) Address() string {
+	return "failing"
+}
+
+func TestServeStale(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.CacheMaxStale = 3600
+	u := &failingUpstream{}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := &dns.Msg{}
+	req.SetQuestion("stale.example.com.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+
+	expireCached(s.cache, cacheKey(req, nil), 100)
+	u.fail = true
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	assert.Equal(t, uint32(staleTTL), reply.Answer[0].Header().Ttl)
+
+	// nothing in cache
+	req.SetQuestion("other.example.com.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeServerFailure, reply.Rcode)
+
+	_ = s.Stop()
+}
+
+// countingUpstream counts the requests
+type countingUpstream struct {
+	n int32
+}
+
+func (u *countingUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	atomic.AddInt32(&u.n, 1)
+	return newTestAResponse(m, net.IP{1, 2, 3, 4}, 100), nil
+}
+
+func (u *countingUpstream) Address() string {
+	return "counting"
+}
+
+func TestPrefetch(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.CachePrefetch = true
+	u := &countingUpstream{}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	s.prefetch.start()
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := &dns.Msg{}
+	req.SetQuestion("popular.example.com.", dns.TypeA)
+	_, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&u.n))
+
+	// 5 seconds of 100 are left
+	key := cacheKey(req, nil)
+	data := s.cache.items.Get(key)
+	binary.BigEndian.PutUint32(data, uint32(time.Now().Unix())+5)
+	s.cache.items.Set(key, data)
+	assert.True(t, s.cache.expiresSoon(key))
+
+	for i := 0; i != prefetchMinHits; i++ {
+		reply, err := dns.Exchange(req, addr.String())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(reply.Answer))
+	}
+
+	for i := 0; i != 100 && atomic.LoadInt32(&u.n) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&u.n))
+	for i := 0; i != 100 && s.cache.expiresSoon(key); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, s.cache.expiresSoon(key))
+
+	s.prefetch.close()
+	_ = s.Stop()
+}
+
+// blockingUpstream responds only after 'unblock' is closed
+type blockingUpstream struct {
+	received chan bool
+	unblock  chan bool
+}
+
+func (u *blockingUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	u.received <- true
+	<-u.unblock
+	return newTestAResponse(m, net.IP{1, 2, 3, 4}, 100), nil
+}
+
+func (u *blockingUpstream) Address() string {
+	return "blocking"
+}
+
+func TestPrefetchClose(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.CachePrefetch = true
+	u := &blockingUpstream{received: make(chan bool, 1), unblock: make(chan bool)}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	s.prefetch.start()
+
+	req := &dns.Msg{}
+	req.SetQuestion("popular.example.com.", dns.TypeA)
+	s.prefetch.queue <- prefetchItem{key: cacheKey(req, nil), req: req}
+	<-u.received
+
+	// close() waits until the refresh in progress is finished
+	closed := make(chan bool)
+	go func() {
+		s.prefetch.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("close() returned while the refresh is in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(u.unblock)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("close() didn't return")
+	}
+
+	_ = s.Stop()
+}
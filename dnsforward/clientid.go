@@ -0,0 +1,85 @@
+package dnsforward
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+)
+
+// ClientID is a short name of a client which is sent by the client itself:
+// in the server name for DNS-over-TLS: "laptop.dns.example.org"
+// or in the URL path for DNS-over-HTTPS: "/dns-query/laptop".
+// It allows to identify the clients outside of the local network where their IP addresses are unknown.
+
+// Path prefix for DNS-over-HTTPS requests with ClientID
+const dohClientIDPrefix = "/dns-query/"
+
+// IsValidClientID returns TRUE if the string may be used as ClientID:
+// it's a single label of letters, digits and hyphens
+func IsValidClientID(id string) bool {
+	return isValidDHCPHostname(id)
+}
+
+// Get ClientID from the server name sent by client in TLS Client Hello
+// The server name must be a subdomain of a wildcard name from the certificate: "laptop.dns.example.org" for "*.dns.example.org"
+// Return "" if there's no ClientID
+func clientIDFromServerName(sni string, dnsNames []string) string {
+	sni = strings.ToLower(sni)
+	pos := strings.IndexByte(sni, '.')
+	if pos <= 0 {
+		return ""
+	}
+	id := sni[:pos]
+	parent := sni[pos+1:]
+
+	for _, dn := range dnsNames {
+		if strings.EqualFold(dn, sni) {
+			return "" // it's the server's name
+		}
+	}
+	for _, dn := range dnsNames {
+		if strings.HasPrefix(dn, "*.") && strings.EqualFold(dn[2:], parent) && IsValidClientID(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// Get ClientID from the URL path of DNS-over-HTTPS request: "/dns-query/laptop"
+// Return "" if there's no ClientID
+// Return FALSE if the path is invalid
+func clientIDFromPath(path string) (string, bool) {
+	if path == "/dns-query" || path == dohClientIDPrefix {
+		return "", true
+	}
+	if !strings.HasPrefix(path, dohClientIDPrefix) {
+		return "", false
+	}
+	id := strings.TrimSuffix(path[len(dohClientIDPrefix):], "/")
+	if !IsValidClientID(id) {
+		return "", false
+	}
+	return strings.ToLower(id), true
+}
+
+// Get ClientID for the request
+// Return "" if there's no ClientID
+func (s *Server) clientIDFromDNSContext(d *proxy.DNSContext) string {
+	switch d.Proto {
+	case proxy.ProtoTLS:
+		conn, ok := d.Conn.(*tls.Conn)
+		if !ok {
+			return ""
+		}
+		return clientIDFromServerName(conn.ConnectionState().ServerName, s.conf.dnsNames)
+
+	case proxy.ProtoHTTPS:
+		if d.HTTPRequest == nil {
+			return ""
+		}
+		id, _ := clientIDFromPath(d.HTTPRequest.URL.Path)
+		return id
+	}
+	return ""
+}
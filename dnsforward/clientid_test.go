@@ -0,0 +1,39 @@
+package dnsforward
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIDFromServerName(t *testing.T) {
+	dnsNames := []string{"dns.example.org", "*.dns.example.org", "*.example.org"}
+	assert.Equal(t, "laptop", clientIDFromServerName("laptop.dns.example.org", dnsNames))
+	assert.Equal(t, "laptop", clientIDFromServerName("LAPTOP.dns.example.org", dnsNames))
+
+	// the server's own name
+	assert.Equal(t, "", clientIDFromServerName("dns.example.org", dnsNames))
+	// not a wildcard name from certificate
+	assert.Equal(t, "", clientIDFromServerName("laptop.other.example.com", dnsNames))
+	assert.Equal(t, "", clientIDFromServerName("a.laptop.dns.example.org", dnsNames))
+	assert.Equal(t, "", clientIDFromServerName("", dnsNames))
+}
+
+func TestClientIDFromPath(t *testing.T) {
+	id, ok := clientIDFromPath("/dns-query")
+	assert.True(t, ok)
+	assert.Equal(t, "", id)
+
+	id, ok = clientIDFromPath("/dns-query/Laptop")
+	assert.True(t, ok)
+	assert.Equal(t, "laptop", id)
+
+	id, ok = clientIDFromPath("/dns-query/laptop/")
+	assert.True(t, ok)
+	assert.Equal(t, "laptop", id)
+
+	_, ok = clientIDFromPath("/dns-query/a/b")
+	assert.False(t, ok)
+	_, ok = clientIDFromPath("/dns-query/bad_id")
+	assert.False(t, ok)
+}
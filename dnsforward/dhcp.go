@@ -0,0 +1,140 @@
+package dnsforward
+
+import (
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Domain name suffix for the host names of DHCP clients
+const defaultLocalDomainName = "lan"
+
+// Return TRUE if the host name received from DHCP client may be used in DNS responses:
+// it's a single label of letters, digits and hyphens
+func isValidDHCPHostname(host string) bool {
+	if len(host) == 0 || len(host) > 63 ||
+		host[0] == '-' || host[len(host)-1] == '-' {
+		return false
+	}
+	for _, c := range host {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Update the table of local host names when DHCP leases are changed
+func (s *Server) onDHCPLeaseChanged(flags int) {
+	switch flags {
+	case dhcpd.LeaseChangedAdded,
+		dhcpd.LeaseChangedAddedStatic,
+		dhcpd.LeaseChangedRemovedStatic,
+		dhcpd.LeaseChangedRemoved:
+		//
+	default:
+		return
+	}
+
+	tableHostToIP := map[string]net.IP{}
+	tablePTR := map[string]string{}
+	leases := s.conf.DHCPServer.Leases(dhcpd.LeasesAll)
+	for _, l := range leases {
+		if !isValidDHCPHostname(l.Hostname) {
+			continue
+		}
+		host := strings.ToLower(l.Hostname)
+		if l.IP.To4() != nil {
+			tableHostToIP[host] = l.IP
+		}
+
+		rev, err := dns.ReverseAddr(l.IP.String())
+		if err != nil {
+			continue
+		}
+		tablePTR[rev] = host
+	}
+	log.Debug("DNS: added %d A and %d PTR entries from DHCP", len(tableHostToIP), len(tablePTR))
+
+	s.tableLock.Lock()
+	s.tableHostToIP = tableHostToIP
+	s.tablePTR = tablePTR
+	s.tableLock.Unlock()
+}
+
+// Get the host name of DHCP client from the requested domain name: "laptop.lan." -> "laptop"
+// Return "" if the name doesn't belong to the local domain
+func (s *Server) localHostname(name string) string {
+	suffix := "." + s.conf.LocalDomainName + "."
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, suffix) {
+		return ""
+	}
+	host := name[:len(name)-len(suffix)]
+	if strings.Contains(host, ".") {
+		return ""
+	}
+	return host
+}
+
+// Respond to A requests for the host names of DHCP clients
+// If the host is known, its other records don't exist: respond with an empty answer to other request types.
+func processInternalHosts(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	q := d.Req.Question[0]
+	host := s.localHostname(q.Name)
+	if len(host) == 0 {
+		return resultDone
+	}
+
+	s.tableLock.Lock()
+	ip, ok := s.tableHostToIP[host]
+	s.tableLock.Unlock()
+	if !ok {
+		return resultDone
+	}
+
+	log.Debug("DNS: A for %s: %s (DHCP)", q.Name, ip)
+	resp := s.makeResponse(d.Req)
+	if q.Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, s.genAAnswer(d.Req, ip))
+	}
+	d.Res = resp
+	return resultDone
+}
+
+// Respond to PTR requests for the IP addresses leased by DHCP server
+func processInternalIPAddrs(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	q := d.Req.Question[0]
+	if q.Qtype != dns.TypePTR {
+		return resultDone
+	}
+
+	s.tableLock.Lock()
+	host, ok := s.tablePTR[strings.ToLower(q.Name)]
+	s.tableLock.Unlock()
+	if !ok {
+		return resultDone
+	}
+
+	log.Debug("DNS: PTR for %s: %s (DHCP)", q.Name, host)
+	resp := s.makeResponse(d.Req)
+	ptr := &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    s.conf.BlockedResponseTTL,
+		},
+		Ptr: dns.Fqdn(host + "." + s.conf.LocalDomainName),
+	}
+	resp.Answer = append(resp.Answer, ptr)
+	d.Res = resp
+	return resultDone
+}
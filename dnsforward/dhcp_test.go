@@ -0,0 +1,83 @@
+package dnsforward
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDHCPHostnames(t *testing.T) {
+	assert.True(t, isValidDHCPHostname("laptop"))
+	assert.True(t, isValidDHCPHostname("My-PC2"))
+	assert.False(t, isValidDHCPHostname(""))
+	assert.False(t, isValidDHCPHostname("-laptop"))
+	assert.False(t, isValidDHCPHostname("John's iPhone"))
+	assert.False(t, isValidDHCPHostname("laptop.lan"))
+}
+
+func TestDHCPLeases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-dhcp")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	dhcp := dhcpd.Create(dhcpd.ServerConfig{WorkDir: dir})
+
+	s := createTestServer(t)
+	s.conf.DHCPServer = dhcp
+	s.conf.LocalDomainName = "home"
+	err = s.startWithUpstream(&dns64Upstream{ipv4: map[string]net.IP{}})
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	err = dhcp.AddStaticLease(dhcpd.Lease{
+		HWAddr:   net.HardwareAddr{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa},
+		IP:       net.IP{192, 168, 1, 50},
+		Hostname: "Laptop",
+	})
+	assert.Nil(t, err)
+
+	req := &dns.Msg{}
+	req.SetQuestion("50.1.168.192.in-addr.arpa.", dns.TypePTR)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	ptr, ok := reply.Answer[0].(*dns.PTR)
+	assert.True(t, ok)
+	assert.Equal(t, "laptop.home.", ptr.Ptr)
+
+	// not leased
+	req.SetQuestion("51.1.168.192.in-addr.arpa.", dns.TypePTR)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	req.SetQuestion("LAPTOP.home.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	a, ok := reply.Answer[0].(*dns.A)
+	assert.True(t, ok)
+	assert.True(t, a.A.Equal(net.IP{192, 168, 1, 50}))
+
+	// the host exists, but has no IPv6 address
+	req.SetQuestion("laptop.home.", dns.TypeAAAA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	// unknown host is resolved by upstream server
+	req.SetQuestion("desktop.home.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	_ = s.Stop()
+}
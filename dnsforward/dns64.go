@@ -0,0 +1,162 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// The Well-Known Prefix for IPv4/IPv6 translation (RFC 6052)
+const defaultDNS64Prefix = "64:ff9b::/96"
+
+// Parse NAT64 prefix
+// RFC 6052 allows prefix lengths of 32, 40, 48, 56, 64 and 96 bits.
+func parseDNS64Prefix(s string) (*net.IPNet, error) {
+	if len(s) == 0 {
+		s = defaultDNS64Prefix
+	}
+
+	ip, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("DNS64: invalid prefix %s: %s", s, err)
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("DNS64: prefix %s is not IPv6", s)
+	}
+
+	ones, _ := prefix.Mask.Size()
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+		// ok
+	default:
+		return nil, fmt.Errorf("DNS64: invalid prefix length %d", ones)
+	}
+	return prefix, nil
+}
+
+// Embed IPv4 address into IPv6 address with the NAT64 prefix
+// Bits 64..71 of the address must be zero (RFC 6052 2.2)
+func dns64Map(prefix *net.IPNet, ip4 net.IP) net.IP {
+	ones, _ := prefix.Mask.Size()
+	ip4 = ip4.To4()
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, prefix.IP.To16())
+
+	i := ones / 8
+	for j := 0; j != net.IPv4len; i++ {
+		if i == 8 {
+			continue
+		}
+		ip6[i] = ip4[j]
+		j++
+	}
+	return ip6
+}
+
+// Extract IPv4 address from IPv6 address with the NAT64 prefix
+// Returns nil if the address doesn't have this prefix
+func dns64Unmap(prefix *net.IPNet, ip6 net.IP) net.IP {
+	if !prefix.Contains(ip6) {
+		return nil
+	}
+
+	ones, _ := prefix.Mask.Size()
+	ip6 = ip6.To16()
+	ip4 := make(net.IP, net.IPv4len)
+	i := ones / 8
+	for j := 0; j != net.IPv4len; i++ {
+		if i == 8 {
+			continue
+		}
+		ip4[j] = ip6[i]
+		j++
+	}
+	return ip4
+}
+
+// Synthesize AAAA records from A records if there are no AAAA records in the response (DNS64)
+func processDNS64(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	if !s.conf.DNS64Enabled || !ctx.responseFromUpstream ||
+		d.Req.Question[0].Qtype != dns.TypeAAAA || d.Res.Rcode != dns.RcodeSuccess {
+		return resultDone
+	}
+
+	// the client wants to validate DNSSEC by itself - synthesized records would be bogus (RFC 6147 5.5)
+	opt := d.Req.IsEdns0()
+	if d.Req.CheckingDisabled && ctx.origReqDNSSEC && opt != nil && opt.Do() {
+		return resultDone
+	}
+
+	for _, a := range d.Res.Answer {
+		if _, ok := a.(*dns.AAAA); ok {
+			return resultDone // there are native AAAA records
+		}
+	}
+
+	req := &dns.Msg{}
+	req.SetQuestion(d.Req.Question[0].Name, dns.TypeA)
+	ad := &proxy.DNSContext{
+		Proto:     d.Proto,
+		Req:       req,
+		Addr:      d.Addr,
+		StartTime: time.Now(),
+		Upstreams: d.Upstreams,
+	}
+	err := s.resolve(ad)
+	if err != nil || ad.Res.Rcode != dns.RcodeSuccess {
+		log.Debug("DNS64: couldn't resolve A for %s: %v", req.Question[0].Name, err)
+		return resultDone // reply with the original response
+	}
+
+	// TTL of the synthesized records must not exceed the negative caching TTL of the AAAA response
+	maxTTL := uint32(0xffffffff)
+	for _, ns := range d.Res.Ns {
+		if soa, ok := ns.(*dns.SOA); ok {
+			maxTTL = soa.Minttl
+			if soa.Hdr.Ttl < maxTTL {
+				maxTTL = soa.Hdr.Ttl
+			}
+		}
+	}
+
+	answer := []dns.RR{}
+	synthesized := false
+	for _, rr := range ad.Res.Answer {
+		switch v := rr.(type) {
+		case *dns.CNAME:
+			answer = append(answer, v)
+
+		case *dns.A:
+			ttl := v.Hdr.Ttl
+			if ttl > maxTTL {
+				ttl = maxTTL
+			}
+			aaaa := &dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   v.Hdr.Name,
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET,
+					Ttl:    ttl,
+				},
+				AAAA: dns64Map(s.dns64Prefix, v.A),
+			}
+			answer = append(answer, aaaa)
+			synthesized = true
+		}
+	}
+	if !synthesized {
+		return resultDone
+	}
+
+	log.Debug("DNS64: synthesized AAAA records for %s", req.Question[0].Name)
+	d.Res.Answer = answer
+	d.Res.Ns = nil
+	ctx.dns64Synthesized = true
+	return resultDone
+}
@@ -0,0 +1,116 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNS64Map(t *testing.T) {
+	// RFC 6052 2.4
+	tests := []struct {
+		prefix string
+		ip6    string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+	}
+	ip4 := net.ParseIP("192.0.2.33")
+	for _, tc := range tests {
+		prefix, err := parseDNS64Prefix(tc.prefix)
+		assert.Nil(t, err)
+		ip6 := dns64Map(prefix, ip4)
+		assert.True(t, ip6.Equal(net.ParseIP(tc.ip6)), "%s: %s", tc.prefix, ip6)
+		assert.True(t, ip4.Equal(dns64Unmap(prefix, ip6)))
+	}
+
+	prefix, err := parseDNS64Prefix("")
+	assert.Nil(t, err)
+	assert.Equal(t, "64:ff9b::/96", prefix.String())
+	assert.Nil(t, dns64Unmap(prefix, net.ParseIP("2001:db8::1")))
+
+	_, err = parseDNS64Prefix("64:ff9b::/80")
+	assert.NotNil(t, err)
+	_, err = parseDNS64Prefix("1.2.3.0/24")
+	assert.NotNil(t, err)
+}
+
+// dns64Upstream has A records only
+type dns64Upstream struct {
+	ipv4 map[string]net.IP
+}
+
+func (u *dns64Upstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	resp := &dns.Msg{}
+	resp.SetReply(m)
+	name := m.Question[0].Name
+	ip, ok := u.ipv4[name]
+	if !ok {
+		resp.SetRcode(m, dns.RcodeNameError)
+		return resp, nil
+	}
+
+	if m.Question[0].Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+			A:   ip,
+		})
+	} else {
+		resp.Ns = append(resp.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 600},
+			Ns:     "ns.example.org.",
+			Mbox:   "admin.example.org.",
+			Minttl: 300,
+		})
+	}
+	return resp, nil
+}
+
+func (u *dns64Upstream) Address() string {
+	return "dns64"
+}
+
+func TestDNS64(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.DNS64Enabled = true
+	u := &dns64Upstream{ipv4: map[string]net.IP{
+		"ipv4only.example.com.": {1, 2, 3, 4},
+		"blocked.example.com.":  {127, 0, 0, 255},
+	}}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := &dns.Msg{}
+	req.SetQuestion("ipv4only.example.com.", dns.TypeAAAA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	aaaa, ok := reply.Answer[0].(*dns.AAAA)
+	assert.True(t, ok)
+	assert.True(t, aaaa.AAAA.Equal(net.ParseIP("64:ff9b::1.2.3.4")))
+	assert.Equal(t, uint32(300), aaaa.Hdr.Ttl)
+
+	// the original IPv4 address is blocked by filters
+	req.SetQuestion("blocked.example.com.", dns.TypeAAAA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	// nothing to synthesize
+	req.SetQuestion("unknown.example.com.", dns.TypeAAAA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	_ = s.Stop()
+}
@@ -0,0 +1,174 @@
+package dnsforward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/joomcode/errorx"
+	"github.com/miekg/dns"
+)
+
+// DNSCryptConfig is the DNSCrypt server configuration
+type DNSCryptConfig struct {
+	Enabled        bool
+	UDPListenAddr  *net.UDPAddr
+	TCPListenAddr  *net.TCPAddr
+	ResolverConfig dnscrypt.ResolverConfig // provider name and keys
+}
+
+// DNSCrypt server along with its listeners (one per listen IP address)
+type dnsCryptServer struct {
+	server     *dnscrypt.Server
+	udpConns   []*net.UDPConn
+	tcpListens []net.Listener
+}
+
+// dnsCryptHandler passes decrypted DNS requests through our filtering pipeline
+type dnsCryptHandler struct {
+	srv *Server
+}
+
+// Create DNSCrypt server object:
+//  the resolver certificate is signed with the provider's private key
+func (s *Server) prepareDNSCrypt() error {
+	rc := s.conf.DNSCryptConfig.ResolverConfig
+	if len(rc.ProviderName) == 0 || len(rc.PrivateKey) == 0 {
+		return fmt.Errorf("DNSCrypt: provider name and private key must be set")
+	}
+
+	cert, err := rc.CreateCert()
+	if err != nil {
+		return errorx.Decorate(err, "DNSCrypt: couldn't create certificate")
+	}
+
+	s.dnsCrypt = &dnsCryptServer{
+		server: &dnscrypt.Server{
+			ProviderName: rc.ProviderName,
+			ResolverCert: cert,
+			Handler:      &dnsCryptHandler{srv: s},
+		},
+	}
+	return nil
+}
+
+// Start listening for DNSCrypt requests
+func (s *Server) startDNSCrypt() error {
+	dc := s.dnsCrypt
+	if dc == nil {
+		return nil
+	}
+
+	if a := s.conf.DNSCryptConfig.UDPListenAddr; a != nil {
+		addrs := []*net.UDPAddr{a}
+		for _, ip := range s.conf.ExtraListenIPs {
+			addrs = append(addrs, &net.UDPAddr{IP: ip, Port: a.Port})
+		}
+		for _, addr := range addrs {
+			conn, err := net.ListenUDP("udp", addr)
+			if err != nil {
+				s.stopDNSCrypt()
+				return errorx.Decorate(err, "DNSCrypt: couldn't listen on UDP")
+			}
+			dc.udpConns = append(dc.udpConns, conn)
+			go func() {
+				err := dc.server.ServeUDP(conn)
+				if err != nil {
+					log.Debug("DNSCrypt: ServeUDP: %s", err)
+				}
+			}()
+		}
+	}
+
+	if a := s.conf.DNSCryptConfig.TCPListenAddr; a != nil {
+		addrs := []*net.TCPAddr{a}
+		for _, ip := range s.conf.ExtraListenIPs {
+			addrs = append(addrs, &net.TCPAddr{IP: ip, Port: a.Port})
+		}
+		for _, addr := range addrs {
+			l, err := net.ListenTCP("tcp", addr)
+			if err != nil {
+				s.stopDNSCrypt()
+				return errorx.Decorate(err, "DNSCrypt: couldn't listen on TCP")
+			}
+			dc.tcpListens = append(dc.tcpListens, l)
+			go func() {
+				err := dc.server.ServeTCP(l)
+				if err != nil {
+					log.Debug("DNSCrypt: ServeTCP: %s", err)
+				}
+			}()
+		}
+	}
+
+	return nil
+}
+
+// Stop listening for DNSCrypt requests and close the sockets
+func (s *Server) stopDNSCrypt() {
+	dc := s.dnsCrypt
+	if dc == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_ = dc.server.Shutdown(ctx)
+	cancel()
+
+	for _, conn := range dc.udpConns {
+		_ = conn.Close()
+	}
+	dc.udpConns = nil
+	for _, l := range dc.tcpListens {
+		_ = l.Close()
+	}
+	dc.tcpListens = nil
+}
+
+// ServeDNS implements dnscrypt.Handler interface
+func (h *dnsCryptHandler) ServeDNS(rw dnscrypt.ResponseWriter, r *dns.Msg) error {
+	s := h.srv
+	s.RLock()
+	p := s.dnsProxy
+	s.RUnlock()
+	if p == nil {
+		return fmt.Errorf("DNS server is not running")
+	}
+
+	d := &proxy.DNSContext{
+		Proto:     "dnscrypt",
+		Req:       r,
+		Addr:      rw.RemoteAddr(),
+		StartTime: time.Now(),
+	}
+
+	ok, err := s.beforeRequestHandler(p, d)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil // don't reply, just like the proxy does
+	}
+	if d.Res != nil {
+		return rw.WriteMsg(d.Res) // the request is rate-limited
+	}
+
+	if s.conf.RefuseAny && r.Question[0].Qtype == dns.TypeANY {
+		d.Res = &dns.Msg{}
+		d.Res.SetRcode(r, dns.RcodeNotImplemented)
+		return rw.WriteMsg(d.Res)
+	}
+
+	err = s.handleDNSRequest(p, d)
+	if err != nil {
+		return err
+	}
+	if d.Res == nil {
+		return nil
+	}
+	return rw.WriteMsg(d.Res)
+}
@@ -8,10 +8,13 @@ import (
 	"net/http"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/AdguardTeam/AdGuardHome/querylog"
 	"github.com/AdguardTeam/AdGuardHome/stats"
@@ -50,17 +53,51 @@ var webRegistered bool
 // The zero Server is empty and ready for use.
 type Server struct {
 	dnsProxy  *proxy.Proxy         // DNS proxy instance
+	extraDNS  []*proxy.Proxy       // DNS proxy instances listening on ExtraListenIPs
 	dnsFilter *dnsfilter.Dnsfilter // DNS filter instance
 	queryLog  querylog.QueryLog    // Query log instance
 	stats     stats.Stats
 	access    *accessCtx
+	ratelimit *rateLimiter // nil if rate limiting is disabled
+
+	blockedResponseIPs []net.IPNet // parsed BlockedResponseIPs
+
+	// Creates upstream objects according to upstream_bind_address, upstream_proxy and upstream_pins settings
+	addressToUpstream proxy.AddressToUpstreamFunction
+	tlsUpstreams      *tlsUpstreams // DNS-over-TLS and DNS-over-HTTPS upstream servers (nil if not used)
 
 	// DNS proxy instance for internal usage
 	// We don't Start() it and so no listen port is required.
 	internalProxy *proxy.Proxy
 
+	dnsCrypt *dnsCryptServer // DNSCrypt server (nil if disabled)
+
+	cache        *dnsCache                      // responses from upstream servers
+	prefetch     *prefetcher                    // refreshes popular responses in cache (nil if disabled)
+	upstreamRTT  *rttTracker                    // round-trip time of upstream servers
+	metrics      *dnsMetrics                    // counters for Prometheus metrics
+	dnssec       *dnssecValidator               // DNSSEC validator
+	dns64Prefix  *net.IPNet                     // NAT64 prefix (DNS64)
+	privateZones map[string][]upstream.Upstream // private zone -> internal DNS servers (conditional forwarding)
+	probeStop    chan bool                      // close it to stop probing upstream servers
+
+	localPTRUpstreams []upstream.Upstream // DNS servers for PTR requests for private IP addresses
+	localResolvers    []string            // private DNS servers from the system configuration
+
+	tableLock      sync.Mutex
+	tableHostToIP  map[string]net.IP // "laptop" -> IP address (DHCP leases)
+	tablePTR       map[string]string // "50.1.168.192.in-addr.arpa." -> "laptop" (DHCP leases)
+	dhcpSubscribed bool              // we receive notifications about DHCP leases
+
+	tableTXT map[string][]string // "_acme-challenge.example.org." -> TXT values (local records)
+
+	protectionDisabledUntil time.Time // protection is paused until this time
+	protectionSuspended     bool      // protection is paused until ResumeProtection() is called
+
 	isRunning bool
 
+	activeRequests int32 // the number of DNS requests being processed (atomic)
+
 	sync.RWMutex
 	conf ServerConfig
 }
@@ -72,6 +109,7 @@ func NewServer(dnsFilter *dnsfilter.Dnsfilter, stats stats.Stats, queryLog query
 	s.dnsFilter = dnsFilter
 	s.stats = stats
 	s.queryLog = queryLog
+	s.metrics = newDNSMetrics()
 
 	if runtime.GOARCH == "mips" || runtime.GOARCH == "mipsle" {
 		// Use plain DNS on MIPS, encryption is too slow
@@ -87,6 +125,7 @@ func (s *Server) Close() {
 	s.stats = nil
 	s.queryLog = nil
 	s.dnsProxy = nil
+	s.extraDNS = nil
 	s.Unlock()
 }
 
@@ -106,7 +145,11 @@ func (s *Server) WriteDiskConfig(c *FilteringConfig) {
 	c.AllowedClients = stringArrayDup(sc.AllowedClients)
 	c.DisallowedClients = stringArrayDup(sc.DisallowedClients)
 	c.BlockedHosts = stringArrayDup(sc.BlockedHosts)
+	c.BlockedResponseIPs = stringArrayDup(sc.BlockedResponseIPs)
 	c.UpstreamDNS = stringArrayDup(sc.UpstreamDNS)
+	c.PrivateZones = stringArrayDup(sc.PrivateZones)
+	c.LocalPTRUpstreams = stringArrayDup(sc.LocalPTRUpstreams)
+	c.UpstreamPins = stringArrayDup(sc.UpstreamPins)
 	s.RUnlock()
 }
 
@@ -114,10 +157,19 @@ func (s *Server) WriteDiskConfig(c *FilteringConfig) {
 // The zero FilteringConfig is empty and ready for use.
 type FilteringConfig struct {
 	// Filtering callback function
-	FilterHandler func(clientAddr string, settings *dnsfilter.RequestFilteringSettings) `yaml:"-"`
+	// clientID: ClientID sent by the client via DNS-over-TLS or DNS-over-HTTPS (may be empty)
+	FilterHandler func(clientAddr, clientID string, settings *dnsfilter.RequestFilteringSettings) `yaml:"-"`
 
-	// This callback function returns the list of upstream servers for a client specified by IP address
-	GetUpstreamsByClient func(clientAddr string) []upstream.Upstream `yaml:"-"`
+	// This callback function returns the list of upstream servers for a client specified by IP address or ClientID
+	GetUpstreamsByClient func(clientAddr, clientID string) []upstream.Upstream `yaml:"-"`
+
+	// This callback function returns the addresses of upstream servers for a client specified by IP address or ClientID
+	// It's used instead of GetUpstreamsByClient:  the upstream objects are created according to the server settings
+	GetUpstreamAddrsByClient func(clientAddr, clientID string) []string `yaml:"-"`
+
+	// This callback function returns TRUE if the requests of a client specified by IP address or ClientID
+	//  must not be written to query log (ignoreQueryLog) or counted in statistics (ignoreStats)
+	GetLogSettingsByClient func(clientAddr, clientID string) (ignoreQueryLog, ignoreStats bool) `yaml:"-"`
 
 	ProtectionEnabled bool `yaml:"protection_enabled"` // whether or not use any of dnsfilter features
 
@@ -128,33 +180,76 @@ type FilteringConfig struct {
 	BlockingIPAddrv6 net.IP `yaml:"-"`
 
 	BlockedResponseTTL uint32   `yaml:"blocked_response_ttl"` // if 0, then default is used (3600)
-	Ratelimit          uint32   `yaml:"ratelimit"`            // max number of requests per second from a given IP (0 to disable)
-	RatelimitWhitelist []string `yaml:"ratelimit_whitelist"`  // a list of whitelisted client IP addresses
+	Ratelimit          uint32   `yaml:"ratelimit"`            // max number of requests per second from a given IP via UDP (0 to disable)
+	RatelimitTCP       uint32   `yaml:"ratelimit_tcp"`        // max number of requests per second from a given IP via TCP, DoT or DoH (0 to disable)
+	RatelimitWhitelist []string `yaml:"ratelimit_whitelist"`  // IP addresses and CIDRs of the clients which aren't rate-limited
+	RatelimitResponse  string   `yaml:"ratelimit_response"`   // the response to the rate-limited requests: "drop" or "servfail"
 	RefuseAny          bool     `yaml:"refuse_any"`           // if true, refuse ANY requests
 	BootstrapDNS       []string `yaml:"bootstrap_dns"`        // a list of bootstrap DNS for DoH and DoT (plain DNS only)
 	AllServers         bool     `yaml:"all_servers"`          // if true, parallel queries to all configured upstream servers are enabled
 
-	EnableEDNSClientSubnet bool `yaml:"edns_client_subnet"` // Enable EDNS Client Subnet option
+	EnableEDNSClientSubnet bool   `yaml:"edns_client_subnet"`       // Enable EDNS Client Subnet option
+	EDNSClientSubnetIP     string `yaml:"edns_client_subnet_ip"`    // IP address to use in EDNS Client Subnet option instead of the client's IP
+	StripEDNSClientSubnet  bool   `yaml:"edns_client_subnet_strip"` // Don't send EDNS Client Subnet option to upstream servers
 
-	EnableDNSSEC bool `yaml:"enable_dnssec"` // Set DNSSEC flag in outcoming DNS request
+	EnableDNSSEC bool `yaml:"enable_dnssec"` // Set DNSSEC flag in outcoming DNS request and validate responses
 
 	// Respond with an empty answer to all AAAA requests
 	AAAADisabled bool `yaml:"aaaa_disabled"`
 
 	FastestAddrAlgo bool `yaml:"fastest_addr"` // use Fastest Address algorithm
 
-	AllowedClients    []string `yaml:"allowed_clients"`    // IP addresses of whitelist clients
-	DisallowedClients []string `yaml:"disallowed_clients"` // IP addresses of clients that should be blocked
+	// Send the requests to the upstream server with the lowest round-trip time first (see upstream_rtt.go)
+	FastestUpstream bool `yaml:"fastest_upstream"`
+
+	// Probe upstream servers every N seconds and don't send the requests to the failed ones (0: disabled)
+	UpstreamCheckInterval uint32 `yaml:"upstream_check_interval"`
+
+	DNS64Enabled bool   `yaml:"dns64_enabled"` // synthesize AAAA records from A records if there are no AAAA records (DNS64)
+	DNS64Prefix  string `yaml:"dns64_prefix"`  // NAT64 prefix, the default is 64:ff9b::/96
+
+	AllowedClients    []string `yaml:"allowed_clients"`    // IP addresses, CIDRs or ClientIDs of whitelist clients
+	DisallowedClients []string `yaml:"disallowed_clients"` // IP addresses, CIDRs or ClientIDs of clients that should be blocked
 	BlockedHosts      []string `yaml:"blocked_hosts"`      // hosts that should be blocked
 
+	// IP addresses and CIDRs:  the responses with these addresses in A or AAAA records are blocked
+	BlockedResponseIPs []string `yaml:"blocked_response_ips"`
+
 	// IP (or domain name) which is used to respond to DNS requests blocked by parental control or safe-browsing
 	ParentalBlockHost     string `yaml:"parental_block_host"`
 	SafeBrowsingBlockHost string `yaml:"safebrowsing_block_host"`
 
-	CacheSize   uint32   `yaml:"cache_size"`    // DNS cache size (in bytes)
-	CacheMinTTL uint32   `yaml:"cache_ttl_min"` // override TTL value (minimum) received from upstream server
-	CacheMaxTTL uint32   `yaml:"cache_ttl_max"` // override TTL value (maximum) received from upstream server
+	CacheSize   uint32 `yaml:"cache_size"`    // DNS cache size (in bytes)
+	CacheMinTTL uint32 `yaml:"cache_ttl_min"` // override TTL value (minimum) received from upstream server
+	CacheMaxTTL uint32 `yaml:"cache_ttl_max"` // override TTL value (maximum) received from upstream server
+
+	// Max. time (in seconds) since expiration when a cached response may be used if upstream servers are unreachable
+	// 0: don't use expired responses
+	CacheMaxStale uint32 `yaml:"cache_max_stale"`
+
+	CachePrefetch bool `yaml:"cache_prefetch"` // refresh popular cached responses before they expire
+
 	UpstreamDNS []string `yaml:"upstream_dns"`
+
+	// IP address or network interface name:  the requests to upstream servers are sent from it
+	UpstreamBindAddress string `yaml:"upstream_bind_address"`
+
+	// URL of SOCKS5 or HTTP proxy for DNS-over-HTTPS and DNS-over-TLS upstream servers
+	UpstreamProxy string `yaml:"upstream_proxy"`
+
+	// SPKI pins of DNS-over-TLS and DNS-over-HTTPS upstream servers: "host base64-sha256"
+	UpstreamPins []string `yaml:"upstream_pins"`
+
+	// Domain name suffix for the host names of DHCP clients: "laptop" -> "laptop.lan"
+	LocalDomainName string `yaml:"local_domain_name"`
+
+	// Conditional forwarding: requests for these zones are sent only to the specified internal DNS servers,
+	// they are neither filtered nor cached.
+	// Format: "[/domain1/domain2/]upstream", e.g. "[/corp.example/1.168.192.in-addr.arpa/]192.168.1.1"
+	PrivateZones []string `yaml:"private_zones"`
+
+	// DNS servers for PTR requests for private IP addresses;  the system resolvers are used if empty
+	LocalPTRUpstreams []string `yaml:"local_ptr_upstreams"`
 }
 
 // TLSConfig is the TLS configuration for HTTPS, DNS-over-HTTPS, and DNS-over-TLS
@@ -179,22 +274,40 @@ type TLSConfig struct {
 type ServerConfig struct {
 	UDPListenAddr            *net.UDPAddr                   // UDP listen address
 	TCPListenAddr            *net.TCPAddr                   // TCP listen address
+	ExtraListenIPs           []net.IP                       // additional IP addresses to listen on (the same ports are used;  plain DNS, DoT and DNSCrypt)
 	Upstreams                []upstream.Upstream            // Configured upstreams
 	DomainsReservedUpstreams map[string][]upstream.Upstream // Map of domains and lists of configured upstreams
 	OnDNSRequest             func(d *proxy.DNSContext)
 
 	FilteringConfig
 	TLSConfig
+	DNSCryptConfig
 	TLSAllowUnencryptedDOH bool
+	TLSDisableDOH          bool // if true, DNS-over-HTTPS requests are rejected
+	TLSEDNSPadding         bool // if true, DNS-over-TLS and DNS-over-HTTPS responses are padded (RFC 8467)
 
 	TLSv12Roots *x509.CertPool // list of root CAs for TLSv1.2
 	TLSCiphers  []uint16       // list of TLS ciphers to use
 
+	// TLS versions and cipher suites of DNS-over-TLS server
+	TLSMinVersion    uint16   // 0: TLS 1.2
+	TLSMaxVersion    uint16   // 0: the highest supported
+	TLSServerCiphers []uint16 // nil: the default list
+
+	// If set, DNS-over-TLS and DNS-over-HTTPS clients must present a certificate signed by one of these CAs
+	TLSClientCAs *x509.CertPool
+
+	// DHCP server whose leases are used to answer local requests (optional)
+	DHCPServer *dhcpd.Server
+
 	// Called when the configuration is changed by HTTP request
 	ConfigModified func()
 
 	// Register an HTTP handler
 	HTTPRegister func(string, string, func(http.ResponseWriter, *http.Request))
+
+	// Get OCSP response to staple for the certificate (DER) (optional)
+	GetOCSPStaple func(cert []byte) []byte
 }
 
 // if any of ServerConfig values are zero, then default values from below are used
@@ -227,6 +340,13 @@ func (s *Server) Exchange(req *dns.Msg) (*dns.Msg, error) {
 		Req:       req,
 		StartTime: time.Now(),
 	}
+	if len(req.Question) == 1 && isPrivatePTR(req.Question[0]) {
+		// PTR requests for private IP addresses are never sent to public upstream servers
+		if len(s.localPTRUpstreams) == 0 {
+			return s.genNXDomain(req), nil
+		}
+		ctx.Upstreams = s.localPTRUpstreams
+	}
 	err := s.internalProxy.Resolve(ctx)
 	if err != nil {
 		return nil, err
@@ -244,10 +364,56 @@ func (s *Server) Start() error {
 // startInternal starts without locking
 func (s *Server) startInternal() error {
 	err := s.dnsProxy.Start()
-	if err == nil {
-		s.isRunning = true
+	if err != nil {
+		return err
 	}
-	return err
+	for _, p := range s.extraDNS {
+		err = p.Start()
+		if err != nil {
+			_ = s.stopProxies()
+			return err
+		}
+	}
+
+	err = s.startDNSCrypt()
+	if err != nil {
+		_ = s.stopProxies()
+		return err
+	}
+
+	if s.conf.FastestUpstream || s.conf.UpstreamCheckInterval != 0 {
+		s.startProbing()
+	}
+	if s.prefetch != nil {
+		s.prefetch.start()
+	}
+
+	s.isRunning = true
+	return nil
+}
+
+// Parse custom blocking IP addresses
+// The addresses are independent: either of them may be empty, but not both.
+// IPv4 address must be used for A requests and IPv6 address - for AAAA requests.
+func parseBlockingIPs(ipv4, ipv6 string) (net.IP, net.IP, error) {
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		return nil, nil, fmt.Errorf("no IP addresses")
+	}
+
+	var ip4, ip6 net.IP
+	if len(ipv4) != 0 {
+		ip4 = net.ParseIP(ipv4)
+		if ip4 == nil || ip4.To4() == nil {
+			return nil, nil, fmt.Errorf("invalid IPv4 address: %s", ipv4)
+		}
+	}
+	if len(ipv6) != 0 {
+		ip6 = net.ParseIP(ipv6)
+		if ip6 == nil || ip6.To4() != nil {
+			return nil, nil, fmt.Errorf("invalid IPv6 address: %s", ipv6)
+		}
+	}
+	return ip4, ip6, nil
 }
 
 // Prepare the object
@@ -256,10 +422,10 @@ func (s *Server) Prepare(config *ServerConfig) error {
 	if config != nil {
 		s.conf = *config
 		if s.conf.BlockingMode == "custom_ip" {
-			s.conf.BlockingIPAddrv4 = net.ParseIP(s.conf.BlockingIPv4)
-			s.conf.BlockingIPAddrv6 = net.ParseIP(s.conf.BlockingIPv6)
-			if s.conf.BlockingIPAddrv4 == nil || s.conf.BlockingIPAddrv6 == nil {
-				return fmt.Errorf("DNS: invalid custom blocking IP address specified")
+			var err error
+			s.conf.BlockingIPAddrv4, s.conf.BlockingIPAddrv6, err = parseBlockingIPs(s.conf.BlockingIPv4, s.conf.BlockingIPv6)
+			if err != nil {
+				return fmt.Errorf("DNS: invalid custom blocking IP address specified: %s", err)
 			}
 		}
 	}
@@ -271,12 +437,50 @@ func (s *Server) Prepare(config *ServerConfig) error {
 		s.conf.BootstrapDNS = defaultBootstrap
 	}
 
-	upstreamConfig, err := proxy.ParseUpstreamsConfig(s.conf.UpstreamDNS, s.conf.BootstrapDNS, DefaultTimeout)
+	var err error
+	s.addressToUpstream, s.tlsUpstreams, err = newAddressToUpstream(s.conf.UpstreamBindAddress, s.conf.UpstreamProxy,
+		s.conf.UpstreamPins, s.conf.BootstrapDNS)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+	upstreamConfig, err := parseUpstreamsConfig(s.conf.UpstreamDNS, s.conf.BootstrapDNS, s.addressToUpstream)
 	if err != nil {
 		return fmt.Errorf("DNS: proxy.ParseUpstreamsConfig: %s", err)
 	}
-	s.conf.Upstreams = upstreamConfig.Upstreams
-	s.conf.DomainsReservedUpstreams = upstreamConfig.DomainReservedUpstreams
+	s.cache = newDNSCache(s.conf.CacheSize, s.conf.CacheMaxStale, s.conf.CacheMinTTL, s.conf.CacheMaxTTL)
+	s.prefetch = nil
+	if s.conf.CachePrefetch {
+		s.prefetch = newPrefetcher(s)
+	}
+
+	if s.upstreamRTT == nil {
+		s.upstreamRTT = newRTTTracker()
+	}
+	s.conf.Upstreams = s.upstreamRTT.wrap(upstreamConfig.Upstreams)
+	s.conf.DomainsReservedUpstreams = map[string][]upstream.Upstream{}
+	for domain, ups := range upstreamConfig.DomainReservedUpstreams {
+		s.conf.DomainsReservedUpstreams[domain] = s.upstreamRTT.wrap(ups)
+	}
+
+	s.privateZones, err = parsePrivateZones(s.conf.PrivateZones, s.conf.BootstrapDNS, s.addressToUpstream)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+
+	err = s.prepareLocalPTR()
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+
+	if s.conf.DHCPServer != nil && !s.dhcpSubscribed {
+		s.conf.DHCPServer.SetOnLeaseChanged(s.onDHCPLeaseChanged)
+		s.dhcpSubscribed = true
+		s.onDHCPLeaseChanged(dhcpd.LeaseChangedAdded)
+	}
+
+	if len(s.conf.LocalDomainName) == 0 {
+		s.conf.LocalDomainName = defaultLocalDomainName
+	}
 
 	if len(s.conf.ParentalBlockHost) == 0 {
 		s.conf.ParentalBlockHost = parentalBlockHost
@@ -294,11 +498,8 @@ func (s *Server) Prepare(config *ServerConfig) error {
 	proxyConfig := proxy.Config{
 		UDPListenAddr:            s.conf.UDPListenAddr,
 		TCPListenAddr:            s.conf.TCPListenAddr,
-		Ratelimit:                int(s.conf.Ratelimit),
-		RatelimitWhitelist:       s.conf.RatelimitWhitelist,
 		RefuseAny:                s.conf.RefuseAny,
-		CacheEnabled:             true,
-		CacheSizeBytes:           int(s.conf.CacheSize),
+		CacheEnabled:             false, // responses are cached by s.cache, it applies the same TTL limits
 		CacheMinTTL:              s.conf.CacheMinTTL,
 		CacheMaxTTL:              s.conf.CacheMaxTTL,
 		Upstreams:                s.conf.Upstreams,
@@ -306,10 +507,17 @@ func (s *Server) Prepare(config *ServerConfig) error {
 		BeforeRequestHandler:     s.beforeRequestHandler,
 		RequestHandler:           s.handleDNSRequest,
 		AllServers:               s.conf.AllServers,
-		EnableEDNSClientSubnet:   s.conf.EnableEDNSClientSubnet,
+		EnableEDNSClientSubnet:   s.ecsEnabled(),
 		FindFastestAddr:          s.conf.FastestAddrAlgo,
 	}
 
+	if len(s.conf.EDNSClientSubnetIP) != 0 {
+		proxyConfig.EDNSAddr = net.ParseIP(s.conf.EDNSClientSubnetIP)
+		if proxyConfig.EDNSAddr == nil {
+			return fmt.Errorf("DNS: invalid EDNS Client Subnet IP address: %s", s.conf.EDNSClientSubnetIP)
+		}
+	}
+
 	intlProxyConfig := proxy.Config{
 		CacheEnabled:             true,
 		CacheSizeBytes:           4096,
@@ -318,6 +526,36 @@ func (s *Server) Prepare(config *ServerConfig) error {
 	}
 	s.internalProxy = &proxy.Proxy{Config: intlProxyConfig}
 
+	if s.dnssec == nil {
+		s.dnssec = newDNSSECValidator(func(req *dns.Msg) (*dns.Msg, error) {
+			d := &proxy.DNSContext{
+				Proto:     "udp",
+				Req:       req,
+				StartTime: time.Now(),
+			}
+			err := s.internalProxy.Resolve(d)
+			return d.Res, err
+		})
+	}
+
+	s.dns64Prefix = nil
+	if s.conf.DNS64Enabled {
+		s.dns64Prefix, err = parseDNS64Prefix(s.conf.DNS64Prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.ratelimit, err = newRateLimiter(&s.conf.FilteringConfig)
+	if err != nil {
+		return fmt.Errorf("DNS: %s", err)
+	}
+
+	s.blockedResponseIPs, err = parseIPNets(s.conf.BlockedResponseIPs)
+	if err != nil {
+		return fmt.Errorf("DNS: blocked_response_ips: %s", err)
+	}
+
 	s.access = &accessCtx{}
 	err = s.access.Init(s.conf.AllowedClients, s.conf.DisallowedClients, s.conf.BlockedHosts)
 	if err != nil {
@@ -348,12 +586,29 @@ func (s *Server) Prepare(config *ServerConfig) error {
 
 		proxyConfig.TLSConfig = &tls.Config{
 			GetCertificate: s.onGetCertificate,
-			MinVersion:     tls.VersionTLS12,
+			MinVersion:     s.conf.TLSMinVersion,
+			MaxVersion:     s.conf.TLSMaxVersion,
+			CipherSuites:   s.conf.TLSServerCiphers,
+		}
+		if proxyConfig.TLSConfig.MinVersion == 0 {
+			proxyConfig.TLSConfig.MinVersion = tls.VersionTLS12
+		}
+		if s.conf.TLSClientCAs != nil {
+			proxyConfig.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			proxyConfig.TLSConfig.ClientCAs = s.conf.TLSClientCAs
 		}
 	}
 	upstream.RootCAs = s.conf.TLSv12Roots
 	upstream.CipherSuites = s.conf.TLSCiphers
 
+	s.dnsCrypt = nil
+	if s.conf.DNSCryptConfig.Enabled {
+		err = s.prepareDNSCrypt()
+		if err != nil {
+			return err
+		}
+	}
+
 	if len(proxyConfig.Upstreams) == 0 {
 		log.Fatal("len(proxyConfig.Upstreams) == 0")
 	}
@@ -365,6 +620,18 @@ func (s *Server) Prepare(config *ServerConfig) error {
 
 	// Initialize and start the DNS proxy
 	s.dnsProxy = &proxy.Proxy{Config: proxyConfig}
+
+	// The same DNS proxy configuration for each additional IP address
+	s.extraDNS = nil
+	for _, ip := range s.conf.ExtraListenIPs {
+		c := proxyConfig
+		c.UDPListenAddr = &net.UDPAddr{IP: ip, Port: s.conf.UDPListenAddr.Port}
+		c.TCPListenAddr = &net.TCPAddr{IP: ip, Port: s.conf.TCPListenAddr.Port}
+		if c.TLSListenAddr != nil {
+			c.TLSListenAddr = &net.TCPAddr{IP: ip, Port: c.TLSListenAddr.Port}
+		}
+		s.extraDNS = append(s.extraDNS, &proxy.Proxy{Config: c})
+	}
 	return nil
 }
 
@@ -412,6 +679,11 @@ func (s *Server) onGetCertificate(ch *tls.ClientHelloInfo) (*tls.Certificate, er
 		log.Info("DNS: TLS: unknown SNI in Client Hello: %s", ch.ServerName)
 		return nil, fmt.Errorf("invalid SNI")
 	}
+	if s.conf.GetOCSPStaple != nil && len(s.conf.cert.Certificate) != 0 {
+		cert := s.conf.cert
+		cert.OCSPStaple = s.conf.GetOCSPStaple(cert.Certificate[0])
+		return &cert, nil
+	}
 	return &s.conf.cert, nil
 }
 
@@ -422,19 +694,56 @@ func (s *Server) Stop() error {
 	return s.stopInternal()
 }
 
+// Drain waits until the DNS requests being processed are finished
+// Call it after Stop():  the requests received via the connections which are still open are processed too.
+// Return FALSE if the timeout has expired
+func (s *Server) Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&s.activeRequests) != 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
 // stopInternal stops without locking
 func (s *Server) stopInternal() error {
-	if s.dnsProxy != nil {
-		err := s.dnsProxy.Stop()
-		if err != nil {
-			return errorx.Decorate(err, "could not stop the DNS server properly")
-		}
+	s.stopProbing()
+	if s.prefetch != nil {
+		s.prefetch.close()
+	}
+	s.stopDNSCrypt()
+	if s.tlsUpstreams != nil {
+		s.tlsUpstreams.close()
+	}
+
+	err := s.stopProxies()
+	if err != nil {
+		return errorx.Decorate(err, "could not stop the DNS server properly")
 	}
 
 	s.isRunning = false
 	return nil
 }
 
+// Stop all DNS proxy instances (the instances which aren't started are skipped)
+// Return the first error
+func (s *Server) stopProxies() error {
+	var err error
+	if s.dnsProxy != nil {
+		err = s.dnsProxy.Stop()
+	}
+	for _, p := range s.extraDNS {
+		e := p.Stop()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 // IsRunning returns true if the DNS server is running
 func (s *Server) IsRunning() bool {
 	s.RLock()
@@ -495,8 +804,25 @@ func ipFromAddr(a net.Addr) string {
 
 func (s *Server) beforeRequestHandler(p *proxy.Proxy, d *proxy.DNSContext) (bool, error) {
 	ip := ipFromAddr(d.Addr)
-	if s.access.IsBlockedIP(ip) {
-		log.Tracef("Client IP %s is blocked by settings", ip)
+	clientID := s.clientIDFromDNSContext(d)
+	if s.access.IsBlockedClient(ip, clientID) {
+		log.Tracef("Client %s (%s) is blocked by settings", ip, clientID)
+		return false, nil
+	}
+
+	s.RLock()
+	rl := s.ratelimit
+	st := s.stats
+	s.RUnlock()
+	if rl != nil && !rl.allow(net.ParseIP(ip), isStreamAddr(d.Addr), time.Now()) {
+		log.Tracef("Client %s is rate-limited", ip)
+		if st != nil {
+			st.UpdateRatelimited(net.ParseIP(ip))
+		}
+		if s.conf.RatelimitResponse == ratelimitServFail {
+			d.Res = s.genServerFailure(d.Req)
+			return true, nil // the response is sent without processing the request
+		}
 		return false, nil
 	}
 
@@ -524,6 +850,10 @@ type dnsContext struct {
 	protectionEnabled    bool         // filtering is enabled, dnsfilter object is ready
 	responseFromUpstream bool         // response is received from upstream servers
 	origReqDNSSEC        bool         // DNSSEC flag in the original request from user
+	dns64Synthesized     bool         // AAAA records are synthesized from A records (DNS64)
+
+	privateUpstreams []upstream.Upstream // internal DNS servers for a private zone (conditional forwarding)
+	clientID         string              // ClientID sent by the client via DNS-over-TLS or DNS-over-HTTPS
 }
 
 const (
@@ -545,6 +875,8 @@ func processInitial(ctx *dnsContext) int {
 		s.conf.OnDNSRequest(d)
 	}
 
+	ctx.clientID = s.clientIDFromDNSContext(d)
+
 	// disable Mozilla DoH
 	if (d.Req.Question[0].Qtype == dns.TypeA || d.Req.Question[0].Qtype == dns.TypeAAAA) &&
 		d.Req.Question[0].Name == "use-application-dns.net." {
@@ -569,9 +901,14 @@ func processFilteringBeforeRequest(ctx *dnsContext) int {
 	//  (to prevent from hanging while waiting for unresponsive DNS server to respond).
 
 	var err error
-	ctx.protectionEnabled = s.conf.ProtectionEnabled && s.dnsFilter != nil
-	if ctx.protectionEnabled {
-		ctx.setts = s.getClientRequestFilteringSettings(d)
+	// local responses and requests for private zones are never filtered
+	check := s.dnsFilter != nil && d.Res == nil && len(ctx.privateUpstreams) == 0
+	ctx.protectionEnabled = s.isProtectionEnabled() && check
+	if check {
+		if ctx.protectionEnabled {
+			ctx.setts = s.getClientRequestFilteringSettings(ctx)
+		}
+		// if filtering is disabled, only the hosts-file is used
 		ctx.result, err = s.filterDNSRequest(ctx)
 	}
 	s.RUnlock()
@@ -591,14 +928,41 @@ func processUpstream(ctx *dnsContext) int {
 		return resultDone // response is already set - nothing to do
 	}
 
-	if d.Addr != nil && s.conf.GetUpstreamsByClient != nil {
+	if d.Addr != nil && s.addressToUpstream != nil {
+		if s.conf.GetUpstreamAddrsByClient != nil {
+			clientIP := ipFromAddr(d.Addr)
+			upstreams := newClientUpstreams(s.conf.GetUpstreamAddrsByClient(clientIP, ctx.clientID), s.addressToUpstream)
+			if len(upstreams) > 0 {
+				log.Debug("Using custom upstreams for %s (%s)", clientIP, ctx.clientID)
+				d.Upstreams = upstreams
+			}
+		}
+	} else if d.Addr != nil && s.conf.GetUpstreamsByClient != nil {
 		clientIP := ipFromAddr(d.Addr)
-		upstreams := s.conf.GetUpstreamsByClient(clientIP)
+		upstreams := s.conf.GetUpstreamsByClient(clientIP, ctx.clientID)
 		if len(upstreams) > 0 {
-			log.Debug("Using custom upstreams for %s", clientIP)
+			log.Debug("Using custom upstreams for %s (%s)", clientIP, ctx.clientID)
 			d.Upstreams = upstreams
 		}
 	}
+	if len(ctx.privateUpstreams) != 0 {
+		d.Upstreams = ctx.privateUpstreams
+	} else if isPrivatePTR(d.Req.Question[0]) {
+		if len(s.localPTRUpstreams) == 0 {
+			log.Debug("DNS: no local resolvers for %s", d.Req.Question[0].Name)
+			d.Res = s.genNXDomain(d.Req)
+			return resultDone
+		}
+		d.Upstreams = s.localPTRUpstreams
+	}
+
+	// Don't pass the client's subnet to upstream servers if ECS is disabled:
+	//  the response would be cached and returned to all other clients.
+	if !s.ecsEnabled() {
+		if removeECS(d.Req) {
+			log.Debug("DNS: removed EDNS Client Subnet option from request")
+		}
+	}
 
 	if s.conf.EnableDNSSEC {
 		opt := d.Req.IsEdns0()
@@ -613,7 +977,7 @@ func processUpstream(ctx *dnsContext) int {
 	}
 
 	// request was not filtered so let it be processed further
-	err := s.dnsProxy.Resolve(d)
+	err := s.resolve(d)
 	if err != nil {
 		ctx.err = err
 		return resultError
@@ -623,6 +987,12 @@ func processUpstream(ctx *dnsContext) int {
 	return resultDone
 }
 
+// Return TRUE if EDNS Client Subnet option is sent to upstream servers
+// If ECS is stripped, no subnet is sent:  neither the one received from the client nor the client's own one.
+func (s *Server) ecsEnabled() bool {
+	return s.conf.EnableEDNSClientSubnet && !s.conf.StripEDNSClientSubnet
+}
+
 // Process DNSSEC after response from upstream server
 func processDNSSECAfterResponse(ctx *dnsContext) int {
 	d := ctx.proxyCtx
@@ -632,6 +1002,17 @@ func processDNSSECAfterResponse(ctx *dnsContext) int {
 		return resultDone
 	}
 
+	// Private zones and reverse zones of private IP addresses aren't delegated in the public DNS tree,
+	//  so the responses from their internal DNS servers can't be validated
+	if !d.Req.CheckingDisabled && len(ctx.privateUpstreams) == 0 && !isPrivatePTR(d.Req.Question[0]) {
+		err := ctx.srv.dnssec.validate(d.Res)
+		if err != nil {
+			log.Info("DNS: bogus response for %s: %s", d.Req.Question[0].Name, err)
+			d.Res = ctx.srv.genServerFailure(d.Req)
+			return resultDone
+		}
+	}
+
 	optResp := d.Res.IsEdns0()
 	if !ctx.origReqDNSSEC && optResp != nil && optResp.Do() {
 		return resultDone
@@ -675,7 +1056,9 @@ func processFilteringAfterResponse(ctx *dnsContext) int {
 	var err error
 
 	switch res.Reason {
-	case dnsfilter.ReasonRewrite:
+	case dnsfilter.ReasonRewrite,
+		dnsfilter.RewriteRule,
+		dnsfilter.FilteredSafeSearch:
 		if len(res.CanonName) == 0 {
 			break
 		}
@@ -688,6 +1071,9 @@ func processFilteringAfterResponse(ctx *dnsContext) int {
 			answer = append(answer, d.Res.Answer...) // host -> IP
 			d.Res.Answer = answer
 		}
+		if res.Reason == dnsfilter.FilteredSafeSearch {
+			addEDE(d.Req, d.Res, res)
+		}
 
 	case dnsfilter.NotFilteredWhiteList:
 		// nothing
@@ -727,6 +1113,13 @@ func processQueryLogsAndStats(ctx *dnsContext) int {
 		shouldLog = false
 	}
 
+	shouldCount := true
+	if d.Addr != nil && s.conf.GetLogSettingsByClient != nil {
+		ignoreQueryLog, ignoreStats := s.conf.GetLogSettingsByClient(ipFromAddr(d.Addr), ctx.clientID)
+		shouldLog = shouldLog && !ignoreQueryLog
+		shouldCount = !ignoreStats
+	}
+
 	s.RLock()
 	// Synchronize access to s.queryLog and s.stats so they won't be suddenly uninitialized while in use.
 	// This can happen after proxy server has been stopped, but its workers haven't yet exited.
@@ -745,15 +1138,22 @@ func processQueryLogsAndStats(ctx *dnsContext) int {
 		s.queryLog.Add(p)
 	}
 
-	s.updateStats(d, elapsed, *ctx.result)
+	if shouldCount {
+		s.updateStats(d, elapsed, *ctx.result)
+	}
 	s.RUnlock()
 
+	s.metrics.addQuery(msg, d.Res, *ctx.result)
+
 	return resultDone
 }
 
 // handleDNSRequest filters the incoming DNS requests and writes them to the query log
 // nolint (gocyclo)
 func (s *Server) handleDNSRequest(p *proxy.Proxy, d *proxy.DNSContext) error {
+	atomic.AddInt32(&s.activeRequests, 1)
+	defer atomic.AddInt32(&s.activeRequests, -1)
+
 	ctx := &dnsContext{srv: s, proxyCtx: d}
 	ctx.result = &dnsfilter.Result{}
 	ctx.startTime = time.Now()
@@ -761,9 +1161,14 @@ func (s *Server) handleDNSRequest(p *proxy.Proxy, d *proxy.DNSContext) error {
 	type modProcessFunc func(ctx *dnsContext) int
 	mods := []modProcessFunc{
 		processInitial,
+		processInternalHosts,
+		processInternalIPAddrs,
+		processLocalTXT,
+		processPrivateZone,
 		processFilteringBeforeRequest,
 		processUpstream,
 		processDNSSECAfterResponse,
+		processDNS64,
 		processFilteringAfterResponse,
 		processQueryLogsAndStats,
 	}
@@ -774,6 +1179,7 @@ func (s *Server) handleDNSRequest(p *proxy.Proxy, d *proxy.DNSContext) error {
 			// continue: call the next filter
 
 		case resultFinish:
+			s.finishResponse(d)
 			return nil
 
 		case resultError:
@@ -781,12 +1187,21 @@ func (s *Server) handleDNSRequest(p *proxy.Proxy, d *proxy.DNSContext) error {
 		}
 	}
 
-	if d.Res != nil {
-		d.Res.Compress = true // some devices require DNS message compression
-	}
+	s.finishResponse(d)
 	return nil
 }
 
+// Prepare the response for sending to the client
+func (s *Server) finishResponse(d *proxy.DNSContext) {
+	if d.Res == nil {
+		return
+	}
+	d.Res.Compress = true // some devices require DNS message compression
+	if s.conf.TLSEDNSPadding && isEncryptedProto(d.Proto) {
+		padResponse(d.Req, d.Res, paddingBlockSize)
+	}
+}
+
 // Get IP address from net.Addr
 func getIP(addr net.Addr) net.IP {
 	switch addr := addr.(type) {
@@ -814,6 +1229,8 @@ func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dns
 	}
 	e.Time = uint32(elapsed / 1000)
 	e.Result = stats.RNotFiltered
+	e.QType = dns.Type(d.Req.Question[0].Qtype).String()
+	e.Proto = d.Proto
 
 	switch res.Reason {
 
@@ -827,6 +1244,7 @@ func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dns
 		e.Result = stats.RSafeSearch
 
 	case dnsfilter.FilteredBlackList:
+		e.Filter = strconv.FormatInt(res.FilterID, 10)
 		fallthrough
 	case dnsfilter.FilteredInvalid:
 		fallthrough
@@ -838,13 +1256,13 @@ func (s *Server) updateStats(d *proxy.DNSContext, elapsed time.Duration, res dns
 }
 
 // getClientRequestFilteringSettings lookups client filtering settings
-// using the client's IP address from the DNSContext
-func (s *Server) getClientRequestFilteringSettings(d *proxy.DNSContext) *dnsfilter.RequestFilteringSettings {
+// using the client's IP address from the DNSContext and ClientID
+func (s *Server) getClientRequestFilteringSettings(ctx *dnsContext) *dnsfilter.RequestFilteringSettings {
 	setts := s.dnsFilter.GetConfig()
 	setts.FilteringEnabled = true
 	if s.conf.FilterHandler != nil {
-		clientAddr := ipFromAddr(d.Addr)
-		s.conf.FilterHandler(clientAddr, &setts)
+		clientAddr := ipFromAddr(ctx.proxyCtx.Addr)
+		s.conf.FilterHandler(clientAddr, ctx.clientID, &setts)
 	}
 	return &setts
 }
@@ -854,11 +1272,22 @@ func (s *Server) filterDNSRequest(ctx *dnsContext) (*dnsfilter.Result, error) {
 	d := ctx.proxyCtx
 	req := d.Req
 	host := strings.TrimSuffix(req.Question[0].Name, ".")
-	res, err := s.dnsFilter.CheckHost(host, d.Req.Question[0].Qtype, ctx.setts)
+	var res dnsfilter.Result
+	var err error
+	if ctx.protectionEnabled {
+		res, err = s.dnsFilter.CheckHost(host, d.Req.Question[0].Qtype, ctx.setts)
+	} else {
+		res = s.dnsFilter.CheckHostsFile(host, d.Req.Question[0].Qtype)
+	}
 	if err != nil {
 		// Return immediately if there's an error
 		return nil, errorx.Decorate(err, "dnsfilter failed to check host '%s'", host)
 
+	} else if res.Reason == dnsfilter.FilteredSafeSearch && len(res.CanonName) != 0 {
+		ctx.origQuestion = d.Req.Question[0]
+		// resolve the safe search host name, not the original host name
+		d.Req.Question[0].Name = dns.Fqdn(res.CanonName)
+
 	} else if res.IsFiltered {
 		// log.Tracef("Host %s is filtered, reason - '%s', matched rule: '%s'", host, res.Reason, res.Rule)
 		d.Res = s.genDNSFilterMessage(d, &res)
@@ -888,11 +1317,15 @@ func (s *Server) filterDNSRequest(ctx *dnsContext) (*dnsfilter.Result, error) {
 
 		d.Res = resp
 
-	} else if res.Reason == dnsfilter.ReasonRewrite && len(res.CanonName) != 0 {
+	} else if (res.Reason == dnsfilter.ReasonRewrite || res.Reason == dnsfilter.RewriteRule) &&
+		len(res.CanonName) != 0 {
 		ctx.origQuestion = d.Req.Question[0]
 		// resolve canonical name, not the original host name
 		d.Req.Question[0].Name = dns.Fqdn(res.CanonName)
 
+	} else if res.Reason == dnsfilter.RewriteRule {
+		d.Res = s.genDNSRewriteResponse(req, res.DNSRewrites)
+
 	} else if res.Reason == dnsfilter.RewriteEtcHosts && len(res.ReverseHost) != 0 {
 
 		resp := s.makeResponse(req)
@@ -929,6 +1362,13 @@ func (s *Server) filterDNSResponse(ctx *dnsContext) (*dnsfilter.Result, error) {
 
 		case *dns.AAAA:
 			host = v.AAAA.String()
+			if ctx.dns64Synthesized {
+				// check the original IPv4 address
+				ip4 := dns64Unmap(s.dns64Prefix, v.AAAA)
+				if ip4 != nil {
+					host = ip4.String()
+				}
+			}
 			log.Debug("DNSFwd: Checking record AAAA (%s) for %s", host, v.Hdr.Name)
 
 		default:
@@ -938,10 +1378,18 @@ func (s *Server) filterDNSResponse(ctx *dnsContext) (*dnsfilter.Result, error) {
 		s.RLock()
 		// Synchronize access to s.dnsFilter so it won't be suddenly uninitialized while in use.
 		// This could happen after proxy server has been stopped, but its workers are not yet exited.
-		if !s.conf.ProtectionEnabled || s.dnsFilter == nil {
+		if !s.isProtectionEnabled() || s.dnsFilter == nil {
 			s.RUnlock()
 			continue
 		}
+		ipRule := s.matchBlockedResponseIP(net.ParseIP(host))
+		if len(ipRule) != 0 {
+			s.RUnlock()
+			res := dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredBlackList, Rule: ipRule}
+			d.Res = s.genDNSFilterMessage(d, &res)
+			log.Debug("DNSFwd: Matched %s by response IP: %s (%s)", d.Req.Question[0].Name, host, ipRule)
+			return &res, nil
+		}
 		res, err := s.dnsFilter.CheckHostRules(host, d.Req.Question[0].Qtype, ctx.setts)
 		s.RUnlock()
 
@@ -968,7 +1416,15 @@ func (s *Server) makeResponse(req *dns.Msg) *dns.Msg {
 }
 
 // genDNSFilterMessage generates a DNS message corresponding to the filtering result
+// Extended DNS Error option is added to the response (see ede.go)
 func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *dnsfilter.Result) *dns.Msg {
+	resp := s.genFilteredResponse(d, result)
+	addEDE(d.Req, resp, result)
+	return resp
+}
+
+// genFilteredResponse generates a DNS message corresponding to the filtering result
+func (s *Server) genFilteredResponse(d *proxy.DNSContext, result *dnsfilter.Result) *dns.Msg {
 	m := d.Req
 
 	if m.Question[0].Qtype != dns.TypeA && m.Question[0].Qtype != dns.TypeAAAA {
@@ -1000,11 +1456,18 @@ func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *dnsfilter.Resu
 
 		} else if s.conf.BlockingMode == "custom_ip" {
 			// means that we should return custom IP for any blocked request
+			// if there's no custom IP for this address family, respond with an empty answer
 
 			switch m.Question[0].Qtype {
 			case dns.TypeA:
+				if s.conf.BlockingIPAddrv4 == nil {
+					return s.makeResponse(m)
+				}
 				return s.genARecord(m, s.conf.BlockingIPAddrv4)
 			case dns.TypeAAAA:
+				if s.conf.BlockingIPAddrv6 == nil {
+					return s.makeResponse(m)
+				}
 				return s.genAAAARecord(m, s.conf.BlockingIPAddrv6)
 			}
 
@@ -1012,6 +1475,11 @@ func (s *Server) genDNSFilterMessage(d *proxy.DNSContext, result *dnsfilter.Resu
 			// means that we should return NXDOMAIN for any blocked request
 
 			return s.genNXDomain(m)
+
+		} else if s.conf.BlockingMode == "refused" {
+			// means that we should return REFUSED for any blocked request
+
+			return s.genRefused(m)
 		}
 
 		// Default blocking mode
@@ -1031,6 +1499,13 @@ func (s *Server) genServerFailure(request *dns.Msg) *dns.Msg {
 	return &resp
 }
 
+func (s *Server) genRefused(request *dns.Msg) *dns.Msg {
+	resp := dns.Msg{}
+	resp.SetRcode(request, dns.RcodeRefused)
+	resp.RecursionAvailable = true
+	return &resp
+}
+
 func (s *Server) genARecord(request *dns.Msg, ip net.IP) *dns.Msg {
 	resp := s.makeResponse(request)
 	resp.Answer = append(resp.Answer, s.genAAnswer(request, ip))
@@ -1088,7 +1563,7 @@ func (s *Server) genBlockedHost(request *dns.Msg, newAddr string, d *proxy.DNSCo
 		return s.genResponseWithIP(request, ip)
 	}
 
-	// look up the hostname, TODO: cache
+	// look up the hostname (the response is cached)
 	replReq := dns.Msg{}
 	replReq.SetQuestion(dns.Fqdn(newAddr), request.Question[0].Qtype)
 	replReq.RecursionDesired = true
@@ -1100,7 +1575,7 @@ func (s *Server) genBlockedHost(request *dns.Msg, newAddr string, d *proxy.DNSCo
 		Req:       &replReq,
 	}
 
-	err := s.dnsProxy.Resolve(newContext)
+	err := s.resolve(newContext)
 	if err != nil {
 		log.Printf("Couldn't look up replacement host '%s': %s", newAddr, err)
 		return s.genServerFailure(request)
@@ -1130,6 +1605,40 @@ func (s *Server) genCNAMEAnswer(req *dns.Msg, cname string) *dns.CNAME {
 	return answer
 }
 
+// generate DNS response message with the response code and the records set by $dnsrewrite rules
+func (s *Server) genDNSRewriteResponse(req *dns.Msg, rewrites []dnsfilter.DNSRewrite) *dns.Msg {
+	resp := s.makeResponse(req)
+	q := req.Question[0]
+	for _, r := range rewrites {
+		if r.RCode != dns.RcodeSuccess {
+			resp.Rcode = r.RCode
+			resp.Answer = nil
+			return resp
+		}
+		if r.RRType != q.Qtype {
+			continue
+		}
+
+		hdr := dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: q.Qtype,
+			Ttl:    s.conf.BlockedResponseTTL,
+			Class:  dns.ClassINET,
+		}
+		switch q.Qtype {
+		case dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: net.ParseIP(r.Value).To4()})
+		case dns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(r.Value)})
+		case dns.TypeTXT:
+			resp.Answer = append(resp.Answer, &dns.TXT{Hdr: hdr, Txt: []string{r.Value}})
+		case dns.TypePTR:
+			resp.Answer = append(resp.Answer, &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(r.Value)})
+		}
+	}
+	return resp
+}
+
 func (s *Server) genNXDomain(request *dns.Msg) *dns.Msg {
 	resp := dns.Msg{}
 	resp.SetRcode(request, dns.RcodeNameError)
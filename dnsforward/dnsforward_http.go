@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/jsonutil"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/utils"
+	"github.com/ameshkov/dnsstamps"
 	"github.com/miekg/dns"
 )
 
@@ -22,19 +26,38 @@ func httpError(r *http.Request, w http.ResponseWriter, code int, format string,
 }
 
 type dnsConfigJSON struct {
-	Upstreams  []string `json:"upstream_dns"`
-	Bootstraps []string `json:"bootstrap_dns"`
-
-	ProtectionEnabled bool   `json:"protection_enabled"`
-	RateLimit         uint32 `json:"ratelimit"`
-	BlockingMode      string `json:"blocking_mode"`
-	BlockingIPv4      string `json:"blocking_ipv4"`
-	BlockingIPv6      string `json:"blocking_ipv6"`
-	EDNSCSEnabled     bool   `json:"edns_cs_enabled"`
-	DNSSECEnabled     bool   `json:"dnssec_enabled"`
-	DisableIPv6       bool   `json:"disable_ipv6"`
-	FastestAddr       bool   `json:"fastest_addr"`
-	ParallelRequests  bool   `json:"parallel_requests"`
+	Upstreams     []string `json:"upstream_dns"`
+	Bootstraps    []string `json:"bootstrap_dns"`
+	PrivateZones  []string `json:"private_zones"`
+	UpstreamBind  string   `json:"upstream_bind_address"`
+	UpstreamProxy string   `json:"upstream_proxy"`
+	UpstreamPins  []string `json:"upstream_pins"`
+
+	LocalPTRUpstreams        []string `json:"local_ptr_upstreams"`
+	DefaultLocalPTRUpstreams []string `json:"default_local_ptr_upstreams"` // read-only: the detected local resolvers
+
+	ProtectionEnabled  bool     `json:"protection_enabled"`
+	RateLimit          uint32   `json:"ratelimit"`
+	RateLimitTCP       uint32   `json:"ratelimit_tcp"`
+	RateLimitWhitelist []string `json:"ratelimit_whitelist"`
+	RateLimitResponse  string   `json:"ratelimit_response"`
+	BlockedResponseIPs []string `json:"blocked_response_ips"`
+	BlockingMode       string   `json:"blocking_mode"`
+	BlockingIPv4       string   `json:"blocking_ipv4"`
+	BlockingIPv6       string   `json:"blocking_ipv6"`
+	EDNSCSEnabled      bool     `json:"edns_cs_enabled"`
+	EDNSCSIP           string   `json:"edns_cs_ip"`
+	EDNSCSStrip        bool     `json:"edns_cs_strip"`
+	DNSSECEnabled      bool     `json:"dnssec_enabled"`
+	DisableIPv6        bool     `json:"disable_ipv6"`
+	FastestAddr        bool     `json:"fastest_addr"`
+	FastestUpstream    bool     `json:"fastest_upstream"`
+	UpstreamCheck      uint32   `json:"upstream_check_interval"` // seconds
+	ParallelRequests   bool     `json:"parallel_requests"`
+	CacheSize          uint32   `json:"cache_size"`
+	CacheMinTTL        uint32   `json:"cache_ttl_min"`
+	CacheMaxTTL        uint32   `json:"cache_ttl_max"`
+	LocalDomainName    string   `json:"local_domain_name"`
 }
 
 func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
@@ -42,17 +65,35 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	s.RLock()
 	resp.Upstreams = stringArrayDup(s.conf.UpstreamDNS)
 	resp.Bootstraps = stringArrayDup(s.conf.BootstrapDNS)
+	resp.PrivateZones = stringArrayDup(s.conf.PrivateZones)
+	resp.UpstreamBind = s.conf.UpstreamBindAddress
+	resp.UpstreamProxy = redactProxyURL(s.conf.UpstreamProxy)
+	resp.UpstreamPins = stringArrayDup(s.conf.UpstreamPins)
+	resp.LocalPTRUpstreams = stringArrayDup(s.conf.LocalPTRUpstreams)
+	resp.DefaultLocalPTRUpstreams = stringArrayDup(s.localResolvers)
 
 	resp.ProtectionEnabled = s.conf.ProtectionEnabled
 	resp.BlockingMode = s.conf.BlockingMode
 	resp.BlockingIPv4 = s.conf.BlockingIPv4
 	resp.BlockingIPv6 = s.conf.BlockingIPv6
 	resp.RateLimit = s.conf.Ratelimit
+	resp.RateLimitTCP = s.conf.RatelimitTCP
+	resp.RateLimitWhitelist = stringArrayDup(s.conf.RatelimitWhitelist)
+	resp.RateLimitResponse = s.conf.RatelimitResponse
+	resp.BlockedResponseIPs = stringArrayDup(s.conf.BlockedResponseIPs)
 	resp.EDNSCSEnabled = s.conf.EnableEDNSClientSubnet
+	resp.EDNSCSIP = s.conf.EDNSClientSubnetIP
+	resp.EDNSCSStrip = s.conf.StripEDNSClientSubnet
 	resp.DNSSECEnabled = s.conf.EnableDNSSEC
 	resp.DisableIPv6 = s.conf.AAAADisabled
 	resp.FastestAddr = s.conf.FastestAddrAlgo
+	resp.FastestUpstream = s.conf.FastestUpstream
+	resp.UpstreamCheck = s.conf.UpstreamCheckInterval
 	resp.ParallelRequests = s.conf.AllServers
+	resp.CacheSize = s.conf.CacheSize
+	resp.CacheMinTTL = s.conf.CacheMinTTL
+	resp.CacheMaxTTL = s.conf.CacheMaxTTL
+	resp.LocalDomainName = s.conf.LocalDomainName
 	s.RUnlock()
 
 	js, err := json.Marshal(resp)
@@ -66,18 +107,13 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 
 func checkBlockingMode(req dnsConfigJSON) bool {
 	bm := req.BlockingMode
-	if !(bm == "default" || bm == "nxdomain" || bm == "null_ip" || bm == "custom_ip") {
+	if !(bm == "default" || bm == "nxdomain" || bm == "refused" || bm == "null_ip" || bm == "custom_ip") {
 		return false
 	}
 
 	if bm == "custom_ip" {
-		ip := net.ParseIP(req.BlockingIPv4)
-		if ip == nil || ip.To4() == nil {
-			return false
-		}
-
-		ip = net.ParseIP(req.BlockingIPv6)
-		if ip == nil {
+		_, _, err := parseBlockingIPs(req.BlockingIPv4, req.BlockingIPv6)
+		if err != nil {
 			return false
 		}
 	}
@@ -85,6 +121,22 @@ func checkBlockingMode(req dnsConfigJSON) bool {
 	return true
 }
 
+// Return FALSE if the minimum TTL is greater than the maximum one
+func (s *Server) checkCacheTTL(js *jsonutil.JSON, req dnsConfigJSON) bool {
+	s.RLock()
+	minTTL := s.conf.CacheMinTTL
+	maxTTL := s.conf.CacheMaxTTL
+	s.RUnlock()
+
+	if js.Exists("cache_ttl_min") {
+		minTTL = req.CacheMinTTL
+	}
+	if js.Exists("cache_ttl_max") {
+		maxTTL = req.CacheMaxTTL
+	}
+	return maxTTL == 0 || minTTL <= maxTTL
+}
+
 // nolint(gocyclo) - we need to check each JSON field separately
 func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 	req := dnsConfigJSON{}
@@ -113,11 +165,104 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if js.Exists("private_zones") {
+		err = validatePrivateZones(req.PrivateZones)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "wrong private zones specification: %s", err)
+			return
+		}
+	}
+
+	if js.Exists("upstream_pins") {
+		err = ValidateUpstreamPins(req.UpstreamPins)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	if js.Exists("local_ptr_upstreams") {
+		err = ValidateLocalPTRUpstreams(req.LocalPTRUpstreams)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	if js.Exists("upstream_proxy") {
+		s.RLock()
+		req.UpstreamProxy = restoreProxyPassword(req.UpstreamProxy, s.conf.UpstreamProxy)
+		s.RUnlock()
+	}
+
+	if js.Exists("upstream_bind_address") || js.Exists("upstream_proxy") ||
+		js.Exists("upstream_dns") || js.Exists("private_zones") || js.Exists("local_ptr_upstreams") {
+		s.RLock()
+		bind, proxyURL := s.conf.UpstreamBindAddress, s.conf.UpstreamProxy
+		ups, zones, ptr := s.conf.UpstreamDNS, s.conf.PrivateZones, s.conf.LocalPTRUpstreams
+		s.RUnlock()
+		if js.Exists("upstream_bind_address") {
+			bind = req.UpstreamBind
+		}
+		if js.Exists("upstream_proxy") {
+			proxyURL = req.UpstreamProxy
+		}
+		if js.Exists("upstream_dns") {
+			ups = req.Upstreams
+		}
+		if js.Exists("private_zones") {
+			zones = req.PrivateZones
+		}
+		if js.Exists("local_ptr_upstreams") {
+			ptr = req.LocalPTRUpstreams
+		}
+		// the local PTR upstream servers are bound too
+		err = ValidateUpstreamBind(bind, ups, append(stringArrayDup(zones), ptr...))
+		if err == nil {
+			err = ValidateUpstreamProxy(proxyURL, bind)
+		}
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
 	if js.Exists("blocking_mode") && !checkBlockingMode(req) {
 		httpError(r, w, http.StatusBadRequest, "blocking_mode: incorrect value")
 		return
 	}
 
+	if !s.checkCacheTTL(js, req) {
+		httpError(r, w, http.StatusBadRequest, "cache_ttl_min must be less or equal than cache_ttl_max")
+		return
+	}
+
+	if js.Exists("edns_cs_ip") && len(req.EDNSCSIP) != 0 && net.ParseIP(req.EDNSCSIP) == nil {
+		httpError(r, w, http.StatusBadRequest, "edns_cs_ip: incorrect value")
+		return
+	}
+
+	if js.Exists("ratelimit_whitelist") || js.Exists("ratelimit_response") {
+		err = ValidateRatelimitSettings(req.RateLimitWhitelist, req.RateLimitResponse)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	if js.Exists("blocked_response_ips") {
+		err = ValidateBlockedResponseIPs(req.BlockedResponseIPs)
+		if err != nil {
+			httpError(r, w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	if js.Exists("local_domain_name") && utils.IsValidHostname(req.LocalDomainName) != nil {
+		httpError(r, w, http.StatusBadRequest, "local_domain_name: incorrect value")
+		return
+	}
+
 	restart := false
 	s.Lock()
 
@@ -131,29 +276,72 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		restart = true
 	}
 
+	if js.Exists("private_zones") {
+		s.conf.PrivateZones = req.PrivateZones
+		restart = true
+	}
+
+	if js.Exists("upstream_bind_address") {
+		s.conf.UpstreamBindAddress = req.UpstreamBind
+		restart = true
+	}
+
+	if js.Exists("upstream_proxy") {
+		s.conf.UpstreamProxy = req.UpstreamProxy
+		restart = true
+	}
+
+	if js.Exists("upstream_pins") {
+		s.conf.UpstreamPins = req.UpstreamPins
+		restart = true
+	}
+
+	if js.Exists("local_ptr_upstreams") {
+		s.conf.LocalPTRUpstreams = req.LocalPTRUpstreams
+		restart = true
+	}
+
 	if js.Exists("protection_enabled") {
 		s.conf.ProtectionEnabled = req.ProtectionEnabled
+		s.protectionDisabledUntil = time.Time{}
 	}
 
 	if js.Exists("blocking_mode") {
 		s.conf.BlockingMode = req.BlockingMode
 		if req.BlockingMode == "custom_ip" {
-			if js.Exists("blocking_ipv4") {
-				s.conf.BlockingIPv4 = req.BlockingIPv4
-				s.conf.BlockingIPAddrv4 = net.ParseIP(req.BlockingIPv4)
-			}
-			if js.Exists("blocking_ipv6") {
-				s.conf.BlockingIPv6 = req.BlockingIPv6
-				s.conf.BlockingIPAddrv6 = net.ParseIP(req.BlockingIPv6)
-			}
+			s.conf.BlockingIPv4 = req.BlockingIPv4
+			s.conf.BlockingIPv6 = req.BlockingIPv6
+			s.conf.BlockingIPAddrv4, s.conf.BlockingIPAddrv6, _ = parseBlockingIPs(req.BlockingIPv4, req.BlockingIPv6)
 		}
 	}
 
+	ratelimitChanged := false
 	if js.Exists("ratelimit") {
-		if s.conf.Ratelimit != req.RateLimit {
-			restart = true
-		}
 		s.conf.Ratelimit = req.RateLimit
+		ratelimitChanged = true
+	}
+
+	if js.Exists("ratelimit_tcp") {
+		s.conf.RatelimitTCP = req.RateLimitTCP
+		ratelimitChanged = true
+	}
+
+	if js.Exists("ratelimit_whitelist") {
+		s.conf.RatelimitWhitelist = req.RateLimitWhitelist
+		ratelimitChanged = true
+	}
+
+	if js.Exists("ratelimit_response") {
+		s.conf.RatelimitResponse = req.RateLimitResponse
+	}
+
+	if ratelimitChanged {
+		s.ratelimit, _ = newRateLimiter(&s.conf.FilteringConfig) // the whitelist is already checked
+	}
+
+	if js.Exists("blocked_response_ips") {
+		s.conf.BlockedResponseIPs = req.BlockedResponseIPs
+		s.blockedResponseIPs, _ = parseIPNets(req.BlockedResponseIPs) // the list is already checked
 	}
 
 	if js.Exists("edns_cs_enabled") {
@@ -161,6 +349,16 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		restart = true
 	}
 
+	if js.Exists("edns_cs_ip") {
+		s.conf.EDNSClientSubnetIP = req.EDNSCSIP
+		restart = true
+	}
+
+	if js.Exists("edns_cs_strip") {
+		s.conf.StripEDNSClientSubnet = req.EDNSCSStrip
+		restart = true
+	}
+
 	if js.Exists("dnssec_enabled") {
 		s.conf.EnableDNSSEC = req.DNSSECEnabled
 	}
@@ -173,10 +371,43 @@ func (s *Server) handleSetConfig(w http.ResponseWriter, r *http.Request) {
 		s.conf.FastestAddrAlgo = req.FastestAddr
 	}
 
+	if js.Exists("fastest_upstream") {
+		if s.conf.FastestUpstream != req.FastestUpstream {
+			restart = true // start or stop probing upstream servers
+		}
+		s.conf.FastestUpstream = req.FastestUpstream
+	}
+
+	if js.Exists("upstream_check_interval") {
+		if s.conf.UpstreamCheckInterval != req.UpstreamCheck {
+			restart = true // start or stop probing upstream servers
+		}
+		s.conf.UpstreamCheckInterval = req.UpstreamCheck
+	}
+
 	if js.Exists("parallel_requests") {
 		s.conf.AllServers = req.ParallelRequests
 	}
 
+	if js.Exists("cache_size") {
+		s.conf.CacheSize = req.CacheSize
+		restart = true
+	}
+
+	if js.Exists("cache_ttl_min") {
+		s.conf.CacheMinTTL = req.CacheMinTTL
+		restart = true
+	}
+
+	if js.Exists("cache_ttl_max") {
+		s.conf.CacheMaxTTL = req.CacheMaxTTL
+		restart = true
+	}
+
+	if js.Exists("local_domain_name") {
+		s.conf.LocalDomainName = req.LocalDomainName
+	}
+
 	s.Unlock()
 	s.conf.ConfigModified()
 
@@ -234,6 +465,12 @@ func validateUpstream(u string) (bool, error) {
 	// Check if the upstream has a valid protocol prefix
 	for _, proto := range protocols {
 		if strings.HasPrefix(u, proto) {
+			if proto == "https://" {
+				return defaultUpstream, checkDOHUpstream(u)
+			}
+			if proto == "sdns://" {
+				return defaultUpstream, checkDNSStamp(u)
+			}
 			return defaultUpstream, nil
 		}
 	}
@@ -272,6 +509,96 @@ func separateUpstream(upstream string) (string, bool, error) {
 	return upstream, defaultUpstream, nil
 }
 
+// checkDOHUpstream checks if DNS-over-HTTPS upstream URL is valid
+// The host name is resolved later using bootstrap DNS servers
+func checkDOHUpstream(upstream string) error {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if len(host) == 0 {
+		return fmt.Errorf("%s: no host name", upstream)
+	}
+	if net.ParseIP(host) == nil {
+		err = utils.IsValidHostname(host)
+		if err != nil {
+			return fmt.Errorf("%s: %s", upstream, err)
+		}
+	}
+
+	port := u.Port()
+	if len(port) != 0 {
+		_, err = strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return fmt.Errorf("%s is not a valid port: %s", port, err)
+		}
+	}
+
+	return nil
+}
+
+// checkDNSStamp checks if DNS stamp (sdns://) is valid and describes a supported server:
+//
+//	plain DNS, DNSCrypt, DNS-over-HTTPS or DNS-over-TLS
+//
+// The server is created from the stamp later by dnsproxy
+func checkDNSStamp(upstream string) error {
+	stamp, err := dnsstamps.NewServerStampFromString(upstream)
+	if err != nil {
+		return fmt.Errorf("invalid DNS stamp: %s", err)
+	}
+
+	switch stamp.Proto {
+	case dnsstamps.StampProtoTypePlain:
+		if len(stamp.ServerAddrStr) == 0 {
+			return fmt.Errorf("invalid DNS stamp: no server address")
+		}
+
+	case dnsstamps.StampProtoTypeDNSCrypt:
+		if len(stamp.ServerAddrStr) == 0 {
+			return fmt.Errorf("invalid DNS stamp: no server address")
+		}
+		if len(stamp.ServerPk) != 32 {
+			return fmt.Errorf("invalid DNS stamp: DNSCrypt server public key must be 32 bytes long")
+		}
+		if len(stamp.ProviderName) == 0 {
+			return fmt.Errorf("invalid DNS stamp: no DNSCrypt provider name")
+		}
+
+	case dnsstamps.StampProtoTypeDoH, dnsstamps.StampProtoTypeTLS:
+		if len(stamp.ProviderName) == 0 {
+			return fmt.Errorf("invalid DNS stamp: no host name")
+		}
+		host, _, err := net.SplitHostPort(stamp.ProviderName)
+		if err != nil {
+			host = stamp.ProviderName
+		}
+		if net.ParseIP(host) == nil {
+			err = utils.IsValidHostname(host)
+			if err != nil {
+				return fmt.Errorf("invalid DNS stamp: %s", err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("invalid DNS stamp: unsupported protocol")
+	}
+
+	if len(stamp.ServerAddrStr) != 0 {
+		host, _, err := net.SplitHostPort(stamp.ServerAddrStr)
+		if err != nil {
+			host = stamp.ServerAddrStr
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid DNS stamp: server address %s is not an IP address", stamp.ServerAddrStr)
+		}
+	}
+
+	return nil
+}
+
 // checkPlainDNS checks if host is plain DNS
 func checkPlainDNS(upstream string) error {
 	// Check if host is ip without port
@@ -297,7 +624,45 @@ func checkPlainDNS(upstream string) error {
 	return nil
 }
 
-func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
+type upstreamTestJSON struct {
+	Address string  `json:"address"`
+	OK      bool    `json:"ok"`
+	RTT     float64 `json:"rtt_ms,omitempty"` // the request time (msec)
+	Error   string  `json:"error,omitempty"`
+}
+
+type upstreamsTestJSON struct {
+	Upstreams []upstreamTestJSON `json:"upstreams"` // in the same order as in the request
+}
+
+// Check all upstream servers in parallel
+func testUpstreams(upstreams []string, bootstrap []string) upstreamsTestJSON {
+	result := upstreamsTestJSON{
+		Upstreams: make([]upstreamTestJSON, len(upstreams)),
+	}
+	wg := sync.WaitGroup{}
+	for i, host := range upstreams {
+		wg.Add(1)
+		go func(res *upstreamTestJSON, host string) {
+			defer wg.Done()
+			res.Address = host
+			elapsed, err := checkDNS(host, bootstrap)
+			if err != nil {
+				log.Info("%v", err)
+				res.Error = err.Error()
+				return
+			}
+			res.OK = true
+			res.RTT = float64(elapsed) / float64(time.Millisecond)
+		}(&result.Upstreams[i], host)
+	}
+	wg.Wait()
+	return result
+}
+
+// HandleTestUpstreamDNS - check that upstream servers work before the configuration is saved
+// It doesn't depend on the running DNS server, so it's also used by the setup wizard
+func HandleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 	req := upstreamJSON{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -310,17 +675,7 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := map[string]string{}
-
-	for _, host := range req.Upstreams {
-		err = checkDNS(host, req.BootstrapDNS)
-		if err != nil {
-			log.Info("%v", err)
-			result[host] = err.Error()
-		} else {
-			result[host] = "OK"
-		}
-	}
+	result := testUpstreams(req.Upstreams, req.BootstrapDNS)
 
 	jsonVal, err := json.Marshal(result)
 	if err != nil {
@@ -336,20 +691,22 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func checkDNS(input string, bootstrap []string) error {
+// Resolve a known host name via the upstream server and check the answer
+// Return the request time
+func checkDNS(input string, bootstrap []string) (time.Duration, error) {
 	// separate upstream from domains list
 	input, defaultUpstream, err := separateUpstream(input)
 	if err != nil {
-		return fmt.Errorf("wrong upstream format: %s", err)
+		return 0, fmt.Errorf("wrong upstream format: %s", err)
 	}
 
 	// No need to check this entrance
 	if input == "#" && !defaultUpstream {
-		return nil
+		return 0, nil
 	}
 
 	if _, err := validateUpstream(input); err != nil {
-		return fmt.Errorf("wrong upstream format: %s", err)
+		return 0, fmt.Errorf("wrong upstream format: %s", err)
 	}
 
 	if len(bootstrap) == 0 {
@@ -359,7 +716,7 @@ func checkDNS(input string, bootstrap []string) error {
 	log.Debug("Checking if DNS %s works...", input)
 	u, err := upstream.AddressToUpstream(input, upstream.Options{Bootstrap: bootstrap, Timeout: DefaultTimeout})
 	if err != nil {
-		return fmt.Errorf("failed to choose upstream for %s: %s", input, err)
+		return 0, fmt.Errorf("failed to choose upstream for %s: %s", input, err)
 	}
 
 	req := dns.Msg{}
@@ -368,29 +725,137 @@ func checkDNS(input string, bootstrap []string) error {
 	req.Question = []dns.Question{
 		{Name: "google-public-dns-a.google.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
 	}
+	start := time.Now()
 	reply, err := u.Exchange(&req)
+	elapsed := time.Since(start)
 	if err != nil {
-		return fmt.Errorf("couldn't communicate with DNS server %s: %s", input, err)
+		return 0, fmt.Errorf("couldn't communicate with DNS server %s: %s", input, err)
 	}
 	if len(reply.Answer) != 1 {
-		return fmt.Errorf("DNS server %s returned wrong answer", input)
+		return 0, fmt.Errorf("DNS server %s returned wrong answer", input)
 	}
 	if t, ok := reply.Answer[0].(*dns.A); ok {
 		if !net.IPv4(8, 8, 8, 8).Equal(t.A) {
-			return fmt.Errorf("DNS server %s returned wrong answer: %v", input, t.A)
+			return 0, fmt.Errorf("DNS server %s returned wrong answer: %v", input, t.A)
 		}
 	}
 
 	log.Debug("DNS %s works OK", input)
-	return nil
+	return elapsed, nil
+}
+
+type upstreamStatusJSON struct {
+	Address   string  `json:"address"`
+	RTT       float64 `json:"rtt_ms"` // average round-trip time (msec)
+	Requests  uint64  `json:"requests"`
+	Errors    uint64  `json:"errors"`
+	LastError string  `json:"last_error,omitempty"`
+	Preferred bool    `json:"preferred"`            // the upstream server is used for the requests
+	Down      bool    `json:"down"`                 // the upstream server has failed and it isn't used for the requests
+	DownSince string  `json:"down_since,omitempty"` // RFC3339
+
+	TLS *tlsConnStatsJSON `json:"tls_connections,omitempty"` // DNS-over-TLS connection pool
+}
+
+type tlsConnStatsJSON struct {
+	Active      int    `json:"active"` // open connections (idle and in use)
+	Idle        int    `json:"idle"`
+	Handshakes  uint64 `json:"handshakes"`  // full TLS handshakes
+	Resumptions uint64 `json:"resumptions"` // resumed TLS sessions
+	Errors      uint64 `json:"connect_errors"`
+}
+
+type upstreamsStatusJSON struct {
+	FastestUpstream bool                 `json:"fastest_upstream"`        // the requests are sent to the fastest upstream server first
+	CheckInterval   uint32               `json:"upstream_check_interval"` // seconds;  0: health checks are disabled
+	Upstreams       []upstreamStatusJSON `json:"upstreams"`
+}
+
+// Get round-trip time statistics of the upstream servers
+func (s *Server) handleUpstreamsStatus(w http.ResponseWriter, r *http.Request) {
+	resp := upstreamsStatusJSON{
+		Upstreams: []upstreamStatusJSON{},
+	}
+
+	s.RLock()
+	resp.FastestUpstream = s.useFastestUpstream()
+	resp.CheckInterval = s.conf.UpstreamCheckInterval
+	ups := s.allUpstreams()
+	var preferred string
+	if s.upstreamRTT != nil {
+		ups = s.upstreamRTT.sort(ups)
+		sorted := s.conf.Upstreams
+		if s.conf.UpstreamCheckInterval != 0 {
+			sorted = s.upstreamRTT.alive(sorted)
+		}
+		sorted = s.upstreamRTT.sort(sorted)
+		if resp.FastestUpstream && len(sorted) != 0 {
+			preferred = sorted[0].Address()
+		}
+	}
+	for _, u := range ups {
+		us := upstreamStatusJSON{
+			Address:   u.Address(),
+			Preferred: u.Address() == preferred,
+		}
+		if s.upstreamRTT != nil {
+			st, ok := s.upstreamRTT.get(u.Address())
+			if ok {
+				us.RTT = float64(st.rtt) / float64(time.Millisecond)
+				us.Requests = st.requests
+				us.Errors = st.errors
+				us.LastError = st.lastErr
+				us.Down = st.down
+				if st.down {
+					us.DownSince = st.downSince.Format(time.RFC3339)
+				}
+			}
+		}
+		if tu, ok := u.(*tlsUpstream); ok {
+			st := tu.getStats()
+			us.TLS = &tlsConnStatsJSON{
+				Active:      st.active,
+				Idle:        st.idle,
+				Handshakes:  st.handshakes,
+				Resumptions: st.resumptions,
+				Errors:      st.errors,
+			}
+		}
+		resp.Upstreams = append(resp.Upstreams, us)
+	}
+	s.RUnlock()
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "json.Marshal: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
 }
 
 func (s *Server) handleDOH(w http.ResponseWriter, r *http.Request) {
+	if s.conf.TLSDisableDOH {
+		httpError(r, w, http.StatusNotFound, "Not Found")
+		return
+	}
+
 	if !s.conf.TLSAllowUnencryptedDOH && r.TLS == nil {
 		httpError(r, w, http.StatusNotFound, "Not Found")
 		return
 	}
 
+	// the certificate has been verified by HTTPS server if it's present
+	if s.conf.TLSClientCAs != nil && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		httpError(r, w, http.StatusForbidden, "Client certificate is required")
+		return
+	}
+
+	if _, ok := clientIDFromPath(r.URL.Path); !ok {
+		httpError(r, w, http.StatusBadRequest, "invalid ClientID in URL path")
+		return
+	}
+
 	if !s.IsRunning() {
 		httpError(r, w, http.StatusInternalServerError, "DNS server is not running")
 		return
@@ -402,10 +867,13 @@ func (s *Server) handleDOH(w http.ResponseWriter, r *http.Request) {
 func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister("GET", "/control/dns_info", s.handleGetConfig)
 	s.conf.HTTPRegister("POST", "/control/dns_config", s.handleSetConfig)
-	s.conf.HTTPRegister("POST", "/control/test_upstream_dns", s.handleTestUpstreamDNS)
+	s.conf.HTTPRegister("POST", "/control/test_upstream_dns", HandleTestUpstreamDNS)
+	s.conf.HTTPRegister("GET", "/control/upstreams_status", s.handleUpstreamsStatus)
+	s.conf.HTTPRegister("POST", "/control/protection", s.handleProtection)
 
 	s.conf.HTTPRegister("GET", "/control/access/list", s.handleAccessList)
 	s.conf.HTTPRegister("POST", "/control/access/set", s.handleAccessSet)
 
 	s.conf.HTTPRegister("", "/dns-query", s.handleDOH)
+	s.conf.HTTPRegister("", dohClientIDPrefix, s.handleDOH)
 }
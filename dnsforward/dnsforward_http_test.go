@@ -0,0 +1,141 @@
+package dnsforward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDOHClientCert(t *testing.T) {
+	s := &Server{}
+	s.conf.TLSClientCAs = x509.NewCertPool()
+
+	// no client certificate
+	r := httptest.NewRequest("GET", "/dns-query", nil)
+	r.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	s.handleDOH(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// the certificate has been verified by HTTPS server:  the request is passed further
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{&x509.Certificate{}}}
+	w = httptest.NewRecorder()
+	s.handleDOH(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// plain HTTP (e.g. from a reverse proxy)
+	s.conf.TLSAllowUnencryptedDOH = true
+	r.TLS = nil
+	w = httptest.NewRecorder()
+	s.handleDOH(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestTestUpstreamDNS(t *testing.T) {
+	// the test DNS server responds with the expected answer
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+			A:   net.IP{8, 8, 8, 8},
+		})
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	body := `{"upstream_dns":["` + conn.LocalAddr().String() + `","invalid://1.2.3.4"],"bootstrap_dns":[]}`
+	r := httptest.NewRequest("POST", "/control/test_upstream_dns", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	HandleTestUpstreamDNS(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp := upstreamsTestJSON{}
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(resp.Upstreams))
+	assert.Equal(t, conn.LocalAddr().String(), resp.Upstreams[0].Address)
+	assert.True(t, resp.Upstreams[0].OK)
+	assert.Equal(t, "", resp.Upstreams[0].Error)
+	assert.Equal(t, "invalid://1.2.3.4", resp.Upstreams[1].Address)
+	assert.False(t, resp.Upstreams[1].OK)
+	assert.NotEqual(t, "", resp.Upstreams[1].Error)
+
+	r = httptest.NewRequest("POST", "/control/test_upstream_dns", strings.NewReader(`{"upstream_dns":[]}`))
+	w = httptest.NewRecorder()
+	HandleTestUpstreamDNS(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetConfigCacheTTL(t *testing.T) {
+	// the test DNS server responds with a short TTL for "short.example." and with a long TTL for others
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		ttl := uint32(86400)
+		if req.Question[0].Name == "short.example." {
+			ttl = 10
+		}
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.IP{8, 8, 8, 8},
+		})
+		resp.Ns = append(resp.Ns, &dns.NS{
+			Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  "ns.example.",
+		})
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	s := createTestServer(t)
+	s.conf.ProtectionEnabled = false // Safe Browsing requests to the external servers would slow down the responses
+	s.conf.UpstreamDNS = []string{conn.LocalAddr().String()}
+	s.conf.ConfigModified = func() {}
+	err = s.Start()
+	assert.Nil(t, err)
+
+	body := `{"cache_ttl_min":600,"cache_ttl_max":3600}`
+	r := httptest.NewRequest("POST", "/control/dns_config", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.handleSetConfig(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint32(600), s.conf.CacheMinTTL)
+	assert.Equal(t, uint32(3600), s.conf.CacheMaxTTL)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// both the response from upstream server and the cached response
+	for i := 0; i != 2; i++ {
+		req := &dns.Msg{}
+		req.SetQuestion("short.example.", dns.TypeA)
+		reply, err := dns.Exchange(req, addr.String())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(reply.Answer))
+		ttl := reply.Answer[0].Header().Ttl
+		assert.True(t, ttl > 590 && ttl <= 600, "TTL: %d", ttl)
+
+		req.SetQuestion("long.example.", dns.TypeA)
+		reply, err = dns.Exchange(req, addr.String())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(reply.Answer))
+		ttl = reply.Answer[0].Header().Ttl
+		assert.True(t, ttl > 3590 && ttl <= 3600, "TTL: %d", ttl)
+	}
+
+	_ = s.Stop()
+}
@@ -12,12 +12,16 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/ameshkov/dnsstamps"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
@@ -127,6 +131,58 @@ func TestDotServer(t *testing.T) {
 	}
 }
 
+func TestDNSCryptServer(t *testing.T) {
+	s := createTestServer(t)
+	rc, err := dnscrypt.GenerateResolverConfig("example.org", nil)
+	assert.Nil(t, err)
+	s.conf.DNSCryptConfig = DNSCryptConfig{
+		Enabled:        true,
+		UDPListenAddr:  &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 0},
+		TCPListenAddr:  &net.TCPAddr{IP: net.IP{127, 0, 0, 1}, Port: 0},
+		ResolverConfig: rc,
+	}
+	// DNSCrypt server listens on the additional addresses too
+	s.conf.ExtraListenIPs = []net.IP{{127, 0, 0, 1}}
+	assert.Nil(t, s.Prepare(nil))
+	err = s.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %s", err)
+	}
+	assert.Equal(t, 2, len(s.dnsCrypt.udpConns))
+	assert.Equal(t, 2, len(s.dnsCrypt.tcpListens))
+
+	addrs := []string{
+		s.dnsCrypt.udpConns[0].LocalAddr().String(),
+		s.dnsCrypt.udpConns[1].LocalAddr().String(),
+		s.dnsCrypt.tcpListens[0].Addr().String(),
+		s.dnsCrypt.tcpListens[1].Addr().String(),
+	}
+	for i, addr := range addrs {
+		stamp, err := rc.CreateStamp(addr)
+		assert.Nil(t, err)
+		client := dnscrypt.Client{Net: "udp", Timeout: time.Second}
+		if i >= 2 {
+			client.Net = "tcp"
+		}
+		ri, err := client.DialStamp(stamp)
+		if err != nil {
+			t.Fatalf("Couldn't get DNSCrypt certificate from %s: %s", addr, err)
+		}
+
+		// the response is generated from a filtering rule
+		reply, err := client.Exchange(createTestMessage("host.example.org."), ri)
+		if err != nil {
+			t.Fatalf("Couldn't talk to DNSCrypt server at %s: %s", addr, err)
+		}
+		assertResponse(t, reply, "127.0.0.1")
+	}
+
+	err = s.Stop()
+	if err != nil {
+		t.Fatalf("DNS server failed to stop: %s", err)
+	}
+}
+
 func TestServerRace(t *testing.T) {
 	s := createTestServer(t)
 	err := s.Start()
@@ -152,7 +208,10 @@ func TestServerRace(t *testing.T) {
 
 func TestSafeSearch(t *testing.T) {
 	s := createTestServer(t)
-	err := s.Start()
+	u := &testUpstream{nil, map[string][]net.IP{
+		"forcesafesearch.google.com.": {{216, 239, 38, 120}},
+	}, nil}
+	err := s.startWithUpstream(u)
 	if err != nil {
 		t.Fatalf("Failed to start server: %s", err)
 	}
@@ -165,24 +224,20 @@ func TestSafeSearch(t *testing.T) {
 		exchangeAndAssertResponse(t, &client, addr, host, "213.180.193.56")
 	}
 
-	// Let's lookup for google safesearch ip
-	ips, err := net.LookupIP("forcesafesearch.google.com")
-	if err != nil {
-		t.Fatalf("Failed to lookup for forcesafesearch.google.com: %s", err)
-	}
-
-	ip := ips[0]
-	for _, i := range ips {
-		if i.To4() != nil {
-			ip = i
-			break
-		}
-	}
-
-	// Test safe search for google.
+	// Test safe search for google: the response contains CNAME to the safe search host
 	googleDomains := []string{"www.google.com.", "www.google.com.af.", "www.google.be.", "www.google.by."}
 	for _, host := range googleDomains {
-		exchangeAndAssertResponse(t, &client, addr, host, ip.String())
+		req := createTestMessage(host)
+		reply, _, err := client.Exchange(req, addr.String())
+		assert.Nil(t, err)
+		assert.Equal(t, host, reply.Question[0].Name)
+		assert.Equal(t, 2, len(reply.Answer))
+		cname, ok := reply.Answer[0].(*dns.CNAME)
+		assert.True(t, ok)
+		assert.Equal(t, "forcesafesearch.google.com.", cname.Target)
+		a, ok := reply.Answer[1].(*dns.A)
+		assert.True(t, ok)
+		assert.Equal(t, "216.239.38.120", a.A.String())
 	}
 
 	err = s.Stop()
@@ -266,13 +321,14 @@ func (u *testUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
 	reqType := m.Question[0].Qtype
 	name := m.Question[0].Name
 
-	// Let's check if we have any CNAME for given name
-	if cname, ok := u.cn[name]; ok {
+	// Let's check if we have any CNAME for given name (follow the whole chain)
+	for cname, ok := u.cn[name]; ok; cname, ok = u.cn[name] {
 		cn := dns.CNAME{}
 		cn.Hdr.Name = name
 		cn.Hdr.Rrtype = dns.TypeCNAME
 		cn.Target = cname
 		resp.Answer = append(resp.Answer, &cn)
+		name = cname
 	}
 
 	// Let's check if we can add some A records to the answer
@@ -333,12 +389,15 @@ func (s *Server) startWithUpstream(u upstream.Upstream) error {
 var testCNAMEs = map[string]string{
 	"badhost.":               "null.example.org.",
 	"whitelist.example.org.": "null.example.org.",
+	"first.example.org.":     "second.example.org.",
+	"second.example.org.":    "tracker.example.net.",
 }
 
 // testIPv4 is a simple map of names and IPv4s necessary for the testUpstream work
 var testIPv4 = map[string][]net.IP{
-	"null.example.org.": {{1, 2, 3, 4}},
-	"example.org.":      {{127, 0, 0, 255}},
+	"null.example.org.":    {{1, 2, 3, 4}},
+	"example.org.":         {{127, 0, 0, 255}},
+	"tracker.example.net.": {{1, 2, 3, 5}},
 }
 
 func TestBlockCNAMEProtectionEnabled(t *testing.T) {
@@ -389,10 +448,40 @@ func TestBlockCNAME(t *testing.T) {
 	_ = s.Stop()
 }
 
+func TestBlockCNAMEChain(t *testing.T) {
+	s := createTestServer(t)
+	testUpstm := &testUpstream{testCNAMEs, testIPv4, nil}
+	s.conf.FilterHandler = func(clientAddr, clientID string, settings *dnsfilter.RequestFilteringSettings) {
+		settings.SafeBrowsingEnabled = false
+		rule, _ := rules.NewNetworkRule("||tracker.example.net^", 0)
+		settings.ServicesRules = []dnsfilter.ServiceEntry{{Name: "tracker", Rules: []*rules.NetworkRule{rule}}}
+	}
+	err := s.startWithUpstream(testUpstm)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// 'first.example.org' -> 'second.example.org' -> 'tracker.example.net' which is a blocked service:
+	// response is blocked
+	req := createTestMessage("first.example.org.")
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	// the blocked service is disabled for the client: response isn't blocked
+	s.conf.FilterHandler = nil
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 3, len(reply.Answer))
+
+	_ = s.Stop()
+}
+
 func TestClientRulesForCNAMEMatching(t *testing.T) {
 	s := createTestServer(t)
 	testUpstm := &testUpstream{testCNAMEs, testIPv4, nil}
-	s.conf.FilterHandler = func(clientAddr string, settings *dnsfilter.RequestFilteringSettings) {
+	s.conf.FilterHandler = func(clientAddr, clientID string, settings *dnsfilter.RequestFilteringSettings) {
 		settings.FilteringEnabled = false
 	}
 	err := s.startWithUpstream(testUpstm)
@@ -453,6 +542,23 @@ func TestNullBlockedRequest(t *testing.T) {
 	}
 }
 
+func TestRefusedBlockedRequest(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.FilteringConfig.BlockingMode = "refused"
+	err := s.Start()
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := createTestMessage("nxdomain.example.org.")
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeRefused, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	err = s.Stop()
+	assert.Nil(t, err)
+}
+
 func TestBlockedCustomIP(t *testing.T) {
 	rules := "||nxdomain.example.org^\n||null.example.org^\n127.0.0.1	host.example.org\n@@||whitelist.example.org^\n||127.0.0.255\n"
 	filters := []dnsfilter.Filter{dnsfilter.Filter{
@@ -501,6 +607,56 @@ func TestBlockedCustomIP(t *testing.T) {
 	if err != nil {
 		t.Fatalf("DNS server failed to stop: %s", err)
 	}
+
+	// IPv6 address for A and IPv4 address for AAAA
+	conf.BlockingIPv4 = "::1"
+	err = s.Prepare(&conf)
+	assert.NotNil(t, err)
+	conf.BlockingIPv4 = "0.0.0.1"
+	conf.BlockingIPv6 = "0.0.0.1"
+	err = s.Prepare(&conf)
+	assert.NotNil(t, err)
+
+	// no IPv6 address: AAAA response is empty
+	conf.BlockingIPv6 = ""
+	err = s.Prepare(&conf)
+	assert.Nil(t, err)
+	err = s.Start()
+	assert.Nil(t, err)
+	addr = s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req = createTestMessageWithType("null.example.org.", dns.TypeAAAA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	req = createTestMessageWithType("null.example.org.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reply.Answer))
+
+	err = s.Stop()
+	assert.Nil(t, err)
+}
+
+func TestParseBlockingIPs(t *testing.T) {
+	ip4, ip6, err := parseBlockingIPs("1.2.3.4", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4", ip4.String())
+	assert.Nil(t, ip6)
+
+	ip4, ip6, err = parseBlockingIPs("", "::1")
+	assert.Nil(t, err)
+	assert.Nil(t, ip4)
+	assert.Equal(t, "::1", ip6.String())
+
+	_, _, err = parseBlockingIPs("", "")
+	assert.NotNil(t, err)
+	_, _, err = parseBlockingIPs("1.2.3", "")
+	assert.NotNil(t, err)
+	_, _, err = parseBlockingIPs("", "::ffff:1.2.3.4")
+	assert.NotNil(t, err)
 }
 
 func TestBlockedByHosts(t *testing.T) {
@@ -794,6 +950,25 @@ func TestIsBlockedIPDisallowed(t *testing.T) {
 	assert.True(t, !a.IsBlockedIP("2.3.1.1"))
 }
 
+func TestIsBlockedClientID(t *testing.T) {
+	a := &accessCtx{}
+	assert.Nil(t, a.Init([]string{"1.1.1.1", "Laptop"}, nil, nil))
+	assert.False(t, a.IsBlockedClient("1.1.1.1", ""))
+	assert.False(t, a.IsBlockedClient("1.1.1.2", "laptop"))
+	assert.True(t, a.IsBlockedClient("1.1.1.2", "phone"))
+	assert.True(t, a.IsBlockedClient("1.1.1.2", ""))
+
+	a = &accessCtx{}
+	assert.Nil(t, a.Init(nil, []string{"phone"}, nil))
+	assert.True(t, a.IsBlockedClient("1.1.1.1", "phone"))
+	assert.False(t, a.IsBlockedClient("1.1.1.1", "laptop"))
+	assert.False(t, a.IsBlockedClient("1.1.1.1", ""))
+
+	assert.Nil(t, ValidateAccessClients([]string{"1.1.1.1", "2.2.0.0/16", "laptop"}))
+	assert.NotNil(t, ValidateAccessClients([]string{"1.1.1.1/33"}))
+	assert.NotNil(t, ValidateAccessClients([]string{"laptop.lan"}))
+}
+
 func TestIsBlockedIPBlockedDomain(t *testing.T) {
 	a := &accessCtx{}
 	assert.True(t, a.Init(nil, nil, []string{"host1",
@@ -824,11 +999,17 @@ func TestValidateUpstream(t *testing.T) {
 		"htttps://google.com/dns-query",
 		"[/host.com]tls://dns.adguard.com",
 		"[host.ru]#",
+		"https:///dns-query",
+		"https://dns.adguard.com:99999/dns-query",
+		"https://dns..adguard.com/dns-query",
 	}
 
 	validDefaultUpstreams := []string{"1.1.1.1",
 		"tls://1.1.1.1",
 		"https://dns.adguard.com/dns-query",
+		"https://dns.adguard.com:8443/dns-query",
+		"https://1.1.1.1/dns-query",
+		"https://[2606:4700:4700::1111]/dns-query",
 		"sdns://AQMAAAAAAAAAFDE3Ni4xMDMuMTMwLjEzMDo1NDQzINErR_JS3PLCu_iZEIbq95zkSV2LFsigxDIuUso_OQhzIjIuZG5zY3J5cHQuZGVmYXVsdC5uczEuYWRndWFyZC5jb20",
 	}
 
@@ -866,6 +1047,42 @@ func TestValidateUpstream(t *testing.T) {
 	}
 }
 
+func TestValidateUpstreamDNSStamp(t *testing.T) {
+	doh := dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypeDoH,
+		ServerAddrStr: "94.140.14.14",
+		ProviderName:  "dns.adguard.com",
+		Path:          "/dns-query",
+	}
+	dot := dnsstamps.ServerStamp{
+		Proto:        dnsstamps.StampProtoTypeTLS,
+		ProviderName: "dns.adguard.com",
+	}
+	plain := dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypePlain,
+		ServerAddrStr: "8.8.8.8",
+	}
+	for _, u := range []string{doh.String(), dot.String(), plain.String()} {
+		_, err := validateUpstream(u)
+		assert.Nil(t, err, u)
+	}
+
+	badHost := dnsstamps.ServerStamp{
+		Proto:        dnsstamps.StampProtoTypeTLS,
+		ProviderName: "dns..adguard.com",
+	}
+	badKey := dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypeDNSCrypt,
+		ServerAddrStr: "176.103.130.130:5443",
+		ServerPk:      []byte{1, 2, 3},
+		ProviderName:  "2.dnscrypt.default.ns1.adguard.com",
+	}
+	for _, u := range []string{badHost.String(), badKey.String(), "sdns://", "sdns://!!!", "sdns://BQ"} {
+		_, err := validateUpstream(u)
+		assert.NotNil(t, err, u)
+	}
+}
+
 func TestValidateUpstreamsSet(t *testing.T) {
 	// Set of valid upstreams. There is no default upstream specified
 	upstreamsSet := []string{"[/host.com/]1.1.1.1",
@@ -917,3 +1134,161 @@ func TestMatchDNSName(t *testing.T) {
 	assert.True(t, !matchDNSName(dnsNames, ""))
 	assert.True(t, !matchDNSName(dnsNames, "*.host2"))
 }
+
+func TestRemoveECS(t *testing.T) {
+	req := dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+	assert.False(t, removeECS(&req))
+
+	req.SetEdns0(4096, true)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.IP{1, 2, 3, 0},
+	})
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"})
+
+	assert.True(t, removeECS(&req))
+	assert.Equal(t, 1, len(opt.Option))
+	assert.Equal(t, uint16(dns.EDNS0COOKIE), opt.Option[0].Option())
+	assert.True(t, opt.Do())
+	assert.False(t, removeECS(&req))
+}
+
+func TestDrain(t *testing.T) {
+	s := &Server{}
+	assert.True(t, s.Drain(time.Second))
+
+	atomic.AddInt32(&s.activeRequests, 1)
+	assert.False(t, s.Drain(100*time.Millisecond))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt32(&s.activeRequests, -1)
+	}()
+	assert.True(t, s.Drain(time.Second))
+}
+
+func TestExtraListenIPs(t *testing.T) {
+	c := dnsfilter.Config{}
+	c.Rewrites = []dnsfilter.RewriteEntry{
+		{Domain: "host.lab.home", Answer: "10.0.0.5"},
+	}
+	f := dnsfilter.New(&c, nil)
+	s := NewServer(f, nil, nil)
+	conf := ServerConfig{
+		UDPListenAddr:  &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 0},
+		TCPListenAddr:  &net.TCPAddr{IP: net.IP{127, 0, 0, 1}, Port: 0},
+		ExtraListenIPs: []net.IP{{127, 0, 0, 1}},
+	}
+	conf.UpstreamDNS = []string{"8.8.8.8:53"}
+	conf.ProtectionEnabled = true
+	assert.Nil(t, s.Prepare(&conf))
+	assert.Nil(t, s.Start())
+	assert.Equal(t, 1, len(s.extraDNS))
+
+	req := &dns.Msg{}
+	req.SetQuestion("host.lab.home.", dns.TypeA)
+	for _, p := range []*proxy.Proxy{s.dnsProxy, s.extraDNS[0]} {
+		for _, proto := range []string{proxy.ProtoUDP, proxy.ProtoTCP} {
+			client := dns.Client{Net: proto}
+			reply, _, err := client.Exchange(req, p.Addr(proto).String())
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(reply.Answer))
+		}
+	}
+
+	assert.Nil(t, s.Stop())
+	assert.Nil(t, s.extraDNS[0].Addr(proxy.ProtoUDP))
+}
+
+// ecsUpstream stores EDNS Client Subnet option of the last request
+type ecsUpstream struct {
+	lock   sync.Mutex
+	subnet *dns.EDNS0_SUBNET
+}
+
+func (u *ecsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	u.lock.Lock()
+	u.subnet = nil
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if sn, ok := o.(*dns.EDNS0_SUBNET); ok {
+				u.subnet = sn
+			}
+		}
+	}
+	u.lock.Unlock()
+
+	resp := &dns.Msg{}
+	resp.SetReply(m)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+		A:   net.IP{1, 2, 3, 4},
+	})
+	return resp, nil
+}
+
+func (u *ecsUpstream) Address() string {
+	return "ecs"
+}
+
+func (u *ecsUpstream) getSubnet() *dns.EDNS0_SUBNET {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.subnet
+}
+
+func TestECSStrip(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.ProtectionEnabled = false
+	s.conf.EnableEDNSClientSubnet = true
+	s.conf.EDNSClientSubnetIP = "1.2.3.4"
+	u := &ecsUpstream{}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// ECS is enabled:  the subnet is added
+	req := &dns.Msg{}
+	req.SetQuestion("ecs1.example.com.", dns.TypeA)
+	_, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	sn := u.getSubnet()
+	assert.NotNil(t, sn)
+	if sn != nil {
+		assert.Equal(t, "1.2.3.0", sn.Address.String())
+		assert.Equal(t, uint8(24), sn.SourceNetmask)
+	}
+	_ = s.Stop()
+
+	// ECS is stripped:  neither the client's option nor the client's subnet is sent
+	s.conf.StripEDNSClientSubnet = true
+	err = s.startWithUpstream(u)
+	assert.Nil(t, err)
+	addr = s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req = &dns.Msg{}
+	req.SetQuestion("ecs2.example.com.", dns.TypeA)
+	req.SetEdns0(4096, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.IP{5, 6, 7, 0},
+	})
+	_, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Nil(t, u.getSubnet())
+
+	req = &dns.Msg{}
+	req.SetQuestion("ecs3.example.com.", dns.TypeA)
+	_, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Nil(t, u.getSubnet())
+
+	_ = s.Stop()
+}
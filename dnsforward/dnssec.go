@@ -0,0 +1,529 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Maximum time we keep the verified zone keys in cache
+const dnssecMaxKeysTTL = 1 * time.Hour
+
+// The root zone trust anchor (KSK-2017)
+// https://data.iana.org/root-anchors/root-anchors.xml
+var rootTrustAnchor = &dns.DS{
+	Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+	KeyTag:     20326,
+	Algorithm:  dns.RSASHA256,
+	DigestType: dns.SHA256,
+	Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+}
+
+// Maximum number of names whose zones we keep in cache
+const dnssecMaxCacheSize = 10000
+
+// The closest enclosing zone of a name and its verified keys
+type zoneKeys struct {
+	zone   string
+	keys   []*dns.DNSKEY // nil: the zone is not signed (insecure delegation)
+	expire time.Time
+}
+
+// The kinds of a name, as proven by DS request
+const (
+	notZoneCut         = iota // the name belongs to the parent zone
+	secureDelegation          // the name is a signed zone with the trusted DS records
+	insecureDelegation        // the name is an unsigned zone:  the absence of DS is proven by signed NSEC/NSEC3
+)
+
+// dnssecValidator verifies the chain of trust of DNS responses.
+// RRSIG of a response is verified with the DNSKEY of the signer's zone,
+// DNSKEY is verified with the DS record from the parent zone,
+// and so on up to the root zone, whose key must match the trust anchor.
+//
+// The zone of each record is found by walking from the root zone down to the record's name.
+// Once a trusted key exists for the zone, its records must be signed:
+//  a zone is considered unsigned only if the parent zone proves the absence of DS records with signed NSEC/NSEC3.
+// Otherwise an attacker could strip the signatures or forge an insecure delegation.
+type dnssecValidator struct {
+	exchange func(req *dns.Msg) (*dns.Msg, error) // send a request to upstream servers
+	anchors  []*dns.DS                            // trust anchors of the root zone
+
+	lock  sync.Mutex
+	cache map[string]zoneKeys // name -> its zone and verified keys
+}
+
+func newDNSSECValidator(exchange func(req *dns.Msg) (*dns.Msg, error)) *dnssecValidator {
+	return &dnssecValidator{
+		exchange: exchange,
+		anchors:  []*dns.DS{rootTrustAnchor},
+		cache:    map[string]zoneKeys{},
+	}
+}
+
+// Split the records into RRsets and find the signatures for each of them
+func groupRRsets(rrs []dns.RR) (map[string][]dns.RR, map[string][]*dns.RRSIG) {
+	rrsets := map[string][]dns.RR{}
+	sigs := map[string][]*dns.RRSIG{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		name := strings.ToLower(hdr.Name)
+		switch rr := rr.(type) {
+		case *dns.RRSIG:
+			key := name + "/" + dns.TypeToString[rr.TypeCovered]
+			sigs[key] = append(sigs[key], rr)
+		case *dns.OPT:
+			// not a part of the zone data
+		default:
+			key := name + "/" + dns.TypeToString[hdr.Rrtype]
+			rrsets[key] = append(rrsets[key], rr)
+		}
+	}
+	return rrsets, sigs
+}
+
+// Get the parent domain name:  "example.org." -> "org." -> "."
+func parentName(name string) string {
+	i, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[i:]
+}
+
+// Return TRUE if CNAME record is synthesized from DNAME record of the section
+// Such CNAME records are not signed.
+func isSynthesizedCNAME(rr dns.RR, section []dns.RR) bool {
+	cname, ok := rr.(*dns.CNAME)
+	if !ok {
+		return false
+	}
+	for _, r := range section {
+		dname, ok := r.(*dns.DNAME)
+		if !ok || strings.EqualFold(dname.Hdr.Name, cname.Hdr.Name) ||
+			!dns.IsSubDomain(dname.Hdr.Name, cname.Hdr.Name) {
+			continue
+		}
+		prefix := cname.Hdr.Name[:len(cname.Hdr.Name)-len(dname.Hdr.Name)]
+		if strings.EqualFold(prefix+dname.Target, cname.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate the response
+// Returns an error if the response is bogus.
+// Records from unsigned zones (insecure delegations) are considered insecure and are passed as is.
+func (v *dnssecValidator) validate(resp *dns.Msg) error {
+	rrsets, sigs := groupRRsets(resp.Answer)
+	for key, rrset := range rrsets {
+		if isSynthesizedCNAME(rrset[0], resp.Answer) {
+			continue // DNAME record is verified instead
+		}
+		err := v.verifyRecords(rrset, sigs[key])
+		if err != nil {
+			return fmt.Errorf("DNSSEC: %s: %s", key, err)
+		}
+	}
+
+	// Authority section may contain unsigned NS records of a delegation,
+	//  so we only verify the records that prove the response
+	denial := false
+	rrsets, sigs = groupRRsets(resp.Ns)
+	for key, rrset := range rrsets {
+		rrtype := rrset[0].Header().Rrtype
+		if rrtype != dns.TypeSOA && rrtype != dns.TypeNSEC && rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		err := v.verifyRecords(rrset, sigs[key])
+		if err != nil {
+			return fmt.Errorf("DNSSEC: %s: %s", key, err)
+		}
+		if rrtype != dns.TypeSOA {
+			denial = true
+		}
+	}
+
+	// a negative response from a signed zone must contain the denial of existence
+	name, negative := isNegativeResponse(resp)
+	if !negative || denial {
+		return nil
+	}
+	zk, err := v.findZone(name)
+	if err != nil {
+		return fmt.Errorf("DNSSEC: %s: %s", name, err)
+	}
+	if zk.keys != nil {
+		return fmt.Errorf("DNSSEC: %s: no NSEC/NSEC3 records in the negative response", name)
+	}
+	return nil
+}
+
+// Check whether the response is NXDOMAIN or NODATA
+// Returns the name whose data is absent (the question name or the target of CNAME chain)
+func isNegativeResponse(resp *dns.Msg) (string, bool) {
+	if len(resp.Question) != 1 ||
+		(resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+		return "", false
+	}
+	q := resp.Question[0]
+	name := q.Name
+	// follow CNAME chain;  it can't be longer than the answer
+	for range resp.Answer {
+		for _, rr := range resp.Answer {
+			cname, ok := rr.(*dns.CNAME)
+			if ok && strings.EqualFold(cname.Hdr.Name, name) {
+				name = cname.Target
+				break
+			}
+		}
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		return name, true
+	}
+	if q.Qtype == dns.TypeCNAME || q.Qtype == dns.TypeANY {
+		return "", false
+	}
+	for _, rr := range resp.Answer {
+		hdr := rr.Header()
+		if hdr.Rrtype == q.Qtype && strings.EqualFold(hdr.Name, name) {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// Verify RRset from the response
+// The RRset must be signed if it belongs to a signed zone.
+func (v *dnssecValidator) verifyRecords(rrset []dns.RR, sigs []*dns.RRSIG) error {
+	name := rrset[0].Header().Name
+	if rrset[0].Header().Rrtype == dns.TypeDS && name != "." {
+		name = parentName(name) // DS record belongs to the parent zone
+	}
+
+	// the records signed by a trusted zone key are secure:
+	//  try the signer's zone first to avoid looking up the zones of all the names between
+	for _, sig := range sigs {
+		if !dns.IsSubDomain(sig.SignerName, name) {
+			continue
+		}
+		zk, err := v.findZone(sig.SignerName)
+		if err == nil && zk.keys != nil && strings.EqualFold(zk.zone, sig.SignerName) &&
+			verifyRRset(rrset, []*dns.RRSIG{sig}, zk.zone, zk.keys) == nil {
+			return nil
+		}
+	}
+
+	zk, err := v.findZone(name)
+	if err != nil {
+		return err
+	}
+	if zk.keys == nil {
+		return nil // the zone is not signed
+	}
+	return verifyRRset(rrset, sigs, zk.zone, zk.keys)
+}
+
+// Verify RRset with one of its signatures made by the zone
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, zone string, keys []*dns.DNSKEY) error {
+	if len(sigs) == 0 {
+		return fmt.Errorf("no signature by %s", zone)
+	}
+
+	var lastErr error
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, zone) {
+			lastErr = fmt.Errorf("signer %s is not the zone %s", sig.SignerName, zone)
+			continue
+		}
+
+		err := verifySig(sig, keys, rrset)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// Verify the signature with the key it refers to
+func verifySig(sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	if !sig.ValidityPeriod(time.Now()) {
+		return fmt.Errorf("signature by %s/%d is expired or not yet valid", sig.SignerName, sig.KeyTag)
+	}
+
+	for _, k := range keys {
+		if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+			continue
+		}
+		if sig.Verify(k, rrset) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid signature by %s/%d", sig.SignerName, sig.KeyTag)
+}
+
+// Send a DNSSEC request
+// NXDOMAIN response is not an error.
+func (v *dnssecValidator) query(name string, qtype uint16) (*dns.Msg, error) {
+	req := &dns.Msg{}
+	req.SetQuestion(name, qtype)
+	req.SetEdns0(4096, true)
+	resp, err := v.exchange(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s %s: %s", name, dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// Find the closest enclosing zone of the name and its verified keys
+// The parent zones are checked first, up to the root zone.
+func (v *dnssecValidator) findZone(name string) (zoneKeys, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	v.lock.Lock()
+	zk, ok := v.cache[name]
+	v.lock.Unlock()
+	if ok && time.Now().Before(zk.expire) {
+		return zk, nil
+	}
+
+	if name == "." {
+		keys, ttl, err := v.getVerifiedDNSKEY(name, v.anchors)
+		if err != nil {
+			return zoneKeys{}, err
+		}
+		return v.storeZone(name, zoneKeys{zone: name, keys: keys, expire: time.Now().Add(ttl)}), nil
+	}
+
+	zk, err := v.findZone(parentName(name))
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	if zk.keys != nil {
+		zk, err = v.checkDelegation(name, zk)
+		if err != nil {
+			return zoneKeys{}, err
+		}
+	}
+	return v.storeZone(name, zk), nil
+}
+
+// Store the zone of the name in cache
+func (v *dnssecValidator) storeZone(name string, zk zoneKeys) zoneKeys {
+	log.Debug("DNSSEC: %s: zone %s: %d trusted keys", name, zk.zone, len(zk.keys))
+	v.lock.Lock()
+	if len(v.cache) >= dnssecMaxCacheSize {
+		v.cache = map[string]zoneKeys{}
+	}
+	v.cache[name] = zk
+	v.lock.Unlock()
+	return zk
+}
+
+// Check whether the name is a zone cut in the signed parent zone
+// Returns the zone of the name
+func (v *dnssecValidator) checkDelegation(name string, parent zoneKeys) (zoneKeys, error) {
+	kind, dsList, err := v.getTrustedDS(name, parent)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+
+	switch kind {
+	case secureDelegation:
+		keys, ttl, err := v.getVerifiedDNSKEY(name, dsList)
+		if err != nil {
+			return zoneKeys{}, err
+		}
+		expire := time.Now().Add(ttl)
+		if parent.expire.Before(expire) {
+			expire = parent.expire
+		}
+		return zoneKeys{zone: name, keys: keys, expire: expire}, nil
+
+	case insecureDelegation:
+		return zoneKeys{zone: name, expire: parent.expire}, nil
+	}
+	return parent, nil
+}
+
+// Get the trusted DS records for the name from the signed parent zone
+// The delegation is insecure only if the absence of DS is proven by signed NSEC/NSEC3 records.
+// Otherwise the name belongs to the parent zone:
+//  if it's actually a signed zone whose DS records are stripped, its records won't pass the verification.
+func (v *dnssecValidator) getTrustedDS(name string, parent zoneKeys) (int, []*dns.DS, error) {
+	resp, err := v.query(name, dns.TypeDS)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		return notZoneCut, nil, nil
+	}
+
+	dsList := []*dns.DS{}
+	rrset := []dns.RR{}
+	sigs := []*dns.RRSIG{}
+	for _, rr := range resp.Answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.DS:
+			dsList = append(dsList, rr)
+			rrset = append(rrset, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDS {
+				sigs = append(sigs, rr)
+			}
+		}
+	}
+	if len(dsList) != 0 {
+		err = verifyRRset(rrset, sigs, parent.zone, parent.keys)
+		if err != nil {
+			return 0, nil, fmt.Errorf("DS of %s: %s", name, err)
+		}
+		return secureDelegation, dsList, nil
+	}
+
+	if provesInsecureDelegation(name, resp.Ns, parent) {
+		return insecureDelegation, nil, nil
+	}
+	return notZoneCut, nil, nil
+}
+
+// Return TRUE if the records prove that the name is a delegation without DS records
+// Only the records signed by the parent zone are used.
+func provesInsecureDelegation(name string, rrs []dns.RR, parent zoneKeys) bool {
+	nsecs := []*dns.NSEC{}
+	nsec3s := []*dns.NSEC3{}
+	rrsets, sigs := groupRRsets(rrs)
+	for key, rrset := range rrsets {
+		rrtype := rrset[0].Header().Rrtype
+		if (rrtype != dns.TypeNSEC && rrtype != dns.TypeNSEC3) ||
+			verifyRRset(rrset, sigs[key], parent.zone, parent.keys) != nil {
+			continue
+		}
+		for _, rr := range rrset {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			return isDelegationWithoutDS(nsec.TypeBitMap)
+		}
+	}
+
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return isDelegationWithoutDS(nsec3.TypeBitMap)
+		}
+	}
+	if len(nsec3s) == 0 {
+		return false
+	}
+
+	// Opt-Out:  the closest encloser of the name is proven to exist
+	//  and the next closer name is covered by NSEC3 with Opt-Out flag (RFC 5155 8.6)
+	next := name
+	for {
+		ce := parentName(next)
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(ce) {
+				return coveredByOptOut(next, nsec3s)
+			}
+		}
+		if strings.EqualFold(ce, parent.zone) || ce == "." {
+			return false
+		}
+		next = ce
+	}
+}
+
+// Return TRUE if the name is covered by NSEC3 record with Opt-Out flag
+func coveredByOptOut(name string, nsec3s []*dns.NSEC3) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Flags&1 != 0 && nsec3.Cover(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return TRUE if the type bitmap of NSEC/NSEC3 record belongs to a delegation without DS records
+func isDelegationWithoutDS(types []uint16) bool {
+	ns := false
+	for _, t := range types {
+		switch t {
+		case dns.TypeNS:
+			ns = true
+		case dns.TypeDS, dns.TypeSOA:
+			return false
+		}
+	}
+	return ns
+}
+
+// Get DNSKEY records of the zone and verify them with the trusted DS records
+func (v *dnssecValidator) getVerifiedDNSKEY(zone string, dsList []*dns.DS) ([]*dns.DNSKEY, time.Duration, error) {
+	resp, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := []*dns.DNSKEY{}
+	rrset := []dns.RR{}
+	sigs := []*dns.RRSIG{}
+	ttl := dnssecMaxKeysTTL
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+			rrset = append(rrset, rr)
+			t := time.Duration(rr.Hdr.Ttl) * time.Second
+			if t < ttl {
+				ttl = t
+			}
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, rr)
+			}
+		}
+	}
+
+	// find the key signing keys which match the DS records
+	ksks := []*dns.DNSKEY{}
+	for _, k := range keys {
+		for _, ds := range dsList {
+			kds := k.ToDS(ds.DigestType)
+			if kds != nil && kds.KeyTag == ds.KeyTag &&
+				strings.EqualFold(kds.Digest, ds.Digest) {
+				ksks = append(ksks, k)
+				break
+			}
+		}
+	}
+	if len(ksks) == 0 {
+		return nil, 0, fmt.Errorf("no DNSKEY of %s matches DS", zone)
+	}
+
+	for _, sig := range sigs {
+		if verifySig(sig, ksks, rrset) == nil {
+			return keys, ttl, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("DNSKEY of %s is not signed by a trusted key", zone)
+}
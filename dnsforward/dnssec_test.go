@@ -0,0 +1,303 @@
+package dnsforward
+
+import (
+	"crypto"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// Signed test zone
+type testZone struct {
+	key  *dns.DNSKEY
+	priv crypto.PrivateKey
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	assert.Nil(t, err)
+	return &testZone{key: key, priv: priv}
+}
+
+func (z *testZone) sign(t *testing.T, rrset []dns.RR, inception, expiration time.Time) *dns.RRSIG {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  z.key.Algorithm,
+		SignerName: z.key.Hdr.Name,
+		KeyTag:     z.key.KeyTag(),
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	err := sig.Sign(z.priv.(crypto.Signer), rrset)
+	assert.Nil(t, err)
+	return sig
+}
+
+// Upstream server with the test zones
+type testDNSSECUpstream struct {
+	answer map[string][]dns.RR // "name|type" -> records of the answer section
+	ns     map[string][]dns.RR // "name|type" -> records of the authority section
+}
+
+func (u *testDNSSECUpstream) exchange(req *dns.Msg) (*dns.Msg, error) {
+	q := req.Question[0]
+	key := fmt.Sprintf("%s|%s", q.Name, dns.TypeToString[q.Qtype])
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.Answer = u.answer[key]
+	resp.Ns = u.ns[key]
+	return resp, nil
+}
+
+func TestDNSSECValidator(t *testing.T) {
+	now := time.Now()
+	inception := now.Add(-time.Hour)
+	expiration := now.Add(time.Hour)
+
+	root := newTestZone(t, ".")
+	zone := newTestZone(t, "example.org.")
+
+	ds := zone.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = 3600
+	dsSig := root.sign(t, []dns.RR{ds}, inception, expiration)
+
+	// the signed proof of insecure delegation
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "insecure.org.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+		NextDomain: "z.org.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	nsecSig := root.sign(t, []dns.RR{nsec}, inception, expiration)
+
+	u := &testDNSSECUpstream{
+		answer: map[string][]dns.RR{
+			".|DNSKEY":            {root.key, root.sign(t, []dns.RR{root.key}, inception, expiration)},
+			"example.org.|DNSKEY": {zone.key, zone.sign(t, []dns.RR{zone.key}, inception, expiration)},
+			"example.org.|DS":     {ds, dsSig},
+		},
+		ns: map[string][]dns.RR{
+			"insecure.org.|DS": {nsec, nsecSig},
+		},
+	}
+	newValidator := func() *dnssecValidator {
+		v := newDNSSECValidator(u.exchange)
+		v.anchors = []*dns.DS{root.key.ToDS(dns.SHA256)}
+		return v
+	}
+	v := newValidator()
+
+	a := &dns.A{
+		Hdr: dns.RR_Header{Name: "host.example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IP{1, 2, 3, 4},
+	}
+	resp := &dns.Msg{}
+	resp.Answer = []dns.RR{a, zone.sign(t, []dns.RR{a}, inception, expiration)}
+
+	// secure
+	assert.Nil(t, v.validate(resp))
+
+	// bogus: the record was modified
+	bogus := &dns.A{Hdr: a.Hdr, A: net.IP{5, 6, 7, 8}}
+	resp.Answer = []dns.RR{bogus, resp.Answer[1]}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: the signature is expired
+	resp.Answer = []dns.RR{a, zone.sign(t, []dns.RR{a}, now.Add(-2*time.Hour), inception)}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: signed by an unknown key
+	other := newTestZone(t, "example.org.")
+	resp.Answer = []dns.RR{a, other.sign(t, []dns.RR{a}, inception, expiration)}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: the signature was stripped from the response of the signed zone
+	resp.Answer = []dns.RR{a}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: the zone key doesn't match the trust anchor
+	v = newDNSSECValidator(u.exchange)
+	v.anchors = []*dns.DS{other.key.ToDS(dns.SHA256)}
+	resp.Answer = []dns.RR{a, zone.sign(t, []dns.RR{a}, inception, expiration)}
+	assert.NotNil(t, v.validate(resp))
+
+	// insecure: the absence of DS is proven by the signed NSEC record
+	insecureA := &dns.A{
+		Hdr: dns.RR_Header{Name: "host.insecure.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IP{1, 2, 3, 4},
+	}
+	resp.Answer = []dns.RR{insecureA}
+	v = newValidator()
+	assert.Nil(t, v.validate(resp))
+
+	// bogus: the signature of NSEC record was stripped
+	u.ns["insecure.org.|DS"] = []dns.RR{nsec}
+	v = newValidator()
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: NSEC record is signed by an unknown key
+	u.ns["insecure.org.|DS"] = []dns.RR{nsec, newTestZone(t, ".").sign(t, []dns.RR{nsec}, inception, expiration)}
+	v = newValidator()
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: NSEC record doesn't prove the absence of DS
+	nsecDS := &dns.NSEC{Hdr: nsec.Hdr, NextDomain: nsec.NextDomain, TypeBitMap: []uint16{dns.TypeNS, dns.TypeDS, dns.TypeRRSIG, dns.TypeNSEC}}
+	u.ns["insecure.org.|DS"] = []dns.RR{nsecDS, root.sign(t, []dns.RR{nsecDS}, inception, expiration)}
+	v = newValidator()
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: NSEC record isn't a delegation
+	nsecA := &dns.NSEC{Hdr: nsec.Hdr, NextDomain: nsec.NextDomain, TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC}}
+	u.ns["insecure.org.|DS"] = []dns.RR{nsecA, root.sign(t, []dns.RR{nsecA}, inception, expiration)}
+	v = newValidator()
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: the forged insecure delegation of the signed zone, DS records are removed
+	delete(u.answer, "example.org.|DS")
+	resp.Answer = []dns.RR{a, zone.sign(t, []dns.RR{a}, inception, expiration)}
+	v = newValidator()
+	assert.NotNil(t, v.validate(resp))
+	resp.Answer = []dns.RR{a}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: the signature of DS records was stripped
+	u.answer["example.org.|DS"] = []dns.RR{ds}
+	v = newValidator()
+	assert.NotNil(t, v.validate(resp))
+}
+
+func TestDNSSECValidatorNSEC3OptOut(t *testing.T) {
+	now := time.Now()
+	inception := now.Add(-time.Hour)
+	expiration := now.Add(time.Hour)
+
+	root := newTestZone(t, ".")
+	zone := newTestZone(t, "org.")
+	ds := zone.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = 3600
+
+	// the closest encloser "org." exists
+	ce := &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: dns.HashName("org.", dns.SHA1, 0, "") + ".org.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+		Hash:       dns.SHA1,
+		NextDomain: dns.HashName("org.", dns.SHA1, 0, ""),
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+	}
+	// the next closer name "optout.org." is covered by Opt-Out span
+	cover := &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: "00000000000000000000000000000000.org.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+		Hash:       dns.SHA1,
+		Flags:      1,
+		NextDomain: "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV",
+	}
+
+	u := &testDNSSECUpstream{
+		answer: map[string][]dns.RR{
+			".|DNSKEY":    {root.key, root.sign(t, []dns.RR{root.key}, inception, expiration)},
+			"org.|DNSKEY": {zone.key, zone.sign(t, []dns.RR{zone.key}, inception, expiration)},
+			"org.|DS":     {ds, root.sign(t, []dns.RR{ds}, inception, expiration)},
+		},
+		ns: map[string][]dns.RR{
+			"optout.org.|DS": {
+				ce, zone.sign(t, []dns.RR{ce}, inception, expiration),
+				cover, zone.sign(t, []dns.RR{cover}, inception, expiration),
+			},
+		},
+	}
+	newValidator := func() *dnssecValidator {
+		v := newDNSSECValidator(u.exchange)
+		v.anchors = []*dns.DS{root.key.ToDS(dns.SHA256)}
+		return v
+	}
+
+	a := &dns.A{
+		Hdr: dns.RR_Header{Name: "optout.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IP{1, 2, 3, 4},
+	}
+	resp := &dns.Msg{}
+	resp.Answer = []dns.RR{a}
+
+	// insecure: the name is in Opt-Out span
+	assert.Nil(t, newValidator().validate(resp))
+
+	// bogus: the span isn't Opt-Out
+	noOptOut := &dns.NSEC3{Hdr: cover.Hdr, Hash: cover.Hash, NextDomain: cover.NextDomain}
+	u.ns["optout.org.|DS"] = []dns.RR{
+		ce, zone.sign(t, []dns.RR{ce}, inception, expiration),
+		noOptOut, zone.sign(t, []dns.RR{noOptOut}, inception, expiration),
+	}
+	assert.NotNil(t, newValidator().validate(resp))
+
+	// bogus: the closest encloser isn't proven
+	u.ns["optout.org.|DS"] = []dns.RR{cover, zone.sign(t, []dns.RR{cover}, inception, expiration)}
+	assert.NotNil(t, newValidator().validate(resp))
+}
+
+func TestDNSSECValidatorNegative(t *testing.T) {
+	now := time.Now()
+	inception := now.Add(-time.Hour)
+	expiration := now.Add(time.Hour)
+
+	root := newTestZone(t, ".")
+	zone := newTestZone(t, "example.org.")
+	ds := zone.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = 3600
+
+	u := &testDNSSECUpstream{
+		answer: map[string][]dns.RR{
+			".|DNSKEY":            {root.key, root.sign(t, []dns.RR{root.key}, inception, expiration)},
+			"example.org.|DNSKEY": {zone.key, zone.sign(t, []dns.RR{zone.key}, inception, expiration)},
+			"example.org.|DS":     {ds, root.sign(t, []dns.RR{ds}, inception, expiration)},
+		},
+	}
+	v := newDNSSECValidator(u.exchange)
+	v.anchors = []*dns.DS{root.key.ToDS(dns.SHA256)}
+
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+		Ns:      "ns.example.org.",
+		Mbox:    "admin.example.org.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  300,
+	}
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+		NextDomain: "z.example.org.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY},
+	}
+
+	req := createTestMessage("nx.example.org.")
+	resp := &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeNameError)
+	resp.Ns = []dns.RR{
+		soa, zone.sign(t, []dns.RR{soa}, inception, expiration),
+		nsec, zone.sign(t, []dns.RR{nsec}, inception, expiration),
+	}
+	assert.Nil(t, v.validate(resp))
+
+	// bogus: NSEC records were stripped
+	resp.Ns = []dns.RR{soa, zone.sign(t, []dns.RR{soa}, inception, expiration)}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: the signature of SOA record was stripped
+	resp.Ns = []dns.RR{soa, nsec, zone.sign(t, []dns.RR{nsec}, inception, expiration)}
+	assert.NotNil(t, v.validate(resp))
+
+	// bogus: NODATA response without NSEC records
+	resp = &dns.Msg{}
+	resp.SetReply(req)
+	resp.Ns = []dns.RR{soa, zone.sign(t, []dns.RR{soa}, inception, expiration)}
+	assert.NotNil(t, v.validate(resp))
+}
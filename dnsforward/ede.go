@@ -0,0 +1,81 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/miekg/dns"
+)
+
+// Extended DNS Errors (RFC 8914):
+//  the responses to the filtered requests contain EDE option with the reason of filtering;
+//  the rule which has filtered the request is the extra text.
+//  INFO-CODE:
+//   Blocked (15): blocklists, blocked services, blocked response IPs, safe browsing
+//   Filtered (17): parental control (it's enabled for the client)
+//   Forged Answer (4): safe search
+//  The option is added only if the request has OPT record (EDNS0).
+
+// EDNS0 option code of Extended DNS Error
+const edeOptionCode = 15
+
+// INFO-CODE values
+const (
+	edeForgedAnswer = 4
+	edeBlocked      = 15
+	edeFiltered     = 17
+)
+
+// Max. length of the extra text (in bytes)
+const edeMaxTextLen = 255
+
+// Get INFO-CODE by the filtering reason
+// Return FALSE if the response isn't filtered
+func edeInfoCode(reason dnsfilter.Reason) (uint16, bool) {
+	switch reason {
+	case dnsfilter.FilteredBlackList,
+		dnsfilter.FilteredBlockedService,
+		dnsfilter.FilteredSafeBrowsing:
+		return edeBlocked, true
+	case dnsfilter.FilteredParental:
+		return edeFiltered, true
+	case dnsfilter.FilteredSafeSearch:
+		return edeForgedAnswer, true
+	}
+	return 0, false
+}
+
+// Add Extended DNS Error option to the response to the filtered request
+func addEDE(req *dns.Msg, resp *dns.Msg, result *dnsfilter.Result) {
+	code, ok := edeInfoCode(result.Reason)
+	if !ok {
+		return
+	}
+	reqOpt := req.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+
+	opt := resp.IsEdns0()
+	if opt == nil {
+		resp.SetEdns0(reqOpt.UDPSize(), false)
+		opt = resp.IsEdns0()
+	}
+
+	text := result.Rule
+	if len(text) == 0 {
+		text = result.ServiceName
+	}
+	if len(text) > edeMaxTextLen {
+		text = text[:edeMaxTextLen]
+		for len(text) != 0 && !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+
+	data := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(data, code)
+	data = append(data, text...)
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: edeOptionCode, Data: data})
+}
@@ -0,0 +1,111 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// Get INFO-CODE and extra text from EDE option;  return FALSE if there's no EDE option
+func getEDE(resp *dns.Msg) (uint16, string, bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return 0, "", false
+	}
+	for _, o := range opt.Option {
+		l, ok := o.(*dns.EDNS0_LOCAL)
+		if !ok || l.Code != edeOptionCode || len(l.Data) < 2 {
+			continue
+		}
+		return binary.BigEndian.Uint16(l.Data), string(l.Data[2:]), true
+	}
+	return 0, "", false
+}
+
+func TestAddEDE(t *testing.T) {
+	req := createTestMessage("example.org.")
+	resp := &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeNameError)
+	result := &dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredBlackList, Rule: "||example.org^"}
+
+	// the request doesn't have OPT record
+	addEDE(req, resp, result)
+	assert.Nil(t, resp.IsEdns0())
+
+	req.SetEdns0(4096, false)
+	addEDE(req, resp, result)
+	code, text, ok := getEDE(resp)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(edeBlocked), code)
+	assert.Equal(t, "||example.org^", text)
+	_, err := resp.Pack()
+	assert.Nil(t, err)
+
+	// blocked service:  the service name is the extra text
+	resp = &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeNameError)
+	addEDE(req, resp, &dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredBlockedService, ServiceName: "youtube"})
+	code, text, ok = getEDE(resp)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(edeBlocked), code)
+	assert.Equal(t, "youtube", text)
+
+	// parental control
+	resp = &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeNameError)
+	addEDE(req, resp, &dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredParental, Rule: "adult-blocker"})
+	code, _, ok = getEDE(resp)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(edeFiltered), code)
+
+	// the long text is truncated on UTF-8 character boundary
+	resp = &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeNameError)
+	addEDE(req, resp, &dnsfilter.Result{IsFiltered: true, Reason: dnsfilter.FilteredBlackList, Rule: "||" + strings.Repeat("ж", 200)})
+	_, text, ok = getEDE(resp)
+	assert.True(t, ok)
+	assert.Equal(t, edeMaxTextLen-1, len(text))
+	assert.True(t, utf8.ValidString(text))
+
+	// the response isn't filtered
+	resp = &dns.Msg{}
+	resp.SetReply(req)
+	addEDE(req, resp, &dnsfilter.Result{Reason: dnsfilter.NotFilteredWhiteList, Rule: "@@||example.org^"})
+	assert.Nil(t, resp.IsEdns0())
+}
+
+func TestBlockedRequestEDE(t *testing.T) {
+	s := createTestServer(t)
+	err := s.Start()
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := createTestMessage("nxdomain.example.org.")
+	req.SetEdns0(4096, false)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	if err == nil {
+		assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+		code, text, ok := getEDE(reply)
+		assert.True(t, ok)
+		assert.Equal(t, uint16(edeBlocked), code)
+		assert.Equal(t, "||nxdomain.example.org", text)
+	}
+
+	// the request without OPT record:  the response doesn't have it either
+	req = createTestMessage("nxdomain.example.org.")
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	if err == nil {
+		assert.Nil(t, reply.IsEdns0())
+	}
+
+	err = s.Stop()
+	assert.Nil(t, err)
+}
@@ -1,6 +1,14 @@
 package dnsforward
 
-import "net"
+import (
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
 
 // GetIPString is a helper function that extracts IP address from net.Addr
 func GetIPString(addr net.Addr) string {
@@ -12,3 +20,71 @@ func GetIPString(addr net.Addr) string {
 	}
 	return ""
 }
+
+// Parse the list of IP addresses and CIDRs
+func parseIPNets(list []string) ([]net.IPNet, error) {
+	nets := []net.IPNet{}
+	for _, s := range list {
+		ip := net.ParseIP(s)
+		if ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR: %s", s)
+		}
+		nets = append(nets, *ipnet)
+	}
+	return nets, nil
+}
+
+// Remove EDNS Client Subnet option from DNS message
+// Returns true if the option was removed
+func removeECS(m *dns.Msg) bool {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return false
+	}
+
+	removed := false
+	options := []dns.EDNS0{}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0SUBNET {
+			removed = true
+			continue
+		}
+		options = append(options, o)
+	}
+	opt.Option = options
+	return removed
+}
+
+// Parse upstreams configuration using the custom function which creates upstream objects (if it isn't nil)
+func parseUpstreamsConfig(lines []string, bootstrap []string, addressToUpstream proxy.AddressToUpstreamFunction) (proxy.UpstreamConfig, error) {
+	if addressToUpstream == nil {
+		return proxy.ParseUpstreamsConfig(lines, bootstrap, DefaultTimeout)
+	}
+	return proxy.ParseUpstreamsConfigEx(lines, bootstrap, DefaultTimeout, addressToUpstream)
+}
+
+// Create upstream objects for the client's upstream servers
+// Return nil if any of them can't be created
+func newClientUpstreams(addrs []string, addressToUpstream proxy.AddressToUpstreamFunction) []upstream.Upstream {
+	ups := []upstream.Upstream{}
+	for _, addr := range addrs {
+		u, err := addressToUpstream(addr, upstream.Options{Timeout: DefaultTimeout})
+		if err != nil {
+			log.Debug("DNS: client upstream %s: %s", addr, err)
+			return nil
+		}
+		ups = append(ups, u)
+	}
+	return ups
+}
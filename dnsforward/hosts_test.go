@@ -0,0 +1,63 @@
+package dnsforward
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// The system hosts-file is used even if filtering is disabled
+func TestHostsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	assert.Nil(t, err)
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, _ = f.WriteString("192.168.1.10\tnas.example.org\n")
+	_ = f.Close()
+
+	ah := util.AutoHosts{}
+	ah.Init(f.Name())
+	ah.Start()
+	defer ah.Close()
+	for i := 0; i != 100 && len(ah.List()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c := dnsfilter.Config{}
+	c.AutoHosts = &ah
+	s := NewServer(dnsfilter.New(&c, nil), nil, nil)
+	s.conf.UDPListenAddr = &net.UDPAddr{Port: 0}
+	s.conf.TCPListenAddr = &net.TCPAddr{Port: 0}
+	s.conf.UpstreamDNS = []string{"8.8.8.8:53"}
+	s.conf.FilteringConfig.ProtectionEnabled = false
+	err = s.startWithUpstream(&dns64Upstream{ipv4: map[string]net.IP{}})
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := &dns.Msg{}
+	req.SetQuestion("NAS.example.org.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	a, ok := reply.Answer[0].(*dns.A)
+	assert.True(t, ok)
+	assert.True(t, a.A.Equal(net.IP{192, 168, 1, 10}))
+
+	req.SetQuestion("10.1.168.192.in-addr.arpa.", dns.TypePTR)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reply.Answer))
+	ptr, ok := reply.Answer[0].(*dns.PTR)
+	assert.True(t, ok)
+	assert.Equal(t, "nas.example.org.", ptr.Ptr)
+
+	_ = s.Stop()
+}
@@ -0,0 +1,134 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Private reverse DNS:
+//  PTR requests for the private IP addresses (RFC 1918, RFC 6598, loopback, link-local, ULA)
+//   are sent only to local_ptr_upstreams - never to the public upstream servers.
+//  If local_ptr_upstreams is empty, the resolvers from the system configuration (/etc/resolv.conf) are used:
+//   only the private addresses except the loopback and the host's own ones.
+//  If there are no such servers, NXDOMAIN is returned.
+//  The PTR responses from DHCP leases, hosts files and filtering rules are returned as usual.
+//  A private zone configured in private_zones takes precedence.
+//  The responses from the local resolvers aren't cached.
+
+// The networks for which PTR requests are sent to the local resolvers
+var privateNets = mustParseIPNets([]string{
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+})
+
+// The system resolvers configuration file
+var resolvConfPath = "/etc/resolv.conf"
+
+func mustParseIPNets(list []string) []net.IPNet {
+	nets, err := parseIPNets(list)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// Return TRUE if IP address belongs to a private network
+func isPrivateIP(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return TRUE if it's a PTR request for a private IP address
+func isPrivatePTR(q dns.Question) bool {
+	if q.Qtype != dns.TypePTR {
+		return false
+	}
+	ip := util.DNSUnreverseAddr(strings.TrimSuffix(strings.ToLower(q.Name), "."))
+	return ip != nil && isPrivateIP(ip)
+}
+
+// ValidateLocalPTRUpstreams - check local_ptr_upstreams setting
+func ValidateLocalPTRUpstreams(list []string) error {
+	for _, u := range list {
+		if strings.HasPrefix(u, "[/") || u == "#" {
+			return fmt.Errorf("local_ptr_upstreams: %s: domains can't be specified", u)
+		}
+		_, err := validateUpstream(u)
+		if err != nil {
+			return fmt.Errorf("local_ptr_upstreams: %s: %s", u, err)
+		}
+	}
+	return nil
+}
+
+// Get the private DNS servers from the system configuration
+// The host's own addresses are skipped so that the requests aren't sent back to us
+func detectLocalResolvers() []string {
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		log.Debug("DNS: local resolvers: %s", err)
+		return nil
+	}
+
+	own := map[string]bool{}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if ok {
+			own[ipnet.IP.String()] = true
+		}
+	}
+
+	resolvers := []string{}
+	for _, s := range conf.Servers {
+		ip := net.ParseIP(s)
+		if ip == nil || ip.IsLoopback() || !isPrivateIP(ip) || own[ip.String()] {
+			continue
+		}
+		resolvers = append(resolvers, net.JoinHostPort(ip.String(), conf.Port))
+	}
+	return resolvers
+}
+
+// Prepare the local resolvers:  local_ptr_upstreams or the detected ones
+func (s *Server) prepareLocalPTR() error {
+	s.localResolvers = detectLocalResolvers()
+
+	list := s.conf.LocalPTRUpstreams
+	if len(list) == 0 {
+		list = s.localResolvers
+	}
+	err := ValidateLocalPTRUpstreams(list)
+	if err != nil {
+		return err
+	}
+
+	s.localPTRUpstreams = nil
+	if len(list) != 0 {
+		var conf proxy.UpstreamConfig
+		conf, err = parseUpstreamsConfig(list, s.conf.BootstrapDNS, s.addressToUpstream)
+		if err != nil {
+			return fmt.Errorf("local_ptr_upstreams: %s", err)
+		}
+		s.localPTRUpstreams = conf.Upstreams
+	}
+	log.Debug("DNS: local PTR resolvers: %v", list)
+	return nil
+}
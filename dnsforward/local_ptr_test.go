@@ -0,0 +1,166 @@
+package dnsforward
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// ptrUpstream responds to PTR requests with the specified host name
+type ptrUpstream struct {
+	host     string
+	requests int
+}
+
+func (u *ptrUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	u.requests++
+	resp := &dns.Msg{}
+	resp.SetReply(m)
+	resp.Answer = append(resp.Answer, &dns.PTR{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 10},
+		Ptr: u.host,
+	})
+	return resp, nil
+}
+
+func (u *ptrUpstream) Address() string {
+	return "ptr"
+}
+
+func TestIsPrivatePTR(t *testing.T) {
+	q := dns.Question{Qtype: dns.TypePTR, Qclass: dns.ClassINET}
+	for _, name := range []string{
+		"1.1.168.192.in-addr.arpa.",
+		"2.0.0.10.IN-ADDR.ARPA.",
+		"1.0.0.127.in-addr.arpa.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.",
+	} {
+		q.Name = name
+		assert.True(t, isPrivatePTR(q), name)
+	}
+
+	for _, name := range []string{
+		"8.8.8.8.in-addr.arpa.",
+		"168.192.in-addr.arpa.",
+		"host.lan.",
+	} {
+		q.Name = name
+		assert.False(t, isPrivatePTR(q), name)
+	}
+
+	q.Name = "1.1.168.192.in-addr.arpa."
+	q.Qtype = dns.TypeA
+	assert.False(t, isPrivatePTR(q))
+}
+
+func TestDetectLocalResolvers(t *testing.T) {
+	f, err := ioutil.TempFile("", "resolv.conf")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString("nameserver 192.168.1.1\nnameserver 127.0.0.53\nnameserver 8.8.8.8\nnameserver fd00::1\n")
+	f.Close()
+
+	prev := resolvConfPath
+	resolvConfPath = f.Name()
+	defer func() { resolvConfPath = prev }()
+	assert.Equal(t, []string{"192.168.1.1:53", "[fd00::1]:53"}, detectLocalResolvers())
+
+	resolvConfPath = "/nonexistent/resolv.conf"
+	assert.Equal(t, 0, len(detectLocalResolvers()))
+}
+
+func TestValidateLocalPTRUpstreams(t *testing.T) {
+	assert.Nil(t, ValidateLocalPTRUpstreams(nil))
+	assert.Nil(t, ValidateLocalPTRUpstreams([]string{"192.168.1.1", "tls://192.168.1.2"}))
+
+	assert.NotNil(t, ValidateLocalPTRUpstreams([]string{"[/lan/]192.168.1.1"}))
+	assert.NotNil(t, ValidateLocalPTRUpstreams([]string{"#"}))
+	assert.NotNil(t, ValidateLocalPTRUpstreams([]string{"udp://192.168.1.1"}))
+}
+
+func TestLocalPTR(t *testing.T) {
+	prev := resolvConfPath
+	resolvConfPath = "/nonexistent/resolv.conf"
+	defer func() { resolvConfPath = prev }()
+
+	s := createTestServer(t)
+	s.conf.ProtectionEnabled = false
+	public := &ptrUpstream{host: "public.example."}
+	err := s.startWithUpstream(public)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// no local resolvers: NXDOMAIN, the request isn't sent to the public upstream server
+	req := &dns.Msg{}
+	req.SetQuestion("1.1.168.192.in-addr.arpa.", dns.TypePTR)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+	assert.Equal(t, 0, public.requests)
+
+	local := &ptrUpstream{host: "router.lan."}
+	s.localPTRUpstreams = []upstream.Upstream{local}
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	assert.Equal(t, "router.lan.", reply.Answer[0].(*dns.PTR).Ptr)
+	assert.Equal(t, 1, local.requests)
+	assert.Equal(t, 0, public.requests)
+
+	// public addresses are resolved as usual
+	req.SetQuestion("8.8.8.8.in-addr.arpa.", dns.TypePTR)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "public.example.", reply.Answer[0].(*dns.PTR).Ptr)
+	assert.Equal(t, 1, public.requests)
+
+	_ = s.Stop()
+}
+
+func TestLocalPTRDNSSEC(t *testing.T) {
+	prev := resolvConfPath
+	resolvConfPath = "/nonexistent/resolv.conf"
+	defer func() { resolvConfPath = prev }()
+
+	s := createTestServer(t)
+	s.conf.ProtectionEnabled = false
+	s.conf.EnableDNSSEC = true
+	// the public DNS tree is unreachable:  the validation of any response fails
+	validatorRequests := 0
+	s.dnssec = newDNSSECValidator(func(req *dns.Msg) (*dns.Msg, error) {
+		validatorRequests++
+		return nil, errors.New("unreachable")
+	})
+	public := &ptrUpstream{host: "public.example."}
+	err := s.startWithUpstream(public)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// the response from the local resolver isn't validated
+	local := &ptrUpstream{host: "router.lan."}
+	s.localPTRUpstreams = []upstream.Upstream{local}
+	req := &dns.Msg{}
+	req.SetQuestion("1.1.168.192.in-addr.arpa.", dns.TypePTR)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	assert.Equal(t, 1, local.requests)
+	assert.Equal(t, 0, validatorRequests)
+
+	// the public response is validated
+	req.SetQuestion("8.8.8.8.in-addr.arpa.", dns.TypePTR)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeServerFailure, reply.Rcode)
+	assert.NotEqual(t, 0, validatorRequests)
+
+	_ = s.Stop()
+}
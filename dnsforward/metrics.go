@@ -0,0 +1,163 @@
+package dnsforward
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/miekg/dns"
+)
+
+// The upper bounds (in seconds) of the buckets of upstream latency histogram
+var latencyBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Key of DNS queries counter
+type queryMetricsKey struct {
+	status string // processing result: "allowed", "blocked", etc.
+	qtype  string // question type: "A", "AAAA", etc.
+}
+
+// dnsMetrics counts DNS queries for Prometheus metrics
+type dnsMetrics struct {
+	lock        sync.Mutex
+	queries     map[queryMetricsKey]uint64
+	rcodes      map[string]uint64 // response code -> number of responses
+	cacheHits   uint64
+	cacheMisses uint64
+}
+
+func newDNSMetrics() *dnsMetrics {
+	return &dnsMetrics{
+		queries: map[queryMetricsKey]uint64{},
+		rcodes:  map[string]uint64{},
+	}
+}
+
+// Get the status of the processed request
+func metricsStatus(reason dnsfilter.Reason) string {
+	switch reason {
+	case dnsfilter.FilteredBlackList, dnsfilter.FilteredInvalid, dnsfilter.FilteredBlockedService:
+		return "blocked"
+	case dnsfilter.FilteredSafeBrowsing:
+		return "safebrowsing"
+	case dnsfilter.FilteredParental:
+		return "parental"
+	case dnsfilter.FilteredSafeSearch:
+		return "safesearch"
+	case dnsfilter.ReasonRewrite, dnsfilter.RewriteEtcHosts, dnsfilter.RewriteRule:
+		return "rewritten"
+	}
+	return "allowed"
+}
+
+// Count the processed request
+func (m *dnsMetrics) addQuery(req, resp *dns.Msg, res dnsfilter.Result) {
+	key := queryMetricsKey{status: metricsStatus(res.Reason)}
+	if len(req.Question) != 0 {
+		key.qtype = dns.Type(req.Question[0].Qtype).String()
+	}
+
+	m.lock.Lock()
+	m.queries[key]++
+	if resp != nil {
+		m.rcodes[dns.RcodeToString[resp.Rcode]]++
+	}
+	m.lock.Unlock()
+}
+
+// Count the cache lookup
+func (m *dnsMetrics) addCacheLookup(hit bool) {
+	m.lock.Lock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+	m.lock.Unlock()
+}
+
+// Get the index of the latency histogram bucket
+func latencyBucket(elapsed time.Duration) int {
+	return sort.SearchFloat64s(latencyBuckets[:], elapsed.Seconds())
+}
+
+// WriteMetrics - write DNS metrics in Prometheus text format
+func (s *Server) WriteMetrics(w io.Writer) {
+	m := s.metrics
+	mw := util.NewMetricsWriter(w)
+
+	m.lock.Lock()
+	keys := []queryMetricsKey{}
+	for k := range m.queries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].status != keys[j].status {
+			return keys[i].status < keys[j].status
+		}
+		return keys[i].qtype < keys[j].qtype
+	})
+	mw.Header("adguard_dns_queries_total", "counter", "DNS queries by processing status and question type")
+	for _, k := range keys {
+		mw.Sample("adguard_dns_queries_total", float64(m.queries[k]), "status", k.status, "type", k.qtype)
+	}
+
+	rcodes := []string{}
+	for rc := range m.rcodes {
+		rcodes = append(rcodes, rc)
+	}
+	sort.Strings(rcodes)
+	mw.Header("adguard_dns_responses_total", "counter", "DNS responses by response code")
+	for _, rc := range rcodes {
+		mw.Sample("adguard_dns_responses_total", float64(m.rcodes[rc]), "rcode", rc)
+	}
+
+	mw.Header("adguard_dns_cache_hits_total", "counter", "Responses found in DNS cache")
+	mw.Sample("adguard_dns_cache_hits_total", float64(m.cacheHits))
+	mw.Header("adguard_dns_cache_misses_total", "counter", "Requests not found in DNS cache")
+	mw.Sample("adguard_dns_cache_misses_total", float64(m.cacheMisses))
+	ratio := float64(0)
+	if m.cacheHits+m.cacheMisses != 0 {
+		ratio = float64(m.cacheHits) / float64(m.cacheHits+m.cacheMisses)
+	}
+	m.lock.Unlock()
+	mw.Header("adguard_dns_cache_hit_ratio", "gauge", "Ratio of cache hits to cache lookups")
+	mw.Sample("adguard_dns_cache_hit_ratio", ratio)
+
+	s.RLock()
+	t := s.upstreamRTT
+	s.RUnlock()
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	addrs := []string{}
+	for addr := range t.stats {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	mw.Header("adguard_upstream_latency_seconds", "histogram", "Time of the successful requests to upstream servers")
+	for _, addr := range addrs {
+		st := t.stats[addr]
+		mw.Histogram("adguard_upstream_latency_seconds", latencyBuckets[:], st.latency[:], st.latencySum.Seconds(),
+			"upstream", addr)
+	}
+	mw.Header("adguard_upstream_errors_total", "counter", "Failed requests to upstream servers")
+	for _, addr := range addrs {
+		mw.Sample("adguard_upstream_errors_total", float64(t.stats[addr].errors), "upstream", addr)
+	}
+	mw.Header("adguard_upstream_up", "gauge", "Whether the upstream server is up (1) or down (0)")
+	for _, addr := range addrs {
+		up := float64(1)
+		if t.stats[addr].down {
+			up = 0
+		}
+		mw.Sample("adguard_upstream_up", up, "upstream", addr)
+	}
+}
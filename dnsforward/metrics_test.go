@@ -0,0 +1,47 @@
+package dnsforward
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	s := Server{}
+	s.metrics = newDNSMetrics()
+	s.upstreamRTT = newRTTTracker()
+
+	req := &dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+	resp := &dns.Msg{}
+	resp.SetRcode(req, dns.RcodeSuccess)
+	s.metrics.addQuery(req, resp, dnsfilter.Result{})
+	s.metrics.addQuery(req, resp, dnsfilter.Result{})
+	s.metrics.addQuery(req, resp, dnsfilter.Result{Reason: dnsfilter.FilteredBlackList})
+	s.metrics.addCacheLookup(true)
+	s.metrics.addCacheLookup(false)
+	s.metrics.addCacheLookup(false)
+	s.metrics.addCacheLookup(false)
+	s.upstreamRTT.update("1.1.1.1:53", 20*time.Millisecond, nil)
+	s.upstreamRTT.update("1.1.1.1:53", 2*time.Second, nil)
+	s.upstreamRTT.update("1.1.1.1:53", 0, errors.New("timeout"))
+
+	b := &bytes.Buffer{}
+	s.WriteMetrics(b)
+	lines := strings.Split(b.String(), "\n")
+	assert.Contains(t, lines, `adguard_dns_queries_total{status="allowed",type="A"} 2`)
+	assert.Contains(t, lines, `adguard_dns_queries_total{status="blocked",type="A"} 1`)
+	assert.Contains(t, lines, `adguard_dns_responses_total{rcode="NOERROR"} 3`)
+	assert.Contains(t, lines, `adguard_dns_cache_hit_ratio 0.25`)
+	assert.Contains(t, lines, `adguard_upstream_latency_seconds_bucket{upstream="1.1.1.1:53",le="0.025"} 1`)
+	assert.Contains(t, lines, `adguard_upstream_latency_seconds_bucket{upstream="1.1.1.1:53",le="2.5"} 2`)
+	assert.Contains(t, lines, `adguard_upstream_latency_seconds_sum{upstream="1.1.1.1:53"} 2.02`)
+	assert.Contains(t, lines, `adguard_upstream_errors_total{upstream="1.1.1.1:53"} 1`)
+	assert.Contains(t, lines, `adguard_upstream_up{upstream="1.1.1.1:53"} 1`)
+}
@@ -0,0 +1,51 @@
+package dnsforward
+
+import (
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+)
+
+// EDNS0 padding (RFC 7830, RFC 8467):
+//  the responses to DNS-over-TLS and DNS-over-HTTPS requests are padded
+//  so that their size is a multiple of paddingBlockSize.
+//  The response is padded only if the request has OPT record (EDNS0).
+//  Plain DNS responses aren't padded;  DNSCrypt has its own padding.
+
+// The block size for the responses recommended by RFC 8467
+const paddingBlockSize = 468
+
+// Return TRUE if the responses to the requests received via this protocol must be padded
+func isEncryptedProto(proto string) bool {
+	return proto == proxy.ProtoTLS || proto == proxy.ProtoHTTPS
+}
+
+// Add Padding option to the response:  the size of the packed response becomes a multiple of blockSize
+func padResponse(req *dns.Msg, resp *dns.Msg, blockSize int) {
+	reqOpt := req.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+
+	opt := resp.IsEdns0()
+	if opt == nil {
+		resp.SetEdns0(reqOpt.UDPSize(), false)
+		opt = resp.IsEdns0()
+	}
+
+	// remove Padding option that could be received from upstream server
+	options := []dns.EDNS0{}
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0PADDING {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+
+	// option code (2 bytes) + option length (2 bytes)
+	size := resp.Len() + 4
+	padLen := 0
+	if size%blockSize != 0 {
+		padLen = blockSize - size%blockSize
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padLen)})
+}
@@ -0,0 +1,62 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPadResponse(t *testing.T) {
+	req := &dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+		A:   net.IP{1, 2, 3, 4},
+	})
+	resp.Compress = true
+
+	// the request doesn't have OPT record
+	padResponse(req, resp, paddingBlockSize)
+	assert.Nil(t, resp.IsEdns0())
+
+	req.SetEdns0(4096, false)
+	padResponse(req, resp, paddingBlockSize)
+	data, err := resp.Pack()
+	assert.Nil(t, err)
+	assert.Equal(t, paddingBlockSize, len(data))
+
+	// the response is padded again:  the old Padding option is replaced
+	for i := 0; i != 20; i++ {
+		resp.Answer = append(resp.Answer, resp.Answer[0])
+	}
+	padResponse(req, resp, paddingBlockSize)
+	assert.Equal(t, 1, len(resp.IsEdns0().Option))
+	data, err = resp.Pack()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(data)%paddingBlockSize)
+}
+
+func TestFinishResponsePadding(t *testing.T) {
+	s := &Server{}
+	s.conf.TLSEDNSPadding = true
+
+	req := &dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+	req.SetEdns0(4096, false)
+	for _, proto := range []string{proxy.ProtoUDP, proxy.ProtoTCP, proxy.ProtoTLS, proxy.ProtoHTTPS} {
+		d := &proxy.DNSContext{Proto: proto, Req: req, Res: s.genNXDomain(req)}
+		s.finishResponse(d)
+		data, err := d.Res.Pack()
+		assert.Nil(t, err)
+		if proto == proxy.ProtoTLS || proto == proxy.ProtoHTTPS {
+			assert.Equal(t, paddingBlockSize, len(data))
+		} else {
+			assert.True(t, len(data) < paddingBlockSize)
+		}
+	}
+}
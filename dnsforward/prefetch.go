@@ -0,0 +1,139 @@
+package dnsforward
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+const (
+	prefetchPeriod     = 1 * time.Minute // the period during which the requests are counted
+	prefetchMinHits    = 3               // a response is popular if it's requested at least this number of times per period
+	prefetchMaxTracked = 10000           // max. number of responses we count the requests for
+	prefetchQueueSize  = 100             // max. number of responses waiting to be refreshed
+	prefetchWorkers    = 2               // number of goroutines refreshing the responses
+)
+
+// A cached response to be refreshed
+type prefetchItem struct {
+	key  []byte
+	req  *dns.Msg
+	addr net.Addr // client's address (for EDNS Client Subnet)
+}
+
+// prefetcher refreshes popular cached responses before they expire
+type prefetcher struct {
+	srv *Server
+
+	lock        sync.Mutex
+	hits        map[string]uint32 // cache key -> number of requests during the current period
+	periodStart time.Time
+	pending     map[string]bool // cache keys which are in queue or being refreshed
+
+	queue chan prefetchItem
+	stop  chan bool
+	wg    sync.WaitGroup // running workers
+}
+
+func newPrefetcher(s *Server) *prefetcher {
+	return &prefetcher{
+		srv:     s,
+		hits:    map[string]uint32{},
+		pending: map[string]bool{},
+		queue:   make(chan prefetchItem, prefetchQueueSize),
+	}
+}
+
+// Start refreshing the responses in background
+func (p *prefetcher) start() {
+	p.stop = make(chan bool)
+	for i := 0; i != prefetchWorkers; i++ {
+		p.wg.Add(1)
+		go p.worker(p.stop)
+	}
+}
+
+// Stop refreshing the responses
+// Wait until the workers exit:  a refresh in progress uses the server's cache and DNS proxy,
+//  which are replaced when the server is reconfigured.
+func (p *prefetcher) close() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.wg.Wait()
+}
+
+// Count a request which was answered from cache
+// Add the response to the refresh queue if it's popular and expires soon
+func (p *prefetcher) hit(key []byte, d *proxy.DNSContext) {
+	now := time.Now()
+	k := string(key)
+
+	p.lock.Lock()
+	if now.Sub(p.periodStart) > prefetchPeriod {
+		p.hits = map[string]uint32{}
+		p.periodStart = now
+	}
+	n, ok := p.hits[k]
+	if !ok && len(p.hits) >= prefetchMaxTracked {
+		p.lock.Unlock()
+		return
+	}
+	n++
+	p.hits[k] = n
+	if n < prefetchMinHits || p.pending[k] || !p.srv.cache.expiresSoon(key) {
+		p.lock.Unlock()
+		return
+	}
+	p.pending[k] = true
+	p.lock.Unlock()
+
+	item := prefetchItem{key: key, req: d.Req.Copy(), addr: d.Addr}
+	select {
+	case p.queue <- item:
+		log.Debug("DNS: prefetch: queued %s", d.Req.Question[0].Name)
+	default:
+		// the queue is full
+		p.lock.Lock()
+		delete(p.pending, k)
+		p.lock.Unlock()
+	}
+}
+
+func (p *prefetcher) worker(stop chan bool) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case item := <-p.queue:
+			p.refresh(item)
+		}
+	}
+}
+
+// Get the new response from upstream servers and store it in cache
+func (p *prefetcher) refresh(item prefetchItem) {
+	s := p.srv
+	d := &proxy.DNSContext{
+		Proto:     "udp",
+		Req:       item.req,
+		Addr:      item.addr,
+		StartTime: time.Now(),
+	}
+	err := s.exchangeUpstream(d)
+	if err != nil {
+		log.Debug("DNS: prefetch: %s: %s", item.req.Question[0].Name, err)
+	} else if d.Res.Rcode != dns.RcodeServerFailure {
+		s.cache.set(item.key, d.Res)
+	}
+
+	p.lock.Lock()
+	delete(p.pending, string(item.key))
+	p.lock.Unlock()
+}
@@ -0,0 +1,96 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Conditional forwarding for private zones.
+// Requests for a private zone are sent only to its internal DNS servers - never to the public ones.
+// These requests bypass filtering and cache, so a public NXDOMAIN response is never returned for a private host.
+
+// Check private zones configuration
+// Each line must contain the list of domains: "[/domain1/domain2/]upstream"
+func validatePrivateZones(lines []string) error {
+	for _, l := range lines {
+		u, defaultUpstream, err := separateUpstream(l)
+		if err != nil {
+			return err
+		}
+		if defaultUpstream {
+			return fmt.Errorf("no domains specified: %s", l)
+		}
+		if u == "#" {
+			return fmt.Errorf("'#' is not supported: %s", l)
+		}
+		_, err = validateUpstream(u)
+		if err != nil {
+			return fmt.Errorf("%s: %s", l, err)
+		}
+	}
+	return nil
+}
+
+// Parse private zones configuration
+// Return the map: zone name (FQDN, lowercase) -> DNS servers
+func parsePrivateZones(lines []string, bootstrap []string, addressToUpstream proxy.AddressToUpstreamFunction) (map[string][]upstream.Upstream, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	err := validatePrivateZones(lines)
+	if err != nil {
+		return nil, fmt.Errorf("private zones: %s", err)
+	}
+
+	conf, err := parseUpstreamsConfig(lines, bootstrap, addressToUpstream)
+	if err != nil {
+		return nil, fmt.Errorf("private zones: %s", err)
+	}
+
+	for zone, ups := range conf.DomainReservedUpstreams {
+		log.Debug("DNS: private zone %s: %d servers", zone, len(ups))
+	}
+	return conf.DomainReservedUpstreams, nil
+}
+
+// Get DNS servers for a private zone the host belongs to
+// Return nil if the host doesn't belong to any private zone
+func (s *Server) getPrivateZoneUpstreams(host string) []upstream.Upstream {
+	if len(s.privateZones) == 0 {
+		return nil
+	}
+
+	host = strings.ToLower(host)
+	dotsCount := strings.Count(host, ".")
+	if dotsCount < 2 {
+		return s.privateZones[proxy.UnqualifiedNames]
+	}
+
+	for i := 1; i <= dotsCount; i++ {
+		h := strings.SplitAfterN(host, ".", i)
+		if ups, ok := s.privateZones[h[i-1]]; ok {
+			return ups
+		}
+	}
+	return nil
+}
+
+// Check if the request is for a private zone
+func processPrivateZone(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+
+	s.RLock()
+	ups := s.getPrivateZoneUpstreams(d.Req.Question[0].Name)
+	s.RUnlock()
+	if len(ups) != 0 {
+		log.Debug("DNS: %s belongs to a private zone", d.Req.Question[0].Name)
+		ctx.privateUpstreams = ups
+	}
+	return resultDone
+}
@@ -0,0 +1,123 @@
+package dnsforward
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrivateZones(t *testing.T) {
+	zones, err := parsePrivateZones([]string{
+		"[/corp.example/1.168.192.in-addr.arpa/]192.168.1.1",
+		"[/lan/]tls://192.168.1.2",
+	}, []string{"8.8.8.8"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(zones))
+	assert.Equal(t, 1, len(zones["corp.example."]))
+	assert.Equal(t, 1, len(zones["1.168.192.in-addr.arpa."]))
+	assert.Equal(t, 1, len(zones["lan."]))
+
+	s := &Server{privateZones: zones}
+	assert.NotNil(t, s.getPrivateZoneUpstreams("host.Corp.Example."))
+	assert.NotNil(t, s.getPrivateZoneUpstreams("10.1.168.192.in-addr.arpa."))
+	assert.Nil(t, s.getPrivateZoneUpstreams("example."))
+	assert.Nil(t, s.getPrivateZoneUpstreams("host.example.org."))
+
+	// a public upstream
+	_, err = parsePrivateZones([]string{"192.168.1.1"}, nil, nil)
+	assert.NotNil(t, err)
+	_, err = parsePrivateZones([]string{"[/corp.example/]#"}, nil, nil)
+	assert.NotNil(t, err)
+	_, err = parsePrivateZones([]string{"[/corp.example/]udp://1.1.1.1"}, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestPrivateZones(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.ProtectionEnabled = false
+	public := &dns64Upstream{ipv4: map[string]net.IP{}}
+	err := s.startWithUpstream(public)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// the public NXDOMAIN response is cached
+	req := &dns.Msg{}
+	req.SetQuestion("host.corp.example.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	private := &dns64Upstream{ipv4: map[string]net.IP{
+		"host.corp.example.":    {192, 168, 1, 10},
+		"nxdomain.example.org.": {192, 168, 1, 11},
+	}}
+	s.privateZones = map[string][]upstream.Upstream{
+		"corp.example.":         {private},
+		"nxdomain.example.org.": {private},
+	}
+
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	a, ok := reply.Answer[0].(*dns.A)
+	assert.True(t, ok)
+	assert.True(t, a.A.Equal(net.IP{192, 168, 1, 10}))
+
+	// not filtered
+	req.SetQuestion("nxdomain.example.org.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+
+	_ = s.Stop()
+}
+
+func TestPrivateZonesDNSSEC(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.ProtectionEnabled = false
+	s.conf.EnableDNSSEC = true
+	// the public DNS tree is unreachable:  the validation of any response fails
+	validatorRequests := 0
+	s.dnssec = newDNSSECValidator(func(req *dns.Msg) (*dns.Msg, error) {
+		validatorRequests++
+		return nil, errors.New("unreachable")
+	})
+	public := &dns64Upstream{ipv4: map[string]net.IP{
+		"public.example.net.": {1, 2, 3, 4},
+	}}
+	err := s.startWithUpstream(public)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	private := &dns64Upstream{ipv4: map[string]net.IP{
+		"host.corp.example.": {192, 168, 1, 10},
+	}}
+	s.privateZones = map[string][]upstream.Upstream{
+		"corp.example.": {private},
+	}
+
+	// the response for a private zone isn't validated
+	req := &dns.Msg{}
+	req.SetQuestion("host.corp.example.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	assert.Equal(t, 0, validatorRequests)
+
+	// the public response is validated
+	req.SetQuestion("public.example.net.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeServerFailure, reply.Rcode)
+	assert.NotEqual(t, 0, validatorRequests)
+
+	_ = s.Stop()
+}
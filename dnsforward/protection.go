@@ -0,0 +1,90 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Protection may be paused for some time, e.g. while the user is debugging a broken web site.
+// The pause isn't stored in configuration file:
+//  the protection is re-enabled automatically when the time is up or when the server is restarted.
+// Protection may also be suspended by the application (e.g. while Windows service is paused)
+//  until it's resumed.
+
+// Return TRUE if protection is enabled and isn't paused
+// Call with s.RLock held
+func (s *Server) isProtectionEnabled() bool {
+	return s.conf.ProtectionEnabled && !s.protectionSuspended && !time.Now().Before(s.protectionDisabledUntil)
+}
+
+// SuspendProtection pauses protection until ResumeProtection is called
+func (s *Server) SuspendProtection() {
+	s.Lock()
+	s.protectionSuspended = true
+	s.Unlock()
+	log.Info("DNS: protection is suspended")
+}
+
+// ResumeProtection resumes protection suspended by SuspendProtection
+func (s *Server) ResumeProtection() {
+	s.Lock()
+	s.protectionSuspended = false
+	s.Unlock()
+	log.Info("DNS: protection is resumed")
+}
+
+// ProtectionStatus returns TRUE if protection is enabled and isn't paused
+// If protection is paused, it also returns the time when it will be re-enabled
+func (s *Server) ProtectionStatus() (bool, time.Time) {
+	s.RLock()
+	defer s.RUnlock()
+	if !s.conf.ProtectionEnabled || s.protectionSuspended {
+		return false, time.Time{}
+	}
+	if time.Now().Before(s.protectionDisabledUntil) {
+		return false, s.protectionDisabledUntil
+	}
+	return true, time.Time{}
+}
+
+type protectionJSON struct {
+	Enabled bool `json:"enabled"`
+	// Pause protection for this time (in milliseconds)
+	// 0: disable protection until it's enabled manually
+	Duration uint64 `json:"duration"`
+}
+
+func (s *Server) handleProtection(w http.ResponseWriter, r *http.Request) {
+	req := protectionJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+
+	if req.Enabled && req.Duration != 0 {
+		httpError(r, w, http.StatusBadRequest, "duration may be set only when disabling protection")
+		return
+	}
+
+	s.Lock()
+	if !req.Enabled && req.Duration != 0 {
+		if !s.conf.ProtectionEnabled {
+			s.Unlock()
+			httpError(r, w, http.StatusBadRequest, "protection is disabled")
+			return
+		}
+		s.protectionDisabledUntil = time.Now().Add(time.Duration(req.Duration) * time.Millisecond)
+		log.Info("DNS: protection is paused until %s", s.protectionDisabledUntil.Format(time.RFC3339))
+		s.Unlock()
+		return
+	}
+
+	s.conf.ProtectionEnabled = req.Enabled
+	s.protectionDisabledUntil = time.Time{}
+	s.Unlock()
+	s.conf.ConfigModified()
+}
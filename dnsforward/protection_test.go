@@ -0,0 +1,84 @@
+package dnsforward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtectionPause(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.ConfigModified = func() {}
+	err := s.startWithUpstream(&countingUpstream{})
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	setProtection := func(body string) int {
+		r := httptest.NewRequest("POST", "/control/protection", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.handleProtection(w, r)
+		return w.Code
+	}
+
+	req := &dns.Msg{}
+	req.SetQuestion("nxdomain.example.org.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	assert.Equal(t, http.StatusBadRequest, setProtection(`{"enabled":true,"duration":1000}`))
+
+	// pause
+	assert.Equal(t, http.StatusOK, setProtection(`{"enabled":false,"duration":300}`))
+	enabled, until := s.ProtectionStatus()
+	assert.False(t, enabled)
+	assert.True(t, until.After(time.Now()))
+	assert.True(t, s.conf.ProtectionEnabled)
+
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+
+	// re-enabled automatically
+	time.Sleep(300 * time.Millisecond)
+	enabled, until = s.ProtectionStatus()
+	assert.True(t, enabled)
+	assert.True(t, until.IsZero())
+
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	// re-enabled manually
+	assert.Equal(t, http.StatusOK, setProtection(`{"enabled":false,"duration":60000}`))
+	assert.Equal(t, http.StatusOK, setProtection(`{"enabled":true}`))
+	enabled, _ = s.ProtectionStatus()
+	assert.True(t, enabled)
+
+	// suspended and resumed
+	s.SuspendProtection()
+	enabled, until = s.ProtectionStatus()
+	assert.False(t, enabled)
+	assert.True(t, until.IsZero())
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	s.ResumeProtection()
+	enabled, _ = s.ProtectionStatus()
+	assert.True(t, enabled)
+
+	// disabled until it's enabled manually
+	assert.Equal(t, http.StatusOK, setProtection(`{"enabled":false}`))
+	enabled, until = s.ProtectionStatus()
+	assert.False(t, enabled)
+	assert.True(t, until.IsZero())
+	assert.False(t, s.conf.ProtectionEnabled)
+
+	_ = s.Stop()
+}
@@ -0,0 +1,133 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Rate limiting:
+//  the number of requests per second from one client IP address is limited separately for each transport:
+//   ratelimit: plain DNS over UDP and DNSCrypt over UDP
+//   ratelimit_tcp: plain DNS over TCP, DNS-over-TLS, DNS-over-HTTPS and DNSCrypt over TCP
+//  0 disables the limit.
+//  ratelimit_whitelist: IP addresses and CIDRs of the clients which aren't limited
+//  ratelimit_response: what to do with the requests over the limit:
+//   "drop" (default): don't respond
+//   "servfail": respond with SERVFAIL
+//  The rate-limited requests aren't written to query log, they are counted in statistics per client.
+
+// Values of ratelimit_response setting
+const (
+	ratelimitDrop     = "drop"
+	ratelimitServFail = "servfail"
+)
+
+// If a client hasn't sent requests for this time, its bucket is full and may be removed
+const ratelimitIdleTime = 10 * time.Second
+
+type ratelimitKey struct {
+	ip  string
+	tcp bool
+}
+
+// Token bucket of a client:  tokens are refilled at the rate of "limit" per second up to "limit"
+type ratelimitBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	udpLimit  uint32
+	tcpLimit  uint32
+	whitelist []net.IPNet
+
+	lock        sync.Mutex
+	buckets     map[ratelimitKey]*ratelimitBucket
+	lastCleanup time.Time
+}
+
+// ValidateRatelimitSettings - check ratelimit_whitelist and ratelimit_response settings
+func ValidateRatelimitSettings(whitelist []string, response string) error {
+	_, err := parseIPNets(whitelist)
+	if err != nil {
+		return fmt.Errorf("ratelimit_whitelist: %s", err)
+	}
+	if !(len(response) == 0 || response == ratelimitDrop || response == ratelimitServFail) {
+		return fmt.Errorf("ratelimit_response: unsupported value: %s", response)
+	}
+	return nil
+}
+
+// Create rate limiter object
+// Return nil if rate limiting is disabled
+func newRateLimiter(conf *FilteringConfig) (*rateLimiter, error) {
+	if conf.Ratelimit == 0 && conf.RatelimitTCP == 0 {
+		return nil, nil
+	}
+	whitelist, err := parseIPNets(conf.RatelimitWhitelist)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit_whitelist: %s", err)
+	}
+	return &rateLimiter{
+		udpLimit:  conf.Ratelimit,
+		tcpLimit:  conf.RatelimitTCP,
+		whitelist: whitelist,
+		buckets:   map[ratelimitKey]*ratelimitBucket{},
+	}, nil
+}
+
+// Return FALSE if the request from this client must be rejected
+// tcp: the request is received via TCP, DNS-over-TLS or DNS-over-HTTPS
+func (rl *rateLimiter) allow(ip net.IP, tcp bool, now time.Time) bool {
+	limit := rl.udpLimit
+	if tcp {
+		limit = rl.tcpLimit
+	}
+	if limit == 0 || ip == nil {
+		return true
+	}
+	for _, n := range rl.whitelist {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if now.Sub(rl.lastCleanup) >= time.Minute {
+		for k, b := range rl.buckets {
+			if now.Sub(b.last) >= ratelimitIdleTime {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastCleanup = now
+	}
+
+	key := ratelimitKey{ip: ip.String(), tcp: tcp}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &ratelimitBucket{tokens: float64(limit), last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(limit)
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Return TRUE if the request is received via a stream transport (TCP, DNS-over-TLS, DNS-over-HTTPS)
+func isStreamAddr(a net.Addr) bool {
+	_, ok := a.(*net.UDPAddr)
+	return !ok
+}
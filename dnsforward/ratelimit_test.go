@@ -0,0 +1,82 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl, err := newRateLimiter(&FilteringConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, rl)
+
+	rl, err = newRateLimiter(&FilteringConfig{
+		Ratelimit:          2,
+		RatelimitWhitelist: []string{"192.168.1.0/24", "10.0.0.1"},
+	})
+	assert.Nil(t, err)
+
+	now := time.Now()
+	ip := net.IP{1, 2, 3, 4}
+	assert.True(t, rl.allow(ip, false, now))
+	assert.True(t, rl.allow(ip, false, now))
+	assert.False(t, rl.allow(ip, false, now))
+
+	// the other clients and TCP aren't limited
+	assert.True(t, rl.allow(net.IP{1, 2, 3, 5}, false, now))
+	assert.True(t, rl.allow(ip, true, now))
+
+	// the tokens are refilled
+	assert.True(t, rl.allow(ip, false, now.Add(500*time.Millisecond)))
+	assert.False(t, rl.allow(ip, false, now.Add(500*time.Millisecond)))
+
+	// whitelist
+	for i := 0; i != 10; i++ {
+		assert.True(t, rl.allow(net.IP{192, 168, 1, 10}, false, now))
+		assert.True(t, rl.allow(net.IP{10, 0, 0, 1}, false, now))
+	}
+
+	// the idle clients are removed
+	rl.allow(ip, false, now.Add(time.Hour))
+	assert.Equal(t, 1, len(rl.buckets))
+
+	_, err = newRateLimiter(&FilteringConfig{Ratelimit: 2, RatelimitWhitelist: []string{"1.2.3"}})
+	assert.NotNil(t, err)
+	assert.Nil(t, ValidateRatelimitSettings([]string{"fd00::/8"}, "servfail"))
+	assert.NotNil(t, ValidateRatelimitSettings(nil, "refused"))
+}
+
+func TestRatelimitServFail(t *testing.T) {
+	c := dnsfilter.Config{}
+	c.Rewrites = []dnsfilter.RewriteEntry{
+		{Domain: "host.lab.home", Answer: "10.0.0.5"},
+	}
+	s := NewServer(dnsfilter.New(&c, nil), nil, nil)
+	conf := ServerConfig{
+		UDPListenAddr: &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 0},
+		TCPListenAddr: &net.TCPAddr{IP: net.IP{127, 0, 0, 1}, Port: 0},
+	}
+	conf.UpstreamDNS = []string{"8.8.8.8:53"}
+	conf.ProtectionEnabled = true
+	conf.Ratelimit = 1
+	conf.RatelimitResponse = "servfail"
+	assert.Nil(t, s.Prepare(&conf))
+	assert.Nil(t, s.Start())
+	defer func() { _ = s.Stop() }()
+
+	req := &dns.Msg{}
+	req.SetQuestion("host.lab.home.", dns.TypeA)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+	reply, err := dns.Exchange(req, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	reply, err = dns.Exchange(req, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeServerFailure, reply.Rcode)
+}
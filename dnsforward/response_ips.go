@@ -0,0 +1,39 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+)
+
+// Blocking responses by answer IP (like "bogus-nxdomain" in dnsmasq):
+//  blocked_response_ips: IP addresses and CIDRs, e.g. the addresses of ISP's hijack pages or sinkholes.
+//  If A or AAAA record in the response from upstream server matches one of them,
+//   the response is blocked according to blocking_mode (NXDOMAIN in the default mode).
+//  The blocked responses are shown in query log and statistics as blocked by filters;
+//   the matched entry is used as the rule text.
+//  Filtering rules may match the answer IP too, but only the exact address.
+//  The responses to the whitelisted requests aren't checked.
+
+// ValidateBlockedResponseIPs - check blocked_response_ips setting
+func ValidateBlockedResponseIPs(list []string) error {
+	_, err := parseIPNets(list)
+	if err != nil {
+		return fmt.Errorf("blocked_response_ips: %s", err)
+	}
+	return nil
+}
+
+// Return the blocked_response_ips entry which matches IP address
+// Return empty string if IP isn't blocked
+// Must be called with s.RLock held
+func (s *Server) matchBlockedResponseIP(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	for i, n := range s.blockedResponseIPs {
+		if n.Contains(ip) {
+			return s.conf.BlockedResponseIPs[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockedResponseIPs(t *testing.T) {
+	s := createTestServer(t)
+	s.conf.BlockedResponseIPs = []string{"10.10.0.0/16", "1.2.3.4"}
+	testUpstm := &testUpstream{testCNAMEs, map[string][]net.IP{
+		"hijack.example.org.": {{1, 1, 1, 1}, {10, 10, 1, 1}},
+		"good.example.org.":   {{1, 1, 1, 1}},
+		"null.example.org.":   {{1, 2, 3, 4}},
+	}, nil}
+	err := s.startWithUpstream(testUpstm)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	// one of the addresses is in the blocked subnet
+	reply, err := dns.Exchange(createTestMessage("hijack.example.org."), addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	reply, err = dns.Exchange(createTestMessage("good.example.org."), addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+
+	// the whitelisted request isn't checked
+	reply, err = dns.Exchange(createTestMessage("whitelist.example.org."), addr)
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+
+	_ = s.Stop()
+
+	assert.Nil(t, ValidateBlockedResponseIPs([]string{"fd00::/8", "192.168.1.1"}))
+	assert.NotNil(t, ValidateBlockedResponseIPs([]string{"10.10.0.0/33"}))
+}
@@ -0,0 +1,122 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewrites(t *testing.T) {
+	c := dnsfilter.Config{}
+	c.Rewrites = []dnsfilter.RewriteEntry{
+		{Domain: "*.lab.home", Answer: "10.0.0.5"},
+		{Domain: "www.lab.home", Answer: "host.example.org"},
+	}
+	f := dnsfilter.New(&c, nil)
+	s := NewServer(f, nil, nil)
+	s.conf.UDPListenAddr = &net.UDPAddr{Port: 0}
+	s.conf.TCPListenAddr = &net.TCPAddr{Port: 0}
+	s.conf.UpstreamDNS = []string{"8.8.8.8:53"}
+	s.conf.FilteringConfig.ProtectionEnabled = true
+	u := &dns64Upstream{ipv4: map[string]net.IP{
+		"host.example.org.": {1, 2, 3, 4},
+	}}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	// wildcard
+	req := &dns.Msg{}
+	req.SetQuestion("nas.lab.home.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reply.Answer))
+	a, ok := reply.Answer[0].(*dns.A)
+	assert.True(t, ok)
+	assert.True(t, a.A.Equal(net.IP{10, 0, 0, 5}))
+
+	// CNAME target is resolved by upstream server
+	req.SetQuestion("www.lab.home.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "www.lab.home.", reply.Question[0].Name)
+	assert.Equal(t, 2, len(reply.Answer))
+	cname, ok := reply.Answer[0].(*dns.CNAME)
+	assert.True(t, ok)
+	assert.Equal(t, "host.example.org.", cname.Target)
+	a, ok = reply.Answer[1].(*dns.A)
+	assert.True(t, ok)
+	assert.True(t, a.A.Equal(net.IP{1, 2, 3, 4}))
+
+	_ = s.Stop()
+}
+
+func TestDNSRewriteRules(t *testing.T) {
+	rules := `||a.example.org^$dnsrewrite=NOERROR;A;1.1.1.1
+||a.example.org^$dnsrewrite=NOERROR;TXT;hello
+||nx.example.org^$dnsrewrite=NXDOMAIN;;
+||cname.example.org^$dnsrewrite=host.example.org
+`
+	filters := []dnsfilter.Filter{{ID: 0, Data: []byte(rules)}}
+	f := dnsfilter.New(&dnsfilter.Config{}, filters)
+	s := NewServer(f, nil, nil)
+	s.conf.UDPListenAddr = &net.UDPAddr{Port: 0}
+	s.conf.TCPListenAddr = &net.TCPAddr{Port: 0}
+	s.conf.UpstreamDNS = []string{"8.8.8.8:53"}
+	s.conf.FilteringConfig.ProtectionEnabled = true
+	s.conf.FilterHandler = func(clientAddr, clientID string, settings *dnsfilter.RequestFilteringSettings) {
+		settings.FilteringEnabled = true
+	}
+	u := &dns64Upstream{ipv4: map[string]net.IP{
+		"host.example.org.": {1, 2, 3, 4},
+	}}
+	err := s.startWithUpstream(u)
+	assert.Nil(t, err)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	req := &dns.Msg{}
+	req.SetQuestion("a.example.org.", dns.TypeA)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 1, len(reply.Answer))
+	a, ok := reply.Answer[0].(*dns.A)
+	assert.True(t, ok)
+	assert.True(t, a.A.Equal(net.IP{1, 1, 1, 1}))
+
+	req.SetQuestion("a.example.org.", dns.TypeTXT)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reply.Answer))
+	txt, ok := reply.Answer[0].(*dns.TXT)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"hello"}, txt.Txt)
+
+	// no records of this type
+	req.SetQuestion("a.example.org.", dns.TypeAAAA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Equal(t, 0, len(reply.Answer))
+
+	req.SetQuestion("nx.example.org.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	// CNAME target is resolved by upstream server
+	req.SetQuestion("cname.example.org.", dns.TypeA)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, "cname.example.org.", reply.Question[0].Name)
+	assert.Equal(t, 2, len(reply.Answer))
+	cname, ok := reply.Answer[0].(*dns.CNAME)
+	assert.True(t, ok)
+	assert.Equal(t, "host.example.org.", cname.Target)
+
+	_ = s.Stop()
+}
@@ -0,0 +1,63 @@
+package dnsforward
+
+import (
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// TTL of local TXT records (in seconds)
+const localTXTTTL = 60
+
+// SetTXT - set the values of local TXT record, e.g. "_acme-challenge.example.org" for ACME DNS-01 challenge
+// If values is empty, the record is removed.
+func (s *Server) SetTXT(name string, values []string) {
+	name = strings.ToLower(dns.Fqdn(name))
+	s.tableLock.Lock()
+	if len(values) == 0 {
+		delete(s.tableTXT, name)
+	} else {
+		if s.tableTXT == nil {
+			s.tableTXT = map[string][]string{}
+		}
+		s.tableTXT[name] = values
+	}
+	s.tableLock.Unlock()
+	log.Debug("DNS: TXT for %s: %v", name, values)
+}
+
+// Respond to TXT requests for local records
+func processLocalTXT(ctx *dnsContext) int {
+	s := ctx.srv
+	d := ctx.proxyCtx
+	q := d.Req.Question[0]
+	if q.Qtype != dns.TypeTXT {
+		return resultDone
+	}
+
+	s.tableLock.Lock()
+	values, ok := s.tableTXT[strings.ToLower(q.Name)]
+	s.tableLock.Unlock()
+	if !ok {
+		return resultDone
+	}
+
+	log.Debug("DNS: TXT for %s: %v (local)", q.Name, values)
+	resp := s.makeResponse(d.Req)
+	resp.Authoritative = true
+	for _, v := range values {
+		txt := &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Ttl:    localTXTTTL,
+				Class:  dns.ClassINET,
+			},
+			Txt: []string{v},
+		}
+		resp.Answer = append(resp.Answer, txt)
+	}
+	d.Res = resp
+	return resultDone
+}
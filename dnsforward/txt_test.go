@@ -0,0 +1,37 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalTXT(t *testing.T) {
+	s := createTestServer(t)
+	err := s.startWithUpstream(&dns64Upstream{ipv4: map[string]net.IP{}})
+	assert.Nil(t, err)
+	defer func() { _ = s.Stop() }()
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	s.SetTXT("_acme-challenge.Example.org", []string{"value"})
+
+	req := &dns.Msg{}
+	req.SetQuestion("_acme-challenge.example.org.", dns.TypeTXT)
+	reply, err := dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.True(t, reply.Authoritative)
+	assert.Equal(t, 1, len(reply.Answer))
+	txt, ok := reply.Answer[0].(*dns.TXT)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"value"}, txt.Txt)
+
+	// removed:  the request is passed to upstream server
+	s.SetTXT("_acme-challenge.example.org", nil)
+	reply, err = dns.Exchange(req, addr.String())
+	assert.Nil(t, err)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+}
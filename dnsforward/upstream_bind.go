@@ -0,0 +1,164 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/dnsstamps"
+	"github.com/miekg/dns"
+)
+
+// Outbound address for upstream queries:
+//  upstream_bind_address: IP address or network interface name, e.g. the address of a VPN tunnel.
+//  The sockets for the requests to upstream servers are bound to this address or interface,
+//   so DNS traffic leaves through it while DNS server still listens on bind_hosts.
+//  Binding to a network interface (SO_BINDTODEVICE) is supported on Linux only.
+//  Only plain DNS upstream servers (UDP, TCP, plain DNS stamps) can be bound:
+//   dnsproxy doesn't allow setting the local address for the encrypted ones,
+//   so they are rejected rather than bypassing the bound address.
+//  Per-client upstream servers are bound too (they are received by GetUpstreamAddrsByClient);
+//   if a client has an encrypted upstream server, the default upstream servers are used for this client.
+
+// upstreamBind is the parsed upstream_bind_address setting
+type upstreamBind struct {
+	ip      net.IP                                                 // source IP address
+	control func(network, address string, c syscall.RawConn) error // binds a socket to the network interface
+}
+
+// Parse upstream_bind_address setting
+// Return nil if the setting is empty
+func parseUpstreamBind(addr string) (*upstreamBind, error) {
+	if len(addr) == 0 {
+		return nil, nil
+	}
+
+	ip := net.ParseIP(addr)
+	if ip != nil {
+		return &upstreamBind{ip: ip}, nil
+	}
+
+	_, err := net.InterfaceByName(addr)
+	if err != nil {
+		return nil, fmt.Errorf("upstream_bind_address: %s is neither an IP address nor a network interface: %s", addr, err)
+	}
+	control, err := bindToDeviceControl(addr)
+	if err != nil {
+		return nil, fmt.Errorf("upstream_bind_address: %s", err)
+	}
+	return &upstreamBind{control: control}, nil
+}
+
+// Create a dialer for the network ("udp" or "tcp") with the bound local address
+func (b *upstreamBind) dialer(network string) *net.Dialer {
+	d := &net.Dialer{Timeout: DefaultTimeout, Control: b.control}
+	if b.ip != nil {
+		if network == "tcp" {
+			d.LocalAddr = &net.TCPAddr{IP: b.ip}
+		} else {
+			d.LocalAddr = &net.UDPAddr{IP: b.ip}
+		}
+	}
+	return d
+}
+
+// Return TRUE if the upstream server (without the domains specification) is plain DNS
+func isPlainUpstream(u string) bool {
+	if !strings.Contains(u, "://") || strings.HasPrefix(u, "tcp://") {
+		return true
+	}
+	if strings.HasPrefix(u, "sdns://") {
+		stamp, err := dnsstamps.NewServerStampFromString(u)
+		return err == nil && stamp.Proto == dnsstamps.StampProtoTypePlain
+	}
+	return false
+}
+
+// ValidateUpstreamBind - check upstream_bind_address setting along with the upstream servers it applies to
+func ValidateUpstreamBind(addr string, upstreams []string, privateZones []string) error {
+	if len(addr) == 0 {
+		return nil
+	}
+
+	_, err := parseUpstreamBind(addr)
+	if err != nil {
+		return err
+	}
+
+	if len(upstreams) == 0 {
+		upstreams = defaultDNS
+	}
+	for _, l := range append(stringArrayDup(upstreams), privateZones...) {
+		u, _, err := separateUpstream(l)
+		if err != nil {
+			return err
+		}
+		if u != "#" && !isPlainUpstream(u) {
+			return fmt.Errorf("upstream_bind_address: %s: only plain DNS upstream servers can be used", u)
+		}
+	}
+	return nil
+}
+
+// boundUpstream is a plain DNS upstream server which sends the requests from the bound local address
+type boundUpstream struct {
+	address   string // host:port
+	preferTCP bool
+	bind      *upstreamBind
+}
+
+// Create upstream object:  the callback function for proxy.ParseUpstreamsConfigEx()
+func (b *upstreamBind) addressToUpstream(address string, opts upstream.Options) (upstream.Upstream, error) {
+	if !isPlainUpstream(address) {
+		return nil, fmt.Errorf("only plain DNS upstream servers can be used with upstream_bind_address")
+	}
+
+	// parse the address and add the default port
+	u, err := upstream.AddressToUpstream(address, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &boundUpstream{
+		address:   u.Address(),
+		preferTCP: strings.HasPrefix(address, "tcp://"),
+		bind:      b,
+	}, nil
+}
+
+// Address returns the address of the upstream server
+func (u *boundUpstream) Address() string {
+	if u.preferTCP {
+		return "tcp://" + u.address
+	}
+	return u.address
+}
+
+// Exchange sends the request to the upstream server;  the request is retried over TCP if the response is truncated
+func (u *boundUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if u.preferTCP {
+		return u.exchange(m, "tcp")
+	}
+
+	reply, err := u.exchange(m, "udp")
+	if reply != nil && reply.Truncated {
+		log.Tracef("Truncated message was received, retrying over TCP, question: %s", m.Question[0].String())
+		reply, err = u.exchange(m, "tcp")
+	}
+	return reply, err
+}
+
+func (u *boundUpstream) exchange(m *dns.Msg, network string) (*dns.Msg, error) {
+	client := dns.Client{
+		Net:     network,
+		Timeout: DefaultTimeout,
+		Dialer:  u.bind.dialer(network),
+	}
+	if network == "udp" {
+		client.UDPSize = dns.MaxMsgSize
+	}
+	reply, _, err := client.Exchange(m, u.address)
+	return reply, err
+}
@@ -0,0 +1,19 @@
+package dnsforward
+
+import (
+	"syscall"
+)
+
+// Return the function which binds a socket to the network interface (SO_BINDTODEVICE)
+func bindToDeviceControl(ifname string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		err := c.Control(func(fd uintptr) {
+			opErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+		})
+		if err != nil {
+			return err
+		}
+		return opErr
+	}, nil
+}
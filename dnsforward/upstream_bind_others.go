@@ -0,0 +1,13 @@
+// +build !linux
+
+package dnsforward
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Binding a socket to the network interface isn't supported:  use the interface's IP address instead
+func bindToDeviceControl(ifname string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, fmt.Errorf("binding to network interface %s isn't supported on this OS, use its IP address", ifname)
+}
@@ -0,0 +1,62 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamBind(t *testing.T) {
+	// the test DNS server responds with the client's address
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+			A:   w.RemoteAddr().(*net.UDPAddr).IP,
+		})
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	bind, err := parseUpstreamBind("127.0.0.2")
+	assert.Nil(t, err)
+	conf, err := parseUpstreamsConfig([]string{conn.LocalAddr().String()}, nil, bind.addressToUpstream)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(conf.Upstreams))
+
+	req := createTestMessage("example.org.")
+	reply, err := conf.Upstreams[0].Exchange(req)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reply.Answer))
+	assert.Equal(t, "127.0.0.2", reply.Answer[0].(*dns.A).A.String())
+
+	// encrypted upstream servers can't be bound
+	_, err = parseUpstreamsConfig([]string{"tls://1.1.1.1"}, nil, bind.addressToUpstream)
+	assert.NotNil(t, err)
+
+	// per-client upstream servers
+	ups := newClientUpstreams([]string{conn.LocalAddr().String(), "tcp://1.1.1.1"}, bind.addressToUpstream)
+	assert.Equal(t, 2, len(ups))
+	assert.Equal(t, "tcp://1.1.1.1:53", ups[1].Address())
+	reply, err = ups[0].Exchange(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.2", reply.Answer[0].(*dns.A).A.String())
+	assert.Nil(t, newClientUpstreams([]string{"8.8.8.8", "tls://1.1.1.1"}, bind.addressToUpstream))
+}
+
+func TestValidateUpstreamBind(t *testing.T) {
+	assert.Nil(t, ValidateUpstreamBind("", []string{"tls://1.1.1.1"}, nil))
+	assert.Nil(t, ValidateUpstreamBind("10.0.0.2", []string{"8.8.8.8", "tcp://1.1.1.1", "[/lan/]#"},
+		[]string{"[/corp.example/]192.168.1.1"}))
+
+	assert.NotNil(t, ValidateUpstreamBind("10.0.0.2", []string{"8.8.8.8", "https://dns.adguard.com/dns-query"}, nil))
+	assert.NotNil(t, ValidateUpstreamBind("10.0.0.2", []string{"8.8.8.8"}, []string{"[/corp.example/]tls://192.168.1.1"}))
+	assert.NotNil(t, ValidateUpstreamBind("10.0.0.2", nil, nil)) // the default upstream server is DNS-over-HTTPS
+	assert.NotNil(t, ValidateUpstreamBind("nosuchiface0", []string{"8.8.8.8"}, nil))
+}
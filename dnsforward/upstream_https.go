@@ -0,0 +1,60 @@
+package dnsforward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// httpsUpstream is a DNS-over-HTTPS upstream server connected through the proxy or with the pinned certificates
+type httpsUpstream struct {
+	address string // https://host[:port]/path
+	client  *http.Client
+}
+
+// Address returns the address of the upstream server
+func (u *httpsUpstream) Address() string {
+	return u.address
+}
+
+// Exchange sends the request to the upstream server (RFC 8484, POST method)
+func (u *httpsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// the message ID is 0 in DNS-over-HTTPS requests
+	req := m.Copy()
+	req.Id = 0
+	buf, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, u.address, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/dns-message")
+	httpReq.Header.Set("Accept", "application/dns-message")
+	resp, err := u.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", u.address, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", u.address, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u.address, resp.Status)
+	}
+
+	reply := &dns.Msg{}
+	err = reply.Unpack(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", u.address, err)
+	}
+	reply.Id = m.Id
+	return reply, nil
+}
@@ -0,0 +1,71 @@
+package dnsforward
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SPKI pinning for encrypted upstream servers:
+//  upstream_pins: ["dns.adguard.com kXgj3TMa5ZRU/c+xsKnUIs2CKg4ZD5Rv3KuxZK4MP5U=", ...]
+//  Each line contains TLS server name and base64-encoded SHA-256 hash of a certificate's SubjectPublicKeyInfo.
+//  A server name may have several pins (e.g. a backup key):
+//   the connection is accepted if any certificate in the verified chain matches any of them.
+//  The certificate chain is still verified as usual.
+//  The pins apply to all DNS-over-TLS and DNS-over-HTTPS upstream servers (including DNS stamps) with this server name:
+//   per-client upstream servers, private zones, local PTR upstream servers.
+//  TLS sessions aren't resumed for the pinned servers so that every connection is checked.
+//  The upstream test command doesn't use this setting.
+
+// upstreamPins is the parsed upstream_pins setting:  server name -> SHA-256 hashes
+type upstreamPins map[string][][]byte
+
+// Parse upstream_pins setting
+func parseUpstreamPins(list []string) (upstreamPins, error) {
+	pins := upstreamPins{}
+	for _, line := range list {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("upstream_pins: %s: the format is \"host base64-sha256\"", line)
+		}
+		hash, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("upstream_pins: %s: invalid SHA-256 hash", line)
+		}
+		host := strings.ToLower(fields[0])
+		pins[host] = append(pins[host], hash)
+	}
+	return pins, nil
+}
+
+// ValidateUpstreamPins - check upstream_pins setting
+func ValidateUpstreamPins(list []string) error {
+	_, err := parseUpstreamPins(list)
+	return err
+}
+
+// Get the function which checks the server certificates against the pins for this server name
+// Return nil if the server has no pins
+func (pins upstreamPins) verifier(host string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	hashes := pins[strings.ToLower(host)]
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range hashes {
+					if bytes.Equal(h[:], pin) {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("%s: the certificate doesn't match the pinned public keys", host)
+	}
+}
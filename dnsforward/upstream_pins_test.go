@@ -0,0 +1,116 @@
+package dnsforward
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/ameshkov/dnsstamps"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUpstreamPins(t *testing.T) {
+	pin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	pins, err := parseUpstreamPins([]string{
+		"DNS.adguard.com " + pin,
+		"dns.adguard.com  " + pin,
+		"1.1.1.1 " + pin,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pins["dns.adguard.com"]))
+	assert.Equal(t, 1, len(pins["1.1.1.1"]))
+	assert.NotNil(t, pins.verifier("Dns.AdGuard.com"))
+	assert.Nil(t, pins.verifier("example.org"))
+
+	assert.Nil(t, ValidateUpstreamPins(nil))
+	assert.NotNil(t, ValidateUpstreamPins([]string{"dns.adguard.com"}))
+	assert.NotNil(t, ValidateUpstreamPins([]string{"dns.adguard.com " + pin + " " + pin}))
+	assert.NotNil(t, ValidateUpstreamPins([]string{"dns.adguard.com invalid!"}))
+	assert.NotNil(t, ValidateUpstreamPins([]string{"dns.adguard.com " + base64.StdEncoding.EncodeToString([]byte{1, 2, 3})}))
+}
+
+func TestUpstreamPins(t *testing.T) {
+	tlsConfig, certPem, _ := createServerTLSConfig(t)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPem)
+	upstream.RootCAs = roots
+	defer func() { upstream.RootCAs = nil }()
+
+	block, _ := pem.Decode(certPem)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	goodPin := tlsServerName + " " + base64.StdEncoding.EncodeToString(h[:])
+	badPin := tlsServerName + " " + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	// DNS-over-TLS server
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	assert.Nil(t, err)
+	srv := &testDoTServer{listener: l}
+	go srv.serve()
+	defer l.Close()
+
+	// DNS-over-HTTPS server
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &dns.Msg{}
+		if req.Unpack(body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := testAnswer(req).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(data)
+	}))
+	doh.TLS = tlsConfig
+	doh.StartTLS()
+	defer doh.Close()
+
+	// the host names are resolved by DNS stamps
+	dot := dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypeTLS,
+		ServerAddrStr: l.Addr().String(),
+		ProviderName:  tlsServerName + ":" + strconv.Itoa(l.Addr().(*net.TCPAddr).Port),
+	}
+	dohStamp := dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypeDoH,
+		ServerAddrStr: doh.Listener.Addr().String(),
+		ProviderName:  tlsServerName + ":" + strconv.Itoa(doh.Listener.Addr().(*net.TCPAddr).Port),
+		Path:          "/dns-query",
+	}
+	addrs := []string{dot.String(), dohStamp.String()}
+
+	pins, _ := parseUpstreamPins([]string{badPin, goodPin})
+	conf, err := parseUpstreamsConfig(addrs, nil, newTLSUpstreams(nil, nil, pins).addressToUpstream)
+	assert.Nil(t, err)
+	_, ok := conf.Upstreams[1].(*httpsUpstream)
+	assert.True(t, ok) // DNS-over-HTTPS server with the pinned certificate
+	for _, u := range conf.Upstreams {
+		reply, err := u.Exchange(createTestMessage("example.org."))
+		assert.Nil(t, err, u.Address())
+		if err == nil {
+			assert.Equal(t, "1.2.3.4", reply.Answer[0].(*dns.A).A.String())
+		}
+	}
+
+	pins, _ = parseUpstreamPins([]string{badPin})
+	conf, err = parseUpstreamsConfig(addrs, nil, newTLSUpstreams(nil, nil, pins).addressToUpstream)
+	assert.Nil(t, err)
+	for _, u := range conf.Upstreams {
+		_, err := u.Exchange(createTestMessage("example.org."))
+		assert.NotNil(t, err, u.Address())
+		if err != nil {
+			assert.Contains(t, err.Error(), "pinned")
+		}
+	}
+}
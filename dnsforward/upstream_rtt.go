@@ -0,0 +1,317 @@
+package dnsforward
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Fastest upstream server:
+//  fastest_upstream: the requests are sent to the upstream server with the lowest round-trip time first;
+//   if it fails, the other upstream servers are used.
+//  All upstream servers are probed periodically so that their round-trip time is up to date.
+//  The setting is ignored if fastest_addr or parallel_requests is enabled:  then the requests are sent to all servers.
+// Upstream health checks:
+//  upstream_check_interval: upstream servers are probed every N seconds (0: health checks are disabled).
+//  An upstream server is marked down after upstreamMaxFailures consecutive failed requests (including probes),
+//   the requests aren't sent to it while it's down.
+//  It's marked up again after the first successful probe.
+//  If all upstream servers for the request are down, all of them are used.
+
+// How often upstream servers are probed in "fastest_upstream" mode if health checks are disabled
+const upstreamProbeInterval = 1 * time.Minute
+
+// The number of consecutive failed requests after which an upstream server is marked down
+const upstreamMaxFailures = 3
+
+// Round-trip time statistics of an upstream server
+type upstreamRTT struct {
+	rtt      time.Duration // moving average of the request time
+	requests uint64        // number of requests (including probes)
+	errors   uint64        // number of failed requests
+	lastErr  string        // the last error message
+
+	failures  uint      // number of consecutive failed requests
+	down      bool      // the upstream server is down
+	downSince time.Time // when the upstream server was marked down
+
+	latency    [len(latencyBuckets) + 1]uint64 // histogram of the successful request time
+	latencySum time.Duration                   // the total time of the successful requests
+}
+
+// rttTracker measures round-trip time of the requests to upstream servers
+type rttTracker struct {
+	lock  sync.Mutex
+	stats map[string]*upstreamRTT // upstream address -> statistics
+}
+
+// rttUpstream passes the requests to the real upstream and measures the request time
+type rttUpstream struct {
+	upstream.Upstream
+	tracker *rttTracker
+}
+
+// Exchange implements upstream.Upstream interface
+func (u *rttUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	start := time.Now()
+	resp, err := u.Upstream.Exchange(m)
+	u.tracker.update(u.Address(), time.Since(start), err)
+	return resp, err
+}
+
+func newRTTTracker() *rttTracker {
+	return &rttTracker{
+		stats: map[string]*upstreamRTT{},
+	}
+}
+
+// Wrap upstream objects so that their requests are measured
+func (t *rttTracker) wrap(ups []upstream.Upstream) []upstream.Upstream {
+	if ups == nil {
+		return nil
+	}
+
+	wrapped := make([]upstream.Upstream, len(ups))
+	for i, u := range ups {
+		wrapped[i] = &rttUpstream{Upstream: u, tracker: t}
+	}
+	return wrapped
+}
+
+// Update statistics for the upstream server
+// A failed request is counted as if it took DefaultTimeout
+func (t *rttTracker) update(addr string, elapsed time.Duration, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	st, ok := t.stats[addr]
+	if !ok {
+		st = &upstreamRTT{}
+		t.stats[addr] = st
+	}
+
+	st.requests++
+	if err != nil {
+		st.errors++
+		st.lastErr = err.Error()
+		elapsed = DefaultTimeout
+		st.failures++
+		if !st.down && st.failures >= upstreamMaxFailures {
+			st.down = true
+			st.downSince = time.Now()
+			log.Info("DNS: upstream %s is down: %s", addr, err)
+		}
+	} else {
+		st.latency[latencyBucket(elapsed)]++
+		st.latencySum += elapsed
+		st.failures = 0
+		if st.down {
+			st.down = false
+			log.Info("DNS: upstream %s is up", addr)
+		}
+	}
+
+	if st.requests == 1 {
+		st.rtt = elapsed
+	} else {
+		st.rtt = (st.rtt*3 + elapsed) / 4
+	}
+}
+
+// Get the measured round-trip time.  Returns false if the upstream wasn't used yet.
+func (t *rttTracker) get(addr string) (upstreamRTT, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	st, ok := t.stats[addr]
+	if !ok {
+		return upstreamRTT{}, false
+	}
+	return *st, true
+}
+
+// Return the upstream servers which aren't down
+// If all of them are down, return the original list
+func (t *rttTracker) alive(ups []upstream.Upstream) []upstream.Upstream {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	alive := []upstream.Upstream{}
+	for _, u := range ups {
+		st, ok := t.stats[u.Address()]
+		if !ok || !st.down {
+			alive = append(alive, u)
+		}
+	}
+	if len(alive) == 0 {
+		return ups
+	}
+	return alive
+}
+
+// Return a copy of the upstreams list sorted by round-trip time, the fastest first
+// Upstream servers which weren't measured yet go first so that they are measured sooner
+func (t *rttTracker) sort(ups []upstream.Upstream) []upstream.Upstream {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	rtt := func(u upstream.Upstream) time.Duration {
+		st, ok := t.stats[u.Address()]
+		if !ok {
+			return 0
+		}
+		return st.rtt
+	}
+
+	sorted := make([]upstream.Upstream, len(ups))
+	copy(sorted, ups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rtt(sorted[i]) < rtt(sorted[j])
+	})
+	return sorted
+}
+
+// Send a test request to each upstream server in parallel
+func (t *rttTracker) probe(ups []upstream.Upstream) {
+	wg := sync.WaitGroup{}
+	for _, u := range ups {
+		wg.Add(1)
+		go func(u upstream.Upstream) {
+			defer wg.Done()
+			req := dns.Msg{}
+			req.Id = dns.Id()
+			req.RecursionDesired = true
+			req.Question = []dns.Question{
+				{Name: "ipv4only.arpa.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			}
+			_, err := u.Exchange(&req) // the request is measured by rttUpstream
+			if err != nil {
+				log.Debug("DNS: probe of %s failed: %s", u.Address(), err)
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// Probe upstream servers periodically until 'stop' channel is closed
+func (t *rttTracker) probeLoop(ups []upstream.Upstream, interval time.Duration, stop chan bool) {
+	for {
+		t.probe(ups)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+			// continue
+		}
+	}
+}
+
+// Get all configured upstream servers without duplicates
+func (s *Server) allUpstreams() []upstream.Upstream {
+	all := []upstream.Upstream{}
+	found := map[string]bool{}
+	add := func(ups []upstream.Upstream) {
+		for _, u := range ups {
+			if !found[u.Address()] {
+				found[u.Address()] = true
+				all = append(all, u)
+			}
+		}
+	}
+
+	add(s.conf.Upstreams)
+	domains := []string{}
+	for domain := range s.conf.DomainsReservedUpstreams {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		add(s.conf.DomainsReservedUpstreams[domain])
+	}
+	return all
+}
+
+// Start probing upstream servers in background
+func (s *Server) startProbing() {
+	interval := upstreamProbeInterval
+	if s.conf.UpstreamCheckInterval != 0 {
+		interval = time.Duration(s.conf.UpstreamCheckInterval) * time.Second
+	}
+	s.probeStop = make(chan bool)
+	go s.upstreamRTT.probeLoop(s.allUpstreams(), interval, s.probeStop)
+}
+
+// Stop probing upstream servers
+func (s *Server) stopProbing() {
+	if s.probeStop != nil {
+		close(s.probeStop)
+		s.probeStop = nil
+	}
+}
+
+// Get upstream servers for the host name;  skip the servers which are down if health checks are enabled
+func (s *Server) getAliveUpstreamsForDomain(host string) []upstream.Upstream {
+	ups := s.getUpstreamsForDomain(host)
+	if s.conf.UpstreamCheckInterval == 0 {
+		return ups
+	}
+	return s.upstreamRTT.alive(ups)
+}
+
+// Get upstream servers for the host name, the same way dnsproxy does it
+func (s *Server) getUpstreamsForDomain(host string) []upstream.Upstream {
+	if len(s.conf.DomainsReservedUpstreams) == 0 {
+		return s.conf.Upstreams
+	}
+
+	dotsCount := strings.Count(host, ".")
+	if dotsCount < 2 {
+		return s.conf.DomainsReservedUpstreams[proxy.UnqualifiedNames]
+	}
+
+	for i := 1; i <= dotsCount; i++ {
+		h := strings.SplitAfterN(host, ".", i)
+		name := h[i-1]
+		if ups, ok := s.conf.DomainsReservedUpstreams[strings.ToLower(name)]; ok {
+			if ups == nil {
+				// domain was excluded from reserved upstreams querying
+				return s.conf.Upstreams
+			}
+			return ups
+		}
+	}
+
+	return s.conf.Upstreams
+}
+
+// Return TRUE if the requests are sent to the upstream server with the lowest round-trip time first
+func (s *Server) useFastestUpstream() bool {
+	return s.conf.FastestUpstream && !s.conf.FastestAddrAlgo && !s.conf.AllServers
+}
+
+// Pass the request to the upstream server with the lowest round-trip time
+// If it fails, try the other upstream servers
+func (s *Server) resolveWithFastestUpstream(d *proxy.DNSContext) error {
+	ups := s.upstreamRTT.sort(s.getAliveUpstreamsForDomain(d.Req.Question[0].Name))
+	if len(ups) <= 1 {
+		return s.dnsProxy.Resolve(d)
+	}
+
+	d.Upstreams = ups[:1]
+	err := s.dnsProxy.Resolve(d)
+	if err == nil {
+		return nil
+	}
+
+	log.Debug("DNS: fastest upstream %s failed: %s", ups[0].Address(), err)
+	d.Upstreams = ups[1:]
+	d.Res = nil
+	return s.dnsProxy.Resolve(d)
+}
@@ -0,0 +1,154 @@
+package dnsforward
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// delayUpstream replies after the specified delay
+type delayUpstream struct {
+	addr  string
+	delay time.Duration
+	fail  bool
+}
+
+func (u *delayUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	time.Sleep(u.delay)
+	if u.fail {
+		return nil, fmt.Errorf("upstream %s failed", u.addr)
+	}
+	resp := dns.Msg{}
+	resp.SetReply(m)
+	return &resp, nil
+}
+
+func (u *delayUpstream) Address() string {
+	return u.addr
+}
+
+func TestUpstreamRTT(t *testing.T) {
+	tr := newRTTTracker()
+	ups := tr.wrap([]upstream.Upstream{
+		&delayUpstream{addr: "slow", delay: 50 * time.Millisecond},
+		&delayUpstream{addr: "fast", delay: 1 * time.Millisecond},
+		&delayUpstream{addr: "failing", fail: true},
+	})
+	assert.Nil(t, tr.wrap(nil))
+
+	// not measured yet - keep the original order
+	sorted := tr.sort(ups)
+	assert.Equal(t, "slow", sorted[0].Address())
+	assert.Equal(t, "fast", sorted[1].Address())
+
+	tr.probe(ups)
+
+	sorted = tr.sort(ups)
+	assert.Equal(t, "fast", sorted[0].Address())
+	assert.Equal(t, "slow", sorted[1].Address())
+	assert.Equal(t, "failing", sorted[2].Address())
+	assert.Equal(t, "slow", ups[0].Address()) // the original list isn't modified
+
+	st, ok := tr.get("failing")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), st.requests)
+	assert.Equal(t, uint64(1), st.errors)
+	assert.Equal(t, DefaultTimeout, st.rtt)
+	assert.Equal(t, "upstream failing failed", st.lastErr)
+
+	st, ok = tr.get("slow")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), st.errors)
+	assert.True(t, st.rtt >= 50*time.Millisecond)
+
+	_, ok = tr.get("unknown")
+	assert.False(t, ok)
+
+	// moving average
+	tr.update("fast", 100*time.Millisecond, nil)
+	st, _ = tr.get("fast")
+	assert.True(t, st.rtt >= 25*time.Millisecond)
+	assert.Equal(t, uint64(2), st.requests)
+}
+
+func TestGetUpstreamsForDomain(t *testing.T) {
+	def := []upstream.Upstream{&delayUpstream{addr: "default"}}
+	reserved := []upstream.Upstream{&delayUpstream{addr: "reserved"}}
+	s := Server{}
+	s.conf.Upstreams = def
+	assert.Equal(t, def, s.getUpstreamsForDomain("host.example.org."))
+
+	s.conf.DomainsReservedUpstreams = map[string][]upstream.Upstream{
+		"example.org.":     reserved,
+		"sub.example.org.": nil,
+	}
+	assert.Equal(t, reserved, s.getUpstreamsForDomain("host.example.org."))
+	assert.Equal(t, def, s.getUpstreamsForDomain("host.sub.example.org."))
+	assert.Equal(t, def, s.getUpstreamsForDomain("host.example.com."))
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	tr := newRTTTracker()
+	failing := &delayUpstream{addr: "failing", fail: true}
+	ups := tr.wrap([]upstream.Upstream{
+		failing,
+		&delayUpstream{addr: "good"},
+	})
+	s := Server{upstreamRTT: tr}
+	s.conf.Upstreams = ups
+
+	// the upstream server is marked down after several consecutive failures
+	for i := 0; i != upstreamMaxFailures-1; i++ {
+		tr.probe(ups)
+	}
+	st, _ := tr.get("failing")
+	assert.False(t, st.down)
+	tr.probe(ups)
+	st, _ = tr.get("failing")
+	assert.True(t, st.down)
+	assert.False(t, st.downSince.IsZero())
+
+	// health checks are disabled:  all upstream servers are used
+	assert.Equal(t, 2, len(s.getAliveUpstreamsForDomain("example.org.")))
+
+	s.conf.UpstreamCheckInterval = 60
+	alive := s.getAliveUpstreamsForDomain("example.org.")
+	assert.Equal(t, 1, len(alive))
+	assert.Equal(t, "good", alive[0].Address())
+
+	// all upstream servers are down:  use all of them
+	assert.Equal(t, 1, len(tr.alive(ups[:1])))
+
+	// the upstream server is up again after a successful probe
+	failing.fail = false
+	tr.probe(ups)
+	st, _ = tr.get("failing")
+	assert.False(t, st.down)
+	assert.Equal(t, uint(0), st.failures)
+	assert.Equal(t, 2, len(s.getAliveUpstreamsForDomain("example.org.")))
+}
+
+func TestUseFastestUpstream(t *testing.T) {
+	s := &Server{}
+	assert.False(t, s.useFastestUpstream())
+
+	s.conf.FastestUpstream = true
+	assert.True(t, s.useFastestUpstream())
+
+	// the requests are sent to all upstream servers in these modes
+	s.conf.FastestAddrAlgo = true
+	assert.False(t, s.useFastestUpstream())
+	s.conf.FastestAddrAlgo = false
+	s.conf.AllServers = true
+	assert.False(t, s.useFastestUpstream())
+
+	// fastest_addr alone doesn't choose the upstream server by round-trip time
+	s.conf.AllServers = false
+	s.conf.FastestUpstream = false
+	s.conf.FastestAddrAlgo = true
+	assert.False(t, s.useFastestUpstream())
+}
@@ -0,0 +1,463 @@
+package dnsforward
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/dnsstamps"
+	"github.com/miekg/dns"
+)
+
+// DNS-over-TLS connection pool:
+//  Each DNS-over-TLS upstream server keeps its TLS connections open and reuses them,
+//   so a burst of requests doesn't pay for TCP and TLS handshakes every time.
+//  A connection which is idle for longer than tlsIdleTimeout is closed.
+//  TLS sessions are cached:  a new connection resumes the previous session if the server supports it
+//   (except the servers with the pinned certificates, see upstream_pins.go).
+//  After a failed connection attempt the next one is delayed (1 sec, doubled each time up to 1 min);
+//   until then the requests fail immediately.
+//  If a reused connection has been closed by the server, the request is retried over a new connection.
+//  The pool statistics are returned by /control/upstreams_status.
+//  With upstream_proxy the connections are made through the proxy.
+//  DNS-over-HTTPS upstream servers are created here too if they use the proxy or the pinned certificates;
+//   their connections are kept by HTTP client.
+
+const (
+	tlsIdleTimeout     = 60 * time.Second // close the connections idle for this time
+	tlsMaxIdleConns    = 8                // max. number of idle connections per upstream server
+	tlsMinRetryBackoff = 1 * time.Second
+	tlsMaxRetryBackoff = 1 * time.Minute
+)
+
+// tlsUpstreams creates DNS-over-TLS and DNS-over-HTTPS upstream objects and keeps them so that their connections are reused
+type tlsUpstreams struct {
+	resolvers    []*upstream.Resolver // bootstrap DNS servers
+	dial         dialContextFunc      // connects through the proxy (nil: directly)
+	pins         upstreamPins
+	sessionCache tls.ClientSessionCache
+
+	lock      sync.Mutex
+	upstreams map[string]upstream.Upstream // address -> upstream object
+}
+
+// Create tlsUpstreams object
+// dial: the function which connects to "host:port" (nil: connect directly, host names are resolved by the bootstrap DNS servers)
+func newTLSUpstreams(bootstrap []string, dial dialContextFunc, pins upstreamPins) *tlsUpstreams {
+	t := &tlsUpstreams{
+		dial:         dial,
+		pins:         pins,
+		sessionCache: tls.NewLRUClientSessionCache(0),
+		upstreams:    map[string]upstream.Upstream{},
+	}
+	if dial == nil {
+		for _, b := range bootstrap {
+			t.resolvers = append(t.resolvers, upstream.NewResolver(b, DefaultTimeout))
+		}
+		if len(t.resolvers) == 0 {
+			t.resolvers = append(t.resolvers, upstream.NewResolver("", DefaultTimeout))
+		}
+	}
+	return t
+}
+
+// Return TRUE if the upstream server is DNS-over-TLS or DNS-over-HTTPS
+// Return the URL address and the server IP address from the DNS stamp
+func parseEncryptedUpstream(address string) (u string, serverIP net.IP, ok bool) {
+	u = address
+	if strings.HasPrefix(u, "sdns://") {
+		stamp, err := dnsstamps.NewServerStampFromString(u)
+		if err != nil {
+			return "", nil, false
+		}
+		switch stamp.Proto {
+		case dnsstamps.StampProtoTypeTLS:
+			u = "tls://" + stamp.ProviderName
+		case dnsstamps.StampProtoTypeDoH:
+			u = "https://" + stamp.ProviderName + stamp.Path
+		default:
+			return "", nil, false
+		}
+		host, _, err := net.SplitHostPort(stamp.ServerAddrStr)
+		if err != nil {
+			host = stamp.ServerAddrStr
+		}
+		serverIP = net.ParseIP(host)
+	}
+	return u, serverIP, strings.HasPrefix(u, "tls://") || strings.HasPrefix(u, "https://")
+}
+
+// Create upstream object:  the callback function for proxy.ParseUpstreamsConfigEx()
+// DNS-over-TLS upstream servers use the connection pool.
+// DNS-over-HTTPS upstream servers are created here if they are connected through the proxy or if their certificates are pinned.
+// The others are created as usual.
+func (t *tlsUpstreams) addressToUpstream(address string, opts upstream.Options) (upstream.Upstream, error) {
+	u, serverIP, ok := parseEncryptedUpstream(address)
+	if !ok {
+		return upstream.AddressToUpstream(address, opts)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.Hostname()) == 0 {
+		return nil, fmt.Errorf("%s: no host name", address)
+	}
+	if parsed.Scheme == "https" && t.dial == nil && len(t.pins[strings.ToLower(parsed.Hostname())]) == 0 {
+		return upstream.AddressToUpstream(address, opts)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ups, ok := t.upstreams[address]
+	if ok {
+		return ups, nil
+	}
+
+	if parsed.Scheme == "tls" {
+		host := parsed.Host
+		if len(parsed.Port()) == 0 {
+			host = net.JoinHostPort(parsed.Hostname(), "853")
+		}
+		ups = &tlsUpstream{
+			address:   u,
+			host:      host,
+			tlsConfig: t.newTLSConfig(parsed.Hostname()),
+			dial:      t.dialContext(serverIP),
+		}
+	} else {
+		transport := &http.Transport{
+			DialContext:       t.dialContext(serverIP),
+			TLSClientConfig:   t.newTLSConfig(parsed.Hostname()),
+			ForceAttemptHTTP2: true,
+			MaxIdleConns:      1,
+			IdleConnTimeout:   5 * time.Minute,
+		}
+		ups = &httpsUpstream{
+			address: u,
+			client:  &http.Client{Transport: transport, Timeout: DefaultTimeout},
+		}
+	}
+	t.upstreams[address] = ups
+	return ups, nil
+}
+
+// Create TLS configuration for the server name
+func (t *tlsUpstreams) newTLSConfig(host string) *tls.Config {
+	c := &tls.Config{
+		ServerName:         host,
+		RootCAs:            upstream.RootCAs,
+		CipherSuites:       upstream.CipherSuites,
+		MinVersion:         tls.VersionTLS12,
+		ClientSessionCache: t.sessionCache,
+	}
+	verify := t.pins.verifier(host)
+	if verify != nil {
+		c.VerifyPeerCertificate = verify
+		// the certificates aren't verified on the resumed sessions
+		c.ClientSessionCache = nil
+	}
+	return c
+}
+
+// Close all idle connections
+func (t *tlsUpstreams) close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, u := range t.upstreams {
+		switch u := u.(type) {
+		case *tlsUpstream:
+			u.close()
+		case *httpsUpstream:
+			u.client.CloseIdleConnections()
+		}
+	}
+}
+
+// Get the function which connects to the upstream server (TCP)
+// serverIP: the server IP address from DNS stamp (optional)
+func (t *tlsUpstreams) dialContext(serverIP net.IP) dialContextFunc {
+	if t.dial != nil {
+		return t.dial
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs := []string{}
+		if serverIP != nil {
+			addrs = append(addrs, net.JoinHostPort(serverIP.String(), port))
+		} else if net.ParseIP(host) != nil {
+			addrs = append(addrs, addr)
+		} else {
+			ips, err := upstream.LookupParallel(ctx, t.resolvers, host)
+			if err != nil {
+				return nil, fmt.Errorf("failed to lookup %s: %s", host, err)
+			}
+			for _, ip := range ips {
+				addrs = append(addrs, net.JoinHostPort(ip.IP.String(), port))
+			}
+			if len(addrs) == 0 {
+				return nil, fmt.Errorf("couldn't find any IP address for host %s", host)
+			}
+		}
+
+		d := net.Dialer{}
+		for _, a := range addrs {
+			var conn net.Conn
+			conn, err = d.DialContext(ctx, network, a)
+			if err == nil {
+				return conn, nil
+			}
+			log.Tracef("DNS: %s: %s", addr, err)
+		}
+		return nil, err
+	}
+}
+
+// tlsConnStats is the statistics of the connection pool
+type tlsConnStats struct {
+	active      int    // open connections (idle and in use)
+	idle        int    // idle connections
+	handshakes  uint64 // full TLS handshakes
+	resumptions uint64 // resumed TLS sessions
+	errors      uint64 // failed connection attempts
+}
+
+// tlsUpstream is a DNS-over-TLS upstream server with the connection pool
+type tlsUpstream struct {
+	address   string // tls://host[:port]
+	host      string // host:port
+	tlsConfig *tls.Config
+	dial      dialContextFunc
+
+	lock       sync.Mutex
+	idle       []tlsIdleConn // the most recently used connection is the last one
+	idleTimer  *time.Timer   // closes the expired idle connections
+	stats      tlsConnStats
+	closed     bool
+	failures   int       // consecutive failed connection attempts
+	retryAfter time.Time // don't connect until this time
+	lastErr    error     // the last connection error
+}
+
+type tlsIdleConn struct {
+	conn  *tls.Conn
+	since time.Time
+}
+
+// Address returns the address of the upstream server
+func (u *tlsUpstream) Address() string {
+	return u.address
+}
+
+// Exchange sends the request to the upstream server over a pooled connection
+func (u *tlsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	conn, reused, err := u.getConn()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", u.address, err)
+	}
+
+	reply, err := exchangeConn(conn, m)
+	if err != nil && reused {
+		// the server might have closed the idle connection
+		log.Tracef("DNS: %s: the pooled connection is expired: %s", u.address, err)
+		u.discard(conn)
+		conn, err = u.newConn()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", u.address, err)
+		}
+		reply, err = exchangeConn(conn, m)
+	}
+	if err != nil {
+		u.discard(conn)
+		return nil, fmt.Errorf("%s: %s", u.address, err)
+	}
+
+	u.put(conn)
+	return reply, nil
+}
+
+func exchangeConn(conn *tls.Conn, m *dns.Msg) (*dns.Msg, error) {
+	_ = conn.SetDeadline(time.Now().Add(DefaultTimeout))
+	dc := &dns.Conn{Conn: conn}
+	err := dc.WriteMsg(m)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := dc.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Id != m.Id {
+		return nil, dns.ErrId
+	}
+	return reply, nil
+}
+
+// Get an idle connection or create a new one
+func (u *tlsUpstream) getConn() (*tls.Conn, bool, error) {
+	now := time.Now()
+	u.lock.Lock()
+	for len(u.idle) != 0 {
+		last := len(u.idle) - 1
+		ic := u.idle[last]
+		u.idle = u.idle[:last]
+		if now.Sub(ic.since) < tlsIdleTimeout {
+			u.lock.Unlock()
+			return ic.conn, true, nil
+		}
+		ic.conn.Close()
+		u.stats.active--
+	}
+	u.lock.Unlock()
+
+	conn, err := u.newConn()
+	return conn, false, err
+}
+
+// Create a new connection
+// After a failure, the next attempts are delayed
+func (u *tlsUpstream) newConn() (*tls.Conn, error) {
+	u.lock.Lock()
+	if time.Now().Before(u.retryAfter) {
+		err := u.lastErr
+		u.lock.Unlock()
+		return nil, fmt.Errorf("connection is delayed after the failure: %s", err)
+	}
+	u.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	conn, err := u.handshake(ctx)
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if err != nil {
+		u.stats.errors++
+		u.failures++
+		backoff := tlsMinRetryBackoff << uint(u.failures-1)
+		if backoff > tlsMaxRetryBackoff || backoff <= 0 {
+			backoff = tlsMaxRetryBackoff
+		}
+		u.retryAfter = time.Now().Add(backoff)
+		u.lastErr = err
+		return nil, err
+	}
+
+	u.failures = 0
+	u.retryAfter = time.Time{}
+	u.stats.active++
+	if conn.ConnectionState().DidResume {
+		u.stats.resumptions++
+	} else {
+		u.stats.handshakes++
+	}
+	return conn, nil
+}
+
+func (u *tlsUpstream) handshake(ctx context.Context) (*tls.Conn, error) {
+	raw, err := u.dial(ctx, "tcp", u.host)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, u.tlsConfig)
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	err = conn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Return the connection to the pool
+func (u *tlsUpstream) put(conn *tls.Conn) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.closed || len(u.idle) >= tlsMaxIdleConns {
+		conn.Close()
+		u.stats.active--
+		return
+	}
+	u.idle = append(u.idle, tlsIdleConn{conn: conn, since: time.Now()})
+	if u.idleTimer == nil {
+		u.idleTimer = time.AfterFunc(tlsIdleTimeout, u.closeExpired)
+	}
+}
+
+// Close the expired idle connections
+// The timer is restarted while there are idle connections
+func (u *tlsUpstream) closeExpired() {
+	now := time.Now()
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.idleTimer = nil
+	idle := u.idle[:0]
+	for _, ic := range u.idle {
+		if now.Sub(ic.since) < tlsIdleTimeout {
+			idle = append(idle, ic)
+			continue
+		}
+		ic.conn.Close()
+		u.stats.active--
+	}
+	u.idle = idle
+	if len(u.idle) != 0 && !u.closed {
+		// the oldest connection expires first
+		u.idleTimer = time.AfterFunc(tlsIdleTimeout-now.Sub(u.idle[0].since), u.closeExpired)
+	}
+}
+
+// Close the broken connection
+func (u *tlsUpstream) discard(conn *tls.Conn) {
+	conn.Close()
+	u.lock.Lock()
+	u.stats.active--
+	u.lock.Unlock()
+}
+
+// Close idle connections;  the connections in use are closed when they are returned
+func (u *tlsUpstream) close() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, ic := range u.idle {
+		ic.conn.Close()
+		u.stats.active--
+	}
+	u.idle = nil
+	u.closed = true
+	if u.idleTimer != nil {
+		u.idleTimer.Stop()
+		u.idleTimer = nil
+	}
+}
+
+// Get the statistics
+// The expired idle connections aren't counted
+func (u *tlsUpstream) getStats() tlsConnStats {
+	now := time.Now()
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	st := u.stats
+	for _, ic := range u.idle {
+		if now.Sub(ic.since) < tlsIdleTimeout {
+			st.idle++
+		} else {
+			st.active--
+		}
+	}
+	return st
+}
@@ -0,0 +1,140 @@
+package dnsforward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/ameshkov/dnsstamps"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDoTServer is DNS-over-TLS server which keeps track of the client connections
+type testDoTServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+func (srv *testDoTServer) serve() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.lock.Lock()
+		srv.conns = append(srv.conns, conn)
+		srv.lock.Unlock()
+		go func() {
+			dc := &dns.Conn{Conn: conn}
+			for {
+				req, err := dc.ReadMsg()
+				if err != nil {
+					return
+				}
+				_ = dc.WriteMsg(testAnswer(req))
+			}
+		}()
+	}
+}
+
+// Close all client connections;  return the number of connections
+func (srv *testDoTServer) closeConns() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	n := len(srv.conns)
+	for _, c := range srv.conns {
+		c.Close()
+	}
+	srv.conns = nil
+	return n
+}
+
+func TestTLSUpstreamPool(t *testing.T) {
+	tlsConfig, certPem, _ := createServerTLSConfig(t)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPem)
+	upstream.RootCAs = roots
+	defer func() { upstream.RootCAs = nil }()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	assert.Nil(t, err)
+	srv := &testDoTServer{listener: l}
+	go srv.serve()
+	defer l.Close()
+
+	// the host name is resolved by the DNS stamp
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	dot := dnsstamps.ServerStamp{
+		Proto:         dnsstamps.StampProtoTypeTLS,
+		ServerAddrStr: l.Addr().String(),
+		ProviderName:  tlsServerName + ":" + port,
+	}
+	stamp := dot.String()
+	pool := newTLSUpstreams(nil, nil, nil)
+	ups, err := pool.addressToUpstream(stamp, upstream.Options{Timeout: DefaultTimeout})
+	assert.Nil(t, err)
+	assert.Equal(t, "tls://"+tlsServerName+":"+port, ups.Address())
+	u, ok := ups.(*tlsUpstream)
+	assert.True(t, ok)
+
+	// the same object is returned for the same address
+	ups2, _ := pool.addressToUpstream(stamp, upstream.Options{Timeout: DefaultTimeout})
+	assert.True(t, ups2 == ups)
+
+	// the connection is reused
+	for i := 0; i < 5; i++ {
+		reply, err := u.Exchange(createTestMessage("example.org."))
+		assert.Nil(t, err)
+		if err == nil {
+			assert.Equal(t, "1.2.3.4", reply.Answer[0].(*dns.A).A.String())
+		}
+	}
+	st := u.getStats()
+	assert.Equal(t, 1, st.active)
+	assert.Equal(t, 1, st.idle)
+	assert.Equal(t, uint64(1), st.handshakes)
+	assert.Equal(t, 1, srv.closeConns())
+
+	// the request is retried over a new connection;  TLS session is resumed
+	reply, err := u.Exchange(createTestMessage("example.org."))
+	assert.Nil(t, err)
+	assert.NotNil(t, reply)
+	st = u.getStats()
+	assert.Equal(t, 1, st.active)
+	assert.Equal(t, uint64(1), st.handshakes)
+	assert.Equal(t, uint64(1), st.resumptions)
+
+	pool.close()
+	st = u.getStats()
+	assert.Equal(t, 0, st.active)
+	assert.Equal(t, 0, st.idle)
+}
+
+func TestTLSUpstreamBackoff(t *testing.T) {
+	// nobody listens on this port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	pool := newTLSUpstreams(nil, nil, nil)
+	ups, err := pool.addressToUpstream("tls://"+addr, upstream.Options{Timeout: DefaultTimeout})
+	assert.Nil(t, err)
+	u := ups.(*tlsUpstream)
+
+	_, err = u.Exchange(createTestMessage("example.org."))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(1), u.getStats().errors)
+
+	// the next attempt is delayed
+	_, err = u.Exchange(createTestMessage("example.org."))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "delayed")
+	assert.Equal(t, uint64(1), u.getStats().errors)
+}
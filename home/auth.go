@@ -60,13 +60,41 @@ type Auth struct {
 	sessions   map[string]*session // session name -> session data
 	lock       sync.Mutex
 	users      []User
+	tokens     []APIToken
 	sessionTTL uint32 // in seconds
+	limiter    *authRateLimiter
+
+	totpPending  map[string]string // user name -> TOTP secret waiting for confirmation
+	totpLastStep map[string]int64  // user name -> time step of the last used TOTP code
 }
 
+// User roles
+const (
+	userRoleAdmin    = "admin"     // full access
+	userRoleReadOnly = "read-only" // may only view the settings and statistics (GET requests)
+)
+
 // User object
 type User struct {
 	Name         string `yaml:"name"`
-	PasswordHash string `yaml:"password"` // bcrypt hash
+	PasswordHash string `yaml:"password,omitempty"`      // bcrypt hash
+	PasswordFile string `yaml:"password_file,omitempty"` // the file with bcrypt hash;  it overrides PasswordHash
+	Role         string `yaml:"role,omitempty"`          // empty: admin
+
+	// Two-factor authentication
+	TOTPSecret    string   `yaml:"totp_secret,omitempty"`    // base32;  empty: 2FA is disabled
+	RecoveryCodes []string `yaml:"recovery_codes,omitempty"` // SHA-256 hashes of unused recovery codes
+}
+
+// Get the role of the user
+// An unknown role is treated as read-only
+func (u *User) role() string {
+	switch u.Role {
+	case "", userRoleAdmin:
+		return userRoleAdmin
+	default:
+		return userRoleReadOnly
+	}
 }
 
 // InitAuth - create a global object
@@ -76,6 +104,9 @@ func InitAuth(dbFilename string, users []User, sessionTTL uint32) *Auth {
 	a := Auth{}
 	a.sessionTTL = sessionTTL
 	a.sessions = make(map[string]*session)
+	a.totpPending = make(map[string]string)
+	a.totpLastStep = make(map[string]int64)
+	a.limiter = newAuthRateLimiter(0, 0)
 	rand.Seed(time.Now().UTC().Unix())
 	var err error
 	a.db, err = bbolt.Open(dbFilename, 0644, nil)
@@ -85,6 +116,11 @@ func InitAuth(dbFilename string, users []User, sessionTTL uint32) *Auth {
 	}
 	a.loadSessions()
 	a.users = users
+	for _, u := range users {
+		if len(u.Role) != 0 && u.Role != userRoleAdmin && u.Role != userRoleReadOnly {
+			log.Error("Auth: user %s: unknown role %q:  the user has read-only access", u.Name, u.Role)
+		}
+	}
 	log.Info("Auth: initialized.  users:%d  sessions:%d", len(a.users), len(a.sessions))
 	return &a
 }
@@ -270,6 +306,7 @@ func (a *Auth) RemoveSession(sess string) {
 type loginJSON struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
+	OTP      string `json:"otp"` // TOTP code or recovery code;  required if 2FA is enabled
 }
 
 func getSession(u *User) []byte {
@@ -278,11 +315,20 @@ func getSession(u *User) []byte {
 	return hash[:]
 }
 
-func (a *Auth) httpCookie(req loginJSON) string {
+// Create a session for the user and get the value of Set-Cookie header
+// secure: the request is received over HTTPS, the cookie must not be sent over plain HTTP
+func (a *Auth) httpCookie(req loginJSON, secure bool) string {
 	u := a.UserFind(req.Name, req.Password)
 	if len(u.Name) == 0 {
 		return ""
 	}
+	ok, modified := a.checkSecondFactor(u.Name, req.OTP)
+	if modified {
+		onConfigModified()
+	}
+	if !ok {
+		return ""
+	}
 
 	sess := getSession(&u)
 
@@ -297,8 +343,12 @@ func (a *Auth) httpCookie(req loginJSON) string {
 	s.expire = uint32(now.Unix()) + a.sessionTTL
 	a.addSession(sess, &s)
 
-	return fmt.Sprintf("%s=%s; Path=/; HttpOnly; Expires=%s",
-		sessionCookieName, hex.EncodeToString(sess), expstr)
+	cookie := fmt.Sprintf("%s=%s; Path=%s; HttpOnly; SameSite=Lax; Expires=%s",
+		sessionCookieName, hex.EncodeToString(sess), webPath("/"), expstr)
+	if secure {
+		cookie += "; Secure"
+	}
+	return cookie
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -309,13 +359,19 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie := Context.auth.httpCookie(req)
+	if Context.auth.isBlocked(r) {
+		http.Error(w, "too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	cookie := Context.auth.httpCookie(req, r.TLS != nil)
 	if len(cookie) == 0 {
-		log.Info("Auth: invalid user name or password: name='%s'", req.Name)
+		Context.auth.authFailed(r, fmt.Sprintf("invalid user name, password or code: name='%s'", req.Name))
 		time.Sleep(1 * time.Second)
-		http.Error(w, "invalid user name or password", http.StatusBadRequest)
+		http.Error(w, "invalid user name, password or two-factor authentication code", http.StatusBadRequest)
 		return
 	}
+	Context.auth.limiter.remove(remoteIP(r))
 
 	w.Header().Set("Set-Cookie", cookie)
 
@@ -332,10 +388,10 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 
 	Context.auth.RemoveSession(sess)
 
-	w.Header().Set("Location", "/login.html")
+	w.Header().Set("Location", webPath("/login.html"))
 
-	s := fmt.Sprintf("%s=; Path=/; HttpOnly; Expires=Thu, 01 Jan 1970 00:00:00 GMT",
-		sessionCookieName)
+	s := fmt.Sprintf("%s=; Path=%s; HttpOnly; SameSite=Lax; Expires=Thu, 01 Jan 1970 00:00:00 GMT",
+		sessionCookieName, webPath("/"))
 	w.Header().Set("Set-Cookie", s)
 
 	w.WriteHeader(http.StatusFound)
@@ -345,6 +401,9 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 func RegisterAuthHandlers() {
 	http.Handle("/control/login", postInstallHandler(ensureHandler("POST", handleLogin)))
 	httpRegister("GET", "/control/logout", handleLogout)
+	registerTOTPHandlers()
+	registerAPITokenHandlers()
+	registerAuthRateLimitHandlers()
 }
 
 func parseCookie(cookie string) string {
@@ -372,7 +431,7 @@ func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.Re
 			if authRequired && err == nil {
 				r := Context.auth.CheckSession(cookie.Value)
 				if r == 0 {
-					w.Header().Set("Location", "/")
+					w.Header().Set("Location", webPath("/"))
 					w.WriteHeader(http.StatusFound)
 					return
 				} else if r < 0 {
@@ -396,21 +455,23 @@ func optionalAuth(handler func(http.ResponseWriter, *http.Request)) func(http.Re
 				} else if r < 0 {
 					log.Debug("Auth: invalid cookie value: %s", cookie)
 				}
-			} else {
+			} else if Context.auth.isBlocked(r) {
+				// too many failed attempts:  don't check the credentials
+			} else if len(bearerToken(r)) != 0 {
+				// API token
+				ok = Context.auth.checkAPIToken(r)
+			} else if _, _, ok2 := r.BasicAuth(); ok2 {
 				// there's no Cookie, check Basic authentication
-				user, pass, ok2 := r.BasicAuth()
-				if ok2 {
-					u := Context.auth.UserFind(user, pass)
-					if len(u.Name) != 0 {
-						ok = true
-					} else {
-						log.Info("Auth: invalid Basic Authorization value")
-					}
+				u := Context.auth.basicAuthUser(r)
+				if len(u.Name) != 0 {
+					ok = true
+				} else {
+					Context.auth.authFailed(r, "invalid Basic Authorization value")
 				}
 			}
 			if !ok {
 				if r.URL.Path == "/" || r.URL.Path == "/index.html" {
-					w.Header().Set("Location", "/login.html")
+					w.Header().Set("Location", webPath("/login.html"))
 					w.WriteHeader(http.StatusFound)
 				} else {
 					w.WriteHeader(http.StatusForbidden)
@@ -469,17 +530,30 @@ func (a *Auth) UserFind(login string, password string) User {
 	return User{}
 }
 
+// Get the user by HTTP Basic authentication
+// Users with 2FA enabled can't use Basic authentication
+func (a *Auth) basicAuthUser(r *http.Request) User {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return User{}
+	}
+	u := a.UserFind(user, pass)
+	if len(u.TOTPSecret) != 0 {
+		return User{}
+	}
+	return u
+}
+
 // GetCurrentUser - get the current user
 func (a *Auth) GetCurrentUser(r *http.Request) User {
 	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
-		// there's no Cookie, check Basic authentication
-		user, pass, ok := r.BasicAuth()
-		if ok {
-			u := Context.auth.UserFind(user, pass)
-			return u
+		// there's no Cookie, check API token and Basic authentication
+		t := a.findAPIToken(bearerToken(r))
+		if t != nil {
+			return apiTokenUser(t)
 		}
-		return User{}
+		return a.basicAuthUser(r)
 	}
 
 	a.lock.Lock()
@@ -498,6 +572,16 @@ func (a *Auth) GetCurrentUser(r *http.Request) User {
 	return User{}
 }
 
+// CanModify - return TRUE if the current user is allowed to change the settings
+// Always TRUE if authentication is disabled
+func (a *Auth) CanModify(r *http.Request) bool {
+	if !a.AuthRequired() {
+		return true
+	}
+	u := a.GetCurrentUser(r)
+	return len(u.Name) != 0 && u.role() == userRoleAdmin
+}
+
 // GetUsers - get users
 func (a *Auth) GetUsers() []User {
 	a.lock.Lock()
@@ -0,0 +1,200 @@
+package home
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Brute-force protection:
+//  failed authentication attempts (log-in, Basic authentication, API token) are counted per source IP.
+//  After "auth_attempts" failed attempts the IP is blocked for "block_auth_min" minutes:
+//  all authentication attempts from it are rejected, the existing sessions still work.
+// Every failed attempt is logged as "Auth: failed authentication from <IP>: ..." (e.g. for fail2ban).
+
+// Failed attempts from one IP
+type authAttempts struct {
+	num          uint      // the number of failed attempts
+	until        time.Time // the time when the counter is reset
+	blockedUntil time.Time // zero: not blocked
+}
+
+// authRateLimiter - failed authentication attempts per IP
+type authRateLimiter struct {
+	lock        sync.Mutex               // protects all fields
+	attempts    map[string]*authAttempts // IP -> failed attempts
+	maxAttempts uint                     // 0: disabled
+	blockDur    time.Duration
+}
+
+func newAuthRateLimiter(maxAttempts uint, blockDur time.Duration) *authRateLimiter {
+	return &authRateLimiter{
+		attempts:    map[string]*authAttempts{},
+		maxAttempts: maxAttempts,
+		blockDur:    blockDur,
+	}
+}
+
+// Set the limits;  the current counters are kept
+func (l *authRateLimiter) setLimits(maxAttempts uint, blockDur time.Duration) {
+	l.lock.Lock()
+	l.maxAttempts = maxAttempts
+	l.blockDur = blockDur
+	l.lock.Unlock()
+}
+
+// Remove the expired entries (the lock must be held)
+func (l *authRateLimiter) cleanup(now time.Time) {
+	for ip, a := range l.attempts {
+		if now.After(a.until) && now.After(a.blockedUntil) {
+			delete(l.attempts, ip)
+		}
+	}
+}
+
+// Get the time left until the IP is unblocked;  0: not blocked
+func (l *authRateLimiter) check(ip string) time.Duration {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	a, ok := l.attempts[ip]
+	if !ok || !now.Before(a.blockedUntil) {
+		return 0
+	}
+	return a.blockedUntil.Sub(now)
+}
+
+// Register a failed attempt, block the IP if the limit is reached
+func (l *authRateLimiter) inc(ip string) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.maxAttempts == 0 {
+		return
+	}
+	l.cleanup(now)
+
+	a, ok := l.attempts[ip]
+	if !ok {
+		a = &authAttempts{until: now.Add(l.blockDur)}
+		l.attempts[ip] = a
+	}
+	a.num++
+	if a.num >= l.maxAttempts {
+		a.num = 0
+		a.until = now.Add(l.blockDur)
+		a.blockedUntil = a.until
+		log.Info("Auth: blocked %s for %s after %d failed attempts", ip, l.blockDur, l.maxAttempts)
+	}
+}
+
+// Reset the counter after a successful attempt
+func (l *authRateLimiter) remove(ip string) {
+	l.lock.Lock()
+	delete(l.attempts, ip)
+	l.lock.Unlock()
+}
+
+type blockedIPJSON struct {
+	IP    string `json:"ip"`
+	Until string `json:"until"` // RFC3339
+}
+
+// Get the list of blocked IPs
+func (l *authRateLimiter) blocked() []blockedIPJSON {
+	now := time.Now()
+	list := []blockedIPJSON{}
+	l.lock.Lock()
+	for ip, a := range l.attempts {
+		if now.Before(a.blockedUntil) {
+			list = append(list, blockedIPJSON{IP: ip, Until: a.blockedUntil.Format(time.RFC3339)})
+		}
+	}
+	l.lock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	return list
+}
+
+// Unblock the IP;  empty: unblock all
+// Return FALSE if the IP isn't blocked
+func (l *authRateLimiter) unblock(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(ip) == 0 {
+		l.attempts = map[string]*authAttempts{}
+		return true
+	}
+	_, ok := l.attempts[ip]
+	delete(l.attempts, ip)
+	return ok
+}
+
+// Get the source IP of the request
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SetRateLimit - set the number of failed attempts after which the IP is blocked (0: disabled) and the block duration
+// The limiter object isn't replaced:  it's used by the request handlers concurrently.
+func (a *Auth) SetRateLimit(maxAttempts uint, blockDur time.Duration) {
+	a.limiter.setLimits(maxAttempts, blockDur)
+}
+
+// Return TRUE if the source IP of the request is blocked
+func (a *Auth) isBlocked(r *http.Request) bool {
+	ip := remoteIP(r)
+	left := a.limiter.check(ip)
+	if left == 0 {
+		return false
+	}
+	log.Debug("Auth: %s is blocked for %s", ip, left)
+	return true
+}
+
+// Log and count a failed authentication attempt
+func (a *Auth) authFailed(r *http.Request, reason string) {
+	ip := remoteIP(r)
+	log.Info("Auth: failed authentication from %s: %s", ip, reason)
+	a.limiter.inc(ip)
+}
+
+func handleAuthBlocked(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(Context.auth.limiter.blocked())
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+	}
+}
+
+type authUnblockJSON struct {
+	IP string `json:"ip"` // empty: unblock all
+}
+
+func handleAuthUnblock(w http.ResponseWriter, r *http.Request) {
+	req := authUnblockJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+	if !Context.auth.limiter.unblock(req.IP) {
+		httpError(w, http.StatusBadRequest, "%s isn't blocked", req.IP)
+		return
+	}
+	log.Info("Auth: unblocked %q", req.IP)
+	returnOK(w)
+}
+
+func registerAuthRateLimitHandlers() {
+	httpRegister(http.MethodGet, "/control/auth/blocked", handleAuthBlocked)
+	httpRegister(http.MethodPost, "/control/auth/unblock", handleAuthUnblock)
+}
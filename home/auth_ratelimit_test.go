@@ -0,0 +1,99 @@
+package home
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthRateLimiter(t *testing.T) {
+	l := newAuthRateLimiter(3, time.Minute)
+	l.inc("1.2.3.4")
+	l.inc("1.2.3.4")
+	assert.Equal(t, time.Duration(0), l.check("1.2.3.4"))
+	assert.Equal(t, 0, len(l.blocked()))
+
+	// the counter is reset after a successful attempt
+	l.remove("1.2.3.4")
+	l.inc("1.2.3.4")
+	l.inc("1.2.3.4")
+	assert.Equal(t, time.Duration(0), l.check("1.2.3.4"))
+
+	l.inc("1.2.3.4")
+	assert.True(t, l.check("1.2.3.4") > 0)
+	assert.Equal(t, time.Duration(0), l.check("1.2.3.5"))
+	list := l.blocked()
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "1.2.3.4", list[0].IP)
+
+	assert.False(t, l.unblock("1.2.3.5"))
+	assert.True(t, l.unblock("1.2.3.4"))
+	assert.Equal(t, time.Duration(0), l.check("1.2.3.4"))
+
+	// disabled
+	l = newAuthRateLimiter(0, time.Minute)
+	for i := 0; i != 10; i++ {
+		l.inc("1.2.3.4")
+	}
+	assert.Equal(t, time.Duration(0), l.check("1.2.3.4"))
+
+	// the limits are changed while the requests are checked
+	done := make(chan bool)
+	go func() {
+		for i := 0; i != 100; i++ {
+			l.inc("1.2.3.4")
+			_ = l.check("1.2.3.4")
+		}
+		done <- true
+	}()
+	l.setLimits(2, time.Minute)
+	<-done
+	l.inc("1.2.3.5")
+	l.inc("1.2.3.5")
+	assert.True(t, l.check("1.2.3.5") > 0)
+}
+
+func TestAuthBlocked(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "sessions.db")
+
+	users := []User{
+		User{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+	Context.auth = InitAuth(fn, users, 60)
+	defer func() {
+		Context.auth.Close()
+		Context.auth = nil
+	}()
+	Context.auth.SetRateLimit(2, time.Minute)
+
+	handlerCalled := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}
+	request := func(password string) bool {
+		w := testResponseWriter{hdr: make(http.Header)}
+		r := http.Request{Method: http.MethodGet, Header: make(http.Header), URL: &url.URL{Path: "/control/status"}}
+		r.RemoteAddr = "1.2.3.4:12345"
+		r.SetBasicAuth("name", password)
+		handlerCalled = false
+		optionalAuth(handler)(&w, &r)
+		return handlerCalled
+	}
+
+	assert.True(t, request("password"))
+	assert.False(t, request("bad"))
+	assert.False(t, request("bad"))
+
+	// the valid password isn't accepted from the blocked IP
+	assert.False(t, request("password"))
+
+	Context.auth.limiter.unblock("1.2.3.4")
+	assert.True(t, request("password"))
+}
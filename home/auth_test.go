@@ -2,10 +2,13 @@ package home
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -129,8 +132,17 @@ func TestAuthHTTP(t *testing.T) {
 	assert.True(t, handlerCalled)
 
 	// perform login
-	cookie := Context.auth.httpCookie(loginJSON{Name: "name", Password: "password"})
+	cookie := Context.auth.httpCookie(loginJSON{Name: "name", Password: "password"}, false)
 	assert.True(t, cookie != "")
+	assert.True(t, strings.Contains(cookie, "; HttpOnly"))
+	assert.True(t, !strings.Contains(cookie, "; Secure"))
+
+	// the cookie received over HTTPS is never sent over plain HTTP
+	secureCookie := Context.auth.httpCookie(loginJSON{Name: "name", Password: "password"}, true)
+	assert.True(t, strings.HasSuffix(secureCookie, "; Secure"))
+
+	// invalid password
+	assert.Equal(t, "", Context.auth.httpCookie(loginJSON{Name: "name", Password: "bad"}, false))
 
 	// get /
 	handler2 = optionalAuth(handler)
@@ -175,3 +187,72 @@ func TestAuthHTTP(t *testing.T) {
 
 	Context.auth.Close()
 }
+
+func TestAuthRoles(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "sessions.db")
+
+	const hash = "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2" // "password"
+	users := []User{
+		User{Name: "admin", PasswordHash: hash},
+		User{Name: "viewer", PasswordHash: hash, Role: userRoleReadOnly},
+		User{Name: "unknown", PasswordHash: hash, Role: "superuser"},
+	}
+	Context.auth = InitAuth(fn, users, 60)
+	defer func() {
+		Context.auth.Close()
+		Context.auth = nil
+	}()
+
+	handlerCalled := false
+	handler := ensureCanModify(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	})
+	w := testResponseWriter{}
+	w.hdr = make(http.Header)
+	r := http.Request{}
+	r.Header = make(http.Header)
+	r.Method = "POST"
+
+	// admin
+	r.SetBasicAuth("admin", "password")
+	assert.True(t, Context.auth.CanModify(&r))
+	handler(&w, &r)
+	assert.True(t, handlerCalled)
+
+	// read-only user via session cookie
+	cookie := Context.auth.httpCookie(loginJSON{Name: "viewer", Password: "password"}, false)
+	r.Header.Del("Authorization")
+	r.Header.Set("Cookie", cookie)
+	assert.False(t, Context.auth.CanModify(&r))
+	handlerCalled = false
+	handler(&w, &r)
+	assert.False(t, handlerCalled)
+	assert.Equal(t, http.StatusForbidden, w.statusCode)
+	r.Header.Del("Cookie")
+
+	// unknown role is treated as read-only
+	r.SetBasicAuth("unknown", "password")
+	assert.False(t, Context.auth.CanModify(&r))
+
+	// the private key isn't shown to read-only users
+	tlsStatus := func(user string) tlsConfig {
+		tm := &TLSMod{}
+		tm.conf.CertificateChain = "cert"
+		tm.conf.PrivateKey = "key"
+		r := httptest.NewRequest(http.MethodGet, "/control/tls/status", nil)
+		r.SetBasicAuth(user, "password")
+		w := httptest.NewRecorder()
+		tm.handleTLSStatus(w, r)
+		data := tlsConfig{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &data))
+		return data
+	}
+	data := tlsStatus("admin")
+	assert.NotEmpty(t, data.PrivateKey)
+	assert.NotEmpty(t, data.CertificateChain)
+	data = tlsStatus("viewer")
+	assert.Empty(t, data.PrivateKey)
+	assert.NotEmpty(t, data.CertificateChain)
+}
@@ -0,0 +1,268 @@
+package home
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// API tokens for scripts and integrations:
+//  a token is sent in "Authorization: Bearer <token>" header instead of the session cookie or user's password.
+//  The token is shown only once when it's created, only its hash is stored in configuration file.
+// Restrictions:
+//  read_only: only GET requests are allowed
+//  scope: the list of allowed API paths (e.g. "/control/stats" allows "/control/stats" and "/control/stats/...",
+//   but not "/control/stats_reset");  empty: all paths
+// A token can't be used to manage the tokens and 2FA settings.
+
+const apiTokenPrefix = "agh_"
+
+// APIToken object
+type APIToken struct {
+	Name      string    `yaml:"name" json:"name"`
+	Hash      string    `yaml:"hash" json:"-"` // SHA-256 of the token
+	ReadOnly  bool      `yaml:"read_only" json:"read_only"`
+	Scope     []string  `yaml:"scope" json:"scope"`
+	CreatedAt time.Time `yaml:"created_at" json:"created_at"`
+}
+
+// Paths which can't be accessed with a token
+var apiTokenDeniedPaths = []string{
+	"/control/api_tokens/",
+	"/control/totp/",
+}
+
+// Get the hash of the token which is stored in configuration file
+func apiTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate a new token value
+func newAPIToken() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("rand.Read: %s", err)
+	}
+	return apiTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// Get the token value from "Authorization: Bearer ..." header
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[len("Bearer "):])
+}
+
+// Return TRUE if the token may be used for the request path
+func (t *APIToken) allowed(path string) bool {
+	for _, p := range apiTokenDeniedPaths {
+		if strings.HasPrefix(path, p) {
+			return false
+		}
+	}
+	if len(t.Scope) == 0 {
+		return true
+	}
+	for _, p := range t.Scope {
+		if pathInScope(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return TRUE if the path is equal to the scope path or is under it
+func pathInScope(path string, scope string) bool {
+	if !strings.HasPrefix(path, scope) {
+		return false
+	}
+	return len(path) == len(scope) ||
+		strings.HasSuffix(scope, "/") ||
+		path[len(scope)] == '/'
+}
+
+// SetAPITokens - set the list of API tokens
+func (a *Auth) SetAPITokens(tokens []APIToken) {
+	a.lock.Lock()
+	a.tokens = tokens
+	a.lock.Unlock()
+	log.Debug("Auth: loaded %d API tokens", len(tokens))
+}
+
+// GetAPITokens - get the list of API tokens
+func (a *Auth) GetAPITokens() []APIToken {
+	a.lock.Lock()
+	tokens := a.tokens
+	a.lock.Unlock()
+	return tokens
+}
+
+// Find the token by its value
+func (a *Auth) findAPIToken(token string) *APIToken {
+	if len(token) == 0 {
+		return nil
+	}
+	h := apiTokenHash(token)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for i := range a.tokens {
+		if a.tokens[i].Hash == h {
+			t := a.tokens[i]
+			return &t
+		}
+	}
+	return nil
+}
+
+// Get the user object for the request with API token
+// The name of the user is "token:<name>", the role is read-only if the token is read-only
+func apiTokenUser(t *APIToken) User {
+	u := User{Name: "token:" + t.Name}
+	if t.ReadOnly {
+		u.Role = userRoleReadOnly
+	}
+	return u
+}
+
+// Check the token of the request
+// Return TRUE if the request has a valid token which may be used for the request path
+func (a *Auth) checkAPIToken(r *http.Request) bool {
+	t := a.findAPIToken(bearerToken(r))
+	if t == nil {
+		a.authFailed(r, "invalid API token")
+		return false
+	}
+	if !t.allowed(r.URL.Path) {
+		log.Info("Auth: API token %s isn't allowed for %s", t.Name, r.URL.Path)
+		return false
+	}
+	return true
+}
+
+type apiTokenAddJSON struct {
+	Name     string   `json:"name"`
+	ReadOnly bool     `json:"read_only"`
+	Scope    []string `json:"scope"`
+}
+
+type apiTokenAddResultJSON struct {
+	Token string `json:"token"` // shown only once
+}
+
+type apiTokenDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+func handleAPITokensList(w http.ResponseWriter, r *http.Request) {
+	tokens := Context.auth.GetAPITokens()
+	if tokens == nil {
+		tokens = []APIToken{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+	}
+}
+
+func handleAPITokensAdd(w http.ResponseWriter, r *http.Request) {
+	req := apiTokenAddJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+	if len(req.Name) == 0 {
+		httpError(w, http.StatusBadRequest, "Token name is required")
+		return
+	}
+	for _, p := range req.Scope {
+		if !strings.HasPrefix(p, "/") {
+			httpError(w, http.StatusBadRequest, "Invalid scope path: %s", p)
+			return
+		}
+	}
+
+	token, err := newAPIToken()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	t := APIToken{
+		Name:      req.Name,
+		Hash:      apiTokenHash(token),
+		ReadOnly:  req.ReadOnly,
+		Scope:     req.Scope,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	a := Context.auth
+	a.lock.Lock()
+	for _, it := range a.tokens {
+		if it.Name == t.Name {
+			a.lock.Unlock()
+			httpError(w, http.StatusBadRequest, "Token %s already exists", t.Name)
+			return
+		}
+	}
+	a.tokens = append(a.tokens, t)
+	a.lock.Unlock()
+
+	onConfigModified()
+	log.Info("Auth: added API token %s", t.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(apiTokenAddResultJSON{Token: token})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+	}
+}
+
+func handleAPITokensDelete(w http.ResponseWriter, r *http.Request) {
+	req := apiTokenDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+
+	a := Context.auth
+	a.lock.Lock()
+	found := false
+	tokens := []APIToken{}
+	for _, t := range a.tokens {
+		if t.Name == req.Name {
+			found = true
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	a.tokens = tokens
+	a.lock.Unlock()
+
+	if !found {
+		httpError(w, http.StatusBadRequest, "Token %s not found", req.Name)
+		return
+	}
+
+	onConfigModified()
+	log.Info("Auth: revoked API token %s", req.Name)
+	returnOK(w)
+}
+
+func registerAPITokenHandlers() {
+	httpRegister(http.MethodGet, "/control/api_tokens/list", handleAPITokensList)
+	httpRegister(http.MethodPost, "/control/api_tokens/add", handleAPITokensAdd)
+	httpRegister(http.MethodPost, "/control/api_tokens/delete", handleAPITokensDelete)
+}
@@ -0,0 +1,82 @@
+package home
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPITokens(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "sessions.db")
+
+	users := []User{
+		User{Name: "admin", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+	Context.auth = InitAuth(fn, users, 60)
+	defer func() {
+		Context.auth.Close()
+		Context.auth = nil
+	}()
+
+	full, err := newAPIToken()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(full, apiTokenPrefix))
+	stats, _ := newAPIToken()
+	Context.auth.SetAPITokens([]APIToken{
+		{Name: "full", Hash: apiTokenHash(full)},
+		{Name: "stats", Hash: apiTokenHash(stats), ReadOnly: true, Scope: []string{"/control/stats"}},
+	})
+
+	handlerCalled := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}
+	// the same checks as for the handlers registered by httpRegister()
+	request := func(token string, method string, path string) bool {
+		h := handler
+		if method != http.MethodGet {
+			h = ensureCanModify(h)
+		}
+		w := testResponseWriter{hdr: make(http.Header)}
+		r := http.Request{Method: method, Header: make(http.Header), URL: &url.URL{Path: path}}
+		r.Header.Set("Authorization", "Bearer "+token)
+		handlerCalled = false
+		optionalAuth(h)(&w, &r)
+		return handlerCalled
+	}
+
+	assert.True(t, request(full, "POST", "/control/filtering/config"))
+	assert.True(t, request(full, "GET", "/control/stats"))
+	assert.False(t, request("agh_invalid", "GET", "/control/stats"))
+
+	// tokens can't manage the tokens
+	assert.False(t, request(full, "GET", "/control/api_tokens/list"))
+
+	// read-only token with scope
+	assert.True(t, request(stats, "GET", "/control/stats"))
+	assert.True(t, request(stats, "GET", "/control/stats/clients"))
+	assert.False(t, request(stats, "POST", "/control/stats_reset"))
+
+	// the scope doesn't allow the other paths with the same prefix
+	assert.False(t, request(stats, "GET", "/control/stats_info"))
+	assert.False(t, request(stats, "GET", "/control/stats_config"))
+	assert.False(t, request(stats, "GET", "/control/querylog"))
+
+	// the current user
+	r := http.Request{Header: make(http.Header)}
+	r.Header.Set("Authorization", "Bearer "+stats)
+	u := Context.auth.GetCurrentUser(&r)
+	assert.Equal(t, "token:stats", u.Name)
+	assert.Equal(t, userRoleReadOnly, u.role())
+
+	// revoked token
+	Context.auth.SetAPITokens([]APIToken{{Name: "stats", Hash: apiTokenHash(stats)}})
+	assert.False(t, request(full, "GET", "/control/stats"))
+}
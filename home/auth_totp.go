@@ -0,0 +1,328 @@
+package home
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Two-factor authentication with TOTP (RFC 6238):
+//  HMAC-SHA1, 6 digits, 30-second period (the defaults of authenticator apps).
+// Enrollment:
+//  1. setup: Server generates a secret and returns it with "otpauth://" URI (for QR code)
+//  2. enable: user confirms with a code from the authenticator app,
+//     Server saves the secret and returns one-time recovery codes
+// Log-in requires the password and either a TOTP code or an unused recovery code.
+// HTTP Basic authentication isn't allowed for users with 2FA enabled.
+
+const (
+	totpPeriod        = 30 // in seconds
+	totpDigits        = 6
+	totpModulo        = 1000000 // 10^totpDigits
+	totpSkew          = 1       // the number of periods before and after the current one, when the code is still valid
+	totpSecretLen     = 20
+	totpIssuer        = "AdGuard Home"
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate a new TOTP secret (base32)
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretLen)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("rand.Read: %s", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// Get the code for the time step
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	h := hmac.New(sha1.New, key)
+	_, _ = h.Write(msg)
+	sum := h.Sum(nil)
+
+	// dynamic truncation
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%totpModulo)
+}
+
+// Check the code
+// Return the time step the code belongs to, or -1 if the code is invalid
+func totpValidate(secret string, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+	cur := now.Unix() / totpPeriod
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// Get "otpauth://" URI for authenticator apps (the content of QR code)
+func totpURI(secret string, userName string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", totpDigits))
+	q.Set("period", fmt.Sprintf("%d", totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + userName,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// Normalize recovery code entered by user:  "ABCDE-12345" -> "abcde12345"
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// Get the hash of recovery code which is stored in configuration file
+func recoveryCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate recovery codes
+// Return the codes for user and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i != recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rand.Read: %s", err)
+		}
+		s := hex.EncodeToString(b)
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	return codes, hashes, nil
+}
+
+// Check the second factor of the user:  TOTP code or recovery code
+// A used code can't be used again.
+// Return TRUE if the user doesn't have 2FA enabled or the code is valid;
+//
+//	modified: the recovery code is used, configuration must be saved
+func (a *Auth) checkSecondFactor(name string, code string) (ok bool, modified bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	u := a.findUser(name)
+	if u == nil {
+		return false, false
+	}
+	if len(u.TOTPSecret) == 0 {
+		return true, false
+	}
+	if len(code) == 0 {
+		return false, false
+	}
+
+	step := totpValidate(u.TOTPSecret, code, time.Now())
+	if step >= 0 {
+		if step <= a.totpLastStep[name] {
+			log.Info("Auth: user %s: TOTP code has already been used", name)
+			return false, false
+		}
+		a.totpLastStep[name] = step
+		return true, false
+	}
+
+	h := recoveryCodeHash(code)
+	for i, rc := range u.RecoveryCodes {
+		if rc == h {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			log.Info("Auth: user %s: used a recovery code, %d left", name, len(u.RecoveryCodes))
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// Get the user object by name (the lock must be held)
+func (a *Auth) findUser(name string) *User {
+	for i := range a.users {
+		if a.users[i].Name == name {
+			return &a.users[i]
+		}
+	}
+	return nil
+}
+
+type totpSetupJSON struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth://totp/... for QR code
+}
+
+type totpEnableJSON struct {
+	Code string `json:"code"`
+}
+
+type totpEnableResultJSON struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type totpDisableJSON struct {
+	Password string `json:"password"`
+}
+
+// Get the current user for 2FA settings
+func currentUserTOTP(w http.ResponseWriter, r *http.Request) (User, bool) {
+	if !Context.auth.AuthRequired() {
+		httpError(w, http.StatusBadRequest, "Authentication is disabled")
+		return User{}, false
+	}
+	u := Context.auth.GetCurrentUser(r)
+	if len(u.Name) == 0 {
+		httpError(w, http.StatusForbidden, "Unknown user")
+		return User{}, false
+	}
+	return u, true
+}
+
+// Generate a new secret for the current user;  2FA isn't enabled until the code is confirmed
+func handleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	u, ok := currentUserTOTP(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	Context.auth.lock.Lock()
+	Context.auth.totpPending[u.Name] = secret
+	Context.auth.lock.Unlock()
+
+	resp := totpSetupJSON{
+		Secret: secret,
+		URI:    totpURI(secret, u.Name),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+	}
+}
+
+// Confirm the code for the secret generated by setup request and enable 2FA
+func handleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	u, ok := currentUserTOTP(w, r)
+	if !ok {
+		return
+	}
+	req := totpEnableJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	a := Context.auth
+	a.lock.Lock()
+	secret := a.totpPending[u.Name]
+	step := int64(-1)
+	if len(secret) != 0 {
+		step = totpValidate(secret, req.Code, time.Now())
+	}
+	if step < 0 {
+		a.lock.Unlock()
+		httpError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+	delete(a.totpPending, u.Name)
+	a.totpLastStep[u.Name] = step
+	pu := a.findUser(u.Name)
+	if pu != nil {
+		pu.TOTPSecret = secret
+		pu.RecoveryCodes = hashes
+	}
+	a.lock.Unlock()
+
+	onConfigModified()
+	log.Info("Auth: user %s: enabled two-factor authentication", u.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(totpEnableResultJSON{RecoveryCodes: codes})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+	}
+}
+
+// Disable 2FA for the current user;  the password is required
+func handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	u, ok := currentUserTOTP(w, r)
+	if !ok {
+		return
+	}
+	req := totpDisableJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)) != nil {
+		time.Sleep(1 * time.Second)
+		httpError(w, http.StatusBadRequest, "Invalid password")
+		return
+	}
+
+	a := Context.auth
+	a.lock.Lock()
+	pu := a.findUser(u.Name)
+	if pu != nil {
+		pu.TOTPSecret = ""
+		pu.RecoveryCodes = nil
+	}
+	a.lock.Unlock()
+
+	onConfigModified()
+	log.Info("Auth: user %s: disabled two-factor authentication", u.Name)
+	returnOK(w)
+}
+
+// Register 2FA handlers:  any user may change their own settings
+func registerTOTPHandlers() {
+	httpRegisterAnyUser(http.MethodPost, "/control/totp/setup", handleTOTPSetup)
+	httpRegisterAnyUser(http.MethodPost, "/control/totp/enable", handleTOTPEnable)
+	httpRegisterAnyUser(http.MethodPost, "/control/totp/disable", handleTOTPDisable)
+}
@@ -0,0 +1,96 @@
+package home
+
+import (
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 test vector:  "12345678901234567890", T=59 -> 94287082 (8 digits)
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(59, 0)
+	assert.Equal(t, int64(1), totpValidate(secret, "287082", now))
+	assert.Equal(t, int64(-1), totpValidate(secret, "287083", now))
+	assert.Equal(t, int64(-1), totpValidate(secret, "", now))
+
+	// the code of the previous period is still valid
+	assert.Equal(t, int64(1), totpValidate(secret, "287082", time.Unix(89, 0)))
+	assert.Equal(t, int64(-1), totpValidate(secret, "287082", time.Unix(150, 0)))
+
+	s, err := newTOTPSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(s))
+
+	uri := totpURI(s, "admin")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/AdGuard%20Home:admin?"))
+	assert.True(t, strings.Contains(uri, "secret="+s))
+}
+
+func TestSecondFactor(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "sessions.db")
+
+	secret, err := newTOTPSecret()
+	assert.Nil(t, err)
+	codes, hashes, err := newRecoveryCodes()
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount, len(codes))
+
+	const hash = "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2" // "password"
+	users := []User{
+		User{Name: "user", PasswordHash: hash},
+		User{Name: "user2fa", PasswordHash: hash, TOTPSecret: secret, RecoveryCodes: hashes},
+	}
+	a := InitAuth(fn, users, 60)
+	defer a.Close()
+
+	// 2FA is disabled
+	ok, _ := a.checkSecondFactor("user", "")
+	assert.True(t, ok)
+
+	// code is required
+	ok, _ = a.checkSecondFactor("user2fa", "")
+	assert.False(t, ok)
+	ok, _ = a.checkSecondFactor("user2fa", "000000x")
+	assert.False(t, ok)
+
+	// valid code can be used only once
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	ok, modified := a.checkSecondFactor("user2fa", code)
+	assert.True(t, ok)
+	assert.False(t, modified)
+	ok, _ = a.checkSecondFactor("user2fa", code)
+	assert.False(t, ok)
+
+	// recovery code:  the case and the separator don't matter, can be used only once
+	ok, modified = a.checkSecondFactor("user2fa", strings.ToUpper(strings.ReplaceAll(codes[3], "-", "")))
+	assert.True(t, ok)
+	assert.True(t, modified)
+	assert.Equal(t, recoveryCodeCount-1, len(a.GetUsers()[1].RecoveryCodes))
+	ok, _ = a.checkSecondFactor("user2fa", codes[3])
+	assert.False(t, ok)
+
+	// Basic authentication isn't allowed with 2FA
+	r := http.Request{Header: make(http.Header)}
+	r.SetBasicAuth("user2fa", "password")
+	assert.Equal(t, "", a.basicAuthUser(&r).Name)
+	r.SetBasicAuth("user", "password")
+	assert.Equal(t, "user", a.basicAuthUser(&r).Name)
+
+	// log in requires the code
+	assert.Equal(t, "", a.httpCookie(loginJSON{Name: "user2fa", Password: "password"}, false))
+	code = totpCode(key, time.Now().Unix()/totpPeriod+1)
+	cookie := a.httpCookie(loginJSON{Name: "user2fa", Password: "password", OTP: code}, false)
+	assert.True(t, cookie != "")
+	_, err = hex.DecodeString(parseCookie(cookie))
+	assert.Nil(t, err)
+}
@@ -40,6 +40,11 @@ type Client struct {
 	UseOwnBlockedServices bool // false: use global settings
 	BlockedServices       []string
 
+	Schedule []SchedulePeriod // periods when filtering is paused
+
+	IgnoreQueryLog   bool // don't write the client's requests to query log
+	IgnoreStatistics bool // don't count the client's requests in statistics
+
 	Upstreams []string // list of upstream servers to be used for the client's requests
 	// Upstream objects:
 	// nil: not yet initialized
@@ -65,6 +70,7 @@ type ClientHost struct {
 	Host      string
 	Source    clientSource
 	WhoisInfo [][]string // [[key,value], ...]
+	Device    string     // device type/vendor guessed from DHCP fingerprint
 }
 
 type clientsContainer struct {
@@ -78,6 +84,10 @@ type clientsContainer struct {
 	// dhcpServer is used for looking up clients IP addresses by MAC addresses
 	dhcpServer *dhcpd.Server
 
+	// IP -> MAC table from 'arp -a' command output
+	// It's used for looking up clients by MAC addresses if DHCP server doesn't know the IP address
+	arpMACs map[string]net.HardwareAddr
+
 	autoHosts *util.AutoHosts // get entries from system hosts-files
 
 	testing bool // if TRUE, this object is used for internal tests
@@ -139,6 +149,11 @@ type clientObject struct {
 	UseGlobalBlockedServices bool     `yaml:"use_global_blocked_services"`
 	BlockedServices          []string `yaml:"blocked_services"`
 
+	Schedule []SchedulePeriod `yaml:"schedule"`
+
+	IgnoreQueryLog   bool `yaml:"ignore_querylog"`
+	IgnoreStatistics bool `yaml:"ignore_statistics"`
+
 	Upstreams []string `yaml:"upstreams"`
 }
 
@@ -160,6 +175,11 @@ func (clients *clientsContainer) addFromConfig(objects []clientObject) {
 
 			UseOwnBlockedServices: !cy.UseGlobalBlockedServices,
 
+			Schedule: cy.Schedule,
+
+			IgnoreQueryLog:   cy.IgnoreQueryLog,
+			IgnoreStatistics: cy.IgnoreStatistics,
+
 			Upstreams: cy.Upstreams,
 		}
 
@@ -187,6 +207,17 @@ func (clients *clientsContainer) addFromConfig(objects []clientObject) {
 	}
 }
 
+// SetConfig - replace the persistent clients with the ones from the configuration file
+// The runtime clients (ARP, DHCP, rDNS, etc.) are kept.
+func (clients *clientsContainer) SetConfig(objects []clientObject) {
+	clients.lock.Lock()
+	clients.list = make(map[string]*Client)
+	clients.idIndex = make(map[string]*Client)
+	clients.lock.Unlock()
+
+	clients.addFromConfig(objects)
+}
+
 // WriteDiskConfig - write configuration
 func (clients *clientsContainer) WriteDiskConfig(objects *[]clientObject) {
 	clients.lock.Lock()
@@ -199,11 +230,14 @@ func (clients *clientsContainer) WriteDiskConfig(objects *[]clientObject) {
 			SafeSearchEnabled:        cli.SafeSearchEnabled,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			IgnoreQueryLog:           cli.IgnoreQueryLog,
+			IgnoreStatistics:         cli.IgnoreStatistics,
 		}
 
 		cy.Tags = stringArrayDup(cli.Tags)
 		cy.IDs = stringArrayDup(cli.IDs)
 		cy.BlockedServices = stringArrayDup(cli.BlockedServices)
+		cy.Schedule = scheduleDup(cli.Schedule)
 		cy.Upstreams = stringArrayDup(cli.Upstreams)
 
 		*objects = append(*objects, cy)
@@ -222,7 +256,8 @@ func (clients *clientsContainer) onDHCPLeaseChanged(flags int) {
 	switch flags {
 	case dhcpd.LeaseChangedAdded,
 		dhcpd.LeaseChangedAddedStatic,
-		dhcpd.LeaseChangedRemovedStatic:
+		dhcpd.LeaseChangedRemovedStatic,
+		dhcpd.LeaseChangedRemoved:
 		clients.addFromDHCP()
 	}
 }
@@ -259,17 +294,25 @@ func stringArrayDup(a []string) []string {
 
 // Find searches for a client by IP
 func (clients *clientsContainer) Find(ip string) (Client, bool) {
+	return clients.FindWithClientID(ip, "")
+}
+
+// FindWithClientID searches for a client by ClientID (if it's set), then by IP
+func (clients *clientsContainer) FindWithClientID(ip, clientID string) (Client, bool) {
 	clients.lock.Lock()
 	defer clients.lock.Unlock()
 
-	c, ok := clients.findByIP(ip)
+	cp, ok := clients.find(ip, clientID)
 	if !ok {
 		return Client{}, false
 	}
+	c := *cp
 	c.IDs = stringArrayDup(c.IDs)
 	c.Tags = stringArrayDup(c.Tags)
 	c.BlockedServices = stringArrayDup(c.BlockedServices)
+	c.Schedule = scheduleDup(c.Schedule)
 	c.Upstreams = stringArrayDup(c.Upstreams)
+	c.upstreamObjects = nil
 	return c, true
 }
 
@@ -279,14 +322,27 @@ func upstreamArrayCopy(a []upstream.Upstream) []upstream.Upstream {
 	return a2
 }
 
+// FindLogSettings returns TRUE if the client's requests must not be written to query log (1st value)
+//  or counted in statistics (2nd value)
+func (clients *clientsContainer) FindLogSettings(ip, clientID string) (bool, bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.find(ip, clientID)
+	if !ok {
+		return false, false
+	}
+	return c.IgnoreQueryLog, c.IgnoreStatistics
+}
+
 // FindUpstreams looks for upstreams configured for the client
-// If no client found for this IP, or if no custom upstreams are configured,
+// If no client found for this IP or ClientID, or if no custom upstreams are configured,
 // this method returns nil
-func (clients *clientsContainer) FindUpstreams(ip string) []upstream.Upstream {
+func (clients *clientsContainer) FindUpstreams(ip, clientID string) []upstream.Upstream {
 	clients.lock.Lock()
 	defer clients.lock.Unlock()
 
-	c, ok := clients.findByIP(ip)
+	c, ok := clients.find(ip, clientID)
 	if !ok {
 		return nil
 	}
@@ -309,36 +365,71 @@ func (clients *clientsContainer) FindUpstreams(ip string) []upstream.Upstream {
 	return upstreamArrayCopy(c.upstreamObjects)
 }
 
+// FindUpstreamAddrs returns the addresses of upstream servers configured for the client
+func (clients *clientsContainer) FindUpstreamAddrs(ip, clientID string) []string {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.find(ip, clientID)
+	if !ok {
+		return nil
+	}
+	return stringArrayDup(c.Upstreams)
+}
+
+// Search for a client by ClientID (if it's set), then by IP (and don't lock anything)
+func (clients *clientsContainer) find(ip, clientID string) (*Client, bool) {
+	if len(clientID) != 0 {
+		c, ok := clients.idIndex[clientID]
+		if ok {
+			return c, true
+		}
+	}
+	return clients.findByIP(ip)
+}
+
 // Find searches for a client by IP (and does not lock anything)
-func (clients *clientsContainer) findByIP(ip string) (Client, bool) {
+// If the IP address belongs to several subnets, the client with the narrowest subnet is returned.
+// The returned object must not be modified outside of the lock.
+func (clients *clientsContainer) findByIP(ip string) (*Client, bool) {
 	ipAddr := net.ParseIP(ip)
 	if ipAddr == nil {
-		return Client{}, false
+		return nil, false
 	}
 
 	c, ok := clients.idIndex[ip]
 	if ok {
-		return *c, true
+		return c, true
 	}
 
+	var found *Client
+	foundBits := -1
 	for _, c = range clients.list {
 		for _, id := range c.IDs {
 			_, ipnet, err := net.ParseCIDR(id)
 			if err != nil {
 				continue
 			}
-			if ipnet.Contains(ipAddr) {
-				return *c, true
+			bits, _ := ipnet.Mask.Size()
+			if ipnet.Contains(ipAddr) && bits > foundBits {
+				found = c
+				foundBits = bits
 			}
 		}
 	}
+	if found != nil {
+		return found, true
+	}
 
-	if clients.dhcpServer == nil {
-		return Client{}, false
+	var macFound net.HardwareAddr
+	if clients.dhcpServer != nil {
+		macFound = clients.dhcpServer.FindMACbyIP(ipAddr)
 	}
-	macFound := clients.dhcpServer.FindMACbyIP(ipAddr)
 	if macFound == nil {
-		return Client{}, false
+		macFound = clients.arpMACs[ipAddr.String()]
+	}
+	if macFound == nil {
+		return nil, false
 	}
 	for _, c = range clients.list {
 		for _, id := range c.IDs {
@@ -347,12 +438,12 @@ func (clients *clientsContainer) findByIP(ip string) (Client, bool) {
 				continue
 			}
 			if bytes.Equal(hwAddr, macFound) {
-				return *c, true
+				return c, true
 			}
 		}
 	}
 
-	return Client{}, false
+	return nil, false
 }
 
 // FindAutoClient - search for an auto-client by IP
@@ -399,6 +490,11 @@ func (clients *clientsContainer) check(c *Client) error {
 			continue
 		}
 
+		if dnsforward.IsValidClientID(id) {
+			c.IDs[i] = strings.ToLower(id) // normalize ClientID
+			continue
+		}
+
 		return fmt.Errorf("invalid ID: %s", id)
 	}
 
@@ -409,6 +505,13 @@ func (clients *clientsContainer) check(c *Client) error {
 	}
 	sort.Strings(c.Tags)
 
+	for i := range c.Schedule {
+		err := c.Schedule[i].check()
+		if err != nil {
+			return fmt.Errorf("invalid schedule: %s", err)
+		}
+	}
+
 	if len(c.Upstreams) != 0 {
 		err := dnsforward.ValidateUpstreams(c.Upstreams)
 		if err != nil {
@@ -652,12 +755,34 @@ func (clients *clientsContainer) addFromSystemARP() {
 		return
 	}
 
+	clients.addFromARPOutput(string(data))
+}
+
+// Parse MAC address from 'arp -a' command output
+// Some systems don't print leading zeros: "0:1:2:a:b:c"
+func parseARPMAC(s string) net.HardwareAddr {
+	parts := strings.Split(s, ":")
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	mac, err := net.ParseMAC(strings.Join(parts, ":"))
+	if err != nil {
+		return nil
+	}
+	return mac
+}
+
+// Add clients and IP -> MAC entries from 'arp -a' command output
+func (clients *clientsContainer) addFromARPOutput(data string) {
 	clients.lock.Lock()
 	defer clients.lock.Unlock()
 	_ = clients.rmHosts(ClientSourceARP)
+	clients.arpMACs = map[string]net.HardwareAddr{}
 
 	n := 0
-	lines := strings.Split(string(data), "\n")
+	lines := strings.Split(data, "\n")
 	for _, ln := range lines {
 
 		open := strings.Index(ln, " (")
@@ -667,8 +792,21 @@ func (clients *clientsContainer) addFromSystemARP() {
 		}
 
 		host := ln[:open]
-		ip := ln[open+2 : close]
-		if utils.IsValidHostname(host) != nil || net.ParseIP(ip) == nil {
+		ipAddr := net.ParseIP(ln[open+2 : close])
+		if ipAddr == nil {
+			continue
+		}
+		ip := ipAddr.String()
+
+		fields := strings.Fields(ln[close+2:])
+		if len(fields) >= 2 && fields[0] == "at" {
+			mac := parseARPMAC(fields[1])
+			if mac != nil {
+				clients.arpMACs[ip] = mac
+			}
+		}
+
+		if utils.IsValidHostname(host) != nil {
 			continue
 		}
 
@@ -681,7 +819,8 @@ func (clients *clientsContainer) addFromSystemARP() {
 		}
 	}
 
-	log.Debug("Clients: added %d client aliases from 'arp -a' command output", n)
+	log.Debug("Clients: added %d client aliases and %d MAC addresses from 'arp -a' command output",
+		n, len(clients.arpMACs))
 }
 
 // Add clients from DHCP that have non-empty Hostname property
@@ -698,13 +837,21 @@ func (clients *clientsContainer) addFromDHCP() {
 	leases := clients.dhcpServer.Leases(dhcpd.LeasesAll)
 	n := 0
 	for _, l := range leases {
-		if len(l.Hostname) == 0 {
+		host := l.Hostname
+		if len(host) == 0 {
+			host = l.Device // show the device type instead of a bare address
+		}
+		if len(host) == 0 {
 			continue
 		}
-		ok, _ := clients.addHost(l.IP.String(), l.Hostname, ClientSourceDHCP)
+		ok, _ := clients.addHost(l.IP.String(), host, ClientSourceDHCP)
 		if ok {
 			n++
 		}
+		ch, found := clients.ipHost[l.IP.String()]
+		if found && len(l.Device) != 0 {
+			ch.Device = l.Device
+		}
 	}
 	log.Debug("Clients: added %d client aliases from DHCP", n)
 }
@@ -20,6 +20,11 @@ type clientJSON struct {
 	UseGlobalBlockedServices bool     `json:"use_global_blocked_services"`
 	BlockedServices          []string `json:"blocked_services"`
 
+	Schedule []SchedulePeriod `json:"schedule"`
+
+	IgnoreQueryLog   bool `json:"ignore_querylog"`
+	IgnoreStatistics bool `json:"ignore_statistics"`
+
 	Upstreams []string `json:"upstreams"`
 }
 
@@ -29,6 +34,7 @@ type clientHostJSON struct {
 	Source string `json:"source"`
 
 	WhoisInfo map[string]interface{} `json:"whois_info"`
+	Device    string                 `json:"device,omitempty"`
 }
 
 type clientListJSON struct {
@@ -48,8 +54,9 @@ func (clients *clientsContainer) handleGetClients(w http.ResponseWriter, r *http
 	}
 	for ip, ch := range clients.ipHost {
 		cj := clientHostJSON{
-			IP:   ip,
-			Name: ch.Host,
+			IP:     ip,
+			Name:   ch.Host,
+			Device: ch.Device,
 		}
 
 		cj.Source = "etc/hosts"
@@ -98,6 +105,11 @@ func jsonToClient(cj clientJSON) (*Client, error) {
 		UseOwnBlockedServices: !cj.UseGlobalBlockedServices,
 		BlockedServices:       cj.BlockedServices,
 
+		Schedule: cj.Schedule,
+
+		IgnoreQueryLog:   cj.IgnoreQueryLog,
+		IgnoreStatistics: cj.IgnoreStatistics,
+
 		Upstreams: cj.Upstreams,
 	}
 	return &c, nil
@@ -118,6 +130,11 @@ func clientToJSON(c *Client) clientJSON {
 		UseGlobalBlockedServices: !c.UseOwnBlockedServices,
 		BlockedServices:          c.BlockedServices,
 
+		Schedule: c.Schedule,
+
+		IgnoreQueryLog:   c.IgnoreQueryLog,
+		IgnoreStatistics: c.IgnoreStatistics,
+
 		Upstreams: c.Upstreams,
 	}
 	return cj
@@ -127,13 +144,15 @@ type clientHostJSONWithID struct {
 	IDs       []string               `json:"ids"`
 	Name      string                 `json:"name"`
 	WhoisInfo map[string]interface{} `json:"whois_info"`
+	Device    string                 `json:"device,omitempty"`
 }
 
 // Convert ClientHost object to JSON
 func clientHostToJSON(ip string, ch ClientHost) clientHostJSONWithID {
 	cj := clientHostJSONWithID{
-		Name: ch.Host,
-		IDs:  []string{ip},
+		Name:   ch.Host,
+		IDs:    []string{ip},
+		Device: ch.Device,
 	}
 
 	cj.WhoisInfo = make(map[string]interface{})
@@ -0,0 +1,176 @@
+package home
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+)
+
+// Schedule of the filtering pauses for a client.
+// During a pause period either the protection is disabled completely for this client,
+//  or only the specified blocked services are allowed.
+// Example: allow YouTube only on weekends:
+//  schedule:
+//  - days: [sat, sun]
+//    start: "00:00"
+//    end: "24:00"
+//    services: [youtube]
+
+// Days of week as they're written in configuration (the index is time.Weekday)
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// SchedulePeriod - the period when filtering is paused
+type SchedulePeriod struct {
+	Days  []string `yaml:"days" json:"days"`   // days of week: "mon", "tue", ...;  empty: every day
+	Start string   `yaml:"start" json:"start"` // "HH:MM"
+	End   string   `yaml:"end" json:"end"`     // "HH:MM";  "24:00": the end of day
+	// If End is not greater than Start, the period continues on the next day: "22:00" - "07:00"
+
+	// Blocked services which are allowed during this period
+	// empty: protection is disabled
+	Services []string `yaml:"services" json:"services"`
+}
+
+// Parse "HH:MM" string
+// Return the number of minutes since midnight
+func parseScheduleTime(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return h*60 + m, nil
+}
+
+func scheduleDayIndex(day string) int {
+	for i, d := range scheduleDays {
+		if d == day {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check the period configuration
+func (p *SchedulePeriod) check() error {
+	for i, d := range p.Days {
+		d = strings.ToLower(d)
+		if scheduleDayIndex(d) < 0 {
+			return fmt.Errorf("invalid day: %s", d)
+		}
+		p.Days[i] = d
+	}
+
+	start, err := parseScheduleTime(p.Start)
+	if err != nil {
+		return err
+	}
+	if start == 24*60 {
+		return fmt.Errorf("invalid time: %s", p.Start)
+	}
+	_, err = parseScheduleTime(p.End)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range p.Services {
+		if !dnsfilter.BlockedSvcKnown(s) {
+			return fmt.Errorf("invalid blocked service: %s", s)
+		}
+	}
+	return nil
+}
+
+// Return TRUE if the period is set for this day of week
+func (p *SchedulePeriod) hasDay(wd time.Weekday) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	for _, d := range p.Days {
+		if d == scheduleDays[wd] {
+			return true
+		}
+	}
+	return false
+}
+
+// Return TRUE if the period is active at the specified time
+func (p *SchedulePeriod) contains(t time.Time) bool {
+	start, err := parseScheduleTime(p.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseScheduleTime(p.End)
+	if err != nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	wd := t.Weekday()
+
+	if start < end {
+		return p.hasDay(wd) && m >= start && m < end
+	}
+
+	// the period continues on the next day
+	prev := (wd + 6) % 7
+	return (p.hasDay(wd) && m >= start) ||
+		(p.hasDay(prev) && m < end)
+}
+
+func scheduleDup(a []SchedulePeriod) []SchedulePeriod {
+	a2 := make([]SchedulePeriod, len(a))
+	for i, p := range a {
+		a2[i] = p
+		a2[i].Days = stringArrayDup(p.Days)
+		a2[i].Services = stringArrayDup(p.Services)
+	}
+	return a2
+}
+
+// Apply the client's schedule to the filtering settings for the request made at the specified time
+func applySchedule(schedule []SchedulePeriod, now time.Time, setts *dnsfilter.RequestFilteringSettings) {
+	for _, p := range schedule {
+		if !p.contains(now) {
+			continue
+		}
+
+		if len(p.Services) == 0 {
+			setts.FilteringEnabled = false
+			setts.SafeSearchEnabled = false
+			setts.SafeBrowsingEnabled = false
+			setts.ParentalEnabled = false
+			setts.ServicesRules = nil
+			return
+		}
+
+		rules := []dnsfilter.ServiceEntry{}
+		for _, s := range setts.ServicesRules {
+			if !stringArrayContains(p.Services, s.Name) {
+				rules = append(rules, s)
+			}
+		}
+		setts.ServicesRules = rules
+	}
+}
+
+func stringArrayContains(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
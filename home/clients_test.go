@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -236,3 +237,266 @@ func TestClientsAddExisting(t *testing.T) {
 	assert.True(t, ok)
 	assert.Nil(t, err)
 }
+
+func TestClientsSubnets(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	ok, err := clients.Add(Client{
+		IDs:       []string{"1.2.0.0/16"},
+		Name:      "network",
+		Upstreams: []string{"1.1.1.1"},
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = clients.Add(Client{
+		IDs:  []string{"1.2.3.0/24"},
+		Name: "subnet",
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	// the narrowest subnet wins
+	c, ok := clients.Find("1.2.3.4")
+	assert.True(t, ok)
+	assert.Equal(t, "subnet", c.Name)
+	c, ok = clients.Find("1.2.4.4")
+	assert.True(t, ok)
+	assert.Equal(t, "network", c.Name)
+	_, ok = clients.Find("1.3.3.4")
+	assert.False(t, ok)
+
+	// upstream objects are created once
+	ups := clients.FindUpstreams("1.2.4.4", "")
+	assert.Equal(t, 1, len(ups))
+	assert.NotNil(t, clients.list["network"].upstreamObjects)
+	assert.Nil(t, clients.FindUpstreams("1.2.3.4", ""))
+}
+
+func TestClientsARP(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	ok, err := clients.Add(Client{
+		IDs:  []string{"aa:aa:aa:aa:aa:aa"},
+		Name: "laptop",
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	clients.addFromARPOutput(`? (192.168.1.2) at aa:aa:aa:aa:aa:aa [ether] on eth0
+router (192.168.1.1) at 0:1:2:a:b:c on en0 ifscope [ethernet]
+? (192.168.1.3) at <incomplete> on eth0
+`)
+
+	// client is found by MAC from ARP table
+	c, ok := clients.Find("192.168.1.2")
+	assert.True(t, ok)
+	assert.Equal(t, "laptop", c.Name)
+	assert.Equal(t, "00:01:02:0a:0b:0c", clients.arpMACs["192.168.1.1"].String())
+	_, ok = clients.Find("192.168.1.3")
+	assert.False(t, ok)
+
+	// auto-client
+	ch, ok := clients.FindAutoClient("192.168.1.1")
+	assert.True(t, ok)
+	assert.Equal(t, "router", ch.Host)
+}
+
+func TestClientsClientID(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	ok, err := clients.Add(Client{
+		IDs:  []string{"1.1.1.1", "Laptop"},
+		Name: "laptop",
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = clients.Add(Client{
+		IDs:  []string{"2.2.2.2"},
+		Name: "phone",
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	_, err = clients.Add(Client{
+		IDs:  []string{"bad.id"},
+		Name: "bad",
+	})
+	assert.NotNil(t, err)
+
+	// ClientID takes priority over IP address
+	c, ok := clients.FindWithClientID("2.2.2.2", "laptop")
+	assert.True(t, ok)
+	assert.Equal(t, "laptop", c.Name)
+
+	// unknown ClientID: search by IP address
+	c, ok = clients.FindWithClientID("2.2.2.2", "unknown")
+	assert.True(t, ok)
+	assert.Equal(t, "phone", c.Name)
+
+	_, ok = clients.FindWithClientID("3.3.3.3", "unknown")
+	assert.False(t, ok)
+}
+
+func TestClientsSchedule(t *testing.T) {
+	dnsfilter.InitModule()
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	_, err := clients.Add(Client{
+		IDs:      []string{"1.1.1.1"},
+		Name:     "bad",
+		Schedule: []SchedulePeriod{{Start: "25:00", End: "07:00"}},
+	})
+	assert.NotNil(t, err)
+	_, err = clients.Add(Client{
+		IDs:      []string{"1.1.1.1"},
+		Name:     "bad",
+		Schedule: []SchedulePeriod{{Days: []string{"someday"}, Start: "00:00", End: "24:00"}},
+	})
+	assert.NotNil(t, err)
+	_, err = clients.Add(Client{
+		IDs:      []string{"1.1.1.1"},
+		Name:     "bad",
+		Schedule: []SchedulePeriod{{Start: "00:00", End: "24:00", Services: []string{"unknown"}}},
+	})
+	assert.NotNil(t, err)
+
+	// allow YouTube on weekends, disable protection at night
+	ok, err := clients.Add(Client{
+		IDs:  []string{"1.1.1.1"},
+		Name: "child",
+		Schedule: []SchedulePeriod{
+			{Days: []string{"Sat", "sun"}, Start: "00:00", End: "24:00", Services: []string{"youtube"}},
+			{Days: []string{"fri"}, Start: "22:00", End: "07:00"},
+		},
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	c, _ := clients.Find("1.1.1.1")
+	assert.Equal(t, "sat", c.Schedule[0].Days[0])
+
+	newSetts := func() *dnsfilter.RequestFilteringSettings {
+		return &dnsfilter.RequestFilteringSettings{
+			FilteringEnabled: true,
+			ParentalEnabled:  true,
+			ServicesRules: []dnsfilter.ServiceEntry{
+				{Name: "youtube"},
+				{Name: "facebook"},
+			},
+		}
+	}
+
+	// Wednesday
+	setts := newSetts()
+	applySchedule(c.Schedule, time.Date(2020, 11, 4, 12, 0, 0, 0, time.Local), setts)
+	assert.True(t, setts.FilteringEnabled)
+	assert.Equal(t, 2, len(setts.ServicesRules))
+
+	// Saturday
+	setts = newSetts()
+	applySchedule(c.Schedule, time.Date(2020, 11, 7, 12, 0, 0, 0, time.Local), setts)
+	assert.True(t, setts.FilteringEnabled)
+	assert.Equal(t, 1, len(setts.ServicesRules))
+	assert.Equal(t, "facebook", setts.ServicesRules[0].Name)
+
+	// Friday night, the period continues on Saturday morning
+	setts = newSetts()
+	applySchedule(c.Schedule, time.Date(2020, 11, 6, 23, 0, 0, 0, time.Local), setts)
+	assert.False(t, setts.FilteringEnabled)
+	assert.False(t, setts.ParentalEnabled)
+	assert.Equal(t, 0, len(setts.ServicesRules))
+
+	setts = newSetts()
+	applySchedule(c.Schedule, time.Date(2020, 11, 7, 6, 59, 0, 0, time.Local), setts)
+	assert.False(t, setts.FilteringEnabled)
+
+	setts = newSetts()
+	applySchedule(c.Schedule, time.Date(2020, 11, 7, 7, 0, 0, 0, time.Local), setts)
+	assert.True(t, setts.FilteringEnabled)
+
+	// Friday morning
+	setts = newSetts()
+	applySchedule(c.Schedule, time.Date(2020, 11, 6, 6, 0, 0, 0, time.Local), setts)
+	assert.True(t, setts.FilteringEnabled)
+}
+
+func TestClientsLogSettings(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	ok, err := clients.Add(Client{
+		IDs:              []string{"1.1.1.1"},
+		Name:             "probe",
+		IgnoreQueryLog:   true,
+		IgnoreStatistics: true,
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = clients.Add(Client{
+		IDs:            []string{"2.2.2.2"},
+		Name:           "phone",
+		IgnoreQueryLog: true,
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	ignoreQueryLog, ignoreStats := clients.FindLogSettings("1.1.1.1", "")
+	assert.True(t, ignoreQueryLog && ignoreStats)
+	ignoreQueryLog, ignoreStats = clients.FindLogSettings("2.2.2.2", "")
+	assert.True(t, ignoreQueryLog && !ignoreStats)
+	ignoreQueryLog, ignoreStats = clients.FindLogSettings("3.3.3.3", "")
+	assert.False(t, ignoreQueryLog || ignoreStats)
+
+	// the settings are stored in configuration file
+	objects := []clientObject{}
+	clients.WriteDiskConfig(&objects)
+	clients2 := clientsContainer{}
+	clients2.testing = true
+	clients2.Init(objects, nil, nil)
+	ignoreQueryLog, ignoreStats = clients2.FindLogSettings("2.2.2.2", "")
+	assert.True(t, ignoreQueryLog && !ignoreStats)
+}
+
+func TestClientsDHCPDevice(t *testing.T) {
+	clients := clientsContainer{}
+	clients.testing = true
+	clients.Init(nil, nil, nil)
+
+	config := dhcpd.ServerConfig{
+		DBFilePath: "leases.db",
+	}
+	defer func() { _ = os.Remove("leases.db") }()
+	clients.dhcpServer = dhcpd.Create(config)
+	err := clients.dhcpServer.AddStaticLease(dhcpd.Lease{
+		HWAddr:   net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		IP:       net.IP{1, 2, 3, 4},
+		Hostname: "johns-phone",
+		Device:   "Apple iPhone",
+	})
+	assert.Nil(t, err)
+	err = clients.dhcpServer.AddStaticLease(dhcpd.Lease{
+		HWAddr: net.HardwareAddr{1, 2, 3, 4, 5, 7},
+		IP:     net.IP{1, 2, 3, 5},
+		Device: "Samsung TV",
+	})
+	assert.Nil(t, err)
+
+	clients.addFromDHCP()
+
+	ch := clients.ipHost["1.2.3.4"]
+	assert.Equal(t, "johns-phone", ch.Host)
+	assert.Equal(t, "Apple iPhone", ch.Device)
+
+	// no host name:  the device type is shown instead
+	ch = clients.ipHost["1.2.3.5"]
+	assert.Equal(t, "Samsung TV", ch.Host)
+	assert.Equal(t, "Samsung TV", ch.Device)
+}
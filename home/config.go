@@ -12,7 +12,6 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/querylog"
 	"github.com/AdguardTeam/AdGuardHome/stats"
-	"github.com/AdguardTeam/golibs/file"
 	"github.com/AdguardTeam/golibs/log"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -35,6 +34,9 @@ type configuration struct {
 	// It's reset after config is parsed
 	fileData []byte
 
+	// The keys whose values are stored in the included files
+	includes []configInclude
+
 	// cached version.json to avoid hammering github.io for each page reload
 	versionCheckJSON     []byte
 	versionCheckLastTime time.Time
@@ -47,10 +49,31 @@ type configuration struct {
 	RlimitNoFile uint   `yaml:"rlimit_nofile"` // Maximum number of opened fd's per process (0: default)
 	DebugPProf   bool   `yaml:"debug_pprof"`   // Enable pprof HTTP server on port 6060
 
+	// Running behind a reverse proxy:
+	// URL prefix of the web interface and API (e.g. "/adguard");  empty: "/"
+	WebBasePath string `yaml:"base_path"`
+	// IP addresses and networks of the reverse proxies which may set X-Forwarded-For, X-Real-IP and X-Forwarded-Proto headers
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
 	// TTL for a web session (in hours)
 	// An active session is automatically refreshed once a day.
 	WebSessionTTLHours uint32 `yaml:"web_session_ttl"`
 
+	// Brute-force protection:  block the IP for AuthBlockMin minutes after AuthAttempts failed authentication attempts
+	// AuthAttempts = 0: disabled
+	AuthAttempts uint `yaml:"auth_attempts"`
+	AuthBlockMin uint `yaml:"block_auth_min"`
+
+	// API tokens for scripts and integrations
+	APITokens []APIToken `yaml:"api_tokens"`
+
+	// Update channel: "release" or "beta";  empty: the channel the application is built for
+	UpdateChannel string `yaml:"update_channel"`
+
+	// The number of the last versions of the configuration file which are kept in the data directory
+	// 0: disabled
+	ConfigHistorySize int `yaml:"config_history_size"`
+
 	DNS dnsConfig         `yaml:"dns"`
 	TLS tlsConfigSettings `yaml:"tls"`
 
@@ -72,22 +95,40 @@ type configuration struct {
 
 // field ordering is important -- yaml fields will mirror ordering from here
 type dnsConfig struct {
-	BindHost string `yaml:"bind_host"`
-	Port     int    `yaml:"port"`
+	BindHosts []string `yaml:"bind_hosts"` // IP addresses and names of network interfaces to listen on
+	Port      int      `yaml:"port"`
 
 	// time interval for statistics (in days)
 	StatsInterval uint32 `yaml:"statistics_interval"`
 
 	QueryLogEnabled   bool   `yaml:"querylog_enabled"`     // if true, query log is enabled
-	QueryLogInterval  uint32 `yaml:"querylog_interval"`    // time interval for query log (in days)
+	QueryLogInterval  uint32 `yaml:"querylog_interval"`    // time interval for query log (in days);  0: no limit
 	QueryLogMemSize   uint32 `yaml:"querylog_size_memory"` // number of entries kept in memory before they are flushed to disk
 	AnonymizeClientIP bool   `yaml:"anonymize_client_ip"`  // anonymize clients' IP addresses in logs and stats
 
+	HostsFileEnabled bool   `yaml:"hosts_file_enabled"` // resolve host names from the system hosts-file (e.g. /etc/hosts)
+	HostsFile        string `yaml:"hosts_file"`         // additional hosts-file (optional)
+
 	dnsforward.FilteringConfig `yaml:",inline"`
 
 	FilteringEnabled           bool             `yaml:"filtering_enabled"`       // whether or not use filter lists
 	FiltersUpdateIntervalHours uint32           `yaml:"filters_update_interval"` // time period to update filters (in hours)
 	DnsfilterConf              dnsfilter.Config `yaml:",inline"`
+
+	DNSCrypt dnsCryptConfig `yaml:"dnscrypt"`
+}
+
+// field ordering is important -- yaml fields will mirror ordering from here
+type dnsCryptConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Port         int    `yaml:"port"`          // UDP and TCP port for DNSCrypt requests
+	ProviderName string `yaml:"provider_name"` // e.g. "2.dnscrypt-cert.example.org"
+
+	// These keys are generated automatically if the private key is empty
+	PublicKey  string `yaml:"public_key"`      // hex-encoded long-term Ed25519 public key
+	PrivateKey string `yaml:"private_key"`     // hex-encoded long-term Ed25519 private key which signs the certificate
+	ResolverSk string `yaml:"resolver_secret"` // hex-encoded short-term X25519 secret key
+	ResolverPk string `yaml:"resolver_public"` // hex-encoded short-term X25519 public key
 }
 
 type tlsConfigSettings struct {
@@ -100,6 +141,35 @@ type tlsConfigSettings struct {
 	// Allow DOH queries via unencrypted HTTP (e.g. for reverse proxying)
 	AllowUnencryptedDOH bool `yaml:"allow_unencrypted_doh" json:"allow_unencrypted_doh"`
 
+	// Don't serve DNS-over-HTTPS requests on /dns-query
+	DisableDOH bool `yaml:"disable_doh" json:"disable_doh"`
+
+	// Send Strict-Transport-Security header in HTTPS responses of the web interface
+	HSTS bool `yaml:"hsts" json:"hsts"`
+
+	// Pad DNS-over-TLS and DNS-over-HTTPS responses with EDNS0 Padding option (RFC 8467)
+	EDNSPadding bool `yaml:"edns_padding" json:"edns_padding"`
+
+	// TLS versions and cipher suites of HTTPS, DNS-over-HTTPS and DNS-over-TLS servers
+	MinVersion   string   `yaml:"min_version" json:"min_version"`     // "1.0", "1.1", "1.2", "1.3";  empty: "1.2"
+	MaxVersion   string   `yaml:"max_version" json:"max_version"`     // empty: the highest supported
+	CipherSuites []string `yaml:"cipher_suites" json:"cipher_suites"` // empty: the default list
+
+	// PEM file with CA certificates:  DNS-over-TLS and DNS-over-HTTPS clients must present a certificate signed by one of them
+	// Empty: client certificates aren't required
+	ClientCAPath string `yaml:"client_ca_path" json:"client_ca_path"`
+
+	// PKCS#12 bundle with the certificate chain and the private key (instead of the certificate and private key settings)
+	PKCS12Path string `yaml:"pkcs12_path" json:"pkcs12_path"`
+
+	// Passphrase of the encrypted private key or PKCS#12 bundle;  it isn't returned by API
+	KeyPassphrase     string `yaml:"key_passphrase" json:"-"`
+	KeyPassphraseEnv  string `yaml:"key_passphrase_env" json:"-"`  // the name of the environment variable with the passphrase
+	KeyPassphraseFile string `yaml:"key_passphrase_file" json:"-"` // the file with the passphrase
+
+	// Automatic certificates from ACME server (e.g. Let's Encrypt) for ServerName
+	ACME acmeConfig `yaml:"acme" json:"-"`
+
 	dnsforward.TLSConfig `yaml:",inline" json:",inline"`
 }
 
@@ -108,27 +178,37 @@ var config = configuration{
 	BindPort: 3000,
 	BindHost: "0.0.0.0",
 	DNS: dnsConfig{
-		BindHost:      "0.0.0.0",
-		Port:          53,
-		StatsInterval: 1,
+		BindHosts:        []string{"0.0.0.0"},
+		Port:             53,
+		StatsInterval:    1,
+		HostsFileEnabled: true,
 		FilteringConfig: dnsforward.FilteringConfig{
-			ProtectionEnabled:  true,      // whether or not use any of dnsfilter features
-			BlockingMode:       "default", // mode how to answer filtered requests
-			BlockedResponseTTL: 10,        // in seconds
-			Ratelimit:          20,
-			RefuseAny:          true,
-			AllServers:         false,
+			ProtectionEnabled:     true,      // whether or not use any of dnsfilter features
+			BlockingMode:          "default", // mode how to answer filtered requests
+			BlockedResponseTTL:    10,        // in seconds
+			Ratelimit:             20,
+			RatelimitResponse:     "drop",
+			RefuseAny:             true,
+			AllServers:            false,
+			UpstreamCheckInterval: 60, // in seconds
 		},
 		FilteringEnabled:           true, // whether or not use filter lists
 		FiltersUpdateIntervalHours: 24,
+		DNSCrypt: dnsCryptConfig{
+			Port: 5443,
+		},
 	},
 	TLS: tlsConfigSettings{
 		PortHTTPS:      443,
 		PortDNSOverTLS: 853, // needs to be passed through to dnsproxy
+		EDNSPadding:    true,
 	},
 	DHCP: dhcpd.ServerConfig{
 		LeaseDuration: 86400,
 		ICMPTimeout:   1000,
+		V6: dhcpd.V6ServerConf{
+			LeaseDuration: 86400,
+		},
 	},
 	SchemaVersion: currentSchemaVersion,
 }
@@ -136,6 +216,9 @@ var config = configuration{
 // initConfig initializes default configuration for the current OS&ARCH
 func initConfig() {
 	config.WebSessionTTLHours = 30 * 24
+	config.AuthAttempts = 5
+	config.AuthBlockMin = 15
+	config.ConfigHistorySize = 10
 
 	config.DNS.QueryLogEnabled = true
 	config.DNS.QueryLogInterval = 90
@@ -194,9 +277,44 @@ func parseConfig() error {
 		return err
 	}
 
+	if len(config.includes) != 0 {
+		// the included files are written only if these values are changed
+		data, err := yaml.Marshal(&config)
+		if err == nil {
+			setIncludeValues(data, config.includes)
+		}
+	}
+
+	err = readPasswordFiles(config.Users)
+	if err != nil {
+		log.Error("%s", err)
+		return err
+	}
+
 	if !checkFiltersUpdateIntervalHours(config.DNS.FiltersUpdateIntervalHours) {
 		config.DNS.FiltersUpdateIntervalHours = 24
 	}
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for i := range filters {
+			f := &filters[i]
+			if !checkFiltersUpdateIntervalHours(f.UpdateInterval) {
+				log.Error("filter %s: unsupported update interval: %d", f.URL, f.UpdateInterval)
+				f.UpdateInterval = 0
+			}
+		}
+	}
+
+	config.WebBasePath, err = normalizeBasePath(config.WebBasePath)
+	if err != nil {
+		log.Error("%s", err)
+		return err
+	}
+
+	err = dnsCryptInitKeys(&config.DNS.DNSCrypt)
+	if err != nil {
+		log.Error("%s", err)
+		return err
+	}
 
 	return nil
 }
@@ -213,6 +331,11 @@ func readConfigFile() ([]byte, error) {
 		log.Error("Couldn't read config file %s: %s", configFile, err)
 		return nil, err
 	}
+	d, config.includes, err = resolveIncludes(d, filepath.Dir(configFile))
+	if err != nil {
+		log.Error("Couldn't read config file %s: %s", configFile, err)
+		return nil, err
+	}
 	return d, nil
 }
 
@@ -221,10 +344,39 @@ func (c *configuration) write() error {
 	c.Lock()
 	defer c.Unlock()
 
+	yamlText, err := c.generateYAML()
+	if err != nil {
+		return err
+	}
+
+	configFile := config.getConfigFilename()
+	log.Debug("Writing YAML file: %s", configFile)
+	err = writeConfigFile(yamlText)
+	if err != nil {
+		log.Error("Couldn't save YAML config: %s", err)
+		return err
+	}
+
+	saveConfigHistory(yamlText, c.ConfigHistorySize)
+	return nil
+}
+
+// Get the current settings of all modules and generate the configuration file data
+// The caller must hold the lock.
+func (c *configuration) generateYAML() ([]byte, error) {
 	Context.clients.WriteDiskConfig(&config.Clients)
 
 	if Context.auth != nil {
-		config.Users = Context.auth.GetUsers()
+		// the password hashes which are read from the files aren't written to the configuration file
+		users := Context.auth.GetUsers()
+		config.Users = make([]User, len(users))
+		for i, u := range users {
+			if len(u.PasswordFile) != 0 {
+				u.PasswordHash = ""
+			}
+			config.Users[i] = u
+		}
+		config.APITokens = Context.auth.GetAPITokens()
 	}
 	if Context.tls != nil {
 		tlsConf := tlsConfigSettings{}
@@ -265,19 +417,11 @@ func (c *configuration) write() error {
 		config.DHCP = c
 	}
 
-	configFile := config.getConfigFilename()
-	log.Debug("Writing YAML file: %s", configFile)
 	yamlText, err := yaml.Marshal(&config)
 	config.Clients = nil
 	if err != nil {
 		log.Error("Couldn't generate YAML file: %s", err)
-		return err
-	}
-	err = file.SafeWrite(configFile, yamlText)
-	if err != nil {
-		log.Error("Couldn't save YAML config: %s", err)
-		return err
+		return nil, err
 	}
-
-	return nil
+	return yamlText, nil
 }
@@ -0,0 +1,200 @@
+package home
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Backup and restore of the settings:
+//  GET /control/backup returns .tar.gz archive with the configuration file (AdGuardHome.yaml).
+//   The configuration file contains all the settings including the user rules and the static DHCP leases.
+//  POST /control/restore receives the archive, checks the configuration file and replaces the current one.
+//   The configuration of an older schema version is upgraded;  the newer schema version isn't supported.
+//   The application is restarted to apply the settings.
+
+const (
+	backupConfigName = "AdGuardHome.yaml"
+	maxBackupSize    = 64 * 1024 * 1024
+)
+
+// Create the backup archive with the configuration file
+func makeBackupArchive(configData []byte, modTime time.Time) ([]byte, error) {
+	buf := bytes.Buffer{}
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	hdr := tar.Header{
+		Name:    backupConfigName,
+		Mode:    0600,
+		Size:    int64(len(configData)),
+		ModTime: modTime,
+	}
+	err := tarWriter.WriteHeader(&hdr)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tarWriter.Write(configData)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gzWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Get the configuration file from the backup archive
+func readBackupArchive(r io.Reader) ([]byte, error) {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %s", err)
+	}
+	tarReader := tar.NewReader(gzReader)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %s", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || strings.TrimPrefix(hdr.Name, "./") != backupConfigName {
+			continue
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tarReader, maxBackupSize))
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %s", err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s isn't found in the archive", backupConfigName)
+}
+
+// Check the configuration file data restored from the backup and upgrade its schema if necessary
+// Return the data to write to the configuration file
+func prepareRestoredConfig(data []byte) ([]byte, error) {
+	diskConfig := map[string]interface{}{}
+	err := yaml.Unmarshal(data, &diskConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", backupConfigName, err)
+	}
+	schemaVersion, ok := diskConfig["schema_version"].(int)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema_version is missing or invalid", backupConfigName)
+	}
+	if schemaVersion > currentSchemaVersion {
+		return nil, fmt.Errorf("%s: schema version %d isn't supported: the backup is made by a newer version of the application",
+			backupConfigName, schemaVersion)
+	}
+	if schemaVersion < currentSchemaVersion {
+		log.Info("Restore: upgrading the configuration from schema version %d", schemaVersion)
+		err = upgradeConfigSchema(schemaVersion, &diskConfig)
+		if err != nil {
+			return nil, err
+		}
+		data, err = yaml.Marshal(diskConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	errs := checkConfigData(data, filepath.Dir(config.getConfigFilename()), &configuration{})
+	if len(errs) != 0 {
+		lines := []string{}
+		for _, e := range errs {
+			s := fmt.Sprintf("line %d: %s", e.line, e.text)
+			if len(e.file) != 0 {
+				s = e.file + ": " + s
+			}
+			lines = append(lines, s)
+		}
+		return nil, fmt.Errorf("%s:\n%s", backupConfigName, strings.Join(lines, "\n"))
+	}
+	return data, nil
+}
+
+// Download the backup archive
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	config.Lock()
+	data, err := config.generateYAML()
+	config.Unlock()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Couldn't generate the configuration: %s", err)
+		return
+	}
+
+	now := time.Now()
+	archive, err := makeBackupArchive(data, now)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Couldn't create the archive: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"AdGuardHome-backup-%s.tar.gz\"", now.Format("20060102-150405")))
+	_, _ = w.Write(archive)
+}
+
+// Restore the settings from the backup archive and restart the application
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	data, err := readBackupArchive(http.MaxBytesReader(w, r.Body, maxBackupSize))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	restoreConfig(w, data)
+}
+
+// Check the configuration file data, replace the configuration file with it and restart the application
+func restoreConfig(w http.ResponseWriter, data []byte) {
+	data, err := prepareRestoredConfig(data)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	binName, err := os.Executable()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Couldn't get the path of the executable: %s", err)
+		return
+	}
+
+	config.Lock()
+	configFile := config.getConfigFilename()
+	err = writeConfigFile(data)
+	if err == nil {
+		saveConfigHistory(data, config.ConfigHistorySize)
+	}
+	config.Unlock()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Couldn't write the configuration file: %s", err)
+		return
+	}
+	log.Info("Restore: the configuration file %s has been replaced, restarting", configFile)
+
+	returnOK(w)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	go restartApp(binName)
+}
@@ -0,0 +1,48 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRestore(t *testing.T) {
+	conf := fmt.Sprintf(`bind_host: 0.0.0.0
+bind_port: 3000
+dns:
+  bind_hosts:
+  - 0.0.0.0
+  port: 53
+  upstream_dns:
+  - 1.1.1.1
+  filters_update_interval: 24
+user_rules:
+- '||example.org^'
+schema_version: %d
+`, currentSchemaVersion)
+
+	archive, err := makeBackupArchive([]byte(conf), time.Now())
+	assert.Nil(t, err)
+	data, err := readBackupArchive(bytes.NewReader(archive))
+	assert.Nil(t, err)
+	assert.Equal(t, conf, string(data))
+
+	data, err = prepareRestoredConfig(data)
+	assert.Nil(t, err)
+	assert.Equal(t, conf, string(data))
+
+	// invalid archive
+	_, err = readBackupArchive(bytes.NewReader([]byte(conf)))
+	assert.NotNil(t, err)
+
+	// newer schema version
+	_, err = prepareRestoredConfig([]byte(fmt.Sprintf("schema_version: %d\n", currentSchemaVersion+1)))
+	assert.NotNil(t, err)
+
+	// invalid settings
+	_, err = prepareRestoredConfig([]byte(fmt.Sprintf("bind_host: 1.2.3\nschema_version: %d\n", currentSchemaVersion)))
+	assert.NotNil(t, err)
+}
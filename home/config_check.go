@@ -0,0 +1,536 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/golibs/log"
+	yaml "gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Checking the configuration file (--check-config):
+//  the file is checked without starting the services and without changing the file:
+//   syntax errors, unknown keys and the values of wrong type
+//   IP addresses, networks and ports
+//   DHCP ranges:  they must be in the subnet of the gateway and mustn't overlap each other
+//   the files of TLS settings (certificate, private key, etc.) must be readable
+//  The errors are printed with the line numbers and the exit code is 1.
+//  The included files (see config_include.go) are checked too.
+// If the file has an older schema version, only its syntax is checked:  the file is upgraded on start.
+
+// configPos - the position in the configuration file or in the included file
+type configPos struct {
+	file string // "": the configuration file
+	line int    // 0: unknown
+}
+
+// configError - an error in the configuration file
+type configError struct {
+	configPos
+	text string
+}
+
+// configChecker - the state of the configuration file check
+type configChecker struct {
+	lines  map[string]configPos // the key path (e.g. "dhcp.interfaces[1].range_start") -> position
+	errors []configError
+
+	// the line numbers in the configuration data with the included data -> the key path
+	// nil: there are no included files
+	mergedLines map[int]string
+}
+
+// "line 12: field foo not found in type home.dnsConfig"
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+var yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type`)
+
+// Get the positions of the keys and the sequence items
+// files: the root nodes of the included data -> file name
+func yamlKeyLines(n *yaml3.Node, path string, file string, files map[*yaml3.Node]string, lines map[string]configPos) {
+	if fn, ok := files[n]; ok {
+		file = fn
+	}
+
+	switch n.Kind {
+	case yaml3.DocumentNode:
+		for _, c := range n.Content {
+			yamlKeyLines(c, path, file, files, lines)
+		}
+
+	case yaml3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			p := k.Value
+			if len(path) != 0 {
+				p = path + "." + k.Value
+			}
+			lines[p] = configPos{file: file, line: k.Line}
+			yamlKeyLines(n.Content[i+1], p, file, files, lines)
+		}
+
+	case yaml3.SequenceNode:
+		for i, c := range n.Content {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			lines[p] = configPos{file: file, line: c.Line}
+			yamlKeyLines(c, p, file, files, lines)
+		}
+	}
+}
+
+// Add an error for the key
+// If the key is missing in the file, the line of its parent is used
+func (c *configChecker) add(path string, format string, args ...interface{}) {
+	c.errors = append(c.errors, configError{configPos: c.pos(path), text: path + ": " + fmt.Sprintf(format, args...)})
+}
+
+// Get the position of the key
+// If the key is missing in the file, the position of its parent is used
+func (c *configChecker) pos(path string) configPos {
+	for p := path; len(p) != 0; {
+		pos, ok := c.lines[p]
+		if ok {
+			return pos
+		}
+		i := strings.LastIndexAny(p, ".[")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return configPos{}
+}
+
+// Add the error returned by YAML parser
+// file: the file which is parsed ("": the configuration file)
+func (c *configChecker) addYAMLError(file string, text string) {
+	m := yamlErrorLine.FindStringSubmatch(text)
+	if m == nil {
+		c.errors = append(c.errors, configError{configPos: configPos{file: file}, text: text})
+		return
+	}
+	line, _ := strconv.Atoi(m[1])
+	msg := m[2]
+	f := yamlUnknownField.FindStringSubmatch(msg)
+	if f != nil {
+		msg = "unknown key: " + f[1]
+	}
+	pos := configPos{file: file, line: line}
+	if c.mergedLines != nil {
+		// the line number in the data with the included data
+		pos = configPos{}
+		if p, ok := c.mergedLines[line]; ok {
+			pos = c.pos(p)
+		}
+	}
+	c.errors = append(c.errors, configError{configPos: pos, text: msg})
+}
+
+// Parse the configuration file data and read the included files
+// Return the data with the included data
+func (c *configChecker) parse(data []byte, dir string) ([]byte, bool) {
+	ic, err := parseIncludes(data, dir)
+	if err != nil {
+		if ie, ok := err.(*includeError); ok {
+			c.addYAMLError(ie.file, ie.err.Error())
+		} else {
+			c.addYAMLError("", err.Error())
+		}
+		return nil, false
+	}
+	yamlKeyLines(&ic.root, "", "", ic.files, c.lines)
+	if len(ic.includes) == 0 {
+		return data, true
+	}
+
+	data, err = ic.merged()
+	if err != nil {
+		c.addYAMLError("", err.Error())
+		return nil, false
+	}
+	node := yaml3.Node{}
+	err = yaml3.Unmarshal(data, &node)
+	if err != nil {
+		c.addYAMLError("", err.Error())
+		return nil, false
+	}
+	merged := map[string]configPos{}
+	yamlKeyLines(&node, "", "", nil, merged)
+	c.mergedLines = map[int]string{}
+	for p, pos := range merged {
+		old, ok := c.mergedLines[pos.line]
+		if !ok || len(p) < len(old) {
+			c.mergedLines[pos.line] = p
+		}
+	}
+	return data, true
+}
+
+func (c *configChecker) checkIP(path string, s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		c.add(path, "invalid IP address: '%s'", s)
+	}
+	return ip
+}
+
+func (c *configChecker) checkIPv4(path string, s string) net.IP {
+	ip := c.checkIP(path, s)
+	if ip != nil && ip.To4() == nil {
+		c.add(path, "not an IPv4 address: %s", s)
+		return nil
+	}
+	return ip.To4()
+}
+
+// Check the port number;  optional: 0 is allowed
+func (c *configChecker) checkPort(path string, port int, optional bool) {
+	if port < 0 || port > 0xffff || (port == 0 && !optional) {
+		c.add(path, "invalid port: %d", port)
+	}
+}
+
+// Check that the file is readable
+func (c *configChecker) checkFile(path string, fn string) {
+	if len(fn) == 0 {
+		return
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		c.add(path, "%s", err)
+		return
+	}
+	f.Close()
+}
+
+func (c *configChecker) checkGeneral(conf *configuration) {
+	c.checkIP("bind_host", conf.BindHost)
+	c.checkPort("bind_port", conf.BindPort, false)
+	if !isValidUpdateChannel(conf.UpdateChannel) {
+		c.add("update_channel", "unknown update channel: '%s'", conf.UpdateChannel)
+	}
+	for i, u := range conf.Users {
+		c.checkFile(fmt.Sprintf("users[%d].password_file", i), u.PasswordFile)
+	}
+
+	_, err := normalizeBasePath(conf.WebBasePath)
+	if err != nil {
+		c.add("base_path", "%s", err)
+	}
+	for i, s := range conf.TrustedProxies {
+		_, err = parseTrustedProxies([]string{s})
+		if err != nil {
+			c.add(fmt.Sprintf("trusted_proxies[%d]", i), "%s", err)
+		}
+	}
+}
+
+func (c *configChecker) checkDNS(conf *dnsConfig) {
+	_, err := getDNSBindIPs(conf.BindHosts)
+	if err != nil {
+		c.add("dns.bind_hosts", "%s", err)
+	}
+	c.checkPort("dns.port", conf.Port, false)
+
+	if len(conf.UpstreamDNS) != 0 {
+		err := dnsforward.ValidateUpstreams(conf.UpstreamDNS)
+		if err != nil {
+			c.add("dns.upstream_dns", "%s", err)
+		}
+	}
+	err = dnsforward.ValidateUpstreamBind(conf.UpstreamBindAddress, conf.UpstreamDNS,
+		append(stringArrayDup(conf.PrivateZones), conf.LocalPTRUpstreams...))
+	if err != nil {
+		c.add("dns", "%s", err)
+	}
+	err = dnsforward.ValidateUpstreamPins(conf.UpstreamPins)
+	if err != nil {
+		c.add("dns", "%s", err)
+	}
+	err = dnsforward.ValidateLocalPTRUpstreams(conf.LocalPTRUpstreams)
+	if err != nil {
+		c.add("dns", "%s", err)
+	}
+	err = dnsforward.ValidateUpstreamProxy(conf.UpstreamProxy, conf.UpstreamBindAddress)
+	if err != nil {
+		c.add("dns", "%s", err)
+	}
+	for i, s := range conf.BootstrapDNS {
+		if net.ParseIP(s) == nil {
+			_, _, err := net.SplitHostPort(s)
+			if err != nil {
+				c.add(fmt.Sprintf("dns.bootstrap_dns[%d]", i), "invalid bootstrap DNS server: '%s'", s)
+			}
+		}
+	}
+	err = dnsforward.ValidateRatelimitSettings(conf.RatelimitWhitelist, conf.RatelimitResponse)
+	if err != nil {
+		c.add("dns", "%s", err)
+	}
+	err = dnsforward.ValidateBlockedResponseIPs(conf.BlockedResponseIPs)
+	if err != nil {
+		c.add("dns", "%s", err)
+	}
+	err = dnsforward.ValidateAccessClients(conf.AllowedClients)
+	if err != nil {
+		c.add("dns.allowed_clients", "%s", err)
+	}
+	err = dnsforward.ValidateAccessClients(conf.DisallowedClients)
+	if err != nil {
+		c.add("dns.disallowed_clients", "%s", err)
+	}
+	if len(conf.BlockingIPv4) != 0 {
+		c.checkIPv4("dns.blocking_ipv4", conf.BlockingIPv4)
+	}
+	if len(conf.BlockingIPv6) != 0 {
+		ip := c.checkIP("dns.blocking_ipv6", conf.BlockingIPv6)
+		if ip != nil && ip.To4() != nil {
+			c.add("dns.blocking_ipv6", "not an IPv6 address: %s", conf.BlockingIPv6)
+		}
+	}
+
+	if !checkFiltersUpdateIntervalHours(conf.FiltersUpdateIntervalHours) {
+		c.add("dns.filters_update_interval", "unsupported update interval: %d", conf.FiltersUpdateIntervalHours)
+	}
+
+	if conf.DNSCrypt.Enabled {
+		c.checkPort("dns.dnscrypt.port", conf.DNSCrypt.Port, false)
+	}
+}
+
+func (c *configChecker) checkFilters(name string, filters []filter) {
+	for i, f := range filters {
+		if !checkFiltersUpdateIntervalHours(f.UpdateInterval) {
+			c.add(fmt.Sprintf("%s[%d].update_interval", name, i), "unsupported update interval: %d", f.UpdateInterval)
+		}
+	}
+}
+
+func (c *configChecker) checkTLS(conf *tlsConfigSettings) {
+	if !conf.Enabled {
+		return
+	}
+	c.checkPort("tls.port_https", conf.PortHTTPS, true)
+	c.checkPort("tls.port_dns_over_tls", conf.PortDNSOverTLS, true)
+
+	_, err := parseTLSVersion(conf.MinVersion, 0)
+	if err != nil {
+		c.add("tls.min_version", "%s", err)
+	}
+	_, err = parseTLSVersion(conf.MaxVersion, 0)
+	if err != nil {
+		c.add("tls.max_version", "%s", err)
+	}
+	for i, s := range conf.CipherSuites {
+		_, err = parseCipherSuites([]string{s})
+		if err != nil {
+			c.add(fmt.Sprintf("tls.cipher_suites[%d]", i), "%s", err)
+		}
+	}
+
+	if !conf.ACME.Enabled {
+		// the files of ACME certificate don't exist until it's issued
+		c.checkFile("tls.certificate_path", conf.CertificatePath)
+		c.checkFile("tls.private_key_path", conf.PrivateKeyPath)
+		c.checkFile("tls.pkcs12_path", conf.PKCS12Path)
+	}
+	c.checkFile("tls.client_ca_path", conf.ClientCAPath)
+	c.checkFile("tls.key_passphrase_file", conf.KeyPassphraseFile)
+}
+
+// DHCPv4 range of an interface
+type dhcpRange struct {
+	path  string
+	iface string
+	start net.IP
+	end   net.IP
+}
+
+// Check DHCPv4 settings of an interface
+// Return nil if the range is invalid
+func (c *configChecker) checkDHCPRange(path string, iface, gateway, mask, start, end string) *dhcpRange {
+	if len(iface) == 0 {
+		c.add(path+".interface_name", "the interface isn't set")
+	}
+	gw := c.checkIPv4(path+".gateway_ip", gateway)
+	m := c.checkIPv4(path+".subnet_mask", mask)
+	if m != nil {
+		_, bits := net.IPMask(m).Size()
+		if bits == 0 {
+			c.add(path+".subnet_mask", "invalid subnet mask: %s", mask)
+			m = nil
+		}
+	}
+	r := &dhcpRange{
+		path:  path,
+		iface: iface,
+		start: c.checkIPv4(path+".range_start", start),
+		end:   c.checkIPv4(path+".range_end", end),
+	}
+	if r.start == nil || r.end == nil {
+		return nil
+	}
+	if bytes.Compare(r.start, r.end) > 0 {
+		c.add(path+".range_start", "range_start %s is greater than range_end %s", start, end)
+		return nil
+	}
+	if gw != nil && m != nil {
+		subnet := net.IPNet{IP: gw.Mask(net.IPMask(m)), Mask: net.IPMask(m)}
+		if !subnet.Contains(r.start) || !subnet.Contains(r.end) {
+			c.add(path+".range_start", "the range %s-%s isn't in the subnet %s", start, end, subnet.String())
+		}
+	}
+	return r
+}
+
+func (c *configChecker) checkDHCP(conf *dhcpd.ServerConfig) {
+	for i, l := range conf.StaticLeases {
+		path := fmt.Sprintf("dhcp.static_leases[%d]", i)
+		_, err := net.ParseMAC(l.HWAddr)
+		if err != nil {
+			c.add(path+".mac", "invalid MAC address: '%s'", l.HWAddr)
+		}
+		c.checkIPv4(path+".ip", l.IP)
+	}
+
+	if !conf.Enabled {
+		return
+	}
+
+	ranges := []*dhcpRange{}
+	if !conf.ProxyDHCP && (!conf.V6.Enabled || len(conf.RangeStart) != 0) {
+		r := c.checkDHCPRange("dhcp", conf.InterfaceName, conf.GatewayIP, conf.SubnetMask, conf.RangeStart, conf.RangeEnd)
+		ranges = append(ranges, r)
+	}
+
+	names := map[string]bool{conf.InterfaceName: true}
+	for i, ic := range conf.Interfaces {
+		path := fmt.Sprintf("dhcp.interfaces[%d]", i)
+		if len(ic.InterfaceName) != 0 && names[ic.InterfaceName] {
+			c.add(path+".interface_name", "interface %s is used more than once", ic.InterfaceName)
+		}
+		names[ic.InterfaceName] = true
+		r := c.checkDHCPRange(path, ic.InterfaceName, ic.GatewayIP, ic.SubnetMask, ic.RangeStart, ic.RangeEnd)
+		ranges = append(ranges, r)
+	}
+
+	for i, a := range ranges {
+		for _, b := range ranges[i+1:] {
+			if a == nil || b == nil {
+				continue
+			}
+			if bytes.Compare(b.start, a.end) <= 0 && bytes.Compare(a.start, b.end) <= 0 {
+				c.add(b.path+".range_start", "the range %s-%s overlaps with the range %s-%s of interface %s",
+					b.start, b.end, a.start, a.end, a.iface)
+			}
+		}
+	}
+
+	if conf.V6.Enabled && len(conf.V6.RangeStart) != 0 {
+		ip := c.checkIP("dhcp.dhcpv6.range_start", conf.V6.RangeStart)
+		if ip != nil && ip.To4() != nil {
+			c.add("dhcp.dhcpv6.range_start", "not an IPv6 address: %s", conf.V6.RangeStart)
+		}
+	}
+}
+
+// Check the configuration file data
+// dir: the directory of the configuration file (the included files are relative to it)
+// conf: the object with the default settings;  it's filled with the data
+// Return the errors sorted by file and line number
+func checkConfigData(data []byte, dir string, conf *configuration) []configError {
+	c := configChecker{lines: map[string]configPos{}}
+
+	data, ok := c.parse(data, dir)
+	if !ok {
+		return c.errors
+	}
+
+	schema := struct {
+		Version int `yaml:"schema_version"`
+	}{}
+	err := yaml.Unmarshal(data, &schema)
+	if err != nil {
+		c.addYAMLError("", err.Error())
+		return c.errors
+	}
+	if schema.Version > currentSchemaVersion {
+		c.add("schema_version", "unknown schema version: %d", schema.Version)
+		return c.errors
+	}
+	if schema.Version < currentSchemaVersion {
+		log.Info("The configuration file has schema version %d and will be upgraded to version %d on start:  only its syntax is checked",
+			schema.Version, currentSchemaVersion)
+		return nil
+	}
+
+	err = yaml.UnmarshalStrict(data, conf)
+	if err != nil {
+		te, ok := err.(*yaml.TypeError)
+		if !ok {
+			c.addYAMLError("", err.Error())
+			return c.errors
+		}
+		// the other values are decoded
+		for _, s := range te.Errors {
+			c.addYAMLError("", s)
+		}
+	}
+
+	c.checkGeneral(conf)
+	c.checkDNS(&conf.DNS)
+	c.checkFilters("filters", conf.Filters)
+	c.checkFilters("whitelist_filters", conf.WhitelistFilters)
+	c.checkTLS(&conf.TLS)
+	c.checkDHCP(&conf.DHCP)
+
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		a, b := c.errors[i], c.errors[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		return a.line < b.line
+	})
+	return c.errors
+}
+
+// Check the configuration file and print the errors
+// Return the exit code
+func checkConfigFile() int {
+	fn := config.getConfigFilename()
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	dir := filepath.Dir(fn)
+	errs := checkConfigData(data, dir, &config)
+	for _, e := range errs {
+		name := fn
+		if len(e.file) != 0 {
+			name = includePath(dir, e.file)
+		}
+		if e.line != 0 {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, e.line, e.text)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, e.text)
+		}
+	}
+	if len(errs) != 0 {
+		return 1
+	}
+
+	log.Info("Configuration file is OK")
+	return 0
+}
@@ -0,0 +1,68 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCheckConfigData(t *testing.T) {
+	conf := configuration{
+		BindHost:      "0.0.0.0",
+		BindPort:      3000,
+		SchemaVersion: currentSchemaVersion,
+	}
+	conf.DNS.BindHosts = []string{"0.0.0.0"}
+	conf.DNS.Port = 53
+	conf.DNS.FiltersUpdateIntervalHours = 24
+	conf.DNS.UpstreamDNS = []string{"1.1.1.1"}
+	data, err := yaml.Marshal(&conf)
+	assert.Nil(t, err)
+	errs := checkConfigData(data, "", &configuration{})
+	assert.Equal(t, 0, len(errs))
+
+	data = []byte(`bind_host: 0.0.0.0
+bind_port: 70000
+dns:
+  bind_hosts: [1.2.3]
+  port: 53
+  unknown_key: 1
+  upstream_dns:
+  - 1.1.1.1
+tls:
+  enabled: true
+  certificate_path: /nonexistent/cert.pem
+dhcp:
+  enabled: true
+  interface_name: eth0
+  gateway_ip: 192.168.1.1
+  subnet_mask: 255.255.255.0
+  range_start: 192.168.1.100
+  range_end: 192.168.1.200
+  interfaces:
+  - interface_name: eth1
+    gateway_ip: 192.168.1.1
+    subnet_mask: 255.255.0.0
+    range_start: 192.168.1.150
+    range_end: 192.168.1.250
+  - interface_name: eth2
+    gateway_ip: 10.0.0.1
+    subnet_mask: 255.255.255.0
+    range_start: 10.0.1.10
+    range_end: 10.0.1.20
+schema_version: 8
+`)
+	errs = checkConfigData(data, "", &configuration{})
+	lines := []int{}
+	for _, e := range errs {
+		lines = append(lines, e.line)
+	}
+	// port, bind_hosts, unknown key, certificate, overlapping range, range outside of the subnet
+	assert.Equal(t, []int{2, 4, 6, 11, 23, 28}, lines)
+	assert.Equal(t, "unknown key: unknown_key", errs[2].text)
+
+	errs = checkConfigData([]byte("dns:\n  port: [\n"), "", &configuration{})
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, 2, errs[0].line)
+}
@@ -0,0 +1,214 @@
+package home
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// History of the configuration file:
+//  every time the configuration file is written, its copy is saved to "data/config_history/<ID>.yaml"
+//  (unless the data is the same as of the last saved version).
+//  ID is UTC time of the change (e.g. "20201017-120000.123456"), so the file names are sorted by time.
+//  Only the last config_history_size versions are kept.
+// A previous version may be compared with the current configuration or with another version
+//  and restored:  the same way as the backup is restored (see config_backup.go).
+
+const (
+	configHistoryDir      = "config_history"
+	configHistoryIDFormat = "20060102-150405.000000"
+)
+
+var configHistoryIDRegexp = regexp.MustCompile(`^\d{8}-\d{6}\.\d{6}$`)
+
+// A saved version of the configuration file
+type configVersion struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+func configHistoryPath() string {
+	return filepath.Join(Context.getDataDir(), configHistoryDir)
+}
+
+// Get the list of saved versions (the newest first)
+func listConfigHistory(dir string) []configVersion {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("Config history: %s", err)
+		}
+		return nil
+	}
+
+	list := []configVersion{}
+	for _, fi := range files {
+		id := strings.TrimSuffix(fi.Name(), ".yaml")
+		if !fi.Mode().IsRegular() || !configHistoryIDRegexp.MatchString(id) {
+			continue
+		}
+		t, _ := time.Parse(configHistoryIDFormat, id)
+		list = append(list, configVersion{ID: id, Time: t, Size: fi.Size()})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// Save the configuration file data to the history directory and remove the old versions
+func saveConfigHistoryTo(dir string, data []byte, size int, now time.Time) {
+	if size <= 0 {
+		return
+	}
+	list := listConfigHistory(dir)
+	if len(list) != 0 {
+		last, err := ioutil.ReadFile(filepath.Join(dir, list[0].ID+".yaml"))
+		if err == nil && bytes.Equal(last, data) {
+			return
+		}
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Error("Config history: %s", err)
+		return
+	}
+	id := now.UTC().Format(configHistoryIDFormat)
+	err = file.SafeWrite(filepath.Join(dir, id+".yaml"), data)
+	if err != nil {
+		log.Error("Config history: %s", err)
+		return
+	}
+	log.Debug("Config history: saved version %s", id)
+
+	if len(list) >= size {
+		for _, v := range list[size-1:] {
+			err = os.Remove(filepath.Join(dir, v.ID+".yaml"))
+			if err != nil {
+				log.Error("Config history: %s", err)
+			}
+		}
+	}
+}
+
+// Save the configuration file data to the history
+func saveConfigHistory(data []byte, size int) {
+	saveConfigHistoryTo(configHistoryPath(), data, size, time.Now())
+}
+
+// Read the saved version
+func readConfigVersion(id string) ([]byte, error) {
+	if !configHistoryIDRegexp.MatchString(id) {
+		return nil, fmt.Errorf("invalid version ID: %s", id)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(configHistoryPath(), id+".yaml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("version %s isn't found", id)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Get the unified diff between two versions of the configuration file
+func configDiff(a, b []byte, nameA, nameB string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: nameA,
+		ToFile:   nameB,
+		Context:  3,
+	})
+}
+
+// List the saved versions
+func handleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	list := listConfigHistory(configHistoryPath())
+	if list == nil {
+		list = []configVersion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(list)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "json.Encode: %s", err)
+		return
+	}
+}
+
+// Compare the saved version with the current configuration or with another saved version
+// ?id=...[&with=...]
+func handleConfigHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := q.Get("id")
+	data, err := readConfigVersion(id)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	with := q.Get("with")
+	var withData []byte
+	if len(with) != 0 {
+		withData, err = readConfigVersion(with)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	} else {
+		with = "current"
+		config.Lock()
+		withData, err = config.generateYAML()
+		config.Unlock()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "Couldn't generate the configuration: %s", err)
+			return
+		}
+	}
+
+	diff, err := configDiff(data, withData, id, with)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(diff))
+}
+
+type configVersionJSON struct {
+	ID string `json:"id"`
+}
+
+// Restore the saved version and restart the application
+func handleConfigHistoryRestore(w http.ResponseWriter, r *http.Request) {
+	req := configVersionJSON{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json.Decode: %s", err)
+		return
+	}
+	data, err := readConfigVersion(req.ID)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	log.Info("Config history: restoring version %s", req.ID)
+	restoreConfig(w, data)
+}
@@ -0,0 +1,43 @@
+package home
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-history")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	now := time.Date(2020, 10, 17, 12, 0, 0, 0, time.UTC)
+	for i := 0; i != 5; i++ {
+		data := []byte(fmt.Sprintf("bind_port: %d\n", 3000+i))
+		saveConfigHistoryTo(dir, data, 3, now.Add(time.Duration(i)*time.Second))
+	}
+	// the same data isn't saved again
+	saveConfigHistoryTo(dir, []byte("bind_port: 3004\n"), 3, now.Add(time.Minute))
+
+	list := listConfigHistory(dir)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, "20201017-120004.000000", list[0].ID)
+	assert.Equal(t, now.Add(4*time.Second), list[0].Time)
+	assert.Equal(t, "20201017-120002.000000", list[2].ID)
+
+	// disabled
+	saveConfigHistoryTo(dir, []byte("bind_port: 3005\n"), 0, now.Add(time.Hour))
+	assert.Equal(t, 3, len(listConfigHistory(dir)))
+
+	_, err = readConfigVersion("../AdGuardHome")
+	assert.NotNil(t, err)
+
+	diff, err := configDiff([]byte("a: 1\nb: 2\n"), []byte("a: 1\nb: 3\n"), "v1", "current")
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(diff, "-b: 2\n+b: 3\n"))
+}
@@ -0,0 +1,320 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Including files:
+//  the value of a key may be stored in a separate YAML file, e.g.:
+//   clients: !include clients.yaml
+//  The path is relative to the directory of the configuration file.
+//  Only the values of the keys may be included (not the items of the lists),
+//  and the included file can't include other files.
+//  When the configuration file is written, the included values are written back to their files.
+//  A file is written only if its value has changed:  the value is compared with the data in the file
+//  and with the value which was loaded from it (the data written by the application may differ from
+//  the user's data, e.g. it contains the default values).  So the user's formatting and comments are kept
+//  and the files which aren't changed via UI may be read-only.
+// Secrets from files:
+//  users[].password_file: the file with bcrypt hash of the password of the user
+//  tls.key_passphrase_file: the file with the passphrase of the private key (see tls_key.go)
+
+const includeTag = "!include"
+
+// A key whose value is stored in a separate file
+type configInclude struct {
+	path string // the key path, e.g. "dns.upstream_dns"
+	file string // the file name as it's written in the configuration file
+	data []byte // the value as it was last loaded or written by the application (YAML);  nil: unknown
+}
+
+// The configuration file data with the included files
+type includedConfig struct {
+	root     yaml3.Node
+	includes []configInclude
+	files    map[*yaml3.Node]string // the root node of the included data -> file name
+}
+
+// An error in the included file
+type includeError struct {
+	file string
+	err  error
+}
+
+func (e *includeError) Error() string {
+	return e.file + ": " + e.err.Error()
+}
+
+func includePath(dir, fn string) string {
+	if filepath.IsAbs(fn) {
+		return fn
+	}
+	return filepath.Join(dir, fn)
+}
+
+// Return TRUE if the node or its children are tagged with "!include"
+func hasIncludes(n *yaml3.Node) bool {
+	if n.Tag == includeTag {
+		return true
+	}
+	for _, c := range n.Content {
+		if hasIncludes(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace the values tagged with "!include" with the data of the included files
+func (ic *includedConfig) resolve(n *yaml3.Node, path string, dir string) error {
+	switch n.Kind {
+	case yaml3.DocumentNode:
+		for _, c := range n.Content {
+			err := ic.resolve(c, path, dir)
+			if err != nil {
+				return err
+			}
+		}
+
+	case yaml3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := n.Content[i].Value
+			if len(path) != 0 {
+				p = path + "." + p
+			}
+			v := n.Content[i+1]
+			if v.Tag != includeTag {
+				err := ic.resolve(v, p, dir)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if v.Kind != yaml3.ScalarNode || len(v.Value) == 0 {
+				return fmt.Errorf("line %d: %s: %s needs a file name", v.Line, p, includeTag)
+			}
+			fn := v.Value
+			data, err := ioutil.ReadFile(includePath(dir, fn))
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %s", v.Line, p, err)
+			}
+			doc := yaml3.Node{}
+			err = yaml3.Unmarshal(data, &doc)
+			if err != nil {
+				return &includeError{file: fn, err: err}
+			}
+			content := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!null", Value: "null"}
+			if len(doc.Content) != 0 {
+				content = doc.Content[0]
+			}
+			if hasIncludes(content) {
+				return &includeError{file: fn, err: fmt.Errorf("the included file can't include other files")}
+			}
+
+			*v = *content
+			ic.files[v] = fn
+			ic.includes = append(ic.includes, configInclude{path: p, file: fn})
+		}
+
+	case yaml3.SequenceNode:
+		for _, c := range n.Content {
+			if hasIncludes(c) {
+				return fmt.Errorf("line %d: %s: %s isn't supported in the lists", c.Line, path, includeTag)
+			}
+		}
+	}
+	return nil
+}
+
+// Parse the configuration file data and read the included files
+// dir: the directory of the configuration file
+func parseIncludes(data []byte, dir string) (*includedConfig, error) {
+	ic := &includedConfig{
+		files: map[*yaml3.Node]string{},
+	}
+	err := yaml3.Unmarshal(data, &ic.root)
+	if err != nil {
+		return nil, err
+	}
+	err = ic.resolve(&ic.root, "", dir)
+	if err != nil {
+		return nil, err
+	}
+	return ic, nil
+}
+
+func encodeYAML(n *yaml3.Node) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := yaml3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(n)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Get the configuration file data with the included data
+func (ic *includedConfig) merged() ([]byte, error) {
+	return encodeYAML(&ic.root)
+}
+
+// Read the included files
+// Return the configuration file data with the included data and the list of the included keys
+func resolveIncludes(data []byte, dir string) ([]byte, []configInclude, error) {
+	if !bytes.Contains(data, []byte(includeTag)) {
+		return data, nil, nil
+	}
+	ic, err := parseIncludes(data, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ic.includes) == 0 {
+		return data, nil, nil
+	}
+	data, err = ic.merged()
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, ic.includes, nil
+}
+
+// Find the value of the key
+func findYAMLKey(n *yaml3.Node, path string) *yaml3.Node {
+	if n.Kind == yaml3.DocumentNode {
+		if len(n.Content) == 0 {
+			return nil
+		}
+		n = n.Content[0]
+	}
+	for _, k := range strings.Split(path, ".") {
+		if n.Kind != yaml3.MappingNode {
+			return nil
+		}
+		var v *yaml3.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == k {
+				v = n.Content[i+1]
+				break
+			}
+		}
+		if v == nil {
+			return nil
+		}
+		n = v
+	}
+	return n
+}
+
+// Remember the included values from the configuration data generated by the application
+//  (the configuration file data isn't used:  e.g. it doesn't contain the default values)
+func setIncludeValues(data []byte, includes []configInclude) {
+	root := yaml3.Node{}
+	err := yaml3.Unmarshal(data, &root)
+	if err != nil {
+		return
+	}
+	for i := range includes {
+		inc := &includes[i]
+		n := findYAMLKey(&root, inc.path)
+		if n == nil {
+			continue
+		}
+		inc.data, _ = encodeYAML(n)
+	}
+}
+
+// Return TRUE if the file data has the same value as the node
+func sameYAMLValue(fileData []byte, n *yaml3.Node) bool {
+	var old, cur interface{}
+	err := yaml3.Unmarshal(fileData, &old)
+	if err != nil {
+		return false
+	}
+	err = n.Decode(&cur)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(old, cur)
+}
+
+// Write the changed included values to their files
+// Return the configuration file data with "!include" tags instead of the included values
+func writeIncludes(data []byte, includes []configInclude, dir string) ([]byte, error) {
+	root := yaml3.Node{}
+	err := yaml3.Unmarshal(data, &root)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range includes {
+		inc := &includes[i]
+		n := findYAMLKey(&root, inc.path)
+		if n == nil {
+			log.Debug("%s isn't found in the configuration data:  %s isn't written", inc.path, inc.file)
+			continue
+		}
+		incData, err := encodeYAML(n)
+		if err != nil {
+			return nil, err
+		}
+		fn := includePath(dir, inc.file)
+		old, err := ioutil.ReadFile(fn)
+		if err != nil ||
+			!(bytes.Equal(inc.data, incData) || sameYAMLValue(old, n)) {
+			err = file.SafeWrite(fn, incData)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", inc.file, err)
+			}
+			log.Debug("%s: written", inc.file)
+		}
+		inc.data = incData
+		*n = yaml3.Node{Kind: yaml3.ScalarNode, Tag: includeTag, Value: inc.file}
+	}
+
+	return encodeYAML(&root)
+}
+
+// Write the configuration file data
+// The included values are written to their files.
+func writeConfigFile(data []byte) error {
+	configFile := config.getConfigFilename()
+	if len(config.includes) != 0 {
+		var err error
+		data, err = writeIncludes(data, config.includes, filepath.Dir(configFile))
+		if err != nil {
+			return err
+		}
+	}
+	return file.SafeWrite(configFile, data)
+}
+
+// Read the password hashes of the users from the files
+func readPasswordFiles(users []User) error {
+	for i := range users {
+		u := &users[i]
+		if len(u.PasswordFile) == 0 {
+			continue
+		}
+		data, err := ioutil.ReadFile(u.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("user %s: password_file: %s", u.Name, err)
+		}
+		u.PasswordHash = strings.TrimSpace(string(data))
+	}
+	return nil
+}
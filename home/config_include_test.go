@@ -0,0 +1,134 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-include")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	const clientsData = "# the clients\n- name: client1\n  ids:\n  - 1.2.3.4\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "clients.yaml"), []byte(clientsData), 0600)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "upstreams.yaml"), []byte("- 1.1.1.1\n"), 0600)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "rules.yaml"), []byte("# the rules\n[ '||example.org^' ]\n"), 0600)
+	assert.Nil(t, err)
+
+	data := []byte(`bind_port: 3000
+dns:
+  port: 53
+  upstream_dns: !include upstreams.yaml
+clients: !include clients.yaml
+user_rules: !include rules.yaml
+`)
+	merged, includes, err := resolveIncludes(data, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []configInclude{
+		{path: "dns.upstream_dns", file: "upstreams.yaml"},
+		{path: "clients", file: "clients.yaml"},
+		{path: "user_rules", file: "rules.yaml"},
+	}, includes)
+
+	conf := configuration{}
+	err = yaml.Unmarshal(merged, &conf)
+	assert.Nil(t, err)
+	assert.Equal(t, 53, conf.DNS.Port)
+	assert.Equal(t, []string{"1.1.1.1"}, conf.DNS.UpstreamDNS)
+	assert.Equal(t, 1, len(conf.Clients))
+	assert.Equal(t, "client1", conf.Clients[0].Name)
+	data, err = yaml.Marshal(&conf)
+	assert.Nil(t, err)
+	setIncludeValues(data, includes)
+
+	// the changed values are written back to their files
+	conf.DNS.UpstreamDNS = []string{"8.8.8.8"}
+	data, err = yaml.Marshal(&conf)
+	assert.Nil(t, err)
+	data, err = writeIncludes(data, includes, dir)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), "upstream_dns: !include upstreams.yaml\n"))
+	assert.True(t, strings.Contains(string(data), "clients: !include clients.yaml\n"))
+	assert.False(t, strings.Contains(string(data), "client1"))
+	upstreams, err := ioutil.ReadFile(filepath.Join(dir, "upstreams.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "- 8.8.8.8\n", string(upstreams))
+
+	// the files with the unchanged values aren't written:  the formatting and the comments are kept
+	// (the clients are written with the default values, so their data differs from the file)
+	clients, err := ioutil.ReadFile(filepath.Join(dir, "clients.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, clientsData, string(clients))
+	rules, err := ioutil.ReadFile(filepath.Join(dir, "rules.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "# the rules\n[ '||example.org^' ]\n", string(rules))
+
+	// the value is compared with the file data if the loaded value is unknown
+	includes[2].data = nil
+	data, err = yaml.Marshal(&conf)
+	assert.Nil(t, err)
+	_, err = writeIncludes(data, includes, dir)
+	assert.Nil(t, err)
+	rules, err = ioutil.ReadFile(filepath.Join(dir, "rules.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "# the rules\n[ '||example.org^' ]\n", string(rules))
+
+	// no includes
+	data = []byte("bind_port: 3000\n")
+	merged, includes, err = resolveIncludes(data, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, data, merged)
+	assert.Nil(t, includes)
+
+	// the items of the lists can't be included
+	_, _, err = resolveIncludes([]byte("clients:\n- !include clients.yaml\n"), dir)
+	assert.NotNil(t, err)
+
+	// the included file can't include other files
+	err = ioutil.WriteFile(filepath.Join(dir, "dns.yaml"), []byte("upstream_dns: !include upstreams.yaml\n"), 0600)
+	assert.Nil(t, err)
+	_, _, err = resolveIncludes([]byte("dns: !include dns.yaml\n"), dir)
+	assert.NotNil(t, err)
+
+	// the errors in the included files
+	err = ioutil.WriteFile(filepath.Join(dir, "dns.yaml"), []byte("port: 53\nbind_hosts: [1.2.3]\nunknown_key: 1\n"), 0600)
+	assert.Nil(t, err)
+	data = []byte("bind_host: 0.0.0.0\nbind_port: 3000\ndns: !include dns.yaml\nschema_version: 8\n")
+	errs := checkConfigData(data, dir, &configuration{})
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, configPos{file: "dns.yaml", line: 2}, errs[0].configPos)
+	assert.Equal(t, configPos{file: "dns.yaml", line: 3}, errs[1].configPos)
+	assert.Equal(t, "unknown key: unknown_key", errs[1].text)
+}
+
+func TestReadPasswordFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-include")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	fn := filepath.Join(dir, "password")
+	err = ioutil.WriteFile(fn, []byte("$2y$10$hash\n"), 0600)
+	assert.Nil(t, err)
+
+	users := []User{
+		{Name: "user1", PasswordHash: "$2y$10$user1"},
+		{Name: "user2", PasswordFile: fn},
+	}
+	err = readPasswordFiles(users)
+	assert.Nil(t, err)
+	assert.Equal(t, "$2y$10$user1", users[0].PasswordHash)
+	assert.Equal(t, "$2y$10$hash", users[1].PasswordHash)
+
+	users[1].PasswordFile = filepath.Join(dir, "nonexistent")
+	err = readPasswordFiles(users)
+	assert.NotNil(t, err)
+}
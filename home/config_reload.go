@@ -0,0 +1,154 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/AdguardTeam/golibs/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Reloading the configuration file:
+//  on SIGHUP or POST /control/reload_config the configuration file is read again
+//  and these settings are applied without restarting the application:
+//   dns: the settings of DNS server and of DNS filtering
+//   filters, whitelist_filters, user_rules
+//   clients
+//   dhcp
+//  The other settings (web server, TLS, users, statistics and query log) are applied after restart.
+//  If the file is invalid, the current configuration is kept.
+
+// The settings which are applied when the configuration file is reloaded
+type reloadableConfig struct {
+	DNS dnsConfig `yaml:"dns"`
+
+	Filters          []filter `yaml:"filters"`
+	WhitelistFilters []filter `yaml:"whitelist_filters"`
+	UserRules        []string `yaml:"user_rules"`
+
+	DHCP dhcpd.ServerConfig `yaml:"dhcp"`
+
+	Clients []clientObject `yaml:"clients"`
+}
+
+// Parse and check the configuration file data
+// The settings which are missing in the file keep their current values.
+func parseReloadableConfig(data []byte) (*reloadableConfig, error) {
+	c := &reloadableConfig{
+		DNS:  config.DNS,
+		DHCP: config.DHCP,
+	}
+	c.DHCP.StaticLeases = nil
+	err := yaml.Unmarshal(data, c)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getDNSBindIPs(c.DNS.BindHosts)
+	if err != nil {
+		return nil, fmt.Errorf("dns: bind_hosts: %s", err)
+	}
+	if c.DNS.Port < 0 || c.DNS.Port > 0xffff {
+		return nil, fmt.Errorf("dns: invalid port: %d", c.DNS.Port)
+	}
+	if len(c.DNS.UpstreamDNS) != 0 {
+		err = dnsforward.ValidateUpstreams(c.DNS.UpstreamDNS)
+		if err != nil {
+			return nil, fmt.Errorf("dns: upstream_dns: %s", err)
+		}
+	}
+
+	if !checkFiltersUpdateIntervalHours(c.DNS.FiltersUpdateIntervalHours) {
+		c.DNS.FiltersUpdateIntervalHours = 24
+	}
+	for _, filters := range [][]filter{c.Filters, c.WhitelistFilters} {
+		for i := range filters {
+			f := &filters[i]
+			if !checkFiltersUpdateIntervalHours(f.UpdateInterval) {
+				log.Error("filter %s: unsupported update interval: %d", f.URL, f.UpdateInterval)
+				f.UpdateInterval = 0
+			}
+		}
+	}
+
+	err = dnsCryptInitKeys(&c.DNS.DNSCrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Read the configuration file again and apply the changes
+func reloadConfigFile() error {
+	if Context.firstRun || Context.dnsServer == nil {
+		return fmt.Errorf("the application isn't configured yet")
+	}
+
+	configFile := config.getConfigFilename()
+	log.Info("Reloading the configuration file %s", configFile)
+	data, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	c, err := parseReloadableConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", configFile, err)
+	}
+	if c.DHCP.Enabled {
+		err = Context.dhcpServer.CheckConfig(c.DHCP)
+		if err != nil {
+			return fmt.Errorf("%s: dhcp: %s", configFile, err)
+		}
+	}
+
+	updateUniqueFilterID(c.Filters)
+	updateUniqueFilterID(c.WhitelistFilters)
+	Context.filters.loadFilters(c.Filters)
+	Context.filters.loadFilters(c.WhitelistFilters)
+
+	config.Lock()
+	config.DNS = c.DNS
+	config.Filters = c.Filters
+	config.WhitelistFilters = c.WhitelistFilters
+	config.UserRules = c.UserRules
+	deduplicateFilters()
+	config.Unlock()
+
+	Context.dnsFilter.SetConfig(c.DNS.DnsfilterConf)
+	Context.clients.SetConfig(c.Clients)
+	enableFilters(true)
+
+	if isRunning() {
+		err = reconfigureDNSServer()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = Context.dhcpServer.Reconfigure(c.DHCP)
+	if err != nil {
+		return fmt.Errorf("DHCP: %s", err)
+	}
+
+	// download the filters which have been added
+	go func() {
+		_, _ = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshAllowlists, "", false)
+	}()
+
+	log.Info("The configuration file has been reloaded")
+	return nil
+}
+
+// Reload the configuration file
+func handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	err := reloadConfigFile()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Couldn't reload the configuration file: %s", err)
+		return
+	}
+
+	returnOK(w)
+}
@@ -0,0 +1,62 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReloadableConfig(t *testing.T) {
+	data := []byte(`dns:
+  bind_hosts:
+  - 127.0.0.1
+  - ::1
+  port: 5353
+  upstream_dns:
+  - 1.1.1.1
+  - '[/lan/]192.168.1.1'
+  filters_update_interval: 5
+  rewrites:
+  - domain: example.org
+    answer: 1.2.3.4
+filters:
+- enabled: true
+  url: https://example.org/filter.txt
+  name: Filter
+  id: 10
+  update_interval: 7
+user_rules:
+- '||example.com^'
+clients:
+- name: client1
+  ids:
+  - 1.2.3.4
+`)
+	c, err := parseReloadableConfig(data)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1", "::1"}, c.DNS.BindHosts)
+	assert.Equal(t, 5353, c.DNS.Port)
+	assert.Equal(t, []string{"1.1.1.1", "[/lan/]192.168.1.1"}, c.DNS.UpstreamDNS)
+	assert.Equal(t, uint32(24), c.DNS.FiltersUpdateIntervalHours)
+	assert.Equal(t, 1, len(c.DNS.DnsfilterConf.Rewrites))
+	assert.Equal(t, 1, len(c.Filters))
+	assert.Equal(t, int64(10), c.Filters[0].ID)
+	assert.Equal(t, uint32(0), c.Filters[0].UpdateInterval)
+	assert.Equal(t, []string{"||example.com^"}, c.UserRules)
+	assert.Equal(t, "client1", c.Clients[0].Name)
+	// the settings which are missing in the file keep their current values
+	assert.Equal(t, config.DNS.BlockingMode, c.DNS.BlockingMode)
+
+	_, err = parseReloadableConfig([]byte("dns:\n  bind_hosts:\n  - 1.2.3\n"))
+	assert.NotNil(t, err)
+
+	_, err = parseReloadableConfig([]byte("dns:\n  bind_hosts:\n  - 0.0.0.0\n  upstream_dns:\n  - ftp://1.1.1.1\n"))
+	assert.NotNil(t, err)
+
+	// the unspecified address along with the other addresses
+	_, err = parseReloadableConfig([]byte("dns:\n  bind_hosts:\n  - 0.0.0.0\n  - 127.0.0.1\n"))
+	assert.NotNil(t, err)
+
+	_, err = parseReloadableConfig([]byte("dns: ["))
+	assert.NotNil(t, err)
+}
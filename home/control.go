@@ -8,8 +8,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/AdguardTeam/AdGuardHome/dnsforward"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/NYTimes/gziphandler"
 )
@@ -42,9 +42,14 @@ func addDNSAddress(dnsAddresses *[]string, addr string) {
 }
 
 func handleStatus(w http.ResponseWriter, r *http.Request) {
-	c := dnsforward.FilteringConfig{}
+	protectionEnabled := false
+	var disabledUntil time.Time
 	if Context.dnsServer != nil {
-		Context.dnsServer.WriteDiskConfig(&c)
+		protectionEnabled, disabledUntil = Context.dnsServer.ProtectionStatus()
+	}
+	var disabledDuration int64 // milliseconds
+	if !disabledUntil.IsZero() {
+		disabledDuration = int64(time.Until(disabledUntil) / time.Millisecond)
 	}
 	data := map[string]interface{}{
 		"dns_addresses": getDNSAddresses(),
@@ -54,7 +59,8 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		"version":       versionString,
 		"language":      config.Language,
 
-		"protection_enabled": c.ProtectionEnabled,
+		"protection_enabled":           protectionEnabled,
+		"protection_disabled_duration": disabledDuration,
 	}
 
 	jsonVal, err := json.Marshal(data)
@@ -71,13 +77,19 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 type profileJSON struct {
-	Name string `json:"name"`
+	Name        string `json:"name"`
+	Role        string `json:"role,omitempty"` // not set if authentication is disabled
+	TOTPEnabled bool   `json:"totp_enabled"`   // two-factor authentication is enabled
 }
 
 func handleGetProfile(w http.ResponseWriter, r *http.Request) {
 	pj := profileJSON{}
 	u := Context.auth.GetCurrentUser(r)
 	pj.Name = u.Name
+	if len(u.Name) != 0 {
+		pj.Role = u.role()
+	}
+	pj.TOTPEnabled = len(u.TOTPSecret) != 0
 
 	data, err := json.Marshal(pj)
 	if err != nil {
@@ -96,8 +108,17 @@ func registerControlHandlers() {
 	httpRegister(http.MethodGet, "/control/i18n/current_language", handleI18nCurrentLanguage)
 	http.HandleFunc("/control/version.json", postInstall(optionalAuth(handleGetVersionJSON)))
 	httpRegister(http.MethodPost, "/control/update", handleUpdate)
+	httpRegister(http.MethodPost, "/control/reload_config", handleReloadConfig)
+	// the backup and the diff of the configuration contain the secrets (password hashes, API tokens, private keys):
+	//  they're available to admins only
+	httpRegisterAnyUser(http.MethodGet, "/control/backup", ensureCanModify(handleBackup))
+	httpRegisterAnyUser(http.MethodGet, "/control/config_history/diff", ensureCanModify(handleConfigHistoryDiff))
+	httpRegister(http.MethodPost, "/control/restore", handleRestore)
+	httpRegister(http.MethodGet, "/control/config_history", handleConfigHistory)
+	httpRegister(http.MethodPost, "/control/config_history/restore", handleConfigHistoryRestore)
 
 	httpRegister("GET", "/control/profile", handleGetProfile)
+	httpRegister(http.MethodGet, "/metrics", handleMetrics)
 	RegisterAuthHandlers()
 }
 
@@ -108,9 +129,29 @@ func httpRegister(method string, url string, handler func(http.ResponseWriter, *
 		return
 	}
 
+	if method != http.MethodGet {
+		handler = ensureCanModify(handler)
+	}
+	httpRegisterAnyUser(method, url, handler)
+}
+
+// httpRegisterAnyUser registers the handler which is allowed for the users of any role
+//  (e.g. the settings of the current user's account)
+func httpRegisterAnyUser(method string, url string, handler func(http.ResponseWriter, *http.Request)) {
 	http.Handle(url, postInstallHandler(optionalAuthHandler(gziphandler.GzipHandler(ensureHandler(method, handler)))))
 }
 
+// ensureCanModify lets the handler run only if the current user is allowed to change the settings
+func ensureCanModify(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if Context.auth != nil && !Context.auth.CanModify(r) {
+			httpError(w, http.StatusForbidden, "User %s has read-only access", Context.auth.GetCurrentUser(r).Name)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 // ----------------------------------
 // helper functions for HTTP handlers
 // ----------------------------------
@@ -181,6 +222,26 @@ func preInstallHandler(handler http.Handler) http.Handler {
 	return &preInstallHandlerStruct{handler}
 }
 
+// Get the address of HTTPS server for the request received over HTTP
+func httpsRedirectURL(r *http.Request, portHTTPS int) string {
+	// we want host from host:port
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// no port in host
+		host = r.Host
+	}
+	if portHTTPS != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(portHTTPS))
+	}
+	newURL := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     webPath(r.URL.Path),
+		RawQuery: r.URL.RawQuery,
+	}
+	return newURL.String()
+}
+
 // postInstall lets the handler run only if firstRun is false, and redirects to /install.html otherwise
 // it also enforces HTTPS if it is enabled and configured
 func postInstall(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
@@ -189,29 +250,20 @@ func postInstall(handler func(http.ResponseWriter, *http.Request)) func(http.Res
 		if Context.firstRun &&
 			!strings.HasPrefix(r.URL.Path, "/install.") &&
 			r.URL.Path != "/favicon.png" {
-			http.Redirect(w, r, "/install.html", http.StatusFound)
+			http.Redirect(w, r, webPath("/install.html"), http.StatusFound)
 			return
 		}
 
 		// enforce https?
-		if r.TLS == nil && Context.web.forceHTTPS && Context.web.httpsServer.server != nil {
-			// yes, and we want host from host:port
-			host, _, err := net.SplitHostPort(r.Host)
-			if err != nil {
-				// no port in host
-				host = r.Host
-			}
-			// construct new URL to redirect to
-			newURL := url.URL{
-				Scheme:   "https",
-				Host:     net.JoinHostPort(host, strconv.Itoa(Context.web.portHTTPS)),
-				Path:     r.URL.Path,
-				RawQuery: r.URL.RawQuery,
-			}
-			http.Redirect(w, r, newURL.String(), http.StatusTemporaryRedirect)
+		if !isHTTPSRequest(r) && Context.web.forceHTTPS && Context.web.httpsServer.server != nil {
+			http.Redirect(w, r, httpsRedirectURL(r, Context.web.portHTTPS), http.StatusMovedPermanently)
 			return
 		}
 
+		if isHTTPSRequest(r) && Context.web.hsts {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		}
+
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		handler(w, r)
 	}
@@ -8,20 +8,20 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
 )
 
 // IsValidURL - return TRUE if URL or file path is valid
 func IsValidURL(rawurl string) bool {
-	if filepath.IsAbs(rawurl) {
-		// this is a file path
-		return util.FileExists(rawurl)
+	if fn := filterLocalPath(rawurl); len(fn) != 0 {
+		// this is a file path or "file://" URL
+		return util.FileExists(fn)
 	}
 
 	url, err := url.ParseRequestURI(rawurl)
@@ -31,13 +31,17 @@ func IsValidURL(rawurl string) bool {
 	if len(url.Scheme) == 0 {
 		return false //No Scheme found
 	}
+	if url.Scheme == "file" {
+		return false // "file://" URL with a relative path or a host name
+	}
 	return true
 }
 
 type filterAddJSON struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	Whitelist bool   `json:"whitelist"`
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Whitelist      bool   `json:"whitelist"`
+	UpdateInterval uint32 `json:"update_interval"` // in hours;  0: use the global setting
 }
 
 func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
@@ -53,6 +57,11 @@ func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !checkFiltersUpdateIntervalHours(fj.UpdateInterval) {
+		httpError(w, http.StatusBadRequest, "Unsupported update interval")
+		return
+	}
+
 	// Check for duplicates
 	if filterExists(fj.URL) {
 		httpError(w, http.StatusBadRequest, "Filter URL already added -- %s", fj.URL)
@@ -61,10 +70,11 @@ func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 
 	// Set necessary properties
 	filt := filter{
-		Enabled: true,
-		URL:     fj.URL,
-		Name:    fj.Name,
-		white:   fj.Whitelist,
+		Enabled:        true,
+		URL:            fj.URL,
+		Name:           fj.Name,
+		white:          fj.Whitelist,
+		UpdateInterval: fj.UpdateInterval,
 	}
 	filt.ID = assignUniqueFilterID()
 
@@ -136,9 +146,10 @@ func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 }
 
 type filterURLJSON struct {
-	Name    string `json:"name"`
-	URL     string `json:"url"`
-	Enabled bool   `json:"enabled"`
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Enabled        bool   `json:"enabled"`
+	UpdateInterval uint32 `json:"update_interval"` // in hours;  0: use the global setting
 }
 
 type filterURLReq struct {
@@ -160,10 +171,16 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !checkFiltersUpdateIntervalHours(fj.Data.UpdateInterval) {
+		httpError(w, http.StatusBadRequest, "Unsupported update interval")
+		return
+	}
+
 	filt := filter{
-		Enabled: fj.Data.Enabled,
-		Name:    fj.Data.Name,
-		URL:     fj.Data.URL,
+		Enabled:        fj.Data.Enabled,
+		Name:           fj.Data.Name,
+		URL:            fj.Data.URL,
+		UpdateInterval: fj.Data.UpdateInterval,
 	}
 	status := f.filterSetProperties(fj.URL, filt, fj.Whitelist)
 	if (status & statusFound) == 0 {
@@ -187,7 +204,7 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		if fj.Whitelist {
 			flags = FilterRefreshAllowlists
 		}
-		nUpdated, _ := f.refreshFilters(flags, true)
+		nUpdated, _ := f.refreshFilters(flags, fj.Data.URL, true)
 		// if at least 1 filter has been updated, refreshFilters() restarts the filtering automatically
 		// if not - we restart the filtering ourselves
 		restart = false
@@ -212,35 +229,82 @@ func (f *Filtering) handleFilteringSetRules(w http.ResponseWriter, r *http.Reque
 	enableFilters(true)
 }
 
+type filterRefreshReq struct {
+	White bool   `json:"whitelist"`
+	URL   string `json:"url"` // refresh only this filter (optional)
+}
+
+// A filter which data has changed after refresh
+type filterRefreshJSON struct {
+	ID            int64  `json:"id"`
+	URL           string `json:"url"`
+	Name          string `json:"name"`
+	RulesCountOld int    `json:"rules_count_old"`
+	RulesCount    int    `json:"rules_count"`
+}
+
+type filterRefreshResp struct {
+	Updated int                 `json:"updated"` // number of filters updated
+	Filters []filterRefreshJSON `json:"filters"` // filters updated
+}
+
 func (f *Filtering) handleFilteringRefresh(w http.ResponseWriter, r *http.Request) {
-	type Req struct {
-		White bool `json:"whitelist"`
-	}
-	type Resp struct {
-		Updated int `json:"updated"`
-	}
-	resp := Resp{}
+	resp := filterRefreshResp{}
 	var err error
 
-	req := Req{}
+	req := filterRefreshReq{}
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, "json decode: %s", err)
 		return
 	}
 
-	Context.controlLock.Unlock()
+	filters := &config.Filters
 	flags := FilterRefreshBlocklists
 	if req.White {
+		filters = &config.WhitelistFilters
 		flags = FilterRefreshAllowlists
 	}
-	resp.Updated, err = f.refreshFilters(flags|FilterRefreshForce, false)
+
+	// remember the state of filters to find out which of them have changed
+	config.RLock()
+	prev := map[int64]filter{}
+	for _, filt := range *filters {
+		if filt.Enabled && (len(req.URL) == 0 || filt.URL == req.URL) {
+			prev[filt.ID] = filt
+		}
+	}
+	config.RUnlock()
+	if len(req.URL) != 0 && len(prev) == 0 {
+		httpError(w, http.StatusBadRequest, "enabled filter with URL %s not found", req.URL)
+		return
+	}
+
+	Context.controlLock.Unlock()
+	resp.Updated, err = f.refreshFilters(flags|FilterRefreshForce, req.URL, false)
 	Context.controlLock.Lock()
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "%s", err)
 		return
 	}
 
+	resp.Filters = []filterRefreshJSON{}
+	config.RLock()
+	for _, filt := range *filters {
+		p, ok := prev[filt.ID]
+		if !ok || p.URL != filt.URL || p.checksum == filt.checksum {
+			continue
+		}
+		resp.Filters = append(resp.Filters, filterRefreshJSON{
+			ID:            filt.ID,
+			URL:           filt.URL,
+			Name:          filt.Name,
+			RulesCountOld: p.RulesCount,
+			RulesCount:    filt.RulesCount,
+		})
+	}
+	config.RUnlock()
+
 	js, err := json.Marshal(resp)
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
@@ -251,12 +315,18 @@ func (f *Filtering) handleFilteringRefresh(w http.ResponseWriter, r *http.Reques
 }
 
 type filterJSON struct {
-	ID          int64  `json:"id"`
-	Enabled     bool   `json:"enabled"`
-	URL         string `json:"url"`
-	Name        string `json:"name"`
-	RulesCount  uint32 `json:"rules_count"`
-	LastUpdated string `json:"last_updated"`
+	ID             int64  `json:"id"`
+	Enabled        bool   `json:"enabled"`
+	URL            string `json:"url"`
+	Name           string `json:"name"`
+	RulesCount     uint32 `json:"rules_count"`
+	LastUpdated    string `json:"last_updated"`
+	UpdateInterval uint32 `json:"update_interval"` // in hours;  0: use the global setting
+
+	// The result of the last download attempt
+	LastChecked string `json:"last_checked,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	HTTPStatus  int    `json:"http_status,omitempty"`
 }
 
 type filteringConfig struct {
@@ -269,16 +339,22 @@ type filteringConfig struct {
 
 func filterToJSON(f filter) filterJSON {
 	fj := filterJSON{
-		ID:         f.ID,
-		Enabled:    f.Enabled,
-		URL:        f.URL,
-		Name:       f.Name,
-		RulesCount: uint32(f.RulesCount),
+		ID:             f.ID,
+		Enabled:        f.Enabled,
+		URL:            f.URL,
+		Name:           f.Name,
+		RulesCount:     uint32(f.RulesCount),
+		UpdateInterval: f.UpdateInterval,
 	}
 
 	if !f.LastUpdated.IsZero() {
 		fj.LastUpdated = f.LastUpdated.Format(time.RFC3339)
 	}
+	if !f.LastChecked.IsZero() {
+		fj.LastChecked = f.LastChecked.Format(time.RFC3339)
+		fj.LastError = f.LastError
+		fj.HTTPStatus = f.HTTPStatus
+	}
 
 	return fj
 }
@@ -340,7 +416,7 @@ type checkHostResp struct {
 	// for FilteredBlockedService:
 	SvcName string `json:"service_name"`
 
-	// for ReasonRewrite:
+	// for ReasonRewrite & RewriteRule:
 	CanonName string   `json:"cname"`    // CNAME value
 	IPList    []net.IP `json:"ip_addrs"` // list of IP addresses
 }
@@ -374,6 +450,163 @@ func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(js)
 }
 
+// Portable filtering configuration which can be exported from one instance and imported on another one
+type filtersExportJSON struct {
+	Filters          []filterExportJSON `json:"filters" yaml:"filters"`
+	WhitelistFilters []filterExportJSON `json:"whitelist_filters" yaml:"whitelist_filters"`
+	UserRules        []string           `json:"user_rules" yaml:"user_rules"`
+}
+
+type filterExportJSON struct {
+	Name           string `json:"name" yaml:"name"`
+	URL            string `json:"url" yaml:"url"`
+	Enabled        bool   `json:"enabled" yaml:"enabled"`
+	UpdateInterval uint32 `json:"update_interval" yaml:"update_interval"`
+}
+
+func filtersToExportJSON(filters []filter) []filterExportJSON {
+	r := []filterExportJSON{}
+	for _, f := range filters {
+		r = append(r, filterExportJSON{
+			Name:           f.Name,
+			URL:            f.URL,
+			Enabled:        f.Enabled,
+			UpdateInterval: f.UpdateInterval,
+		})
+	}
+	return r
+}
+
+// Export filtering configuration
+// ?format=yaml: YAML document;  JSON document by default
+func (f *Filtering) handleFilteringExport(w http.ResponseWriter, r *http.Request) {
+	doc := filtersExportJSON{}
+	config.RLock()
+	doc.Filters = filtersToExportJSON(config.Filters)
+	doc.WhitelistFilters = filtersToExportJSON(config.WhitelistFilters)
+	doc.UserRules = append([]string{}, config.UserRules...)
+	config.RUnlock()
+
+	var data []byte
+	var err error
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "yaml":
+		data, err = yaml.Marshal(doc)
+		w.Header().Set("Content-Type", "application/x-yaml")
+	case "", "json":
+		format = "json"
+		data, err = json.MarshalIndent(doc, "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		httpError(w, http.StatusBadRequest, "unsupported format: %s", format)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "encode: %s", err)
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=filters."+format)
+	_, _ = w.Write(data)
+}
+
+// Check the filters from the imported document
+func checkImportedFilters(filters []filterExportJSON, urls map[string]bool) error {
+	for _, f := range filters {
+		if !IsValidURL(f.URL) {
+			return fmt.Errorf("invalid URL or file path: %s", f.URL)
+		}
+		if urls[f.URL] {
+			return fmt.Errorf("duplicate URL: %s", f.URL)
+		}
+		urls[f.URL] = true
+		if !checkFiltersUpdateIntervalHours(f.UpdateInterval) {
+			return fmt.Errorf("unsupported update interval: %s: %d", f.URL, f.UpdateInterval)
+		}
+	}
+	return nil
+}
+
+// Replace the filters with the imported ones
+// The filters with the same URL keep their IDs and data
+// The files of the removed filters are renamed to "<ID>.txt.old"
+func importFilters(filters []filter, imported []filterExportJSON, white bool) []filter {
+	r := []filter{}
+	used := map[string]bool{}
+	for _, fj := range imported {
+		used[fj.URL] = true
+		filt := filter{
+			URL:   fj.URL,
+			white: white,
+		}
+		for _, old := range filters {
+			if old.URL == fj.URL {
+				filt = old
+				break
+			}
+		}
+		if filt.ID == 0 {
+			filt.ID = assignUniqueFilterID()
+		}
+		filt.Name = fj.Name
+		filt.Enabled = fj.Enabled
+		filt.UpdateInterval = fj.UpdateInterval
+		r = append(r, filt)
+	}
+
+	for _, old := range filters {
+		if used[old.URL] {
+			continue
+		}
+		err := os.Rename(old.Path(), old.Path()+".old")
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("os.Rename: %s: %s", old.Path(), err)
+		}
+	}
+	return r
+}
+
+// Import filtering configuration (JSON or YAML document)
+// All filters and user rules are replaced, then all enabled filters are downloaded
+func (f *Filtering) handleFilteringImport(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Failed to read request body: %s", err)
+		return
+	}
+
+	// YAML parser accepts JSON documents too
+	doc := filtersExportJSON{}
+	err = yaml.Unmarshal(body, &doc)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "decode: %s", err)
+		return
+	}
+
+	urls := map[string]bool{}
+	err = checkImportedFilters(doc.Filters, urls)
+	if err == nil {
+		err = checkImportedFilters(doc.WhitelistFilters, urls)
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	config.Lock()
+	config.Filters = importFilters(config.Filters, doc.Filters, false)
+	config.WhitelistFilters = importFilters(config.WhitelistFilters, doc.WhitelistFilters, true)
+	config.UserRules = doc.UserRules
+	config.Unlock()
+
+	onConfigModified()
+	enableFilters(true)
+
+	Context.controlLock.Unlock()
+	_, _ = f.refreshFilters(FilterRefreshBlocklists|FilterRefreshAllowlists|FilterRefreshForce, "", true)
+	Context.controlLock.Lock()
+}
+
 // RegisterFilteringHandlers - register handlers
 func (f *Filtering) RegisterFilteringHandlers() {
 	httpRegister("GET", "/control/filtering/status", f.handleFilteringStatus)
@@ -384,6 +617,8 @@ func (f *Filtering) RegisterFilteringHandlers() {
 	httpRegister("POST", "/control/filtering/refresh", f.handleFilteringRefresh)
 	httpRegister("POST", "/control/filtering/set_rules", f.handleFilteringSetRules)
 	httpRegister("GET", "/control/filtering/check_host", f.handleCheckHost)
+	httpRegister("GET", "/control/filtering/export", f.handleFilteringExport)
+	httpRegister("POST", "/control/filtering/import", f.handleFilteringImport)
 }
 
 func checkFiltersUpdateIntervalHours(i uint32) bool {
@@ -16,6 +16,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/util"
 
 	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
 
 	"github.com/AdguardTeam/golibs/log"
 )
@@ -270,7 +271,7 @@ type applyConfigReq struct {
 func copyInstallSettings(dst *configuration, src *configuration) {
 	dst.BindHost = src.BindHost
 	dst.BindPort = src.BindPort
-	dst.DNS.BindHost = src.DNS.BindHost
+	dst.DNS.BindHosts = src.DNS.BindHosts
 	dst.DNS.Port = src.DNS.Port
 }
 
@@ -322,7 +323,7 @@ func (web *Web) handleInstallConfigure(w http.ResponseWriter, r *http.Request) {
 	Context.firstRun = false
 	config.BindHost = newSettings.Web.IP
 	config.BindPort = newSettings.Web.Port
-	config.DNS.BindHost = newSettings.DNS.IP
+	config.DNS.BindHosts = []string{newSettings.DNS.IP}
 	config.DNS.Port = newSettings.DNS.Port
 
 	err = StartMods()
@@ -369,4 +370,5 @@ func (web *Web) registerInstallHandlers() {
 	http.HandleFunc("/control/install/get_addresses", preInstall(ensureGET(web.handleInstallGetAddresses)))
 	http.HandleFunc("/control/install/check_config", preInstall(ensurePOST(web.handleInstallCheckConfig)))
 	http.HandleFunc("/control/install/configure", preInstall(ensurePOST(web.handleInstallConfigure)))
+	http.HandleFunc("/control/install/test_upstream_dns", preInstall(ensurePOST(dnsforward.HandleTestUpstreamDNS)))
 }
@@ -1,8 +1,13 @@
 package home
 
 import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 /* Tests performed:
@@ -75,3 +80,62 @@ kXS9jgARhhiWXJrk
 		t.Fatalf("valid cert & priv key: validateCertificates(): %v", data)
 	}
 }
+
+func TestHTTPSRedirect(t *testing.T) {
+	Context.web = &Web{
+		forceHTTPS: true,
+		hsts:       true,
+		portHTTPS:  8443,
+		httpsServer: HTTPSServer{
+			server: &http.Server{},
+		},
+	}
+	defer func() { Context.web = nil }()
+
+	handlerCalled := false
+	handler := postInstall(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	})
+
+	// HTTP: redirect
+	w := testResponseWriter{hdr: make(http.Header)}
+	r := http.Request{Method: http.MethodGet, Host: "example.org:3000", URL: &url.URL{Path: "/control/status", RawQuery: "a=1"}}
+	handler(&w, &r)
+	assert.False(t, handlerCalled)
+	assert.Equal(t, http.StatusMovedPermanently, w.statusCode)
+	assert.Equal(t, "https://example.org:8443/control/status?a=1", w.hdr.Get("Location"))
+	assert.Equal(t, "", w.hdr.Get("Strict-Transport-Security"))
+
+	// the default port isn't added
+	assert.Equal(t, "https://example.org/", httpsRedirectURL(&http.Request{Host: "example.org", URL: &url.URL{Path: "/"}}, 443))
+
+	// HTTPS: HSTS header
+	w = testResponseWriter{hdr: make(http.Header)}
+	r.TLS = &tls.ConnectionState{}
+	handler(&w, &r)
+	assert.True(t, handlerCalled)
+	assert.Equal(t, "max-age=31536000", w.hdr.Get("Strict-Transport-Security"))
+
+	// HTTPS is terminated by a trusted proxy:  no redirect, HSTS header
+	proxies, _ := parseTrustedProxies([]string{"127.0.0.1"})
+	handlerCalled = false
+	w = testResponseWriter{hdr: make(http.Header)}
+	r = http.Request{Method: http.MethodGet, Host: "example.org", URL: &url.URL{Path: "/control/status"},
+		RemoteAddr: "127.0.0.1:1234", Header: make(http.Header)}
+	r.Header.Set("X-Forwarded-Proto", "https")
+	setProxyRemoteAddr(proxies, &r)
+	handler(&w, &r)
+	assert.True(t, handlerCalled)
+	assert.Equal(t, "max-age=31536000", w.hdr.Get("Strict-Transport-Security"))
+
+	// X-Forwarded-Proto from an untrusted address is ignored:  redirect
+	handlerCalled = false
+	w = testResponseWriter{hdr: make(http.Header)}
+	r.RemoteAddr = "1.2.3.4:1234"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	setProxyRemoteAddr(proxies, &r)
+	handler(&w, &r)
+	assert.False(t, handlerCalled)
+	assert.Equal(t, http.StatusMovedPermanently, w.statusCode)
+	assert.Equal(t, "", w.hdr.Get("Strict-Transport-Security"))
+}
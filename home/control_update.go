@@ -15,7 +15,6 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/util"
 
@@ -44,14 +43,11 @@ func getVersionResp(data []byte) []byte {
 		return []byte{}
 	}
 
-	// the key is download_linux_arm or download_linux_arm64 for regular ARM versions
-	dloadName := fmt.Sprintf("download_%s_%s", runtime.GOOS, runtime.GOARCH)
-	if runtime.GOARCH == "arm" && ARMVersion == "5" {
-		// the key is download_linux_armv5 for ARMv5
-		dloadName = fmt.Sprintf("download_%s_%sv%s", runtime.GOOS, runtime.GOARCH, ARMVersion)
-	}
-	_, ok := versionJSON[dloadName]
-	if ok && ret["new_version"] != versionString && versionString >= selfUpdateMinVersion {
+	_, ok := versionJSON["download_"+updatePackageKey()]
+	checksum, _ := versionJSON["checksum_"+updatePackageKey()].(string)
+	signature, _ := versionJSON["signature_"+updatePackageKey()].(string)
+	if ok && len(checksum) != 0 && len(signature) != 0 && len(updatePublicKey) != 0 &&
+		ret["new_version"] != versionString && versionString >= selfUpdateMinVersion {
 		canUpdate := true
 
 		tlsConf := tlsConfigSettings{}
@@ -93,52 +89,12 @@ func handleGetVersionJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	now := time.Now()
-	if !req.RecheckNow {
-		Context.controlLock.Lock()
-		cached := now.Sub(config.versionCheckLastTime) <= versionCheckPeriod && len(config.versionCheckJSON) != 0
-		data := config.versionCheckJSON
-		Context.controlLock.Unlock()
-
-		if cached {
-			log.Tracef("Returning cached data")
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(getVersionResp(data))
-			return
-		}
-	}
-
-	var resp *http.Response
-	for i := 0; i != 3; i++ {
-		log.Tracef("Downloading data from %s", versionCheckURL)
-		resp, err = Context.client.Get(versionCheckURL)
-		if resp != nil && resp.Body != nil {
-			defer resp.Body.Close()
-		}
-		if err != nil && strings.HasSuffix(err.Error(), "i/o timeout") {
-			// This case may happen while we're restarting DNS server
-			// https://github.com/AdguardTeam/AdGuardHome/issues/934
-			continue
-		}
-		break
-	}
+	body, err := getVersionJSON(req.RecheckNow)
 	if err != nil {
-		httpError(w, http.StatusBadGateway, "Couldn't get version check json from %s: %T %s\n", versionCheckURL, err, err)
+		httpError(w, http.StatusBadGateway, "%s", err)
 		return
 	}
 
-	// read the body entirely
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		httpError(w, http.StatusBadGateway, "Couldn't read response body from %s: %s", versionCheckURL, err)
-		return
-	}
-
-	Context.controlLock.Lock()
-	config.versionCheckLastTime = now
-	config.versionCheckJSON = body
-	Context.controlLock.Unlock()
-
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(getVersionResp(body))
 	if err != nil {
@@ -161,6 +117,8 @@ func copyFile(src, dst string) error {
 
 type updateInfo struct {
 	pkgURL           string // URL for the new package
+	pkgChecksum      string // SHA-256 checksum of the new package (hex)
+	pkgSignature     string // Ed25519 signature of the new package (base64)
 	pkgName          string // Full path to package file
 	newVer           string // New version string
 	updateDir        string // Full path to the directory containing unpacked files from the new package
@@ -184,11 +142,19 @@ func getUpdateInfo(jsonData []byte) (*updateInfo, error) {
 		return nil, fmt.Errorf("JSON parse: %s", err)
 	}
 
-	u.pkgURL = versionJSON[fmt.Sprintf("download_%s_%s", runtime.GOOS, runtime.GOARCH)].(string)
-	u.newVer = versionJSON["version"].(string)
+	u.pkgURL, _ = versionJSON["download_"+updatePackageKey()].(string)
+	u.pkgChecksum, _ = versionJSON["checksum_"+updatePackageKey()].(string)
+	u.pkgSignature, _ = versionJSON["signature_"+updatePackageKey()].(string)
+	u.newVer, _ = versionJSON["version"].(string)
 	if len(u.pkgURL) == 0 || len(u.newVer) == 0 {
 		return nil, fmt.Errorf("invalid JSON")
 	}
+	if len(u.pkgChecksum) == 0 {
+		return nil, fmt.Errorf("the checksum of the package isn't available")
+	}
+	if len(u.pkgSignature) == 0 {
+		return nil, fmt.Errorf("the signature of the package isn't available")
+	}
 
 	if u.newVer == versionString {
 		return nil, fmt.Errorf("no need to update")
@@ -390,6 +356,9 @@ func getPackageFile(u *updateInfo) error {
 	if resp != nil && resp.Body != nil {
 		defer resp.Body.Close()
 	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP request failed: status code %d", resp.StatusCode)
+	}
 
 	log.Tracef("Reading HTTP body")
 	body, err := ioutil.ReadAll(resp.Body)
@@ -397,6 +366,18 @@ func getPackageFile(u *updateInfo) error {
 		return fmt.Errorf("ioutil.ReadAll() failed: %s", err)
 	}
 
+	log.Tracef("Verifying the checksum")
+	err = verifyChecksum(body, u.pkgChecksum)
+	if err != nil {
+		return err
+	}
+
+	log.Tracef("Verifying the signature")
+	err = verifySignature(body, u.pkgSignature)
+	if err != nil {
+		return err
+	}
+
 	log.Tracef("Saving package to file")
 	err = ioutil.WriteFile(u.pkgName, body, 0644)
 	if err != nil {
@@ -490,6 +471,11 @@ func doUpdate(u *updateInfo) error {
 
 // Complete an update procedure
 func finishUpdate(u *updateInfo) {
+	restartApp(u.curBinName)
+}
+
+// Stop all tasks and start the application again
+func restartApp(binName string) {
 	log.Info("Stopping all tasks")
 	cleanup()
 	cleanupAlways()
@@ -507,7 +493,7 @@ func finishUpdate(u *updateInfo) {
 			os.Exit(0)
 		}
 
-		cmd := exec.Command(u.curBinName, os.Args[1:]...)
+		cmd := exec.Command(binName, os.Args[1:]...)
 		log.Info("Restarting: %v", cmd.Args)
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
@@ -519,7 +505,7 @@ func finishUpdate(u *updateInfo) {
 		os.Exit(0)
 	} else {
 		log.Info("Restarting: %v", os.Args)
-		err := syscall.Exec(u.curBinName, os.Args, os.Environ())
+		err := syscall.Exec(binName, os.Args, os.Environ())
 		if err != nil {
 			log.Fatalf("syscall.Exec() failed: %s", err)
 		}
@@ -530,12 +516,18 @@ func finishUpdate(u *updateInfo) {
 // Perform an update procedure to the latest available version
 func handleUpdate(w http.ResponseWriter, r *http.Request) {
 
-	if len(config.versionCheckJSON) == 0 {
+	if Context.disableUpdate {
 		httpError(w, http.StatusBadRequest, "/update request isn't allowed now")
 		return
 	}
 
-	u, err := getUpdateInfo(config.versionCheckJSON)
+	data, err := getVersionJSON(false)
+	if err != nil {
+		httpError(w, http.StatusBadGateway, "%s", err)
+		return
+	}
+
+	u, err := getUpdateInfo(data)
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "%s", err)
 		return
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/AdguardTeam/AdGuardHome/dnsforward"
@@ -13,6 +15,7 @@ import (
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/ameshkov/dnscrypt/v2"
 	"github.com/joomcode/errorx"
 )
 
@@ -51,18 +54,25 @@ func initDNSServer() error {
 	Context.queryLog = querylog.New(conf)
 
 	filterConf := config.DNS.DnsfilterConf
-	bindhost := config.DNS.BindHost
-	if config.DNS.BindHost == "0.0.0.0" {
-		bindhost = "127.0.0.1"
+	bindhost := "127.0.0.1"
+	bindIPs, err := getDNSBindIPs(config.DNS.BindHosts)
+	if err == nil && !bindIPs[0].IsUnspecified() {
+		bindhost = bindIPs[0].String()
+	}
+	filterConf.ResolverAddress = net.JoinHostPort(bindhost, strconv.Itoa(config.DNS.Port))
+	if config.DNS.HostsFileEnabled {
+		filterConf.AutoHosts = &Context.autoHosts
 	}
-	filterConf.ResolverAddress = fmt.Sprintf("%s:%d", bindhost, config.DNS.Port)
-	filterConf.AutoHosts = &Context.autoHosts
 	filterConf.ConfigModified = onConfigModified
 	filterConf.HTTPRegister = httpRegister
 	Context.dnsFilter = dnsfilter.New(&filterConf, nil)
 
 	Context.dnsServer = dnsforward.NewServer(Context.dnsFilter, Context.stats, Context.queryLog)
-	dnsConfig := generateServerConfig()
+	dnsConfig, err := generateServerConfig()
+	if err != nil {
+		closeDNSServer()
+		return err
+	}
 	err = Context.dnsServer.Prepare(&dnsConfig)
 	if err != nil {
 		closeDNSServer()
@@ -150,10 +160,66 @@ func onDNSRequest(d *proxy.DNSContext) {
 	}
 }
 
-func generateServerConfig() dnsforward.ServerConfig {
+// Get IP addresses to listen on for DNS requests
+// hosts: IP addresses and names of network interfaces:
+//  the addresses of the network interface are used (except link-local addresses)
+// The unspecified address (0.0.0.0 or ::) can't be used along with the other addresses.
+func getDNSBindIPs(hosts []string) ([]net.IP, error) {
+	ips := []net.IP{}
+	unspecified := false
+	for _, h := range hosts {
+		ip := net.ParseIP(h)
+		if ip != nil {
+			unspecified = unspecified || ip.IsUnspecified()
+			ips = append(ips, ip)
+			continue
+		}
+
+		ifaceIPs, err := util.GetInterfaceIPs(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or network interface: '%s'", h)
+		}
+		if len(ifaceIPs) == 0 {
+			return nil, fmt.Errorf("network interface %s has no IP addresses", h)
+		}
+		ips = append(ips, ifaceIPs...)
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses to listen on")
+	}
+	if unspecified && len(ips) != 1 {
+		return nil, fmt.Errorf("the unspecified address can't be used along with the other addresses")
+	}
+
+	// the same address may be specified twice:  directly and via the network interface
+	uniq := []net.IP{}
+	for _, ip := range ips {
+		dup := false
+		for _, u := range uniq {
+			if u.Equal(ip) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			uniq = append(uniq, ip)
+		}
+	}
+	return uniq, nil
+}
+
+func generateServerConfig() (dnsforward.ServerConfig, error) {
+	bindIPs, err := getDNSBindIPs(config.DNS.BindHosts)
+	if err != nil {
+		return dnsforward.ServerConfig{}, fmt.Errorf("dns.bind_hosts: %s", err)
+	}
+	bindIP := bindIPs[0]
+
 	newconfig := dnsforward.ServerConfig{
-		UDPListenAddr:   &net.UDPAddr{IP: net.ParseIP(config.DNS.BindHost), Port: config.DNS.Port},
-		TCPListenAddr:   &net.TCPAddr{IP: net.ParseIP(config.DNS.BindHost), Port: config.DNS.Port},
+		UDPListenAddr:   &net.UDPAddr{IP: bindIP, Port: config.DNS.Port},
+		TCPListenAddr:   &net.TCPAddr{IP: bindIP, Port: config.DNS.Port},
+		ExtraListenIPs:  bindIPs[1:],
 		FilteringConfig: config.DNS.FilteringConfig,
 		ConfigModified:  onConfigModified,
 		HTTPRegister:    httpRegister,
@@ -164,9 +230,18 @@ func generateServerConfig() dnsforward.ServerConfig {
 	Context.tls.WriteDiskConfig(&tlsConf)
 	if tlsConf.Enabled {
 		newconfig.TLSConfig = tlsConf.TLSConfig
+		newconfig.GetOCSPStaple = Context.tls.ocsp.Staple
+		params, err := getTLSParams(tlsConf)
+		if err != nil {
+			log.Error("TLS: %s", err)
+		}
+		newconfig.TLSMinVersion = params.minVersion
+		newconfig.TLSMaxVersion = params.maxVersion
+		newconfig.TLSServerCiphers = params.ciphers
+		newconfig.TLSClientCAs = params.clientCAs
 		if tlsConf.PortDNSOverTLS != 0 {
 			newconfig.TLSListenAddr = &net.TCPAddr{
-				IP:   net.ParseIP(config.DNS.BindHost),
+				IP:   bindIP,
 				Port: tlsConf.PortDNSOverTLS,
 			}
 		}
@@ -174,17 +249,89 @@ func generateServerConfig() dnsforward.ServerConfig {
 	newconfig.TLSv12Roots = Context.tlsRoots
 	newconfig.TLSCiphers = Context.tlsCiphers
 	newconfig.TLSAllowUnencryptedDOH = tlsConf.AllowUnencryptedDOH
+	newconfig.TLSDisableDOH = tlsConf.DisableDOH
+	newconfig.TLSEDNSPadding = tlsConf.EDNSPadding
+
+	// DNSCrypt server listens on ExtraListenIPs too (with the same port)
+	if config.DNS.DNSCrypt.Enabled {
+		newconfig.DNSCryptConfig = dnsforward.DNSCryptConfig{
+			Enabled: true,
+			UDPListenAddr: &net.UDPAddr{
+				IP:   bindIP,
+				Port: config.DNS.DNSCrypt.Port,
+			},
+			TCPListenAddr: &net.TCPAddr{
+				IP:   bindIP,
+				Port: config.DNS.DNSCrypt.Port,
+			},
+			ResolverConfig: config.DNS.DNSCrypt.resolverConfig(),
+		}
+	}
 
 	newconfig.FilterHandler = applyAdditionalFiltering
 	newconfig.GetUpstreamsByClient = getUpstreamsByClient
-	return newconfig
+	newconfig.GetUpstreamAddrsByClient = getUpstreamAddrsByClient
+	newconfig.GetLogSettingsByClient = getLogSettingsByClient
+	newconfig.DHCPServer = Context.dhcpServer
+	return newconfig, nil
+}
+
+// Generate DNSCrypt provider keys if they aren't set yet
+func dnsCryptInitKeys(c *dnsCryptConfig) error {
+	if !c.Enabled || len(c.PrivateKey) != 0 {
+		return nil
+	}
+
+	name := c.ProviderName
+	if len(name) == 0 {
+		name = "adguardhome"
+	}
+	rc, err := dnscrypt.GenerateResolverConfig(name, nil)
+	if err != nil {
+		return fmt.Errorf("DNSCrypt: couldn't generate keys: %s", err)
+	}
+
+	c.ProviderName = rc.ProviderName
+	c.PublicKey = rc.PublicKey
+	c.PrivateKey = rc.PrivateKey
+	c.ResolverSk = rc.ResolverSk
+	c.ResolverPk = rc.ResolverPk
+	log.Info("DNSCrypt: generated new keys for %s", c.ProviderName)
+	return nil
+}
+
+func (c *dnsCryptConfig) resolverConfig() dnscrypt.ResolverConfig {
+	return dnscrypt.ResolverConfig{
+		ProviderName: c.ProviderName,
+		PublicKey:    c.PublicKey,
+		PrivateKey:   c.PrivateKey,
+		ResolverSk:   c.ResolverSk,
+		ResolverPk:   c.ResolverPk,
+		EsVersion:    dnscrypt.XSalsa20Poly1305,
+	}
+}
+
+// Get DNS stamp (sdns://...) of our DNSCrypt server listening on the specified IP address
+func dnsCryptStamp(ip string) (string, error) {
+	rc := config.DNS.DNSCrypt.resolverConfig()
+	stamp, err := rc.CreateStamp(net.JoinHostPort(ip, strconv.Itoa(config.DNS.DNSCrypt.Port)))
+	if err != nil {
+		return "", err
+	}
+	return stamp.String(), nil
 }
 
 // Get the list of DNS addresses the server is listening on
 func getDNSAddresses() []string {
 	dnsAddresses := []string{}
 
-	if config.DNS.BindHost == "0.0.0.0" {
+	bindIPs, err := getDNSBindIPs(config.DNS.BindHosts)
+	if err != nil {
+		log.Error("Couldn't get DNS addresses: %s", err)
+		return []string{}
+	}
+	ips := []string{}
+	if bindIPs[0].IsUnspecified() {
 		ifaces, e := util.GetValidNetInterfacesForWeb()
 		if e != nil {
 			log.Error("Couldn't get network interfaces: %v", e)
@@ -192,19 +339,23 @@ func getDNSAddresses() []string {
 		}
 
 		for _, iface := range ifaces {
-			for _, addr := range iface.Addresses {
-				addDNSAddress(&dnsAddresses, addr)
-			}
+			ips = append(ips, iface.Addresses...)
 		}
 	} else {
-		addDNSAddress(&dnsAddresses, config.DNS.BindHost)
+		for _, ip := range bindIPs {
+			ips = append(ips, ip.String())
+		}
+	}
+
+	for _, ip := range ips {
+		addDNSAddress(&dnsAddresses, ip)
 	}
 
 	tlsConf := tlsConfigSettings{}
 	Context.tls.WriteDiskConfig(&tlsConf)
 	if tlsConf.Enabled && len(tlsConf.ServerName) != 0 {
 
-		if tlsConf.PortHTTPS != 0 {
+		if tlsConf.PortHTTPS != 0 && !tlsConf.DisableDOH {
 			addr := tlsConf.ServerName
 			if tlsConf.PortHTTPS != 443 {
 				addr = fmt.Sprintf("%s:%d", addr, tlsConf.PortHTTPS)
@@ -219,27 +370,46 @@ func getDNSAddresses() []string {
 		}
 	}
 
+	if config.DNS.DNSCrypt.Enabled {
+		for _, ip := range ips {
+			stamp, err := dnsCryptStamp(ip)
+			if err != nil {
+				log.Error("DNSCrypt: couldn't create stamp: %s", err)
+				break
+			}
+			dnsAddresses = append(dnsAddresses, stamp)
+		}
+	}
+
 	return dnsAddresses
 }
 
-func getUpstreamsByClient(clientAddr string) []upstream.Upstream {
-	return Context.clients.FindUpstreams(clientAddr)
+func getUpstreamsByClient(clientAddr, clientID string) []upstream.Upstream {
+	return Context.clients.FindUpstreams(clientAddr, clientID)
+}
+
+func getUpstreamAddrsByClient(clientAddr, clientID string) []string {
+	return Context.clients.FindUpstreamAddrs(clientAddr, clientID)
+}
+
+func getLogSettingsByClient(clientAddr, clientID string) (bool, bool) {
+	return Context.clients.FindLogSettings(clientAddr, clientID)
 }
 
 // If a client has his own settings, apply them
-func applyAdditionalFiltering(clientAddr string, setts *dnsfilter.RequestFilteringSettings) {
+func applyAdditionalFiltering(clientAddr, clientID string, setts *dnsfilter.RequestFilteringSettings) {
 	Context.dnsFilter.ApplyBlockedServices(setts, nil, true)
 
-	if len(clientAddr) == 0 {
+	if len(clientAddr) == 0 && len(clientID) == 0 {
 		return
 	}
 
-	c, ok := Context.clients.Find(clientAddr)
+	c, ok := Context.clients.FindWithClientID(clientAddr, clientID)
 	if !ok {
 		return
 	}
 
-	log.Debug("Using settings for client with IP %s", clientAddr)
+	log.Debug("Using settings for client with IP %s (ClientID: %s)", clientAddr, clientID)
 
 	if c.UseOwnBlockedServices {
 		Context.dnsFilter.ApplyBlockedServices(setts, c.BlockedServices, false)
@@ -247,14 +417,14 @@ func applyAdditionalFiltering(clientAddr string, setts *dnsfilter.RequestFilteri
 
 	setts.ClientTags = c.Tags
 
-	if !c.UseOwnSettings {
-		return
+	if c.UseOwnSettings {
+		setts.FilteringEnabled = c.FilteringEnabled
+		setts.SafeSearchEnabled = c.SafeSearchEnabled
+		setts.SafeBrowsingEnabled = c.SafeBrowsingEnabled
+		setts.ParentalEnabled = c.ParentalEnabled
 	}
 
-	setts.FilteringEnabled = c.FilteringEnabled
-	setts.SafeSearchEnabled = c.SafeSearchEnabled
-	setts.SafeBrowsingEnabled = c.SafeBrowsingEnabled
-	setts.ParentalEnabled = c.ParentalEnabled
+	applySchedule(c.Schedule, time.Now(), setts)
 }
 
 func startDNSServer() error {
@@ -292,8 +462,11 @@ func startDNSServer() error {
 }
 
 func reconfigureDNSServer() error {
-	newconfig := generateServerConfig()
-	err := Context.dnsServer.Reconfigure(&newconfig)
+	newconfig, err := generateServerConfig()
+	if err != nil {
+		return err
+	}
+	err = Context.dnsServer.Reconfigure(&newconfig)
 	if err != nil {
 		return errorx.Decorate(err, "Couldn't start forwarding DNS server")
 	}
@@ -311,6 +484,11 @@ func stopDNSServer() error {
 		return errorx.Decorate(err, "Couldn't stop forwarding DNS server")
 	}
 
+	// finish the requests being processed before the query log and statistics are flushed
+	if !Context.dnsServer.Drain(shutdownTimeout) {
+		log.Info("DNS requests haven't been finished in %s", shutdownTimeout)
+	}
+
 	closeDNSServer()
 	return nil
 }
@@ -0,0 +1,43 @@
+package home
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDNSBindIPs(t *testing.T) {
+	ips, err := getDNSBindIPs([]string{"0.0.0.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ips))
+	assert.True(t, ips[0].IsUnspecified())
+
+	ips, err = getDNSBindIPs([]string{"127.0.0.1", "fd00::1", "127.0.0.1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ips))
+	assert.True(t, ips[1].Equal(net.ParseIP("fd00::1")))
+
+	// the addresses of the loopback interface
+	ifaces, err := net.Interfaces()
+	assert.Nil(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		ips, err = getDNSBindIPs([]string{iface.Name, "127.0.0.1"})
+		assert.Nil(t, err)
+		assert.True(t, len(ips) != 0)
+		for _, ip := range ips {
+			assert.True(t, ip.IsLoopback())
+		}
+		break
+	}
+
+	_, err = getDNSBindIPs([]string{"nonexistent0"})
+	assert.NotNil(t, err)
+	_, err = getDNSBindIPs([]string{"0.0.0.0", "127.0.0.1"})
+	assert.NotNil(t, err)
+	_, err = getDNSBindIPs(nil)
+	assert.NotNil(t, err)
+}
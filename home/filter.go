@@ -6,6 +6,7 @@ import (
 	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -22,6 +23,9 @@ import (
 
 var (
 	nextFilterID = time.Now().Unix() // semi-stable way to generate an unique ID
+
+	// used to calculate the update delay of filters, different for each run
+	filterUpdateJitterSeed = rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
 )
 
 // Filtering - module object
@@ -30,6 +34,7 @@ type Filtering struct {
 	refreshStatus     uint32 // 0:none; 1:in progress
 	refreshLock       sync.Mutex
 	filterTitleRegexp *regexp.Regexp
+	watch             filterWatcher // watcher for the local files of the filters
 }
 
 // Init - initialize the module
@@ -51,10 +56,13 @@ func (f *Filtering) Start() {
 	//  but currently we can't wake up the periodic task to do so.
 	// So for now we just start this periodic task from here.
 	go f.periodicallyRefreshFilters()
+
+	f.startWatcher()
 }
 
 // Close - close the module
 func (f *Filtering) Close() {
+	f.closeWatcher()
 }
 
 func defaultFilters() []filter {
@@ -67,13 +75,17 @@ func defaultFilters() []filter {
 
 // field ordering is important -- yaml fields will mirror ordering from here
 type filter struct {
-	Enabled     bool
-	URL         string    // URL or a file path
-	Name        string    `yaml:"name"`
-	RulesCount  int       `yaml:"-"`
-	LastUpdated time.Time `yaml:"-"`
-	checksum    uint32    // checksum of the file data
-	white       bool
+	Enabled        bool
+	URL            string    // URL or a file path
+	Name           string    `yaml:"name"`
+	RulesCount     int       `yaml:"-"`
+	UpdateInterval uint32    `yaml:"update_interval,omitempty"` // in hours;  0: use the global setting
+	LastUpdated    time.Time `yaml:"-"`
+	LastChecked    time.Time `yaml:"-"` // the time of the last download attempt
+	LastError      string    `yaml:"-"` // the error of the last download attempt
+	HTTPStatus     int       `yaml:"-"` // HTTP status code received on the last download attempt
+	checksum       uint32    // checksum of the file data
+	white          bool
 
 	dnsfilter.Filter `yaml:",inline"`
 }
@@ -114,9 +126,10 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 			continue
 		}
 
-		log.Debug("filter: set properties: %s: {%s %s %v}",
-			filt.URL, newf.Name, newf.URL, newf.Enabled)
+		log.Debug("filter: set properties: %s: {%s %s %v %d}",
+			filt.URL, newf.Name, newf.URL, newf.Enabled, newf.UpdateInterval)
 		filt.Name = newf.Name
+		filt.UpdateInterval = newf.UpdateInterval
 
 		if filt.URL != newf.URL {
 			r |= statusURLChanged | statusUpdateRequired
@@ -252,16 +265,21 @@ func assignUniqueFilterID() int64 {
 // Sets up a timer that will be checking for filters updates periodically
 func (f *Filtering) periodicallyRefreshFilters() {
 	const maxInterval = 1 * 60 * 60
+	const checkInterval = 10 * 60
 	intval := 5 // use a dynamically increasing time interval
+	if allFiltersDownloaded() {
+		// don't download the expired filters right after the start of all instances
+		intval += rand.Intn(60)
+	}
 	for {
 		isNetworkErr := false
-		if config.DNS.FiltersUpdateIntervalHours != 0 && atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1) {
+		if atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1) {
 			f.refreshLock.Lock()
-			_, isNetworkErr = f.refreshFiltersIfNecessary(FilterRefreshBlocklists | FilterRefreshAllowlists)
+			_, isNetworkErr = f.refreshFiltersIfNecessary(FilterRefreshBlocklists|FilterRefreshAllowlists, "")
 			f.refreshLock.Unlock()
 			f.refreshStatus = 0
 			if !isNetworkErr {
-				intval = maxInterval
+				intval = checkInterval
 			}
 		}
 
@@ -276,24 +294,62 @@ func (f *Filtering) periodicallyRefreshFilters() {
 	}
 }
 
+// Return TRUE if all enabled filters have been downloaded
+func allFiltersDownloaded() bool {
+	config.RLock()
+	defer config.RUnlock()
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, f := range filters {
+			if f.Enabled && f.LastUpdated.IsZero() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Get the update interval of the filter (in hours)
+// 0: the filter isn't updated automatically
+func (filter *filter) updateIntervalHours() uint32 {
+	if filter.UpdateInterval != 0 {
+		return filter.UpdateInterval
+	}
+	return config.DNS.FiltersUpdateIntervalHours
+}
+
+// Get the time when the filter must be updated
+// A delay (up to 10% of the update interval) is added,
+//  so that the same filter isn't downloaded by all instances at the same time.
+// If the last download attempt has failed, the next attempt is made after the same interval.
+func (filter *filter) expireTime(intervalHours uint32) time.Time {
+	interval := time.Duration(intervalHours) * time.Hour
+	jitter := time.Duration((crc32.ChecksumIEEE([]byte(filter.URL))^filterUpdateJitterSeed)%1000) * interval / 10000
+	last := filter.LastUpdated
+	if filter.LastChecked.After(last) {
+		last = filter.LastChecked
+	}
+	return last.Add(interval + jitter)
+}
+
 // Refresh filters
 // flags: FilterRefresh*
+// url: refresh only the filter with this URL (if not empty)
 // important:
 //  TRUE: ignore the fact that we're currently updating the filters
-func (f *Filtering) refreshFilters(flags int, important bool) (int, error) {
+func (f *Filtering) refreshFilters(flags int, url string, important bool) (int, error) {
 	set := atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1)
 	if !important && !set {
 		return 0, fmt.Errorf("filters update procedure is already running")
 	}
 
 	f.refreshLock.Lock()
-	nUpdated, _ := f.refreshFiltersIfNecessary(flags)
+	nUpdated, _ := f.refreshFiltersIfNecessary(flags, url)
 	f.refreshLock.Unlock()
 	f.refreshStatus = 0
 	return nUpdated, nil
 }
 
-func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []filter, []bool, bool) {
+func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool, url string) (int, []filter, []bool, bool) {
 	var updateFilters []filter
 	var updateFlags []bool // 'true' if filter data has changed
 
@@ -302,19 +358,22 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 	for i := range *filters {
 		f := &(*filters)[i] // otherwise we will be operating on a copy
 
-		if !f.Enabled {
+		if !f.Enabled || (len(url) != 0 && f.URL != url) {
 			continue
 		}
 
-		expireTime := f.LastUpdated.Unix() + int64(config.DNS.FiltersUpdateIntervalHours)*60*60
-		if !force && expireTime > now.Unix() {
-			continue
+		if !force {
+			interval := f.updateIntervalHours()
+			if interval == 0 || f.expireTime(interval).After(now) {
+				continue
+			}
 		}
 
 		var uf filter
 		uf.ID = f.ID
 		uf.URL = f.URL
 		uf.Name = f.Name
+		uf.LastUpdated = f.LastUpdated
 		uf.checksum = f.checksum
 		updateFilters = append(updateFilters, uf)
 	}
@@ -336,10 +395,6 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 		}
 	}
 
-	if nfail == len(updateFilters) {
-		return 0, nil, nil, true
-	}
-
 	updateCount := 0
 	for i := range updateFilters {
 		uf := &updateFilters[i]
@@ -352,6 +407,9 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 				continue
 			}
 			f.LastUpdated = uf.LastUpdated
+			f.LastChecked = uf.LastChecked
+			f.LastError = uf.LastError
+			f.HTTPStatus = uf.HTTPStatus
 			if !updated {
 				continue
 			}
@@ -366,6 +424,10 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 		config.Unlock()
 	}
 
+	if nfail == len(updateFilters) {
+		return 0, nil, nil, true
+	}
+
 	return updateCount, updateFilters, updateFlags, false
 }
 
@@ -378,6 +440,7 @@ const (
 // Checks filters updates if necessary
 // If force is true, it ignores the filter.LastUpdated field value
 // flags: FilterRefresh*
+// url: update only the filter with this URL (if not empty)
 //
 // Algorithm:
 // . Get the list of filters to be updated
@@ -394,7 +457,7 @@ const (
 //
 // Return the number of updated filters
 // Return TRUE - there was a network error and nothing could be updated
-func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
+func (f *Filtering) refreshFiltersIfNecessary(flags int, url string) (int, bool) {
 	log.Debug("Filters: updating...")
 
 	updateCount := 0
@@ -407,13 +470,13 @@ func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
 		force = true
 	}
 	if (flags & FilterRefreshBlocklists) != 0 {
-		updateCount, updateFilters, updateFlags, netError = f.refreshFiltersArray(&config.Filters, force)
+		updateCount, updateFilters, updateFlags, netError = f.refreshFiltersArray(&config.Filters, force, url)
 	}
 	if (flags & FilterRefreshAllowlists) != 0 {
 		updateCountW := 0
 		var updateFiltersW []filter
 		var updateFlagsW []bool
-		updateCountW, updateFiltersW, updateFlagsW, netErrorW = f.refreshFiltersArray(&config.WhitelistFilters, force)
+		updateCountW, updateFiltersW, updateFlagsW, netErrorW = f.refreshFiltersArray(&config.WhitelistFilters, force, url)
 		updateCount += updateCountW
 		updateFilters = append(updateFilters, updateFiltersW...)
 		updateFlags = append(updateFlags, updateFlagsW...)
@@ -487,9 +550,18 @@ func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
 }
 
 // Perform upgrade on a filter and update LastUpdated value
+// The result of the attempt is stored in the filter object.
+// The last update time isn't changed if the attempt has failed.
 func (f *Filtering) update(filter *filter) (bool, error) {
+	filter.HTTPStatus = 0
 	b, err := f.updateIntl(filter)
-	filter.LastUpdated = time.Now()
+	filter.LastChecked = time.Now()
+	filter.LastError = ""
+	if err != nil {
+		filter.LastError = err.Error()
+		return false, err
+	}
+	filter.LastUpdated = filter.LastChecked
 	if !b {
 		e := os.Chtimes(filter.Path(), filter.LastUpdated, filter.LastUpdated)
 		if e != nil {
@@ -515,8 +587,8 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 	}()
 
 	var reader io.Reader
-	if filepath.IsAbs(filter.URL) {
-		f, err := os.Open(filter.URL)
+	if fn := filterLocalPath(filter.URL); len(fn) != 0 {
+		f, err := os.Open(fn)
 		if err != nil {
 			return false, fmt.Errorf("open file: %s", err)
 		}
@@ -532,6 +604,7 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 			return false, err
 		}
 
+		filter.HTTPStatus = resp.StatusCode
 		if resp.StatusCode != 200 {
 			log.Printf("Got status code %d from URL %s, skipping", resp.StatusCode, filter.URL)
 			return false, fmt.Errorf("got status code != 200: %d", resp.StatusCode)
@@ -707,4 +780,5 @@ func enableFilters(async bool) {
 	}
 
 	_ = Context.dnsFilter.SetFilters(filters, whiteFilters, async)
+	Context.filters.updateWatchedDirs()
 }
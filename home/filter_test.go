@@ -1,13 +1,19 @@
 package home
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,3 +68,269 @@ func TestFilters(t *testing.T) {
 	f.unload()
 	_ = os.Remove(f.Path())
 }
+
+func TestFilterUpdateInterval(t *testing.T) {
+	config.DNS.FiltersUpdateIntervalHours = 24
+	defer func() { config.DNS.FiltersUpdateIntervalHours = 0 }()
+
+	f := filter{URL: "https://example.org/filter.txt"}
+	assert.Equal(t, uint32(24), f.updateIntervalHours())
+	f.UpdateInterval = 1
+	assert.Equal(t, uint32(1), f.updateIntervalHours())
+
+	// the delay is up to 10% of the update interval
+	f.LastUpdated = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	exp := f.expireTime(f.updateIntervalHours())
+	assert.False(t, exp.Before(f.LastUpdated.Add(time.Hour)))
+	assert.True(t, exp.Before(f.LastUpdated.Add(66*time.Minute)))
+	assert.Equal(t, exp, f.expireTime(f.updateIntervalHours()))
+}
+
+func TestFiltersRefresh(t *testing.T) {
+	contents := map[string]string{
+		"/1.txt": "||example.org^\n",
+		"/2.txt": "||example.com^\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(contents[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = srv.Client()
+	Context.dnsFilter = dnsfilter.New(nil, nil)
+	defer Context.dnsFilter.Close()
+	Context.filters.Init()
+	config.DNS.FilteringEnabled = true
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() { config.Filters = nil }()
+
+	refresh := func(body string) (int, filterRefreshResp) {
+		Context.controlLock.Lock()
+		defer Context.controlLock.Unlock()
+		r := httptest.NewRequest("POST", "/control/filtering/refresh", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		Context.filters.handleFilteringRefresh(w, r)
+		resp := filterRefreshResp{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := refresh(`{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, resp.Updated)
+	assert.Equal(t, 2, len(resp.Filters))
+
+	// nothing has changed
+	code, resp = refresh(`{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, resp.Updated)
+	assert.Equal(t, 0, len(resp.Filters))
+
+	// refresh only one filter
+	contents["/1.txt"] = "||example.org^\n||example.net^\n"
+	contents["/2.txt"] = "||example.com^\n||example.net^\n"
+	code, resp = refresh(fmt.Sprintf(`{"url":"%s/1.txt"}`, srv.URL))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, resp.Updated)
+	if assert.Equal(t, 1, len(resp.Filters)) {
+		assert.Equal(t, int64(1), resp.Filters[0].ID)
+		assert.Equal(t, 1, resp.Filters[0].RulesCountOld)
+		assert.Equal(t, 2, resp.Filters[0].RulesCount)
+	}
+	assert.Equal(t, 1, config.Filters[1].RulesCount)
+
+	code, _ = refresh(`{"url":"https://unknown.example.org/filter.txt"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	for _, f := range config.Filters {
+		_ = os.Remove(f.Path())
+	}
+}
+
+func TestFiltersExportImport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = srv.Client()
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(nil, nil)
+	Context.dnsFilter.Start()
+	defer Context.dnsFilter.Close()
+	Context.filters.Init()
+	config.DNS.FilteringEnabled = true
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Name: "one", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: srv.URL + "/2.txt", Name: "two", UpdateInterval: 12, Filter: dnsfilter.Filter{ID: 2}},
+	}
+	config.WhitelistFilters = nil
+	config.UserRules = []string{"||example.com^"}
+	defer func() {
+		config.Filters = nil
+		config.UserRules = nil
+	}()
+
+	export := func(format string) (int, []byte) {
+		r := httptest.NewRequest("GET", "/control/filtering/export?format="+format, nil)
+		w := httptest.NewRecorder()
+		Context.filters.handleFilteringExport(w, r)
+		return w.Code, w.Body.Bytes()
+	}
+	importDoc := func(doc []byte) int {
+		Context.controlLock.Lock()
+		defer Context.controlLock.Unlock()
+		r := httptest.NewRequest("POST", "/control/filtering/import", strings.NewReader(string(doc)))
+		w := httptest.NewRecorder()
+		Context.filters.handleFilteringImport(w, r)
+		return w.Code
+	}
+
+	code, data := export("")
+	assert.Equal(t, http.StatusOK, code)
+	doc := filtersExportJSON{}
+	assert.Nil(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, 2, len(doc.Filters))
+	assert.Equal(t, filterExportJSON{Name: "two", URL: srv.URL + "/2.txt", UpdateInterval: 12}, doc.Filters[1])
+	assert.Equal(t, 0, len(doc.WhitelistFilters))
+	assert.Equal(t, []string{"||example.com^"}, doc.UserRules)
+
+	code, yamlData := export("yaml")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, strings.Contains(string(yamlData), "update_interval: 12"))
+
+	code, _ = export("xml")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// import on a clean instance
+	config.Filters = nil
+	config.UserRules = nil
+	assert.Equal(t, http.StatusOK, importDoc(yamlData))
+	assert.Equal(t, 2, len(config.Filters))
+	assert.Equal(t, "one", config.Filters[0].Name)
+	assert.True(t, config.Filters[0].ID != 0)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.False(t, config.Filters[1].Enabled)
+	assert.Equal(t, uint32(12), config.Filters[1].UpdateInterval)
+	assert.Equal(t, []string{"||example.com^"}, config.UserRules)
+
+	// JSON document: the filter with the same URL keeps its ID
+	id := config.Filters[0].ID
+	doc.Filters = doc.Filters[:1]
+	doc.Filters[0].Name = "renamed"
+	doc.WhitelistFilters = []filterExportJSON{{Name: "allow", URL: srv.URL + "/3.txt", Enabled: true}}
+	data, _ = json.Marshal(doc)
+	assert.Equal(t, http.StatusOK, importDoc(data))
+	assert.Equal(t, 1, len(config.Filters))
+	assert.Equal(t, id, config.Filters[0].ID)
+	assert.Equal(t, "renamed", config.Filters[0].Name)
+	assert.Equal(t, 1, len(config.WhitelistFilters))
+
+	// duplicate URL
+	doc.WhitelistFilters[0].URL = doc.Filters[0].URL
+	data, _ = json.Marshal(doc)
+	assert.Equal(t, http.StatusBadRequest, importDoc(data))
+	assert.Equal(t, "allow", config.WhitelistFilters[0].Name)
+
+	config.WhitelistFilters = nil
+}
+
+func TestFilterUpdateStatus(t *testing.T) {
+	status := http.StatusNotFound
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = srv.Client()
+	Context.filters.Init()
+
+	f := filter{URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}}
+	_, err := Context.filters.update(&f)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusNotFound, f.HTTPStatus)
+	assert.NotEqual(t, "", f.LastError)
+	assert.True(t, f.LastUpdated.IsZero())
+	assert.False(t, f.LastChecked.IsZero())
+
+	fj := filterToJSON(f)
+	assert.Equal(t, http.StatusNotFound, fj.HTTPStatus)
+	assert.Equal(t, f.LastError, fj.LastError)
+	assert.Equal(t, "", fj.LastUpdated)
+
+	// the next attempt is made after the update interval
+	assert.True(t, f.expireTime(1).After(f.LastChecked.Add(time.Hour-time.Second)))
+
+	status = http.StatusOK
+	ok, err := Context.filters.update(&f)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusOK, f.HTTPStatus)
+	assert.Equal(t, "", f.LastError)
+	assert.Equal(t, f.LastChecked, f.LastUpdated)
+
+	_ = os.Remove(f.Path())
+}
+
+func TestFilterLocalFile(t *testing.T) {
+	assert.Equal(t, "/tmp/rules.txt", filterLocalPath("/tmp/rules.txt"))
+	assert.Equal(t, "/tmp/rules.txt", filterLocalPath("file:///tmp/rules.txt"))
+	assert.Equal(t, "", filterLocalPath("file://host/rules.txt"))
+	assert.Equal(t, "", filterLocalPath("https://example.org/rules.txt"))
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.dnsFilter = dnsfilter.New(nil, nil)
+	Context.dnsFilter.Start()
+	defer Context.dnsFilter.Close()
+	Context.filters.Init()
+
+	scriptDir, _ := filepath.Abs(filepath.Join(dir, "scripts"))
+	_ = os.MkdirAll(scriptDir, 0755)
+	fn := filepath.Join(scriptDir, "rules.txt")
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("||example.org^\n"), 0644))
+	assert.True(t, IsValidURL("file://"+fn))
+	assert.False(t, IsValidURL("file://"+filepath.Join(scriptDir, "none.txt")))
+
+	config.DNS.FilteringEnabled = true
+	config.Filters = []filter{{Enabled: true, URL: "file://" + fn, Filter: dnsfilter.Filter{ID: 1}}}
+	defer func() { config.Filters = nil }()
+
+	n, _ := Context.filters.refreshFilters(FilterRefreshForce|FilterRefreshBlocklists, "", true)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+
+	// the filter is reloaded after the file has been modified
+	Context.filters.startWatcher()
+	defer Context.filters.closeWatcher()
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("||example.org^\n||example.com^\n"), 0644))
+
+	rulesCount := 0
+	for i := 0; i != 50 && rulesCount != 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+		config.RLock()
+		rulesCount = config.Filters[0].RulesCount
+		config.RUnlock()
+	}
+	assert.Equal(t, 2, rulesCount)
+
+	_ = os.Remove(config.Filters[0].Path())
+}
@@ -0,0 +1,170 @@
+package home
+
+import (
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// A filter may be loaded from a local file: its URL is an absolute file path or "file://" URL.
+// Such files are watched for changes so that the filters generated by user scripts
+//  are reloaded automatically.
+// We watch the directories, not the files:
+//  a script or a text editor may replace the file and the watcher for the old file would stop working.
+
+// Wait for this time after the last change before reloading the file:
+//  a script may write the file in several steps
+const filterWatchDelay = 1 * time.Second
+
+// filterWatcher - watches the local files of the filters
+type filterWatcher struct {
+	lock    sync.Mutex
+	watcher *fsnotify.Watcher
+	dirs    map[string]bool // the directories we watch
+}
+
+// Get the file path of the local filter
+// Return "" if the filter is downloaded from the network
+func filterLocalPath(rawurl string) string {
+	if filepath.IsAbs(rawurl) {
+		return filepath.Clean(rawurl)
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "file" || len(u.Host) != 0 {
+		return ""
+	}
+	p := filepath.FromSlash(u.Path)
+	if !filepath.IsAbs(p) {
+		return ""
+	}
+	return filepath.Clean(p)
+}
+
+// Start watching the local files of the filters
+func (f *Filtering) startWatcher() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("Filters: fsnotify.NewWatcher(): %s", err)
+		return
+	}
+
+	f.watch.lock.Lock()
+	f.watch.watcher = w
+	f.watch.dirs = map[string]bool{}
+	f.watch.lock.Unlock()
+
+	go f.watcherLoop(w)
+	f.updateWatchedDirs()
+}
+
+// Stop watching the local files of the filters
+func (f *Filtering) closeWatcher() {
+	f.watch.lock.Lock()
+	defer f.watch.lock.Unlock()
+	if f.watch.watcher == nil {
+		return
+	}
+	_ = f.watch.watcher.Close()
+	f.watch.watcher = nil
+	f.watch.dirs = nil
+}
+
+// Get the paths of the local files of the enabled filters
+func localFilterFiles() map[string]string {
+	files := map[string]string{} // file path -> filter URL
+	config.RLock()
+	for _, list := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, filt := range list {
+			if !filt.Enabled {
+				continue
+			}
+			if fn := filterLocalPath(filt.URL); len(fn) != 0 {
+				files[fn] = filt.URL
+			}
+		}
+	}
+	config.RUnlock()
+	return files
+}
+
+// Update the list of the watched directories after the filters have been changed
+func (f *Filtering) updateWatchedDirs() {
+	dirs := map[string]bool{}
+	for fn := range localFilterFiles() {
+		dirs[filepath.Dir(fn)] = true
+	}
+
+	f.watch.lock.Lock()
+	defer f.watch.lock.Unlock()
+	if f.watch.watcher == nil {
+		return
+	}
+
+	for dir := range f.watch.dirs {
+		if dirs[dir] {
+			continue
+		}
+		_ = f.watch.watcher.Remove(dir)
+		delete(f.watch.dirs, dir)
+		log.Debug("Filters: stopped watching directory %s", dir)
+	}
+
+	for dir := range dirs {
+		if f.watch.dirs[dir] {
+			continue
+		}
+		err := f.watch.watcher.Add(dir)
+		if err != nil {
+			log.Error("Filters: error while initializing watcher for a directory %s: %s", dir, err)
+			continue
+		}
+		f.watch.dirs[dir] = true
+		log.Debug("Filters: watching directory %s", dir)
+	}
+}
+
+// Receive notifications from fsnotify package and reload the modified filters
+func (f *Filtering) watcherLoop(w *fsnotify.Watcher) {
+	modified := map[string]bool{} // the paths of the modified files
+	timer := time.NewTimer(filterWatchDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			modified[filepath.Clean(event.Name)] = true
+			timer.Reset(filterWatchDelay)
+
+		case <-timer.C:
+			files := localFilterFiles()
+			for fn := range modified {
+				u, ok := files[fn]
+				if !ok {
+					continue
+				}
+				log.Debug("Filters: modified: %s", fn)
+				flags := FilterRefreshForce | FilterRefreshBlocklists | FilterRefreshAllowlists
+				_, _ = f.refreshFilters(flags, u, true)
+			}
+			modified = map[string]bool{}
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Error("Filters: %s", err)
+		}
+	}
+}
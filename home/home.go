@@ -45,12 +45,15 @@ const (
 var (
 	versionString   = "dev"
 	updateChannel   = "none"
-	versionCheckURL = ""
 	ARMVersion      = ""
+	updatePublicKey = "" // base64-encoded Ed25519 key which verifies the update packages
 )
 
 const versionCheckPeriod = time.Hour * 8
 
+// On shutdown the HTTP requests and the DNS requests being processed are given this time to finish
+const shutdownTimeout = 5 * time.Second
+
 // Global context
 type homeContext struct {
 	// Modules
@@ -97,19 +100,24 @@ func (c *homeContext) getDataDir() string {
 var Context homeContext
 
 // Main is the entry point
-func Main(version string, channel string, armVer string) {
+func Main(version string, channel string, armVer string, updateKey string) {
 	// Init update-related global variables
 	versionString = version
 	updateChannel = channel
 	ARMVersion = armVer
-	versionCheckURL = "https://static.adguard.com/adguardhome/" + updateChannel + "/version.json"
+	updatePublicKey = updateKey
 
 	// config can be specified, which reads options from there, but other command line flags have to override config values
 	// therefore, we must do it manually instead of using a lib
 	args := loadOptions()
 
 	if args.serviceControlAction != "" {
-		handleServiceControlAction(args.serviceControlAction)
+		handleServiceControlAction(args)
+		return
+	}
+
+	if args.hashPassword {
+		printPasswordHash()
 		return
 	}
 
@@ -121,6 +129,10 @@ func Main(version string, channel string, armVer string) {
 			log.Info("Received signal '%s'", sig)
 			switch sig {
 			case syscall.SIGHUP:
+				err := reloadConfigFile()
+				if err != nil {
+					log.Error("%s", err)
+				}
 				Context.clients.Reload()
 				Context.tls.Reload()
 
@@ -167,6 +179,11 @@ func run(args options) {
 	Context.runningAsService = args.runningAsService
 	Context.disableUpdate = args.disableUpdate
 
+	if args.checkConfig {
+		initConfig()
+		os.Exit(checkConfigFile())
+	}
+
 	Context.firstRun = detectFirstRun()
 	if Context.firstRun {
 		log.Info("This is the first time AdGuard Home is launched")
@@ -196,16 +213,19 @@ func run(args options) {
 			log.Fatal(err)
 		}
 
+		if len(args.resetPassword) != 0 {
+			err = resetPassword(args.resetPassword)
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		}
+
 		err = parseConfig()
 		if err != nil {
 			log.Error("Failed to parse configuration, exiting")
 			os.Exit(1)
 		}
-
-		if args.checkConfig {
-			log.Info("Configuration file is OK")
-			os.Exit(0)
-		}
 	}
 
 	// 'clients' module uses 'dnsfilter' module's static data (dnsfilter.BlockedSvcKnown()),
@@ -222,6 +242,9 @@ func run(args options) {
 		os.Exit(1)
 	}
 	Context.autoHosts.Init("")
+	if len(config.DNS.HostsFile) != 0 {
+		Context.autoHosts.AddHostsFile(config.DNS.HostsFile)
+	}
 	Context.clients.Init(config.Clients, Context.dhcpServer, &Context.autoHosts)
 	config.Clients = nil
 
@@ -269,7 +292,10 @@ func run(args options) {
 	if Context.auth == nil {
 		log.Fatalf("Couldn't initialize Auth module")
 	}
+	Context.auth.SetAPITokens(config.APITokens)
+	Context.auth.SetRateLimit(config.AuthAttempts, time.Duration(config.AuthBlockMin)*time.Minute)
 	config.Users = nil
+	config.APITokens = nil
 
 	Context.tls = tlsCreate(config.TLS)
 	if Context.tls == nil {
@@ -280,6 +306,11 @@ func run(args options) {
 		firstRun: Context.firstRun,
 		BindHost: config.BindHost,
 		BindPort: config.BindPort,
+		BasePath: config.WebBasePath,
+	}
+	webConf.TrustedProxies, err = parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
 	Context.web = CreateWeb(&webConf)
 	if Context.web == nil {
@@ -292,7 +323,9 @@ func run(args options) {
 			log.Fatalf("%s", err)
 		}
 		Context.tls.Start()
-		Context.autoHosts.Start()
+		if config.DNS.HostsFileEnabled {
+			Context.autoHosts.Start()
+		}
 
 		go func() {
 			err := startDNSServer()
@@ -309,6 +342,10 @@ func run(args options) {
 
 	Context.web.Start()
 
+	if !Context.firstRun {
+		startUpdateChecker()
+	}
+
 	// wait indefinitely for other go-routines to complete their job
 	select {}
 }
@@ -484,6 +521,8 @@ type options struct {
 	pidFile        string // File name to save PID to
 	checkConfig    bool   // Check configuration and exit
 	disableUpdate  bool   // If set, don't check for updates
+	hashPassword   bool   // Print the hash of the password and exit
+	resetPassword  string // Set the password of the user in config file and exit
 
 	// service control action (see service.ControlAction array + "status" command)
 	serviceControlAction string
@@ -523,6 +562,10 @@ func loadOptions() options {
 		{"pidfile", "", "Path to a file where PID is stored", func(value string) { o.pidFile = value }, nil},
 		{"check-config", "", "Check configuration and exit", nil, func() { o.checkConfig = true }},
 		{"no-check-update", "", "Don't check for updates", nil, func() { o.disableUpdate = true }},
+		{"hash-password", "", "Print bcrypt hash of the password read from stdin and exit", nil, func() { o.hashPassword = true }},
+		{"reset-password", "", "Set the password (read from stdin) of the user in config file and exit", func(value string) {
+			o.resetPassword = value
+		}, nil},
 		{"verbose", "v", "Enable verbose output", nil, func() { o.verbose = true }},
 		{"version", "", "Show the version and exit", nil, func() {
 			fmt.Printf("AdGuardHome %s\n", versionString)
@@ -589,9 +632,9 @@ func printHTTPAddresses(proto string) {
 	}
 	if proto == "https" && tlsConf.ServerName != "" {
 		if tlsConf.PortHTTPS == 443 {
-			log.Printf("Go to https://%s", tlsConf.ServerName)
+			log.Printf("Go to https://%s%s", tlsConf.ServerName, config.WebBasePath)
 		} else {
-			log.Printf("Go to https://%s:%d", tlsConf.ServerName, tlsConf.PortHTTPS)
+			log.Printf("Go to https://%s:%d%s", tlsConf.ServerName, tlsConf.PortHTTPS, config.WebBasePath)
 		}
 	} else if config.BindHost == "0.0.0.0" {
 		log.Println("AdGuard Home is available on the following addresses:")
@@ -599,17 +642,17 @@ func printHTTPAddresses(proto string) {
 		if err != nil {
 			// That's weird, but we'll ignore it
 			address = net.JoinHostPort(config.BindHost, strconv.Itoa(config.BindPort))
-			log.Printf("Go to %s://%s", proto, address)
+			log.Printf("Go to %s://%s%s", proto, address, config.WebBasePath)
 			return
 		}
 
 		for _, iface := range ifaces {
 			address = net.JoinHostPort(iface.Addresses[0], strconv.Itoa(config.BindPort))
-			log.Printf("Go to %s://%s", proto, address)
+			log.Printf("Go to %s://%s%s", proto, address, config.WebBasePath)
 		}
 	} else {
 		address = net.JoinHostPort(config.BindHost, strconv.Itoa(config.BindPort))
-		log.Printf("Go to %s://%s", proto, address)
+		log.Printf("Go to %s://%s%s", proto, address, config.WebBasePath)
 	}
 }
 
@@ -0,0 +1,81 @@
+package home
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+
+	"github.com/AdguardTeam/AdGuardHome/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/util"
+)
+
+// Prometheus metrics:  GET /metrics
+// The handler requires authentication like the other handlers:
+//  Prometheus may use Basic authentication ("basic_auth" setting of the scrape config).
+
+// Write the metrics of the enabled filter lists
+func writeFilterMetrics(mw *util.MetricsWriter) {
+	mw.Header("adguard_filter_rules", "gauge", "Number of rules in the enabled filter lists")
+	config.RLock()
+	for i, list := range [][]filter{config.Filters, config.WhitelistFilters} {
+		typ := "blocklist"
+		if i == 1 {
+			typ = "allowlist"
+		}
+		for _, f := range list {
+			if !f.Enabled {
+				continue
+			}
+			mw.Sample("adguard_filter_rules", float64(f.RulesCount),
+				"id", strconv.FormatInt(f.ID, 10), "name", f.Name, "type", typ)
+		}
+	}
+	userRules := len(config.UserRules)
+	config.RUnlock()
+
+	mw.Header("adguard_user_rules", "gauge", "Number of custom filtering rules")
+	mw.Sample("adguard_user_rules", float64(userRules))
+}
+
+// Write the metrics of DHCP server
+func writeDHCPMetrics(mw *util.MetricsWriter) {
+	if Context.dhcpServer == nil {
+		return
+	}
+	mw.Header("adguard_dhcp_leases", "gauge", "Number of DHCP leases")
+	mw.Sample("adguard_dhcp_leases", float64(len(Context.dhcpServer.Leases(dhcpd.LeasesDynamic))), "type", "dynamic")
+	mw.Sample("adguard_dhcp_leases", float64(len(Context.dhcpServer.Leases(dhcpd.LeasesStatic))), "type", "static")
+}
+
+// Write the metrics of Go runtime
+func writeRuntimeMetrics(mw *util.MetricsWriter) {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+
+	mw.Header("go_info", "gauge", "Information about the Go environment")
+	mw.Sample("go_info", 1, "version", runtime.Version())
+	mw.Header("go_goroutines", "gauge", "Number of goroutines that currently exist")
+	mw.Sample("go_goroutines", float64(runtime.NumGoroutine()))
+	mw.Header("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use")
+	mw.Sample("go_memstats_alloc_bytes", float64(ms.Alloc))
+	mw.Header("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system")
+	mw.Sample("go_memstats_sys_bytes", float64(ms.Sys))
+	mw.Header("go_memstats_heap_objects", "gauge", "Number of allocated objects")
+	mw.Sample("go_memstats_heap_objects", float64(ms.HeapObjects))
+	mw.Header("go_memstats_gc_cycles_total", "counter", "Number of completed GC cycles")
+	mw.Sample("go_memstats_gc_cycles_total", float64(ms.NumGC))
+	mw.Header("go_memstats_gc_pause_seconds_total", "counter", "Total time of GC stop-the-world pauses")
+	mw.Sample("go_memstats_gc_pause_seconds_total", float64(ms.PauseTotalNs)/1e9)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mw := util.NewMetricsWriter(w)
+
+	if Context.dnsServer != nil {
+		Context.dnsServer.WriteMetrics(w)
+	}
+	writeFilterMetrics(mw)
+	writeDHCPMetrics(mw)
+	writeRuntimeMetrics(mw)
+}
@@ -0,0 +1,168 @@
+package home
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Command-line password utilities:
+//  --hash-password: print bcrypt hash of the password (e.g. to put it into configuration file manually)
+//  --reset-password NAME: set the password of the user in configuration file
+//   (or in the password file of the user if it's set)
+// The password is read from the terminal (without echo) or from the standard input.
+
+// Return TRUE if the string is a bcrypt hash
+func isPasswordHash(s string) bool {
+	_, err := bcrypt.Cost([]byte(s))
+	return err == nil
+}
+
+// Get bcrypt hash of the password
+func hashPassword(password string) (string, error) {
+	if len(password) == 0 {
+		return "", fmt.Errorf("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt.GenerateFromPassword: %s", err)
+	}
+	return string(hash), nil
+}
+
+// Read the new password from the terminal (asking it twice) or from the standard input
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return "", fmt.Errorf("couldn't read password: %s", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "New password: ")
+	pass, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read password: %s", err)
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	pass2, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read password: %s", err)
+	}
+	if string(pass) != string(pass2) {
+		return "", fmt.Errorf("passwords don't match")
+	}
+	return string(pass), nil
+}
+
+// Print bcrypt hash of the password read from the terminal or standard input
+func printPasswordHash() {
+	password, err := readPassword()
+	if err == nil {
+		password, err = hashPassword(password)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(password)
+}
+
+// Get the password file of the user in configuration data
+func userPasswordFile(diskConfig map[string]interface{}, name string) string {
+	users, _ := diskConfig["users"].([]interface{})
+	for _, it := range users {
+		u, ok := it.(map[interface{}]interface{})
+		if ok && u["name"] == name {
+			fn, _ := u["password_file"].(string)
+			return fn
+		}
+	}
+	return ""
+}
+
+// Set the password hash of the user in configuration data;  add a new user if it doesn't exist
+// Return TRUE if the existing user is updated
+func setUserPassword(diskConfig *map[string]interface{}, name string, hash string) bool {
+	users, _ := (*diskConfig)["users"].([]interface{})
+	for _, it := range users {
+		u, ok := it.(map[interface{}]interface{})
+		if ok && u["name"] == name {
+			u["password"] = hash
+			return true
+		}
+	}
+
+	u := map[interface{}]interface{}{
+		"name":     name,
+		"password": hash,
+	}
+	(*diskConfig)["users"] = append(users, u)
+	return false
+}
+
+// Set the password of the user in configuration file
+// The file is modified directly:  the other settings stay the same
+func resetPassword(name string) error {
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	body, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	diskConfig := map[string]interface{}{}
+	err = yaml.Unmarshal(body, &diskConfig)
+	if err != nil {
+		return fmt.Errorf("couldn't parse config file: %s", err)
+	}
+
+	fn := userPasswordFile(diskConfig, name)
+	if len(fn) != 0 {
+		// the configuration file stays the same
+		err = file.SafeWrite(fn, []byte(hash+"\n"))
+		if err != nil {
+			return fmt.Errorf("couldn't save password file: %s", err)
+		}
+		log.Info("Password of user %s has been changed in %s", name, fn)
+		log.Info("Restart AdGuard Home to apply the changes")
+		return nil
+	}
+
+	found := setUserPassword(&diskConfig, name, hash)
+
+	body, err = yaml.Marshal(diskConfig)
+	if err != nil {
+		return fmt.Errorf("couldn't generate YAML file: %s", err)
+	}
+	err = writeConfigFile(body)
+	if err != nil {
+		return fmt.Errorf("couldn't save YAML config: %s", err)
+	}
+	config.fileData = body
+
+	if found {
+		log.Info("Password of user %s has been changed", name)
+	} else {
+		log.Info("User %s has been added", name)
+	}
+	log.Info("Restart AdGuard Home to apply the changes")
+	return nil
+}
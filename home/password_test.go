@@ -0,0 +1,39 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetUserPassword(t *testing.T) {
+	diskConfig := map[string]interface{}{
+		"users": []interface{}{
+			map[interface{}]interface{}{"name": "admin", "password": "old"},
+		},
+	}
+
+	// existing user
+	assert.True(t, setUserPassword(&diskConfig, "admin", "new"))
+	users := diskConfig["users"].([]interface{})
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, "new", users[0].(map[interface{}]interface{})["password"])
+
+	// new user
+	assert.False(t, setUserPassword(&diskConfig, "user2", "hash2"))
+	users = diskConfig["users"].([]interface{})
+	assert.Equal(t, 2, len(users))
+	assert.Equal(t, "user2", users[1].(map[interface{}]interface{})["name"])
+
+	// no users in configuration
+	diskConfig = map[string]interface{}{}
+	assert.False(t, setUserPassword(&diskConfig, "admin", "hash"))
+	assert.Equal(t, 1, len(diskConfig["users"].([]interface{})))
+
+	_, err := hashPassword("")
+	assert.NotNil(t, err)
+	hash, err := hashPassword("password")
+	assert.Nil(t, err)
+	assert.True(t, isPasswordHash(hash))
+	assert.False(t, isPasswordHash("password"))
+}
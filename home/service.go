@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -24,12 +25,15 @@ const (
 
 // Represents the program that will be launched by a service or daemon
 type program struct {
+	opts options // command-line arguments
 }
 
 // Start should quickly start the program
 func (p *program) Start(s service.Service) error {
 	// Start should not block. Do the actual work async.
-	args := options{runningAsService: true}
+	args := p.opts
+	args.serviceControlAction = ""
+	args.runningAsService = true
 	go run(args)
 	return nil
 }
@@ -38,12 +42,27 @@ func (p *program) Start(s service.Service) error {
 func (p *program) Stop(s service.Service) error {
 	// Stop should not block. Return with a few seconds.
 	if Context.appSignalChannel == nil {
-		os.Exit(0)
+		// running as a service:  the process exits after the service manager is notified
+		cleanup()
+		cleanupAlways()
+		return nil
 	}
 	Context.appSignalChannel <- syscall.SIGINT
 	return nil
 }
 
+// Suspend or resume DNS protection (e.g. when Windows service is paused)
+func suspendProtection(suspend bool) {
+	if Context.dnsServer == nil {
+		return
+	}
+	if suspend {
+		Context.dnsServer.SuspendProtection()
+	} else {
+		Context.dnsServer.ResumeProtection()
+	}
+}
+
 // Check the service's status
 // Note: on OpenWrt 'service' utility may not exist - we use our service script directly in this case.
 func svcStatus(s service.Service) (service.Status, error) {
@@ -115,6 +134,63 @@ func sendSigReload() {
 	log.Debug("Sent signal to PID %d", pid)
 }
 
+// Get the absolute path;  the service is started from another directory
+func absPath(fn string) string {
+	s, err := filepath.Abs(fn)
+	if err != nil {
+		return fn
+	}
+	return s
+}
+
+// Get the command-line arguments of the service process:
+//  the arguments passed along with "-s install" are kept
+func serviceArguments(opts options) []string {
+	args := []string{"-s", "run"}
+	if len(opts.configFilename) != 0 {
+		args = append(args, "-c", absPath(opts.configFilename))
+	}
+	if len(opts.workDir) != 0 {
+		args = append(args, "-w", absPath(opts.workDir))
+	}
+	if len(opts.bindHost) != 0 {
+		args = append(args, "-h", opts.bindHost)
+	}
+	if opts.bindPort != 0 {
+		args = append(args, "-p", strconv.Itoa(opts.bindPort))
+	}
+	if len(opts.logFile) != 0 {
+		fn := opts.logFile
+		if fn != "syslog" {
+			fn = absPath(fn)
+		}
+		args = append(args, "-l", fn)
+	}
+	if len(opts.pidFile) != 0 {
+		args = append(args, "--pidfile", absPath(opts.pidFile))
+	}
+	if opts.disableUpdate {
+		args = append(args, "--no-check-update")
+	}
+	if opts.verbose {
+		args = append(args, "-v")
+	}
+	return args
+}
+
+// Get the working directory of the service process:
+//  the working directory passed with "-w" or the directory of the executable file
+func serviceWorkingDir(opts options) (string, error) {
+	if len(opts.workDir) != 0 {
+		return absPath(opts.workDir), nil
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(execPath), nil
+}
+
 // handleServiceControlAction one of the possible control actions:
 // install -- installs a service/daemon
 // uninstall -- uninstalls it
@@ -125,7 +201,8 @@ func sendSigReload() {
 // run - this is a special command that is not supposed to be used directly
 // it is specified when we register a service, and it indicates to the app
 // that it is being run as a service/daemon.
-func handleServiceControlAction(action string) {
+func handleServiceControlAction(opts options) {
+	action := opts.serviceControlAction
 	log.Printf("Service control action: %s", action)
 
 	if action == "reload" {
@@ -133,19 +210,23 @@ func handleServiceControlAction(action string) {
 		return
 	}
 
-	pwd, err := os.Getwd()
+	if (action == "install" || action == "uninstall") && runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		log.Fatalf("Service %s requires root privileges:  run it with sudo", action)
+	}
+
+	workDir, err := serviceWorkingDir(opts)
 	if err != nil {
-		log.Fatal("Unable to find the path to the current directory")
+		log.Fatalf("Unable to find the working directory: %s", err)
 	}
 	svcConfig := &service.Config{
 		Name:             serviceName,
 		DisplayName:      serviceDisplayName,
 		Description:      serviceDescription,
-		WorkingDirectory: pwd,
-		Arguments:        []string{"-s", "run"},
+		WorkingDirectory: workDir,
+		Arguments:        serviceArguments(opts),
 	}
 	configureService(svcConfig)
-	prg := &program{}
+	prg := &program{opts: opts}
 	s, err := service.New(prg, svcConfig)
 	if err != nil {
 		log.Fatal(err)
@@ -154,7 +235,7 @@ func handleServiceControlAction(action string) {
 	if action == "status" {
 		handleServiceStatusCommand(s)
 	} else if action == "run" {
-		err = s.Run()
+		err = runService(s, prg)
 		if err != nil {
 			log.Fatalf("Failed to run service: %s", err)
 		}
@@ -175,6 +256,10 @@ func handleServiceControlAction(action string) {
 // handleServiceStatusCommand handles service "status" command
 func handleServiceStatusCommand(s service.Service) {
 	status, errSt := svcStatus(s)
+	if errSt == service.ErrNotInstalled {
+		log.Printf("Service is not installed")
+		return
+	}
 	if errSt != nil {
 		log.Fatalf("failed to get service status: %s", errSt)
 	}
@@ -196,6 +281,11 @@ func handleServiceInstallCommand(s service.Service) {
 		log.Fatal(err)
 	}
 
+	err = configureServiceRecovery()
+	if err != nil {
+		log.Printf("Failed to configure the service recovery: %s", err)
+	}
+
 	if util.IsOpenWrt() {
 		// On OpenWrt it is important to run enable after the service installation
 		// Otherwise, the service won't start on the system startup
@@ -232,7 +322,16 @@ func handleServiceUninstallCommand(s service.Service) {
 		}
 	}
 
-	err := svcAction(s, "uninstall")
+	// Stop the service first, otherwise it keeps running after uninstall
+	status, err := svcStatus(s)
+	if err == nil && status == service.StatusRunning {
+		err = svcAction(s, "stop")
+		if err != nil {
+			log.Printf("Failed to stop the service: %s", err)
+		}
+	}
+
+	err = svcAction(s, "uninstall")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -285,6 +384,8 @@ func runInitdCommand(action string) (int, error) {
 
 // Basically the same template as the one defined in github.com/kardianos/service
 // but with two additional keys - StandardOutPath and StandardErrorPath
+// and with ThrottleInterval:  launchd restarts the service not earlier than in 10 seconds after it exits
+// The file is installed to /Library/LaunchDaemons, so the service is started on boot before login.
 var launchdConfig = `<?xml version='1.0' encoding='UTF-8'?>
 <!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN"
 "http://www.apple.com/DTDs/PropertyList-1.0.dtd" >
@@ -304,6 +405,7 @@ var launchdConfig = `<?xml version='1.0' encoding='UTF-8'?>
 <key>SessionCreate</key><{{bool .SessionCreate}}/>
 <key>KeepAlive</key><{{bool .KeepAlive}}/>
 <key>RunAtLoad</key><{{bool .RunAtLoad}}/>
+<key>ThrottleInterval</key><integer>10</integer>
 <key>Disabled</key><false/>
 <key>StandardOutPath</key>
 <string>` + launchdStdoutPath + `</string>
@@ -314,16 +416,19 @@ var launchdConfig = `<?xml version='1.0' encoding='UTF-8'?>
 `
 
 // Note: we should keep it in sync with the template from service_systemd_linux.go file
-// Add "After=" setting for systemd service file, because we must be started only after network is online
+// Add "After=" and "Wants=" settings for systemd service file, because we must be started only after network is online
 // Set "RestartSec" to 10
+// Allow binding to the privileged ports (DNS, HTTP) and using raw sockets (DHCP) when not running as root
 const systemdScript = `[Unit]
 Description={{.Description}}
 ConditionFileIsExecutable={{.Path|cmdEscape}}
 After=syslog.target network-online.target
+Wants=network-online.target
+StartLimitIntervalSec=5
+StartLimitBurst=10
 
 [Service]
-StartLimitInterval=5
-StartLimitBurst=10
+AmbientCapabilities=CAP_NET_BIND_SERVICE CAP_NET_RAW
 ExecStart={{.Path|cmdEscape}}{{range .Arguments}} {{.|cmd}}{{end}}
 {{if .ChRoot}}RootDirectory={{.ChRoot|cmd}}{{end}}
 {{if .WorkingDirectory}}WorkingDirectory={{.WorkingDirectory|cmdEscape}}{{end}}
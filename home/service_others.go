@@ -0,0 +1,17 @@
+// +build !windows
+
+package home
+
+import (
+	"github.com/kardianos/service"
+)
+
+// Run the service
+func runService(s service.Service, prg *program) error {
+	return s.Run()
+}
+
+// The service manager restarts the service if it fails:  it's set in the service file
+func configureServiceRecovery() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package home
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/kardianos/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceArguments(t *testing.T) {
+	assert.Equal(t, []string{"-s", "run"}, serviceArguments(options{serviceControlAction: "install"}))
+
+	opts := options{
+		serviceControlAction: "install",
+		configFilename:       "/etc/AdGuardHome.yaml",
+		workDir:              "data",
+		bindPort:             8080,
+		logFile:              "syslog",
+		disableUpdate:        true,
+	}
+	workDir, _ := filepath.Abs("data")
+	assert.Equal(t, []string{"-s", "run", "-c", "/etc/AdGuardHome.yaml", "-w", workDir, "-p", "8080", "-l", "syslog", "--no-check-update"},
+		serviceArguments(opts))
+
+	dir, err := serviceWorkingDir(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, workDir, dir)
+}
+
+func TestLaunchdConfig(t *testing.T) {
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"bool": func(v bool) string {
+			if v {
+				return "true"
+			}
+			return "false"
+		},
+	}).Parse(launchdConfig)
+	assert.Nil(t, err)
+
+	c := &service.Config{
+		Name:             serviceName,
+		WorkingDirectory: "/Applications/AdGuardHome",
+		Arguments:        serviceArguments(options{configFilename: "/etc/AdGuardHome.yaml"}),
+	}
+	configureService(c)
+	buf := bytes.Buffer{}
+	err = tmpl.Execute(&buf, &struct {
+		*service.Config
+		Path                 string
+		KeepAlive, RunAtLoad bool
+		SessionCreate        bool
+	}{
+		Config:    c,
+		Path:      "/Applications/AdGuardHome/AdGuardHome",
+		KeepAlive: true,
+		RunAtLoad: c.Option["RunAtLoad"].(bool),
+	})
+	assert.Nil(t, err)
+
+	// the plist is well-formed
+	text := buf.String()
+	dec := xml.NewDecoder(strings.NewReader(text))
+	for {
+		_, err = dec.Token()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		if err != nil {
+			break
+		}
+	}
+	assert.True(t, strings.Contains(text, "<key>RunAtLoad</key><true/>"))
+	assert.True(t, strings.Contains(text, "<key>KeepAlive</key><true/>"))
+	assert.True(t, strings.Contains(text, "<string>/etc/AdGuardHome.yaml</string>"))
+	assert.True(t, strings.Contains(text, "<key>WorkingDirectory</key><string>/Applications/AdGuardHome</string>"))
+}
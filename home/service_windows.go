@@ -0,0 +1,91 @@
+package home
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/kardianos/service"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Windows service:
+//  the service is controlled by Service Control Manager (SCM):
+//   stop, shutdown: the services are stopped and the process exits
+//   pause: DNS protection is suspended (DNS queries are still answered);  continue: it's resumed
+//  The log is written to Event Log unless the log file is set (see configureLogger()).
+//  SCM restarts the service if it fails.
+
+// windowsService handles the requests of SCM
+type windowsService struct {
+	prg *program
+}
+
+// Execute is called by SCM when the service is started;  it returns when the service is stopped
+func (ws *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+	changes <- svc.Status{State: svc.StartPending}
+	_ = ws.prg.Start(nil)
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+
+		case svc.Stop, svc.Shutdown:
+			changes <- svc.Status{State: svc.StopPending}
+			_ = ws.prg.Stop(nil)
+			return false, 0
+
+		case svc.Pause:
+			changes <- svc.Status{State: svc.PausePending, Accepts: accepts}
+			suspendProtection(true)
+			changes <- svc.Status{State: svc.Paused, Accepts: accepts}
+
+		case svc.Continue:
+			changes <- svc.Status{State: svc.ContinuePending, Accepts: accepts}
+			suspendProtection(false)
+			changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+		default:
+			log.Debug("Service: unexpected control request: %d", c.Cmd)
+		}
+	}
+	return false, 0
+}
+
+// Run the service:  it's started by SCM or from the console
+func runService(s service.Service, prg *program) error {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		return err
+	}
+	if interactive {
+		return s.Run()
+	}
+	return svc.Run(serviceName, &windowsService{prg: prg})
+}
+
+// Configure SCM to restart the service if it fails
+func configureServiceRecovery() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = m.Disconnect() }()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	actions := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 60 * time.Second},
+	}
+	// the failure count is reset after a day without failures
+	return s.SetRecoveryActions(actions, 24*60*60)
+}
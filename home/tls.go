@@ -1,6 +1,7 @@
 package home
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
@@ -12,8 +13,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
@@ -27,17 +30,41 @@ var tlsWebHandlersRegistered = false
 
 // TLSMod - TLS module object
 type TLSMod struct {
-	certLastMod time.Time // last modification time of the certificate file
+	certLastMod time.Time // last modification time of the certificate or key file
 	conf        tlsConfigSettings
 	confLock    sync.Mutex
 	status      tlsConfigStatus
+	acme        *acmeMod // ACME client (nil if disabled)
+	ocsp        *ocspStapler
+
+	reloadLock sync.Mutex // serializes reloading of the certificate files
+	watch      tlsWatcher // watcher for the certificate files
 }
 
 // Create TLS module
 func tlsCreate(conf tlsConfigSettings) *TLSMod {
 	t := &TLSMod{}
 	t.conf = conf
+	t.ocsp = ocspCreate(Context.client)
+	_, err := getTLSParams(t.conf)
+	if err != nil {
+		log.Error("TLS: %s", err)
+		return nil
+	}
+	if t.conf.ACME.Enabled {
+		t.acme, err = acmeCreate(t.conf.ACME, t.conf.ServerName, filepath.Join(Context.getDataDir(), acmeDir))
+		if err != nil {
+			log.Error("ACME: %s", err)
+			return nil
+		}
+		t.acme.onRenewed = t.Reload
+		t.acme.setPaths(&t.conf)
+	}
 	if t.conf.Enabled {
+		if t.acme != nil && !t.acme.certExists() {
+			// the certificate will be obtained by ACME module
+			return t
+		}
 		if !t.load() {
 			return nil
 		}
@@ -63,6 +90,11 @@ func (t *TLSMod) load() bool {
 
 // Close - close module
 func (t *TLSMod) Close() {
+	t.closeWatcher()
+	t.ocsp.Close()
+	if t.acme != nil {
+		t.acme.Close()
+	}
 }
 
 // WriteDiskConfig - write config
@@ -72,16 +104,35 @@ func (t *TLSMod) WriteDiskConfig(conf *tlsConfigSettings) {
 	t.confLock.Unlock()
 }
 
+// Get the last modification time of the certificate and private key files
+// Symbolic links (e.g. created by certbot) are followed.
+func certFilesModTime(conf tlsConfigSettings) (time.Time, error) {
+	var last time.Time
+	for _, fn := range []string{conf.CertificatePath, conf.PrivateKeyPath, conf.PKCS12Path} {
+		if len(fn) == 0 {
+			continue
+		}
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last.UTC(), nil
+}
+
 func (t *TLSMod) setCertFileTime() {
-	if len(t.conf.CertificatePath) == 0 {
+	if len(t.conf.CertificatePath) == 0 && len(t.conf.PKCS12Path) == 0 {
 		return
 	}
-	fi, err := os.Stat(t.conf.CertificatePath)
+	mod, err := certFilesModTime(t.conf)
 	if err != nil {
 		log.Error("TLS: %s", err)
 		return
 	}
-	t.certLastMod = fi.ModTime().UTC()
+	t.certLastMod = mod
 }
 
 // Start - start the module
@@ -95,39 +146,66 @@ func (t *TLSMod) Start() {
 	tlsConf := t.conf
 	t.confLock.Unlock()
 	Context.web.TLSConfigChanged(tlsConf)
+
+	t.ocsp.Start()
+	if tlsConf.Enabled {
+		t.ocsp.SetCert(tlsConf.CertificateChainData)
+	}
+	t.startWatcher()
+
+	if t.acme != nil {
+		t.acme.Start()
+	}
 }
 
-// Reload - reload certificate file
+// Reload - reload certificate files if they are modified
+// Called on SIGHUP, when the files are changed and when ACME module has renewed the certificate
 func (t *TLSMod) Reload() {
+	tlsConf, ok := t.reload()
+	if ok {
+		Context.web.TLSConfigChanged(tlsConf)
+	}
+}
+
+// Reload certificate files and reconfigure DNS server
+// Return TRUE if the new certificate is loaded:  web server must be reconfigured by the caller
+func (t *TLSMod) reload() (tlsConfigSettings, bool) {
+	t.reloadLock.Lock()
+	defer t.reloadLock.Unlock()
+
 	t.confLock.Lock()
 	tlsConf := t.conf
 	t.confLock.Unlock()
 
-	if !tlsConf.Enabled || len(tlsConf.CertificatePath) == 0 {
-		return
+	if !tlsConf.Enabled || (len(tlsConf.CertificatePath) == 0 && len(tlsConf.PKCS12Path) == 0) {
+		return tlsConf, false
 	}
-	fi, err := os.Stat(tlsConf.CertificatePath)
+	mod, err := certFilesModTime(tlsConf)
 	if err != nil {
 		log.Error("TLS: %s", err)
-		return
+		return tlsConf, false
 	}
-	if fi.ModTime().UTC().Equal(t.certLastMod) {
+	if mod.Equal(t.certLastMod) {
 		log.Debug("TLS: certificate file isn't modified")
-		return
+		return tlsConf, false
 	}
 	log.Debug("TLS: certificate file is modified")
 
 	t.confLock.Lock()
 	r := t.load()
+	tlsConf = t.conf
 	t.confLock.Unlock()
 	if !r {
-		return
+		log.Error("TLS: couldn't reload the certificate")
+		return tlsConf, false
 	}
 
-	t.certLastMod = fi.ModTime().UTC()
+	t.certLastMod = mod
+	log.Info("TLS: reloaded the certificate")
+	t.ocsp.SetCert(tlsConf.CertificateChainData)
 
 	_ = reconfigureDNSServer()
-	Context.web.TLSConfigChanged(tlsConf)
+	return tlsConf, true
 }
 
 // Set certificate and private key data
@@ -136,6 +214,26 @@ func tlsLoadConfig(tls *tlsConfigSettings, status *tlsConfigStatus) bool {
 	tls.PrivateKeyData = []byte(tls.PrivateKey)
 
 	var err error
+	if tls.PKCS12Path != "" {
+		if tls.CertificateChain != "" || tls.CertificatePath != "" || tls.PrivateKey != "" || tls.PrivateKeyPath != "" {
+			status.WarningValidation = "PKCS#12 file can't be used with certificate or private key"
+			return false
+		}
+		pass, err := keyPassphrase(*tls)
+		if err != nil {
+			status.WarningValidation = err.Error()
+			return false
+		}
+		tls.CertificateChainData, tls.PrivateKeyData, err = loadPKCS12(tls.PKCS12Path, pass)
+		if err != nil {
+			status.WarningValidation = err.Error()
+			return false
+		}
+		status.ValidCert = true
+		status.ValidKey = true
+		return true
+	}
+
 	if tls.CertificatePath != "" {
 		if tls.CertificateChain != "" {
 			status.WarningValidation = "certificate data and file can't be set together"
@@ -162,6 +260,19 @@ func tlsLoadConfig(tls *tlsConfigSettings, status *tlsConfigStatus) bool {
 		status.ValidKey = true
 	}
 
+	if isEncryptedKey(tls.PrivateKeyData) {
+		pass, err := keyPassphrase(*tls)
+		if err != nil {
+			status.WarningValidation = err.Error()
+			return false
+		}
+		tls.PrivateKeyData, err = decryptPrivateKey(tls.PrivateKeyData, pass)
+		if err != nil {
+			status.WarningValidation = fmt.Sprintf("Failed to decrypt private key: %s", err)
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -174,6 +285,11 @@ type tlsConfigStatus struct {
 	NotAfter   time.Time `json:"not_after,omitempty"`  // NotAfter is the NotAfter field of the first certificate in the chain
 	DNSNames   []string  `json:"dns_names"`            // DNSNames is the value of SubjectAltNames field of the first certificate in the chain
 
+	// chain status
+	ChainComplete   bool   `json:"chain_complete"`        // ChainComplete is true if the chain contains all intermediate certificates up to a known CA or a self-signed certificate
+	DaysUntilExpiry int    `json:"days_until_expiry"`     // DaysUntilExpiry is the number of days until the first certificate in the chain expires (negative if it has expired)
+	OCSPStatus      string `json:"ocsp_status,omitempty"` // OCSPStatus is one of "good", "revoked", "unknown", "unavailable" (no OCSP server or issuer), "error";  empty: not checked yet
+
 	// key status
 	ValidKey bool   `json:"valid_key"`          // ValidKey is true if the key is a valid private key
 	KeyType  string `json:"key_type,omitempty"` // KeyType is one of RSA or ECDSA
@@ -191,22 +307,49 @@ type tlsConfig struct {
 	tlsConfigStatus   `json:",inline"`
 }
 
-func (t *TLSMod) handleTLSStatus(w http.ResponseWriter, r *http.Request) {
+// Get the current configuration and the status of the certificate
+func (t *TLSMod) getStatus() tlsConfig {
 	t.confLock.Lock()
 	data := tlsConfig{
 		tlsConfigSettings: t.conf,
 		tlsConfigStatus:   t.status,
 	}
 	t.confLock.Unlock()
+
+	if !data.NotAfter.IsZero() {
+		data.DaysUntilExpiry = certDaysLeft(data.NotAfter, time.Now())
+	}
+	if data.Enabled {
+		data.OCSPStatus = t.ocsp.Status()
+	}
+	return data
+}
+
+func (t *TLSMod) handleTLSStatus(w http.ResponseWriter, r *http.Request) {
+	data := t.getStatus()
+	// the private key is shown only to the users who may change the settings
+	if Context.auth != nil && !Context.auth.CanModify(r) {
+		data.PrivateKey = ""
+	}
 	marshalTLS(w, data)
 }
 
+// Use the passphrase of the private key from the current configuration:  it isn't received from UI
+func (t *TLSMod) setKeyPassphrase(conf *tlsConfigSettings) {
+	t.confLock.Lock()
+	conf.KeyPassphrase = t.conf.KeyPassphrase
+	conf.KeyPassphraseEnv = t.conf.KeyPassphraseEnv
+	conf.KeyPassphraseFile = t.conf.KeyPassphraseFile
+	t.confLock.Unlock()
+}
+
 func (t *TLSMod) handleTLSValidate(w http.ResponseWriter, r *http.Request) {
 	setts, err := unmarshalTLS(r)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, "Failed to unmarshal TLS config: %s", err)
 		return
 	}
+	t.setKeyPassphrase(&setts)
 
 	if !WebCheckPortAvailable(setts.PortHTTPS) {
 		httpError(w, http.StatusBadRequest, "port %d is not available, cannot enable HTTPS on it", setts.PortHTTPS)
@@ -231,12 +374,18 @@ func (t *TLSMod) handleTLSConfigure(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusBadRequest, "Failed to unmarshal TLS config: %s", err)
 		return
 	}
+	t.setKeyPassphrase(&data)
 
 	if !WebCheckPortAvailable(data.PortHTTPS) {
 		httpError(w, http.StatusBadRequest, "port %d is not available, cannot enable HTTPS on it", data.PortHTTPS)
 		return
 	}
 
+	if t.acme != nil {
+		// the certificate is managed by ACME module
+		t.acme.setPaths(&data)
+	}
+
 	status := tlsConfigStatus{}
 	if !tlsLoadConfig(&data, &status) {
 		data2 := tlsConfig{
@@ -259,15 +408,29 @@ func (t *TLSMod) handleTLSConfigure(w http.ResponseWriter, r *http.Request) {
 	t.conf.ForceHTTPS = data.ForceHTTPS
 	t.conf.PortHTTPS = data.PortHTTPS
 	t.conf.PortDNSOverTLS = data.PortDNSOverTLS
+	t.conf.DisableDOH = data.DisableDOH
+	t.conf.HSTS = data.HSTS
+	t.conf.EDNSPadding = data.EDNSPadding
+	t.conf.MinVersion = data.MinVersion
+	t.conf.MaxVersion = data.MaxVersion
+	t.conf.CipherSuites = data.CipherSuites
+	t.conf.ClientCAPath = data.ClientCAPath
 	t.conf.CertificateChain = data.CertificateChain
 	t.conf.CertificatePath = data.CertificatePath
 	t.conf.CertificateChainData = data.CertificateChainData
 	t.conf.PrivateKey = data.PrivateKey
 	t.conf.PrivateKeyPath = data.PrivateKeyPath
+	t.conf.PKCS12Path = data.PKCS12Path
 	t.conf.PrivateKeyData = data.PrivateKeyData
 	t.status = status
 	t.confLock.Unlock()
 	t.setCertFileTime()
+	t.updateWatchedDirs()
+	if data.Enabled {
+		t.ocsp.SetCert(data.CertificateChainData)
+	} else {
+		t.ocsp.SetCert(nil)
+	}
 	onConfigModified()
 	err = reconfigureDNSServer()
 	if err != nil {
@@ -361,6 +524,7 @@ func verifyCertChain(data *tlsConfigStatus, certChain string, serverName string)
 		data.ValidChain = true
 	}
 	// spew.Dump(chains)
+	data.ChainComplete = chainComplete(parsedCerts, Context.tlsRoots)
 
 	// update status
 	if mainCert != nil {
@@ -370,11 +534,43 @@ func verifyCertChain(data *tlsConfigStatus, certChain string, serverName string)
 		data.NotAfter = notAfter
 		data.NotBefore = mainCert.NotBefore
 		data.DNSNames = mainCert.DNSNames
+		data.DaysUntilExpiry = certDaysLeft(notAfter, time.Now())
 	}
 
 	return nil
 }
 
+// Return TRUE if the chain contains all intermediate certificates,
+// i.e. each certificate is signed by the next one and the last one is self-signed or issued by a root CA.
+// Only the issuers are checked here, not the validity period or the server name.
+func chainComplete(certs []*x509.Certificate, roots *x509.CertPool) bool {
+	for i := 0; i+1 < len(certs); i++ {
+		if certs[i].CheckSignatureFrom(certs[i+1]) != nil {
+			return false
+		}
+	}
+
+	last := certs[len(certs)-1]
+	if bytes.Equal(last.RawIssuer, last.RawSubject) &&
+		last.CheckSignature(last.SignatureAlgorithm, last.RawTBSCertificate, last.Signature) == nil {
+		return true
+	}
+
+	// check the time in the middle of the validity period, so that an expired certificate doesn't fail the check
+	opts := x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: last.NotBefore.Add(last.NotAfter.Sub(last.NotBefore) / 2),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	_, err := last.Verify(opts)
+	return err == nil
+}
+
+// Get the number of days left until the certificate expires (negative if it has expired)
+func certDaysLeft(notAfter time.Time, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
 func validatePkey(data *tlsConfigStatus, pkey string) error {
 	// now do a more extended validation
 	var key *pem.Block        // PEM-encoded certificates
@@ -506,6 +702,11 @@ func unmarshalTLS(r *http.Request) (tlsConfigSettings, error) {
 		}
 	}
 
+	_, err = getTLSParams(data)
+	if err != nil {
+		return data, err
+	}
+
 	return data, nil
 }
 
@@ -529,9 +730,27 @@ func marshalTLS(w http.ResponseWriter, data tlsConfig) {
 	}
 }
 
+// Reload the certificate files now, without waiting for the notification about file change
+func (t *TLSMod) handleTLSReload(w http.ResponseWriter, r *http.Request) {
+	tlsConf, reloaded := t.reload()
+
+	marshalTLS(w, t.getStatus())
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// restart HTTPS server in a goroutine:  we're inside a request and Shutdown() would block
+	if reloaded {
+		go func() {
+			Context.web.TLSConfigChanged(tlsConf)
+		}()
+	}
+}
+
 // registerWebHandlers registers HTTP handlers for TLS configuration
 func (t *TLSMod) registerWebHandlers() {
 	httpRegister("GET", "/control/tls/status", t.handleTLSStatus)
 	httpRegister("POST", "/control/tls/configure", t.handleTLSConfigure)
 	httpRegister("POST", "/control/tls/validate", t.handleTLSValidate)
+	httpRegister("POST", "/control/tls/reload", t.handleTLSReload)
 }
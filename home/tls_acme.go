@@ -0,0 +1,376 @@
+package home
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/crypto/acme"
+)
+
+// Automatic certificates (ACME, RFC 8555), e.g. from Let's Encrypt:
+//  If tls.acme.enabled is true, the certificate for tls.server_name is obtained from ACME server
+//  and it's renewed 30 days before it expires.
+//  The account key, the certificate and its private key are stored in "data/acme/" directory,
+//  certificate_path and private_key_path point to these files.
+// Challenges:
+//  http-01: the token is served on /.well-known/acme-challenge/ by the web server;
+//   if the web server doesn't listen on port 80, a temporary server is started on port 80
+//  dns-01: TXT record "_acme-challenge.<server_name>" is served by the built-in DNS server
+//   (the domain must be delegated to AdGuard Home)
+
+const (
+	acmeDir           = "acme" // the directory for the account key and certificates (under data directory)
+	acmeChallengeHTTP = "http-01"
+	acmeChallengeDNS  = "dns-01"
+	acmeHTTPPath      = "/.well-known/acme-challenge/"
+	acmeRenewBefore   = 30 * 24 * time.Hour
+	acmeCheckInterval = 12 * time.Hour
+	acmeRetryInterval = 1 * time.Hour
+	acmeTimeout       = 5 * time.Minute
+)
+
+type acmeConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Email        string `yaml:"email"`         // contact e-mail of the account
+	DirectoryURL string `yaml:"directory_url"` // empty: Let's Encrypt
+	Challenge    string `yaml:"challenge"`     // "http-01" (default) or "dns-01"
+}
+
+// acmeMod - ACME client module
+type acmeMod struct {
+	conf   acmeConfig
+	domain string
+	dir    string
+
+	lock       sync.Mutex
+	httpTokens map[string]string // token -> key authorization (http-01)
+
+	onRenewed func() // called after the new certificate is saved
+	stop      chan bool
+}
+
+// Create ACME module
+func acmeCreate(conf acmeConfig, domain string, dir string) (*acmeMod, error) {
+	if len(domain) == 0 {
+		return nil, fmt.Errorf("server_name is required")
+	}
+	switch conf.Challenge {
+	case "":
+		conf.Challenge = acmeChallengeHTTP
+	case acmeChallengeHTTP, acmeChallengeDNS:
+		//
+	default:
+		return nil, fmt.Errorf("unsupported challenge: %s", conf.Challenge)
+	}
+
+	m := &acmeMod{
+		conf:       conf,
+		domain:     strings.ToLower(domain),
+		dir:        dir,
+		httpTokens: map[string]string{},
+		stop:       make(chan bool),
+	}
+	return m, nil
+}
+
+// Get the paths of the certificate and private key files
+func (m *acmeMod) certPaths() (string, string) {
+	return filepath.Join(m.dir, m.domain+".crt"), filepath.Join(m.dir, m.domain+".key")
+}
+
+// Use the certificate files managed by ACME module
+func (m *acmeMod) setPaths(conf *tlsConfigSettings) {
+	conf.CertificateChain = ""
+	conf.PrivateKey = ""
+	conf.PKCS12Path = ""
+	conf.CertificatePath, conf.PrivateKeyPath = m.certPaths()
+}
+
+// Return TRUE if the certificate has been obtained
+func (m *acmeMod) certExists() bool {
+	certFile, _ := m.certPaths()
+	_, err := os.Stat(certFile)
+	return err == nil
+}
+
+// Return TRUE if the certificate doesn't exist, is issued for another domain or expires soon
+func (m *acmeMod) needRenew(now time.Time) bool {
+	certFile, _ := m.certPaths()
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return true
+	}
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return true
+	}
+	if cert.VerifyHostname(m.domain) != nil {
+		return true
+	}
+	return now.Add(acmeRenewBefore).After(cert.NotAfter)
+}
+
+// Write the file atomically
+func writeFileAtomic(fn string, data []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := fn + ".tmp"
+	err = ioutil.WriteFile(tmp, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// Save ECDSA private key to PEM file which is readable only by the owner
+func writeKeyFile(fn string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return writeFileAtomic(fn, data, 0600)
+}
+
+// Load the account key or generate a new one
+func (m *acmeMod) accountKey() (crypto.Signer, error) {
+	fn := filepath.Join(m.dir, "account.key")
+	data, err := ioutil.ReadFile(fn)
+	if err == nil {
+		b, _ := pem.Decode(data)
+		if b == nil {
+			return nil, fmt.Errorf("%s: invalid PEM data", fn)
+		}
+		return x509.ParseECPrivateKey(b.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = writeKeyFile(fn, key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ServeHTTP - respond to http-01 challenge request:  GET /.well-known/acme-challenge/<token>
+func (m *acmeMod) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, acmeHTTPPath)
+	m.lock.Lock()
+	resp, ok := m.httpTokens[token]
+	m.lock.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(resp))
+}
+
+// Start a temporary HTTP server on port 80 for http-01 challenge
+func (m *acmeMod) startHTTPServer() (*http.Server, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(config.BindHost, "80"))
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: m}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	return srv, nil
+}
+
+// Fulfil the challenge for the authorization
+func (m *acmeMod) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == m.conf.Challenge {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s challenge isn't offered by ACME server", m.conf.Challenge)
+	}
+
+	if chal.Type == acmeChallengeDNS {
+		if Context.dnsServer == nil {
+			return fmt.Errorf("DNS server isn't running")
+		}
+		rec, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		name := "_acme-challenge." + m.domain
+		Context.dnsServer.SetTXT(name, []string{rec})
+		defer Context.dnsServer.SetTXT(name, nil)
+
+	} else {
+		resp, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		m.lock.Lock()
+		m.httpTokens[chal.Token] = resp
+		m.lock.Unlock()
+		defer func() {
+			m.lock.Lock()
+			delete(m.httpTokens, chal.Token)
+			m.lock.Unlock()
+		}()
+
+		if config.BindPort != 80 {
+			srv, err := m.startHTTPServer()
+			if err != nil {
+				return fmt.Errorf("couldn't start HTTP server on port 80: %s", err)
+			}
+			defer func() {
+				_ = srv.Shutdown(context.Background())
+			}()
+		}
+	}
+
+	_, err = client.Accept(ctx, chal)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// Obtain a new certificate and save it
+func (m *acmeMod) obtain(ctx context.Context) error {
+	key, err := m.accountKey()
+	if err != nil {
+		return fmt.Errorf("account key: %s", err)
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: m.conf.DirectoryURL,
+	}
+	if len(client.DirectoryURL) == 0 {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+
+	acct := &acme.Account{}
+	if len(m.conf.Email) != 0 {
+		acct.Contact = []string{"mailto:" + m.conf.Email}
+	}
+	_, err = client.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("register: %s", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.domain))
+	if err != nil {
+		return fmt.Errorf("order: %s", err)
+	}
+	for _, u := range order.AuthzURLs {
+		err = m.authorize(ctx, client, u)
+		if err != nil {
+			return fmt.Errorf("authorization: %s", err)
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("order: %s", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{m.domain}}, certKey)
+	if err != nil {
+		return err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("certificate: %s", err)
+	}
+	certData := []byte{}
+	for _, c := range der {
+		certData = append(certData, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+
+	certFile, keyFile := m.certPaths()
+	err = writeKeyFile(keyFile, certKey)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(certFile, certData, 0644)
+	if err != nil {
+		return err
+	}
+	log.Info("ACME: obtained certificate for %s", m.domain)
+	return nil
+}
+
+// Start - start the module:  check the certificate periodically and renew it when necessary
+func (m *acmeMod) Start() {
+	go m.run()
+}
+
+func (m *acmeMod) run() {
+	for {
+		wait := acmeCheckInterval
+		if m.needRenew(time.Now()) {
+			log.Info("ACME: requesting certificate for %s", m.domain)
+			ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+			err := m.obtain(ctx)
+			cancel()
+			if err != nil {
+				log.Error("ACME: %s", err)
+				wait = acmeRetryInterval
+			} else if m.onRenewed != nil {
+				m.onRenewed()
+			}
+		}
+
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(wait):
+			//
+		}
+	}
+}
+
+// Close - stop the module
+func (m *acmeMod) Close() {
+	close(m.stop)
+}
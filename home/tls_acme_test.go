@@ -0,0 +1,92 @@
+package home
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Save a self-signed certificate
+func writeTestCert(t *testing.T, fn string, domain string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	err = writeFileAtomic(fn, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	assert.Nil(t, err)
+}
+
+func TestACMENeedRenew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-acme")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	_, err = acmeCreate(acmeConfig{}, "", dir)
+	assert.NotNil(t, err)
+	_, err = acmeCreate(acmeConfig{Challenge: "tls-alpn-01"}, "example.org", dir)
+	assert.NotNil(t, err)
+
+	m, err := acmeCreate(acmeConfig{}, "Example.org", dir)
+	assert.Nil(t, err)
+	assert.Equal(t, acmeChallengeHTTP, m.conf.Challenge)
+	certFile, _ := m.certPaths()
+
+	now := time.Now()
+	assert.False(t, m.certExists())
+	assert.True(t, m.needRenew(now))
+
+	writeTestCert(t, certFile, "example.org", now.Add(60*24*time.Hour))
+	assert.True(t, m.certExists())
+	assert.False(t, m.needRenew(now))
+
+	// expires soon
+	writeTestCert(t, certFile, "example.org", now.Add(10*24*time.Hour))
+	assert.True(t, m.needRenew(now))
+
+	// another domain
+	writeTestCert(t, certFile, "example.com", now.Add(60*24*time.Hour))
+	assert.True(t, m.needRenew(now))
+
+	// the account key is generated once
+	key, err := m.accountKey()
+	assert.Nil(t, err)
+	key2, err := m.accountKey()
+	assert.Nil(t, err)
+	assert.Equal(t, key.Public(), key2.Public())
+}
+
+func TestACMEHTTPChallenge(t *testing.T) {
+	m, err := acmeCreate(acmeConfig{}, "example.org", "")
+	assert.Nil(t, err)
+	m.httpTokens["token"] = "token.thumbprint"
+
+	w := testResponseWriter{hdr: make(http.Header)}
+	r := http.Request{Method: http.MethodGet, URL: &url.URL{Path: acmeHTTPPath + "token"}}
+	m.ServeHTTP(&w, &r)
+	assert.Equal(t, 0, w.statusCode)
+	assert.Equal(t, "text/plain", w.hdr.Get("Content-Type"))
+
+	w = testResponseWriter{hdr: make(http.Header)}
+	r = http.Request{Method: http.MethodGet, URL: &url.URL{Path: acmeHTTPPath + "unknown"}}
+	m.ServeHTTP(&w, &r)
+	assert.Equal(t, http.StatusNotFound, w.statusCode)
+}
@@ -0,0 +1,257 @@
+package home
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSP stapling:
+//  the status of the certificate is requested from the OCSP server of the issuer (the URL is taken from the certificate)
+//  and the response is sent to the clients in TLS handshake (HTTPS, DNS-over-HTTPS, DNS-over-TLS),
+//  so that they don't have to request it themselves.
+// The response is refreshed in the middle of its validity period.
+// Only "good" responses are stapled.
+// The issuer's certificate must be in the certificate chain.
+
+const (
+	ocspStatusGood        = "good"
+	ocspStatusRevoked     = "revoked"
+	ocspStatusUnknown     = "unknown"     // OCSP server doesn't know about the certificate
+	ocspStatusUnavailable = "unavailable" // the certificate doesn't have OCSP server or its issuer isn't in the chain
+	ocspStatusError       = "error"       // couldn't get the response
+
+	ocspRefreshInterval = 24 * time.Hour // if the response doesn't have NextUpdate field
+	ocspRetryInterval   = 1 * time.Hour
+	ocspMaxRespSize     = 64 * 1024
+)
+
+// ocspStapler - OCSP stapling module
+type ocspStapler struct {
+	lock       sync.Mutex
+	leaf       *x509.Certificate
+	issuer     *x509.Certificate
+	resp       []byte    // the response to staple
+	nextUpdate time.Time // the response is valid until this time
+	status     string
+
+	client  *http.Client
+	trigger chan bool // the certificate has been changed
+	stop    chan bool
+}
+
+// Create OCSP stapling module
+func ocspCreate(client *http.Client) *ocspStapler {
+	return &ocspStapler{
+		client:  client,
+		trigger: make(chan bool, 1),
+		stop:    make(chan bool),
+	}
+}
+
+// Parse the certificates from PEM data
+func parseCertChain(data []byte) []*x509.Certificate {
+	certs := []*x509.Certificate{}
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, c)
+	}
+	return certs
+}
+
+// SetCert - set the certificate chain (PEM) which is used now;  empty: TLS is disabled
+func (o *ocspStapler) SetCert(chainData []byte) {
+	certs := parseCertChain(chainData)
+
+	o.lock.Lock()
+	o.leaf = nil
+	o.issuer = nil
+	if len(certs) != 0 {
+		o.leaf = certs[0]
+	}
+	if len(certs) > 1 && certs[0].CheckSignatureFrom(certs[1]) == nil {
+		o.issuer = certs[1]
+	}
+	o.resp = nil
+	o.nextUpdate = time.Time{}
+	o.status = ""
+	o.lock.Unlock()
+
+	select {
+	case o.trigger <- true:
+	default:
+	}
+}
+
+// Status - get OCSP status of the certificate;  empty: not checked yet
+func (o *ocspStapler) Status() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.status
+}
+
+// Staple - get the response to staple for the certificate (DER);  nil: no valid response
+func (o *ocspStapler) Staple(cert []byte) []byte {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.resp == nil || o.leaf == nil || !bytes.Equal(o.leaf.Raw, cert) ||
+		(!o.nextUpdate.IsZero() && time.Now().After(o.nextUpdate)) {
+		return nil
+	}
+	return o.resp
+}
+
+// Get the certificate with the current OCSP response stapled
+func stapleOCSP(cert tls.Certificate) *tls.Certificate {
+	if Context.tls != nil && len(cert.Certificate) != 0 {
+		cert.OCSPStaple = Context.tls.ocsp.Staple(cert.Certificate[0])
+	}
+	return &cert
+}
+
+// Request the status of the certificate from OCSP server
+func ocspFetch(client *http.Client, leaf, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("the certificate doesn't have OCSP server")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: status code %d", leaf.OCSPServer[0], resp.StatusCode)
+	}
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, ocspMaxRespSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, raw, nil
+}
+
+// Get the time when the response should be refreshed:  in the middle of its validity period
+func ocspRefreshTime(r *ocsp.Response, now time.Time) time.Time {
+	if r.NextUpdate.IsZero() {
+		return now.Add(ocspRefreshInterval)
+	}
+	t := r.ThisUpdate.Add(r.NextUpdate.Sub(r.ThisUpdate) / 2)
+	if t.Before(now.Add(ocspRetryInterval)) {
+		t = now.Add(ocspRetryInterval)
+	}
+	return t
+}
+
+// Update OCSP response
+// Return the time to wait until the next update;  0: no updates are necessary
+func (o *ocspStapler) update() time.Duration {
+	o.lock.Lock()
+	leaf := o.leaf
+	issuer := o.issuer
+	o.lock.Unlock()
+
+	if leaf == nil {
+		return 0
+	}
+	if issuer == nil || len(leaf.OCSPServer) == 0 {
+		log.Debug("TLS: OCSP: the certificate doesn't have OCSP server or its issuer isn't in the chain")
+		o.setStatus(leaf, ocspStatusUnavailable, nil, time.Time{})
+		return 0
+	}
+
+	r, raw, err := ocspFetch(o.client, leaf, issuer)
+	if err != nil {
+		log.Error("TLS: OCSP: %s", err)
+		o.setStatus(leaf, ocspStatusError, nil, time.Time{})
+		return ocspRetryInterval
+	}
+
+	now := time.Now()
+	switch r.Status {
+	case ocsp.Good:
+		log.Debug("TLS: OCSP: the certificate is good, the response is valid until %s", r.NextUpdate)
+		o.setStatus(leaf, ocspStatusGood, raw, r.NextUpdate)
+	case ocsp.Revoked:
+		log.Error("TLS: OCSP: the certificate has been revoked at %s", r.RevokedAt)
+		o.setStatus(leaf, ocspStatusRevoked, nil, time.Time{})
+	default:
+		log.Info("TLS: OCSP: the status of the certificate is unknown")
+		o.setStatus(leaf, ocspStatusUnknown, nil, time.Time{})
+	}
+	return ocspRefreshTime(r, now).Sub(now)
+}
+
+// Set the result of the update if the certificate hasn't been changed meanwhile
+func (o *ocspStapler) setStatus(leaf *x509.Certificate, status string, resp []byte, nextUpdate time.Time) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.leaf != leaf {
+		return
+	}
+	o.status = status
+	if resp != nil || status != ocspStatusError {
+		// on error we still use the previous response while it's valid
+		o.resp = resp
+		o.nextUpdate = nextUpdate
+	}
+}
+
+// Start - start the module
+func (o *ocspStapler) Start() {
+	go o.run()
+}
+
+// Update the response when the certificate is changed and when it's time to refresh it
+func (o *ocspStapler) run() {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-o.trigger:
+			//
+		case <-timer:
+			//
+		}
+
+		timer = nil
+		wait := o.update()
+		if wait != 0 {
+			timer = time.After(wait)
+		}
+	}
+}
+
+// Close - stop the module
+func (o *ocspStapler) Close() {
+	close(o.stop)
+}
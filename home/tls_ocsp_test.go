@@ -0,0 +1,134 @@
+package home
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Create a certificate signed by the parent (self-signed if parent is nil)
+func newTestCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ocspURL string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(90 * 24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.DNSNames = []string{cn}
+	}
+	if len(ocspURL) != 0 {
+		tmpl.OCSPServer = []string{ocspURL}
+	}
+	if parent == nil {
+		parent = tmpl
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func certsPEM(certs ...*x509.Certificate) []byte {
+	data := []byte{}
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return data
+}
+
+func TestChainComplete(t *testing.T) {
+	root, rootKey := newTestCert(t, "Test Root", true, nil, nil, "")
+	inter, interKey := newTestCert(t, "Test Intermediate", true, root, rootKey, "")
+	leaf, _ := newTestCert(t, "example.org", false, inter, interKey, "")
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	assert.True(t, chainComplete([]*x509.Certificate{leaf, inter}, roots))
+	assert.True(t, chainComplete([]*x509.Certificate{leaf, inter, root}, roots))
+	assert.True(t, chainComplete([]*x509.Certificate{root}, nil))
+
+	// the intermediate is missing
+	assert.False(t, chainComplete([]*x509.Certificate{leaf}, roots))
+	// wrong order
+	assert.False(t, chainComplete([]*x509.Certificate{inter, leaf}, roots))
+	// unknown root CA
+	assert.False(t, chainComplete([]*x509.Certificate{leaf, inter}, x509.NewCertPool()))
+
+	now := time.Now()
+	assert.Equal(t, 10, certDaysLeft(now.Add(10*24*time.Hour+time.Minute), now))
+	assert.Equal(t, 0, certDaysLeft(now.Add(time.Hour), now))
+	assert.Equal(t, -1, certDaysLeft(now.Add(-time.Hour), now))
+}
+
+func TestOCSPStapler(t *testing.T) {
+	status := ocsp.Good
+	var issuer *x509.Certificate
+	var issuerKey *ecdsa.PrivateKey
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		assert.Nil(t, err)
+		now := time.Now()
+		tmpl := ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now.Add(-time.Hour),
+			NextUpdate:   now.Add(47 * time.Hour),
+			RevokedAt:    now.Add(-time.Hour),
+		}
+		resp, err := ocsp.CreateResponse(issuer, issuer, tmpl, issuerKey)
+		assert.Nil(t, err)
+		_, _ = w.Write(resp)
+	}))
+	defer srv.Close()
+
+	issuer, issuerKey = newTestCert(t, "Test CA", true, nil, nil, "")
+	leaf, _ := newTestCert(t, "example.org", false, issuer, issuerKey, srv.URL)
+
+	o := ocspCreate(srv.Client())
+
+	// the issuer isn't in the chain
+	o.SetCert(certsPEM(leaf))
+	assert.Equal(t, time.Duration(0), o.update())
+	assert.Equal(t, ocspStatusUnavailable, o.Status())
+	assert.Nil(t, o.Staple(leaf.Raw))
+
+	o.SetCert(certsPEM(leaf, issuer))
+	assert.Equal(t, "", o.Status())
+	wait := o.update()
+	assert.True(t, wait > 22*time.Hour && wait <= 23*time.Hour)
+	assert.Equal(t, ocspStatusGood, o.Status())
+	assert.NotNil(t, o.Staple(leaf.Raw))
+	assert.Nil(t, o.Staple(issuer.Raw))
+
+	status = ocsp.Revoked
+	_ = o.update()
+	assert.Equal(t, ocspStatusRevoked, o.Status())
+	assert.Nil(t, o.Staple(leaf.Raw))
+
+	// TLS is disabled
+	o.SetCert(nil)
+	assert.Equal(t, time.Duration(0), o.update())
+	assert.Equal(t, "", o.Status())
+}
@@ -0,0 +1,116 @@
+package home
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLS versions and cipher suites of HTTPS, DNS-over-HTTPS and DNS-over-TLS servers:
+//  min_version: "1.0", "1.1", "1.2", "1.3";  empty: "1.2"
+//  max_version: empty: the highest supported version
+//  cipher_suites: the names of the cipher suites (e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256");
+//   empty: the default list.  TLS 1.3 cipher suites aren't configurable.
+// Client certificate authentication (mutual TLS):
+//  client_ca_path: PEM file with CA certificates.
+//   DNS-over-TLS server requires a client certificate signed by one of these CAs.
+//   HTTPS server requests a client certificate, but doesn't require it:
+//   DNS-over-HTTPS requests without a valid certificate are rejected, the web interface works as usual.
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsParams - TLS parameters of the servers
+type tlsParams struct {
+	minVersion uint16
+	maxVersion uint16         // 0: the highest supported
+	ciphers    []uint16       // nil: the default list of crypto/tls package
+	clientCAs  *x509.CertPool // nil: client certificates aren't required
+}
+
+// Parse TLS version string
+func parseTLSVersion(s string, def uint16) (uint16, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version: %s", s)
+	}
+	return v, nil
+}
+
+// Get the IDs of the cipher suites by their names
+func parseCipherSuites(names []string) ([]uint16, error) {
+	all := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		all[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		all[cs.Name] = cs.ID
+	}
+
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Load CA certificates for client certificate authentication
+func loadClientCAs(fn string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("client_ca_path: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client_ca_path: %s: no valid certificates", fn)
+	}
+	return pool, nil
+}
+
+// Get TLS parameters from the configuration
+func getTLSParams(conf tlsConfigSettings) (tlsParams, error) {
+	p := tlsParams{}
+	var err error
+	p.minVersion, err = parseTLSVersion(conf.MinVersion, tls.VersionTLS12)
+	if err != nil {
+		return p, err
+	}
+	p.maxVersion, err = parseTLSVersion(conf.MaxVersion, 0)
+	if err != nil {
+		return p, err
+	}
+	if p.maxVersion != 0 && p.maxVersion < p.minVersion {
+		return p, fmt.Errorf("max_version %s is lower than min_version", conf.MaxVersion)
+	}
+
+	if len(conf.CipherSuites) != 0 {
+		p.ciphers, err = parseCipherSuites(conf.CipherSuites)
+		if err != nil {
+			return p, err
+		}
+	} else if p.minVersion >= tls.VersionTLS12 {
+		p.ciphers = Context.tlsCiphers
+	}
+	// else: the default list has the cipher suites for TLS 1.0 and 1.1 clients
+
+	if len(conf.ClientCAPath) != 0 {
+		p.clientCAs, err = loadClientCAs(conf.ClientCAPath)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
@@ -0,0 +1,56 @@
+package home
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTLSParams(t *testing.T) {
+	p, err := getTLSParams(tlsConfigSettings{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), p.minVersion)
+	assert.Equal(t, uint16(0), p.maxVersion)
+
+	p, err = getTLSParams(tlsConfigSettings{MinVersion: "1.0", MaxVersion: "1.2"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS10), p.minVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), p.maxVersion)
+	assert.Nil(t, p.ciphers)
+
+	p, err = getTLSParams(tlsConfigSettings{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}, p.ciphers)
+
+	_, err = getTLSParams(tlsConfigSettings{MinVersion: "1.4"})
+	assert.NotNil(t, err)
+	_, err = getTLSParams(tlsConfigSettings{MinVersion: "1.3", MaxVersion: "1.2"})
+	assert.NotNil(t, err)
+	_, err = getTLSParams(tlsConfigSettings{CipherSuites: []string{"TLS_UNKNOWN"}})
+	assert.NotNil(t, err)
+
+	_, err = getTLSParams(tlsConfigSettings{ClientCAPath: "/nonexistent/ca.crt"})
+	assert.NotNil(t, err)
+}
+
+func TestGetTLSParamsClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-tls")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	fn := filepath.Join(dir, "ca.crt")
+	writeTestCert(t, fn, "Test CA", time.Now().Add(time.Hour))
+	p, err := getTLSParams(tlsConfigSettings{ClientCAPath: fn})
+	assert.Nil(t, err)
+	assert.NotNil(t, p.clientCAs)
+
+	// not a certificate
+	_ = ioutil.WriteFile(fn, []byte("data"), 0644)
+	_, err = getTLSParams(tlsConfigSettings{ClientCAPath: fn})
+	assert.NotNil(t, err)
+}
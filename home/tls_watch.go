@@ -0,0 +1,134 @@
+package home
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// When certificate_path and private_key_path are used, the files are watched for changes
+//  so that the certificate renewed by an external tool (e.g. certbot) is applied without restart.
+// We watch the directories, not the files:
+//  certbot replaces the symbolic links and the watcher for the old file would stop working.
+
+// Wait for this time after the last change before reloading the files
+// (the certificate and its key are written one after another)
+const tlsWatchDelay = 1 * time.Second
+
+// tlsWatcher - watches the certificate files
+type tlsWatcher struct {
+	lock    sync.Mutex
+	watcher *fsnotify.Watcher
+	dirs    map[string]bool // the directories we watch
+}
+
+// Get the directories of the certificate files
+func certFilesDirs(conf tlsConfigSettings) map[string]bool {
+	dirs := map[string]bool{}
+	for _, fn := range []string{conf.CertificatePath, conf.PrivateKeyPath, conf.PKCS12Path} {
+		if len(fn) != 0 {
+			dirs[filepath.Dir(filepath.Clean(fn))] = true
+		}
+	}
+	return dirs
+}
+
+// Start watching the certificate files
+func (t *TLSMod) startWatcher() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("TLS: fsnotify.NewWatcher(): %s", err)
+		return
+	}
+
+	t.watch.lock.Lock()
+	t.watch.watcher = w
+	t.watch.dirs = map[string]bool{}
+	t.watch.lock.Unlock()
+
+	go t.watcherLoop(w)
+	t.updateWatchedDirs()
+}
+
+// Stop watching the certificate files
+func (t *TLSMod) closeWatcher() {
+	t.watch.lock.Lock()
+	defer t.watch.lock.Unlock()
+	if t.watch.watcher == nil {
+		return
+	}
+	_ = t.watch.watcher.Close()
+	t.watch.watcher = nil
+	t.watch.dirs = nil
+}
+
+// Update the list of the watched directories after TLS settings have been changed
+func (t *TLSMod) updateWatchedDirs() {
+	t.confLock.Lock()
+	dirs := map[string]bool{}
+	if t.conf.Enabled {
+		dirs = certFilesDirs(t.conf)
+	}
+	t.confLock.Unlock()
+
+	t.watch.lock.Lock()
+	defer t.watch.lock.Unlock()
+	if t.watch.watcher == nil {
+		return
+	}
+
+	for dir := range t.watch.dirs {
+		if dirs[dir] {
+			continue
+		}
+		_ = t.watch.watcher.Remove(dir)
+		delete(t.watch.dirs, dir)
+		log.Debug("TLS: stopped watching directory %s", dir)
+	}
+
+	for dir := range dirs {
+		if t.watch.dirs[dir] {
+			continue
+		}
+		err := t.watch.watcher.Add(dir)
+		if err != nil {
+			log.Error("TLS: error while initializing watcher for a directory %s: %s", dir, err)
+			continue
+		}
+		t.watch.dirs[dir] = true
+		log.Debug("TLS: watching directory %s", dir)
+	}
+}
+
+// Receive notifications from fsnotify package and reload the certificate files
+func (t *TLSMod) watcherLoop(w *fsnotify.Watcher) {
+	timer := time.NewTimer(tlsWatchDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			timer.Reset(tlsWatchDelay)
+
+		case <-timer.C:
+			// Reload() checks the modification time of the files:  other changes in the directory are ignored
+			t.Reload()
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Error("TLS: %s", err)
+		}
+	}
+}
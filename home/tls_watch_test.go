@@ -0,0 +1,48 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsforward"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertFilesModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-tls")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	conf := tlsConfigSettings{
+		TLSConfig: dnsforward.TLSConfig{
+			CertificatePath: filepath.Join(dir, "cert.pem"),
+			PrivateKeyPath:  filepath.Join(dir, "keys", "key.pem"),
+		},
+	}
+	dirs := certFilesDirs(conf)
+	assert.Equal(t, 2, len(dirs))
+	assert.True(t, dirs[dir])
+	assert.True(t, dirs[filepath.Join(dir, "keys")])
+
+	_, err = certFilesModTime(conf)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, writeFileAtomic(conf.CertificatePath, []byte("cert"), 0644))
+	assert.Nil(t, writeFileAtomic(conf.PrivateKeyPath, []byte("key"), 0600))
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, os.Chtimes(conf.CertificatePath, t1, t1))
+	assert.Nil(t, os.Chtimes(conf.PrivateKeyPath, t1, t1))
+	mod, err := certFilesModTime(conf)
+	assert.Nil(t, err)
+	assert.True(t, mod.Equal(t1))
+
+	// only the key is replaced
+	assert.Nil(t, os.Chtimes(conf.PrivateKeyPath, t2, t2))
+	mod, err = certFilesModTime(conf)
+	assert.Nil(t, err)
+	assert.True(t, mod.Equal(t2))
+}
@@ -0,0 +1,185 @@
+package home
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Update checker:
+//  version.json of the update channel is downloaded every versionCheckPeriod
+//  and a message is logged when a new version is available.
+// Update channel:
+//  update_channel setting ("release" or "beta");  empty: the channel the application is built for.
+// The package checksum and signature:
+//  version.json contains SHA-256 checksum of each package ("checksum_linux_amd64" for "download_linux_amd64")
+//  and its Ed25519 signature ("signature_linux_amd64", base64).
+//  version.json isn't signed, so the checksum only detects a damaged download;
+//  the signature is verified with the public key built into the application (updateKey in main.go, set via ldflags).
+//  The package is installed only if both its checksum and its signature are valid.
+//  If the application is built without the public key, it can't be updated automatically.
+
+// Update channels which may be set in configuration file
+var updateChannels = []string{"release", "beta"}
+
+// Return TRUE if the update channel may be set in configuration file
+func isValidUpdateChannel(channel string) bool {
+	if len(channel) == 0 {
+		return true
+	}
+	for _, c := range updateChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the current update channel
+func getUpdateChannel() string {
+	if len(config.UpdateChannel) != 0 {
+		return config.UpdateChannel
+	}
+	return updateChannel
+}
+
+// Get URL of version.json for the update channel
+func getVersionCheckURL(channel string) string {
+	return "https://static.adguard.com/adguardhome/" + channel + "/version.json"
+}
+
+// Get the suffix of the keys in version.json for the current platform:
+//  "linux_arm" or "linux_arm64" for regular ARM versions, "linux_armv5" for ARMv5
+func updatePackageKey() string {
+	if runtime.GOARCH == "arm" && ARMVersion == "5" {
+		return fmt.Sprintf("%s_%sv%s", runtime.GOOS, runtime.GOARCH, ARMVersion)
+	}
+	return fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// Check SHA-256 checksum (hex string) of the data
+func verifyChecksum(data []byte, checksum string) error {
+	if len(checksum) == 0 {
+		return fmt.Errorf("the checksum of the package isn't available")
+	}
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), checksum) {
+		return fmt.Errorf("the checksum of the package doesn't match")
+	}
+	return nil
+}
+
+// Check Ed25519 signature (base64) of the data with the public key built into the application
+func verifySignature(data []byte, signature string) error {
+	if len(updatePublicKey) == 0 {
+		return fmt.Errorf("the public key for the update packages isn't built into the application")
+	}
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key for the update packages")
+	}
+	if len(signature) == 0 {
+		return fmt.Errorf("the signature of the package isn't available")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature of the package")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return fmt.Errorf("the signature of the package doesn't match")
+	}
+	return nil
+}
+
+// Get version.json data:  it's downloaded if the cached data is older than versionCheckPeriod
+// recheck: download the data even if it's cached
+func getVersionJSON(recheck bool) ([]byte, error) {
+	now := time.Now()
+	if !recheck {
+		Context.controlLock.Lock()
+		cached := now.Sub(config.versionCheckLastTime) <= versionCheckPeriod && len(config.versionCheckJSON) != 0
+		data := config.versionCheckJSON
+		Context.controlLock.Unlock()
+
+		if cached {
+			log.Tracef("Returning cached data")
+			return data, nil
+		}
+	}
+
+	url := getVersionCheckURL(getUpdateChannel())
+	var resp *http.Response
+	var err error
+	for i := 0; i != 3; i++ {
+		log.Tracef("Downloading data from %s", url)
+		resp, err = Context.client.Get(url)
+		if resp != nil && resp.Body != nil {
+			defer resp.Body.Close()
+		}
+		if err != nil && strings.HasSuffix(err.Error(), "i/o timeout") {
+			// This case may happen while we're restarting DNS server
+			// https://github.com/AdguardTeam/AdGuardHome/issues/934
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get version check json from %s: %T %s", url, err, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't get version check json from %s: status code %d", url, resp.StatusCode)
+	}
+
+	// read the body entirely
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read response body from %s: %s", url, err)
+	}
+
+	Context.controlLock.Lock()
+	config.versionCheckLastTime = now
+	config.versionCheckJSON = body
+	Context.controlLock.Unlock()
+	return body, nil
+}
+
+// Check for a new version periodically
+func startUpdateChecker() {
+	if Context.disableUpdate || getUpdateChannel() == "none" {
+		return
+	}
+
+	go func() {
+		lastVersion := ""
+		for {
+			// the first check is delayed until the services are started
+			time.Sleep(time.Minute)
+
+			data, err := getVersionJSON(false)
+			if err != nil {
+				log.Debug("Update checker: %s", err)
+			} else {
+				v := struct {
+					Version string `json:"version"`
+				}{}
+				_ = json.Unmarshal(data, &v)
+				if len(v.Version) != 0 && v.Version != versionString && v.Version != lastVersion {
+					log.Info("A new version %s is available in %s channel (the current version is %s)",
+						v.Version, getUpdateChannel(), versionString)
+					lastVersion = v.Version
+				}
+			}
+			time.Sleep(versionCheckPeriod - time.Minute)
+		}
+	}()
+}
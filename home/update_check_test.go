@@ -0,0 +1,63 @@
+package home
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCheck(t *testing.T) {
+	// echo -n "package" | sha256sum
+	checksum := "bc4a71180870f7945155fbb02f4b0a2e3faa2a62d6d31b7039013055ed19869a"
+	err := verifyChecksum([]byte("package"), checksum)
+	assert.Nil(t, err)
+	err = verifyChecksum([]byte("package"), "BC4A71180870F7945155FBB02F4B0A2E3FAA2A62D6D31B7039013055ED19869A")
+	assert.Nil(t, err)
+	err = verifyChecksum([]byte("package"), "")
+	assert.NotNil(t, err)
+	err = verifyChecksum([]byte("package2"), checksum)
+	assert.NotNil(t, err)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("package")))
+
+	// the public key isn't built into the application
+	updatePublicKey = ""
+	err = verifySignature([]byte("package"), signature)
+	assert.NotNil(t, err)
+
+	updatePublicKey = base64.StdEncoding.EncodeToString(pub)
+	err = verifySignature([]byte("package"), signature)
+	assert.Nil(t, err)
+	// tampered package
+	err = verifySignature([]byte("package2"), signature)
+	assert.NotNil(t, err)
+	// missing or broken signature
+	err = verifySignature([]byte("package"), "")
+	assert.NotNil(t, err)
+	err = verifySignature([]byte("package"), "invalid")
+	assert.NotNil(t, err)
+	// the package is signed with another key
+	_, priv2, _ := ed25519.GenerateKey(rand.Reader)
+	err = verifySignature([]byte("package"), base64.StdEncoding.EncodeToString(ed25519.Sign(priv2, []byte("package"))))
+	assert.NotNil(t, err)
+	updatePublicKey = ""
+
+	assert.True(t, isValidUpdateChannel(""))
+	assert.True(t, isValidUpdateChannel("beta"))
+	assert.False(t, isValidUpdateChannel("edge"))
+
+	config.UpdateChannel = "beta"
+	assert.Equal(t, "https://static.adguard.com/adguardhome/beta/version.json", getVersionCheckURL(getUpdateChannel()))
+	config.UpdateChannel = ""
+	assert.Equal(t, updateChannel, getUpdateChannel())
+
+	if runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+		assert.Equal(t, "linux_amd64", updatePackageKey())
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/util"
 
@@ -13,7 +15,14 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-const currentSchemaVersion = 6 // used for upgrading from old configs to new config
+const currentSchemaVersion = 8 // used for upgrading from old configs to new config
+
+// Before the configuration file is upgraded, its copy is saved to "AdGuardHome.yaml.schema<N>-<TIME>.bak"
+//  (N: the old schema version) in the same directory,
+//  and the applied upgrade steps are appended to "AdGuardHome.yaml.upgrade.log".
+// If the upgrade fails, the file stays the same;  to downgrade, replace the file with the copy.
+
+const configUpgradeTimeFormat = "20060102-150405"
 
 // Performs necessary upgrade operations if needed
 func upgradeConfig() error {
@@ -48,8 +57,83 @@ func upgradeConfig() error {
 		// do nothing
 		return nil
 	}
+	if schemaVersion < 0 || schemaVersion > currentSchemaVersion {
+		err = fmt.Errorf("configuration file contains unknown schema_version, abort")
+		log.Println(err)
+		return err
+	}
+
+	configFile := config.getConfigFilename()
+	now := time.Now()
+	backupFile, err := backupConfigBeforeUpgrade(configFile, body, schemaVersion, now)
+	if err != nil {
+		log.Error("Couldn't save the copy of the configuration file before upgrade: %s", err)
+		return err
+	}
+
+	err = upgradeConfigSchema(schemaVersion, &diskConfig)
+	newVersion, _ := diskConfig["schema_version"].(int)
+	logConfigUpgrade(configFile, backupFile, schemaVersion, newVersion, err, now)
+	if err != nil {
+		return err
+	}
 
-	return upgradeConfigSchema(schemaVersion, &diskConfig)
+	body, err = yaml.Marshal(diskConfig)
+	if err != nil {
+		log.Printf("Couldn't generate YAML file: %s", err)
+		return err
+	}
+
+	config.fileData = body
+	err = writeConfigFile(body)
+	if err != nil {
+		log.Printf("Couldn't save YAML config: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// Save the copy of the configuration file data before upgrade
+// Return the file name
+func backupConfigBeforeUpgrade(configFile string, data []byte, schemaVersion int, now time.Time) (string, error) {
+	fn := fmt.Sprintf("%s.schema%d-%s.bak", configFile, schemaVersion, now.Format(configUpgradeTimeFormat))
+	err := file.SafeWrite(fn, data)
+	if err != nil {
+		return "", err
+	}
+	log.Info("Saved the copy of the configuration file with schema version %d to %s", schemaVersion, fn)
+	return fn, nil
+}
+
+// Append the record of the upgrade to the log file
+// newVersion: the schema version reached by the upgrade
+// upgradeErr: the error of the failed upgrade step
+func logConfigUpgrade(configFile, backupFile string, oldVersion, newVersion int, upgradeErr error, now time.Time) {
+	steps := []string{}
+	for v := oldVersion; v < newVersion; v++ {
+		steps = append(steps, fmt.Sprintf("%d->%d", v, v+1))
+	}
+	if newVersion < oldVersion {
+		newVersion = oldVersion
+	}
+	s := fmt.Sprintf("%s: schema_version %d -> %d: steps: [%s]",
+		now.Format(time.RFC3339), oldVersion, newVersion, strings.Join(steps, " "))
+	if upgradeErr != nil {
+		s += fmt.Sprintf(": failed at %d->%d: %s", newVersion, newVersion+1, upgradeErr)
+	}
+	s += fmt.Sprintf(": backup: %s\n", filepath.Base(backupFile))
+
+	f, err := os.OpenFile(configFile+".upgrade.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Error("Couldn't write the upgrade log: %s", err)
+		return
+	}
+	_, err = f.WriteString(s)
+	if err != nil {
+		log.Error("Couldn't write the upgrade log: %s", err)
+	}
+	_ = f.Close()
 }
 
 // Upgrade from oldVersion to newVersion
@@ -90,26 +174,24 @@ func upgradeConfigSchema(oldVersion int, diskConfig *map[string]interface{}) err
 		if err != nil {
 			return err
 		}
+		fallthrough
+	case 6:
+		err := upgradeSchema6to7(diskConfig)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 7:
+		err := upgradeSchema7to8(diskConfig)
+		if err != nil {
+			return err
+		}
 	default:
 		err := fmt.Errorf("configuration file contains unknown schema_version, abort")
 		log.Println(err)
 		return err
 	}
 
-	configFile := config.getConfigFilename()
-	body, err := yaml.Marshal(diskConfig)
-	if err != nil {
-		log.Printf("Couldn't generate YAML file: %s", err)
-		return err
-	}
-
-	config.fileData = body
-	err = file.SafeWrite(configFile, body)
-	if err != nil {
-		log.Printf("Couldn't save YAML config: %s", err)
-		return err
-	}
-
 	return nil
 }
 
@@ -345,3 +427,75 @@ func upgradeSchema5to6(diskConfig *map[string]interface{}) error {
 
 	return nil
 }
+
+// Replace plain-text passwords of users with bcrypt hashes:
+// users:
+// - name: "..."
+//   password: "plain text"
+//
+// ->
+//
+// users:
+// - name: "..."
+//   password: "$2a$10$..."
+func upgradeSchema6to7(diskConfig *map[string]interface{}) error {
+	log.Printf("%s(): called", util.FuncName())
+
+	(*diskConfig)["schema_version"] = 7
+
+	users, ok := (*diskConfig)["users"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	for _, it := range users {
+		u, ok := it.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		pass, ok := u["password"].(string)
+		if !ok || len(pass) == 0 || isPasswordHash(pass) {
+			continue
+		}
+
+		hash, err := hashPassword(pass)
+		if err != nil {
+			return fmt.Errorf("user %v: %s", u["name"], err)
+		}
+		u["password"] = hash
+		log.Info("Replaced the plain-text password of user %v with its hash", u["name"])
+	}
+
+	return nil
+}
+
+// Replace the single DNS listen address with the list:
+// dns:
+//   bind_host: 0.0.0.0
+//
+// ->
+//
+// dns:
+//   bind_hosts:
+//   - 0.0.0.0
+func upgradeSchema7to8(diskConfig *map[string]interface{}) error {
+	log.Printf("%s(): called", util.FuncName())
+
+	(*diskConfig)["schema_version"] = 8
+
+	dns, ok := (*diskConfig)["dns"].(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+	bindHost, ok := dns["bind_host"]
+	if !ok {
+		return nil
+	}
+	delete(dns, "bind_host")
+	host, ok := bindHost.(string)
+	if !ok || len(host) == 0 {
+		return nil
+	}
+	dns["bind_hosts"] = []interface{}{host}
+	return nil
+}
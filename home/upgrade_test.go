@@ -2,7 +2,14 @@ package home
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestUpgrade1to2(t *testing.T) {
@@ -228,3 +235,77 @@ func createTestDNSConfig(schemaVersion int) map[interface{}]interface{} {
 	dnsConfig["safebrowsing_enabled"] = true
 	return dnsConfig
 }
+
+func TestUpgrade6to7(t *testing.T) {
+	const hash = "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"
+	diskConfig := map[string]interface{}{
+		"schema_version": 6,
+		"users": []interface{}{
+			map[interface{}]interface{}{"name": "plain", "password": "password"},
+			map[interface{}]interface{}{"name": "hashed", "password": hash},
+		},
+	}
+
+	err := upgradeSchema6to7(&diskConfig)
+	if err != nil {
+		t.Fatalf("Can't update schema version from 6 to 7: %s", err)
+	}
+	compareSchemaVersion(t, diskConfig["schema_version"], 7)
+
+	users := diskConfig["users"].([]interface{})
+	plain := users[0].(map[interface{}]interface{})["password"].(string)
+	if !isPasswordHash(plain) || bcrypt.CompareHashAndPassword([]byte(plain), []byte("password")) != nil {
+		t.Fatalf("Plain-text password wasn't replaced with its hash: %s", plain)
+	}
+	if users[1].(map[interface{}]interface{})["password"] != hash {
+		t.Fatalf("Password hash was modified")
+	}
+}
+
+func TestUpgrade7to8(t *testing.T) {
+	diskConfig := map[string]interface{}{
+		"schema_version": 7,
+		"dns": map[interface{}]interface{}{
+			"bind_host": "127.0.0.1",
+			"port":      53,
+		},
+	}
+
+	err := upgradeSchema7to8(&diskConfig)
+	if err != nil {
+		t.Fatalf("Can't update schema version from 7 to 8: %s", err)
+	}
+	compareSchemaVersion(t, diskConfig["schema_version"], 8)
+
+	dns := diskConfig["dns"].(map[interface{}]interface{})
+	if _, ok := dns["bind_host"]; ok {
+		t.Fatalf("bind_host wasn't removed")
+	}
+	bindHosts, ok := dns["bind_hosts"].([]interface{})
+	if !ok || len(bindHosts) != 1 || bindHosts[0] != "127.0.0.1" {
+		t.Fatalf("Wrong bind_hosts: %v", dns["bind_hosts"])
+	}
+}
+
+func TestConfigUpgradeBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-upgrade")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	configFile := filepath.Join(dir, "AdGuardHome.yaml")
+	now := time.Date(2020, 10, 17, 12, 0, 0, 0, time.UTC)
+	backupFile, err := backupConfigBeforeUpgrade(configFile, []byte("schema_version: 5\n"), 5, now)
+	assert.Nil(t, err)
+	assert.Equal(t, configFile+".schema5-20201017-120000.bak", backupFile)
+	data, err := ioutil.ReadFile(backupFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "schema_version: 5\n", string(data))
+
+	logConfigUpgrade(configFile, backupFile, 5, 7, nil, now)
+	logConfigUpgrade(configFile, backupFile, 5, 6, fmt.Errorf("some error"), now)
+	data, err = ioutil.ReadFile(configFile + ".upgrade.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-10-17T12:00:00Z: schema_version 5 -> 7: steps: [5->6 6->7]: backup: AdGuardHome.yaml.schema5-20201017-120000.bak\n"+
+		"2020-10-17T12:00:00Z: schema_version 5 -> 6: steps: [5->6]: failed at 6->7: some error: backup: AdGuardHome.yaml.schema5-20201017-120000.bak\n",
+		string(data))
+}
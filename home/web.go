@@ -19,6 +19,9 @@ type WebConfig struct {
 	BindHost  string
 	BindPort  int
 	PortHTTPS int
+
+	BasePath       string       // URL prefix of the web interface, e.g. "/adguard";  empty: "/"
+	TrustedProxies []*net.IPNet // reverse proxies which may set the client's IP address in HTTP headers
 }
 
 // HTTPSServer - HTTPS Server
@@ -29,12 +32,14 @@ type HTTPSServer struct {
 	shutdown bool // if TRUE, don't restart the server
 	enabled  bool
 	cert     tls.Certificate
+	params   tlsParams
 }
 
 // Web - module object
 type Web struct {
 	conf        *WebConfig
 	forceHTTPS  bool
+	hsts        bool
 	portHTTPS   int
 	httpServer  *http.Server // HTTP module
 	httpsServer HTTPSServer  // HTTPS module
@@ -93,13 +98,19 @@ func (web *Web) TLSConfigChanged(tlsConf tlsConfigSettings) {
 		tlsConf.PortHTTPS != 0 &&
 		len(tlsConf.PrivateKeyData) != 0 &&
 		len(tlsConf.CertificateChainData) != 0
+	web.hsts = (tlsConf.HSTS && enabled)
 	var cert tls.Certificate
+	var params tlsParams
 	var err error
 	if enabled {
 		cert, err = tls.X509KeyPair(tlsConf.CertificateChainData, tlsConf.PrivateKeyData)
 		if err != nil {
 			log.Fatal(err)
 		}
+		params, err = getTLSParams(tlsConf)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	web.httpsServer.cond.L.Lock()
@@ -108,6 +119,7 @@ func (web *Web) TLSConfigChanged(tlsConf tlsConfigSettings) {
 	}
 	web.httpsServer.enabled = enabled
 	web.httpsServer.cert = cert
+	web.httpsServer.params = params
 	web.httpsServer.cond.Broadcast()
 	web.httpsServer.cond.L.Unlock()
 }
@@ -124,7 +136,8 @@ func (web *Web) Start() {
 		// we need to have new instance, because after Shutdown() the Server is not usable
 		address := net.JoinHostPort(web.conf.BindHost, strconv.Itoa(web.conf.BindPort))
 		web.httpServer = &http.Server{
-			Addr: address,
+			Addr:    address,
+			Handler: web,
 		}
 		err := web.httpServer.ListenAndServe()
 		if err != http.ErrServerClosed {
@@ -136,16 +149,24 @@ func (web *Web) Start() {
 }
 
 // Close - stop HTTP server, possibly waiting for all active connections to be closed
+// The requests being processed are given shutdownTimeout to finish
 func (web *Web) Close() {
 	log.Info("Stopping HTTP server...")
 	web.httpsServer.cond.L.Lock()
 	web.httpsServer.shutdown = true
 	web.httpsServer.cond.L.Unlock()
-	if web.httpsServer.server != nil {
-		_ = web.httpsServer.server.Shutdown(context.TODO())
-	}
-	if web.httpServer != nil {
-		_ = web.httpServer.Shutdown(context.TODO())
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range []*http.Server{web.httpsServer.server, web.httpServer} {
+		if srv == nil {
+			continue
+		}
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			log.Info("HTTP requests haven't been finished: %s", err)
+			_ = srv.Close()
+		}
 	}
 
 	log.Info("Stopped HTTP server")
@@ -168,19 +189,30 @@ func (web *Web) httpServerLoop() {
 			}
 		}
 
+		cert := web.httpsServer.cert
+		params := web.httpsServer.params
 		web.httpsServer.cond.L.Unlock()
 
 		// prepare HTTPS server
 		address := net.JoinHostPort(web.conf.BindHost, strconv.Itoa(web.conf.PortHTTPS))
 		web.httpsServer.server = &http.Server{
-			Addr: address,
+			Addr:    address,
+			Handler: web,
 			TLSConfig: &tls.Config{
-				Certificates: []tls.Certificate{web.httpsServer.cert},
-				MinVersion:   tls.VersionTLS12,
+				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return stapleOCSP(cert), nil
+				},
+				MinVersion:   params.minVersion,
+				MaxVersion:   params.maxVersion,
 				RootCAs:      Context.tlsRoots,
-				CipherSuites: Context.tlsCiphers,
+				CipherSuites: params.ciphers,
 			},
 		}
+		if params.clientCAs != nil {
+			// the certificate is checked by DNS-over-HTTPS handler:  the web interface doesn't require it
+			web.httpsServer.server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			web.httpsServer.server.TLSConfig.ClientCAs = params.clientCAs
+		}
 
 		printHTTPAddresses("https")
 		err := web.httpsServer.server.ListenAndServeTLS("", "")
@@ -0,0 +1,179 @@
+package home
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Running behind a reverse proxy:
+//  base_path: URL prefix of the web interface and API (e.g. "/adguard" for "https://example.org/adguard/").
+//   The requests must be received with this prefix:  the proxy must not strip it.
+//   DNS-over-HTTPS requests (/dns-query) are served without the prefix.
+//  trusted_proxies: IP addresses and networks of the reverse proxies.
+//   The client's IP address is taken from X-Forwarded-For or X-Real-IP header
+//   only if the request is received from a trusted proxy.
+//   X-Forwarded-Proto from a trusted proxy tells that the client has connected via HTTPS
+//   (the proxy has terminated TLS):  HTTPS isn't forced and HSTS header is sent.
+//   These headers are removed from all the other requests.
+
+// HTTP headers which may contain the client's IP address
+var proxyIPHeaders = []string{
+	"CF-Connecting-IP",
+	"True-Client-IP",
+	"X-Real-IP",
+	"X-Forwarded-For",
+}
+
+// Check and normalize the base path:  "adguard/" -> "/adguard";  "/" -> ""
+func normalizeBasePath(p string) (string, error) {
+	p = strings.TrimRight(p, "/")
+	if len(p) == 0 {
+		return "", nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	u, err := url.Parse(p)
+	if err != nil || u.Path != p || len(u.RawQuery) != 0 || len(u.Fragment) != 0 {
+		return "", fmt.Errorf("invalid base_path: %s", p)
+	}
+	return p, nil
+}
+
+// Get the URL path of the web interface page with the base path prefix
+func webPath(p string) string {
+	return config.WebBasePath + p
+}
+
+// Parse the list of trusted proxies:  IP addresses or networks (CIDR)
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		if strings.Contains(s, "/") {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", s)
+			}
+			nets = append(nets, n)
+			continue
+		}
+
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// Return TRUE if the IP address belongs to a trusted proxy
+func isTrustedProxy(proxies []*net.IPNet, ip net.IP) bool {
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the client's IP address from the headers of the request received from a trusted proxy
+// X-Forwarded-For is checked from right to left:  the first address which isn't a trusted proxy is the client's address.
+// Return nil if the headers don't contain a valid address
+func proxyClientIP(proxies []*net.IPNet, r *http.Request) net.IP {
+	addrs := []string{}
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		addrs = append(addrs, strings.Split(h, ",")...)
+	}
+	var ip net.IP
+	for i := len(addrs) - 1; i >= 0; i-- {
+		it := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if it == nil {
+			break
+		}
+		ip = it
+		if !isTrustedProxy(proxies, ip) {
+			break
+		}
+	}
+	if ip != nil {
+		return ip
+	}
+
+	return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+}
+
+// Set the client's address of the request received from a trusted proxy and remove the proxy headers
+// X-Forwarded-Proto is kept only if the request is received from a trusted proxy.
+func setProxyRemoteAddr(proxies []*net.IPNet, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	trusted := err == nil && len(proxies) != 0 && isTrustedProxy(proxies, net.ParseIP(host))
+	if trusted {
+		ip := proxyClientIP(proxies, r)
+		if ip != nil {
+			log.Tracef("Web: client %s via proxy %s", ip, host)
+			r.RemoteAddr = net.JoinHostPort(ip.String(), port)
+		}
+	} else {
+		r.Header.Del("X-Forwarded-Proto")
+	}
+
+	for _, h := range proxyIPHeaders {
+		r.Header.Del(h)
+	}
+}
+
+// Return TRUE if the client has connected via HTTPS:
+//  directly or via a trusted proxy which has set "X-Forwarded-Proto: https"
+// The first value is set by the proxy which has received the request from the client.
+func isHTTPSRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	proto := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// ServeHTTP - handle the request received by HTTP or HTTPS server
+func (web *Web) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	setProxyRemoteAddr(web.conf.TrustedProxies, r)
+
+	if strings.HasPrefix(r.URL.Path, acmeHTTPPath) && Context.tls != nil && Context.tls.acme != nil {
+		Context.tls.acme.ServeHTTP(w, r)
+		return
+	}
+
+	basePath := web.conf.BasePath
+	if len(basePath) != 0 && !strings.HasPrefix(r.URL.Path, "/dns-query") {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusFound)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		// the same as http.StripPrefix()
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = r.URL.Path[len(basePath):]
+		r2.URL.RawPath = ""
+		r = r2
+	}
+
+	http.DefaultServeMux.ServeHTTP(w, r)
+}
+
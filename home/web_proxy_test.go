@@ -0,0 +1,113 @@
+package home
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	p, err := normalizeBasePath("")
+	assert.Nil(t, err)
+	assert.Equal(t, "", p)
+
+	p, err = normalizeBasePath("/")
+	assert.Nil(t, err)
+	assert.Equal(t, "", p)
+
+	p, err = normalizeBasePath("adguard/")
+	assert.Nil(t, err)
+	assert.Equal(t, "/adguard", p)
+
+	_, err = normalizeBasePath("/adguard?a=1")
+	assert.NotNil(t, err)
+}
+
+func TestSetProxyRemoteAddr(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	assert.Nil(t, err)
+	_, err = parseTrustedProxies([]string{"1.2.3"})
+	assert.NotNil(t, err)
+
+	newRequest := func(remoteAddr string, xff string) *http.Request {
+		r := &http.Request{RemoteAddr: remoteAddr, Header: make(http.Header)}
+		if len(xff) != 0 {
+			r.Header.Set("X-Forwarded-For", xff)
+		}
+		return r
+	}
+
+	// the request isn't received from a trusted proxy:  the headers are ignored and removed
+	r := newRequest("1.2.3.4:1234", "5.6.7.8")
+	r.Header.Set("X-Real-IP", "5.6.7.8")
+	setProxyRemoteAddr(proxies, r)
+	assert.Equal(t, "1.2.3.4:1234", r.RemoteAddr)
+	assert.Equal(t, "", r.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "", r.Header.Get("X-Real-IP"))
+
+	// trusted proxy
+	r = newRequest("127.0.0.1:1234", "5.6.7.8")
+	setProxyRemoteAddr(proxies, r)
+	assert.Equal(t, "5.6.7.8:1234", r.RemoteAddr)
+	assert.Equal(t, "", r.Header.Get("X-Forwarded-For"))
+
+	// the left-most address is set by the client:  the right-most untrusted address is used
+	r = newRequest("127.0.0.1:1234", "1.1.1.1, 5.6.7.8, 10.0.0.1")
+	setProxyRemoteAddr(proxies, r)
+	assert.Equal(t, "5.6.7.8:1234", r.RemoteAddr)
+
+	// X-Real-IP
+	r = newRequest("127.0.0.1:1234", "")
+	r.Header.Set("X-Real-IP", "5.6.7.8")
+	setProxyRemoteAddr(proxies, r)
+	assert.Equal(t, "5.6.7.8:1234", r.RemoteAddr)
+
+	// no proxies
+	r = newRequest("127.0.0.1:1234", "5.6.7.8")
+	setProxyRemoteAddr(nil, r)
+	assert.Equal(t, "127.0.0.1:1234", r.RemoteAddr)
+
+	// X-Forwarded-Proto is kept only for a trusted proxy
+	r = newRequest("127.0.0.1:1234", "")
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+	setProxyRemoteAddr(proxies, r)
+	assert.True(t, isHTTPSRequest(r))
+	r = newRequest("1.2.3.4:1234", "")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	setProxyRemoteAddr(proxies, r)
+	assert.Equal(t, "", r.Header.Get("X-Forwarded-Proto"))
+	assert.False(t, isHTTPSRequest(r))
+	r = newRequest("127.0.0.1:1234", "")
+	r.Header.Set("X-Forwarded-Proto", "http")
+	setProxyRemoteAddr(proxies, r)
+	assert.False(t, isHTTPSRequest(r))
+}
+
+func TestWebBasePath(t *testing.T) {
+	path := ""
+	http.HandleFunc("/test_base_path", func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	})
+
+	web := &Web{conf: &WebConfig{BasePath: "/adguard"}}
+	request := func(p string) testResponseWriter {
+		w := testResponseWriter{hdr: make(http.Header)}
+		r := http.Request{Method: http.MethodGet, Header: make(http.Header), URL: &url.URL{Path: p}}
+		path = ""
+		web.ServeHTTP(&w, &r)
+		return w
+	}
+
+	request("/adguard/test_base_path")
+	assert.Equal(t, "/test_base_path", path)
+
+	w := request("/test_base_path")
+	assert.Equal(t, "", path)
+	assert.Equal(t, http.StatusNotFound, w.statusCode)
+
+	w = request("/adguard")
+	assert.Equal(t, http.StatusFound, w.statusCode)
+	assert.Equal(t, "/adguard/", w.hdr.Get("Location"))
+}
@@ -15,7 +15,10 @@ var channel = "release"
 // GOARM value - set via ldflags
 var goarm = ""
 
+// the public key which verifies the update packages (base64-encoded Ed25519 key) - set via ldflags
+var updateKey = ""
+
 func main() {
 	debug.SetGCPercent(10)
-	home.Main(version, channel, goarm)
+	home.Main(version, channel, goarm, updateKey)
 }
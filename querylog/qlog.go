@@ -2,7 +2,6 @@ package querylog
 
 import (
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -11,6 +10,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
 )
@@ -34,6 +34,9 @@ type queryLog struct {
 	fileFlushLock sync.Mutex // synchronize a file-flushing goroutine and main thread
 	flushPending  bool       // don't start another goroutine while the previous one is still running
 	fileWriteLock sync.Mutex
+
+	subsLock sync.Mutex
+	subs     map[chan *logEntry]bool // live stream subscribers
 }
 
 // create a new instance of the query log
@@ -59,8 +62,9 @@ func (l *queryLog) Close() {
 	_ = l.flushLogBuffer(true)
 }
 
+// Check the retention interval (in days);  0: no limit
 func checkInterval(days uint32) bool {
-	return days == 1 || days == 7 || days == 30 || days == 90
+	return days == 0 || days == 1 || days == 7 || days == 30 || days == 90
 }
 
 func (l *queryLog) WriteDiskConfig(dc *DiskConfig) {
@@ -80,12 +84,14 @@ func (l *queryLog) clear() {
 	l.flushPending = false
 	l.bufferLock.Unlock()
 
-	err := os.Remove(l.logFile + ".1")
-	if err != nil && !os.IsNotExist(err) {
-		log.Error("file remove: %s: %s", l.logFile+".1", err)
+	for n := l.chunksCount(); n > 0; n-- {
+		err := os.Remove(l.chunkFile(n))
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("file remove: %s: %s", l.chunkFile(n), err)
+		}
 	}
 
-	err = os.Remove(l.logFile)
+	err := os.Remove(l.logFile)
 	if err != nil && !os.IsNotExist(err) {
 		log.Error("file remove: %s: %s", l.logFile, err)
 	}
@@ -166,6 +172,8 @@ func (l *queryLog) Add(params AddParams) {
 	}
 	l.bufferLock.Unlock()
 
+	l.publish(&entry)
+
 	// if buffer needs to be flushed to disk, do it now
 	if needFlush {
 		// write to file
@@ -177,6 +185,8 @@ func (l *queryLog) Add(params AddParams) {
 // Parameters for getData()
 type getDataParams struct {
 	OlderThan         time.Time          // return entries that are older than this value
+	NewerThan         time.Time          // return entries that are newer than this value (optional)
+	Limit             int                // maximum number of entries to return;  0: getDataLimit
 	Domain            string             // filter by domain name in question
 	Client            string             // filter by client IP
 	QuestionType      string             // filter by question type
@@ -192,8 +202,18 @@ type responseStatusType int32
 const (
 	responseStatusAll responseStatusType = iota + 1
 	responseStatusFiltered
+	responseStatusRewritten
+	responseStatusAllowed
 )
 
+// Get the maximum number of entries to return
+func (params *getDataParams) limit() int {
+	if params.Limit <= 0 || params.Limit > getDataLimit {
+		return getDataLimit
+	}
+	return params.Limit
+}
+
 // Gets log entries
 func (l *queryLog) getData(params getDataParams) map[string]interface{} {
 	now := time.Now()
@@ -225,6 +245,10 @@ func (l *queryLog) getData(params getDataParams) map[string]interface{} {
 			// Ignore entries newer than what was requested
 			continue
 		}
+		if !params.NewerThan.IsZero() && entry.Time.UnixNano() <= params.NewerThan.UnixNano() {
+			// the rest of the entries are older than the requested time range
+			break
+		}
 
 		if !matchesGetDataParams(entry, params) {
 			continue
@@ -236,11 +260,12 @@ func (l *queryLog) getData(params getDataParams) map[string]interface{} {
 
 	// now let's get a unified collection
 	entries := append(memoryEntries, fileEntries...)
-	if len(entries) > getDataLimit {
+	limit := params.limit()
+	if len(entries) > limit {
 		// remove extra records
-		entries = entries[:getDataLimit]
+		entries = entries[:limit]
 	}
-	if len(entries) == getDataLimit {
+	if len(entries) == limit {
 		// change the "oldest" value here.
 		// we cannot use the "oldest" we got from "searchFiles" anymore
 		// because after adding in-memory records and removing extra records
@@ -273,17 +298,7 @@ func (l *queryLog) getData(params getDataParams) map[string]interface{} {
 // Get Client IP address
 func (l *queryLog) getClientIP(clientIP string) string {
 	if l.conf.AnonymizeClientIP {
-		ip := net.ParseIP(clientIP)
-		if ip != nil {
-			ip4 := ip.To4()
-			const AnonymizeClientIP4Mask = 24
-			const AnonymizeClientIP6Mask = 112
-			if ip4 != nil {
-				clientIP = ip4.Mask(net.CIDRMask(AnonymizeClientIP4Mask, 32)).String()
-			} else {
-				clientIP = ip.Mask(net.CIDRMask(AnonymizeClientIP6Mask, 128)).String()
-			}
-		}
+		clientIP = util.AnonymizeIP(clientIP)
 	}
 
 	return clientIP
@@ -326,6 +341,9 @@ func (l *queryLog) logEntryToJSONEntry(entry *logEntry) map[string]interface{} {
 	if len(entry.Result.Rule) > 0 {
 		jsonEntry["rule"] = entry.Result.Rule
 		jsonEntry["filterId"] = entry.Result.FilterID
+		if entry.Result.Priority != dnsfilter.PriorityNone {
+			jsonEntry["rule_priority"] = entry.Result.Priority.String()
+		}
 	}
 
 	if len(entry.Result.ServiceName) != 0 {
@@ -0,0 +1,169 @@
+package querylog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Query log export: all entries matching the search parameters are streamed as CSV or JSON Lines.
+// Unlike GET /control/querylog, the number of entries isn't limited:
+//  "limit" parameter is ignored, the time range is set by "older_than" and "newer_than".
+
+// Columns of the exported CSV data
+var exportCSVHeader = []string{
+	"time", "client", "host", "type", "class", "status", "reason",
+	"rule", "filter_id", "answer", "elapsed_ms", "upstream",
+}
+
+// Call the function for each log entry matching the search parameters (from newer to older)
+// Stop if the function returns FALSE
+func (l *queryLog) forEachEntry(params getDataParams, f func(entry *logEntry) bool) {
+	if len(params.Client) != 0 && l.conf.AnonymizeClientIP {
+		params.Client = l.getClientIP(params.Client)
+	}
+
+	olderThan := params.OlderThan
+	if olderThan.IsZero() {
+		olderThan = time.Now().Add(time.Millisecond)
+	}
+
+	// entries from memory buffer
+	memoryEntries := []*logEntry{}
+	l.bufferLock.Lock()
+	for i := len(l.buffer) - 1; i >= 0; i-- {
+		entry := l.buffer[i]
+		if entry.Time.UnixNano() >= olderThan.UnixNano() {
+			continue
+		}
+		if !params.NewerThan.IsZero() && entry.Time.UnixNano() <= params.NewerThan.UnixNano() {
+			break
+		}
+		if matchesGetDataParams(entry, params) {
+			memoryEntries = append(memoryEntries, entry)
+		}
+	}
+	l.bufferLock.Unlock()
+
+	for _, entry := range memoryEntries {
+		if !f(entry) {
+			return
+		}
+	}
+
+	// entries from files
+	r, err := l.openReader()
+	if err != nil {
+		log.Error("Failed to open qlog reader: %v", err)
+		return
+	}
+	defer r.Close()
+
+	if params.OlderThan.IsZero() {
+		err = r.SeekStart()
+	} else {
+		err = r.Seek(params.OlderThan.UnixNano())
+		if err == nil {
+			_, err = r.ReadNext()
+		}
+	}
+	if err != nil {
+		log.Debug("Cannot Seek() to %v: %v", params.OlderThan, err)
+		return
+	}
+
+	for {
+		entry, ts, err := l.readNextEntry(r, params)
+		if err == io.EOF {
+			break
+		}
+		if !params.NewerThan.IsZero() && ts <= params.NewerThan.UnixNano() {
+			break
+		}
+		if entry != nil && !f(entry) {
+			break
+		}
+	}
+}
+
+// Get the fields of CSV record for the log entry
+func (l *queryLog) logEntryToCSVRecord(entry *logEntry) []string {
+	status := ""
+	answer := []string{}
+	if len(entry.Answer) != 0 {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(entry.Answer); err == nil {
+			status = dns.RcodeToString[msg.Rcode]
+			for _, a := range answerToMap(msg) {
+				answer = append(answer, fmt.Sprintf("%s %v", a["type"], a["value"]))
+			}
+		}
+	}
+
+	filterID := ""
+	if len(entry.Result.Rule) != 0 {
+		filterID = strconv.FormatInt(entry.Result.FilterID, 10)
+	}
+
+	return []string{
+		entry.Time.Format(time.RFC3339Nano),
+		l.getClientIP(entry.IP),
+		entry.QHost,
+		entry.QType,
+		entry.QClass,
+		status,
+		entry.Result.Reason.String(),
+		entry.Result.Rule,
+		filterID,
+		strings.Join(answer, "; "),
+		strconv.FormatFloat(entry.Elapsed.Seconds()*1000, 'f', -1, 64),
+		entry.Upstream,
+	}
+}
+
+func (l *queryLog) handleQueryLogExport(w http.ResponseWriter, r *http.Request) {
+	params, err := parseGetDataParams(r)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=querylog.csv")
+		cw := csv.NewWriter(w)
+		_ = cw.Write(exportCSVHeader)
+		l.forEachEntry(params, func(entry *logEntry) bool {
+			return cw.Write(l.logEntryToCSVRecord(entry)) == nil
+		})
+		cw.Flush()
+		err = cw.Error()
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=querylog.jsonl")
+		e := json.NewEncoder(w)
+		l.forEachEntry(params, func(entry *logEntry) bool {
+			err = e.Encode(l.logEntryToJSONEntry(entry))
+			return err == nil
+		})
+
+	default:
+		httpError(r, w, http.StatusBadRequest, "unsupported format: %s", format)
+		return
+	}
+
+	if err != nil {
+		log.Debug("QueryLog: export: %s", err)
+	}
+}
@@ -86,8 +86,8 @@ func (q *QLogFile) Seek(timestamp int64) (int64, int, error) {
 	end := fileInfo.Size()     // end of the search interval (position in the file)
 	probe := (end - start) / 2 // probe -- approximate index of the line we'll try to check
 	var line string
-	var lineIdx int64          // index of the probe line in the file
-	var lastProbeLineIdx int64 // index of the last probe line
+	var lineIdx int64               // index of the probe line in the file
+	var lastProbeLineIdx int64 = -1 // index of the last probe line
 
 	// Count seek depth in order to detect mistakes
 	// If depth is too large, we should stop the search
@@ -124,15 +124,18 @@ func (q *QLogFile) Seek(timestamp int64) (int64, int, error) {
 		// Narrow the scope and repeat the search
 		if ts > timestamp {
 			// If the timestamp we're looking for is OLDER than what we found
-			// Then the line is somewhere on the LEFT side from the current probe position
-			end = probe
-			probe = start + (end-start)/2
+			// Then the line is somewhere on the LEFT side from the current probe line
+			end = lineIdx
 		} else {
 			// If the timestamp we're looking for is NEWER than what we found
-			// Then the line is somewhere on the RIGHT side from the current probe position
-			start = probe
-			probe = start + (end-start)/2
+			// Then the line is somewhere on the RIGHT side from the current probe line
+			start = lineIdx + int64(len(line)) + 1
 		}
+		if start >= end {
+			// There are no more lines in the search scope
+			return 0, depth, ErrSeekNotFound
+		}
+		probe = start + (end-start)/2
 
 		depth++
 		if depth >= 100 {
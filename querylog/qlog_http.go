@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/AdguardTeam/golibs/jsonutil"
@@ -21,6 +22,8 @@ func httpError(r *http.Request, w http.ResponseWriter, code int, format string,
 
 type request struct {
 	olderThan            string
+	newerThan            string
+	limit                string
 	filterDomain         string
 	filterClient         string
 	filterQuestionType   string
@@ -37,11 +40,15 @@ func getDoubleQuotesEnclosedValue(s *string) bool {
 	return false
 }
 
-func (l *queryLog) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+// Get search parameters from the HTTP request
+// nolint(gocyclo)
+func parseGetDataParams(r *http.Request) (getDataParams, error) {
 	var err error
 	req := request{}
 	q := r.URL.Query()
 	req.olderThan = q.Get("older_than")
+	req.newerThan = q.Get("newer_than")
+	req.limit = q.Get("limit")
 	req.filterDomain = q.Get("filter_domain")
 	req.filterClient = q.Get("filter_client")
 	req.filterQuestionType = q.Get("filter_question_type")
@@ -55,8 +62,23 @@ func (l *queryLog) handleQueryLog(w http.ResponseWriter, r *http.Request) {
 	if len(req.olderThan) != 0 {
 		params.OlderThan, err = time.Parse(time.RFC3339Nano, req.olderThan)
 		if err != nil {
-			httpError(r, w, http.StatusBadRequest, "invalid time stamp: %s", err)
-			return
+			return params, fmt.Errorf("invalid time stamp: %s", err)
+		}
+	}
+	if len(req.newerThan) != 0 {
+		params.NewerThan, err = time.Parse(time.RFC3339Nano, req.newerThan)
+		if err != nil {
+			return params, fmt.Errorf("invalid time stamp: %s", err)
+		}
+		if !params.OlderThan.IsZero() && !params.NewerThan.Before(params.OlderThan) {
+			return params, fmt.Errorf("newer_than must be less than older_than")
+		}
+	}
+
+	if len(req.limit) != 0 {
+		params.Limit, err = strconv.Atoi(req.limit)
+		if err != nil || params.Limit <= 0 || params.Limit > getDataLimit {
+			return params, fmt.Errorf("limit must be in range 1..%d", getDataLimit)
 		}
 	}
 
@@ -70,22 +92,34 @@ func (l *queryLog) handleQueryLog(w http.ResponseWriter, r *http.Request) {
 	if len(req.filterQuestionType) != 0 {
 		_, ok := dns.StringToType[req.filterQuestionType]
 		if !ok {
-			httpError(r, w, http.StatusBadRequest, "invalid question_type")
-			return
+			return params, fmt.Errorf("invalid question_type")
 		}
 		params.QuestionType = req.filterQuestionType
 	}
 
 	if len(req.filterResponseStatus) != 0 {
 		switch req.filterResponseStatus {
-		case "filtered":
+		case "filtered", "blocked":
 			params.ResponseStatus = responseStatusFiltered
+		case "allowed":
+			params.ResponseStatus = responseStatusAllowed
+		case "rewritten":
+			params.ResponseStatus = responseStatusRewritten
 		default:
-			httpError(r, w, http.StatusBadRequest, "invalid response_status")
-			return
+			return params, fmt.Errorf("invalid response_status")
 		}
 	}
 
+	return params, nil
+}
+
+func (l *queryLog) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+	params, err := parseGetDataParams(r)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
 	data := l.getData(params)
 
 	jsonVal, err := json.Marshal(data)
@@ -166,6 +200,8 @@ func (l *queryLog) handleQueryLogConfig(w http.ResponseWriter, r *http.Request)
 func (l *queryLog) initWeb() {
 	l.conf.HTTPRegister("GET", "/control/querylog", l.handleQueryLog)
 	l.conf.HTTPRegister("GET", "/control/querylog_info", l.handleQueryLogInfo)
+	l.conf.HTTPRegister("GET", "/control/querylog_export", l.handleQueryLogExport)
+	l.conf.HTTPRegister("GET", "/control/querylog/stream", l.handleQueryLogStream)
 	l.conf.HTTPRegister("POST", "/control/querylog_clear", l.handleQueryLogClear)
 	l.conf.HTTPRegister("POST", "/control/querylog_config", l.handleQueryLogConfig)
 }
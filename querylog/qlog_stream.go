@@ -0,0 +1,120 @@
+package querylog
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/AdguardTeam/golibs/log"
+	"golang.org/x/net/websocket"
+)
+
+// Live query log: the new entries are sent to WebSocket clients as soon as they are added.
+// Each message is a JSON object of the same format as the elements of "data" array
+//  in GET /control/querylog response.
+// The filter parameters of GET /control/querylog ("filter_domain", "filter_client", etc.) are supported.
+
+// The number of entries waiting to be sent to one client
+// The new entries are dropped while the queue is full, so a slow client doesn't stall DNS processing
+const streamQueueSize = 100
+
+// Add a live stream subscriber
+func (l *queryLog) subscribe() chan *logEntry {
+	ch := make(chan *logEntry, streamQueueSize)
+	l.subsLock.Lock()
+	if l.subs == nil {
+		l.subs = map[chan *logEntry]bool{}
+	}
+	l.subs[ch] = true
+	l.subsLock.Unlock()
+	return ch
+}
+
+// Remove the live stream subscriber
+func (l *queryLog) unsubscribe(ch chan *logEntry) {
+	l.subsLock.Lock()
+	delete(l.subs, ch)
+	l.subsLock.Unlock()
+}
+
+// Pass the new entry to the live stream subscribers
+func (l *queryLog) publish(entry *logEntry) {
+	l.subsLock.Lock()
+	for ch := range l.subs {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+	l.subsLock.Unlock()
+}
+
+// Allow the connections from the pages of the same host only:
+//  a browser sends the authentication cookie with WebSocket request from any site
+// The clients which are not browsers don't send Origin header
+func checkStreamOrigin(config *websocket.Config, r *http.Request) error {
+	if len(r.Header.Get("Origin")) == 0 {
+		return nil
+	}
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return fmt.Errorf("origin %s is not allowed", r.Header.Get("Origin"))
+	}
+	config.Origin = origin
+	return nil
+}
+
+// Send the new entries to the client until the connection is closed
+func (l *queryLog) stream(ws *websocket.Conn, params getDataParams) {
+	defer ws.Close()
+
+	ch := l.subscribe()
+	defer l.unsubscribe(ch)
+
+	// we don't expect any messages from the client:  just wait until it closes the connection
+	closed := make(chan bool)
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, ws)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case entry := <-ch:
+			if !matchesGetDataParams(entry, params) {
+				continue
+			}
+			err := websocket.JSON.Send(ws, l.logEntryToJSONEntry(entry))
+			if err != nil {
+				log.Debug("QueryLog: stream: %s", err)
+				return
+			}
+
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (l *queryLog) handleQueryLogStream(w http.ResponseWriter, r *http.Request) {
+	params, err := parseGetDataParams(r)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	if len(params.Client) != 0 && l.conf.AnonymizeClientIP {
+		params.Client = l.getClientIP(params.Client)
+	}
+
+	srv := websocket.Server{
+		Handshake: checkStreamOrigin,
+		Handler: func(ws *websocket.Conn) {
+			l.stream(ws, params)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
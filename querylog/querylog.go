@@ -35,7 +35,7 @@ type QueryLog interface {
 type Config struct {
 	Enabled           bool
 	BaseDir           string // directory where log file is stored
-	Interval          uint32 // interval to rotate logs (in days)
+	Interval          uint32 // retention interval of the log (in days);  0: no limit
 	MemSize           uint32 // number of entries kept in memory before they are flushed to disk
 	AnonymizeClientIP bool   // anonymize clients' IP addresses
 
@@ -1,14 +1,31 @@
 package querylog
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 )
 
+// Retention of the log on disk:
+// The entries are written to the current log file.
+// When its oldest entry becomes older than 1/rotateChunks of the retention interval,
+//  the file is rotated: it becomes the chunk "querylog.json.1", the older chunks are renamed to ".2", ".3", etc.
+// The chunk whose newest entry is older than the retention interval is deleted,
+//  so an entry is kept on disk no longer than the retention interval plus the chunk period.
+// If the retention interval is 0 (no limit), the log file is never rotated.
+
+const (
+	rotateCheckInterval = 10 * time.Minute // how often we check whether the log file must be rotated
+	rotateChunks        = 24               // the number of chunks in the retention interval
+)
+
 // flushLogBuffer flushes the current buffer to file and resets the current buffer
 func (l *queryLog) flushLogBuffer(fullFlush bool) error {
 	l.fileFlushLock.Lock()
@@ -79,15 +96,42 @@ func (l *queryLog) flushToFile(buffer []*logEntry) error {
 	return nil
 }
 
+// Get the retention interval;  0: no limit
+func (l *queryLog) retention() time.Duration {
+	return time.Duration(l.conf.Interval) * 24 * time.Hour
+}
+
+// Get the path of the rotated chunk (1: the newest)
+func (l *queryLog) chunkFile(n int) string {
+	return l.logFile + "." + strconv.Itoa(n)
+}
+
+// Get the number of the rotated chunks on disk
+func (l *queryLog) chunksCount() int {
+	n := 0
+	for util.FileExists(l.chunkFile(n + 1)) {
+		n++
+	}
+	return n
+}
+
 func (l *queryLog) rotate() error {
 	from := l.logFile
-	to := l.logFile + ".1"
+	to := l.chunkFile(1)
 
 	if _, err := os.Stat(from); os.IsNotExist(err) {
 		// do nothing, file doesn't exist
 		return nil
 	}
 
+	for n := l.chunksCount(); n > 0; n-- {
+		err := os.Rename(l.chunkFile(n), l.chunkFile(n+1))
+		if err != nil {
+			log.Error("Failed to rename querylog chunk: %s", err)
+			return err
+		}
+	}
+
 	err := os.Rename(from, to)
 	if err != nil {
 		log.Error("Failed to rename querylog: %s", err)
@@ -98,12 +142,105 @@ func (l *queryLog) rotate() error {
 	return nil
 }
 
-func (l *queryLog) periodicRotate() {
-	for range time.Tick(time.Duration(l.conf.Interval) * 24 * time.Hour) {
-		err := l.rotate()
+// Get the time of the oldest entry in the current log file
+func (l *queryLog) readFileFirstTime() (time.Time, error) {
+	f, err := os.Open(l.logFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if len(line) == 0 {
+		return time.Time{}, err
+	}
+	entry := logEntry{}
+	err = json.Unmarshal(line, &entry)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return entry.Time, nil
+}
+
+// Get the time of the newest entry in the file
+func readFileLastTime(fn string) (time.Time, error) {
+	qf, err := NewQLogFile(fn)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer qf.Close()
+
+	_, err = qf.SeekStart()
+	if err != nil {
+		return time.Time{}, err
+	}
+	line, err := qf.ReadNext()
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts := readQLogTimestamp(line)
+	if ts == 0 {
+		return time.Time{}, fmt.Errorf("invalid entry: %s", line)
+	}
+	return time.Unix(0, ts), nil
+}
+
+// Delete the chunks whose entries are all older than the retention interval
+func (l *queryLog) removeExpiredChunks(now time.Time) {
+	for n := l.chunksCount(); n > 0; n-- {
+		fn := l.chunkFile(n)
+		last, err := readFileLastTime(fn)
+		if err != nil {
+			log.Debug("querylog: can't get the time of the newest entry: %s: %s", fn, err)
+			return
+		}
+		if now.Sub(last) < l.retention() {
+			return
+		}
+
+		err = os.Remove(fn)
 		if err != nil {
-			log.Error("Failed to rotate querylog: %s", err)
-			// do nothing, continue rotating
+			log.Error("file remove: %s: %s", fn, err)
+			return
 		}
+		log.Debug("querylog: removed expired chunk %s", fn)
+	}
+}
+
+// Rotate the log file if its oldest entry is older than the chunk period
+//  and delete the expired chunks
+// The age is taken from the file data so that the log is rotated in time
+//  even if the application is restarted more often than the interval
+func (l *queryLog) rotateIfNecessary(now time.Time) {
+	if l.retention() == 0 {
+		return
+	}
+
+	l.removeExpiredChunks(now)
+
+	first, err := l.readFileFirstTime()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debug("querylog: can't get the time of the oldest entry: %s", err)
+		}
+		return
+	}
+
+	if now.Sub(first) < l.retention()/rotateChunks {
+		return
+	}
+
+	err = l.rotate()
+	if err != nil {
+		log.Error("Failed to rotate querylog: %s", err)
+		// do nothing, continue rotating
+	}
+}
+
+// Check the age of the log file periodically
+func (l *queryLog) periodicRotate() {
+	for {
+		l.rotateIfNecessary(time.Now())
+		time.Sleep(rotateCheckInterval)
 	}
 }
@@ -51,6 +51,7 @@ func (l *queryLog) searchFiles(params getDataParams) ([]*logEntry, time.Time, in
 
 	total := 0
 	oldestNano := int64(0)
+	limit := params.limit()
 	// Do not scan more than 50k at once
 	for total <= maxSearchEntries {
 		entry, ts, err := l.readNextEntry(r, params)
@@ -60,13 +61,18 @@ func (l *queryLog) searchFiles(params getDataParams) ([]*logEntry, time.Time, in
 			break
 		}
 
+		if !params.NewerThan.IsZero() && ts <= params.NewerThan.UnixNano() {
+			// the rest of the entries are older than the requested time range
+			break
+		}
+
 		oldestNano = ts
 		total++
 
 		if entry != nil {
 			entries = append(entries, entry)
-			if len(entries) == getDataLimit {
-				// Do not read more than "getDataLimit" records at once
+			if len(entries) == limit {
+				// Do not read more than "limit" records at once
 				break
 			}
 		}
@@ -111,8 +117,9 @@ func (l *queryLog) readNextEntry(r *QLogReader, params getDataParams) (*logEntry
 func (l *queryLog) openReader() (*QLogReader, error) {
 	files := make([]string, 0)
 
-	if util.FileExists(l.logFile + ".1") {
-		files = append(files, l.logFile+".1")
+	// from the oldest chunk to the current file
+	for n := l.chunksCount(); n > 0; n-- {
+		files = append(files, l.chunkFile(n))
 	}
 	if util.FileExists(l.logFile) {
 		files = append(files, l.logFile)
@@ -130,6 +137,11 @@ func quickMatchesGetDataParams(line string, params getDataParams) bool {
 		if !ok || !boolVal {
 			return false
 		}
+	} else if params.ResponseStatus == responseStatusAllowed {
+		boolVal, ok := readJSONBool(line, "IsFiltered")
+		if ok && boolVal {
+			return false
+		}
 	}
 
 	if len(params.Domain) != 0 {
@@ -173,6 +185,16 @@ func matchesGetDataParams(entry *logEntry, params getDataParams) bool {
 		return false
 	}
 
+	if params.ResponseStatus == responseStatusAllowed && entry.Result.IsFiltered {
+		return false
+	}
+
+	if params.ResponseStatus == responseStatusRewritten &&
+		entry.Result.Reason != dnsfilter.ReasonRewrite && entry.Result.Reason != dnsfilter.RewriteEtcHosts &&
+		entry.Result.Reason != dnsfilter.RewriteRule {
+		return false
+	}
+
 	if len(params.QuestionType) != 0 {
 		if entry.QType != params.QuestionType {
 			return false
@@ -238,6 +260,9 @@ func decodeLogEntry(ent *logEntry, str string) {
 		case "Reason":
 			i, err = strconv.Atoi(v)
 			ent.Result.Reason = dnsfilter.Reason(i)
+		case "Priority":
+			i, err = strconv.Atoi(v)
+			ent.Result.Priority = dnsfilter.RulePriority(i)
 
 		case "Upstream":
 			ent.Upstream = v
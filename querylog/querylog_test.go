@@ -1,14 +1,20 @@
 package querylog
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
 )
 
 func prepareTestDir() string {
@@ -105,7 +111,327 @@ func TestQueryLog(t *testing.T) {
 	assert.True(t, checkEntry(t, mdata[3], "example.org", "1.1.1.1", "2.2.2.1"))
 }
 
+// Check filtering entries by response status
+func TestQueryLogResponseStatus(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntryWithReason(l, "rewrite.example.org", "1.1.1.2", "2.2.2.2", dnsfilter.ReasonRewrite)
+	_ = l.flushLogBuffer(true)
+	addEntryWithReason(l, "hosts.example.org", "1.1.1.3", "2.2.2.3", dnsfilter.RewriteEtcHosts)
+
+	params := getDataParams{
+		OlderThan:      time.Time{},
+		ResponseStatus: responseStatusRewritten,
+	}
+	d := l.getData(params)
+	mdata := d["data"].([]map[string]interface{})
+	assert.Equal(t, 2, len(mdata))
+	assert.True(t, checkEntry(t, mdata[0], "hosts.example.org", "1.1.1.3", "2.2.2.3"))
+	assert.True(t, checkEntry(t, mdata[1], "rewrite.example.org", "1.1.1.2", "2.2.2.2"))
+}
+
+// Check that the priority of the matched rule is stored and returned
+func TestQueryLogRulePriority(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	q := dns.Msg{}
+	q.SetQuestion("blocked.example.org.", dns.TypeA)
+	res := dnsfilter.Result{
+		IsFiltered: true,
+		Reason:     dnsfilter.FilteredBlackList,
+		Rule:       "||blocked.example.org^$important",
+		FilterID:   1,
+		Priority:   dnsfilter.PriorityBlocklistImportant,
+	}
+	l.Add(AddParams{
+		Question: &q,
+		Answer:   &dns.Msg{},
+		Result:   &res,
+		ClientIP: net.ParseIP("2.2.2.1"),
+	})
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.2")
+	// read the entries from file
+	_ = l.flushLogBuffer(true)
+
+	d := l.getData(getDataParams{OlderThan: time.Time{}})
+	mdata := d["data"].([]map[string]interface{})
+	assert.Equal(t, 2, len(mdata))
+	_, ok := mdata[0]["rule_priority"]
+	assert.False(t, ok)
+	assert.Equal(t, "||blocked.example.org^$important", mdata[1]["rule"])
+	assert.Equal(t, "BlocklistImportant", mdata[1]["rule_priority"])
+}
+
+// Check the time range, the number of entries and the "allowed" response status
+func TestQueryLogSearchRange(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	t0 := time.Now().Add(-time.Hour).Truncate(time.Second)
+	addEntry(l, "1.example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "2.example.org", "1.1.1.2", "2.2.2.2")
+	addEntryWithReason(l, "3.example.org", "1.1.1.3", "2.2.2.3", dnsfilter.FilteredBlackList)
+	addEntry(l, "4.example.org", "1.1.1.4", "2.2.2.4")
+	for i, e := range l.buffer {
+		e.Time = t0.Add(time.Duration(i+1) * time.Second)
+	}
+	l.buffer[2].Result.IsFiltered = true
+	_ = l.flushLogBuffer(true)
+	addEntry(l, "5.example.org", "1.1.1.5", "2.2.2.5")
+	l.buffer[0].Time = t0.Add(5 * time.Second)
+
+	d := l.getData(getDataParams{NewerThan: t0.Add(2 * time.Second)})
+	mdata := d["data"].([]map[string]interface{})
+	assert.Equal(t, 3, len(mdata))
+	assert.True(t, checkEntry(t, mdata[0], "5.example.org", "1.1.1.5", "2.2.2.5"))
+	assert.True(t, checkEntry(t, mdata[2], "3.example.org", "1.1.1.3", "2.2.2.3"))
+
+	// pagination
+	d = l.getData(getDataParams{Limit: 2})
+	mdata = d["data"].([]map[string]interface{})
+	assert.Equal(t, 2, len(mdata))
+	assert.True(t, checkEntry(t, mdata[1], "4.example.org", "1.1.1.4", "2.2.2.4"))
+	oldest, err := time.Parse(time.RFC3339Nano, d["oldest"].(string))
+	assert.Nil(t, err)
+	d = l.getData(getDataParams{OlderThan: oldest, Limit: 2})
+	mdata = d["data"].([]map[string]interface{})
+	assert.Equal(t, 2, len(mdata))
+	assert.True(t, checkEntry(t, mdata[0], "3.example.org", "1.1.1.3", "2.2.2.3"))
+	assert.True(t, checkEntry(t, mdata[1], "2.example.org", "1.1.1.2", "2.2.2.2"))
+
+	d = l.getData(getDataParams{ResponseStatus: responseStatusAllowed})
+	mdata = d["data"].([]map[string]interface{})
+	assert.Equal(t, 4, len(mdata))
+	for _, m := range mdata {
+		assert.NotEqual(t, "3.example.org", m["question"].(map[string]interface{})["host"])
+	}
+}
+
+// Check exporting entries from disk and memory
+func TestQueryLogExport(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntryWithReason(l, "blocked.example.org", "0.0.0.0", "2.2.2.2", dnsfilter.FilteredBlackList)
+	_ = l.flushLogBuffer(true)
+	addEntry(l, "example.com", "1.1.1.3", "2.2.2.3")
+
+	export := func(query string) (int, string) {
+		r := httptest.NewRequest("GET", "/control/querylog_export?"+query, nil)
+		w := httptest.NewRecorder()
+		l.handleQueryLogExport(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := export("format=csv")
+	assert.Equal(t, http.StatusOK, code)
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, exportCSVHeader, records[0])
+	assert.Equal(t, "2.2.2.3", records[1][1])
+	assert.Equal(t, "example.com", records[1][2])
+	assert.Equal(t, "A 1.1.1.3", records[1][9])
+	assert.Equal(t, "example.org", records[3][2])
+
+	code, body = export("format=jsonl&filter_client=2.2.2.2")
+	assert.Equal(t, http.StatusOK, code)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	assert.Equal(t, 1, len(lines))
+	m := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &m))
+	assert.Equal(t, "blocked.example.org", m["question"].(map[string]interface{})["host"])
+	assert.Equal(t, "2.2.2.2", m["client"])
+
+	code, _ = export("format=xml")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// Clients' IP addresses are anonymized before they are stored
+func TestQueryLogStream(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	srv := httptest.NewServer(http.HandlerFunc(l.handleQueryLogStream))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?filter_domain=example.com"
+
+	// the connections from other sites are not allowed
+	_, err := websocket.Dial(wsURL, "", "http://example.net")
+	assert.NotNil(t, err)
+
+	ws, err := websocket.Dial(wsURL, "", srv.URL)
+	assert.Nil(t, err)
+	defer ws.Close()
+
+	// wait until the handler subscribes to the new entries
+	for i := 0; ; i++ {
+		l.subsLock.Lock()
+		n := len(l.subs)
+		l.subsLock.Unlock()
+		if n != 0 || i == 100 {
+			assert.Equal(t, 1, n)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example.com", "1.1.1.2", "2.2.2.2")
+
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+	m := map[string]interface{}{}
+	err = websocket.JSON.Receive(ws, &m)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", m["question"].(map[string]interface{})["host"])
+	assert.Equal(t, "2.2.2.2", m["client"])
+
+	// the subscriber is removed when the connection is closed
+	_ = ws.Close()
+	for i := 0; ; i++ {
+		l.subsLock.Lock()
+		n := len(l.subs)
+		l.subsLock.Unlock()
+		if n == 0 || i == 100 {
+			assert.Equal(t, 0, n)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueryLogAnonymizeClientIP(t *testing.T) {
+	conf := Config{
+		Enabled:           true,
+		Interval:          1,
+		MemSize:           100,
+		AnonymizeClientIP: true,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	addEntry(l, "example.com", "1.1.1.2", "2001:db8:1:2:3:4:5:6")
+	assert.Equal(t, "2.2.2.0", l.buffer[0].IP)
+	assert.Equal(t, "2001:db8:1:2::", l.buffer[1].IP)
+
+	d := l.getData(getDataParams{Client: "2.2.2.1"})
+	mdata := d["data"].([]map[string]interface{})
+	assert.Equal(t, 1, len(mdata))
+	assert.Equal(t, "2.2.2.0", mdata[0]["client"])
+}
+
+// The log file is rotated when its oldest entry is older than the rotation interval
+func TestQueryLogRotateIfNecessary(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 1,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+
+	// nothing to rotate
+	l.rotateIfNecessary(time.Now())
+	_, err := os.Stat(l.logFile + ".1")
+	assert.True(t, os.IsNotExist(err))
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	_ = l.flushLogBuffer(true)
+	first, err := l.readFileFirstTime()
+	assert.Nil(t, err)
+
+	// the chunk period is 1 hour for 24 hours retention
+	l.rotateIfNecessary(first.Add(59 * time.Minute))
+	_, err = os.Stat(l.logFile)
+	assert.Nil(t, err)
+
+	l.rotateIfNecessary(first.Add(time.Hour))
+	_, err = os.Stat(l.logFile)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(l.logFile + ".1")
+	assert.Nil(t, err)
+
+	// the older chunk is renamed
+	addEntry(l, "example.com", "1.1.1.1", "2.2.2.1")
+	_ = l.flushLogBuffer(true)
+	l.rotateIfNecessary(first.Add(2 * time.Hour))
+	assert.Equal(t, 2, l.chunksCount())
+	d := l.getData(getDataParams{
+		OlderThan: time.Time{},
+	})
+	mdata := d["data"].([]map[string]interface{})
+	assert.Equal(t, 2, len(mdata))
+	assert.True(t, checkEntry(t, mdata[0], "example.com", "1.1.1.1", "2.2.2.1"))
+	assert.True(t, checkEntry(t, mdata[1], "example.org", "1.1.1.1", "2.2.2.1"))
+
+	// the chunks are deleted when all their entries are expired
+	l.rotateIfNecessary(first.Add(23 * time.Hour))
+	assert.Equal(t, 2, l.chunksCount())
+	l.rotateIfNecessary(first.Add(25 * time.Hour))
+	assert.Equal(t, 0, l.chunksCount())
+}
+
+func TestQueryLogRetentionNoLimit(t *testing.T) {
+	conf := Config{
+		Enabled:  true,
+		Interval: 0,
+		MemSize:  100,
+	}
+	conf.BaseDir = prepareTestDir()
+	defer func() { _ = os.RemoveAll(conf.BaseDir) }()
+	l := newQueryLog(conf)
+	assert.Equal(t, uint32(0), l.conf.Interval)
+
+	addEntry(l, "example.org", "1.1.1.1", "2.2.2.1")
+	_ = l.flushLogBuffer(true)
+	l.rotateIfNecessary(time.Now().Add(1000 * 24 * time.Hour))
+	_, err := os.Stat(l.logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, l.chunksCount())
+}
+
 func addEntry(l *queryLog, host, answerStr, client string) {
+	addEntryWithReason(l, host, answerStr, client, dnsfilter.NotFilteredNotFound)
+}
+
+func addEntryWithReason(l *queryLog, host, answerStr, client string, reason dnsfilter.Reason) {
 	q := dns.Msg{}
 	q.Question = append(q.Question, dns.Question{
 		Name:   host + ".",
@@ -123,7 +449,7 @@ func addEntry(l *queryLog, host, answerStr, client string) {
 	}
 	answer.A = net.ParseIP(answerStr)
 	a.Answer = append(a.Answer, answer)
-	res := dnsfilter.Result{}
+	res := dnsfilter.Result{Reason: reason}
 	params := AddParams{
 		Question: &q,
 		Answer:   &a,
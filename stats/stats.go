@@ -47,6 +47,9 @@ type Stats interface {
 	// Update counters
 	Update(e Entry)
 
+	// Count the request which has been rejected by rate limiter
+	UpdateRatelimited(client net.IP)
+
 	// Get IP addresses of the clients with the most number of requests
 	GetTopClientsIP(limit uint) []string
 
@@ -82,4 +85,11 @@ type Entry struct {
 	Client net.IP
 	Result Result
 	Time   uint32 // processing time (msec)
+
+	// ID of the filter list that blocked the request ("0": custom filtering rules)
+	// Empty if the request wasn't blocked by a filter list
+	Filter string
+
+	QType string // question type: "A", "AAAA", etc.
+	Proto string // protocol: "udp", "tcp", "tls", "https", "quic", "dnscrypt"
 }
@@ -37,14 +37,21 @@ func TestStats(t *testing.T) {
 	e.Client = net.ParseIP("127.0.0.1")
 	e.Result = RFiltered
 	e.Time = 123456
+	e.Filter = "1"
+	e.QType = "A"
+	e.Proto = "udp"
 	s.Update(e)
 
 	e.Domain = "domain"
 	e.Client = net.ParseIP("127.0.0.1")
 	e.Result = RNotFiltered
+	e.Filter = ""
 	e.Time = 123456
+	e.Proto = "https"
 	s.Update(e)
 
+	s.UpdateRatelimited(net.ParseIP("127.0.0.2"))
+
 	d := s.getData()
 	a := []uint64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
 	assert.True(t, UIntArrayEquals(d["dns_queries"].([]uint64), a))
@@ -67,11 +74,28 @@ func TestStats(t *testing.T) {
 	m = d["top_clients"].([]map[string]uint64)
 	assert.True(t, m[0]["127.0.0.1"] == 2)
 
+	m = d["top_blocking_filters"].([]map[string]uint64)
+	assert.Equal(t, 1, len(m))
+	assert.True(t, m[0]["1"] == 1)
+
+	m = d["query_types"].([]map[string]uint64)
+	assert.Equal(t, 1, len(m))
+	assert.True(t, m[0]["A"] == 2)
+
+	m = d["protocols"].([]map[string]uint64)
+	assert.Equal(t, 2, len(m))
+	assert.True(t, m[0]["https"] == 1 || m[0]["udp"] == 1)
+
+	m = d["top_ratelimited_clients"].([]map[string]uint64)
+	assert.Equal(t, 1, len(m))
+	assert.True(t, m[0]["127.0.0.2"] == 1)
+
 	assert.True(t, d["num_dns_queries"].(uint64) == 2)
 	assert.True(t, d["num_blocked_filtering"].(uint64) == 1)
 	assert.True(t, d["num_replaced_safebrowsing"].(uint64) == 0)
 	assert.True(t, d["num_replaced_safesearch"].(uint64) == 0)
 	assert.True(t, d["num_replaced_parental"].(uint64) == 0)
+	assert.True(t, d["num_ratelimited"].(uint64) == 1)
 	assert.True(t, d["avg_processing_time"].(float64) == 0.123456)
 
 	topClients := s.GetTopClientsIP(2)
@@ -150,3 +174,31 @@ func TestAggregateDataPerTimeUnit(t *testing.T) {
 		assert.True(t, alen == 30, "i=%d", i)
 	}
 }
+
+func TestStatsSaveUnit(t *testing.T) {
+	conf := Config{
+		Filename:  "./stats.db",
+		LimitDays: 1,
+		UnitID:    func() uint32 { return 1000 },
+	}
+	os.Remove(conf.Filename)
+	defer os.Remove(conf.Filename)
+	s, _ := createObject(conf)
+
+	e := Entry{}
+	e.Domain = "domain"
+	e.Client = net.ParseIP("127.0.0.1")
+	e.Result = RNotFiltered
+	e.Time = 123456
+	s.Update(e)
+	s.Update(e)
+	s.saveUnit()
+
+	// the application is terminated:  the current unit isn't flushed by Close()
+	_ = s.db.Close()
+
+	s, _ = createObject(conf)
+	d := s.getData()
+	assert.Equal(t, uint64(2), d["num_dns_queries"].(uint64))
+	s.Close()
+}
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/util"
 	"github.com/AdguardTeam/golibs/log"
 	bolt "go.etcd.io/bbolt"
 )
@@ -18,6 +19,13 @@ import (
 const (
 	maxDomains = 100 // max number of top domains to store in file or return via Get()
 	maxClients = 100 // max number of top clients to store in file or return via Get()
+	maxFilters = 100 // max number of filter lists to store in file or return via Get()
+	maxQTypes  = 100 // max number of question types to store in file or return via Get()
+	maxProtos  = 10  // max number of protocols to store in file or return via Get()
+
+	// how often the current unit is saved to file,
+	//  so that its data isn't lost if the application is terminated abnormally
+	saveInterval = 5 * time.Minute
 )
 
 // statsCtx - global context
@@ -41,6 +49,13 @@ type unit struct {
 	domains        map[string]uint64 // number of requests per domain
 	blockedDomains map[string]uint64 // number of blocked requests per domain
 	clients        map[string]uint64 // number of requests per client
+	filters        map[string]uint64 // number of blocked requests per filter list ID
+
+	qtypes map[string]uint64 // number of requests per question type
+	protos map[string]uint64 // number of requests per protocol
+
+	nRatelimited uint64            // total rate-limited requests
+	ratelimited  map[string]uint64 // number of rate-limited requests per client
 }
 
 // name-count pair
@@ -57,6 +72,12 @@ type unitDB struct {
 	Domains        []countPair
 	BlockedDomains []countPair
 	Clients        []countPair
+	Filters        []countPair
+	QTypes         []countPair
+	Protos         []countPair
+	Ratelimited    []countPair
+
+	NRatelimited uint64
 
 	TimeAvg uint32 // usec
 }
@@ -165,6 +186,10 @@ func (s *statsCtx) initUnit(u *unit, id uint32) {
 	u.domains = make(map[string]uint64)
 	u.blockedDomains = make(map[string]uint64)
 	u.clients = make(map[string]uint64)
+	u.filters = make(map[string]uint64)
+	u.qtypes = make(map[string]uint64)
+	u.protos = make(map[string]uint64)
+	u.ratelimited = make(map[string]uint64)
 }
 
 // Open a DB transaction
@@ -211,6 +236,7 @@ func btoi(b []byte) uint64 {
 }
 
 // Flush the current unit to DB and delete an old unit when a new hour is started
+// The current unit is also saved to DB periodically
 // If a unit must be flushed:
 // . lock DB
 // . atomically set a new empty unit as the current one and get the old unit
@@ -219,6 +245,7 @@ func btoi(b []byte) uint64 {
 // . remove the stale unit from DB
 // . unlock DB
 func (s *statsCtx) periodicFlush() {
+	lastSave := time.Now()
 	for {
 		s.unitLock.Lock()
 		ptr := s.unit
@@ -229,6 +256,10 @@ func (s *statsCtx) periodicFlush() {
 
 		id := s.conf.UnitID()
 		if ptr.id == id {
+			if time.Since(lastSave) >= saveInterval {
+				s.saveUnit()
+				lastSave = time.Now()
+			}
 			time.Sleep(time.Second)
 			continue
 		}
@@ -254,6 +285,36 @@ func (s *statsCtx) periodicFlush() {
 	log.Tracef("periodicFlush() exited")
 }
 
+// Save the current unit to DB without replacing it
+func (s *statsCtx) saveUnit() {
+	s.unitLock.Lock()
+	u := s.unit
+	if u == nil {
+		s.unitLock.Unlock()
+		return
+	}
+	udb := serialize(u)
+	s.unitLock.Unlock()
+
+	tx := s.beginTxn(true)
+	if tx == nil {
+		return
+	}
+
+	// the unit has been replaced while we were waiting for DB:
+	//  its newer data is written by the code which replaced it
+	s.unitLock.Lock()
+	replaced := s.unit != u
+	s.unitLock.Unlock()
+
+	if !replaced && s.flushUnitToDB(tx, u.id, udb) {
+		s.commitTxn(tx)
+		log.Tracef("Stats: saved unit %d", u.id)
+	} else {
+		_ = tx.Rollback()
+	}
+}
+
 // Delete unit's data from file
 func (s *statsCtx) deleteUnit(tx *bolt.Tx, id uint32) bool {
 	err := tx.DeleteBucket(unitName(id))
@@ -306,6 +367,11 @@ func serialize(u *unit) *unitDB {
 	udb.Domains = convertMapToArray(u.domains, maxDomains)
 	udb.BlockedDomains = convertMapToArray(u.blockedDomains, maxDomains)
 	udb.Clients = convertMapToArray(u.clients, maxClients)
+	udb.Filters = convertMapToArray(u.filters, maxFilters)
+	udb.QTypes = convertMapToArray(u.qtypes, maxQTypes)
+	udb.Protos = convertMapToArray(u.protos, maxProtos)
+	udb.Ratelimited = convertMapToArray(u.ratelimited, maxClients)
+	udb.NRatelimited = u.nRatelimited
 	return &udb
 }
 
@@ -323,6 +389,11 @@ func deserialize(u *unit, udb *unitDB) {
 	u.domains = convertArrayToMap(udb.Domains)
 	u.blockedDomains = convertArrayToMap(udb.BlockedDomains)
 	u.clients = convertArrayToMap(udb.Clients)
+	u.filters = convertArrayToMap(udb.Filters)
+	u.qtypes = convertArrayToMap(udb.QTypes)
+	u.protos = convertArrayToMap(udb.Protos)
+	u.ratelimited = convertArrayToMap(udb.Ratelimited)
+	u.nRatelimited = udb.NRatelimited
 	u.timeSum = uint64(udb.TimeAvg) * u.nTotal
 }
 
@@ -446,17 +517,7 @@ func (s *statsCtx) clear() {
 // Get Client IP address
 func (s *statsCtx) getClientIP(clientIP string) string {
 	if s.conf.AnonymizeClientIP {
-		ip := net.ParseIP(clientIP)
-		if ip != nil {
-			ip4 := ip.To4()
-			const AnonymizeClientIP4Mask = 24
-			const AnonymizeClientIP6Mask = 112
-			if ip4 != nil {
-				clientIP = ip4.Mask(net.CIDRMask(AnonymizeClientIP4Mask, 32)).String()
-			} else {
-				clientIP = ip.Mask(net.CIDRMask(AnonymizeClientIP6Mask, 128)).String()
-			}
-		}
+		clientIP = util.AnonymizeIP(clientIP)
 	}
 
 	return clientIP
@@ -481,6 +542,15 @@ func (s *statsCtx) Update(e Entry) {
 	} else {
 		u.blockedDomains[e.Domain]++
 	}
+	if e.Result == RFiltered && len(e.Filter) != 0 {
+		u.filters[e.Filter]++
+	}
+	if len(e.QType) != 0 {
+		u.qtypes[e.QType]++
+	}
+	if len(e.Proto) != 0 {
+		u.protos[e.Proto]++
+	}
 
 	u.clients[client]++
 	u.timeSum += uint64(e.Time)
@@ -488,6 +558,18 @@ func (s *statsCtx) Update(e Entry) {
 	s.unitLock.Unlock()
 }
 
+func (s *statsCtx) UpdateRatelimited(clientIP net.IP) {
+	if !(len(clientIP) == 4 || len(clientIP) == 16) {
+		return
+	}
+	client := s.getClientIP(clientIP.String())
+
+	s.unitLock.Lock()
+	s.unit.ratelimited[client]++
+	s.unit.nRatelimited++
+	s.unitLock.Unlock()
+}
+
 func (s *statsCtx) loadUnits(limit uint32) ([]*unitDB, uint32) {
 	tx := s.beginTxn(false)
 	if tx == nil {
@@ -538,6 +620,10 @@ func (s *statsCtx) loadUnits(limit uint32) ([]*unitDB, uint32) {
   * queries/domain
   * queries/blocked-domain
   * queries/client
+  * blocked-queries/filter-list
+  * queries/question-type
+  * queries/protocol
+  * rate-limited-queries/client
   To get these values we first sum up data for all units into a single map.
   Then we get the pairs with the highest numbers (the values are sorted in descending order)
  * total counters:
@@ -546,6 +632,7 @@ func (s *statsCtx) loadUnits(limit uint32) ([]*unitDB, uint32) {
   * safebrowsing-blocked
   * safesearch-blocked
   * parental-blocked
+  * rate-limited
   These values are just the sum of data for all units.
 */
 // nolint (gocyclo)
@@ -696,6 +783,42 @@ func (s *statsCtx) getData() map[string]interface{} {
 	a2 = convertMapToArray(m, maxClients)
 	d["top_clients"] = convertTopArray(a2)
 
+	m = map[string]uint64{}
+	for _, u := range units {
+		for _, it := range u.Filters {
+			m[it.Name] += it.Count
+		}
+	}
+	a2 = convertMapToArray(m, maxFilters)
+	d["top_blocking_filters"] = convertTopArray(a2)
+
+	m = map[string]uint64{}
+	for _, u := range units {
+		for _, it := range u.QTypes {
+			m[it.Name] += it.Count
+		}
+	}
+	a2 = convertMapToArray(m, maxQTypes)
+	d["query_types"] = convertTopArray(a2)
+
+	m = map[string]uint64{}
+	for _, u := range units {
+		for _, it := range u.Protos {
+			m[it.Name] += it.Count
+		}
+	}
+	a2 = convertMapToArray(m, maxProtos)
+	d["protocols"] = convertTopArray(a2)
+
+	m = map[string]uint64{}
+	for _, u := range units {
+		for _, it := range u.Ratelimited {
+			m[it.Name] += it.Count
+		}
+	}
+	a2 = convertMapToArray(m, maxClients)
+	d["top_ratelimited_clients"] = convertTopArray(a2)
+
 	// total counters:
 
 	sum := unitDB{}
@@ -711,6 +834,7 @@ func (s *statsCtx) getData() map[string]interface{} {
 		sum.NResult[RSafeBrowsing] += u.NResult[RSafeBrowsing]
 		sum.NResult[RSafeSearch] += u.NResult[RSafeSearch]
 		sum.NResult[RParental] += u.NResult[RParental]
+		sum.NRatelimited += u.NRatelimited
 	}
 
 	d["num_dns_queries"] = sum.NTotal
@@ -718,6 +842,7 @@ func (s *statsCtx) getData() map[string]interface{} {
 	d["num_replaced_safebrowsing"] = sum.NResult[RSafeBrowsing]
 	d["num_replaced_safesearch"] = sum.NResult[RSafeSearch]
 	d["num_replaced_parental"] = sum.NResult[RParental]
+	d["num_ratelimited"] = sum.NRatelimited
 
 	avgTime := float64(0)
 	if timeN != 0 {
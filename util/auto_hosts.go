@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -24,6 +25,7 @@ type AutoHosts struct {
 	tableReverse map[string]string   // "IP -> hostname" table for reverse lookup
 
 	hostsFn    string            // path to the main hosts-file
+	hostsFiles []string          // paths to additional hosts-files
 	hostsDirs  []string          // paths to OS-specific directories with hosts-files
 	watcher    *fsnotify.Watcher // file and directory watcher object
 	updateChan chan bool         // signal for 'updateLoop' goroutine
@@ -57,6 +59,7 @@ func (a *AutoHosts) Init(hostsFn string) {
 	if len(hostsFn) != 0 {
 		a.hostsFn = hostsFn
 	}
+	a.hostsFn = filepath.Clean(a.hostsFn)
 
 	if IsOpenWrt() {
 		a.hostsDirs = append(a.hostsDirs, "/tmp/hosts") // OpenWRT: "/tmp/hosts/dhcp.cfg01411c"
@@ -69,6 +72,12 @@ func (a *AutoHosts) Init(hostsFn string) {
 	}
 }
 
+// AddHostsFile - load host names from this file in addition to the main hosts-file
+// Must be called before Start()
+func (a *AutoHosts) AddHostsFile(fn string) {
+	a.hostsFiles = append(a.hostsFiles, filepath.Clean(fn))
+}
+
 // Start - start module
 func (a *AutoHosts) Start() {
 	log.Debug("Start AutoHosts module")
@@ -78,13 +87,23 @@ func (a *AutoHosts) Start() {
 
 	go a.watcherLoop()
 
-	err := a.watcher.Add(a.hostsFn)
-	if err != nil {
-		log.Error("Error while initializing watcher for a file %s: %s", a.hostsFn, err)
+	// Watch the directories, not the files:
+	// a text editor may replace the file and the watcher for the old file would stop working
+	dirs := map[string]bool{}
+	for _, fn := range append([]string{a.hostsFn}, a.hostsFiles...) {
+		dir := filepath.Dir(fn)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		err := a.watcher.Add(dir)
+		if err != nil {
+			log.Error("Error while initializing watcher for a directory %s: %s", dir, err)
+		}
 	}
 
 	for _, dir := range a.hostsDirs {
-		err = a.watcher.Add(dir)
+		err := a.watcher.Add(dir)
 		if err != nil {
 			log.Error("Error while initializing watcher for a directory %s: %s", dir, err)
 		}
@@ -153,18 +172,21 @@ func (a *AutoHosts) load(table map[string][]net.IP, tableRev map[string]string,
 			log.Error("AutoHosts: %s", err)
 			return
 		}
-		line = strings.TrimSpace(line)
+		i := strings.IndexByte(line, '#')
+		if i != -1 {
+			line = line[:i] // remove comment
+		}
 
-		ip := SplitNext(&line, ' ')
-		ipAddr := net.ParseIP(ip)
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ipAddr := net.ParseIP(fields[0])
 		if ipAddr == nil {
 			continue
 		}
-		for {
-			host := SplitNext(&line, ' ')
-			if len(host) == 0 {
-				break
-			}
+		for _, host := range fields[1:] {
+			host = strings.ToLower(host)
 			a.updateTable(table, host, ipAddr)
 			a.updateTableRev(tableRev, host, ipAddr)
 		}
@@ -180,19 +202,23 @@ func (a *AutoHosts) watcherLoop() {
 			if !ok {
 				return
 			}
+			update := a.isHostsEvent(event)
 
 			// skip duplicate events
 			repeat := true
 			for repeat {
 				select {
-				case _ = <-a.watcher.Events:
-					// skip this event
+				case e, ok := <-a.watcher.Events:
+					if !ok {
+						return
+					}
+					update = update || a.isHostsEvent(e)
 				default:
 					repeat = false
 				}
 			}
 
-			if event.Op&fsnotify.Write == fsnotify.Write {
+			if update {
 				log.Debug("AutoHosts: modified: %s", event.Name)
 				select {
 				case a.updateChan <- true:
@@ -211,6 +237,30 @@ func (a *AutoHosts) watcherLoop() {
 	}
 }
 
+// Return TRUE if a hosts-file is modified, created or removed
+func (a *AutoHosts) isHostsEvent(e fsnotify.Event) bool {
+	if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+		return false
+	}
+
+	fn := filepath.Clean(e.Name)
+	if fn == a.hostsFn {
+		return true
+	}
+	for _, f := range a.hostsFiles {
+		if fn == f {
+			return true
+		}
+	}
+	dir := filepath.Dir(fn)
+	for _, d := range a.hostsDirs {
+		if dir == filepath.Clean(d) {
+			return true
+		}
+	}
+	return false
+}
+
 // updateLoop - read static hosts from system files
 func (a *AutoHosts) updateLoop() {
 	for {
@@ -232,6 +282,9 @@ func (a *AutoHosts) updateHosts() {
 	tableRev := make(map[string]string)
 
 	a.load(table, tableRev, a.hostsFn)
+	for _, fn := range a.hostsFiles {
+		a.load(table, tableRev, fn)
+	}
 
 	for _, dir := range a.hostsDirs {
 		fis, err := ioutil.ReadDir(dir)
@@ -317,8 +370,9 @@ func ipReverse(ip net.IP) net.IP {
 	return r
 }
 
-// Convert reversed ARPA address to a normal IP address
-func dnsUnreverseAddr(s string) net.IP {
+// DNSUnreverseAddr - convert reversed ARPA address to a normal IP address
+// The name must not have the trailing dot
+func DNSUnreverseAddr(s string) net.IP {
 	const arpaV4 = ".in-addr.arpa"
 	const arpaV6 = ".ip6.arpa"
 
@@ -346,7 +400,7 @@ func (a *AutoHosts) ProcessReverse(addr string, qtype uint16) string {
 		return ""
 	}
 
-	ipReal := dnsUnreverseAddr(addr)
+	ipReal := DNSUnreverseAddr(addr)
 	if ipReal == nil {
 		return "" // invalid IP in question
 	}
@@ -100,12 +100,57 @@ func TestAutoHostsFSNotify(t *testing.T) {
 	assert.Equal(t, "127.0.0.2", ips[0].String())
 }
 
+func TestAutoHostsExtraFile(t *testing.T) {
+	ah := AutoHosts{}
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	f, _ := ioutil.TempFile(dir, "")
+	_, _ = f.WriteString("# comment\n127.0.0.1\tlocalhost\n")
+	_ = f.Close()
+	f2, _ := ioutil.TempFile(dir, "")
+	_, _ = f2.WriteString("192.168.1.10\tNAS nas.lan # file server\n")
+	_ = f2.Close()
+
+	ah.Init(f.Name())
+	ah.AddHostsFile(f2.Name())
+	ah.updateHosts()
+
+	ips := ah.Process("localhost", dns.TypeA)
+	assert.Equal(t, 1, len(ips))
+	ips = ah.Process("nas", dns.TypeA)
+	assert.Equal(t, 1, len(ips))
+	assert.Equal(t, "192.168.1.10", ips[0].String())
+	assert.Equal(t, 1, len(ah.Process("nas.lan", dns.TypeA)))
+	assert.Nil(t, ah.Process("#", dns.TypeA))
+	assert.Nil(t, ah.Process("file", dns.TypeA))
+
+	// the file is replaced
+	ah.Start()
+	defer ah.Close()
+
+	tmp := f2.Name() + ".tmp"
+	_ = ioutil.WriteFile(tmp, []byte("192.168.1.11 nas\n"), 0644)
+	_ = os.Rename(tmp, f2.Name())
+
+	for i := 0; i != 100; i++ {
+		ips = ah.Process("nas", dns.TypeA)
+		if len(ips) == 1 && ips[0].String() == "192.168.1.11" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(ips))
+	assert.Equal(t, "192.168.1.11", ips[0].String())
+}
+
 func TestIP(t *testing.T) {
-	assert.True(t, dnsUnreverseAddr("1.0.0.127.in-addr.arpa").Equal(net.ParseIP("127.0.0.1").To4()))
-	assert.True(t, dnsUnreverseAddr("4.3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa").Equal(net.ParseIP("::abcd:1234")))
+	assert.True(t, DNSUnreverseAddr("1.0.0.127.in-addr.arpa").Equal(net.ParseIP("127.0.0.1").To4()))
+	assert.True(t, DNSUnreverseAddr("4.3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa").Equal(net.ParseIP("::abcd:1234")))
 
-	assert.True(t, dnsUnreverseAddr("1.0.0.127.in-addr.arpa.") == nil)
-	assert.True(t, dnsUnreverseAddr(".0.0.127.in-addr.arpa") == nil)
-	assert.True(t, dnsUnreverseAddr(".3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa") == nil)
-	assert.True(t, dnsUnreverseAddr("4.3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0..ip6.arpa") == nil)
+	assert.True(t, DNSUnreverseAddr("1.0.0.127.in-addr.arpa.") == nil)
+	assert.True(t, DNSUnreverseAddr(".0.0.127.in-addr.arpa") == nil)
+	assert.True(t, DNSUnreverseAddr(".3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa") == nil)
+	assert.True(t, DNSUnreverseAddr("4.3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0..ip6.arpa") == nil)
 }
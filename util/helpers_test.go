@@ -12,3 +12,9 @@ func TestSplitNext(t *testing.T) {
 	assert.True(t, SplitNext(&s, ',') == "b")
 	assert.True(t, SplitNext(&s, ',') == "c" && len(s) == 0)
 }
+
+func TestAnonymizeIP(t *testing.T) {
+	assert.Equal(t, "192.168.1.0", AnonymizeIP("192.168.1.123"))
+	assert.Equal(t, "2001:db8:1:2::", AnonymizeIP("2001:db8:1:2:3:4:5:6"))
+	assert.Equal(t, "client", AnonymizeIP("client"))
+}
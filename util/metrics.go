@@ -0,0 +1,59 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MetricsWriter - writes metrics in Prometheus text exposition format
+type MetricsWriter struct {
+	w io.Writer
+}
+
+// NewMetricsWriter - create a new MetricsWriter object
+func NewMetricsWriter(w io.Writer) *MetricsWriter {
+	return &MetricsWriter{w: w}
+}
+
+// Header - write the description of the metric
+// typ: "counter", "gauge" or "histogram"
+func (m *MetricsWriter) Header(name, typ, help string) {
+	_, _ = fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample - write the value of the metric
+// labels: the pairs of label name and value
+func (m *MetricsWriter) Sample(name string, value float64, labels ...string) {
+	s := name
+	if len(labels) >= 2 {
+		pairs := []string{}
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+"=\""+escapeLabelValue(labels[i+1])+"\"")
+		}
+		s += "{" + strings.Join(pairs, ",") + "}"
+	}
+	_, _ = fmt.Fprintf(m.w, "%s %s\n", s, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Histogram - write the samples of the histogram
+// bounds: the upper bounds of the buckets;  counts: the number of observations in each bucket (not cumulative)
+//  with one more element for the observations greater than the last bound
+func (m *MetricsWriter) Histogram(name string, bounds []float64, counts []uint64, sum float64, labels ...string) {
+	total := uint64(0)
+	for i, b := range bounds {
+		total += counts[i]
+		m.Sample(name+"_bucket", float64(total), append(labels, "le", strconv.FormatFloat(b, 'g', -1, 64))...)
+	}
+	total += counts[len(bounds)]
+	m.Sample(name+"_bucket", float64(total), append(labels, "le", "+Inf")...)
+	m.Sample(name+"_sum", sum, labels...)
+	m.Sample(name+"_count", float64(total), labels...)
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
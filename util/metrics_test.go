@@ -0,0 +1,27 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	b := &bytes.Buffer{}
+	mw := NewMetricsWriter(b)
+	mw.Header("test_total", "counter", "Test counter")
+	mw.Sample("test_total", 1)
+	mw.Sample("test_total", 2.5, "name", "a \"b\" \\c\n")
+	mw.Histogram("test_seconds", []float64{0.1, 1}, []uint64{1, 2, 3}, 10.5, "upstream", "1.1.1.1")
+	assert.Equal(t, `# HELP test_total Test counter
+# TYPE test_total counter
+test_total 1
+test_total{name="a \"b\" \\c\n"} 2.5
+test_seconds_bucket{upstream="1.1.1.1",le="0.1"} 1
+test_seconds_bucket{upstream="1.1.1.1",le="1"} 3
+test_seconds_bucket{upstream="1.1.1.1",le="+Inf"} 6
+test_seconds_sum{upstream="1.1.1.1"} 10.5
+test_seconds_count{upstream="1.1.1.1"} 6
+`, b.String())
+}
@@ -132,6 +132,29 @@ func GetSubnet(ifaceName string) string {
 	return ""
 }
 
+// GetInterfaceIPs - Get IP addresses of the specified interface
+// Link-local addresses are skipped
+func GetInterfaceIPs(ifaceName string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errorx.Decorate(err, "Failed to get addresses for interface %s", ifaceName)
+	}
+
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips, nil
+}
+
 // CheckPortAvailable - check if TCP port is available
 func CheckPortAvailable(host string, port int) error {
 	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
@@ -184,3 +207,22 @@ func ErrorIsAddrInUse(err error) bool {
 
 	return errErrno == syscall.EADDRINUSE
 }
+
+// Masks of the anonymized clients' IP addresses
+const (
+	AnonymizeIP4Mask = 24
+	AnonymizeIP6Mask = 64
+)
+
+// AnonymizeIP - mask the low bits of the IP address: /24 for IPv4, /64 for IPv6
+// The input string is returned as is if it isn't an IP address
+func AnonymizeIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(AnonymizeIP4Mask, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(AnonymizeIP6Mask, 128)).String()
+}
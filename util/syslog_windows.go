@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bytes"
 	"log"
 	"strings"
 
@@ -13,7 +14,11 @@ type eventLogWriter struct {
 }
 
 // Write sends a log message to the Event Log.
+// The messages with "[error]" and "[fatal]" levels are written as errors.
 func (w *eventLogWriter) Write(b []byte) (int, error) {
+	if bytes.Contains(b, []byte("[error] ")) || bytes.Contains(b, []byte("[fatal] ")) {
+		return len(b), w.el.Error(1, string(b))
+	}
 	return len(b), w.el.Info(1, string(b))
 }
 